@@ -0,0 +1,440 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// parserTuning is the machine-readable output of `boldkit bench`, loadable by
+// other subcommands via -tuning.
+type parserTuning struct {
+	reportHeader
+	Workers    int `json:"workers"`
+	ChunkSize  int `json:"chunk_size"`
+	BatchLines int `json:"batch_lines"`
+	BufferSize int `json:"buffer_size"`
+}
+
+func (t *parserTuning) apply(opts Options) Options {
+	if t == nil {
+		return opts
+	}
+	if t.Workers > 0 {
+		opts.Workers = t.Workers
+	}
+	if t.ChunkSize > 0 {
+		opts.ChunkSize = t.ChunkSize
+	}
+	if t.BatchLines > 0 {
+		opts.BatchLines = t.BatchLines
+	}
+	if t.BufferSize > 0 {
+		opts.BufferSize = t.BufferSize
+	}
+	return opts
+}
+
+// explicitWorkers returns -workers when it was set on the command line and
+// 0 otherwise, leaving the worker count to a -tuning profile or GOMAXPROCS.
+func explicitWorkers(fs *flag.FlagSet, workers int) int {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "workers" {
+			set = true
+		}
+	})
+	if !set {
+		return 0
+	}
+	return workers
+}
+
+func loadParserTuning(path string) (*parserTuning, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read tuning file: %w", err)
+	}
+	var t parserTuning
+	if err := decodeReport("tuning", data, &t); err != nil {
+		return nil, err
+	}
+	if err := t.apply(Options{}).Validate(); err != nil {
+		return nil, fmt.Errorf("tuning file %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+type syntheticSpec struct {
+	Rows int64
+	Cols int
+}
+
+// parseSyntheticSpec parses "rows=10M,cols=80" (K/M/G suffixes allowed).
+func parseSyntheticSpec(raw string) (syntheticSpec, error) {
+	spec := syntheticSpec{Rows: 1_000_000, Cols: 80}
+	for _, part := range splitList(raw) {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return spec, fmt.Errorf("invalid synthetic option %q (want key=value)", part)
+		}
+		n, err := parseCount(value)
+		if err != nil {
+			return spec, fmt.Errorf("synthetic %s: %w", key, err)
+		}
+		switch strings.TrimSpace(key) {
+		case "rows":
+			spec.Rows = n
+		case "cols":
+			spec.Cols = int(n)
+		default:
+			return spec, fmt.Errorf("unknown synthetic option %q (rows, cols)", key)
+		}
+	}
+	if spec.Rows <= 0 || spec.Cols <= 0 {
+		return spec, fmt.Errorf("synthetic rows and cols must be > 0")
+	}
+	return spec, nil
+}
+
+func parseCount(raw string) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(raw, "K"), strings.HasSuffix(raw, "k"):
+		mult = 1_000
+	case strings.HasSuffix(raw, "M"), strings.HasSuffix(raw, "m"):
+		mult = 1_000_000
+	case strings.HasSuffix(raw, "G"), strings.HasSuffix(raw, "g"):
+		mult = 1_000_000_000
+	}
+	if mult > 1 {
+		raw = raw[:len(raw)-1]
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+// syntheticTSV deterministically generates a header plus spec.Rows data rows
+// shaped roughly like a BOLD snapshot (short fields plus one long sequence).
+type syntheticTSV struct {
+	spec  syntheticSpec
+	row   int64
+	state uint64
+	buf   []byte
+	off   int
+}
+
+func newSyntheticTSV(spec syntheticSpec) *syntheticTSV {
+	return &syntheticTSV{spec: spec, row: -1, state: 0x9e3779b97f4a7c15}
+}
+
+func (s *syntheticTSV) next() uint64 {
+	s.state ^= s.state << 13
+	s.state ^= s.state >> 7
+	s.state ^= s.state << 17
+	return s.state
+}
+
+func (s *syntheticTSV) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if s.off >= len(s.buf) {
+			if s.row >= s.spec.Rows {
+				if n == 0 {
+					return 0, io.EOF
+				}
+				return n, nil
+			}
+			s.fill()
+		}
+		c := copy(p[n:], s.buf[s.off:])
+		s.off += c
+		n += c
+	}
+	return n, nil
+}
+
+func (s *syntheticTSV) fill() {
+	s.buf = s.buf[:0]
+	s.off = 0
+	if s.row < 0 {
+		for c := 0; c < s.spec.Cols; c++ {
+			if c > 0 {
+				s.buf = append(s.buf, '\t')
+			}
+			s.buf = append(s.buf, "col"...)
+			s.buf = strconv.AppendInt(s.buf, int64(c), 10)
+		}
+	} else {
+		for c := 0; c < s.spec.Cols; c++ {
+			if c > 0 {
+				s.buf = append(s.buf, '\t')
+			}
+			r := s.next()
+			width := 4 + int(r%12)
+			if c == s.spec.Cols-1 {
+				width = 600 + int(r%60)
+			}
+			for i := 0; i < width; i++ {
+				s.buf = append(s.buf, "ACGT"[(r>>(uint(i)%60))&3])
+			}
+		}
+	}
+	s.buf = append(s.buf, '\n')
+	s.row++
+}
+
+type benchResult struct {
+	Workers    int
+	ChunkSize  int
+	BatchLines int
+	Rows       int64
+	Bytes      int64
+	Passes     int // complete passes over the source
+	Elapsed    time.Duration
+	PeakHeap   uint64
+	AllocBytes uint64
+}
+
+func (r benchResult) rowsPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Rows) / r.Elapsed.Seconds()
+}
+
+func (r benchResult) mbPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / (1 << 20) / r.Elapsed.Seconds()
+}
+
+func (r benchResult) allocPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.AllocBytes) / (1 << 20) / r.Elapsed.Seconds()
+}
+
+// benchGrid returns the Options combinations explored by `boldkit bench`.
+func benchGrid(maxProcs int) []Options {
+	workerSet := []int{1}
+	if maxProcs >= 4 {
+		workerSet = append(workerSet, maxProcs/2)
+	}
+	if maxProcs > 1 {
+		workerSet = append(workerSet, maxProcs)
+	}
+	var grid []Options
+	for _, workers := range workerSet {
+		for _, chunk := range []int{4 << 20, defaultChunkSize} {
+			for _, batch := range []int{defaultBatchLines, 4096} {
+				opts := DefaultOptions()
+				opts.Workers = workers
+				opts.ChunkSize = chunk
+				opts.BatchLines = batch
+				grid = append(grid, opts)
+			}
+		}
+	}
+	return grid
+}
+
+// benchSource opens a fresh reader for each pass over the workload.
+type benchSource func() (io.ReadCloser, error)
+
+func fileBenchSource(path string) benchSource {
+	return func() (io.ReadCloser, error) {
+		return openInput(path)
+	}
+}
+
+func syntheticBenchSource(spec syntheticSpec) benchSource {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(newSyntheticTSV(spec)), nil
+	}
+}
+
+// runBenchGrid measures each Options combination for one full pass over the
+// source, repeating passes until budget is used.
+func runBenchGrid(source benchSource, grid []Options, budget time.Duration) ([]benchResult, error) {
+	results := make([]benchResult, 0, len(grid))
+	for _, opts := range grid {
+		res, err := benchOne(source, opts, budget)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func benchOne(source benchSource, opts Options, budget time.Duration) (benchResult, error) {
+	res := benchResult{Workers: opts.Workers, ChunkSize: opts.ChunkSize, BatchLines: opts.BatchLines}
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var peak atomic.Uint64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > peak.Load() {
+				peak.Store(ms.HeapAlloc)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	// The first pass always runs to completion; later passes stop at the
+	// budget, and their rows up to that point still count.
+	start := time.Now()
+	for {
+		remaining := budget - time.Since(start)
+		if remaining <= 0 && res.Passes > 0 {
+			break
+		}
+		in, err := source()
+		if err != nil {
+			close(stop)
+			wg.Wait()
+			return res, err
+		}
+		counter := &countReader{reader: in}
+		passOpts := opts
+		if res.Passes > 0 {
+			passOpts.Timeout = remaining
+		}
+		err = ParseTSV(counter, passOpts, func(Row) error {
+			res.Rows++
+			return nil
+		})
+		_ = in.Close()
+		res.Bytes += counter.Count()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			close(stop)
+			wg.Wait()
+			return res, err
+		}
+		if err != nil {
+			break
+		}
+		res.Passes++
+	}
+	res.Elapsed = time.Since(start)
+	close(stop)
+	wg.Wait()
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	res.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	res.PeakHeap = peak.Load()
+	return res, nil
+}
+
+// recommendTuning picks the highest-throughput result; near-ties (within 5%)
+// go to the one with the lower peak heap.
+func recommendTuning(results []benchResult) (benchResult, bool) {
+	if len(results) == 0 {
+		return benchResult{}, false
+	}
+	sorted := append([]benchResult(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].rowsPerSec() > sorted[j].rowsPerSec()
+	})
+	best := sorted[0]
+	for _, r := range sorted[1:] {
+		if r.rowsPerSec() < best.rowsPerSec()*0.95 {
+			break
+		}
+		if r.PeakHeap < best.PeakHeap {
+			best = r
+		}
+	}
+	return best, true
+}
+
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	input := fs.String("input", "", "TSV/TSV.gz workload (empty uses -synthetic)")
+	synthetic := fs.String("synthetic", "rows=1M,cols=80", "Synthetic workload when -input is empty (rows=N,cols=N; K/M/G suffixes)")
+	duration := fs.Duration("duration", 30*time.Second, "Total time budget split across the grid")
+	tuningOut := fs.String("tuning-out", "", "Optional tuning JSON to write (load with -tuning)")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+
+	var source benchSource
+	if *input != "" {
+		source = fileBenchSource(*input)
+	} else {
+		spec, err := parseSyntheticSpec(*synthetic)
+		if err != nil {
+			fatalf("invalid synthetic spec: %v", err)
+		}
+		source = syntheticBenchSource(spec)
+	}
+
+	grid := benchGrid(runtime.GOMAXPROCS(0))
+	budget := *duration / time.Duration(len(grid))
+	if budget <= 0 {
+		fatalf("duration must be > 0")
+	}
+	logf("bench: %d configurations, %s each", len(grid), budget)
+	results, err := runBenchGrid(source, grid, budget)
+	if err != nil {
+		fatalf("bench failed: %v", err)
+	}
+
+	fmt.Printf("%-8s %-10s %-6s %14s %10s %12s %12s\n", "workers", "chunk", "batch", "rows/s", "MB/s", "peak_heap", "alloc_MB/s")
+	for _, r := range results {
+		fmt.Printf("%-8d %-10s %-6d %14.0f %10.1f %12s %12.1f\n", r.Workers, formatMiB(uint64(r.ChunkSize)), r.BatchLines, r.rowsPerSec(), r.mbPerSec(), formatMiB(r.PeakHeap), r.allocPerSec())
+	}
+	best, _ := recommendTuning(results)
+	fmt.Printf("\nRecommended: Workers=%d ChunkSize=%d BatchLines=%d\n", best.Workers, best.ChunkSize, best.BatchLines)
+
+	if *tuningOut != "" {
+		tuning := parserTuning{
+			reportHeader: newReportHeader("tuning"),
+			Workers:      best.Workers,
+			ChunkSize:    best.ChunkSize,
+			BatchLines:   best.BatchLines,
+			BufferSize:   defaultBufferSize,
+		}
+		if err := writeReportJSON(*tuningOut, tuning); err != nil {
+			fatalf("write tuning: %v", err)
+		}
+		logf("bench: tuning -> %s", *tuningOut)
+	}
+}
+
+func formatMiB(n uint64) string {
+	return strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64) + "MiB"
+}
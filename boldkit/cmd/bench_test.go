@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSyntheticSpec(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    syntheticSpec
+		wantErr bool
+	}{
+		{raw: "rows=10M,cols=80", want: syntheticSpec{Rows: 10_000_000, Cols: 80}},
+		{raw: "rows=2k", want: syntheticSpec{Rows: 2_000, Cols: 80}},
+		{raw: "cols=0", wantErr: true},
+		{raw: "width=3", wantErr: true},
+		{raw: "rows", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := parseSyntheticSpec(tc.raw)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("%q: expected error", tc.raw)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Fatalf("%q: got %+v err=%v want %+v", tc.raw, got, err, tc.want)
+		}
+	}
+}
+
+func TestSyntheticTSVDeterministic(t *testing.T) {
+	spec := syntheticSpec{Rows: 50, Cols: 6}
+	a, err := io.ReadAll(newSyntheticTSV(spec))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	b, _ := io.ReadAll(newSyntheticTSV(spec))
+	if !bytes.Equal(a, b) {
+		t.Fatalf("synthetic output not deterministic")
+	}
+	lines := bytes.Split(bytes.TrimSuffix(a, []byte("\n")), []byte("\n"))
+	if len(lines) != 51 {
+		t.Fatalf("lines=%d want 51", len(lines))
+	}
+	for i, line := range lines {
+		if n := len(bytes.Split(line, []byte("\t"))); n != 6 {
+			t.Fatalf("line %d has %d columns", i, n)
+		}
+	}
+}
+
+func TestRunBenchGrid(t *testing.T) {
+	grid := benchGrid(2)
+	if len(grid) != 8 {
+		t.Fatalf("grid=%d want 8", len(grid))
+	}
+	source := syntheticBenchSource(syntheticSpec{Rows: 200, Cols: 5})
+	results, err := runBenchGrid(source, grid[:2], 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("runBenchGrid: %v", err)
+	}
+	for _, r := range results {
+		if r.Passes < 1 || r.Rows < 201 || r.Bytes == 0 || r.Elapsed <= 0 || r.rowsPerSec() <= 0 {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	}
+}
+
+func TestRecommendTuning(t *testing.T) {
+	results := []benchResult{
+		{Workers: 1, Rows: 1000, Elapsed: time.Second, PeakHeap: 10},
+		{Workers: 4, Rows: 2000, Elapsed: time.Second, PeakHeap: 80},
+		{Workers: 8, Rows: 1980, Elapsed: time.Second, PeakHeap: 40},
+	}
+	best, ok := recommendTuning(results)
+	if !ok || best.Workers != 8 {
+		t.Fatalf("best=%+v want workers=8 (near-tie with lower heap)", best)
+	}
+	if _, ok := recommendTuning(nil); ok {
+		t.Fatalf("expected no recommendation for empty results")
+	}
+}
+
+func TestParserTuningRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tuning.json")
+	want := parserTuning{reportHeader: newReportHeader("tuning"), Workers: 3, ChunkSize: 4 << 20, BatchLines: 4096}
+	if err := writeReportJSON(path, want); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got, err := loadParserTuning(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	opts := got.apply(DefaultOptions())
+	if opts.Workers != 3 || opts.ChunkSize != 4<<20 || opts.BatchLines != 4096 || opts.BufferSize != defaultBufferSize {
+		t.Fatalf("opts=%+v", opts)
+	}
+	var none *parserTuning
+	if none.apply(DefaultOptions()).Workers != DefaultOptions().Workers {
+		t.Fatalf("nil tuning changed options")
+	}
+
+	// A hand-edited file is checked on load, not when the parse starts.
+	want.Workers = maxWorkers + 1
+	if err := writeReportJSON(path, want); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := loadParserTuning(path); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("oversized workers: err=%v", err)
+	}
+}
+
+func TestExplicitWorkers(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{args: nil, want: 0},
+		{args: []string{"-workers", "2"}, want: 2},
+	} {
+		fs := flag.NewFlagSet("markers", flag.ContinueOnError)
+		workers := fs.Int("workers", 8, "")
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := explicitWorkers(fs, *workers); got != tc.want {
+			t.Fatalf("args %v: workers=%d want %d", tc.args, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// bgzfBlockData is the input of a full BGZF block, as bgzip writes it. Its
+// deflated form always fits the 64 KiB block limit.
+const bgzfBlockData = 0xff00
+
+// bgzfEOF is the empty block that ends a BGZF file.
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0x06, 0, 'B', 'C', 0x02, 0,
+	0x1b, 0, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+// bgzfBlockStart locates a block: its offset in the compressed file and the
+// offset of its first byte in the decompressed stream.
+type bgzfBlockStart struct {
+	compressed   uint64
+	uncompressed uint64
+}
+
+// bgzfWriter writes BGZF (bgzip) output: independent gzip members of at
+// most bgzfBlockData input bytes, each carrying its size in a BC extra
+// subfield. Plain gzip readers see one multi-member stream. The block
+// starts are kept for WriteIndex.
+type bgzfWriter struct {
+	w      io.Writer
+	data   []byte
+	block  bytes.Buffer
+	flate  *flate.Writer
+	next   bgzfBlockStart
+	starts []bgzfBlockStart
+	err    error
+}
+
+// newBGZFWriter writes to w, which Close does not close.
+func newBGZFWriter(w io.Writer) *bgzfWriter {
+	fw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return &bgzfWriter{w: w, data: make([]byte, 0, bgzfBlockData), flate: fw}
+}
+
+func (z *bgzfWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 && z.err == nil {
+		k := copy(z.data[len(z.data):cap(z.data)], p)
+		z.data = z.data[:len(z.data)+k]
+		p = p[k:]
+		n += k
+		if len(z.data) == cap(z.data) {
+			z.writeBlock()
+		}
+	}
+	return n, z.err
+}
+
+func (z *bgzfWriter) writeBlock() {
+	if z.err != nil || len(z.data) == 0 {
+		return
+	}
+	z.block.Reset()
+	z.block.Write([]byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0x06, 0, 'B', 'C', 0x02, 0, 0, 0})
+	z.flate.Reset(&z.block)
+	if _, err := z.flate.Write(z.data); err != nil {
+		z.err = err
+		return
+	}
+	if err := z.flate.Close(); err != nil {
+		z.err = err
+		return
+	}
+	var tail [8]byte
+	binary.LittleEndian.PutUint32(tail[:4], crc32.ChecksumIEEE(z.data))
+	binary.LittleEndian.PutUint32(tail[4:], uint32(len(z.data)))
+	z.block.Write(tail[:])
+	b := z.block.Bytes()
+	// BSIZE is the block size minus one.
+	binary.LittleEndian.PutUint16(b[16:], uint16(len(b)-1))
+	if _, z.err = z.w.Write(b); z.err != nil {
+		return
+	}
+	z.starts = append(z.starts, z.next)
+	z.next.compressed += uint64(len(b))
+	z.next.uncompressed += uint64(len(z.data))
+	z.data = z.data[:0]
+}
+
+// Close writes the buffered block and the EOF block.
+func (z *bgzfWriter) Close() error {
+	z.writeBlock()
+	if z.err == nil {
+		_, z.err = z.w.Write(bgzfEOF)
+	}
+	return z.err
+}
+
+// WriteIndex writes the block starts in the .gzi format of bgzip -i and
+// samtools faidx: a little-endian uint64 count, then (compressed,
+// uncompressed) offset pairs for every block after the first.
+func (z *bgzfWriter) WriteIndex(w io.Writer) error {
+	return writeGZI(w, z.starts)
+}
+
+func writeGZI(w io.Writer, starts []bgzfBlockStart) error {
+	if len(starts) > 0 {
+		starts = starts[1:]
+	}
+	buf := make([]byte, 8+16*len(starts))
+	binary.LittleEndian.PutUint64(buf, uint64(len(starts)))
+	for i, s := range starts {
+		binary.LittleEndian.PutUint64(buf[8+16*i:], s.compressed)
+		binary.LittleEndian.PutUint64(buf[16+16*i:], s.uncompressed)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readGZI reads a .gzi index, restoring the implicit first block.
+func readGZI(r io.Reader) ([]bgzfBlockStart, error) {
+	var n uint64
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, fmt.Errorf("read gzi: %w", err)
+	}
+	if n > 1<<40 {
+		return nil, fmt.Errorf("read gzi: %d entries", n)
+	}
+	pairs := make([]uint64, 2*n)
+	if err := binary.Read(r, binary.LittleEndian, pairs); err != nil {
+		return nil, fmt.Errorf("read gzi: %w", err)
+	}
+	starts := make([]bgzfBlockStart, 1, n+1)
+	for i := uint64(0); i < n; i++ {
+		starts = append(starts, bgzfBlockStart{compressed: pairs[2*i], uncompressed: pairs[2*i+1]})
+	}
+	return starts, nil
+}
+
+// scanBGZFBlocks walks the block headers of a BGZF file of size bytes and
+// returns the start of the first block and of each later non-empty one.
+func scanBGZFBlocks(r io.ReaderAt, size int64) ([]bgzfBlockStart, error) {
+	var (
+		starts []bgzfBlockStart
+		next   bgzfBlockStart
+		head   [bgzfHeaderSize]byte
+		isize  [4]byte
+	)
+	for off := int64(0); off < size; {
+		if _, err := r.ReadAt(head[:], off); err != nil || !isBGZFHeader(head[:]) {
+			return nil, fmt.Errorf("%w at offset %d", errBGZFBlock, off)
+		}
+		blockSize := int64(binary.LittleEndian.Uint16(head[16:])) + 1
+		if _, err := r.ReadAt(isize[:], off+blockSize-4); err != nil {
+			return nil, fmt.Errorf("%w at offset %d", errBGZFBlock, off)
+		}
+		if n := binary.LittleEndian.Uint32(isize[:]); n > 0 || off == 0 {
+			next.compressed = uint64(off)
+			starts = append(starts, next)
+			next.uncompressed += uint64(n)
+		}
+		off += blockSize
+	}
+	return starts, nil
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+)
+
+// bloomFilter is a fixed-size Bloom filter over strings. It answers "maybe
+// present" or "definitely absent"; qc uses it to skip taxid.map lookups for
+// IDs that are not in the map.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // hashes per key
+	seed maphash.Seed
+}
+
+// newBloomFilter sizes a filter for n keys at false-positive rate fpp.
+func newBloomFilter(n int, fpp float64) (*bloomFilter, error) {
+	if fpp <= 0 || fpp >= 1 {
+		return nil, fmt.Errorf("bloom false-positive rate must be in (0, 1), got %g", fpp)
+	}
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpp) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) &^ 63
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &bloomFilter{
+		bits: make([]uint64, m/64),
+		m:    m,
+		k:    k,
+		seed: maphash.MakeSeed(),
+	}, nil
+}
+
+// Positions come from double hashing the two halves of one 64-bit hash.
+func (b *bloomFilter) add(key string) {
+	h := maphash.String(b.seed, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (b *bloomFilter) mayContain(key string) bool {
+	h := maphash.String(b.seed, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sizeBytes reports the memory held by the bit array.
+func (b *bloomFilter) sizeBytes() int {
+	return len(b.bits) * 8
+}
+
+// taxidBloom builds a filter over the keys of a loaded taxid map.
+func taxidBloom(taxidMap map[string]int, fpp float64) (*bloomFilter, error) {
+	b, err := newBloomFilter(len(taxidMap), fpp)
+	if err != nil {
+		return nil, err
+	}
+	for id := range taxidMap {
+		b.add(id)
+	}
+	return b, nil
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilterRates(t *testing.T) {
+	const n = 20000
+	taxidMap := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		taxidMap[fmt.Sprintf("P%d", i)] = i
+	}
+	b, err := taxidBloom(taxidMap, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range taxidMap {
+		if !b.mayContain(id) {
+			t.Fatalf("false negative for %s", id)
+		}
+	}
+	fp := 0
+	for i := 0; i < n; i++ {
+		if b.mayContain(fmt.Sprintf("Q%d", i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / n; rate > 0.02 {
+		t.Fatalf("false-positive rate %.4f, want about 0.01", rate)
+	}
+	for _, fpp := range []float64{0, 1, -0.5} {
+		if _, err := newBloomFilter(n, fpp); err == nil {
+			t.Fatalf("fpp %g: expected error", fpp)
+		}
+	}
+}
+
+func TestQCTaxIDBloomMiss(t *testing.T) {
+	env := &QCFilterEnv{taxidMap: map[string]int{"P1": 7}}
+	b, err := taxidBloom(env.taxidMap, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.taxidBloom = b
+	if taxid, ok := (&QCRecord{ID: "P1", env: env}).TaxID(); !ok || taxid != 7 {
+		t.Fatalf("P1: taxid=%d ok=%v", taxid, ok)
+	}
+	if _, ok := (&QCRecord{ID: "P2", env: env}).TaxID(); ok {
+		t.Fatal("P2: expected missing taxid")
+	}
+}
+
+// BenchmarkQCTaxIDLookup measures taxid.map lookups on a workload where 90%
+// of IDs are absent, with and without the Bloom prefilter.
+func BenchmarkQCTaxIDLookup(b *testing.B) {
+	const n = 1 << 20
+	taxidMap := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		taxidMap[fmt.Sprintf("BOLD%08d", i)] = i
+	}
+	ids := make([]string, 4096)
+	for i := range ids {
+		if i%10 == 0 {
+			ids[i] = fmt.Sprintf("BOLD%08d", i*97%n)
+		} else {
+			ids[i] = fmt.Sprintf("MISS%08d", i)
+		}
+	}
+	bloom, err := taxidBloom(taxidMap, 0.01)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name  string
+		bloom *bloomFilter
+	}{{"map", nil}, {"bloom", bloom}} {
+		b.Run(bc.name, func(b *testing.B) {
+			env := &QCFilterEnv{taxidMap: taxidMap, taxidBloom: bc.bloom}
+			found := 0
+			for i := 0; i < b.N; i++ {
+				r := QCRecord{ID: ids[i%len(ids)], env: env}
+				if _, ok := r.TaxID(); ok {
+					found++
+				}
+			}
+			if b.N >= len(ids) && found == 0 {
+				b.Fatal("no hits")
+			}
+		})
+	}
+}
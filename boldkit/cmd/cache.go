@@ -0,0 +1,429 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheFormat is bumped whenever the key material or entry layout changes,
+// which invalidates every existing entry.
+const cacheFormat = 1
+
+const cacheEntryFile = "entry.json"
+
+// artifactCache stores derived artifacts (qc FASTAs, classifier outputs)
+// under a key that digests the input bytes, the resolved options, and the
+// reference files the run reads. Entries live in objects/<k[:2]>/<k>/ next to
+// an entry.json listing each file's size and SHA-256; the entry.json mtime is
+// the last use, which cache gc prunes by.
+type artifactCache struct {
+	dir string
+
+	mu   sync.Mutex
+	refs map[string]refDigest // memoized reference file hashes by path
+}
+
+type refDigest struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+type cacheEntry struct {
+	Key     string          `json:"key"`
+	Kind    string          `json:"kind"`
+	Created time.Time       `json:"created"`
+	Files   []cacheFile     `json:"files"`
+	Stats   json.RawMessage `json:"stats,omitempty"`
+
+	dir string
+}
+
+type cacheFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// cacheCounters are reported when a run consulted the cache.
+type cacheCounters struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+var cacheHits, cacheMisses atomic.Int64
+
+// cacheStats returns this process's hit and miss counts, or nil outside a
+// command run (keeping test reports deterministic) or when no cache lookup
+// was made.
+func cacheStats() *cacheCounters {
+	if currentRun() == nil {
+		return nil
+	}
+	c := &cacheCounters{Hits: cacheHits.Load(), Misses: cacheMisses.Load()}
+	if c.Hits == 0 && c.Misses == 0 {
+		return nil
+	}
+	return c
+}
+
+// openArtifactCache returns nil when dir is empty (caching disabled).
+func openArtifactCache(dir string) (*artifactCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	for _, sub := range []string{"objects", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("create cache dir: %w", err)
+		}
+	}
+	return &artifactCache{dir: dir, refs: make(map[string]refDigest)}, nil
+}
+
+// cacheKeyMaterial is hashed to form a cache key.
+type cacheKeyMaterial struct {
+	Format  int               `json:"format"`
+	Tool    string            `json:"tool"`
+	Kind    string            `json:"kind"`
+	Input   string            `json:"input"`
+	Options map[string]any    `json:"options"`
+	Refs    map[string]string `json:"refs"`
+}
+
+// cacheOptions returns the exported fields of the config struct cfg, minus
+// exclude, for key material. Fields must be JSON-encodable values.
+func cacheOptions(cfg any, exclude ...string) map[string]any {
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	out := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || containsString(exclude, f.Name) {
+			continue
+		}
+		out[f.Name] = v.Field(i).Interface()
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// key digests the input file, the options, and the named reference files.
+func (c *artifactCache) key(kind, input string, options map[string]any, refs map[string]string) (string, error) {
+	m := cacheKeyMaterial{
+		Format:  cacheFormat,
+		Tool:    appVersion,
+		Kind:    kind,
+		Options: options,
+		Refs:    make(map[string]string, len(refs)),
+	}
+	var err error
+	if m.Input, err = c.digest(input); err != nil {
+		return "", fmt.Errorf("hash input: %w", err)
+	}
+	for name, path := range refs {
+		if m.Refs[name], err = c.digest(path); err != nil {
+			return "", fmt.Errorf("hash %s: %w", name, err)
+		}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("encode cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// digest hashes a file's contents. Hashes are memoized per process while the
+// file's size and mtime are unchanged, since reference files repeat across
+// markers and classifiers.
+func (c *artifactCache) digest(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	d, ok := c.refs[path]
+	c.mu.Unlock()
+	if ok && d.size == info.Size() && d.modTime.Equal(info.ModTime()) {
+		return d.sum, nil
+	}
+	sum, err := sha256File(path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.refs[path] = refDigest{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	c.mu.Unlock()
+	return sum, nil
+}
+
+func (c *artifactCache) entryDir(key string) string {
+	return filepath.Join(c.dir, "objects", key[:2], key)
+}
+
+// lookup returns the entry for key after verifying every file against its
+// recorded checksum. A corrupt entry is removed and reported as a miss.
+func (c *artifactCache) lookup(key string) (*cacheEntry, bool) {
+	dir := c.entryDir(key)
+	data, err := os.ReadFile(filepath.Join(dir, cacheEntryFile))
+	if err != nil {
+		cacheMisses.Add(1)
+		return nil, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil || e.Key != key {
+		logf("cache: removing unreadable entry %s", key[:12])
+		_ = os.RemoveAll(dir)
+		cacheMisses.Add(1)
+		return nil, false
+	}
+	e.dir = dir
+	if err := e.verify(); err != nil {
+		logf("cache: removing corrupt entry %s: %v", key[:12], err)
+		_ = os.RemoveAll(dir)
+		cacheMisses.Add(1)
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(filepath.Join(dir, cacheEntryFile), now, now)
+	cacheHits.Add(1)
+	return &e, true
+}
+
+func (e *cacheEntry) verify() error {
+	for _, f := range e.Files {
+		path := filepath.Join(e.dir, filepath.FromSlash(f.Name))
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() != f.Size {
+			return fmt.Errorf("%s: size %d, recorded %d", f.Name, info.Size(), f.Size)
+		}
+		sum, err := sha256File(path)
+		if err != nil {
+			return err
+		}
+		if sum != f.SHA256 {
+			return fmt.Errorf("%s: checksum mismatch", f.Name)
+		}
+	}
+	return nil
+}
+
+// restore places the cached file name at dest, hardlinking when possible.
+// Writers remove their outputs before recreating them, so a linked output
+// is never rewritten in place.
+func (e *cacheEntry) restore(name, dest string) error {
+	src := filepath.Join(e.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("create output dir: %w", err)
+	}
+	if err := removeIfExists(dest); err != nil {
+		return err
+	}
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	return copyFile(src, dest)
+}
+
+// store copies files and stats into a new entry for key, naming each file
+// by its slash-separated path below root, or by its base name when root is
+// "". Another process storing the same key first wins.
+func (c *artifactCache) store(key, kind, root string, files []string, stats any) error {
+	tmp, err := os.MkdirTemp(filepath.Join(c.dir, "tmp"), key[:12]+"-")
+	if err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	e := cacheEntry{Key: key, Kind: kind, Created: time.Now().UTC()}
+	for _, path := range files {
+		name := filepath.Base(path)
+		if root != "" {
+			rel, err := filepath.Rel(root, path)
+			if err != nil || !filepath.IsLocal(rel) {
+				return fmt.Errorf("cache: %s is outside %s", path, root)
+			}
+			name = filepath.ToSlash(rel)
+		}
+		dest := filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return fmt.Errorf("cache: %w", err)
+		}
+		if err := copyFile(path, dest); err != nil {
+			return fmt.Errorf("cache: %w", err)
+		}
+		sum, err := sha256File(dest)
+		if err != nil {
+			return fmt.Errorf("cache: %w", err)
+		}
+		e.Files = append(e.Files, cacheFile{Name: name, Size: fileSize(dest), SHA256: sum})
+	}
+	if e.Stats, err = json.Marshal(stats); err != nil {
+		return fmt.Errorf("cache: encode stats: %w", err)
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cache: encode entry: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, cacheEntryFile), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	dir := c.entryDir(key)
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if fileExists(filepath.Join(dir, cacheEntryFile)) {
+			return nil
+		}
+		return fmt.Errorf("cache: %w", err)
+	}
+	return nil
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove %s: %w", path, err)
+	}
+	return nil
+}
+
+func runCache(args []string) {
+	if len(args) < 1 || args[0] != "gc" {
+		fatalf("usage: boldkit cache gc -cache-dir DIR -max-size SIZE")
+	}
+	fs := flag.NewFlagSet("cache gc", flag.ExitOnError)
+	dir := fs.String("cache-dir", "", "Derived-artifact cache directory")
+	maxSize := fs.String("max-size", "", "Evict least recently used entries until the cache fits (e.g. 200G; K/M/G/T are powers of 1024)")
+	if err := fs.Parse(args[1:]); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *dir == "" || *maxSize == "" {
+		fatalf("cache gc requires -cache-dir and -max-size")
+	}
+	if !fileExists(*dir) {
+		fatalf("cache dir %s does not exist", *dir)
+	}
+	limit, err := parseByteSize(*maxSize)
+	if err != nil {
+		fatalf("invalid -max-size: %v", err)
+	}
+	res, err := gcArtifactCache(*dir, limit, time.Now())
+	if err != nil {
+		fatalf("cache gc failed: %v", err)
+	}
+	logf("cache gc: kept %d entries (%s), evicted %d entries (%s)", res.Kept, formatMiB(uint64(res.KeptBytes)), res.Evicted, formatMiB(uint64(res.EvictedBytes)))
+}
+
+type cacheGCResult struct {
+	Kept, Evicted           int
+	KeptBytes, EvictedBytes int64
+}
+
+// gcArtifactCache evicts the least recently used entries until the cache
+// holds at most maxBytes, and clears abandoned temporary directories.
+func gcArtifactCache(dir string, maxBytes int64, now time.Time) (cacheGCResult, error) {
+	var res cacheGCResult
+	tmps, _ := os.ReadDir(filepath.Join(dir, "tmp"))
+	for _, t := range tmps {
+		if info, err := t.Info(); err == nil && now.Sub(info.ModTime()) > time.Hour {
+			_ = os.RemoveAll(filepath.Join(dir, "tmp", t.Name()))
+		}
+	}
+
+	type entryInfo struct {
+		dir     string
+		size    int64
+		lastUse time.Time
+	}
+	var entries []entryInfo
+	var total int64
+	matches, err := filepath.Glob(filepath.Join(dir, "objects", "*", "*", cacheEntryFile))
+	if err != nil {
+		return res, err
+	}
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		e := entryInfo{dir: filepath.Dir(m), lastUse: info.ModTime()}
+		files, _ := os.ReadDir(e.dir)
+		for _, f := range files {
+			if fi, err := f.Info(); err == nil {
+				e.size += fi.Size()
+			}
+		}
+		entries = append(entries, e)
+		total += e.size
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lastUse.Before(entries[j].lastUse) })
+	for _, e := range entries {
+		if total <= maxBytes {
+			res.Kept++
+			res.KeptBytes += e.size
+			continue
+		}
+		if err := os.RemoveAll(e.dir); err != nil {
+			return res, fmt.Errorf("evict %s: %w", e.dir, err)
+		}
+		total -= e.size
+		res.Evicted++
+		res.EvictedBytes += e.size
+	}
+	return res, nil
+}
+
+// parseByteSize parses sizes like "200G" or "512M" (binary multiples).
+func parseByteSize(raw string) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	shift := 0
+	if n := len(raw); n > 0 {
+		switch raw[n-1] {
+		case 'K', 'k':
+			shift = 10
+		case 'M', 'm':
+			shift = 20
+		case 'G', 'g':
+			shift = 30
+		case 'T', 't':
+			shift = 40
+		}
+		if shift > 0 {
+			raw = raw[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("size must be >= 0")
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mutateField changes v to a different value of the same type.
+func mutateField(t *testing.T, name string, v reflect.Value) {
+	t.Helper()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(v.String() + "x")
+	case reflect.Int, reflect.Int64:
+		v.SetInt(v.Int() + 1)
+	case reflect.Float64:
+		v.SetFloat(v.Float() + 0.5)
+	case reflect.Bool:
+		v.SetBool(!v.Bool())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			t.Fatalf("field %s: unsupported slice type %s", name, v.Type())
+		}
+		v.Set(reflect.Append(v, reflect.ValueOf("x").Convert(v.Type().Elem())))
+	default:
+		t.Fatalf("field %s: unsupported kind %s; teach mutateField or exclude it from the key", name, v.Kind())
+	}
+}
+
+// checkKeyCoversFields mutates every field of base in turn and requires the
+// key to change unless the field is listed in uncached.
+func checkKeyCoversFields[T any](t *testing.T, base T, uncached []string, key func(T) (string, error)) {
+	t.Helper()
+	typ := reflect.TypeOf(base)
+	for _, name := range uncached {
+		if _, ok := typ.FieldByName(name); !ok {
+			t.Fatalf("uncached option %s is not a field of %s", name, typ)
+		}
+	}
+	baseKey, err := key(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if containsString(uncached, f.Name) {
+			continue
+		}
+		cfg := base
+		mutateField(t, f.Name, reflect.ValueOf(&cfg).Elem().Field(i))
+		got, err := key(cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if got == baseKey {
+			t.Errorf("changing %s.%s does not change the cache key", typ.Name(), f.Name)
+		}
+	}
+}
+
+func newCacheFixture(t *testing.T) (dir string, cache *artifactCache) {
+	t.Helper()
+	dir = t.TempDir()
+	writeTaxdumpFixture(t, dir)
+	if err := os.WriteFile(filepath.Join(dir, "in.fasta"), []byte(qcFixtureFasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := openArtifactCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, cache
+}
+
+func TestQCCacheKeyCoversOptions(t *testing.T) {
+	dir, cache := newCacheFixture(t)
+	input := filepath.Join(dir, "in.fasta")
+	base := qcConfig{
+		MaxN:         -1,
+		MaxAmbig:     -1,
+		DedupeSeqs:   true,
+		RequireRanks: []string{"species"},
+		FilterOrder:  []string{"length"},
+		TaxdumpDir:   dir,
+		Cache:        cache,
+	}
+	checkKeyCoversFields(t, base, qcUncachedOptions, func(cfg qcConfig) (string, error) {
+		return qcCacheKey(input, cfg)
+	})
+}
+
+func TestFormatCacheKeyCoversOptions(t *testing.T) {
+	dir, cache := newCacheFixture(t)
+	base := formatConfig{
+		Classifiers:  []string{"blast"},
+		RequireRanks: []string{"species"},
+		Input:        filepath.Join(dir, "in.fasta"),
+		TaxdumpDir:   dir,
+		Sanitize:     sanitizeTranslit,
+		Cache:        cache,
+	}
+	checkKeyCoversFields(t, base, formatUncachedOptions, formatCacheKey)
+}
+
+func TestCacheKeyFollowsContent(t *testing.T) {
+	dir, cache := newCacheFixture(t)
+	input := filepath.Join(dir, "in.fasta")
+	cfg := qcConfig{RequireRanks: []string{"species"}, TaxdumpDir: dir, Cache: cache}
+	base, err := qcCacheKey(input, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same bytes under another path: same key.
+	moved := t.TempDir()
+	writeTaxdumpFixture(t, moved)
+	copyInput := filepath.Join(moved, "copy.fasta")
+	if err := copyFile(input, copyInput); err != nil {
+		t.Fatal(err)
+	}
+	movedCfg := cfg
+	movedCfg.TaxdumpDir = moved
+	if got, _ := qcCacheKey(copyInput, movedCfg); got != base {
+		t.Fatalf("same content under another path changed the key")
+	}
+
+	// Different input bytes or taxdump bytes: different key.
+	if err := os.WriteFile(copyInput, []byte(">P1\nACGT\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := qcCacheKey(copyInput, cfg); got == base {
+		t.Fatalf("input change kept the key")
+	}
+	names := filepath.Join(moved, "names.dmp")
+	if err := os.WriteFile(names, []byte("1\t|\troot\t|\t\t|\tscientific name\t|\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := qcCacheKey(input, movedCfg); got == base {
+		t.Fatalf("taxdump change kept the key")
+	}
+
+	// Another tool version invalidates entries.
+	old := appVersion
+	appVersion = "other"
+	defer func() { appVersion = old }()
+	if got, _ := qcCacheKey(input, cfg); got == base {
+		t.Fatalf("tool version change kept the key")
+	}
+}
+
+func TestQCCacheHitAndIntegrity(t *testing.T) {
+	dir, cache := newCacheFixture(t)
+	input := filepath.Join(dir, "in.fasta")
+	cfg := qcConfig{
+		MaxN:         -1,
+		MaxAmbig:     -1,
+		DedupeSeqs:   true,
+		DedupeIDs:    true,
+		RequireRanks: splitList("kingdom,phylum,class,order,family,genus,species"),
+		TaxdumpDir:   dir,
+		OutputPath:   filepath.Join(dir, "out", "qc.fasta"),
+		Cache:        cache,
+	}
+	hits, misses := cacheHits.Load(), cacheMisses.Load()
+	if err := qcFasta(context.Background(), input, cfg); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(cfg.OutputPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := qcFasta(context.Background(), input, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("cached output differs:\n got %q\nwant %q", got, want)
+	}
+	if cacheHits.Load()-hits != 1 || cacheMisses.Load()-misses != 1 {
+		t.Fatalf("hits=%d misses=%d", cacheHits.Load()-hits, cacheMisses.Load()-misses)
+	}
+
+	// Corrupt the cached copy: the entry is dropped and qc reruns.
+	key, err := qcCacheKey(input, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := filepath.Join(cache.entryDir(key), "qc.fasta")
+	if err := os.Remove(cached); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cached, []byte(">P9\nAAAAAAAAAA\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := qcFasta(context.Background(), input, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = os.ReadFile(cfg.OutputPath)
+	if string(got) != string(want) || cacheMisses.Load()-misses != 2 {
+		t.Fatalf("corrupt entry reused: %q", got)
+	}
+}
+
+func TestGCArtifactCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := openArtifactCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "artifact")
+	if err := os.WriteFile(src, make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	keys := []string{"aa01", "bb02", "cc03"}
+	for i, k := range keys {
+		key := k + strings.Repeat("0", 60)
+		keys[i] = key
+		if err := cache.store(key, "test", "", []string{src}, nil); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(filepath.Join(cache.entryDir(key), cacheEntryFile), used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Touch the oldest entry: it becomes the most recently used.
+	if _, ok := cache.lookup(keys[0]); !ok {
+		t.Fatal("lookup missed")
+	}
+	res, err := gcArtifactCache(dir, 3000, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Evicted != 1 || res.Kept != 2 {
+		t.Fatalf("result %+v", res)
+	}
+	if fileExists(cache.entryDir(keys[1])) || !fileExists(cache.entryDir(keys[0])) || !fileExists(cache.entryDir(keys[2])) {
+		t.Fatal("gc evicted the wrong entry")
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{"0": 0, "512": 512, "4K": 4 << 10, "200G": 200 << 30, "1t": 1 << 40}
+	for raw, want := range cases {
+		if got, err := parseByteSize(raw); err != nil || got != want {
+			t.Fatalf("%q: got %d err=%v want %d", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"", "G", "-1", "1.5G"} {
+		if _, err := parseByteSize(raw); err == nil {
+			t.Fatalf("%q: expected error", raw)
+		}
+	}
+}
+
+func TestFormatCacheHit(t *testing.T) {
+	dir, cache := newCacheFixture(t)
+	cfg := formatConfig{
+		Classifiers:  []string{"blast", "rdp"},
+		RequireRanks: splitList("kingdom,phylum,class,order,family,genus,species"),
+		Input:        filepath.Join(dir, "in.fasta"),
+		OutDir:       filepath.Join(dir, "first"),
+		TaxdumpDir:   dir,
+		Cache:        cache,
+	}
+	if err := formatFasta(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	hits := cacheHits.Load()
+	cfg.OutDir = filepath.Join(dir, "second")
+	if err := formatFasta(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cacheHits.Load()-hits != 1 {
+		t.Fatal("second run missed the cache")
+	}
+	for _, name := range []string{"blast.fasta", "blast_seqid2taxid.map", "rdp_train_seqs.fasta", "rdp_taxonomy.txt"} {
+		want, err := os.ReadFile(filepath.Join(dir, "first", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, "second", name))
+		if err != nil || string(got) != string(want) {
+			t.Fatalf("%s: got %q err=%v want %q", name, got, err, want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"path/filepath"
@@ -9,13 +10,16 @@ import (
 
 func runClassify(args []string) {
 	fs := flag.NewFlagSet("classify", flag.ExitOnError)
-	input := fs.String("input", "", "Input FASTA/FASTA.gz")
-	outDir := fs.String("outdir", "classifier_outputs", "Output directory")
-	classifiers := fs.String("classifier", "blast", "Comma-separated classifiers")
-	markerDir := fs.String("marker-dir", "marker_fastas", "Marker FASTA directory (used when -input is empty)")
+	input := fs.String("input", "", "Input FASTA, plain or gzip/bgzip (- reads stdin)")
+	outDir := fs.String("outdir", "", "Output directory (default: classifier_outputs.<snapshot>, or "+legacyClassifyOutDir+" without a snapshot ID)")
+	classifiers := fs.String("classifier", "blast", "Comma-separated classifiers, formatted together in one pass (list prints them)")
+	markerDir := fs.String("marker-dir", "", "Marker FASTA directory used when -input is empty (default: marker_fastas.<snapshot>, or "+legacyMarkerDir+" without a snapshot ID)")
+	snapshot := addSnapshotFlag(fs, "a marker_fastas.<snapshot> -input or -marker-dir")
 	markers := fs.String("markers", "COI-5P", "Comma-separated markers to process (used when -input is empty)")
 	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Taxdump directory with nodes.dmp/names.dmp/taxid.map")
 	taxidMap := fs.String("taxid-map", "", "Optional taxid.map override")
+	excludeIDs := fs.String("exclude-ids", "", "QC drop records whose ID is listed in this file (one per line, .gz ok, # comments)")
+	includeIDs := fs.String("include-ids", "", "QC keep only records whose ID is listed in this file (one per line, .gz ok, # comments)")
 	requireRanks := fs.String("require-ranks", "kingdom,phylum,class,order,family,genus,species", "Comma-separated ranks required to keep a sequence (empty disables)")
 	qcMin := fs.Int("qc-min-length", 200, "QC minimum cleaned length")
 	qcMax := fs.Int("qc-max-length", 700, "QC maximum cleaned length")
@@ -27,17 +31,86 @@ func runClassify(args []string) {
 	qcProgress := fs.Bool("qc-progress", true, "Show QC progress bar (approximate)")
 	formatProgress := fs.Bool("format-progress", true, "Show format progress bar (approximate)")
 	qcOnly := fs.Bool("qc-only", false, "Run QC only (skip classifier formatting)")
+	qcGzip := fs.Bool("qc-gzip", false, "Write the QC output as qc/<name>.fasta.gz; the formatters read it compressed")
+	qcRejects := fs.Bool("qc-rejects", false, "Write QC-dropped records to qc/<name>.rejects.fasta and qc/<name>.rejects.tsv")
 	compress := fs.Bool("compress", false, "Compress classifier output directories (.tar.gz)")
 	force := fs.Bool("force", false, "Overwrite existing archives")
+	sanitize := fs.String("sanitize", string(defaultSanitizeMode), "Taxon/marker name sanitation: translit (fold diacritics to ASCII) or ascii (replace every non-ASCII byte)")
+	uniqueIDsScope := fs.String("unique-ids-scope", idScopePerMarker, "Sequence ID uniqueness: per-marker, or global across the markers written to each classifier")
+	onCollision := fs.String("on-collision", collisionSuffix, "With -unique-ids-scope=global: suffix colliding IDs with the marker name, or drop them")
+	report := fs.String("report", "", "Optional JSON report output path")
+	cacheDir := fs.String("cache-dir", "", "Reuse qc and format outputs from identical earlier runs cached in this directory")
+	noTaxonomy := fs.Bool("no-taxonomy", false, "Skip taxid.map and the taxdump: no taxid or rank checks; only sequence-only classifiers (blast)")
+	provenanceDir := fs.String("provenance-dir", "", "Record per-record provenance events under this directory (one subdirectory per marker when -input is empty)")
 	if err := fs.Parse(args); err != nil {
 		fatalf("parse args failed: %v", err)
 	}
+	if *classifiers == "list" {
+		printClassifiers()
+		return
+	}
+	if *noTaxonomy {
+		if err := checkNoTaxonomyFlags(fs); err != nil {
+			fatalf("%v", err)
+		}
+		*requireRanks = ""
+	}
+
+	snap := markerStageSnapshot(*snapshot, *input, *markerDir)
+	if *markerDir == "" {
+		*markerDir = snapshotPath(legacyMarkerDir, snap)
+	}
+	if *outDir == "" {
+		*outDir = snapshotPath(legacyClassifyOutDir, snap)
+	}
 
 	ranks := splitList(*requireRanks)
-	classifierList := splitList(*classifiers)
-	if len(classifierList) == 0 {
+	entries, err := lookupClassifiers(splitList(*classifiers))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(entries) == 0 {
 		fatalf("classifier must not be empty")
 	}
+	classifierList := make([]string, len(entries))
+	for i, e := range entries {
+		classifierList[i] = e.name
+	}
+	if *noTaxonomy && !*qcOnly {
+		if err := checkNoTaxonomyClassifiers(classifierList); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	sanitizeMode, err := parseSanitizeMode(*sanitize)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	ids, err := parseIDScope(*uniqueIDsScope, *onCollision)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	cache, err := openArtifactCache(*cacheDir)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	// Every marker reads the same taxdump; load it once, on first use.
+	var taxdump *taxDumpHandle
+	if !*noTaxonomy {
+		taxdump = newTaxDumpHandle(*taxdumpDir, nil)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	stageInput := *input
+	if stageInput == "" {
+		stageInput = *markerDir
+	}
+	// failed records the interrupted or failed run before exiting.
+	failed := func(err error, format string, args ...any) {
+		err = recordStage(*outDir, "classify", stageInput, err)
+		exitIfInterrupted(err)
+		fatalf(format, append(args, err)...)
+	}
 
 	if *input == "" {
 		markerList := splitList(*markers)
@@ -50,38 +123,98 @@ func runClassify(args []string) {
 				fatalf("marker %s: %v", marker, err)
 			}
 			baseOut := filepath.Join(*outDir, safeTag(marker))
-			if err := classifyOne(markerInput, baseOut, classifierList, ranks, *taxdumpDir, *taxidMap, *qcMin, *qcMax, *qcMaxN, *qcMaxAmbig, *qcMaxInvalid, *qcDedupe, *qcDedupeIDs, *qcProgress, *formatProgress, *qcOnly, *compress, *force); err != nil {
-				fatalf("classify %s failed: %v", marker, err)
+			if err := classifyOne(ctx, markerInput, baseOut, classifierList, ranks, *taxdumpDir, *taxidMap, *excludeIDs, *includeIDs, *qcMin, *qcMax, *qcMaxN, *qcMaxAmbig, *qcMaxInvalid, *qcDedupe, *qcDedupeIDs, *qcProgress, *formatProgress, *qcOnly, *qcRejects, *qcGzip, *compress, *force, *noTaxonomy, sanitizeMode, marker, markerProvenanceDir(*provenanceDir, marker), ids, cache, taxdump); err != nil {
+				failed(err, "classify %s failed: %v", marker)
 			}
 		}
-		return
+	} else {
+		// A single input has nothing to collide with.
+		if err := classifyOne(ctx, *input, *outDir, classifierList, ranks, *taxdumpDir, *taxidMap, *excludeIDs, *includeIDs, *qcMin, *qcMax, *qcMaxN, *qcMaxAmbig, *qcMaxInvalid, *qcDedupe, *qcDedupeIDs, *qcProgress, *formatProgress, *qcOnly, *qcRejects, *qcGzip, *compress, *force, *noTaxonomy, sanitizeMode, qcBaseName(*input), *provenanceDir, nil, cache, taxdump); err != nil {
+			failed(err, "classify failed: %v")
+		}
+	}
+	if err := recordStage(*outDir, "classify", stageInput, nil); err != nil {
+		fatalf("%v", err)
 	}
 
-	if err := classifyOne(*input, *outDir, classifierList, ranks, *taxdumpDir, *taxidMap, *qcMin, *qcMax, *qcMaxN, *qcMaxAmbig, *qcMaxInvalid, *qcDedupe, *qcDedupeIDs, *qcProgress, *formatProgress, *qcOnly, *compress, *force); err != nil {
-		fatalf("classify failed: %v", err)
+	if *report != "" {
+		if err := writeClassifyReport(*report, snap, *uniqueIDsScope, *onCollision, ids); err != nil {
+			fatalf("write report: %v", err)
+		}
 	}
 }
 
-func classifyOne(input, outDir string, classifierList, ranks []string, taxdumpDir, taxidMap string, qcMin, qcMax, qcMaxN, qcMaxAmbig, qcMaxInvalid int, qcDedupe, qcDedupeIDs, qcProgress, formatProgress, qcOnly, compress, force bool) error {
+// classifyReport records the ID uniqueness settings and every decision taken
+// for an ID that an earlier marker already wrote.
+type classifyReport struct {
+	reportHeader
+	SnapshotID     string         `json:"snapshot_id,omitempty"`
+	UniqueIDsScope string         `json:"unique_ids_scope"`
+	OnCollision    string         `json:"on_collision"`
+	Renamed        int            `json:"renamed"`
+	Dropped        int            `json:"dropped"`
+	Collisions     []idCollision  `json:"collisions"`
+	Cache          *cacheCounters `json:"cache,omitempty"`
+	Resources      *runResources  `json:"resources,omitempty"`
+}
+
+func writeClassifyReport(path, snapshot, scope, onCollision string, ids *idRegistry) error {
+	report := classifyReport{
+		reportHeader:   newReportHeader("classify-report"),
+		SnapshotID:     snapshot,
+		UniqueIDsScope: scope,
+		OnCollision:    onCollision,
+		Collisions:     ids.decisions(),
+		Cache:          cacheStats(),
+		Resources:      currentRun().resources(),
+	}
+	if report.Collisions == nil {
+		report.Collisions = []idCollision{}
+	}
+	for _, c := range report.Collisions {
+		if c.Action == "dropped" {
+			report.Dropped++
+		} else {
+			report.Renamed++
+		}
+	}
+	return writeReportJSON(path, report)
+}
+
+func classifyOne(ctx context.Context, input, outDir string, classifierList, ranks []string, taxdumpDir, taxidMap, excludeIDs, includeIDs string, qcMin, qcMax, qcMaxN, qcMaxAmbig, qcMaxInvalid int, qcDedupe, qcDedupeIDs, qcProgress, formatProgress, qcOnly, qcRejects, qcGzip, compress, force, noTaxonomy bool, sanitize nameSanitizer, marker, provenanceDir string, ids *idRegistry, cache *artifactCache, taxdump *taxDumpHandle) error {
 	base := qcBaseName(input)
 	qcOut := filepath.Join(outDir, "qc", base+".fasta")
+	if qcGzip {
+		qcOut += ".gz"
+	}
 	qcCfg := qcConfig{
-		MinLen:       qcMin,
-		MaxLen:       qcMax,
-		MaxN:         qcMaxN,
-		MaxAmbig:     qcMaxAmbig,
-		MaxInvalid:   qcMaxInvalid,
-		DedupeSeqs:   qcDedupe,
-		DedupeIDs:    qcDedupeIDs,
-		RequireRanks: ranks,
-		TaxdumpDir:   taxdumpDir,
-		TaxidMapPath: taxidMap,
-		OutputPath:   qcOut,
-		Progress:     qcProgress,
+		MinLen:        qcMin,
+		MaxLen:        qcMax,
+		MaxN:          qcMaxN,
+		MaxAmbig:      qcMaxAmbig,
+		MaxInvalid:    qcMaxInvalid,
+		DedupeSeqs:    qcDedupe,
+		DedupeIDs:     qcDedupeIDs,
+		RequireRanks:  ranks,
+		TaxdumpDir:    taxdumpDir,
+		TaxidMapPath:  taxidMap,
+		ExcludeIDs:    excludeIDs,
+		IncludeIDs:    includeIDs,
+		OutputPath:    qcOut,
+		MaxErrors:     -1,
+		Progress:      qcProgress,
+		Cache:         cache,
+		Taxdump:       taxdump,
+		NoTaxonomy:    noTaxonomy,
+		ProvenanceDir: provenanceDir,
+	}
+	if qcRejects {
+		qcCfg.RejectsOutput = filepath.Join(outDir, "qc", base+".rejects.fasta")
+		qcCfg.RejectsTSV = filepath.Join(outDir, "qc", base+".rejects.tsv")
 	}
 
 	logf("QC -> %s", qcOut)
-	if err := qcFasta(input, qcCfg); err != nil {
+	if err := qcFasta(ctx, input, qcCfg); err != nil {
 		return fmt.Errorf("qc failed: %w", err)
 	}
 
@@ -89,29 +222,34 @@ func classifyOne(input, outDir string, classifierList, ranks []string, taxdumpDi
 		return nil
 	}
 
-	for _, classifier := range classifierList {
-		if classifier == "" {
-			continue
-		}
-		name := strings.ToLower(classifier)
-		outPath := filepath.Join(outDir, name)
-		cfg := formatConfig{
-			Classifiers:  []string{name},
-			RequireRanks: ranks,
-			Input:        qcOut,
-			OutDir:       outPath,
-			TaxdumpDir:   taxdumpDir,
-			TaxidMapPath: taxidMap,
-			Progress:     formatProgress,
-		}
-		logf("Format %s -> %s", name, outPath)
-		if err := formatFasta(cfg); err != nil {
-			return fmt.Errorf("format %s failed: %w", name, err)
-		}
+	// One pass over the qc output writes every classifier, each into
+	// outDir/<classifier>.
+	cfg := formatConfig{
+		Classifiers:    classifierList,
+		RequireRanks:   ranks,
+		Input:          qcOut,
+		OutDir:         outDir,
+		ClassifierDirs: true,
+		TaxdumpDir:     taxdumpDir,
+		TaxidMapPath:   taxidMap,
+		Progress:       formatProgress,
+		Sanitize:       sanitize,
+		IDs:            ids,
+		Marker:         marker,
+		Cache:          cache,
+		Taxdump:        taxdump,
+		NoTaxonomy:     noTaxonomy,
+		ProvenanceDir:  provenanceDir,
+	}
+	logf("Format %s -> %s", strings.Join(classifierList, ","), outDir)
+	if err := formatFasta(ctx, cfg); err != nil {
+		return fmt.Errorf("format failed: %w", err)
+	}
 
-		if compress {
+	if compress {
+		for _, name := range classifierList {
 			archive := filepath.Join(outDir, name+".tar.gz")
-			if err := packageDirGzip(outPath, archive, force); err != nil {
+			if err := packageDirGzip(filepath.Join(outDir, name), archive, force, true); err != nil {
 				return fmt.Errorf("compress %s failed: %w", name, err)
 			}
 		}
@@ -120,6 +258,9 @@ func classifyOne(input, outDir string, classifierList, ranks []string, taxdumpDi
 }
 
 func qcBaseName(path string) string {
+	if path == stdinPath {
+		return "stdin"
+	}
 	base := filepath.Base(path)
 	base = strings.TrimSuffix(base, ".gz")
 	ext := filepath.Ext(base)
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// outputCompression is the -compression mode of FASTA outputs.
+type outputCompression string
+
+const (
+	compressionGzip outputCompression = "gzip"
+	// compressionBGZF writes bgzip blocks and a .gzi index next to the
+	// output, so samtools faidx and pyfaidx can seek into it.
+	compressionBGZF outputCompression = "bgzf"
+	compressionNone outputCompression = "none"
+)
+
+// compressionUsage is the -compression flag help of markers and qc.
+const compressionUsage = "FASTA output compression: gzip, bgzf (bgzip blocks plus a .gzi index, for samtools faidx and pyfaidx), or none (default: per -gzip)"
+
+// resolveCompression returns the -compression mode, or legacy, the mode the
+// older -gzip and -bgzip flags select, when it is unset. The two cannot be
+// combined.
+func resolveCompression(fs *flag.FlagSet, value string, legacy outputCompression) (outputCompression, error) {
+	if value == "" {
+		return legacy, nil
+	}
+	var set []string
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "gzip", "bgzip":
+			set = append(set, "-"+f.Name)
+		}
+	})
+	if len(set) > 0 {
+		return "", fmt.Errorf("-compression cannot be combined with %s", strings.Join(set, ", "))
+	}
+	switch c := outputCompression(strings.ToLower(strings.TrimSpace(value))); c {
+	case compressionGzip, compressionBGZF, compressionNone:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown -compression %q (want gzip, bgzf, or none)", value)
+}
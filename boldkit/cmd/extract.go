@@ -1,12 +1,11 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"strings"
 )
 
 const writerBufferSize = 1 << 20
@@ -14,72 +13,105 @@ const writerBufferSize = 1 << 20
 func runExtract(args []string) {
 	fs := flag.NewFlagSet("extract", flag.ExitOnError)
 	input := fs.String("input", "BOLD_Public.*/BOLD_Public.*.tsv", "BOLD input file (TSV or Parquet)")
-	output := fs.String("output", "taxonkit_input.tsv", "Output taxonkit input TSV")
+	output := fs.String("output", "", "Output taxonkit input TSV (default: taxonkit_input.<snapshot>.tsv.gz, or "+legacyTaxonkitOutput+" without a snapshot ID)")
+	snapshot := addSnapshotFlag(fs, "the -input filename")
 	curateProtocol := fs.String("curate-protocol", extractCurationProtocolNone, "Extraction curation profile (none,bioscan-5m)")
 	curateReport := fs.String("curate-report", "", "Optional extraction curation JSON report path")
 	curateAudit := fs.String("curate-audit", "", "Optional extraction curation audit TSV path")
+	inputFlags := addInputFlags(fs)
+	sampleFlags := addSampleFlags(fs)
 	progressOn := fs.Bool("progress", true, "Show progress bar")
 	force := fs.Bool("force", false, "Overwrite existing outputs")
 	if err := fs.Parse(args); err != nil {
 		fatalf("parse args failed: %v", err)
 	}
+	snap := resolveSnapshot(*snapshot, *input)
+	if *output == "" {
+		*output = snapshotPath(legacyTaxonkitOutput, snap)
+	}
 	curationCfg := extractCurationConfig{
 		Protocol:   *curateProtocol,
 		ReportPath: *curateReport,
 		AuditPath:  *curateAudit,
+		Snapshot:   snap,
 	}.normalized()
 	if err := curationCfg.validate(); err != nil {
 		fatalf("invalid extraction curation config: %v", err)
 	}
 
-	if !*force && fileExists(*output) {
+	if !*force && fileExists(*output) && !outputStale(*output) {
 		fmt.Fprintf(os.Stderr, "Output exists, skipping: %s\n", *output)
 		return
 	}
 
-	totalRows := -1
-	if *progressOn {
-		count, err := RowCount(*input)
-		if err != nil {
-			fatalf("count rows failed: %v", err)
-		}
-		totalRows = int(count)
-	}
+	totalRows := progressTotal(*input, *progressOn, *inputFlags.noCount)
 
 	reportEvery := 0
 	if *progressOn {
 		reportEvery = 1
 	}
 
-	if _, err := buildTaxonkit(*input, *output, reportEvery, totalRows, curationCfg); err != nil {
-		fatalf("build failed: %v", err)
+	inputCfg, err := inputFlags.config()
+	if err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	if inputCfg.Sample, err = sampleFlags.config(); err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	_, buildErr := buildTaxonkit(ctx, *input, *output, reportEvery, totalRows, curationCfg, inputCfg)
+	if err := inputCfg.Close(); err != nil && buildErr == nil {
+		buildErr = err
 	}
+	record := recordStage
+	if inputCfg.Sample.enabled() {
+		record = recordSampledStage
+	}
+	exitOnStageError(record(*output, "extract", *input, buildErr))
 }
 
-func buildTaxonkit(inputPath, outputPath string, reportEvery, totalRows int, curationCfg extractCurationConfig) (int, error) {
+// taxonkitColumns is the header of the extract output.
+var taxonkitColumns = []string{"kingdom", "phylum", "class", "order", "family", "subfamily", "tribe", "genus", "species", "processid"}
+
+func buildTaxonkit(ctx context.Context, inputPath, outputPath string, reportEvery, totalRows int, curationCfg extractCurationConfig, inputCfg inputConfig) (int, error) {
 	curator, err := newExtractCurator(curationCfg, inputPath)
 	if err != nil {
 		return 0, fmt.Errorf("create curation profile: %w", err)
 	}
 
-	out, err := os.Create(outputPath)
+	writer, err := createTSVWriter(outputPath, tsvWriterOptions{})
 	if err != nil {
 		return 0, fmt.Errorf("create output: %w", err)
 	}
 	defer func() {
-		_ = out.Close()
+		_ = writer.Close()
 	}()
 
-	writer := bufio.NewWriterSize(out, writerBufferSize)
-	defer func() {
-		_ = writer.Flush()
-	}()
-
-	progress := newProgress(totalRows, reportEvery)
+	progress, bytesBar := inputCfg.progress("extract", inputPath, totalRows, reportEvery)
 
 	opts := DefaultOptions()
+	opts = inputCfg.Tuning.apply(opts)
+	opts.QuotedFields = inputCfg.QuotedFields
 	opts.Progress = progress
+	if bytesBar != nil {
+		opts.ByteProgress = bytesBar
+	}
 	opts.SkipProgressFirstRow = true
+	if reportEvery > 0 {
+		opts.Stats = &ParseStats{}
+	}
+	quarantine := inputCfg.Quarantine
+	quarantine.setStage("extract")
+	opts = quarantine.apply(opts)
+	opts = inputCfg.applyMaxErrors(opts)
+	opts = inputCfg.Sample.apply(opts)
+	if inputCfg.Sample.enabled() {
+		logf("extract: sampling %s", inputCfg.Sample)
+	}
+	if err := opts.Validate(); err != nil {
+		return 0, fmt.Errorf("parser options: %w", err)
+	}
 
 	var rowCount int
 	var (
@@ -96,27 +128,39 @@ func buildTaxonkit(inputPath, outputPath string, reportEvery, totalRows int, cur
 		idxSpecies   = -1
 	)
 
-	err = ParseRows(inputPath, opts, func(row Row) error {
-		if idxProcess < 0 {
-			idxProcess = indexOfBytes(row.Fields, "processid")
-			idxBin = indexOfBytes(row.Fields, "bin_uri")
-			idxKingdom = indexOfBytes(row.Fields, "kingdom")
-			idxPhylum = indexOfBytes(row.Fields, "phylum")
-			idxClass = indexOfBytes(row.Fields, "class")
-			idxOrder = indexOfBytes(row.Fields, "order")
-			idxFamily = indexOfBytes(row.Fields, "family")
-			idxSubfamily = indexOfBytes(row.Fields, "subfamily")
-			idxTribe = indexOfBytes(row.Fields, "tribe")
-			idxGenus = indexOfBytes(row.Fields, "genus")
-			idxSpecies = indexOfBytes(row.Fields, "species")
-			if idxProcess < 0 || idxBin < 0 || idxKingdom < 0 || idxPhylum < 0 || idxClass < 0 ||
-				idxOrder < 0 || idxFamily < 0 || idxGenus < 0 || idxSpecies < 0 {
-				return errors.New("required headers missing in input")
-			}
-			_, err := writer.WriteString("kingdom\tphylum\tclass\torder\tfamily\tsubfamily\ttribe\tgenus\tspecies\tprocessid\n")
+	// The header is consumed by the parser, so sampling never drops it.
+	opts.HasHeader = true
+	opts.OnHeader = func(h *Header) error {
+		if err := inputCfg.Header.check("extract", h.fields); err != nil {
 			return err
 		}
+		idxProcess = h.Index("processid")
+		idxBin = h.Index("bin_uri")
+		idxKingdom = h.Index("kingdom")
+		idxPhylum = h.Index("phylum")
+		idxClass = h.Index("class")
+		idxOrder = h.Index("order")
+		idxFamily = h.Index("family")
+		idxSubfamily = h.Index("subfamily")
+		idxTribe = h.Index("tribe")
+		idxGenus = h.Index("genus")
+		idxSpecies = h.Index("species")
+		if idxProcess < 0 || idxBin < 0 || idxKingdom < 0 || idxPhylum < 0 || idxClass < 0 ||
+			idxOrder < 0 || idxFamily < 0 || idxGenus < 0 || idxSpecies < 0 {
+			return errors.New("required headers missing in input")
+		}
+		return writer.WriteHeader(taxonkitColumns)
+	}
+
+	prov, err := openProvenanceLog(inputCfg.ProvenanceDir, "extract")
+	if err != nil {
+		return 0, err
+	}
 
+	var lastLine int64
+	outRow := make([]string, 0, len(taxonkitColumns))
+	err = parseRowsContext(ctx, inputPath, opts, func(row Row) error {
+		lastLine = row.Line
 		rowCount++
 		fields := row.Fields
 
@@ -133,9 +177,17 @@ func buildTaxonkit(inputPath, outputPath string, reportEvery, totalRows int, cur
 			Genus:     string(normalizeBytes(fieldBytes(fields, idxGenus))),
 			Species:   string(normalizeBytes(fieldBytes(fields, idxSpecies))),
 		}
+		before := record
 		if err := curator.Curate(&record); err != nil {
 			return fmt.Errorf("line %d curation failed: %w", rowCount+1, err)
 		}
+		if prov != nil {
+			if changes := lineageChanges(before, record); changes != "" {
+				if err := prov.record(record.ProcessID, "curated", curationCfg.Protocol+": "+changes, row.Line); err != nil {
+					return err
+				}
+			}
+		}
 
 		if record.Genus != "" && record.Species == "" {
 			suffix := record.BinURI
@@ -144,26 +196,41 @@ func buildTaxonkit(inputPath, outputPath string, reportEvery, totalRows int, cur
 			}
 			if suffix != "" {
 				record.Species = record.Genus + " sp. " + suffix
+				if err := prov.record(record.ProcessID, "species_placeholder", record.Species, row.Line); err != nil {
+					return err
+				}
 			}
 		}
 
-		line := strings.Join([]string{
+		outRow = append(outRow[:0],
 			record.Kingdom, record.Phylum, record.Class, record.Order, record.Family,
-			record.Subfamily, record.Tribe, record.Genus, record.Species, record.ProcessID,
-		}, "\t")
-		if _, err := writer.WriteString(line + "\n"); err != nil {
+			record.Subfamily, record.Tribe, record.Genus, record.Species, record.ProcessID)
+		if err := writer.WriteRowStrings(outRow); err != nil {
 			return fmt.Errorf("write row: %w", err)
 		}
 
-		return nil
+		return prov.record(record.ProcessID, "extracted", "", row.Line)
 	})
+	err = acceptRowErrors("extract", err)
 	if err != nil {
+		_ = prov.Close()
+		return 0, interruptedAt(ctx, err, "extract", lastLine)
+	}
+	if err := prov.Close(); err != nil {
 		return 0, err
 	}
 
 	progress.finish()
+	bytesBar.Finish()
+	logParseStats("extract", opts.Stats)
 	if err := curator.Close(); err != nil {
 		return 0, fmt.Errorf("finalize curation profile: %w", err)
 	}
+	if err := writer.Close(); err != nil {
+		return 0, fmt.Errorf("close output: %w", err)
+	}
+	if n := quarantine.count("extract"); n > 0 {
+		logf("extract: QUARANTINED %d malformed lines -> %s", n, quarantine.path)
+	}
 	return rowCount, nil
 }
@@ -15,6 +15,8 @@ type extractCurationConfig struct {
 	Protocol   string
 	ReportPath string
 	AuditPath  string
+	// Snapshot is recorded in the curation report.
+	Snapshot string
 }
 
 func (c extractCurationConfig) normalized() extractCurationConfig {
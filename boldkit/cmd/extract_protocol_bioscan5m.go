@@ -3,7 +3,6 @@ package cmd
 import (
 	"bufio"
 	"fmt"
-	"os"
 	"strings"
 )
 
@@ -16,7 +15,7 @@ type bioscan5MCurator struct {
 	binsCanonical  int
 	binsConflicted int
 	stats          bioscanCurationStats
-	auditFile      *os.File
+	auditFile      *meteredFile
 	auditWriter    *bufio.Writer
 }
 
@@ -70,12 +70,15 @@ type bioscanCurationBinSummary struct {
 }
 
 type bioscanCurationReport struct {
+	reportHeader
 	Protocol       string                    `json:"protocol"`
+	SnapshotID     string                    `json:"snapshot_id,omitempty"`
 	RulesetVersion string                    `json:"ruleset_version"`
 	InputPath      string                    `json:"input_path"`
 	AuditPath      string                    `json:"audit_path,omitempty"`
 	BinSummary     bioscanCurationBinSummary `json:"bin_summary"`
 	Stats          bioscanCurationStats      `json:"stats"`
+	Resources      *runResources             `json:"resources,omitempty"`
 }
 
 func (c *bioscan5MCurator) openAudit() error {
@@ -85,7 +88,7 @@ func (c *bioscan5MCurator) openAudit() error {
 	if err := os.MkdirAll(filepath.Dir(c.cfg.AuditPath), 0o755); err != nil {
 		return fmt.Errorf("create audit dir: %w", err)
 	}
-	f, err := os.Create(c.cfg.AuditPath)
+	f, err := createFile(c.cfg.AuditPath)
 	if err != nil {
 		return fmt.Errorf("create audit file: %w", err)
 	}
@@ -142,7 +145,7 @@ func (c *bioscan5MCurator) writeReport() error {
 	if err := os.MkdirAll(filepath.Dir(c.cfg.ReportPath), 0o755); err != nil {
 		return fmt.Errorf("create report dir: %w", err)
 	}
-	f, err := os.Create(c.cfg.ReportPath)
+	f, err := createFile(c.cfg.ReportPath)
 	if err != nil {
 		return fmt.Errorf("create report file: %w", err)
 	}
@@ -151,7 +154,9 @@ func (c *bioscan5MCurator) writeReport() error {
 	}()
 
 	report := bioscanCurationReport{
+		reportHeader:   newReportHeader("curation-report"),
 		Protocol:       extractCurationProtocolBioscan5M,
+		SnapshotID:     c.cfg.Snapshot,
 		RulesetVersion: bioscanRulesetVersion,
 		InputPath:      c.inputPath,
 		AuditPath:      c.cfg.AuditPath,
@@ -160,7 +165,8 @@ func (c *bioscan5MCurator) writeReport() error {
 			Canonical:  c.binsCanonical,
 			Conflicted: c.binsConflicted,
 		},
-		Stats: c.stats,
+		Stats:     c.stats,
+		Resources: currentRun().resources(),
 	}
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -48,7 +49,7 @@ func TestBioscanCurateBinCanonicalAdoption(t *testing.T) {
 		t.Fatalf("write input: %v", err)
 	}
 
-	if _, err := buildTaxonkit(input, output, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolBioscan5M}.normalized()); err != nil {
+	if _, err := buildTaxonkit(context.Background(), input, output, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolBioscan5M}.normalized(), inputConfig{}); err != nil {
 		t.Fatalf("buildTaxonkit failed: %v", err)
 	}
 	data, err := os.ReadFile(output)
@@ -74,7 +75,7 @@ func TestBioscanCurateGenusMismatchFallsBackToBinProvisional(t *testing.T) {
 		t.Fatalf("write input: %v", err)
 	}
 
-	if _, err := buildTaxonkit(input, output, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolBioscan5M}.normalized()); err != nil {
+	if _, err := buildTaxonkit(context.Background(), input, output, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolBioscan5M}.normalized(), inputConfig{}); err != nil {
 		t.Fatalf("buildTaxonkit failed: %v", err)
 	}
 	data, err := os.ReadFile(output)
@@ -101,7 +102,7 @@ func TestBioscanCurateDoesNotAdoptConflictedBinSpecies(t *testing.T) {
 		t.Fatalf("write input: %v", err)
 	}
 
-	if _, err := buildTaxonkit(input, output, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolBioscan5M}.normalized()); err != nil {
+	if _, err := buildTaxonkit(context.Background(), input, output, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolBioscan5M}.normalized(), inputConfig{}); err != nil {
 		t.Fatalf("buildTaxonkit failed: %v", err)
 	}
 	data, err := os.ReadFile(output)
@@ -138,7 +139,7 @@ func TestBioscanReportAndAuditOutputs(t *testing.T) {
 		ReportPath: report,
 		AuditPath:  audit,
 	}.normalized()
-	if _, err := buildTaxonkit(input, output, 0, -1, cfg); err != nil {
+	if _, err := buildTaxonkit(context.Background(), input, output, 0, -1, cfg, inputConfig{}); err != nil {
 		t.Fatalf("buildTaxonkit failed: %v", err)
 	}
 
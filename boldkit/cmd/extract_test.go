@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,7 +22,7 @@ func TestBuildTaxonkitProcessIDFallbackByProtocol(t *testing.T) {
 		t.Fatalf("write input: %v", err)
 	}
 
-	if _, err := buildTaxonkit(input, outputNone, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolNone}.normalized()); err != nil {
+	if _, err := buildTaxonkit(context.Background(), input, outputNone, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolNone}.normalized(), inputConfig{}); err != nil {
 		t.Fatalf("buildTaxonkit none failed: %v", err)
 	}
 	dataNone, err := os.ReadFile(outputNone)
@@ -32,7 +33,7 @@ func TestBuildTaxonkitProcessIDFallbackByProtocol(t *testing.T) {
 		t.Fatalf("expected PROCESSID fallback in none mode, got:\n%s", string(dataNone))
 	}
 
-	if _, err := buildTaxonkit(input, outputBioscan, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolBioscan5M}.normalized()); err != nil {
+	if _, err := buildTaxonkit(context.Background(), input, outputBioscan, 0, -1, extractCurationConfig{Protocol: extractCurationProtocolBioscan5M}.normalized(), inputConfig{}); err != nil {
 		t.Fatalf("buildTaxonkit bioscan failed: %v", err)
 	}
 	dataBioscan, err := os.ReadFile(outputBioscan)
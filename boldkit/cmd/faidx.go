@@ -0,0 +1,394 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// errGzipNotIndexable is returned for gzip FASTA that is not bgzip: its
+// members cannot be located without decompressing everything before them.
+var errGzipNotIndexable = errors.New("plain gzip cannot be indexed; recompress with bgzip, or write marker FASTAs with -bgzip")
+
+// faiEntry is one line of a samtools .fai index.
+type faiEntry struct {
+	Name string
+	// Length is the number of bases and Offset the byte offset of the first
+	// one in the uncompressed file.
+	Length int64
+	Offset int64
+	// LineBases is the number of bases on each full line and LineWidth its
+	// length in bytes, line terminator included.
+	LineBases int64
+	LineWidth int64
+}
+
+// rawLength is the number of bytes the sequence spans, line terminators
+// included, up to its last base.
+func (e faiEntry) rawLength() int64 {
+	if e.LineBases == 0 {
+		return 0
+	}
+	return e.Length/e.LineBases*e.LineWidth + e.Length%e.LineBases
+}
+
+// faiBuilder indexes records as a fastaWriter writes them, which fixes
+// every line length in advance.
+type faiBuilder struct {
+	wrap    int64
+	offset  int64
+	entries []faiEntry
+}
+
+func newFaiBuilder(wrap int) *faiBuilder {
+	return &faiBuilder{wrap: int64(wrap)}
+}
+
+// add records a record with header (without '>') and seqLen bases, in the
+// layout writeSeq uses.
+func (b *faiBuilder) add(header string, seqLen int) {
+	name, _ := splitFastaHeader(header)
+	n := int64(seqLen)
+	lineBases := n
+	if b.wrap > 0 && n > b.wrap {
+		lineBases = b.wrap
+	}
+	lines := int64(1)
+	if lineBases > 0 {
+		lines = (n + lineBases - 1) / lineBases
+	}
+	b.offset += int64(len(header)) + 2
+	e := faiEntry{Name: name, Length: n, Offset: b.offset, LineBases: lineBases}
+	if lineBases > 0 {
+		e.LineWidth = lineBases + 1
+	}
+	b.entries = append(b.entries, e)
+	b.offset += n + lines
+}
+
+func writeFai(path string, entries []faiEntry) error {
+	w, err := createTSVWriter(path, tsvWriterOptions{})
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	row := make([]string, 5)
+	for _, e := range entries {
+		row[0] = e.Name
+		row[1] = strconv.FormatInt(e.Length, 10)
+		row[2] = strconv.FormatInt(e.Offset, 10)
+		row[3] = strconv.FormatInt(e.LineBases, 10)
+		row[4] = strconv.FormatInt(e.LineWidth, 10)
+		if err := w.WriteRowStrings(row); err != nil {
+			_ = w.Close()
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+func readFai(path string) ([]faiEntry, error) {
+	var entries []faiEntry
+	opts := DefaultOptions()
+	opts.ExpectedColumns = 5
+	opts.StrictColumns = true
+	err := ParseRows(path, opts, func(row Row) error {
+		e := faiEntry{Name: string(row.Fields[0])}
+		for i, dst := range []*int64{&e.Length, &e.Offset, &e.LineBases, &e.LineWidth} {
+			n, err := strconv.ParseInt(string(row.Fields[i+1]), 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("line %d: bad field %q", row.Line, row.Fields[i+1])
+			}
+			*dst = n
+		}
+		if e.LineBases > 0 && e.LineWidth <= e.LineBases {
+			return fmt.Errorf("line %d: line width %d below %d bases", row.Line, e.LineWidth, e.LineBases)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// indexFasta builds the .fai entries of an uncompressed FASTA stream. As
+// with samtools, every sequence line of a record but the last must have the
+// same length and line terminator.
+func indexFasta(r io.Reader) ([]faiEntry, error) {
+	br := bufio.NewReaderSize(r, fastaReadSize)
+	var (
+		entries []faiEntry
+		offset  int64
+		short   bool // the record has had a line shorter than its first
+	)
+	for lineNo := int64(1); ; lineNo++ {
+		header, width, bases, err := readFaiLine(br)
+		if err != nil {
+			return nil, fmt.Errorf("read fasta: %w", err)
+		}
+		if width == 0 {
+			return entries, nil
+		}
+		offset += width
+		if header != nil {
+			name, _ := splitFastaHeader(string(header[1:]))
+			entries = append(entries, faiEntry{Name: name, Offset: offset})
+			short = false
+			continue
+		}
+		if len(entries) == 0 {
+			if bases > 0 {
+				return nil, fmt.Errorf("line %d: sequence before the first header", lineNo)
+			}
+			continue
+		}
+		cur := &entries[len(entries)-1]
+		switch {
+		case bases == 0:
+			// A blank line ends the sequence, like a short one.
+			short = short || cur.LineBases > 0
+			continue
+		case cur.LineBases == 0:
+			cur.LineBases, cur.LineWidth = bases, width
+		case short, bases > cur.LineBases, width > bases && width-bases != cur.LineWidth-cur.LineBases:
+			return nil, fmt.Errorf("line %d: record %s has lines of different lengths", lineNo, cur.Name)
+		}
+		short = short || bases < cur.LineBases
+		cur.Length += bases
+	}
+}
+
+// readFaiLine reads one line of any length and returns its length in bytes
+// and without its terminator. Header lines also come back in full; width
+// is 0 at the end of the input.
+func readFaiLine(br *bufio.Reader) (header []byte, width, bases int64, err error) {
+	var prev, last byte
+	for first := true; ; first = false {
+		piece, err := br.ReadSlice('\n')
+		if first && len(piece) > 0 && piece[0] == '>' {
+			header = []byte{}
+		}
+		if header != nil {
+			header = append(header, piece...)
+		}
+		width += int64(len(piece))
+		switch n := len(piece); {
+		case n >= 2:
+			prev, last = piece[n-2], piece[n-1]
+		case n == 1:
+			prev, last = last, piece[0]
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return nil, 0, 0, err
+		}
+		bases = width
+		if last == '\n' {
+			bases--
+			if prev == '\r' {
+				bases--
+			}
+		}
+		return header, width, bases, nil
+	}
+}
+
+func runFaidx(args []string) {
+	fs := flag.NewFlagSet("faidx", flag.ExitOnError)
+	input := fs.String("input", "", "FASTA to index, uncompressed or bgzip; writes <input>.fai (and <input>.gzi for bgzip)")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *input == "" {
+		fatalf("input is required")
+	}
+	entries, err := writeFastaIndex(*input)
+	if err != nil {
+		fatalf("faidx failed: %v", err)
+	}
+	logf("faidx: %d records -> %s.fai", len(entries), *input)
+}
+
+// writeFastaIndex indexes an existing FASTA: path.fai, plus path.gzi when
+// it is bgzip compressed.
+func writeFastaIndex(path string) ([]faiEntry, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := checkIndexable(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var src io.Reader = f
+	if compressed {
+		blocks, err := scanBGZFBlocks(f, info.Size())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := writeGZIFile(path+".gzi", blocks); err != nil {
+			return nil, err
+		}
+		zr, err := gzip.NewReader(io.NewSectionReader(f, 0, info.Size()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		src = zr
+	}
+	entries, err := indexFasta(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, writeFai(path+".fai", entries)
+}
+
+// checkIndexable reports whether f is bgzip compressed, failing for other
+// gzip input.
+func checkIndexable(f io.ReaderAt) (compressed bool, err error) {
+	head := make([]byte, bgzfHeaderSize)
+	n, _ := f.ReadAt(head, 0)
+	if n < 2 || head[0] != 0x1f || head[1] != 0x8b {
+		return false, nil
+	}
+	if !isBGZFHeader(head[:n]) {
+		return false, errGzipNotIndexable
+	}
+	return true, nil
+}
+
+func writeGZIFile(path string, blocks []bgzfBlockStart) error {
+	f, err := createFile(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	if err := writeGZI(f, blocks); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// fetchSequences reads the records named by ids, in that order, from their
+// offsets in fastaPath.fai; bgzip input also needs fastaPath.gzi. IDs not
+// in the index are returned in missing.
+func fetchSequences(fastaPath string, ids []string) (recs []fastaRecord, missing []string, err error) {
+	if !fileExists(fastaPath + ".fai") {
+		return nil, nil, missingIndex(fastaPath, ".fai")
+	}
+	entries, err := readFai(fastaPath + ".fai")
+	if err != nil {
+		return nil, nil, err
+	}
+	// As in samtools, the first record of a repeated name wins.
+	byName := make(map[string]faiEntry, len(entries))
+	for _, e := range entries {
+		if _, ok := byName[e.Name]; !ok {
+			byName[e.Name] = e
+		}
+	}
+
+	f, err := openFile(fastaPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	src := faiSource{f: f}
+	compressed, err := checkIndexable(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", fastaPath, err)
+	}
+	if compressed {
+		if !fileExists(fastaPath + ".gzi") {
+			return nil, nil, missingIndex(fastaPath, ".gzi")
+		}
+		gzi, err := openFile(fastaPath + ".gzi")
+		if err != nil {
+			return nil, nil, err
+		}
+		src.blocks, err = readGZI(bufio.NewReader(gzi))
+		_ = gzi.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s.gzi: %w", fastaPath, err)
+		}
+	}
+
+	for _, id := range ids {
+		e, ok := byName[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		raw, err := src.read(e.Offset, e.rawLength())
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %s: %w", id, err)
+		}
+		seq := raw[:0]
+		for _, c := range raw {
+			if c != '\n' && c != '\r' {
+				seq = append(seq, c)
+			}
+		}
+		if int64(len(seq)) != e.Length {
+			return nil, nil, fmt.Errorf("read %s: %d bases at offset %d, index says %d; is %s.fai stale?", id, len(seq), e.Offset, e.Length, fastaPath)
+		}
+		recs = append(recs, fastaRecord{id: id, seq: seq})
+	}
+	return recs, missing, nil
+}
+
+func missingIndex(fastaPath, suffix string) error {
+	return fmt.Errorf("%s%s not found; run boldkit faidx -input %s", fastaPath, suffix, fastaPath)
+}
+
+// faiSource reads byte ranges of the uncompressed FASTA. With blocks it
+// starts inflating at the bgzip block holding the range.
+type faiSource struct {
+	f      *meteredFile
+	blocks []bgzfBlockStart
+}
+
+func (s faiSource) read(off, n int64) ([]byte, error) {
+	buf := make([]byte, n)
+	if s.blocks == nil {
+		if _, err := s.f.ReadAt(buf, off); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	i := sort.Search(len(s.blocks), func(i int) bool { return s.blocks[i].uncompressed > uint64(off) }) - 1
+	if i < 0 {
+		i = 0
+	}
+	b := s.blocks[i]
+	zr, err := gzip.NewReader(io.NewSectionReader(s.f, int64(b.compressed), 1<<62))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, zr, off-int64(b.uncompressed)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The example of the samtools faidx manual.
+const faiManualFasta = ">one\nATGCATGCATGCATGCATGCATGCATGCAT\nGCATGCATGCATGCATGCATGCATGCATGC\nATGCAT\n" +
+	">two another chromosome\nATGCATGCATGCAT\nGCATGCATGCATGC\n"
+
+var faiManualEntries = []faiEntry{
+	{Name: "one", Length: 66, Offset: 5, LineBases: 30, LineWidth: 31},
+	{Name: "two", Length: 28, Offset: 98, LineBases: 14, LineWidth: 15},
+}
+
+func TestIndexFasta(t *testing.T) {
+	got, err := indexFasta(iotestReader(faiManualFasta))
+	if err != nil || !reflect.DeepEqual(got, faiManualEntries) {
+		t.Fatalf("got %+v err=%v", got, err)
+	}
+
+	crlf := strings.ReplaceAll(faiManualFasta, "\n", "\r\n")
+	got, err = indexFasta(strings.NewReader(crlf))
+	want := []faiEntry{
+		{Name: "one", Length: 66, Offset: 6, LineBases: 30, LineWidth: 32},
+		{Name: "two", Length: 28, Offset: 103, LineBases: 14, LineWidth: 16},
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("crlf: got %+v err=%v", got, err)
+	}
+
+	for _, bad := range []string{
+		">a\nACGT\nACG\nACGT\n",
+		">a\nACG\nACGT\n",
+		">a\nACGT\n\nACGT\n",
+		">a\nACGT\r\nACGT\nAC\n",
+		"ACGT\n>a\nAC\n",
+	} {
+		if _, err := indexFasta(strings.NewReader(bad)); err == nil {
+			t.Fatalf("%q: expected an error", bad)
+		}
+	}
+}
+
+func iotestReader(s string) io.Reader {
+	return bufio.NewReaderSize(strings.NewReader(s), 16)
+}
+
+// The index built while writing matches the one read back from the file.
+func TestFaiBuilderMatchesIndexFasta(t *testing.T) {
+	for _, wrap := range []int{0, 1, 7, 60} {
+		var buf bytes.Buffer
+		bw := bufio.NewWriter(&buf)
+		fw := newFastaWriter(bw, wrap)
+		fw.fai = newFaiBuilder(wrap)
+		for i, n := range []int{1, 6, 7, 8, 14, 60, 61, 120, 0, 333} {
+			seq := bytes.Repeat([]byte("ACGTN"), n)[:n]
+			header := fmt.Sprintf("R%d desc\twith  spaces", i)
+			if i%2 == 0 {
+				_ = fw.Write(header, seq)
+			} else {
+				_ = fw.WriteBytes([]byte(header), seq)
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := indexFasta(&buf)
+		if err != nil {
+			t.Fatalf("wrap %d: %v", wrap, err)
+		}
+		if !reflect.DeepEqual(fw.fai.entries, got) {
+			t.Fatalf("wrap %d:\nbuilt %+v\nread  %+v", wrap, fw.fai.entries, got)
+		}
+	}
+}
+
+func TestBGZFWriter(t *testing.T) {
+	var data bytes.Buffer
+	for i := 0; data.Len() < 5*bgzfBlockData; i++ {
+		fmt.Fprintf(&data, ">P%d\n%s\n", i, strings.Repeat("ACGT", i%90))
+	}
+	var out bytes.Buffer
+	zw := newBGZFWriter(&out)
+	// Uneven writes cross block boundaries.
+	for rest := data.Bytes(); len(rest) > 0; {
+		n := min(len(rest), 7777)
+		if _, err := zw.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(out.Bytes(), bgzfEOF) {
+		t.Fatal("missing EOF block")
+	}
+
+	for _, workers := range []int{1, 4} {
+		zr, err := newGzipReader(bytes.NewReader(out.Bytes()), workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(zr)
+		if err != nil || !bytes.Equal(got, data.Bytes()) {
+			t.Fatalf("workers %d: read %d bytes err=%v, want %d", workers, len(got), err, data.Len())
+		}
+	}
+
+	scanned, err := scanBGZFBlocks(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil || !reflect.DeepEqual(scanned, zw.starts) {
+		t.Fatalf("scanned %v err=%v, written %v", scanned, err, zw.starts)
+	}
+	var gzi bytes.Buffer
+	if err := zw.WriteIndex(&gzi); err != nil {
+		t.Fatal(err)
+	}
+	if gzi.Len() != 8+16*(len(zw.starts)-1) {
+		t.Fatalf("gzi of %d bytes for %d blocks", gzi.Len(), len(zw.starts))
+	}
+	back, err := readGZI(&gzi)
+	if err != nil || !reflect.DeepEqual(back, zw.starts) {
+		t.Fatalf("gzi round trip %v err=%v", back, err)
+	}
+}
+
+func TestMarkersIndexAndFetch(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "BOLD_Public.index.tsv")
+	writeMarkersBenchTSV(t, input, 400)
+
+	for _, bc := range []struct {
+		name string
+		gzip bool
+		cfg  inputConfig
+	}{
+		{"plain", false, inputConfig{Index: true}},
+		{"plain-wrap", false, inputConfig{Index: true, Wrap: 60}},
+		{"bgzip", true, inputConfig{Index: true, BGZip: true, Wrap: 80}},
+	} {
+		outDir := filepath.Join(tmp, bc.name)
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := buildMarkerFastas(context.Background(), input, outDir, bc.gzip, 0, -1, 1, bc.cfg); err != nil {
+			t.Fatalf("%s: %v", bc.name, err)
+		}
+		path := filepath.Join(outDir, "COI-5P.fasta")
+		if bc.gzip {
+			path += ".gz"
+		}
+
+		in, _, err := openFastaInput(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all := make(map[string]string)
+		var ids []string
+		err = parseFasta(in, func(rec fastaRecord) error {
+			all[rec.id] = string(rec.seq)
+			ids = append(ids, rec.id)
+			return nil
+		})
+		_ = in.Close()
+		if err != nil || len(ids) < 100 {
+			t.Fatalf("%s: %d records err=%v", bc.name, len(ids), err)
+		}
+
+		want := []string{ids[len(ids)-1], ids[0], ids[len(ids)/2], "NOPE"}
+		recs, missing, err := fetchSequences(path, want)
+		if err != nil {
+			t.Fatalf("%s: %v", bc.name, err)
+		}
+		if !reflect.DeepEqual(missing, []string{"NOPE"}) || len(recs) != 3 {
+			t.Fatalf("%s: %d records, missing %v", bc.name, len(recs), missing)
+		}
+		for i, rec := range recs {
+			if rec.id != want[i] || string(rec.seq) != all[want[i]] {
+				t.Fatalf("%s: record %d is %s with %d bases", bc.name, i, rec.id, len(rec.seq))
+			}
+		}
+
+		// boldkit faidx rebuilds the same indexes from the file.
+		built := map[string][]byte{}
+		for _, suffix := range []string{".fai", ".gzi"} {
+			built[suffix], _ = os.ReadFile(path + suffix)
+			_ = os.Remove(path + suffix)
+		}
+		if _, err := writeFastaIndex(path); err != nil {
+			t.Fatalf("%s: faidx: %v", bc.name, err)
+		}
+		for suffix, want := range built {
+			got, _ := os.ReadFile(path + suffix)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s: faidx %s differs from the one markers wrote", bc.name, suffix)
+			}
+		}
+	}
+
+	// pgzip output has no block index.
+	outDir := filepath.Join(tmp, "pgzip")
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := buildMarkerFastas(context.Background(), input, outDir, true, 0, -1, 1, inputConfig{Index: true})
+	if !errors.Is(err, errGzipNotIndexable) {
+		t.Fatalf("pgzip -index: err=%v", err)
+	}
+	if err := buildMarkerFastas(context.Background(), input, outDir, true, 0, -1, 1, inputConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeFastaIndex(filepath.Join(outDir, "COI-5P.fasta.gz")); !errors.Is(err, errGzipNotIndexable) {
+		t.Fatalf("faidx of pgzip output: err=%v", err)
+	}
+}
+
+func TestSubsetFasta(t *testing.T) {
+	dir := t.TempDir()
+	fasta := filepath.Join(dir, "refs.fasta")
+	if err := os.WriteFile(fasta, []byte(faiManualFasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fetchSequences(fasta, []string{"one"}); err == nil || !strings.Contains(err.Error(), "boldkit faidx") {
+		t.Fatalf("unindexed: err=%v", err)
+	}
+	if _, err := writeFastaIndex(fasta); err != nil {
+		t.Fatal(err)
+	}
+	ids := filepath.Join(dir, "ids.txt")
+	if err := os.WriteFile(ids, []byte("# benchmark set\ntwo extra words\n\nmissing\none\ntwo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "subset.fasta")
+	if err := subsetFasta(fasta, ids, out, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ">two\nATGCATGCATGCATGCATGCATGCATGC\n>one\n" +
+		"ATGCATGCATGCATGCATGCATGCATGCATGCATGCATGCATGCATGCATGCATGCATGCATGCAT\n"
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	gz := filepath.Join(dir, "plain.fasta")
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(faiManualFasta))
+	_ = zw.Close()
+	if err := os.WriteFile(gz, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeFastaIndex(gz); !errors.Is(err, errGzipNotIndexable) {
+		t.Fatalf("gzip named .fasta: err=%v", err)
+	}
+}
@@ -3,89 +3,182 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"os"
 	"strings"
+	"unicode"
 )
 
+// fastaReadSize is the read buffer of parseFasta. Longer lines are read in
+// pieces, so it is not a line length limit.
+const fastaReadSize = 1 << 20
+
+// stdinPath as a FASTA input path reads standard input.
+const stdinPath = "-"
+
+// fastaRecord is one record: id is the header up to the first whitespace
+// and desc the rest of the header, trimmed.
 type fastaRecord struct {
-	id  string
-	seq []byte
+	id   string
+	desc string
+	seq  []byte
 }
 
+// parseFasta calls onRecord for each record of r, with the sequence lines
+// trimmed and joined. Lines of any length are read a buffer at a time, so
+// memory grows with the longest record only. A UTF-8 BOM is skipped and the
+// last line needs no newline. Sequence lines before the first header, and
+// records whose header is blank, are dropped.
 func parseFasta(r io.Reader, onRecord func(fastaRecord) error) error {
-	scanner := bufio.NewScanner(r)
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 10*1024*1024)
+	br := bufio.NewReaderSize(r, fastaReadSize)
+	if _, err := skipBOM(br, Options{}); err != nil {
+		return err
+	}
 
-	var header string
-	var seq bytes.Buffer
+	var (
+		header    []byte
+		seq       []byte
+		inRecord  bool
+		inLine    bool
+		isHeader  bool
+		lineStart int
+	)
 	emit := func() error {
-		if header == "" {
+		if !inRecord {
 			return nil
 		}
-		rec := fastaRecord{
-			id:  fastaID(header),
-			seq: append([]byte(nil), seq.Bytes()...),
+		inRecord = false
+		id, desc := splitFastaHeader(string(header))
+		s := seq
+		seq = seq[:0]
+		if id == "" {
+			return nil
 		}
-		seq.Reset()
-		header = ""
-		return onRecord(rec)
+		return onRecord(fastaRecord{id: id, desc: desc, seq: append([]byte(nil), s...)})
 	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, ">") {
-			if err := emit(); err != nil {
-				return err
+	for {
+		piece, err := br.ReadSlice('\n')
+		if len(piece) > 0 {
+			if !inLine {
+				inLine = true
+				isHeader = piece[0] == '>'
+				if isHeader {
+					if err := emit(); err != nil {
+						return err
+					}
+					inRecord = true
+					header = header[:0]
+					piece = piece[1:]
+				}
+				lineStart = len(seq)
+			}
+			switch {
+			case isHeader:
+				header = append(header, piece...)
+			case inRecord:
+				seq = append(seq, piece...)
 			}
-			header = strings.TrimSpace(line[1:])
-			continue
 		}
-		seq.WriteString(strings.TrimSpace(line))
-	}
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("scan fasta: %w", err)
+		// The line is complete unless it filled the buffer.
+		if inLine && err != bufio.ErrBufferFull {
+			inLine = false
+			if !isHeader && inRecord {
+				line := bytes.TrimSpace(seq[lineStart:])
+				seq = seq[:lineStart+copy(seq[lineStart:], line)]
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return fmt.Errorf("read fasta: %w", err)
+		}
 	}
-	if err := emit(); err != nil {
-		return err
+	return emit()
+}
+
+// parseFastaContext is parseFasta with external cancellation: once ctx is
+// cancelled it stops before the next record and returns ctx.Err(). A Read
+// already blocked in r is not interrupted.
+func parseFastaContext(ctx context.Context, r io.Reader, onRecord func(fastaRecord) error) error {
+	return parseFasta(r, func(rec fastaRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return onRecord(rec)
+	})
+}
+
+// splitFastaHeader splits a header (without its '>') at the first run of
+// whitespace. The description keeps its inner spacing, tabs included.
+func splitFastaHeader(header string) (id, desc string) {
+	header = strings.TrimSpace(header)
+	i := strings.IndexFunc(header, unicode.IsSpace)
+	if i < 0 {
+		return header, ""
 	}
-	return nil
+	return header[:i], strings.TrimSpace(header[i:])
 }
 
-func fastaID(header string) string {
-	if header == "" {
-		return ""
+// openFastaInput opens a FASTA input path, or standard input for "-". gzip
+// and bgzip input is recognised by its magic bytes, not the file name, so a
+// compressed file named .fasta reads the same as .fasta.gz. The counter sees
+// the bytes read from the source, before decompression.
+func openFastaInput(path string) (io.ReadCloser, *countReader, error) {
+	var src io.ReadCloser = io.NopCloser(os.Stdin)
+	if path != stdinPath {
+		f, err := openFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		src = f
+	}
+	counter := &countReader{reader: src}
+	br := bufio.NewReaderSize(counter, bgzfMaxBlock)
+	head, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		_ = src.Close()
+		return nil, nil, err
+	}
+	if len(head) < 2 || head[0] != 0x1f || head[1] != 0x8b {
+		return readCloser{reader: br, close: src.Close}, counter, nil
 	}
-	fields := strings.Fields(header)
-	if len(fields) == 0 {
-		return ""
+	gz, err := newGzipReader(br, 0)
+	if err != nil {
+		_ = src.Close()
+		return nil, nil, err
 	}
-	return fields[0]
+	return readCloser{
+		reader: gz,
+		close: func() error {
+			_ = gz.Close()
+			return src.Close()
+		},
+	}, counter, nil
 }
 
-func sanitizeTaxon(name string) string {
-	if name == "" {
-		return ""
+// spoolStdin copies standard input to a temporary file for the commands
+// that read their FASTA input more than once. Other paths are returned as
+// is. cleanup removes the copy.
+func spoolStdin(path string) (spooled string, cleanup func(), err error) {
+	if path != stdinPath {
+		return path, func() {}, nil
 	}
-	var b strings.Builder
-	b.Grow(len(name))
-	for i := 0; i < len(name); i++ {
-		c := name[i]
-		switch {
-		case c >= 'A' && c <= 'Z':
-			b.WriteByte(c)
-		case c >= 'a' && c <= 'z':
-			b.WriteByte(c)
-		case c >= '0' && c <= '9':
-			b.WriteByte(c)
-		case c == '-' || c == '_' || c == '.':
-			b.WriteByte(c)
-		case c == ' ':
-			b.WriteByte('_')
-		default:
-			b.WriteByte('_')
-		}
+	tmp, err := os.CreateTemp("", "boldkit_stdin_*.fasta")
+	if err != nil {
+		return "", nil, fmt.Errorf("spool stdin: %w", err)
+	}
+	cleanup = func() { _ = os.Remove(tmp.Name()) }
+	_, err = io.Copy(&meteredFile{f: tmp}, os.Stdin)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("spool stdin: %w", err)
 	}
-	return b.String()
+	return tmp.Name(), cleanup, nil
 }
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func collectFasta(t *testing.T, r io.Reader) ([]string, error) {
+	t.Helper()
+	var got []string
+	err := parseFasta(r, func(rec fastaRecord) error {
+		got = append(got, rec.id+"="+string(rec.seq))
+		return nil
+	})
+	return got, err
+}
+
+func TestParseFasta(t *testing.T) {
+	for _, tc := range []struct {
+		name, input string
+		want        []string
+	}{
+		{"wrapped", ">P1 desc\nACGT\nTTGG\n>P2\nCC\n", []string{"P1=ACGTTTGG", "P2=CC"}},
+		{"no final newline", ">P1\nAC\nGT", []string{"P1=ACGT"}},
+		{"crlf and blanks", ">P1\r\n AC \r\n\r\nGT\r\n>P2\r\n", []string{"P1=ACGT", "P2="}},
+		{"bom", "\xef\xbb\xbf>P1\nAC\n", []string{"P1=AC"}},
+		{"headerless lines", "NNNN\n>P1\nAC\n>  \nGGGG\n>P2\nT\n", []string{"P1=AC", "P2=T"}},
+		{"empty", "", nil},
+	} {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tc.input)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			got, err := collectFasta(t, r)
+			if err != nil || !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("%s (one byte %v): got %q err=%v, want %q", tc.name, oneByte, got, err, tc.want)
+			}
+		}
+	}
+
+	if _, err := collectFasta(t, strings.NewReader("\xff\xfe>\x00P\x00")); !errors.Is(err, ErrUTF16Input) {
+		t.Fatalf("utf-16: err=%v", err)
+	}
+}
+
+// An unwrapped sequence longer than bufio.Scanner's old 10 MiB token cap
+// parses whole.
+func TestParseFastaLongLine(t *testing.T) {
+	seq := bytes.Repeat([]byte("ACGT"), 3<<20)
+	input := ">genome\n" + string(seq) + "\n>P2\nAC"
+	var got []fastaRecord
+	err := parseFasta(strings.NewReader(input), func(rec fastaRecord) error {
+		got = append(got, rec)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].id != "genome" || !bytes.Equal(got[0].seq, seq) || string(got[1].seq) != "AC" {
+		t.Fatalf("got %d records, first %q with %d bases", len(got), got[0].id, len(got[0].seq))
+	}
+}
+
+const fastaInputFixture = ">P1 desc\nACGT\n>P2\nTTGG\n"
+
+// pipeStdin points os.Stdin at a pipe fed data, as in "zcat x | boldkit qc
+// -input -".
+func pipeStdin(t *testing.T, data []byte) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = w.Write(data)
+		_ = w.Close()
+	}()
+	old := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = old
+		_ = r.Close()
+	})
+}
+
+func fastaInputVariants(t *testing.T) map[string][]byte {
+	t.Helper()
+	var bgzf bytes.Buffer
+	writeBGZF(t, &bgzf, []byte(fastaInputFixture))
+	return map[string][]byte{
+		"plain": []byte(fastaInputFixture),
+		"gzip":  gzipBytes(t, []byte(fastaInputFixture)),
+		"bgzip": bgzf.Bytes(),
+	}
+}
+
+// Compression is detected from the content: a gzipped file named .fasta
+// reads the same as a plain one.
+func TestOpenFastaInputMisnamedGzip(t *testing.T) {
+	want := []string{"P1=ACGT", "P2=TTGG"}
+	dir := t.TempDir()
+	for name, data := range fastaInputVariants(t) {
+		path := filepath.Join(dir, name+".fasta")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		in, counter, err := openFastaInput(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := collectFasta(t, in)
+		_ = in.Close()
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %q err=%v, want %q", name, got, err, want)
+		}
+		if counter.Count() != int64(len(data)) {
+			t.Fatalf("%s: counted %d bytes, want the %d on disk", name, counter.Count(), len(data))
+		}
+	}
+
+	empty := filepath.Join(dir, "empty.fasta")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	in, _, err := openFastaInput(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := collectFasta(t, in); err != nil || got != nil {
+		t.Fatalf("empty: got %q err=%v", got, err)
+	}
+	_ = in.Close()
+}
+
+func TestFastaInputStdin(t *testing.T) {
+	want := []string{"P1=ACGT", "P2=TTGG"}
+	for name, data := range fastaInputVariants(t) {
+		pipeStdin(t, data)
+		in, _, err := openFastaInput(stdinPath)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := collectFasta(t, in)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %q err=%v, want %q", name, got, err, want)
+		}
+		if err := in.Close(); err != nil {
+			t.Fatalf("%s: close: %v", name, err)
+		}
+
+		// The multi-pass commands read a spooled copy.
+		pipeStdin(t, data)
+		path, cleanup, err := spoolStdin(stdinPath)
+		if err != nil {
+			t.Fatalf("%s: spool: %v", name, err)
+		}
+		for pass := 0; pass < 2; pass++ {
+			in, _, err := openFastaInput(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := collectFasta(t, in)
+			_ = in.Close()
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: spooled pass %d: got %q err=%v", name, pass, got, err)
+			}
+		}
+		cleanup()
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s: spooled copy left behind: %v", name, err)
+		}
+	}
+
+	// An unknown size leaves the progress bar in spinner mode.
+	if got := fileSize(stdinPath); got != -1 {
+		t.Fatalf("fileSize(stdin) = %d, want -1", got)
+	}
+	if got := qcBaseName(stdinPath); got != "stdin" {
+		t.Fatalf("qcBaseName(stdin) = %q", got)
+	}
+}
+
+// qc streams standard input and skips the cache, which keys on the input
+// file's hash.
+func TestQCFastaStdin(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := openArtifactCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeStdin(t, gzipBytes(t, []byte(fastaInputFixture)))
+	out := filepath.Join(dir, "out.fasta")
+	if err := qcFasta(context.Background(), stdinPath, qcConfig{NoTaxonomy: true, MaxErrors: -1, OutputPath: out, Cache: cache}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), ">P1") || !strings.Contains(string(got), "TTGG") {
+		t.Fatalf("qc output %q", got)
+	}
+}
+
+func TestSplitFastaHeader(t *testing.T) {
+	for _, tc := range []struct{ header, id, desc string }{
+		{"PROC123", "PROC123", ""},
+		{"PROC123 Lepidoptera|COI-5P", "PROC123", "Lepidoptera|COI-5P"},
+		{"PROC123\tLepidoptera\tCOI-5P", "PROC123", "Lepidoptera\tCOI-5P"},
+		{"  PROC123   Lepidoptera  |  COI-5P  \r", "PROC123", "Lepidoptera  |  COI-5P"},
+		{"PROC123 \t ", "PROC123", ""},
+		{"   ", "", ""},
+	} {
+		id, desc := splitFastaHeader(tc.header)
+		if id != tc.id || desc != tc.desc {
+			t.Fatalf("%q: got %q, %q want %q, %q", tc.header, id, desc, tc.id, tc.desc)
+		}
+	}
+
+	var got []string
+	err := parseFasta(strings.NewReader(">P1\tmoth  COI\nAC\n>P2\nGT\n"), func(rec fastaRecord) error {
+		got = append(got, rec.id+"|"+rec.desc)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(got, []string{"P1|moth  COI", "P2|"}) {
+		t.Fatalf("got %q err=%v", got, err)
+	}
+}
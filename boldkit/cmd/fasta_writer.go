@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// fastaWriter writes FASTA records to a bufio.Writer. With a wrap width
+// sequences are split into lines of that many bases, as alignment tools
+// such as mafft and hmmer prefer; 0 writes each sequence on one line. The
+// lines are slices of the caller's sequence, so wrapping does not allocate.
+type fastaWriter struct {
+	w    *bufio.Writer
+	wrap int
+	// fai, when set, indexes each record written.
+	fai *faiBuilder
+}
+
+func newFastaWriter(w *bufio.Writer, wrap int) fastaWriter {
+	return fastaWriter{w: w, wrap: wrap}
+}
+
+// Write writes the record ">header" followed by seq.
+func (fw fastaWriter) Write(header string, seq []byte) error {
+	if fw.fai != nil {
+		fw.fai.add(header, len(seq))
+	}
+	_ = fw.w.WriteByte('>')
+	_, _ = fw.w.WriteString(header)
+	return fw.writeSeq(seq)
+}
+
+// WriteBytes is Write for a header held in a byte slice.
+func (fw fastaWriter) WriteBytes(header, seq []byte) error {
+	if fw.fai != nil {
+		fw.fai.add(string(header), len(seq))
+	}
+	_ = fw.w.WriteByte('>')
+	_, _ = fw.w.Write(header)
+	return fw.writeSeq(seq)
+}
+
+// writeSeq ends the header line and writes the sequence lines. bufio.Writer
+// errors are sticky, so checking the last write covers the record.
+func (fw fastaWriter) writeSeq(seq []byte) error {
+	_ = fw.w.WriteByte('\n')
+	if fw.wrap > 0 {
+		for len(seq) > fw.wrap {
+			_, _ = fw.w.Write(seq[:fw.wrap])
+			_ = fw.w.WriteByte('\n')
+			seq = seq[fw.wrap:]
+		}
+	}
+	_, _ = fw.w.Write(seq)
+	if err := fw.w.WriteByte('\n'); err != nil {
+		return fmt.Errorf("write fasta: %w", err)
+	}
+	return nil
+}
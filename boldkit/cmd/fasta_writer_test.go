@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFastaWriterWrapRoundTrip(t *testing.T) {
+	var seqs [][]byte
+	for _, n := range []int{0, 1, 59, 60, 61, 80, 159, 160, 161, 1000} {
+		seqs = append(seqs, bytes.Repeat([]byte("ACGTN"), n)[:n])
+	}
+	for _, wrap := range []int{0, 1, 60, 80} {
+		var buf bytes.Buffer
+		bw := bufio.NewWriter(&buf)
+		fw := newFastaWriter(bw, wrap)
+		for i, seq := range seqs {
+			var err error
+			if i%2 == 0 {
+				err = fw.Write("S"+string(rune('A'+i)), seq)
+			} else {
+				err = fw.WriteBytes([]byte("S"+string(rune('A'+i))), seq)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			if wrap > 0 && !strings.HasPrefix(line, ">") && len(line) > wrap {
+				t.Fatalf("wrap %d: line of %d bases", wrap, len(line))
+			}
+		}
+		var got [][]byte
+		err := parseFasta(&buf, func(rec fastaRecord) error {
+			got = append(got, rec.seq)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(seqs) {
+			t.Fatalf("wrap %d: parsed %d records, want %d", wrap, len(got), len(seqs))
+		}
+		for i := range seqs {
+			if !bytes.Equal(got[i], seqs[i]) {
+				t.Fatalf("wrap %d: record %d = %q, want %q", wrap, i, got[i], seqs[i])
+			}
+		}
+	}
+}
+
+func TestFastaWriterWrapAllocs(t *testing.T) {
+	fw := newFastaWriter(bufio.NewWriter(io.Discard), 60)
+	seq := bytes.Repeat([]byte("ACGT"), 400)
+	header := []byte("P1")
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = fw.WriteBytes(header, seq)
+		_ = fw.Write("P2", seq)
+	})
+	if allocs != 0 {
+		t.Fatalf("%v allocations per record pair", allocs)
+	}
+}
+
+func TestQCWrap(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fasta")
+	seq := strings.Repeat("ACGT", 50)
+	if err := os.WriteFile(input, []byte(">P1\n"+seq+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.fasta")
+	if err := qcFasta(context.Background(), input, qcConfig{NoTaxonomy: true, MaxErrors: -1, OutputPath: out, Wrap: 80}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ">P1\n" + seq[:80] + "\n" + seq[80:160] + "\n" + seq[160:] + "\n"
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -1,13 +1,13 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
-	"strconv"
+	"reflect"
 	"strings"
 )
 
@@ -20,477 +20,801 @@ type formatConfig struct {
 	TaxidMapPath string
 	ReportPath   string
 	Progress     bool
+	Sanitize     nameSanitizer
+	// IDs, when set, keeps sequence IDs unique across the markers formatted
+	// into the same classifier output; Marker names this input.
+	IDs    *idRegistry
+	Marker string
+	// ProvenanceDir enables per-record provenance events (empty disables).
+	ProvenanceDir string
+	// Cache reuses outputs from an earlier identical run (nil disables). It
+	// is bypassed when IDs is set, since the output then depends on the
+	// other markers, and when ProvenanceDir is set.
+	Cache *artifactCache
+	// Taxdump is as in qcConfig.
+	Taxdump *taxDumpHandle
+	// NoTaxonomy writes sequence-only outputs without loading taxid.map or
+	// the taxdump; classifiers that need lineages are rejected.
+	NoTaxonomy bool
+	// Wrap splits FASTA sequences into lines of this many bases (0 writes
+	// each on one line).
+	Wrap int
+	// TaxidMapColumn is the 1-based taxid.map column holding the taxid (0
+	// means defaultTaxidMapColumn).
+	TaxidMapColumn int
+	// ResolveByName, ResolveSynonyms, ResolvedTSV, and RankAliases are as
+	// in qcConfig.
+	ResolveByName   bool
+	ResolveSynonyms bool
+	ResolvedTSV     string
+	RankAliases     string
+	// SintaxGzip writes sintax.fasta.gz instead of sintax.fasta.
+	SintaxGzip bool
+	// Qiime2Prefix is the level prefix style of the qiime2 taxonomy (""
+	// means greengenes).
+	Qiime2Prefix qiime2PrefixStyle
+	// Kraken2Style is how the kraken2 reference carries taxids ("" means
+	// header).
+	Kraken2Style kraken2Style
+	// Kraken2Symlink links the kraken2 taxonomy files instead of copying
+	// them.
+	Kraken2Symlink bool
+	// RdpRanks are the levels of the rdp taxonomy tree (empty means
+	// RequireRanks). A kept record missing one gets a placeholder node.
+	RdpRanks []string
+	// MothurRanks are the levels of the mothur taxonomy (empty means
+	// defaultMothurRanks). A kept record missing one is left out of the
+	// mothur outputs unless MothurPad writes it as "unclassified".
+	MothurRanks []string
+	MothurPad   bool
+	// IdtaxaRanks are the levels of the idtaxa taxonomy (empty means
+	// RequireRanks); IdtaxaRankFile also writes their ranks.
+	IdtaxaRanks    []string
+	IdtaxaRankFile bool
+	// BlastIDs is what to do with blast IDs makeblastdb rejects ("" means
+	// fail). RunMakeblastdb builds the blast database after formatting.
+	BlastIDs       blastIDPolicy
+	RunMakeblastdb bool
+	// CentrifugeNCBIMap, an ncbi-map output, remaps the centrifuge taxids
+	// to NCBI taxids; CentrifugeNCBITaxdump is the NCBI taxdump copied
+	// next to them.
+	CentrifugeNCBIMap     string
+	CentrifugeNCBITaxdump string
+	// SourmashCSVOnly writes the sourmash lineages CSV without its FASTA.
+	SourmashCSVOnly bool
+	// ClassifierDirs writes each classifier into OutDir/<classifier>
+	// instead of OutDir itself.
+	ClassifierDirs bool
 }
 
+// checkNoTaxonomyFlags rejects taxonomy flags set together with
+// -no-taxonomy, which would otherwise be silently ignored.
+func checkNoTaxonomyFlags(fs *flag.FlagSet) error {
+	var set []string
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "require-ranks", "taxid-map", "taxid-map-column", "taxdump-dir", "resolve-by-name", "resolve-synonyms", "resolved-tsv", "rank-aliases":
+			set = append(set, "-"+f.Name)
+		}
+	})
+	if len(set) > 0 {
+		return fmt.Errorf("-no-taxonomy cannot be combined with %s", strings.Join(set, ", "))
+	}
+	return nil
+}
+
+// checkNoTaxonomyClassifiers rejects unknown classifiers and those whose
+// outputs need taxids or lineages, before any work is done. Sequence-only
+// classifiers (blast: its seqid2taxid map is skipped) can run.
+func checkNoTaxonomyClassifiers(classifiers []string) error {
+	entries, err := lookupClassifiers(classifiers)
+	if err != nil {
+		return err
+	}
+	var need []string
+	for _, e := range entries {
+		if !e.sequenceOnly {
+			need = append(need, e.name)
+		}
+	}
+	if len(need) > 0 {
+		return fmt.Errorf("classifier %s needs taxids or lineages and cannot run with -no-taxonomy (sequence-only: %s)", strings.Join(need, ","), strings.Join(sequenceOnlyClassifiers(), ","))
+	}
+	return nil
+}
+
+// formatStats counts records seen, written, and dropped. Under
+// -resolve-by-name, ResolvedByName and UnresolvedByName count the records
+// missing from taxid.map whose description did and did not resolve; the
+// unresolved also count as missing_taxid. MergedTaxID counts the records
+// whose taxid merged.dmp replaced, and DeletedTaxID those dropped because
+// delnodes.dmp lists their taxid, which are not counted as missing_ranks.
 type formatStats struct {
-	Total        int
-	Written      int
-	MissingTaxID int
-	MissingRanks int
+	Total            int `json:"total"`
+	Written          int `json:"written"`
+	MissingTaxID     int `json:"missing_taxid"`
+	MissingRanks     int `json:"missing_ranks"`
+	ResolvedByName   int `json:"resolved_by_name,omitempty"`
+	UnresolvedByName int `json:"unresolved_by_name,omitempty"`
+	MergedTaxID      int `json:"merged_taxid,omitempty"`
+	DeletedTaxID     int `json:"deleted_taxid,omitempty"`
+	// SintaxNoKingdom counts the kept records left out of the sintax
+	// output because their lineage names no kingdom.
+	SintaxNoKingdom int `json:"sintax_no_kingdom,omitempty"`
+	// MothurMissingRanks counts the kept records left out of the mothur
+	// outputs because their lineage lacks a -mothur-ranks level.
+	MothurMissingRanks int `json:"mothur_missing_ranks,omitempty"`
+	// BlastIDsSanitized counts the blast IDs -blast-ids sanitize changed.
+	BlastIDsSanitized int `json:"blast_ids_sanitized,omitempty"`
+	// CentrifugeUnmapped counts the kept records left out of the
+	// centrifuge outputs under -centrifuge-ncbi-map because their taxid
+	// has no NCBI taxid; centrifuge_unmapped.tsv lists them.
+	CentrifugeUnmapped int `json:"centrifuge_unmapped,omitempty"`
+}
+
+// formatReport writes missing_taxid and missing_ranks as null when
+// NoTaxonomy is set: the checks did not run.
+type formatReport struct {
+	reportHeader
+	formatStats
+	Sanitize   nameSanitizer  `json:"sanitize"`
+	NoTaxonomy bool           `json:"no_taxonomy,omitempty"`
+	Cache      *cacheCounters `json:"cache,omitempty"`
+	Resources  *runResources  `json:"resources,omitempty"`
+}
+
+func (r formatReport) MarshalJSON() ([]byte, error) {
+	type plain formatReport
+	if !r.NoTaxonomy {
+		return json.Marshal(plain(r))
+	}
+	// The outer fields shadow the embedded counters.
+	return json.Marshal(struct {
+		plain
+		MissingTaxID *int `json:"missing_taxid"`
+		MissingRanks *int `json:"missing_ranks"`
+	}{plain: plain(r)})
+}
+
+// jsonSchema is the reflected schema with the counters made nullable.
+func (formatReport) jsonSchema() map[string]any {
+	type plain formatReport
+	schema := jsonSchemaFor(reflect.TypeOf(plain{}))
+	props := schema["properties"].(map[string]any)
+	for _, name := range []string{"missing_taxid", "missing_ranks"} {
+		props[name] = nullableIntegerSchema
+	}
+	return schema
 }
 
 func runFormat(args []string) {
 	fs := flag.NewFlagSet("format", flag.ExitOnError)
-	input := fs.String("input", "", "Input FASTA/FASTA.gz")
+	input := fs.String("input", "", "Input FASTA, plain or gzip/bgzip (- reads stdin)")
 	outDir := fs.String("outdir", "formatted", "Output directory")
-	classifiers := fs.String("classifier", "blast,kraken2,sintax", "Comma-separated classifiers (blast,kraken2,sintax,rdp,idtaxa,protax,dnasketch)")
+	classifiers := fs.String("classifier", "blast,kraken2,sintax", "Comma-separated classifiers ("+strings.Join(classifierNames(), ",")+"), or list to print them")
 	requireRanks := fs.String("require-ranks", "kingdom,phylum,class,order,family,genus,species", "Comma-separated ranks required to keep a sequence (empty disables)")
+	rankAliasSpec := fs.String("rank-aliases", "", rankAliasesUsage)
 	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Taxdump directory with nodes.dmp/names.dmp/taxid.map")
 	taxidMap := fs.String("taxid-map", "", "Optional taxid.map override")
+	taxidMapColumn := fs.Int("taxid-map-column", defaultTaxidMapColumn, "1-based taxid.map column holding the taxid (the ID is column 1)")
+	resolveByName := fs.Bool("resolve-by-name", false, "Give IDs missing from taxid.map the taxid of the species name (binomial) in their header description, from names.dmp")
+	resolveSynonyms := fs.Bool("resolve-synonyms", false, "With -resolve-by-name, also match names.dmp synonyms")
+	resolvedTSV := fs.String("resolved-tsv", "", "With -resolve-by-name, write the resolved id/taxid/name pairs here, ready to append to taxid.map")
 	progressOn := fs.Bool("progress", true, "Show progress bar (approximate)")
 	report := fs.String("report", "", "Optional JSON report output path")
+	provenanceDir := fs.String("provenance-dir", "", "Record per-record provenance events under this directory")
+	sanitize := fs.String("sanitize", string(defaultSanitizeMode), "Taxon/marker name sanitation: translit (fold diacritics to ASCII) or ascii (replace every non-ASCII byte)")
+	cacheDir := fs.String("cache-dir", "", "Reuse outputs from identical earlier runs cached in this directory")
+	noTaxonomy := fs.Bool("no-taxonomy", false, "Write sequence-only outputs (blast.fasta) without loading taxid.map or the taxdump")
+	wrap := fs.Int("wrap", 0, "Wrap FASTA sequences at this many bases per line (0 disables)")
+	sintaxGzip := fs.Bool("sintax-gzip", false, "Write the sintax reference gzipped (sintax.fasta.gz)")
+	kraken2StyleFlag := fs.String("kraken2-style", string(kraken2Header), "How the kraken2 reference carries taxids: header (>ID|kraken:taxid|TAXID) or map (plain headers and seqid2taxid.map)")
+	kraken2Symlink := fs.Bool("kraken2-symlink", false, "Symlink nodes.dmp/names.dmp into the kraken2 taxonomy/ directory instead of copying them")
+	rdpRanks := fs.String("rdp-ranks", "", "Comma-separated levels of the rdp taxonomy tree (empty uses -require-ranks); ranks a kept sequence lacks become <Rank>_incertae_sedis_<ancestor> nodes")
+	mothurRanks := fs.String("mothur-ranks", strings.Join(defaultMothurRanks, ","), "Comma-separated levels of the mothur taxonomy")
+	mothurPad := fs.Bool("mothur-pad", false, "Write levels a sequence lacks as unclassified in the mothur taxonomy instead of leaving the sequence out")
+	idtaxaRanks := fs.String("idtaxa-ranks", "", "Comma-separated levels of the idtaxa taxonomy (empty uses -require-ranks); a level a sequence lacks is written unclassified_<name above>")
+	idtaxaRankFile := fs.Bool("idtaxa-rank-file", false, "Also write idtaxa_ranks.tsv, the rank of each idtaxa taxonomy level")
+	blastIDs := fs.String("blast-ids", string(blastIDsFail), "Blast IDs makeblastdb -parse_seqids rejects: fail (list them), sanitize (replace bad characters with _), or keep")
+	runMakeblastdbFlag := fs.Bool("run-makeblastdb", false, "Build the blast database with makeblastdb when it is in PATH (output in makeblastdb.log)")
+	centrifugeNCBIMap := fs.String("centrifuge-ncbi-map", "", "Write centrifuge taxids as NCBI taxids from this ncbi-map output (needs -centrifuge-ncbi-taxdump-dir)")
+	centrifugeNCBITaxdump := fs.String("centrifuge-ncbi-taxdump-dir", "", "NCBI taxdump directory copied as the centrifuge taxonomy under -centrifuge-ncbi-map")
+	sourmashCSVOnly := fs.Bool("sourmash-csv-only", false, "Write only the sourmash lineages CSV, not the FASTA to sketch")
+	qiime2Prefix := fs.String("qiime2-prefix", string(qiime2Greengenes), "Level prefixes of the qiime2 taxonomy: greengenes (k__, p__, ...) or silva (D_0__, D_1__, ...)")
 	if err := fs.Parse(args); err != nil {
 		fatalf("parse args failed: %v", err)
 	}
+	if *classifiers == "list" {
+		printClassifiers()
+		return
+	}
+	if *wrap < 0 {
+		fatalf("wrap must be >= 0")
+	}
 	if *input == "" {
 		fatalf("input is required")
 	}
+	if *taxidMapColumn < 2 {
+		fatalf("taxid-map-column must be >= 2")
+	}
+	if (*resolveSynonyms || *resolvedTSV != "") && !*resolveByName {
+		fatalf("resolve-synonyms and resolved-tsv need -resolve-by-name")
+	}
+	if *noTaxonomy {
+		if err := checkNoTaxonomyFlags(fs); err != nil {
+			fatalf("%v", err)
+		}
+		*requireRanks = ""
+	}
+	sanitizeMode, err := parseSanitizeMode(*sanitize)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	cache, err := openArtifactCache(*cacheDir)
+	if err != nil {
+		fatalf("%v", err)
+	}
 	cfg := formatConfig{
-		Classifiers:  splitList(*classifiers),
-		RequireRanks: splitList(*requireRanks),
-		Input:        *input,
-		OutDir:       *outDir,
-		TaxdumpDir:   *taxdumpDir,
-		TaxidMapPath: *taxidMap,
-		ReportPath:   *report,
-		Progress:     *progressOn,
+		Classifiers:           splitList(*classifiers),
+		RequireRanks:          splitList(*requireRanks),
+		Input:                 *input,
+		OutDir:                *outDir,
+		TaxdumpDir:            *taxdumpDir,
+		TaxidMapPath:          *taxidMap,
+		TaxidMapColumn:        *taxidMapColumn,
+		ResolveByName:         *resolveByName,
+		ResolveSynonyms:       *resolveSynonyms,
+		ResolvedTSV:           *resolvedTSV,
+		RankAliases:           *rankAliasSpec,
+		ReportPath:            *report,
+		ProvenanceDir:         *provenanceDir,
+		Progress:              *progressOn,
+		Sanitize:              sanitizeMode,
+		Cache:                 cache,
+		NoTaxonomy:            *noTaxonomy,
+		Wrap:                  *wrap,
+		SintaxGzip:            *sintaxGzip,
+		Kraken2Symlink:        *kraken2Symlink,
+		RdpRanks:              splitList(*rdpRanks),
+		MothurRanks:           splitList(*mothurRanks),
+		MothurPad:             *mothurPad,
+		IdtaxaRanks:           splitList(*idtaxaRanks),
+		IdtaxaRankFile:        *idtaxaRankFile,
+		RunMakeblastdb:        *runMakeblastdbFlag,
+		CentrifugeNCBIMap:     *centrifugeNCBIMap,
+		CentrifugeNCBITaxdump: *centrifugeNCBITaxdump,
+		SourmashCSVOnly:       *sourmashCSVOnly,
+	}
+	if cfg.BlastIDs, err = parseBlastIDPolicy(*blastIDs); err != nil {
+		fatalf("%v", err)
+	}
+	if cfg.Kraken2Style, err = parseKraken2Style(*kraken2StyleFlag); err != nil {
+		fatalf("%v", err)
+	}
+	if cfg.Qiime2Prefix, err = parseQiime2PrefixStyle(*qiime2Prefix); err != nil {
+		fatalf("%v", err)
 	}
 	if len(cfg.Classifiers) == 0 {
 		fatalf("classifier must not be empty")
 	}
-	if err := formatFasta(cfg); err != nil {
+	if _, err := lookupClassifiers(cfg.Classifiers); err != nil {
+		fatalf("%v", err)
+	}
+	if (cfg.CentrifugeNCBIMap == "") != (cfg.CentrifugeNCBITaxdump == "") {
+		fatalf("centrifuge-ncbi-map and centrifuge-ncbi-taxdump-dir must be set together")
+	}
+	if _, err := parseRankAliases(cfg.RankAliases); err != nil {
+		fatalf("invalid -rank-aliases: %v", err)
+	}
+	if cfg.NoTaxonomy {
+		if err := checkNoTaxonomyClassifiers(cfg.Classifiers); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	err = recordStage(cfg.OutDir, "format", cfg.Input, formatFasta(ctx, cfg))
+	exitIfInterrupted(err)
+	if err != nil {
 		fatalf("format failed: %v", err)
 	}
 }
 
-type writerHandle struct {
-	w *bufio.Writer
-	f *os.File
-}
-
-type formatWriters struct {
-	blastFasta    writerHandle
-	blastMap      writerHandle
-	krakenFasta   writerHandle
-	sintaxFasta   writerHandle
-	rdpTrainFasta writerHandle
-	rdpTaxonomy   writerHandle
-	idtaxaFasta   writerHandle
-	idtaxaLineage writerHandle
-	protaxFasta   writerHandle
-	protaxMap     writerHandle
-}
-
-func formatFasta(cfg formatConfig) error {
-	in, counter, err := openInputWithCounter(cfg.Input)
+func formatFasta(ctx context.Context, cfg formatConfig) error {
+	if cfg.Sanitize == "" {
+		cfg.Sanitize = defaultSanitizeMode
+	}
+	// The cache key hashes the input; standard input is spooled first.
+	input, cleanup, err := spoolStdin(cfg.Input)
 	if err != nil {
-		return fmt.Errorf("open input: %w", err)
+		return err
 	}
-	defer func() {
-		_ = in.Close()
-	}()
+	defer cleanup()
+	cfg.Input = input
 
-	var bar *byteProgress
-	var lastCount int64
-	if cfg.Progress {
-		total := fileSize(cfg.Input)
-		bar = newByteProgress(total, "format (approx)")
+	// Provenance and the resolved table are side outputs the cache does not
+	// keep.
+	var key string
+	if cfg.Cache != nil && cfg.IDs == nil && cfg.ProvenanceDir == "" && cfg.ResolvedTSV == "" {
+		if key, err = formatCacheKey(cfg); err != nil {
+			return err
+		}
+		if e, ok := cfg.Cache.lookup(key); ok {
+			var stats formatStats
+			if err := json.Unmarshal(e.Stats, &stats); err != nil {
+				return fmt.Errorf("cache entry %s: %w", key, err)
+			}
+			for _, f := range e.Files {
+				if err := e.restore(f.Name, filepath.Join(cfg.OutDir, filepath.FromSlash(f.Name))); err != nil {
+					return err
+				}
+			}
+			logf("format: cache hit %s", key[:12])
+			return finishFormat(cfg, stats)
+		}
 	}
 
-	if err := os.MkdirAll(cfg.OutDir, 0o755); err != nil {
-		return fmt.Errorf("create outdir: %w", err)
+	stats, outputs, err := runFormatFasta(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		if err := cfg.Cache.store(key, "format", cfg.OutDir, outputs, stats); err != nil {
+			logf("format: WARNING not cached: %v", err)
+		}
 	}
+	return finishFormat(cfg, stats)
+}
 
+// formatCacheKey covers every format option that affects the outputs and
+// the taxdump files the run loads.
+func formatCacheKey(cfg formatConfig) (string, error) {
+	if cfg.NoTaxonomy {
+		return cfg.Cache.key("format", cfg.Input, cacheOptions(cfg, formatUncachedOptions...), nil)
+	}
 	taxidPath := cfg.TaxidMapPath
 	if taxidPath == "" {
 		taxidPath = filepath.Join(cfg.TaxdumpDir, "taxid.map")
 	}
-	taxidMap, err := loadTaxidMap(taxidPath)
-	if err != nil {
-		return err
+	refs := map[string]string{
+		"taxid.map": taxidPath,
+		"nodes.dmp": filepath.Join(cfg.TaxdumpDir, "nodes.dmp"),
+		"names.dmp": filepath.Join(cfg.TaxdumpDir, "names.dmp"),
 	}
-
-	nodesPath := filepath.Join(cfg.TaxdumpDir, "nodes.dmp")
-	namesPath := filepath.Join(cfg.TaxdumpDir, "names.dmp")
-	dump, err := loadTaxDump(nodesPath, namesPath)
-	if err != nil {
-		return err
+	addOptionalDmpRefs(refs, cfg.TaxdumpDir)
+	if cfg.CentrifugeNCBIMap != "" {
+		refs["centrifuge_ncbi_map"] = cfg.CentrifugeNCBIMap
 	}
-
-	writers, err := openFormatWriters(cfg.OutDir, cfg.Classifiers)
-	if err != nil {
-		return err
+	opts := cacheOptions(cfg, formatUncachedOptions...)
+	if err := addRankAliasOption(opts, cfg.RankAliases); err != nil {
+		return "", err
 	}
-	defer closeFormatWriters(writers)
-
-	stats := formatStats{}
-	err = parseFasta(in, func(rec fastaRecord) error {
-		stats.Total++
-		if rec.id == "" {
-			stats.MissingTaxID++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
-		}
-		taxid, ok := taxidMap[rec.id]
-		if !ok {
-			stats.MissingTaxID++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
-		}
-		lineage := dump.lineage(taxid)
-		if !hasAllRanks(lineage, cfg.RequireRanks) {
-			stats.MissingRanks++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
-		}
+	return cfg.Cache.key("format", cfg.Input, opts, refs)
+}
 
-		names := buildLineage(lineage, cfg.RequireRanks)
-		if len(names) == 0 {
-			stats.MissingRanks++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
-		}
-		seq := rec.seq
+// formatUncachedOptions do not change the format outputs. Input and the
+// taxdump paths are replaced by content hashes (a shared Taxdump is the one
+// TaxdumpDir names), and RankAliases by the
+// aliases it parses to; Marker only matters with IDs,
+// which bypasses the cache.
+var formatUncachedOptions = []string{"Input", "OutDir", "TaxdumpDir", "TaxidMapPath", "ReportPath", "ProvenanceDir", "Progress", "IDs", "Marker", "Cache", "ResolvedTSV", "RankAliases", "Taxdump", "Kraken2Symlink", "RunMakeblastdb", "CentrifugeNCBIMap", "CentrifugeNCBITaxdump"}
 
-		if writers.blastFasta.w != nil {
-			if err := writeFasta(writers.blastFasta.w, rec.id, seq); err != nil {
-				return err
-			}
-		}
-		if writers.blastMap.w != nil {
-			if _, err := writers.blastMap.w.WriteString(rec.id + "\t" + strconv.Itoa(taxid) + "\n"); err != nil {
-				return fmt.Errorf("write blast map: %w", err)
-			}
-		}
-		if writers.krakenFasta.w != nil {
-			header := rec.id + "|kraken:taxid|" + strconv.Itoa(taxid)
-			if err := writeFasta(writers.krakenFasta.w, header, seq); err != nil {
-				return err
-			}
-		}
-		if writers.sintaxFasta.w != nil {
-			header := rec.id + ";tax=" + sintaxLineage(names)
-			if err := writeFasta(writers.sintaxFasta.w, header, seq); err != nil {
-				return err
-			}
-		}
-		// RDP is handled separately in formatFastaRdp
-		if writers.idtaxaFasta.w != nil {
-			if err := writeFasta(writers.idtaxaFasta.w, rec.id, seq); err != nil {
-				return err
-			}
-		}
-		if writers.idtaxaLineage.w != nil {
-			lineageStr := "Root;" + strings.Join(names, ";")
-			if _, err := writers.idtaxaLineage.w.WriteString(rec.id + "\t" + lineageStr + "\n"); err != nil {
-				return fmt.Errorf("write idtaxa lineage: %w", err)
-			}
-		}
-		if writers.protaxFasta.w != nil {
-			if err := writeFasta(writers.protaxFasta.w, rec.id, seq); err != nil {
-				return err
-			}
-		}
-		if writers.protaxMap.w != nil {
-			lineageStr := strings.Join(names, ";")
-			if _, err := writers.protaxMap.w.WriteString(rec.id + "\t" + lineageStr + "\n"); err != nil {
-				return fmt.Errorf("write protax map: %w", err)
-			}
-		}
+// classifierDir is the directory classifier writes into.
+func classifierDir(cfg formatConfig, classifier string) string {
+	if cfg.ClassifierDirs {
+		return filepath.Join(cfg.OutDir, classifier)
+	}
+	return cfg.OutDir
+}
 
-		stats.Written++
-		updateByteProgress(bar, counter, &lastCount)
-		return nil
-	})
+// finishFormat runs the steps that follow the outputs on a cache hit as
+// well, such as placing taxonomy files, then reports.
+func finishFormat(cfg formatConfig, stats formatStats) error {
+	entries, err := lookupClassifiers(cfg.Classifiers)
 	if err != nil {
 		return err
 	}
-	updateByteProgress(bar, counter, &lastCount)
-	if bar != nil {
-		bar.Finish()
-	}
-
-	// Handle RDP separately with two-pass approach
-	if writers.rdpTrainFasta.w != nil {
-		if err := formatFastaRdp(cfg, taxidMap, dump, writers); err != nil {
-			return fmt.Errorf("rdp format: %w", err)
+	for _, e := range entries {
+		if e.finish == nil {
+			continue
+		}
+		dirCfg := cfg
+		dirCfg.OutDir = classifierDir(cfg, e.name)
+		if err := e.finish(dirCfg); err != nil {
+			return err
 		}
 	}
-
 	if cfg.ReportPath != "" {
-		if err := writeQCReport(cfg.ReportPath, qcStats{
-			Total:        stats.Total,
-			Written:      stats.Written,
-			MissingTaxID: stats.MissingTaxID,
-			MissingRanks: stats.MissingRanks,
+		if err := writeReportJSON(cfg.ReportPath, formatReport{
+			reportHeader: newReportHeader("format-report"),
+			formatStats:  stats,
+			Sanitize:     cfg.Sanitize,
+			NoTaxonomy:   cfg.NoTaxonomy,
+			Cache:        cacheStats(),
+			Resources:    currentRun().resources(),
 		}); err != nil {
 			return err
 		}
 	}
-	logf("format: total=%d kept=%d missing-taxid=%d missing-ranks=%d", stats.Total, stats.Written, stats.MissingTaxID, stats.MissingRanks)
+	if cfg.NoTaxonomy {
+		logf("format: total=%d kept=%d (no taxonomy)", stats.Total, stats.Written)
+		return nil
+	}
+	var resolved string
+	if cfg.ResolveByName {
+		resolved = fmt.Sprintf(" resolved-by-name=%d unresolved=%d", stats.ResolvedByName, stats.UnresolvedByName)
+	}
+	logf("format: total=%d kept=%d missing-taxid=%d missing-ranks=%d%s", stats.Total, stats.Written, stats.MissingTaxID, stats.MissingRanks, resolved)
 	return nil
 }
 
-// formatFastaRdp handles RDP-native output with two-pass processing
-func formatFastaRdp(cfg formatConfig, taxidMap map[string]int, dump *taxDump, writers *formatWriters) error {
-	// Create temp file for sequences
-	tmpFasta, err := os.CreateTemp("", "rdp_seqs_*.fasta")
+// runFormatFasta reads the input once, handing each kept record to the
+// formatter of every requested classifier, and returns the output paths.
+// The taxid and lineage of a record are looked up once for all of them.
+func runFormatFasta(ctx context.Context, cfg formatConfig) (formatStats, []string, error) {
+	entries, err := lookupClassifiers(cfg.Classifiers)
+	if err != nil {
+		return formatStats{}, nil, err
+	}
+	if cfg.NoTaxonomy {
+		if err := checkNoTaxonomyClassifiers(cfg.Classifiers); err != nil {
+			return formatStats{}, nil, err
+		}
+	}
+	in, counter, err := openFastaInput(cfg.Input)
 	if err != nil {
-		return fmt.Errorf("create temp file: %w", err)
+		return formatStats{}, nil, fmt.Errorf("open input: %w", err)
 	}
-	tmpPath := tmpFasta.Name()
 	defer func() {
-		_ = tmpFasta.Close()
-		_ = os.Remove(tmpPath)
+		_ = in.Close()
 	}()
 
-	tmpWriter := bufio.NewWriterSize(tmpFasta, writerBufferSize)
+	var bar *byteProgress
+	var lastCount int64
+	if cfg.Progress {
+		total := fileSize(cfg.Input)
+		bar = newByteProgress(total, "format (approx)")
+	}
 
-	// Pass 1: collect lineages and write sequences to temp file
-	builder := newRdpTaxonomyBuilder(cfg.RequireRanks)
-	var seqCount int
+	if err := os.MkdirAll(cfg.OutDir, 0o755); err != nil {
+		return formatStats{}, nil, fmt.Errorf("create outdir: %w", err)
+	}
 
-	in, err := openInput(cfg.Input)
+	aliases, err := parseRankAliases(cfg.RankAliases)
 	if err != nil {
-		return fmt.Errorf("open input for rdp: %w", err)
+		return formatStats{}, nil, fmt.Errorf("rank aliases: %w", err)
 	}
-	defer func() {
-		_ = in.Close()
-	}()
-
-	err = parseFasta(in, func(rec fastaRecord) error {
-		if rec.id == "" {
-			return nil
+	var taxidMap map[string]int
+	var dump *taxDump
+	if !cfg.NoTaxonomy {
+		cfg.RequireRanks = aliases.ranks(cfg.RequireRanks)
+		cfg.RdpRanks = aliases.ranks(cfg.RdpRanks)
+		if len(cfg.MothurRanks) == 0 {
+			cfg.MothurRanks = defaultMothurRanks
 		}
-		taxid, ok := taxidMap[rec.id]
-		if !ok {
-			return nil
+		cfg.MothurRanks = aliases.ranks(cfg.MothurRanks)
+		if len(cfg.IdtaxaRanks) == 0 {
+			cfg.IdtaxaRanks = cfg.RequireRanks
 		}
-		lineage := dump.lineage(taxid)
-		if !hasAllRanks(lineage, cfg.RequireRanks) {
-			return nil
+		cfg.IdtaxaRanks = aliases.ranks(cfg.IdtaxaRanks)
+		taxidPath := cfg.TaxidMapPath
+		if taxidPath == "" {
+			taxidPath = filepath.Join(cfg.TaxdumpDir, "taxid.map")
 		}
-
-		names := buildLineage(lineage, cfg.RequireRanks)
-		if len(names) == 0 {
-			return nil
+		taxidMap, err = loadTaxidMap(taxidPath, cfg.TaxidMapColumn, -1)
+		if err = acceptRowErrors("format: taxid.map", err); err != nil {
+			return formatStats{}, nil, err
 		}
 
-		// Add lineage to taxonomy builder
-		resolved := builder.addLineage(names)
-		if len(resolved) == 0 {
-			return nil
-		}
-
-		// Write to temp file: seqid\tlineage_keys\tsequence
-		lineageStr := strings.Join(resolved, "|")
-		if _, err := tmpWriter.WriteString(rec.id + "\t" + lineageStr + "\t" + string(rec.seq) + "\n"); err != nil {
-			return fmt.Errorf("write temp: %w", err)
+		dump, err = cfg.Taxdump.load(cfg.TaxdumpDir, aliases)
+		if err != nil {
+			return formatStats{}, nil, err
 		}
-		seqCount++
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	if err := tmpWriter.Flush(); err != nil {
-		return fmt.Errorf("flush temp: %w", err)
 	}
-	_ = tmpFasta.Close()
-
-	// Log disambiguation count
-	if builder.disambiguatedCount() > 0 {
-		logf("rdp: disambiguated %d taxonomy names due to parent conflicts", builder.disambiguatedCount())
+	var resolver *nameResolver
+	if cfg.ResolveByName && !cfg.NoTaxonomy {
+		if resolver, err = loadNameResolver(filepath.Join(cfg.TaxdumpDir, "names.dmp"), cfg.ResolveSynonyms); err != nil {
+			return formatStats{}, nil, err
+		}
+		logf("format: resolve-by-name index: %d names", len(resolver.taxids))
 	}
-
-	// Write taxonomy file
-	if err := builder.writeTaxonomyFile(writers.rdpTaxonomy.w); err != nil {
-		return fmt.Errorf("write taxonomy: %w", err)
+	resolvedTable, err := openResolvedTable(cfg.ResolvedTSV)
+	if err != nil {
+		return formatStats{}, nil, err
 	}
+	defer func() {
+		_ = resolvedTable.Close()
+	}()
+	// resolved holds the IDs resolved by name, each written to the table
+	// once.
+	resolved := make(map[string]bool)
 
-	// Pass 2: read temp file and write final FASTA with resolved lineages
-	tmpIn, err := os.Open(tmpPath)
+	prov, err := openProvenanceLog(cfg.ProvenanceDir, "format")
 	if err != nil {
-		return fmt.Errorf("open temp: %w", err)
+		return formatStats{}, nil, err
 	}
 	defer func() {
-		_ = tmpIn.Close()
+		_ = prov.Close()
 	}()
 
-	scanner := bufio.NewScanner(tmpIn)
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 10*1024*1024)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, "\t", 3)
-		if len(parts) != 3 {
-			continue
+	stats := formatStats{}
+	envs := make([]*FormatEnv, len(entries))
+	formatters := make([]ClassifierFormatter, len(entries))
+	defer func() {
+		for _, env := range envs {
+			if env != nil {
+				_ = env.close()
+			}
 		}
-		seqID := parts[0]
-		keys := strings.Split(parts[1], "|")
-		seq := parts[2]
-
-		// Build lineage string from resolved keys
-		lineageNames := builder.getLineageString(keys)
-		header := seqID + "\t" + lineageNames
-		if err := writeFasta(writers.rdpTrainFasta.w, header, []byte(seq)); err != nil {
-			return err
+	}()
+	for i, e := range entries {
+		envCfg := cfg
+		envCfg.OutDir = classifierDir(cfg, e.name)
+		if err := os.MkdirAll(envCfg.OutDir, 0o755); err != nil {
+			return formatStats{}, nil, fmt.Errorf("create outdir: %w", err)
 		}
-	}
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("scan temp: %w", err)
-	}
-
-	return nil
-}
-
-func openFormatWriters(outDir string, classifiers []string) (*formatWriters, error) {
-	w := &formatWriters{}
-	needs := make(map[string]struct{})
-	for _, c := range classifiers {
-		name := strings.ToLower(strings.TrimSpace(c))
-		if name == "" {
-			continue
+		envs[i] = &FormatEnv{cfg: envCfg, aliases: aliases, stats: &stats}
+		if formatters[i], err = e.factory(envs[i]); err != nil {
+			return formatStats{}, nil, fmt.Errorf("%s: %w", e.name, err)
 		}
-		needs[name] = struct{}{}
 	}
 
-	openFasta := func(name string) (writerHandle, error) {
-		path := filepath.Join(outDir, name)
-		f, err := os.Create(path)
-		if err != nil {
-			return writerHandle{}, fmt.Errorf("create %s: %w", path, err)
+	// Several formatters run concurrently, one goroutine each.
+	var fan *formatFanOut
+	if len(formatters) > 1 {
+		names := make([]string, len(entries))
+		for i, e := range entries {
+			names[i] = e.name
 		}
-		return writerHandle{w: bufio.NewWriterSize(f, writerBufferSize), f: f}, nil
+		fan = newFormatFanOut(formatters, names)
+		defer func() {
+			_ = fan.wait()
+		}()
 	}
 
-	if _, ok := needs["blast"]; ok {
-		bw, err := openFasta("blast.fasta")
-		if err != nil {
-			return nil, err
+	classifierKey := strings.Join(cfg.Classifiers, ",")
+	collided := 0
+	err = parseFastaContext(ctx, in, func(rec fastaRecord) error {
+		stats.Total++
+		if rec.id == "" {
+			if !cfg.NoTaxonomy {
+				stats.MissingTaxID++
+			}
+			updateByteProgress(bar, counter, &lastCount)
+			return nil
 		}
-		mw, err := openFasta("blast_seqid2taxid.map")
-		if err != nil {
-			return nil, err
+		var taxid int
+		var names []string
+		var lineage taxLineage
+		if !cfg.NoTaxonomy {
+			var ok bool
+			taxid, ok = taxidMap[rec.id]
+			if !ok && resolver != nil {
+				var name string
+				if name, taxid, ok = resolver.resolve(rec.desc); ok {
+					stats.ResolvedByName++
+					if !resolved[rec.id] {
+						resolved[rec.id] = true
+						if err := resolvedTable.write(rec.id, taxid, name); err != nil {
+							return err
+						}
+					}
+				} else {
+					stats.UnresolvedByName++
+				}
+			}
+			if !ok {
+				stats.MissingTaxID++
+				updateByteProgress(bar, counter, &lastCount)
+				return prov.record(rec.id, "dropped", "missing_taxid", 0)
+			}
+			var merged bool
+			if taxid, merged = dump.current(taxid); merged {
+				stats.MergedTaxID++
+			}
+			lineage = dump.lineage(taxid)
+			if !hasAllRanks(lineage, cfg.RequireRanks) {
+				reason := "missing_ranks"
+				if dump.isDeleted(taxid) {
+					stats.DeletedTaxID++
+					reason = "deleted_taxid"
+				} else {
+					stats.MissingRanks++
+				}
+				updateByteProgress(bar, counter, &lastCount)
+				return prov.record(rec.id, "dropped", reason, 0)
+			}
+
+			names = buildLineage(lineage, cfg.RequireRanks, cfg.Sanitize)
+			if len(names) == 0 {
+				stats.MissingRanks++
+				updateByteProgress(bar, counter, &lastCount)
+				return prov.record(rec.id, "dropped", "missing_ranks", 0)
+			}
 		}
-		w.blastFasta = bw
-		w.blastMap = mw
-	}
-	if _, ok := needs["kraken2"]; ok {
-		bw, err := openFasta("kraken2.fasta")
-		if err != nil {
-			return nil, err
+		id, keep := cfg.IDs.claim(classifierKey, cfg.Marker, rec.id)
+		if !keep || id != rec.id {
+			collided++
 		}
-		w.krakenFasta = bw
-	}
-	if _, ok := needs["sintax"]; ok {
-		bw, err := openFasta("sintax.fasta")
-		if err != nil {
-			return nil, err
+		if !keep {
+			updateByteProgress(bar, counter, &lastCount)
+			return prov.record(rec.id, "dropped", "id written by another marker", 0)
 		}
-		w.sintaxFasta = bw
-	}
-	if _, ok := needs["rdp"]; ok {
-		bw, err := openFasta("rdp_train_seqs.fasta")
-		if err != nil {
-			return nil, err
+
+		out := &FormatRecord{ID: id, Seq: rec.seq, TaxID: taxid, lineage: lineage, names: names}
+		if fan != nil {
+			if !fan.add(out) {
+				return fan.wait()
+			}
+		} else {
+			for i, f := range formatters {
+				if err := f.Format(out); err != nil {
+					return fmt.Errorf("%s: %w", entries[i].name, err)
+				}
+			}
 		}
-		tw, err := openFasta("rdp_taxonomy.txt")
-		if err != nil {
-			return nil, err
+
+		stats.Written++
+		updateByteProgress(bar, counter, &lastCount)
+		detail := classifierKey
+		if id != rec.id {
+			detail += " as " + id
 		}
-		w.rdpTrainFasta = bw
-		w.rdpTaxonomy = tw
+		return prov.record(rec.id, "written", detail, 0)
+	})
+	if err != nil {
+		return formatStats{}, nil, interruptedAt(ctx, err, "format", int64(stats.Total))
 	}
-	if _, ok := needs["idtaxa"]; ok {
-		bw, err := openFasta("idtaxa_seqs.fasta")
-		if err != nil {
-			return nil, err
-		}
-		tw, err := openFasta("idtaxa_lineage.tsv")
-		if err != nil {
-			return nil, err
+	if fan != nil {
+		if err := fan.wait(); err != nil {
+			return formatStats{}, nil, err
 		}
-		w.idtaxaFasta = bw
-		w.idtaxaLineage = tw
 	}
-	if _, ok := needs["protax"]; ok {
-		bw, err := openFasta("protax_seqs.fasta")
-		if err != nil {
-			return nil, err
+	updateByteProgress(bar, counter, &lastCount)
+	if bar != nil {
+		bar.Finish()
+	}
+
+	var outputs []string
+	for i, f := range formatters {
+		if err := f.Close(); err != nil {
+			return formatStats{}, nil, err
 		}
-		tw, err := openFasta("protax_seqid2tax.tsv")
-		if err != nil {
-			return nil, err
+		if err := envs[i].close(); err != nil {
+			return formatStats{}, nil, err
 		}
-		w.protaxFasta = bw
-		w.protaxMap = tw
+		outputs = append(outputs, envs[i].paths()...)
+	}
+	if err := resolvedTable.Close(); err != nil {
+		return formatStats{}, nil, err
 	}
-	return w, nil
+	if err := prov.Close(); err != nil {
+		return formatStats{}, nil, err
+	}
+	if collided > 0 {
+		logf("format: %d IDs already written by another marker (%s)", collided, cfg.IDs.policy)
+	}
+	return stats, outputs, nil
 }
 
-func closeFormatWriters(w *formatWriters) {
-	flush := func(h writerHandle) {
-		if h.w == nil {
-			return
-		}
-		_ = h.w.Flush()
-		if h.f != nil {
-			_ = h.f.Close()
-		}
-	}
-	flush(w.blastFasta)
-	flush(w.blastMap)
-	flush(w.krakenFasta)
-	flush(w.sintaxFasta)
-	flush(w.rdpTrainFasta)
-	flush(w.rdpTaxonomy)
-	flush(w.idtaxaFasta)
-	flush(w.idtaxaLineage)
-	flush(w.protaxFasta)
-	flush(w.protaxMap)
+// protaxFormatter writes protax_seqs.fasta and protax_seqid2tax.tsv, the
+// ';'-joined -require-ranks names of each record.
+type protaxFormatter struct {
+	fasta, taxonomy *FormatOutput
 }
 
-func writeFasta(w *bufio.Writer, header string, seq []byte) error {
-	if _, err := w.WriteString(">" + header + "\n"); err != nil {
-		return fmt.Errorf("write header: %w", err)
+func newProtaxFormatter(env *FormatEnv) (ClassifierFormatter, error) {
+	p := &protaxFormatter{}
+	var err error
+	if p.fasta, err = env.Create("protax_seqs.fasta"); err != nil {
+		return nil, err
 	}
-	if _, err := w.Write(seq); err != nil {
-		return fmt.Errorf("write seq: %w", err)
+	if p.taxonomy, err = env.Create("protax_seqid2tax.tsv"); err != nil {
+		return nil, err
 	}
-	if _, err := w.WriteString("\n"); err != nil {
-		return fmt.Errorf("write newline: %w", err)
+	return p, nil
+}
+
+func (p *protaxFormatter) Name() string { return "protax" }
+
+func (p *protaxFormatter) Format(rec *FormatRecord) error {
+	if err := p.fasta.fasta.Write(rec.ID, rec.Seq); err != nil {
+		return err
+	}
+	if _, err := p.taxonomy.w.WriteString(rec.ID + "\t" + strings.Join(rec.names, ";") + "\n"); err != nil {
+		return fmt.Errorf("write protax map: %w", err)
 	}
 	return nil
 }
 
-func buildLineage(lineage map[string]string, ranks []string) []string {
+func (p *protaxFormatter) Close() error { return nil }
+
+func buildLineage(lineage taxLineage, ranks []string, sanitize nameSanitizer) []string {
 	if len(ranks) == 0 {
 		return nil
 	}
 	out := make([]string, 0, len(ranks))
 	for _, rank := range ranks {
-		name := lineage[rank]
+		name := lineage.get(rank)
 		if name == "" {
 			return nil
 		}
-		out = append(out, sanitizeTaxon(name))
+		out = append(out, sanitize.taxon(name))
 	}
 	return out
 }
 
-func sintaxLineage(names []string) string {
-	prefixes := []string{"d", "p", "c", "o", "f", "g", "s"}
-	parts := make([]string, 0, len(names))
-	for i, name := range names {
-		if i >= len(prefixes) {
-			break
-		}
-		parts = append(parts, prefixes[i]+":"+name)
+// sintaxRanks are the lineage ranks a SINTAX header names, root first,
+// with their prefixes.
+var sintaxRanks = [...]struct{ rank, prefix string }{
+	{"domain", "d"},
+	{"kingdom", "k"},
+	{"phylum", "p"},
+	{"class", "c"},
+	{"order", "o"},
+	{"family", "f"},
+	{"genus", "g"},
+	{"species", "s"},
+}
+
+// sintaxFormatter writes the vsearch --sintax reference, sintax.fasta or
+// under -sintax-gzip sintax.fasta.gz.
+type sintaxFormatter struct {
+	fasta    *FormatOutput
+	sanitize nameSanitizer
+	stats    *formatStats
+}
+
+func newSintaxFormatter(env *FormatEnv) (ClassifierFormatter, error) {
+	name := "sintax.fasta"
+	if env.cfg.SintaxGzip {
+		name += ".gz"
+	}
+	fasta, err := env.Create(name)
+	if err != nil {
+		return nil, err
 	}
-	if len(names) > len(prefixes) {
-		log.Printf("sintax: dropping %d ranks beyond species for %v", len(names)-len(prefixes), names)
+	return &sintaxFormatter{fasta: fasta, sanitize: env.cfg.Sanitize, stats: env.stats}, nil
+}
+
+func (s *sintaxFormatter) Name() string { return "sintax" }
+
+func (s *sintaxFormatter) Format(rec *FormatRecord) error {
+	header, ok := sintaxHeader(rec.ID, rec.lineage, s.sanitize)
+	if !ok {
+		s.stats.SintaxNoKingdom++
+		return nil
+	}
+	return s.fasta.fasta.Write(header, rec.Seq)
+}
+
+func (s *sintaxFormatter) Close() error { return nil }
+
+// sintaxHeader returns the vsearch --sintax reference header of id,
+// "id;tax=k:Animalia,p:Arthropoda,...,s:Genus_species;", with the
+// sanitized names of the sintaxRanks lineage names and the rest left out.
+// ok is false when lineage names no kingdom.
+func sintaxHeader(id string, lineage taxLineage, sanitize nameSanitizer) (header string, ok bool) {
+	if lineage.get("kingdom") == "" {
+		return "", false
+	}
+	b := make([]byte, 0, len(id)+128)
+	b = append(b, id...)
+	b = append(b, ";tax="...)
+	first := true
+	for _, r := range sintaxRanks {
+		name := lineage.get(r.rank)
+		if name == "" {
+			continue
+		}
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		b = append(b, r.prefix...)
+		b = append(b, ':')
+		b = sanitize.appendName(b, []byte(name))
 	}
-	return strings.Join(parts, ",")
+	b = append(b, ';')
+	return string(b), true
 }
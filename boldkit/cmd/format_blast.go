@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// blastMaxIDLen is the longest local sequence ID makeblastdb -parse_seqids
+// accepts.
+const blastMaxIDLen = 50
+
+// blastIDPolicy chooses what format does with blast IDs makeblastdb
+// -parse_seqids would reject.
+type blastIDPolicy string
+
+const (
+	// blastIDsFail fails the run, listing the offending IDs.
+	blastIDsFail blastIDPolicy = "fail"
+	// blastIDsSanitize replaces the rejected bytes with '_'. Over-long IDs
+	// still fail: truncating them could make two IDs collide.
+	blastIDsSanitize blastIDPolicy = "sanitize"
+	// blastIDsKeep writes IDs unchecked, and -run-makeblastdb then leaves
+	// out -parse_seqids.
+	blastIDsKeep blastIDPolicy = "keep"
+)
+
+func parseBlastIDPolicy(s string) (blastIDPolicy, error) {
+	switch p := blastIDPolicy(s); p {
+	case blastIDsFail, blastIDsSanitize, blastIDsKeep:
+		return p, nil
+	case "":
+		return blastIDsFail, nil
+	default:
+		return "", fmt.Errorf("unknown -blast-ids %q (use fail, sanitize, or keep)", s)
+	}
+}
+
+// blastIDByteOK reports whether makeblastdb -parse_seqids takes c in a
+// local ID: printable ASCII other than '|', which would make it parse the
+// ID as a database-tagged Seq-id.
+func blastIDByteOK(c byte) bool {
+	return c > ' ' && c < 0x7f && c != '|'
+}
+
+// blastIDChecker applies a blastIDPolicy to each blast ID, counting the
+// sanitized IDs and keeping the first rejected ones for the error.
+type blastIDChecker struct {
+	policy    blastIDPolicy
+	sanitized int
+	rejected  int
+	examples  []string
+}
+
+const blastIDExamples = 10
+
+// check returns the ID to write for id.
+func (c *blastIDChecker) check(id string) string {
+	if c.policy == blastIDsKeep {
+		return id
+	}
+	bad := strings.IndexFunc(id, func(r rune) bool { return r >= 0x80 || !blastIDByteOK(byte(r)) }) >= 0
+	if bad && c.policy == blastIDsSanitize {
+		b := []byte(id)
+		for i, ch := range b {
+			if !blastIDByteOK(ch) {
+				b[i] = '_'
+			}
+		}
+		id, bad = string(b), false
+		c.sanitized++
+	}
+	if bad || len(id) > blastMaxIDLen {
+		c.rejected++
+		if len(c.examples) < blastIDExamples {
+			c.examples = append(c.examples, fmt.Sprintf("%q", id))
+		}
+	}
+	return id
+}
+
+// err reports the rejected IDs, nil when there were none.
+func (c *blastIDChecker) err() error {
+	if c.rejected == 0 {
+		return nil
+	}
+	more := ""
+	if c.rejected > len(c.examples) {
+		more = fmt.Sprintf(" and %d more", c.rejected-len(c.examples))
+	}
+	return fmt.Errorf("%d blast IDs makeblastdb -parse_seqids would reject ('|', whitespace, non-ASCII, or over %d characters): %s%s (use -blast-ids sanitize or keep)",
+		c.rejected, blastMaxIDLen, strings.Join(c.examples, ", "), more)
+}
+
+// blastFormatter writes blast.fasta and, with taxonomy, the
+// blast_seqid2taxid.map makeblastdb -taxid_map reads.
+type blastFormatter struct {
+	fasta, seqid2taxid *FormatOutput
+	ids                blastIDChecker
+	stats              *formatStats
+}
+
+func newBlastFormatter(env *FormatEnv) (ClassifierFormatter, error) {
+	b := &blastFormatter{ids: blastIDChecker{policy: env.cfg.BlastIDs}, stats: env.stats}
+	var err error
+	if b.fasta, err = env.Create("blast.fasta"); err != nil {
+		return nil, err
+	}
+	if !env.cfg.NoTaxonomy {
+		if b.seqid2taxid, err = env.Create("blast_seqid2taxid.map"); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (b *blastFormatter) Name() string { return "blast" }
+
+func (b *blastFormatter) Format(rec *FormatRecord) error {
+	id := b.ids.check(rec.ID)
+	if err := b.fasta.fasta.Write(id, rec.Seq); err != nil {
+		return err
+	}
+	if b.seqid2taxid != nil {
+		if _, err := b.seqid2taxid.w.WriteString(id + "\t" + strconv.Itoa(rec.TaxID) + "\n"); err != nil {
+			return fmt.Errorf("write blast map: %w", err)
+		}
+	}
+	return nil
+}
+
+func (b *blastFormatter) Close() error {
+	if err := b.ids.err(); err != nil {
+		return err
+	}
+	b.stats.BlastIDsSanitized = b.ids.sanitized
+	return nil
+}
+
+// finishBlast runs makeblastdb under -run-makeblastdb.
+func finishBlast(cfg formatConfig) error {
+	if !cfg.RunMakeblastdb {
+		return nil
+	}
+	return runMakeblastdb(cfg.OutDir, !cfg.NoTaxonomy, cfg.BlastIDs != blastIDsKeep)
+}
+
+const makeblastdbLog = "makeblastdb.log"
+
+// runMakeblastdb builds the blast nucleotide database "blast" in outDir
+// from blast.fasta, with the taxids of blast_seqid2taxid.map when
+// taxidMap is set. Its output goes to makeblastdb.log in outDir. A missing
+// makeblastdb binary is logged, not an error.
+func runMakeblastdb(outDir string, taxidMap, parseSeqids bool) error {
+	bin, err := exec.LookPath("makeblastdb")
+	if err != nil {
+		logf("format: makeblastdb not found in PATH; skipping -run-makeblastdb")
+		return nil
+	}
+	args := []string{"-in", "blast.fasta", "-dbtype", "nucl", "-out", "blast"}
+	if parseSeqids {
+		args = append(args, "-parse_seqids")
+	}
+	if taxidMap {
+		args = append(args, "-taxid_map", "blast_seqid2taxid.map")
+	}
+	logPath := filepath.Join(outDir, makeblastdbLog)
+	log, err := os.Create(logPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", logPath, err)
+	}
+	defer func() {
+		_ = log.Close()
+	}()
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = outDir
+	cmd.Stdout = log
+	cmd.Stderr = log
+	logf("format: makeblastdb %s", strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("makeblastdb failed (see %s): %w", logPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// centrifugeTaxonomyDir holds the nodes.dmp and names.dmp centrifuge-build
+// reads with --taxonomy-tree and --name-table.
+const centrifugeTaxonomyDir = "centrifuge_taxonomy"
+
+// centrifugeFormatter writes the centrifuge-build inputs: centrifuge.fasta
+// and its --conversion-table centrifuge_seqid2taxid.map. With ncbi set
+// (from an ncbi-map output) taxids are written as their NCBI taxids, and
+// sequences whose taxid has none are left out and listed in
+// centrifuge_unmapped.tsv.
+type centrifugeFormatter struct {
+	fasta, seqid2taxid, unmapped *FormatOutput
+	ncbi                         map[int]int
+	stats                        *formatStats
+}
+
+func newCentrifugeFormatter(env *FormatEnv) (ClassifierFormatter, error) {
+	c := &centrifugeFormatter{stats: env.stats}
+	var err error
+	if c.fasta, err = env.Create("centrifuge.fasta"); err != nil {
+		return nil, err
+	}
+	if c.seqid2taxid, err = env.Create("centrifuge_seqid2taxid.map"); err != nil {
+		return nil, err
+	}
+	if env.cfg.CentrifugeNCBIMap != "" {
+		if c.ncbi, err = loadNCBIMap(env.cfg.CentrifugeNCBIMap); err != nil {
+			return nil, err
+		}
+		if c.unmapped, err = env.Create("centrifuge_unmapped.tsv"); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *centrifugeFormatter) Name() string { return "centrifuge" }
+
+func (c *centrifugeFormatter) Format(rec *FormatRecord) error {
+	taxid := rec.TaxID
+	if c.ncbi != nil {
+		ncbiTaxid, ok := c.ncbi[taxid]
+		if !ok {
+			c.stats.CentrifugeUnmapped++
+			if _, err := c.unmapped.w.WriteString(rec.ID + "\t" + strconv.Itoa(taxid) + "\n"); err != nil {
+				return fmt.Errorf("write centrifuge unmapped list: %w", err)
+			}
+			return nil
+		}
+		taxid = ncbiTaxid
+	}
+	if err := c.fasta.fasta.Write(rec.ID, rec.Seq); err != nil {
+		return err
+	}
+	if _, err := c.seqid2taxid.w.WriteString(rec.ID + "\t" + strconv.Itoa(taxid) + "\n"); err != nil {
+		return fmt.Errorf("write centrifuge seqid2taxid map: %w", err)
+	}
+	return nil
+}
+
+func (c *centrifugeFormatter) Close() error { return nil }
+
+// loadNCBIMap reads the bold -> NCBI taxid pairs of an ncbi-map output,
+// skipping the taxa it found no NCBI taxid for.
+func loadNCBIMap(path string) (map[int]int, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("open ncbi map: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	out := make(map[int]int)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), "\t")
+		if line == 1 && len(fields) > 0 && fields[0] == "bold_taxid" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%s line %d: want the bold_taxid and ncbi_taxid columns of ncbi-map", path, line)
+		}
+		if fields[3] == "" {
+			continue
+		}
+		bold, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: invalid bold_taxid %q", path, line, fields[0])
+		}
+		ncbi, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: invalid ncbi_taxid %q", path, line, fields[3])
+		}
+		out[bold] = ncbi
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// writeCentrifugeTaxonomy copies the taxonomy the centrifuge taxids come
+// from, the NCBI taxdump when they were remapped, into
+// outDir/centrifuge_taxonomy.
+func writeCentrifugeTaxonomy(cfg formatConfig) error {
+	src := cfg.TaxdumpDir
+	if cfg.CentrifugeNCBIMap != "" {
+		src = cfg.CentrifugeNCBITaxdump
+	}
+	return writeTaxonomyFiles(src, filepath.Join(cfg.OutDir, centrifugeTaxonomyDir), false)
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// dada2Ranks are the ranks of a DADA2 assignTaxonomy training lineage.
+var dada2Ranks = []string{"kingdom", "phylum", "class", "order", "family", "genus"}
+
+const dada2ReportName = "dada2_report.json"
+
+// dada2Stats counts the DADA2 outputs. Truncated counts the training
+// records whose lineage stops short of genus at a missing rank, NoKingdom the
+// records left out for lacking even a kingdom, and NoSpecies those left out
+// of the species FASTA for lacking a genus and species. Collapsed and
+// SpeciesCollapsed count the records that repeat the sequence and lineage
+// (or species) of one already written.
+type dada2Stats struct {
+	TrainWritten     int `json:"train_written"`
+	Truncated        int `json:"truncated"`
+	NoKingdom        int `json:"no_kingdom"`
+	Collapsed        int `json:"collapsed"`
+	SpeciesWritten   int `json:"species_written"`
+	NoSpecies        int `json:"no_species"`
+	SpeciesCollapsed int `json:"species_collapsed"`
+}
+
+// dada2Report is written as dada2_report.json next to the DADA2 outputs.
+type dada2Report struct {
+	reportHeader
+	dada2Stats
+}
+
+// dada2Formatter writes the DADA2 references: dada2_train.fasta for
+// assignTaxonomy, headed by the ';'-terminated lineage, and
+// dada2_species.fasta for assignSpecies, headed "ID Genus species". Close
+// writes dada2_report.json.
+type dada2Formatter struct {
+	env            *FormatEnv
+	train, species *FormatOutput
+	// trainSeen and speciesSeen hold the lineage or species NUL sequence of
+	// each record written.
+	trainSeen, speciesSeen *hashSet
+	stats                  dada2Stats
+}
+
+func newDada2Formatter(env *FormatEnv) (ClassifierFormatter, error) {
+	d := &dada2Formatter{env: env, trainSeen: newHashSet(), speciesSeen: newHashSet()}
+	var err error
+	if d.train, err = env.Create("dada2_train.fasta"); err != nil {
+		return nil, err
+	}
+	if d.species, err = env.Create("dada2_species.fasta"); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *dada2Formatter) Name() string { return "dada2" }
+
+func (d *dada2Formatter) Format(rec *FormatRecord) error {
+	return d.write(rec.ID, rec.lineage, rec.Seq, d.env.cfg.Sanitize)
+}
+
+func (d *dada2Formatter) Close() error {
+	path, err := d.writeReport(d.env.cfg.OutDir)
+	if err != nil {
+		return err
+	}
+	d.env.extra = append(d.env.extra, path)
+	return nil
+}
+
+// write adds a record to both references, skipping what they already hold.
+func (d *dada2Formatter) write(id string, lineage taxLineage, seq []byte, sanitize nameSanitizer) error {
+	header := make([]byte, 0, 128)
+	depth := 0
+	for _, rank := range dada2Ranks {
+		name := lineage.get(rank)
+		if name == "" {
+			break
+		}
+		header = sanitize.appendName(header, []byte(name))
+		header = append(header, ';')
+		depth++
+	}
+	if depth == 0 {
+		d.stats.NoKingdom++
+	} else {
+		if depth < len(dada2Ranks) {
+			d.stats.Truncated++
+		}
+		if d.trainSeen.add(string(header) + "\x00" + string(seq)) {
+			d.stats.Collapsed++
+		} else {
+			if err := d.train.fasta.Write(string(header), seq); err != nil {
+				return err
+			}
+			d.stats.TrainWritten++
+		}
+	}
+
+	genus, epithet := dada2Species(lineage)
+	if genus == "" {
+		d.stats.NoSpecies++
+		return nil
+	}
+	species := sanitize.taxon(genus) + " " + sanitize.taxon(epithet)
+	if d.speciesSeen.add(species + "\x00" + string(seq)) {
+		d.stats.SpeciesCollapsed++
+		return nil
+	}
+	if err := d.species.fasta.Write(id+" "+species, seq); err != nil {
+		return err
+	}
+	d.stats.SpeciesWritten++
+	return nil
+}
+
+// dada2Species splits the species of lineage into its genus, from the
+// lineage when it has one, and the rest of the name. genus is "" when the
+// lineage has no species of two or more words.
+func dada2Species(lineage taxLineage) (genus, epithet string) {
+	words := strings.Fields(lineage.get("species"))
+	if len(words) < 2 {
+		return "", ""
+	}
+	genus = lineage.get("genus")
+	if genus == "" {
+		genus = words[0]
+	}
+	return genus, strings.Join(words[1:], " ")
+}
+
+// writeReport writes dada2_report.json into outDir and returns its path.
+func (d *dada2Formatter) writeReport(outDir string) (string, error) {
+	path := filepath.Join(outDir, dada2ReportName)
+	// The old report may be hardlinked into the cache; never rewrite it.
+	if err := removeIfExists(path); err != nil {
+		return "", err
+	}
+	report := dada2Report{reportHeader: newReportHeader("dada2-report"), dada2Stats: d.stats}
+	if err := writeReportJSON(path, report); err != nil {
+		return "", err
+	}
+	logf("format: dada2 train=%d truncated=%d collapsed=%d no-kingdom=%d species=%d species-collapsed=%d no-species=%d",
+		d.stats.TrainWritten, d.stats.Truncated, d.stats.Collapsed, d.stats.NoKingdom, d.stats.SpeciesWritten, d.stats.SpeciesCollapsed, d.stats.NoSpecies)
+	return path, nil
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// idtaxaUnclassified prefixes the name of the nearest named level above
+// to fill a gap in an IDTAXA taxonomy, as DECIPHER training workflows do.
+const idtaxaUnclassified = "unclassified_"
+
+// idtaxaFormatter writes idtaxa_seqs.fasta and idtaxa_lineage.tsv, the
+// DECIPHER LearnTaxa inputs, and under -idtaxa-rank-file idtaxa_ranks.tsv.
+type idtaxaFormatter struct {
+	fasta, lineage *FormatOutput
+	ranks          []string
+	sanitize       nameSanitizer
+}
+
+func newIdtaxaFormatter(env *FormatEnv) (ClassifierFormatter, error) {
+	t := &idtaxaFormatter{ranks: env.cfg.IdtaxaRanks, sanitize: env.cfg.Sanitize}
+	var err error
+	if t.fasta, err = env.Create("idtaxa_seqs.fasta"); err != nil {
+		return nil, err
+	}
+	if t.lineage, err = env.Create("idtaxa_lineage.tsv"); err != nil {
+		return nil, err
+	}
+	if env.cfg.IdtaxaRankFile {
+		rw, err := env.Create("idtaxa_ranks.tsv")
+		if err != nil {
+			return nil, err
+		}
+		if err := writeIdtaxaRanks(rw.w, t.ranks); err != nil {
+			return nil, fmt.Errorf("write idtaxa ranks: %w", err)
+		}
+	}
+	return t, nil
+}
+
+func (t *idtaxaFormatter) Name() string { return "idtaxa" }
+
+func (t *idtaxaFormatter) Format(rec *FormatRecord) error {
+	if err := t.fasta.fasta.Write(rec.ID, rec.Seq); err != nil {
+		return err
+	}
+	if _, err := t.lineage.w.WriteString(rec.ID + "\t" + idtaxaTaxonomy(rec.lineage, t.ranks, t.sanitize) + "\n"); err != nil {
+		return fmt.Errorf("write idtaxa lineage: %w", err)
+	}
+	return nil
+}
+
+func (t *idtaxaFormatter) Close() error { return nil }
+
+// idtaxaTaxonomy returns the DECIPHER LearnTaxa taxonomy of lineage, such
+// as "Root;Animalia;Arthropoda;unclassified_Arthropoda;...": Root and the
+// sanitized name of each of ranks, joined by ';'. A rank lineage lacks
+// repeats the last name above it with the unclassified_ prefix, so every
+// taxonomy has the same number of levels.
+func idtaxaTaxonomy(lineage taxLineage, ranks []string, sanitize nameSanitizer) string {
+	b := make([]byte, 0, 160)
+	b = append(b, "Root"...)
+	known := "Root"
+	for _, rank := range ranks {
+		b = append(b, ';')
+		if name := lineage.get(rank); name != "" {
+			known = sanitize.taxon(name)
+			b = append(b, known...)
+		} else {
+			b = append(b, idtaxaUnclassified+known...)
+		}
+	}
+	return string(b)
+}
+
+// writeIdtaxaRanks writes the rank of each IDTAXA taxonomy level, one
+// "level<TAB>rank" line per level from 0 for Root (rootrank).
+func writeIdtaxaRanks(w io.Writer, ranks []string) error {
+	if _, err := io.WriteString(w, "0\trootrank\n"); err != nil {
+		return err
+	}
+	for i, rank := range ranks {
+		if _, err := io.WriteString(w, strconv.Itoa(i+1)+"\t"+rank+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// kraken2Style chooses how the kraken2 reference carries taxids.
+type kraken2Style string
+
+const (
+	// kraken2Header writes ">ID|kraken:taxid|TAXID" headers.
+	kraken2Header kraken2Style = "header"
+	// kraken2Map writes plain ">ID" headers and a seqid2taxid.map, which
+	// kraken2-build uses in place of scanning the headers.
+	kraken2Map kraken2Style = "map"
+)
+
+func parseKraken2Style(s string) (kraken2Style, error) {
+	switch style := kraken2Style(s); style {
+	case kraken2Header, kraken2Map:
+		return style, nil
+	case "":
+		return kraken2Header, nil
+	default:
+		return "", fmt.Errorf("unknown -kraken2-style %q (use header or map)", s)
+	}
+}
+
+// kraken2Formatter writes kraken2.fasta and, in the map style, the
+// seqid2taxid.map kraken2-build reads in place of the headers.
+type kraken2Formatter struct {
+	fasta, seqid2taxid *FormatOutput
+	style              kraken2Style
+}
+
+func newKraken2Formatter(env *FormatEnv) (ClassifierFormatter, error) {
+	k := &kraken2Formatter{style: env.cfg.Kraken2Style}
+	var err error
+	if k.fasta, err = env.Create("kraken2.fasta"); err != nil {
+		return nil, err
+	}
+	if k.style == kraken2Map {
+		if k.seqid2taxid, err = env.Create("seqid2taxid.map"); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (k *kraken2Formatter) Name() string { return "kraken2" }
+
+func (k *kraken2Formatter) Format(rec *FormatRecord) error {
+	taxid := strconv.Itoa(rec.TaxID)
+	if k.seqid2taxid == nil {
+		return k.fasta.fasta.Write(rec.ID+"|kraken:taxid|"+taxid, rec.Seq)
+	}
+	if err := k.fasta.fasta.Write(rec.ID, rec.Seq); err != nil {
+		return err
+	}
+	if _, err := k.seqid2taxid.w.WriteString(rec.ID + "\t" + taxid + "\n"); err != nil {
+		return fmt.Errorf("write kraken2 map: %w", err)
+	}
+	return nil
+}
+
+func (k *kraken2Formatter) Close() error { return nil }
+
+// finishKraken2 places the taxonomy files in the taxonomy directory.
+func finishKraken2(cfg formatConfig) error {
+	return writeTaxonomyFiles(cfg.TaxdumpDir, filepath.Join(cfg.OutDir, "taxonomy"), cfg.Kraken2Symlink)
+}
+
+// taxonomyDmpFiles are the taxdump files kraken2-build reads from the
+// taxonomy directory of a database, and centrifuge-build from its own.
+var taxonomyDmpFiles = []string{"nodes.dmp", "names.dmp"}
+
+// writeTaxonomyFiles places nodes.dmp and names.dmp from taxdumpDir into
+// dir, the layout kraken2-build --build expects for dir "taxonomy", by
+// copying or, with symlink, by absolute symbolic links. The files come from
+// the taxdump rather than the cache, so this runs on cache hits too.
+func writeTaxonomyFiles(taxdumpDir, dir string, symlink bool) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create taxonomy dir: %w", err)
+	}
+	for _, name := range taxonomyDmpFiles {
+		src, dest := filepath.Join(taxdumpDir, name), filepath.Join(dir, name)
+		// A copy from an earlier run may be linked elsewhere; never rewrite it.
+		if err := removeIfExists(dest); err != nil {
+			return err
+		}
+		if !symlink {
+			if err := copyFile(src, dest); err != nil {
+				return fmt.Errorf("taxonomy: %w", err)
+			}
+			continue
+		}
+		abs, err := filepath.Abs(src)
+		if err != nil {
+			return fmt.Errorf("taxonomy: %w", err)
+		}
+		if !fileExists(abs) {
+			return fmt.Errorf("taxonomy: %s does not exist", abs)
+		}
+		if err := os.Symlink(abs, dest); err != nil {
+			return fmt.Errorf("taxonomy: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import "fmt"
+
+// defaultMothurRanks are the levels of a mothur taxonomy unless
+// -mothur-ranks says otherwise.
+var defaultMothurRanks = []string{"kingdom", "phylum", "class", "order", "family", "genus", "species"}
+
+// mothurUnclassified stands in for a missing level under -mothur-pad.
+const mothurUnclassified = "unclassified"
+
+// mothurFormatter writes mothur.fasta and mothur.tax, leaving out the
+// records mothurTaxonomy rejects.
+type mothurFormatter struct {
+	fasta, tax *FormatOutput
+	ranks      []string
+	pad        bool
+	sanitize   nameSanitizer
+	stats      *formatStats
+}
+
+func newMothurFormatter(env *FormatEnv) (ClassifierFormatter, error) {
+	m := &mothurFormatter{ranks: env.cfg.MothurRanks, pad: env.cfg.MothurPad, sanitize: env.cfg.Sanitize, stats: env.stats}
+	var err error
+	if m.fasta, err = env.Create("mothur.fasta"); err != nil {
+		return nil, err
+	}
+	if m.tax, err = env.Create("mothur.tax"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *mothurFormatter) Name() string { return "mothur" }
+
+func (m *mothurFormatter) Format(rec *FormatRecord) error {
+	taxonomy, ok := mothurTaxonomy(rec.lineage, m.ranks, m.pad, m.sanitize)
+	if !ok {
+		m.stats.MothurMissingRanks++
+		return nil
+	}
+	if err := m.fasta.fasta.Write(rec.ID, rec.Seq); err != nil {
+		return err
+	}
+	if _, err := m.tax.w.WriteString(rec.ID + "\t" + taxonomy + "\n"); err != nil {
+		return fmt.Errorf("write mothur taxonomy: %w", err)
+	}
+	return nil
+}
+
+func (m *mothurFormatter) Close() error { return nil }
+
+// mothurTaxonomy returns the mothur .tax taxonomy of lineage, such as
+// "Animalia;Arthropoda;...;Genus_species;": the sanitized name of each of
+// ranks followed by ';', so no level is ever empty (mothur rejects ";;").
+// ok is false when lineage lacks one of ranks, unless pad writes the
+// missing level as "unclassified".
+func mothurTaxonomy(lineage taxLineage, ranks []string, pad bool, sanitize nameSanitizer) (taxonomy string, ok bool) {
+	b := make([]byte, 0, 160)
+	for _, rank := range ranks {
+		name := lineage.get(rank)
+		switch {
+		case name != "":
+			b = sanitize.appendName(b, []byte(name))
+		case pad:
+			b = append(b, mothurUnclassified...)
+		default:
+			return "", false
+		}
+		b = append(b, ';')
+	}
+	return string(b), true
+}
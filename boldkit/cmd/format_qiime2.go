@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// qiime2Ranks are the seven levels of a QIIME 2 taxonomy string.
+var qiime2Ranks = []string{"kingdom", "phylum", "class", "order", "family", "genus", "species"}
+
+// qiime2PrefixStyle chooses the level prefixes of QIIME 2 taxonomy strings.
+type qiime2PrefixStyle string
+
+const (
+	// qiime2Greengenes prefixes levels k__, p__, c__, o__, f__, g__, s__.
+	qiime2Greengenes qiime2PrefixStyle = "greengenes"
+	// qiime2Silva prefixes levels D_0__ through D_6__.
+	qiime2Silva qiime2PrefixStyle = "silva"
+)
+
+var greengenesPrefixes = []string{"k__", "p__", "c__", "o__", "f__", "g__", "s__"}
+
+func parseQiime2PrefixStyle(s string) (qiime2PrefixStyle, error) {
+	switch style := qiime2PrefixStyle(s); style {
+	case qiime2Greengenes, qiime2Silva:
+		return style, nil
+	case "":
+		return qiime2Greengenes, nil
+	default:
+		return "", fmt.Errorf("unknown -qiime2-prefix %q (use greengenes or silva)", s)
+	}
+}
+
+// prefix returns the prefix of level i (0 for kingdom).
+func (s qiime2PrefixStyle) prefix(i int) string {
+	if s == qiime2Silva {
+		return "D_" + strconv.Itoa(i) + "__"
+	}
+	return greengenesPrefixes[i]
+}
+
+// qiime2Formatter writes qiime2_seqs.fasta and the headed
+// qiime2_taxonomy.tsv that qiime tools import reads as
+// FeatureData[Taxonomy].
+type qiime2Formatter struct {
+	fasta, taxonomy *FormatOutput
+	prefix          qiime2PrefixStyle
+	sanitize        nameSanitizer
+}
+
+func newQiime2Formatter(env *FormatEnv) (ClassifierFormatter, error) {
+	q := &qiime2Formatter{prefix: env.cfg.Qiime2Prefix, sanitize: env.cfg.Sanitize}
+	var err error
+	if q.fasta, err = env.Create("qiime2_seqs.fasta"); err != nil {
+		return nil, err
+	}
+	if q.taxonomy, err = env.Create("qiime2_taxonomy.tsv"); err != nil {
+		return nil, err
+	}
+	if _, err := q.taxonomy.w.WriteString("Feature ID\tTaxon\n"); err != nil {
+		return nil, fmt.Errorf("write qiime2 taxonomy: %w", err)
+	}
+	return q, nil
+}
+
+func (q *qiime2Formatter) Name() string { return "qiime2" }
+
+func (q *qiime2Formatter) Format(rec *FormatRecord) error {
+	if err := q.fasta.fasta.Write(rec.ID, rec.Seq); err != nil {
+		return err
+	}
+	if _, err := q.taxonomy.w.WriteString(rec.ID + "\t" + qiime2Taxon(rec.lineage, q.prefix, q.sanitize) + "\n"); err != nil {
+		return fmt.Errorf("write qiime2 taxonomy: %w", err)
+	}
+	return nil
+}
+
+func (q *qiime2Formatter) Close() error { return nil }
+
+// qiime2Taxon returns the QIIME 2 taxonomy string of lineage, such as
+// "k__Animalia; p__Arthropoda; ...; s__Genus_species": every level of
+// qiime2Ranks with its prefix, joined by "; ", a missing rank left as the
+// bare prefix so the string keeps seven levels.
+func qiime2Taxon(lineage taxLineage, style qiime2PrefixStyle, sanitize nameSanitizer) string {
+	b := make([]byte, 0, 160)
+	for i, rank := range qiime2Ranks {
+		if i > 0 {
+			b = append(b, "; "...)
+		}
+		b = append(b, style.prefix(i)...)
+		if name := lineage.get(rank); name != "" {
+			b = sanitize.appendName(b, []byte(name))
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// rdpFormatter writes the RDP classifier training files. The taxonomy tree
+// is complete only after the last record, so records are spooled to a
+// scratch file with their resolved lineage keys, and Close writes
+// rdp_taxonomy.txt and then rdp_train_seqs.fasta from the spool.
+type rdpFormatter struct {
+	train, taxonomy *FormatOutput
+	ranks           []string
+	sanitize        nameSanitizer
+	builder         *rdpTaxonomyBuilder
+	spool           *os.File
+	spoolW          *bufio.Writer
+}
+
+func newRdpFormatter(env *FormatEnv) (ClassifierFormatter, error) {
+	ranks := env.cfg.RdpRanks
+	if len(ranks) == 0 {
+		ranks = env.cfg.RequireRanks
+	}
+	r := &rdpFormatter{ranks: ranks, sanitize: env.cfg.Sanitize, builder: newRdpTaxonomyBuilder(ranks)}
+	var err error
+	if r.train, err = env.Create("rdp_train_seqs.fasta"); err != nil {
+		return nil, err
+	}
+	if r.taxonomy, err = env.Create("rdp_taxonomy.txt"); err != nil {
+		return nil, err
+	}
+	if r.spool, err = env.tempFile("rdp_seqs_*.fasta"); err != nil {
+		return nil, err
+	}
+	r.spoolW = bufio.NewWriterSize(&meteredFile{f: r.spool}, writerBufferSize)
+	return r, nil
+}
+
+func (r *rdpFormatter) Name() string { return "rdp" }
+
+func (r *rdpFormatter) Format(rec *FormatRecord) error {
+	resolved := r.builder.addLineage(rdpLineageNames(rec.lineage, r.ranks, r.sanitize))
+	if len(resolved) == 0 {
+		return nil
+	}
+	// Spool seqid\tlineage_keys\tsequence. The keys ("name|rank") are
+	// joined by the unit separator, which no name holds.
+	if _, err := r.spoolW.WriteString(rec.ID + "\t" + strings.Join(resolved, "\x1f") + "\t" + string(rec.Seq) + "\n"); err != nil {
+		return fmt.Errorf("write temp: %w", err)
+	}
+	return nil
+}
+
+func (r *rdpFormatter) Close() error {
+	if err := r.spoolW.Flush(); err != nil {
+		return fmt.Errorf("rdp format: flush temp: %w", err)
+	}
+	if _, err := r.spool.Seek(0, 0); err != nil {
+		return fmt.Errorf("rdp format: rewind temp: %w", err)
+	}
+	defer func() {
+		_ = r.spool.Close()
+	}()
+
+	if r.builder.disambiguatedCount() > 0 {
+		logf("rdp: disambiguated %d taxonomy names due to parent conflicts", r.builder.disambiguatedCount())
+	}
+	if err := r.builder.writeTaxonomyFile(r.taxonomy.w); err != nil {
+		return fmt.Errorf("rdp format: write taxonomy: %w", err)
+	}
+
+	scanner := bufio.NewScanner(r.spool)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		header := parts[0] + "\t" + r.builder.getLineageString(strings.Split(parts[1], "\x1f"))
+		if err := r.train.fasta.Write(header, []byte(parts[2])); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("rdp format: scan temp: %w", err)
+	}
+	return nil
+}
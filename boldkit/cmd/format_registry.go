@@ -0,0 +1,386 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// FormatRecord is a kept record handed to each classifier formatter, after
+// the taxid and rank checks and with the ID it is written under.
+type FormatRecord struct {
+	ID  string
+	Seq []byte
+	// TaxID is 0 under -no-taxonomy.
+	TaxID int
+
+	lineage taxLineage
+	names   []string // the sanitized names of -require-ranks
+}
+
+// Lineage returns the rank -> name map of the record's taxid, or nil under
+// -no-taxonomy.
+func (r *FormatRecord) Lineage() map[string]string {
+	if r.lineage.dump == nil {
+		return nil
+	}
+	return r.lineage.toMap()
+}
+
+// ClassifierFormatter writes the reference files of one classifier. The
+// driver reads the input once and hands every kept record to each
+// requested formatter; with several, each runs on its own goroutine, so
+// Format must not modify the record or share unsynchronized state with
+// other formatters.
+type ClassifierFormatter interface {
+	Name() string
+	// Format writes one kept record.
+	Format(rec *FormatRecord) error
+	// Close completes the outputs after the last record. The files opened
+	// with FormatEnv.Create are flushed and closed by the driver afterwards.
+	Close() error
+}
+
+// ClassifierFactory builds a formatter for a run, opening its outputs with
+// env.Create.
+type ClassifierFactory func(env *FormatEnv) (ClassifierFormatter, error)
+
+// FormatEnv is what a formatter gets from the run: the options, with the
+// rank lists resolved through -rank-aliases, and its output directory.
+type FormatEnv struct {
+	// cfg.OutDir is the formatter's directory.
+	cfg     formatConfig
+	aliases rankAliases
+	stats   *formatStats
+
+	outputs []*FormatOutput
+	extra   []string   // other outputs, written whole (dada2_report.json)
+	temps   []*os.File // scratch files removed after the run
+}
+
+// Dir returns the directory the formatter writes into.
+func (env *FormatEnv) Dir() string {
+	return env.cfg.OutDir
+}
+
+// Create opens name in the formatter's directory, gzipped when it ends in
+// .gz. FASTA records written with WriteFasta are wrapped at -wrap bases.
+func (env *FormatEnv) Create(name string) (*FormatOutput, error) {
+	path := filepath.Join(env.cfg.OutDir, name)
+	// The old output may be hardlinked into the cache; never rewrite it.
+	if err := removeIfExists(path); err != nil {
+		return nil, err
+	}
+	f, err := createFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	o := &FormatOutput{f: f}
+	if strings.HasSuffix(name, ".gz") {
+		o.gz = gzip.NewWriter(f)
+		o.w = bufio.NewWriterSize(o.gz, writerBufferSize)
+	} else {
+		o.w = bufio.NewWriterSize(f, writerBufferSize)
+	}
+	o.fasta = newFastaWriter(o.w, env.cfg.Wrap)
+	env.outputs = append(env.outputs, o)
+	return o, nil
+}
+
+// tempFile creates a scratch file removed when the run ends.
+func (env *FormatEnv) tempFile(pattern string) (*os.File, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+	env.temps = append(env.temps, f)
+	return f, nil
+}
+
+// paths lists the outputs written.
+func (env *FormatEnv) paths() []string {
+	out := make([]string, 0, len(env.outputs)+len(env.extra))
+	for _, o := range env.outputs {
+		out = append(out, o.f.Name())
+	}
+	return append(out, env.extra...)
+}
+
+// close flushes and closes the outputs and removes the scratch files,
+// returning the first error. It is safe to call more than once.
+func (env *FormatEnv) close() error {
+	var first error
+	for _, o := range env.outputs {
+		if err := o.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	for _, f := range env.temps {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	env.temps = nil
+	return first
+}
+
+// FormatOutput is a buffered output file of a formatter.
+type FormatOutput struct {
+	w     *bufio.Writer
+	gz    *gzip.Writer // between w and f for a .gz output
+	f     *meteredFile
+	fasta fastaWriter
+	done  bool
+}
+
+// WriteFasta writes a FASTA record, wrapping the sequence at -wrap bases.
+func (o *FormatOutput) WriteFasta(header string, seq []byte) error {
+	return o.fasta.Write(header, seq)
+}
+
+// WriteString writes s as is.
+func (o *FormatOutput) WriteString(s string) (int, error) {
+	return o.w.WriteString(s)
+}
+
+// Write writes p as is.
+func (o *FormatOutput) Write(p []byte) (int, error) {
+	return o.w.Write(p)
+}
+
+func (o *FormatOutput) close() error {
+	if o.done {
+		return nil
+	}
+	o.done = true
+	err := o.w.Flush()
+	if o.gz != nil {
+		if cerr := o.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", o.f.Name(), err)
+	}
+	return nil
+}
+
+// formatFanOutBatch is how many records the driver sends each formatter
+// goroutine at a time.
+const formatFanOutBatch = 256
+
+// formatFanOut runs each formatter on its own goroutine, sending every batch
+// of records to all of them. A batch is read-only once sent.
+type formatFanOut struct {
+	formatters []ClassifierFormatter
+	names      []string
+	chans      []chan []*FormatRecord
+	errs       []error // per formatter, read after wg.Wait
+	batch      []*FormatRecord
+	failed     atomic.Bool
+	wg         sync.WaitGroup
+}
+
+func newFormatFanOut(formatters []ClassifierFormatter, names []string) *formatFanOut {
+	f := &formatFanOut{
+		formatters: formatters,
+		names:      names,
+		chans:      make([]chan []*FormatRecord, len(formatters)),
+		errs:       make([]error, len(formatters)),
+		batch:      make([]*FormatRecord, 0, formatFanOutBatch),
+	}
+	for i := range formatters {
+		ch := make(chan []*FormatRecord, 4)
+		f.chans[i] = ch
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			// After an error the batches are drained unformatted, so the
+			// sender never blocks.
+			for batch := range ch {
+				if f.errs[i] != nil {
+					continue
+				}
+				for _, rec := range batch {
+					if err := f.formatters[i].Format(rec); err != nil {
+						f.errs[i] = fmt.Errorf("%s: %w", f.names[i], err)
+						f.failed.Store(true)
+						break
+					}
+				}
+			}
+		}()
+	}
+	return f
+}
+
+// add queues rec for every formatter. It returns false once one has
+// failed; wait then returns the error.
+func (f *formatFanOut) add(rec *FormatRecord) bool {
+	f.batch = append(f.batch, rec)
+	if len(f.batch) == formatFanOutBatch {
+		f.flush()
+	}
+	return !f.failed.Load()
+}
+
+func (f *formatFanOut) flush() {
+	if len(f.batch) == 0 {
+		return
+	}
+	for _, ch := range f.chans {
+		ch <- f.batch
+	}
+	f.batch = make([]*FormatRecord, 0, formatFanOutBatch)
+}
+
+// wait sends the queued records, waits for every formatter to finish them,
+// and returns the first error in classifier order. It is safe to call more
+// than once.
+func (f *formatFanOut) wait() error {
+	if f.chans != nil {
+		f.flush()
+		for _, ch := range f.chans {
+			close(ch)
+		}
+		f.chans = nil
+		f.wg.Wait()
+	}
+	for _, err := range f.errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type classifierEntry struct {
+	name    string
+	factory ClassifierFactory
+	// sequenceOnly classifiers need no taxids or lineages and run under
+	// -no-taxonomy.
+	sequenceOnly bool
+	// finish, when set, runs after the outputs are written or restored from
+	// the cache, with cfg.OutDir the classifier's directory.
+	finish func(cfg formatConfig) error
+}
+
+var (
+	classifierMu       sync.Mutex
+	classifierRegistry []classifierEntry
+)
+
+// RegisterClassifier adds a classifier that format and classify -classifier
+// can name. Names are lowercase and must be unique; the classifier needs
+// taxonomy, so -no-taxonomy rejects it.
+func RegisterClassifier(name string, factory ClassifierFactory) error {
+	return registerClassifier(classifierEntry{name: name, factory: factory})
+}
+
+func registerClassifier(e classifierEntry) error {
+	if e.name == "" || e.name != strings.ToLower(strings.TrimSpace(e.name)) || strings.Contains(e.name, ",") || e.name == "list" {
+		return fmt.Errorf("invalid classifier name %q", e.name)
+	}
+	classifierMu.Lock()
+	defer classifierMu.Unlock()
+	for _, r := range classifierRegistry {
+		if r.name == e.name {
+			return fmt.Errorf("classifier %q already registered", e.name)
+		}
+	}
+	classifierRegistry = append(classifierRegistry, e)
+	return nil
+}
+
+func mustRegisterClassifier(e classifierEntry) {
+	if err := registerClassifier(e); err != nil {
+		panic(err)
+	}
+}
+
+// classifierNames returns the registered classifiers in registration order.
+func classifierNames() []string {
+	classifierMu.Lock()
+	defer classifierMu.Unlock()
+	names := make([]string, len(classifierRegistry))
+	for i, e := range classifierRegistry {
+		names[i] = e.name
+	}
+	return names
+}
+
+// lookupClassifiers returns the entries of names, which are matched without
+// case; empty and repeated names are skipped.
+func lookupClassifiers(names []string) ([]classifierEntry, error) {
+	classifierMu.Lock()
+	byName := make(map[string]classifierEntry, len(classifierRegistry))
+	for _, e := range classifierRegistry {
+		byName[e.name] = e
+	}
+	classifierMu.Unlock()
+
+	var out []classifierEntry
+	used := make(map[string]bool, len(names))
+	for _, n := range names {
+		name := strings.ToLower(strings.TrimSpace(n))
+		if name == "" || used[name] {
+			continue
+		}
+		e, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown classifier %q (available: %s)", n, strings.Join(classifierNames(), ","))
+		}
+		used[name] = true
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// sequenceOnlyClassifiers returns the classifiers that run under
+// -no-taxonomy.
+func sequenceOnlyClassifiers() []string {
+	classifierMu.Lock()
+	defer classifierMu.Unlock()
+	var names []string
+	for _, e := range classifierRegistry {
+		if e.sequenceOnly {
+			names = append(names, e.name)
+		}
+	}
+	return names
+}
+
+// printClassifiers lists the registered classifiers for -classifier list,
+// marking those that run under -no-taxonomy.
+func printClassifiers() {
+	classifierMu.Lock()
+	defer classifierMu.Unlock()
+	for _, e := range classifierRegistry {
+		if e.sequenceOnly {
+			fmt.Println(e.name + "\tsequence-only")
+		} else {
+			fmt.Println(e.name)
+		}
+	}
+}
+
+func init() {
+	mustRegisterClassifier(classifierEntry{name: "blast", factory: newBlastFormatter, sequenceOnly: true, finish: finishBlast})
+	mustRegisterClassifier(classifierEntry{name: "kraken2", factory: newKraken2Formatter, finish: finishKraken2})
+	mustRegisterClassifier(classifierEntry{name: "sintax", factory: newSintaxFormatter})
+	mustRegisterClassifier(classifierEntry{name: "rdp", factory: newRdpFormatter})
+	mustRegisterClassifier(classifierEntry{name: "idtaxa", factory: newIdtaxaFormatter})
+	mustRegisterClassifier(classifierEntry{name: "protax", factory: newProtaxFormatter})
+	mustRegisterClassifier(classifierEntry{name: "mothur", factory: newMothurFormatter})
+	mustRegisterClassifier(classifierEntry{name: "centrifuge", factory: newCentrifugeFormatter, finish: writeCentrifugeTaxonomy})
+	mustRegisterClassifier(classifierEntry{name: "sourmash", factory: newSourmashFormatter})
+	mustRegisterClassifier(classifierEntry{name: "dada2", factory: newDada2Formatter})
+	mustRegisterClassifier(classifierEntry{name: "qiime2", factory: newQiime2Formatter})
+}
@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// speciesFormatter is a third-party style formatter writing each record's
+// species.
+type speciesFormatter struct {
+	out    *FormatOutput
+	closed bool
+}
+
+func (s *speciesFormatter) Name() string { return "species_list" }
+
+func (s *speciesFormatter) Format(rec *FormatRecord) error {
+	_, err := s.out.WriteString(rec.ID + "\t" + rec.Lineage()["species"] + "\n")
+	return err
+}
+
+func (s *speciesFormatter) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestRegisterClassifier(t *testing.T) {
+	classifierMu.Lock()
+	saved := append([]classifierEntry(nil), classifierRegistry...)
+	classifierMu.Unlock()
+	t.Cleanup(func() {
+		classifierMu.Lock()
+		classifierRegistry = saved
+		classifierMu.Unlock()
+	})
+
+	var formatter *speciesFormatter
+	err := RegisterClassifier("species_list", func(env *FormatEnv) (ClassifierFormatter, error) {
+		out, err := env.Create("species.tsv")
+		if err != nil {
+			return nil, err
+		}
+		formatter = &speciesFormatter{out: out}
+		return formatter, nil
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	for _, name := range []string{"species_list", "blast", "Upper", "", "list", "a,b"} {
+		if err := RegisterClassifier(name, nil); err == nil {
+			t.Fatalf("registered %q", name)
+		}
+	}
+	if names := classifierNames(); names[len(names)-1] != "species_list" {
+		t.Fatalf("names = %v", names)
+	}
+
+	dir := filepath.Join("testdata", "format")
+	outDir := t.TempDir()
+	_, outputs, err := runFormatFasta(context.Background(), formatConfig{
+		Classifiers:  []string{"blast", "Species_List"},
+		RequireRanks: []string{"species"},
+		Input:        filepath.Join(dir, "input.fasta"),
+		OutDir:       outDir,
+		TaxdumpDir:   dir,
+		Sanitize:     sanitizeTranslit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !formatter.closed {
+		t.Fatal("formatter not closed")
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "species.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(got, []byte("P1\tDanaus plexippus\n")) || bytes.Count(got, []byte("\n")) != 4 {
+		t.Fatalf("species.tsv:\n%s", got)
+	}
+	if len(outputs) != 3 || filepath.Base(outputs[2]) != "species.tsv" {
+		t.Fatalf("outputs = %v", outputs)
+	}
+	if err := checkNoTaxonomyClassifiers([]string{"species_list"}); err == nil {
+		t.Fatal("custom classifier accepted under -no-taxonomy")
+	}
+}
+
+// failingFormatter fails on its second record.
+type failingFormatter struct{ n int }
+
+func (f *failingFormatter) Name() string { return "failing" }
+
+func (f *failingFormatter) Format(rec *FormatRecord) error {
+	if f.n++; f.n == 2 {
+		return errors.New("disk full")
+	}
+	return nil
+}
+
+func (f *failingFormatter) Close() error { return nil }
+
+func TestFormatFanOutError(t *testing.T) {
+	classifierMu.Lock()
+	saved := append([]classifierEntry(nil), classifierRegistry...)
+	classifierMu.Unlock()
+	t.Cleanup(func() {
+		classifierMu.Lock()
+		classifierRegistry = saved
+		classifierMu.Unlock()
+	})
+	if err := RegisterClassifier("failing", func(env *FormatEnv) (ClassifierFormatter, error) {
+		return &failingFormatter{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join("testdata", "format")
+	_, _, err := runFormatFasta(context.Background(), formatConfig{
+		Classifiers:  []string{"blast", "failing", "sintax"},
+		RequireRanks: []string{"species"},
+		Input:        filepath.Join(dir, "input.fasta"),
+		OutDir:       t.TempDir(),
+		TaxdumpDir:   dir,
+		Sanitize:     sanitizeTranslit,
+	})
+	if err == nil || err.Error() != "failing: disk full" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestLookupClassifiersUnknown(t *testing.T) {
+	_, err := lookupClassifiers([]string{"blast", "dnasketch"})
+	if err == nil || !strings.Contains(err.Error(), `unknown classifier "dnasketch" (available: blast,kraken2,`) {
+		t.Fatalf("err = %v", err)
+	}
+	entries, err := lookupClassifiers([]string{" BLAST", "", "blast", "rdp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].name != "blast" || entries[1].name != "rdp" {
+		t.Fatalf("entries = %+v", entries)
+	}
+}
+
+// TestFormatFanOut checks that one pass over every classifier, each in its
+// own directory, writes what separate runs write, and that the combined
+// cache entry restores into the classifier directories.
+func TestFormatFanOut(t *testing.T) {
+	dir := filepath.Join("testdata", "format")
+	base := formatConfig{
+		RequireRanks: []string{"species"},
+		RdpRanks:     []string{"kingdom", "phylum", "class", "order", "family", "genus", "species"},
+		Input:        filepath.Join(dir, "input.fasta"),
+		TaxdumpDir:   dir,
+		Sanitize:     sanitizeTranslit,
+		BlastIDs:     blastIDsKeep,
+	}
+	names := classifierNames()
+	var want formatStats
+	separate := t.TempDir()
+	for _, name := range names {
+		cfg := base
+		cfg.Classifiers = []string{name}
+		cfg.OutDir = filepath.Join(separate, name)
+		stats, _, err := runFormatFasta(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Total, want.Written = stats.Total, stats.Written
+		want.SintaxNoKingdom += stats.SintaxNoKingdom
+		want.MothurMissingRanks += stats.MothurMissingRanks
+	}
+
+	cache, err := openArtifactCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range []string{"miss", "hit"} {
+		cfg := base
+		cfg.Classifiers = names
+		cfg.ClassifierDirs = true
+		cfg.OutDir = t.TempDir()
+		cfg.Cache = cache
+		if err := formatFasta(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			files, err := os.ReadDir(filepath.Join(separate, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				if f.IsDir() {
+					continue
+				}
+				wantData, err := os.ReadFile(filepath.Join(separate, name, f.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := os.ReadFile(filepath.Join(cfg.OutDir, name, f.Name()))
+				if err != nil {
+					t.Fatalf("%s: %v", run, err)
+				}
+				if !bytes.Equal(got, wantData) {
+					t.Fatalf("%s: %s/%s differs from a separate run", run, name, f.Name())
+				}
+			}
+		}
+		if !fileExists(filepath.Join(cfg.OutDir, "kraken2", "taxonomy", "nodes.dmp")) {
+			t.Fatalf("%s: kraken2 taxonomy not in its directory", run)
+		}
+	}
+
+	stats, _, err := runFormatFasta(context.Background(), formatConfig{
+		Classifiers:  names,
+		RequireRanks: base.RequireRanks,
+		RdpRanks:     base.RdpRanks,
+		Input:        base.Input,
+		OutDir:       t.TempDir(),
+		TaxdumpDir:   dir,
+		Sanitize:     sanitizeTranslit,
+		BlastIDs:     blastIDsKeep,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+// BenchmarkFormatClassifiers formats four classifiers from a 1M-record
+// input, once per classifier as classify did and in one shared pass.
+func BenchmarkFormatClassifiers(b *testing.B) {
+	records := 1_000_000
+	if testing.Short() {
+		records = 100_000
+	}
+	tmp := b.TempDir()
+	fixture := filepath.Join("testdata", "format")
+	for _, name := range taxonomyDmpFiles {
+		if err := copyFile(filepath.Join(fixture, name), filepath.Join(tmp, name)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	input := filepath.Join(tmp, "input.fasta")
+	fasta, err := os.Create(input)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var taxids bytes.Buffer
+	rng := rand.New(rand.NewSource(1))
+	seq := make([]byte, 200)
+	for i := range records {
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+		}
+		id := fmt.Sprintf("R%07d", i)
+		if _, err := fmt.Fprintf(fasta, ">%s\n%s\n", id, seq); err != nil {
+			b.Fatal(err)
+		}
+		fmt.Fprintf(&taxids, "%s\t%d\n", id, []int{8, 9, 11, 13}[i%4])
+	}
+	if err := fasta.Close(); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "taxid.map"), taxids.Bytes(), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	classifiers := []string{"blast", "kraken2", "sintax", "qiime2"}
+	base := formatConfig{
+		RequireRanks: []string{"species"},
+		Input:        input,
+		TaxdumpDir:   tmp,
+		Sanitize:     sanitizeTranslit,
+		BlastIDs:     blastIDsKeep,
+	}
+	b.Run("per-classifier", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, name := range classifiers {
+				cfg := base
+				cfg.Classifiers = []string{name}
+				cfg.OutDir = filepath.Join(b.TempDir(), name)
+				if _, _, err := runFormatFasta(context.Background(), cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(records*b.N)/b.Elapsed().Seconds(), "records/s")
+	})
+	b.Run("single-pass", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cfg := base
+			cfg.Classifiers = classifiers
+			cfg.ClassifierDirs = true
+			cfg.OutDir = b.TempDir()
+			if _, _, err := runFormatFasta(context.Background(), cfg); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(records*b.N)/b.Elapsed().Seconds(), "records/s")
+	})
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sourmashColumns are the rank columns of a sourmash tax lineages CSV,
+// after ident.
+var sourmashColumns = []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species"}
+
+// sourmashFormatter writes sourmash_lineages.csv, the lineages sourmash
+// tax reads with --taxonomy, and unless -sourmash-csv-only the matching
+// sourmash.fasta to sketch. ranks holds the lineage rank of each column:
+// the rank aliases read the superkingdom column from the kingdom.
+type sourmashFormatter struct {
+	fasta, csv *FormatOutput
+	ranks      []string
+	buf        []byte
+}
+
+func newSourmashFormatter(env *FormatEnv) (ClassifierFormatter, error) {
+	s := &sourmashFormatter{ranks: make([]string, len(sourmashColumns))}
+	for i, col := range sourmashColumns {
+		s.ranks[i] = env.aliases.canonical(col)
+	}
+	var err error
+	if !env.cfg.SourmashCSVOnly {
+		if s.fasta, err = env.Create("sourmash.fasta"); err != nil {
+			return nil, err
+		}
+	}
+	if s.csv, err = env.Create("sourmash_lineages.csv"); err != nil {
+		return nil, err
+	}
+	if _, err := s.csv.w.WriteString("ident," + strings.Join(sourmashColumns, ",") + "\n"); err != nil {
+		return nil, fmt.Errorf("write sourmash lineages: %w", err)
+	}
+	return s, nil
+}
+
+func (s *sourmashFormatter) Name() string { return "sourmash" }
+
+// Format adds a row for the record, leaving the cells of missing ranks
+// empty. Names are written as the taxdump has them, quoted where CSV needs
+// it.
+func (s *sourmashFormatter) Format(rec *FormatRecord) error {
+	if s.fasta != nil {
+		if err := s.fasta.fasta.Write(rec.ID, rec.Seq); err != nil {
+			return err
+		}
+	}
+	b := appendCSVField(s.buf[:0], rec.ID)
+	for _, rank := range s.ranks {
+		b = append(b, ',')
+		b = appendCSVField(b, rec.lineage.get(rank))
+	}
+	b = append(b, '\n')
+	s.buf = b
+	if _, err := s.csv.w.Write(b); err != nil {
+		return fmt.Errorf("write sourmash lineages: %w", err)
+	}
+	return nil
+}
+
+func (s *sourmashFormatter) Close() error { return nil }
+
+// appendCSVField appends field to dst as an RFC 4180 field: quoted, with
+// quotes doubled, when it holds a comma, quote, or line break or has
+// leading or trailing spaces.
+func appendCSVField(dst []byte, field string) []byte {
+	if field == "" || (!strings.ContainsAny(field, ",\"\r\n") && field[0] != ' ' && field[len(field)-1] != ' ') {
+		return append(dst, field...)
+	}
+	dst = append(dst, '"')
+	for i := 0; i < len(field); i++ {
+		if field[i] == '"' {
+			dst = append(dst, '"')
+		}
+		dst = append(dst, field[i])
+	}
+	return append(dst, '"')
+}
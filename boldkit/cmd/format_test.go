@@ -0,0 +1,998 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestClassifyNoTaxonomy(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "COI-5P.fasta")
+	if err := os.WriteFile(input, []byte(">P1\nACGTACGTAC\n>P2\nACGTACGTAC\n>P3\nCCGGTTAACC\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// No taxdump exists: nothing may try to load it.
+	missing := filepath.Join(tmp, "no-taxdump")
+	outDir := filepath.Join(tmp, "out")
+	err := classifyOne(context.Background(), input, outDir, []string{"blast"}, nil, missing, "", "", "", 1, 100, 0, 0, 0, true, true, false, false, false, false, false, false, false, true, sanitizeTranslit, "COI-5P", "", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "blast", "blast.fasta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ">P1\nACGTACGTAC\n>P3\nCCGGTTAACC\n"; string(got) != want {
+		t.Fatalf("blast.fasta = %q, want %q", got, want)
+	}
+	if fileExists(filepath.Join(outDir, "blast", "blast_seqid2taxid.map")) {
+		t.Fatal("seqid2taxid map written without taxonomy")
+	}
+
+	report := filepath.Join(tmp, "format.json")
+	cfg := formatConfig{Classifiers: []string{"blast"}, Input: input, OutDir: filepath.Join(tmp, "format"), TaxdumpDir: missing, ReportPath: report, NoTaxonomy: true}
+	if err := formatFasta(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"missing_taxid", "missing_ranks"} {
+		if v, ok := fields[name]; !ok || v != nil {
+			t.Fatalf("%s = %v (present %v), want null", name, v, ok)
+		}
+	}
+	if fields["no_taxonomy"] != true || fields["written"] != float64(3) {
+		t.Fatalf("report %s", data)
+	}
+}
+
+func TestClassifyQCGzip(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "COI-5P.fasta")
+	if err := os.WriteFile(input, []byte(">P1\nACGTACGTAC\n>P2\nACGTACGTAC\n>P3\nCCGGTTAACC\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(tmp, "out")
+	err := classifyOne(context.Background(), input, outDir, []string{"blast"}, nil, filepath.Join(tmp, "no-taxdump"), "", "", "", 1, 100, 0, 0, 0, true, true, false, false, false, false, true, false, false, true, sanitizeTranslit, "COI-5P", "", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Join(outDir, "qc", "COI-5P.fasta")) || !fileExists(filepath.Join(outDir, "qc", "COI-5P.fasta.gz")) {
+		t.Fatal("qc output not written as qc/COI-5P.fasta.gz")
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "blast", "blast.fasta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ">P1\nACGTACGTAC\n>P3\nCCGGTTAACC\n"; string(got) != want {
+		t.Fatalf("blast.fasta = %q, want %q", got, want)
+	}
+}
+
+func TestNoTaxonomyRejectsLineageClassifiers(t *testing.T) {
+	tmp := t.TempDir()
+	for _, classifier := range []string{"sintax", "rdp", "kraken2"} {
+		outDir := filepath.Join(tmp, classifier)
+		cfg := formatConfig{Classifiers: []string{"blast", classifier}, Input: filepath.Join(tmp, "absent.fasta"), OutDir: outDir, NoTaxonomy: true}
+		err := formatFasta(context.Background(), cfg)
+		if err == nil || !strings.Contains(err.Error(), "classifier "+classifier+" needs taxids or lineages") {
+			t.Fatalf("%s: err=%v", classifier, err)
+		}
+		// Rejected before opening the input or creating outputs.
+		if fileExists(outDir) {
+			t.Fatalf("%s: output dir created", classifier)
+		}
+	}
+	if err := checkNoTaxonomyClassifiers([]string{"BLAST"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckNoTaxonomyFlags(t *testing.T) {
+	fs := flag.NewFlagSet("qc", flag.ContinueOnError)
+	fs.String("require-ranks", "species", "")
+	fs.String("taxid-map", "", "")
+	fs.Bool("no-taxonomy", false, "")
+	if err := fs.Parse([]string{"-no-taxonomy"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNoTaxonomyFlags(fs); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"-no-taxonomy", "-require-ranks", "genus"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNoTaxonomyFlags(fs); err == nil || !strings.Contains(err.Error(), "-require-ranks") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+// TestFormatSintaxGolden checks the SINTAX headers of taxa with commas and
+// parentheses in their names and with ranks missing from their lineages,
+// plain and gzipped; the taxon without a kingdom is left out.
+func TestFormatSintaxGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "format")
+	golden := filepath.Join(dir, "sintax.fasta")
+	for _, gz := range []bool{false, true} {
+		outDir := t.TempDir()
+		stats, _, err := runFormatFasta(context.Background(), formatConfig{
+			Classifiers:  []string{"sintax"},
+			RequireRanks: []string{"species"},
+			Input:        filepath.Join(dir, "input.fasta"),
+			OutDir:       outDir,
+			TaxdumpDir:   dir,
+			Sanitize:     sanitizeTranslit,
+			SintaxGzip:   gz,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Written != 4 || stats.SintaxNoKingdom != 1 {
+			t.Fatalf("gzip=%v: %+v", gz, stats)
+		}
+		name := "sintax.fasta"
+		if gz {
+			name += ".gz"
+		}
+		in, err := openInput(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(in)
+		_ = in.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *updateGolden && !gz {
+			if err := os.WriteFile(golden, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("gzip=%v: sintax.fasta\n got: %s\nwant: %s", gz, got, want)
+		}
+	}
+}
+
+func TestFormatDada2(t *testing.T) {
+	dir := t.TempDir()
+	writeDmps(t, dir, []string{
+		"1|1|no rank|root",
+		"2|1|kingdom|Animalia",
+		"3|2|phylum|Arthropoda",
+		"4|3|class|Insecta",
+		"5|4|order|Lepidoptera",
+		"6|5|family|Nymphalidae",
+		"7|6|genus|Danaus",
+		"8|7|species|Danaus plexippus",
+		"9|3|genus|Aus", // no class, order, or family
+		"10|9|species|Aus bus",
+		"11|1|phylum|Orphanophyta", // no kingdom
+		"12|11|species|Orphan one",
+	})
+	files := map[string]string{
+		"taxid.map": "P1\t8\nP2\t8\nP3\t8\nP4\t10\nP5\t12\nP6\t3\n",
+		// P2 repeats P1, and P6 the truncated lineage and sequence of P4.
+		"in.fasta": ">P1\nACGTACGTAC\n>P2\nACGTACGTAC\n>P3\nACGTACGTAA\n>P4\nACGTACGTAG\n>P5\nACGTACGTAT\n>P6\nACGTACGTAG\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outDir := filepath.Join(dir, "out")
+	stats, outputs, err := runFormatFasta(context.Background(), formatConfig{
+		Classifiers:  []string{"dada2"},
+		RequireRanks: []string{"phylum"},
+		Input:        filepath.Join(dir, "in.fasta"),
+		OutDir:       outDir,
+		TaxdumpDir:   dir,
+		Sanitize:     sanitizeTranslit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 6 || len(outputs) != 3 {
+		t.Fatalf("stats %+v, outputs %v", stats, outputs)
+	}
+	want := map[string]string{
+		"dada2_train.fasta": ">Animalia;Arthropoda;Insecta;Lepidoptera;Nymphalidae;Danaus;\nACGTACGTAC\n" +
+			">Animalia;Arthropoda;Insecta;Lepidoptera;Nymphalidae;Danaus;\nACGTACGTAA\n" +
+			">Animalia;Arthropoda;\nACGTACGTAG\n",
+		"dada2_species.fasta": ">P1 Danaus plexippus\nACGTACGTAC\n>P3 Danaus plexippus\nACGTACGTAA\n" +
+			">P4 Aus bus\nACGTACGTAG\n>P5 Orphan one\nACGTACGTAT\n",
+	}
+	for name, body := range want {
+		got, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != body {
+			t.Errorf("%s:\n%s\nwant:\n%s", name, got, body)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, dada2ReportName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report dada2Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	wantStats := dada2Stats{TrainWritten: 3, Truncated: 2, NoKingdom: 1, Collapsed: 2, SpeciesWritten: 4, NoSpecies: 1, SpeciesCollapsed: 1}
+	if report.dada2Stats != wantStats {
+		t.Fatalf("report %+v, want %+v", report.dada2Stats, wantStats)
+	}
+}
+
+// TestFormatQiime2Golden checks the QIIME 2 taxonomy TSV byte for byte in
+// both prefix styles: the header, tabs, "; " separators, and the bare
+// prefixes of missing ranks.
+func TestFormatQiime2Golden(t *testing.T) {
+	dir := filepath.Join("testdata", "format")
+	for _, style := range []qiime2PrefixStyle{qiime2Greengenes, qiime2Silva} {
+		outDir := t.TempDir()
+		stats, _, err := runFormatFasta(context.Background(), formatConfig{
+			Classifiers:  []string{"qiime2"},
+			RequireRanks: []string{"species"},
+			Input:        filepath.Join(dir, "input.fasta"),
+			OutDir:       outDir,
+			TaxdumpDir:   dir,
+			Sanitize:     sanitizeTranslit,
+			Qiime2Prefix: style,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Written != 4 {
+			t.Fatalf("%s: %+v", style, stats)
+		}
+		for name, golden := range map[string]string{
+			"qiime2_taxonomy.tsv": "qiime2_taxonomy." + string(style) + ".tsv",
+			"qiime2_seqs.fasta":   "qiime2_seqs.fasta",
+		} {
+			got, err := os.ReadFile(filepath.Join(outDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, golden)
+			if *updateGolden {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s %s\n got: %q\nwant: %q", style, name, got, want)
+			}
+		}
+	}
+	if _, err := parseQiime2PrefixStyle("gtdb"); err == nil {
+		t.Fatal("unknown prefix style accepted")
+	}
+}
+
+// TestFormatMothurGolden checks the mothur taxonomy byte for byte: one
+// ';' after every level, never two in a row, with records lacking a level
+// dropped or, under -mothur-pad, padded with "unclassified".
+// TestFormatBlastGolden pins the blast outputs byte for byte, wrapped and
+// unwrapped.
+func TestFormatBlastGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "format")
+	for _, wrap := range []int{0, 4} {
+		outDir := t.TempDir()
+		stats, _, err := runFormatFasta(context.Background(), formatConfig{
+			Classifiers:  []string{"blast"},
+			RequireRanks: []string{"species"},
+			Input:        filepath.Join(dir, "input.fasta"),
+			OutDir:       outDir,
+			TaxdumpDir:   dir,
+			Sanitize:     sanitizeTranslit,
+			Wrap:         wrap,
+			BlastIDs:     blastIDsKeep,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Written != 4 {
+			t.Fatalf("wrap=%d: %+v", wrap, stats)
+		}
+		goldens := map[string]string{"blast.fasta": "blast.fasta", "blast_seqid2taxid.map": "blast_seqid2taxid.map"}
+		if wrap == 0 {
+			goldens["blast.fasta"] = "input.fasta"
+		}
+		for name, golden := range goldens {
+			got, err := os.ReadFile(filepath.Join(outDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, golden)
+			if *updateGolden && golden != "input.fasta" {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("wrap=%d %s\n got: %q\nwant: %q", wrap, name, got, want)
+			}
+		}
+	}
+}
+
+func TestFormatMothurGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "format")
+	for _, pad := range []bool{false, true} {
+		outDir := t.TempDir()
+		stats, _, err := runFormatFasta(context.Background(), formatConfig{
+			Classifiers:  []string{"mothur"},
+			RequireRanks: []string{"species"},
+			Input:        filepath.Join(dir, "input.fasta"),
+			OutDir:       outDir,
+			TaxdumpDir:   dir,
+			Sanitize:     sanitizeTranslit,
+			MothurPad:    pad,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		goldens := map[string]string{"mothur.tax": "mothur.tax", "mothur.fasta": "mothur.fasta"}
+		if pad {
+			goldens = map[string]string{"mothur.tax": "mothur.pad.tax", "mothur.fasta": "input.fasta"}
+			if stats.Written != 4 || stats.MothurMissingRanks != 0 {
+				t.Fatalf("pad: %+v", stats)
+			}
+		} else if stats.Written != 4 || stats.MothurMissingRanks != 2 {
+			t.Fatalf("%+v", stats)
+		}
+		for name, golden := range goldens {
+			got, err := os.ReadFile(filepath.Join(outDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(got, []byte(";;")) {
+				t.Fatalf("pad=%v %s has an empty level:\n%s", pad, name, got)
+			}
+			path := filepath.Join(dir, golden)
+			if *updateGolden && golden != "input.fasta" {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("pad=%v %s\n got: %q\nwant: %q", pad, name, got, want)
+			}
+		}
+	}
+}
+
+func TestIdtaxaTaxonomyGaps(t *testing.T) {
+	ranks := []string{"kingdom", "phylum", "class", "order", "family", "genus", "species"}
+	cases := []struct {
+		lineage map[string]string
+		want    string
+	}{
+		{
+			map[string]string{"kingdom": "Animalia", "phylum": "Arthropoda", "class": "Insecta", "order": "Lepidoptera", "family": "Nymphalidae", "genus": "Danaus", "species": "Danaus plexippus"},
+			"Root;Animalia;Arthropoda;Insecta;Lepidoptera;Nymphalidae;Danaus;Danaus_plexippus",
+		},
+		{
+			map[string]string{"kingdom": "Animalia", "phylum": "Arthropoda", "genus": "Aus", "species": "Aus bus"},
+			"Root;Animalia;Arthropoda;unclassified_Arthropoda;unclassified_Arthropoda;unclassified_Arthropoda;Aus;Aus_bus",
+		},
+		{
+			map[string]string{"phylum": "Orphanophyta", "family": "Orphanidae"},
+			"Root;unclassified_Root;Orphanophyta;unclassified_Orphanophyta;unclassified_Orphanophyta;Orphanidae;unclassified_Orphanidae;unclassified_Orphanidae",
+		},
+		{map[string]string{}, "Root;unclassified_Root;unclassified_Root;unclassified_Root;unclassified_Root;unclassified_Root;unclassified_Root;unclassified_Root"},
+	}
+	for _, tc := range cases {
+		got := idtaxaTaxonomy(lineageFromMap(tc.lineage), ranks, sanitizeTranslit)
+		if got != tc.want {
+			t.Errorf("%v:\n got %s\nwant %s", tc.lineage, got, tc.want)
+		}
+		if n := strings.Count(got, ";"); n != len(ranks) {
+			t.Errorf("%s has %d levels below Root, want %d", got, n, len(ranks))
+		}
+	}
+
+	var b strings.Builder
+	if err := writeIdtaxaRanks(&b, []string{"kingdom", "species"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "0\trootrank\n1\tkingdom\n2\tspecies\n"; b.String() != want {
+		t.Fatalf("ranks file %q, want %q", b.String(), want)
+	}
+}
+
+// TestFormatIdtaxaGaps checks -idtaxa-ranks beyond -require-ranks: kept
+// records lacking a level get unclassified_ fills in idtaxa_lineage.tsv.
+func TestFormatIdtaxaGaps(t *testing.T) {
+	dir := filepath.Join("testdata", "format")
+	outDir := t.TempDir()
+	ranks := []string{"kingdom", "phylum", "class", "order", "family", "genus", "species"}
+	stats, outputs, err := runFormatFasta(context.Background(), formatConfig{
+		Classifiers:    []string{"idtaxa"},
+		RequireRanks:   []string{"species"},
+		IdtaxaRanks:    ranks,
+		IdtaxaRankFile: true,
+		Input:          filepath.Join(dir, "input.fasta"),
+		OutDir:         outDir,
+		TaxdumpDir:     dir,
+		Sanitize:       sanitizeTranslit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 4 || len(outputs) != 3 {
+		t.Fatalf("stats %+v, outputs %v", stats, outputs)
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "idtaxa_lineage.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "P1\tRoot;Animalia;Arthropoda;Insecta;Lepidoptera;Nymphalidae;Danaus;Danaus_plexippus\n" +
+		"P2\tRoot;Animalia;Arthropoda;Insecta;Lepidoptera;Nymphalidae;Danaus;Danaus_sp._BOLD_AAA1234_\n" +
+		"P3\tRoot;Animalia;Arthropoda;unclassified_Arthropoda;unclassified_Arthropoda;unclassified_Arthropoda;Aus_Bus;Aus_bus_Linnaeus_1758_\n" +
+		"P4\tRoot;unclassified_Root;Orphanophyta;unclassified_Orphanophyta;unclassified_Orphanophyta;unclassified_Orphanophyta;unclassified_Orphanophyta;Orphan_one\n"
+	if string(got) != want {
+		t.Fatalf("idtaxa_lineage.tsv:\n%s\nwant:\n%s", got, want)
+	}
+	got, err = os.ReadFile(filepath.Join(outDir, "idtaxa_ranks.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(got), "0\trootrank\n1\tkingdom\n") || strings.Count(string(got), "\n") != len(ranks)+1 {
+		t.Fatalf("idtaxa_ranks.tsv:\n%s", got)
+	}
+}
+
+func TestBlastIDChecker(t *testing.T) {
+	long := strings.Repeat("A", blastMaxIDLen+1)
+	fail := &blastIDChecker{policy: blastIDsFail}
+	for _, id := range []string{"ABC123-19.COI-5P", "gb|X1|", "caf\u00e9", long} {
+		if got := fail.check(id); got != id {
+			t.Errorf("fail policy changed %q to %q", id, got)
+		}
+	}
+	if err := fail.err(); err == nil || !strings.Contains(err.Error(), "3 blast IDs") || !strings.Contains(err.Error(), `"gb|X1|"`) {
+		t.Fatalf("err=%v", err)
+	}
+
+	sanitize := &blastIDChecker{policy: blastIDsSanitize}
+	if got := sanitize.check("gb|X1|"); got != "gb_X1_" {
+		t.Errorf("sanitized %q", got)
+	}
+	if got := sanitize.check("caf\u00e9"); got != "caf__" {
+		t.Errorf("sanitized %q", got)
+	}
+	if sanitize.err() != nil || sanitize.sanitized != 2 {
+		t.Fatalf("sanitized=%d err=%v", sanitize.sanitized, sanitize.err())
+	}
+	sanitize.check(long)
+	if sanitize.err() == nil {
+		t.Fatal("over-long ID accepted")
+	}
+
+	keep := &blastIDChecker{policy: blastIDsKeep}
+	keep.check("gb|X1|")
+	keep.check(long)
+	if keep.err() != nil {
+		t.Fatal(keep.err())
+	}
+}
+
+// TestFormatBlastIDs checks that rejected IDs fail the run and that
+// sanitized ones match between blast.fasta and its taxid map, which holds
+// only the sequences written.
+func TestFormatBlastIDs(t *testing.T) {
+	dir := t.TempDir()
+	writeDmps(t, dir, []string{
+		"1|1|no rank|root",
+		"2|1|kingdom|Animalia",
+		"3|2|species|Aus bus",
+	})
+	files := map[string]string{
+		"taxid.map": "P1\t3\nP|2\t3\nP3\t2\n",
+		"in.fasta":  ">P1\nACGTACGTAC\n>P|2\nACGTACGTAA\n>P3\nACGTACGTAG\n>P4\nACGTACGTAT\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := formatConfig{
+		Classifiers:  []string{"blast"},
+		RequireRanks: []string{"species"},
+		Input:        filepath.Join(dir, "in.fasta"),
+		OutDir:       filepath.Join(dir, "out"),
+		TaxdumpDir:   dir,
+		Sanitize:     sanitizeTranslit,
+	}
+	if _, _, err := runFormatFasta(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), `"P|2"`) {
+		t.Fatalf("err=%v", err)
+	}
+	cfg.BlastIDs = blastIDsSanitize
+	stats, _, err := runFormatFasta(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 2 || stats.BlastIDsSanitized != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	for name, want := range map[string]string{
+		"blast.fasta":           ">P1\nACGTACGTAC\n>P_2\nACGTACGTAA\n",
+		"blast_seqid2taxid.map": "P1\t3\nP_2\t3\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(cfg.OutDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRunMakeblastdb(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script on PATH")
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"makeblastdb $*\" >&2\n"
+	if err := os.WriteFile(filepath.Join(bin, "makeblastdb"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	outDir := t.TempDir()
+	if err := runMakeblastdb(outDir, true, true); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, makeblastdbLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "makeblastdb -in blast.fasta -dbtype nucl -out blast -parse_seqids -taxid_map blast_seqid2taxid.map\n"
+	if string(got) != want {
+		t.Fatalf("log %q, want %q", got, want)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if err := runMakeblastdb(outDir, true, true); err != nil {
+		t.Fatalf("missing makeblastdb: %v", err)
+	}
+}
+
+// TestFormatCentrifugeLayout checks the centrifuge-build inputs with bold
+// taxids and remapped to NCBI taxids, where the sequence whose taxid has
+// no NCBI match is left out and listed.
+func TestFormatCentrifugeLayout(t *testing.T) {
+	dir := t.TempDir()
+	writeDmps(t, dir, []string{
+		"1|1|no rank|root",
+		"2|1|kingdom|Animalia",
+		"3|2|species|Aus bus",
+		"4|2|species|Cus dus",
+	})
+	ncbiDir := filepath.Join(dir, "ncbi")
+	if err := os.MkdirAll(ncbiDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeDmps(t, ncbiDir, []string{
+		"1|1|no rank|root",
+		"33208|1|kingdom|Metazoa",
+		"50557|33208|species|Aus bus",
+	})
+	files := map[string]string{
+		"taxid.map": "P1\t3\nP2\t4\n",
+		"in.fasta":  ">P1\nACGTACGTAC\n>P2\nACGTACGTAA\n>P3\nACGTACGTAG\n",
+		"ncbi_map.tsv": "bold_taxid\tbold_rank\tbold_name\tncbi_taxid\tncbi_rank\tncbi_name\tmatched_rank\n" +
+			"2\tkingdom\tAnimalia\t33208\tkingdom\tMetazoa\tkingdom\n" +
+			"3\tspecies\tAus bus\t50557\tspecies\tAus bus\tspecies\n" +
+			"4\tspecies\tCus dus\t\t\t\t\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		name, ncbiMap, taxonomy  string
+		fasta, seqid2tax, listed string
+		unmapped                 int
+	}{
+		{"bold", "", dir, ">P1\nACGTACGTAC\n>P2\nACGTACGTAA\n", "P1\t3\nP2\t4\n", "", 0},
+		{"ncbi", filepath.Join(dir, "ncbi_map.tsv"), ncbiDir, ">P1\nACGTACGTAC\n", "P1\t50557\n", "P2\t4\n", 1},
+	}
+	for _, tc := range cases {
+		outDir := filepath.Join(dir, tc.name)
+		report := filepath.Join(dir, tc.name+".json")
+		cfg := formatConfig{
+			Classifiers:       []string{"centrifuge"},
+			RequireRanks:      []string{"species"},
+			Input:             filepath.Join(dir, "in.fasta"),
+			OutDir:            outDir,
+			TaxdumpDir:        dir,
+			ReportPath:        report,
+			Sanitize:          sanitizeTranslit,
+			CentrifugeNCBIMap: tc.ncbiMap,
+		}
+		if tc.ncbiMap != "" {
+			cfg.CentrifugeNCBITaxdump = ncbiDir
+		}
+		if err := formatFasta(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"centrifuge.fasta": tc.fasta, "centrifuge_seqid2taxid.map": tc.seqid2tax}
+		if tc.listed != "" {
+			want["centrifuge_unmapped.tsv"] = tc.listed
+		} else if fileExists(filepath.Join(outDir, "centrifuge_unmapped.tsv")) {
+			t.Errorf("%s: centrifuge_unmapped.tsv written", tc.name)
+		}
+		for name, body := range want {
+			got, err := os.ReadFile(filepath.Join(outDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("%s: %s = %q, want %q", tc.name, name, got, body)
+			}
+		}
+		for _, name := range taxonomyDmpFiles {
+			got, err := os.ReadFile(filepath.Join(outDir, centrifugeTaxonomyDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			src, err := os.ReadFile(filepath.Join(tc.taxonomy, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, src) {
+				t.Errorf("%s: %s/%s is not the %s taxdump's", tc.name, centrifugeTaxonomyDir, name, tc.name)
+			}
+		}
+		var stats formatStats
+		data, err := os.ReadFile(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Written != 2 || stats.MissingTaxID != 1 || stats.CentrifugeUnmapped != tc.unmapped {
+			t.Errorf("%s: stats %+v", tc.name, stats)
+		}
+	}
+}
+
+func TestAppendCSVField(t *testing.T) {
+	cases := map[string]string{
+		"":                   "",
+		"Danaus plexippus":   "Danaus plexippus",
+		"Aus, Bus":           `"Aus, Bus"`,
+		`Aus "bus"`:          `"Aus ""bus"""`,
+		`"`:                  `""""`,
+		"line\nbreak":        "\"line\nbreak\"",
+		" padded":            `" padded"`,
+		"Aus (Bus) cus 1758": "Aus (Bus) cus 1758",
+	}
+	for in, want := range cases {
+		if got := string(appendCSVField(nil, in)); got != want {
+			t.Errorf("appendCSVField(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+// TestFormatSourmashLineages checks the lineages CSV reads back with a CSV
+// parser: names with commas and quotes stay single cells, the kingdom
+// fills the superkingdom column, and missing ranks are empty.
+func TestFormatSourmashLineages(t *testing.T) {
+	dir := t.TempDir()
+	writeDmps(t, dir, []string{
+		"1|1|no rank|root",
+		"2|1|superkingdom|Eukaryota",
+		"3|2|phylum|Arthropoda",
+		`4|3|genus|Aus, "Bus"`,
+		"5|4|species|Aus bus (Linnaeus, 1758)",
+	})
+	files := map[string]string{
+		"taxid.map": "P1\t5\nP2\t3\n",
+		"in.fasta":  ">P1\nACGTACGTAC\n>P2\nACGTACGTAA\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, csvOnly := range []bool{false, true} {
+		outDir := t.TempDir()
+		_, outputs, err := runFormatFasta(context.Background(), formatConfig{
+			Classifiers:     []string{"sourmash"},
+			RequireRanks:    []string{"phylum"},
+			Input:           filepath.Join(dir, "in.fasta"),
+			OutDir:          outDir,
+			TaxdumpDir:      dir,
+			Sanitize:        sanitizeTranslit,
+			SourmashCSVOnly: csvOnly,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantOutputs := 2
+		if csvOnly {
+			wantOutputs = 1
+		}
+		if fileExists(filepath.Join(outDir, "sourmash.fasta")) == csvOnly || len(outputs) != wantOutputs {
+			t.Fatalf("csvOnly=%v: outputs %v", csvOnly, outputs)
+		}
+		f, err := os.Open(filepath.Join(outDir, "sourmash_lineages.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(f).ReadAll()
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := [][]string{
+			{"ident", "superkingdom", "phylum", "class", "order", "family", "genus", "species"},
+			{"P1", "Eukaryota", "Arthropoda", "", "", "", `Aus, "Bus"`, "Aus bus (Linnaeus, 1758)"},
+			{"P2", "Eukaryota", "Arthropoda", "", "", "", "", ""},
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Fatalf("csvOnly=%v: rows\n%q\nwant\n%q", csvOnly, rows, want)
+		}
+	}
+}
+
+// TestFormatKraken2Layout checks the database directory kraken2-build
+// --build reads: taxonomy/nodes.dmp and taxonomy/names.dmp, and either
+// kraken:taxid headers or plain headers with seqid2taxid.map. The record
+// missing from taxid.map is dropped.
+func TestFormatKraken2Layout(t *testing.T) {
+	dir := t.TempDir()
+	writeDmps(t, dir, []string{
+		"1|1|no rank|root",
+		"2|1|kingdom|Animalia",
+		"3|2|species|Aus bus",
+	})
+	files := map[string]string{
+		"taxid.map": "P1\t3\nP2\t2\n",
+		"in.fasta":  ">P1\nACGTACGTAC\n>P2\nACGTACGTAA\n>P3\nACGTACGTAG\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		style            kraken2Style
+		symlink          bool
+		fasta, seqid2tax string
+	}{
+		{kraken2Header, false, ">P1|kraken:taxid|3\nACGTACGTAC\n>P2|kraken:taxid|2\nACGTACGTAA\n", ""},
+		{kraken2Map, true, ">P1\nACGTACGTAC\n>P2\nACGTACGTAA\n", "P1\t3\nP2\t2\n"},
+	}
+	for _, tc := range cases {
+		outDir := filepath.Join(dir, string(tc.style))
+		report := filepath.Join(dir, string(tc.style)+".json")
+		err := formatFasta(context.Background(), formatConfig{
+			Classifiers:    []string{"kraken2"},
+			RequireRanks:   []string{"kingdom"},
+			Input:          filepath.Join(dir, "in.fasta"),
+			OutDir:         outDir,
+			TaxdumpDir:     dir,
+			ReportPath:     report,
+			Sanitize:       sanitizeTranslit,
+			Kraken2Style:   tc.style,
+			Kraken2Symlink: tc.symlink,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(outDir, "kraken2.fasta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.fasta {
+			t.Errorf("%s: kraken2.fasta = %q, want %q", tc.style, got, tc.fasta)
+		}
+		got, err = os.ReadFile(filepath.Join(outDir, "seqid2taxid.map"))
+		if tc.seqid2tax == "" {
+			if err == nil {
+				t.Errorf("%s: seqid2taxid.map written", tc.style)
+			}
+		} else if string(got) != tc.seqid2tax {
+			t.Errorf("%s: seqid2taxid.map = %q, want %q (err %v)", tc.style, got, tc.seqid2tax, err)
+		}
+		for _, name := range taxonomyDmpFiles {
+			path := filepath.Join(outDir, "taxonomy", name)
+			info, err := os.Lstat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if linked := info.Mode()&os.ModeSymlink != 0; linked != tc.symlink {
+				t.Errorf("%s: %s symlink=%v, want %v", tc.style, name, linked, tc.symlink)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: taxonomy/%s differs from the taxdump", tc.style, name)
+			}
+		}
+		var stats formatStats
+		data, err := os.ReadFile(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Written != 2 || stats.MissingTaxID != 1 {
+			t.Errorf("%s: stats %+v", tc.style, stats)
+		}
+	}
+}
+
+// TestFormatRdpTree checks the rdp taxonomy is one consistent tree: every
+// parent exists one level up, every training lineage follows a path of it
+// from Root to a leaf at the full depth, and rank gaps get placeholders.
+func TestFormatRdpTree(t *testing.T) {
+	dir := t.TempDir()
+	writeDmps(t, dir, []string{
+		"1|1|no rank|root",
+		"2|1|kingdom|Animalia",
+		"3|2|phylum|Arthropoda",
+		"4|3|class|Insecta",
+		"5|4|order|Lepidoptera",
+		"6|5|family|Nymphalidae",
+		"7|6|genus|Danaus",
+		"8|7|species|Danaus plexippus",
+		"9|4|genus|Aus", // no order or family
+		"10|9|species|Aus bus",
+		"11|4|species|Cus dus", // no order, family, or genus
+	})
+	files := map[string]string{
+		"taxid.map": "P1\t8\nP2\t10\nP3\t11\nP4\t7\n",
+		"in.fasta":  ">P1\nACGTACGTAC\n>P2\nACGTACGTAA\n>P3\nACGTACGTAG\n>P4\nACGTACGTAT\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outDir := filepath.Join(dir, "out")
+	ranks := []string{"kingdom", "phylum", "class", "order", "family", "genus", "species"}
+	stats, _, err := runFormatFasta(context.Background(), formatConfig{
+		Classifiers:  []string{"rdp"},
+		RequireRanks: []string{"class"},
+		RdpRanks:     ranks,
+		Input:        filepath.Join(dir, "in.fasta"),
+		OutDir:       outDir,
+		TaxdumpDir:   dir,
+		Sanitize:     sanitizeTranslit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 4 {
+		t.Fatalf("stats %+v", stats)
+	}
+
+	type node struct {
+		name   string
+		parent int
+		depth  int
+		rank   string
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "rdp_taxonomy.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := make(map[int]node)
+	byName := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		f := strings.Split(line, "*")
+		if len(f) != 5 {
+			t.Fatalf("taxonomy line %q", line)
+		}
+		id, _ := strconv.Atoi(f[0])
+		parent, _ := strconv.Atoi(f[2])
+		depth, _ := strconv.Atoi(f[3])
+		nodes[id] = node{name: f[1], parent: parent, depth: depth, rank: f[4]}
+		byName[f[1]] = id
+	}
+	for id, n := range nodes {
+		if id == 0 {
+			if n.name != "Root" || n.depth != 0 || n.parent != -1 {
+				t.Errorf("root %+v", n)
+			}
+			continue
+		}
+		p, ok := nodes[n.parent]
+		if !ok || p.depth != n.depth-1 {
+			t.Errorf("%s: parent %d (%+v) is not one level up", n.name, n.parent, p)
+		}
+		if want := rdpRankLabel(ranks[n.depth-1]); n.rank != want {
+			t.Errorf("%s: rank %s at depth %d, want %s", n.name, n.rank, n.depth, want)
+		}
+	}
+
+	data, err = os.ReadFile(filepath.Join(outDir, "rdp_train_seqs.fasta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineages := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if id, lineage, ok := strings.Cut(strings.TrimPrefix(line, ">"), "\t"); ok {
+			lineages[id] = lineage
+		}
+	}
+	for id, lineage := range lineages {
+		names := strings.Split(lineage, ";")
+		if len(names) != len(ranks)+1 {
+			t.Errorf("%s: lineage %q does not reach the full depth", id, lineage)
+			continue
+		}
+		parent := -1
+		for _, name := range names {
+			tid, ok := byName[name]
+			if !ok || nodes[tid].parent != parent {
+				t.Errorf("%s: %s is not a child of %d in the taxonomy", id, name, parent)
+				break
+			}
+			parent = tid
+		}
+	}
+	want := map[string]string{
+		"P2": "Root;Animalia;Arthropoda;Insecta;Order_incertae_sedis_Insecta;Family_incertae_sedis_Insecta;Aus;Aus_bus",
+		"P3": "Root;Animalia;Arthropoda;Insecta;Order_incertae_sedis_Insecta;Family_incertae_sedis_Insecta;Genus_incertae_sedis_Insecta;Cus_dus",
+		"P4": "Root;Animalia;Arthropoda;Insecta;Lepidoptera;Nymphalidae;Danaus;Species_incertae_sedis_Danaus",
+	}
+	for id, lineage := range want {
+		if lineages[id] != lineage {
+			t.Errorf("%s: lineage %q, want %q", id, lineages[id], lineage)
+		}
+	}
+}
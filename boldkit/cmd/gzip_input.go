@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/pgzip"
+)
+
+const (
+	// pgzipBlockSize is the block size of the pgzip read-ahead.
+	pgzipBlockSize = 1 << 20
+	// bgzfHeaderSize is the gzip header of a BGZF block: the fixed header
+	// plus the 6-byte BC extra subfield that carries the block size.
+	bgzfHeaderSize = 18
+	bgzfMaxBlock   = 1 << 16
+)
+
+var errBGZFBlock = errors.New("malformed bgzip block")
+
+// newGzipReader decompresses r with up to workers goroutines (<= 0 means
+// GOMAXPROCS). bgzip (BGZF) input is split into its independent blocks and
+// inflated in parallel; other gzip streams are read ahead by pgzip, which
+// moves decompression off the reading goroutine. One worker keeps the
+// single-threaded compress/gzip reader.
+func newGzipReader(r io.Reader, workers int) (io.ReadCloser, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	br := bufio.NewReaderSize(r, bgzfMaxBlock)
+	head, _ := br.Peek(bgzfHeaderSize)
+	switch {
+	case workers == 1:
+		return gzip.NewReader(br)
+	case isBGZFHeader(head):
+		return newBGZFReader(br, workers), nil
+	}
+	return pgzip.NewReaderN(br, pgzipBlockSize, workers)
+}
+
+// isBGZFHeader reports whether head starts a gzip member whose only extra
+// subfield is BGZF's BC block size, as written by bgzip and htslib.
+func isBGZFHeader(head []byte) bool {
+	return len(head) >= bgzfHeaderSize &&
+		head[0] == 0x1f && head[1] == 0x8b && head[2] == 8 && head[3]&0x04 != 0 &&
+		binary.LittleEndian.Uint16(head[10:]) == 6 &&
+		head[12] == 'B' && head[13] == 'C' && binary.LittleEndian.Uint16(head[14:]) == 2
+}
+
+type bgzfBlock struct {
+	data []byte
+	err  error
+	done chan struct{}
+}
+
+// bgzfReader inflates BGZF blocks on a worker pool and returns them in file
+// order. The reading goroutine queues each block before a worker picks it
+// up, so the queue holds the blocks in order.
+type bgzfReader struct {
+	blocks chan *bgzfBlock
+	stop   chan struct{}
+	once   sync.Once
+	cur    *bgzfBlock
+	off    int
+}
+
+func newBGZFReader(r *bufio.Reader, workers int) *bgzfReader {
+	z := &bgzfReader{
+		blocks: make(chan *bgzfBlock, workers*4),
+		stop:   make(chan struct{}),
+	}
+	jobs := make(chan *bgzfBlock, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inflater := flate.NewReader(bytes.NewReader(nil))
+			for b := range jobs {
+				b.data, b.err = inflateBGZF(inflater, b.data)
+				close(b.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(z.blocks)
+		defer func() {
+			close(jobs)
+			wg.Wait()
+		}()
+		for {
+			raw, err := readBGZFBlock(r)
+			if err == io.EOF {
+				return
+			}
+			b := &bgzfBlock{data: raw, done: make(chan struct{})}
+			if err != nil {
+				b.err = err
+				close(b.done)
+			}
+			select {
+			case z.blocks <- b:
+			case <-z.stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case jobs <- b:
+			case <-z.stop:
+				// Queued but never inflated: release Close.
+				close(b.done)
+				return
+			}
+		}
+	}()
+	return z
+}
+
+func (z *bgzfReader) Read(p []byte) (int, error) {
+	for z.cur == nil || z.off == len(z.cur.data) {
+		b, ok := <-z.blocks
+		if !ok {
+			return 0, io.EOF
+		}
+		<-b.done
+		if b.err != nil {
+			return 0, b.err
+		}
+		z.cur, z.off = b, 0
+	}
+	n := copy(p, z.cur.data[z.off:])
+	z.off += n
+	return n, nil
+}
+
+// Close stops the reader and its workers; blocks in flight are discarded.
+func (z *bgzfReader) Close() error {
+	z.once.Do(func() {
+		close(z.stop)
+		for b := range z.blocks {
+			<-b.done
+		}
+	})
+	return nil
+}
+
+// readBGZFBlock reads one whole BGZF block, header to trailer. io.EOF means
+// the input ended cleanly between blocks.
+func readBGZFBlock(r *bufio.Reader) ([]byte, error) {
+	head, err := r.Peek(bgzfHeaderSize)
+	if len(head) == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	if !isBGZFHeader(head) {
+		return nil, fmt.Errorf("%w: missing BC size field", errBGZFBlock)
+	}
+	size := int(binary.LittleEndian.Uint16(head[16:])) + 1
+	if size < bgzfHeaderSize+8 {
+		return nil, fmt.Errorf("%w: block size %d", errBGZFBlock, size)
+	}
+	block := make([]byte, size)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBGZFBlock, err)
+	}
+	return block, nil
+}
+
+// inflateBGZF decompresses a BGZF block and checks its CRC and size.
+func inflateBGZF(inflater io.ReadCloser, block []byte) ([]byte, error) {
+	trailer := block[len(block)-8:]
+	want := binary.LittleEndian.Uint32(trailer[4:])
+	if want > bgzfMaxBlock {
+		return nil, fmt.Errorf("%w: inflated size %d", errBGZFBlock, want)
+	}
+	if err := inflater.(flate.Resetter).Reset(bytes.NewReader(block[bgzfHeaderSize:len(block)-8]), nil); err != nil {
+		return nil, err
+	}
+	out := make([]byte, want)
+	if _, err := io.ReadFull(inflater, out); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBGZFBlock, err)
+	}
+	if crc32.ChecksumIEEE(out) != binary.LittleEndian.Uint32(trailer) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errBGZFBlock)
+	}
+	return out, nil
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// writeBGZF writes data as bgzip does: independent gzip members of at most
+// 64 KiB input each, carrying their size in a BC extra subfield, followed by
+// the empty end-of-file block.
+func writeBGZF(tb testing.TB, w io.Writer, data []byte) {
+	tb.Helper()
+	const chunk = 0xff00
+	for {
+		n := min(len(data), chunk)
+		var member bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&member, gzip.BestSpeed)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		zw.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		if _, err := zw.Write(data[:n]); err != nil {
+			tb.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			tb.Fatal(err)
+		}
+		block := member.Bytes()
+		binary.LittleEndian.PutUint16(block[16:], uint16(len(block)-1))
+		if _, err := w.Write(block); err != nil {
+			tb.Fatal(err)
+		}
+		data = data[n:]
+		if n == 0 {
+			return
+		}
+	}
+}
+
+func gzipBytes(tb testing.TB, data []byte) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		tb.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGzipReaderFormats(t *testing.T) {
+	data, err := io.ReadAll(newSyntheticTSV(syntheticSpec{Rows: 5000, Cols: 20}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bgzf bytes.Buffer
+	writeBGZF(t, &bgzf, data)
+	plain := gzipBytes(t, data)
+	// Concatenated members are one stream to gzip readers.
+	multi := append(gzipBytes(t, data[:1000]), gzipBytes(t, data[1000:])...)
+
+	for name, input := range map[string][]byte{"gzip": plain, "multi-member": multi, "bgzf": bgzf.Bytes()} {
+		for _, workers := range []int{1, 4} {
+			zr, err := newGzipReader(bytes.NewReader(input), workers)
+			if err != nil {
+				t.Fatalf("%s workers=%d: %v", name, workers, err)
+			}
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("%s workers=%d: %v", name, workers, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%s workers=%d: %d bytes, want %d", name, workers, len(got), len(data))
+			}
+			if err := zr.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// A corrupted block fails the read instead of returning bad bytes.
+	corrupt := bytes.Clone(bgzf.Bytes())
+	size := int(binary.LittleEndian.Uint16(corrupt[16:])) + 1
+	corrupt[size-8] ^= 0xff
+	zr, err := newGzipReader(bytes.NewReader(corrupt), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(zr); !errors.Is(err, errBGZFBlock) {
+		t.Fatalf("err=%v", err)
+	}
+	_ = zr.Close()
+
+	// Closing early stops the workers.
+	before := runtime.NumGoroutine()
+	zr, err = newGzipReader(bytes.NewReader(bgzf.Bytes()), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zr.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitForGoroutines(t, before)
+}
+
+// BenchmarkOpenInputGzip reads a gzip and a bgzip copy of a synthetic TSV
+// through openInputWorkers. BOLDKIT_BENCH_GZIP_MB sets the uncompressed size
+// (default 64; use 1024 for a dump-sized run).
+func BenchmarkOpenInputGzip(b *testing.B) {
+	mb := 64
+	if v := os.Getenv("BOLDKIT_BENCH_GZIP_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		mb = n
+	}
+	dir := b.TempDir()
+	src := newSyntheticTSV(syntheticSpec{Rows: int64(mb) << 20 / 400, Cols: 80})
+	data, err := io.ReadAll(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	gzPath := filepath.Join(dir, "in.tsv.gz")
+	if err := os.WriteFile(gzPath, gzipBytes(b, data), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	var bgzf bytes.Buffer
+	writeBGZF(b, &bgzf, data)
+	bgzfPath := filepath.Join(dir, "in.bgzf.tsv.gz")
+	if err := os.WriteFile(bgzfPath, bgzf.Bytes(), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		path    string
+		workers int
+	}{
+		{gzPath, 1},
+		{gzPath, 0},
+		{bgzfPath, 1},
+		{bgzfPath, 0},
+	} {
+		name := fmt.Sprintf("%s/workers=%d", filepath.Base(bc.path), bc.workers)
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				in, err := openInputWorkers(bc.path, bc.workers)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, in); err != nil {
+					b.Fatal(err)
+				}
+				_ = in.Close()
+			}
+		})
+	}
+}
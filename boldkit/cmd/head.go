@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var errStopRows = errors.New("stop rows")
+
+func runHead(args []string) {
+	fs := flag.NewFlagSet("head", flag.ExitOnError)
+	input := fs.String("input", "BOLD_Public.*/BOLD_Public.*.tsv", "BOLD input file (TSV or Parquet)")
+	rows := fs.Int("n", 10, "Number of data rows to print")
+	printSchema := fs.Bool("print-schema", false, "Print header column names one per line (for -expected-schema)")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *rows < 0 {
+		fatalf("n must be >= 0")
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer func() {
+		_ = out.Flush()
+	}()
+	if err := headInput(out, *input, *rows, *printSchema); err != nil {
+		fatalf("head failed: %v", err)
+	}
+}
+
+func headInput(w io.Writer, inputPath string, rows int, printSchema bool) error {
+	opts := DefaultOptions()
+	opts.Workers = 1
+	seen := -1
+	err := ParseRows(inputPath, opts, func(row Row) error {
+		seen++
+		if seen == 0 && printSchema {
+			if _, err := fmt.Fprintf(w, "# columns of %s\n", filepath.Base(inputPath)); err != nil {
+				return err
+			}
+			for _, name := range row.Fields {
+				if _, err := fmt.Fprintf(w, "%s\n", name); err != nil {
+					return err
+				}
+			}
+			return errStopRows
+		}
+		if seen > rows {
+			return errStopRows
+		}
+		for i, f := range row.Fields {
+			if i > 0 {
+				if _, err := io.WriteString(w, "\t"); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(f); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	})
+	if err != nil && !errors.Is(err, errStopRows) {
+		return err
+	}
+	return nil
+}
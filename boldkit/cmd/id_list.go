@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// readIDList reads one ID per line, keeping the first word of each and the
+// first occurrence of repeated IDs. Blank lines and # comments are skipped.
+func readIDList(path string) ([]string, error) {
+	data, err := readIDListData(path)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	seen := make(map[string]struct{})
+	eachListedID(data, func(id string) {
+		if _, dup := seen[id]; dup {
+			return
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	})
+	return ids, nil
+}
+
+// loadIDSet reads an ID list as readIDList does into a set. The map is
+// sized from the line count, so a million-ID list loads without rehashing.
+func loadIDSet(path string) (map[string]struct{}, error) {
+	data, err := readIDListData(path)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]struct{}, strings.Count(data, "\n")+1)
+	eachListedID(data, func(id string) {
+		set[id] = struct{}{}
+	})
+	return set, nil
+}
+
+// readIDListData reads a whole ID list, gunzipping .gz. It is returned as
+// one string so the IDs can be substrings of it.
+func readIDListData(path string) (string, error) {
+	in, err := openInput(path)
+	if err != nil {
+		return "", fmt.Errorf("open ids: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return "", fmt.Errorf("read ids %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+// eachListedID calls fn with the first word of each line of data, skipping
+// blank lines and # comments.
+func eachListedID(data string, fn func(id string)) {
+	for len(data) > 0 {
+		line := data
+		if i := strings.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = ""
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			line = line[:i]
+		}
+		fn(line)
+	}
+}
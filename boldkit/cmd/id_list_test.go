@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadIDSet(t *testing.T) {
+	dir := t.TempDir()
+	list := "# contaminants\r\nP1\r\n\n  P2 human contamination\nP1\n\t# indented comment\nP3"
+	plain := filepath.Join(dir, "ids.txt")
+	gz := filepath.Join(dir, "ids.txt.gz")
+	if err := os.WriteFile(plain, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gz, gzipBytes(t, []byte(list)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct{}{"P1": {}, "P2": {}, "P3": {}}
+	for _, path := range []string{plain, gz} {
+		got, err := loadIDSet(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: %v", path, got)
+		}
+		ids, err := readIDList(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(ids, ",") != "P1,P2,P3" {
+			t.Fatalf("%s: readIDList = %v", path, ids)
+		}
+	}
+}
+
+func TestQCIDLists(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fasta")
+	if err := os.WriteFile(input, []byte(">P1 Homo sapiens\nACGTA\n>P2\nCCGTA\n>P3\nGCGTA\n>P4\nTCGTA\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exclude := filepath.Join(dir, "exclude.txt")
+	include := filepath.Join(dir, "include.txt")
+	if err := os.WriteFile(exclude, []byte("# known contaminants\nP1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(include, []byte("P1\nP2\nP3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.fasta")
+	stats, err := runQCFasta(context.Background(), input, qcConfig{
+		NoTaxonomy: true, MaxN: -1, MaxAmbig: -1, OutputPath: out, ExcludeIDs: exclude, IncludeIDs: include,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != ">P2\nCCGTA\n>P3\nGCGTA\n" {
+		t.Fatalf("output %q", got)
+	}
+	if stats.Dropped["excluded"] != 1 || stats.Dropped["not_included"] != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+// BenchmarkLoadIDSet loads a list of a million processids.
+func BenchmarkLoadIDSet(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 1_000_000; i++ {
+		fmt.Fprintf(&sb, "BOLD%07d-24\n", i)
+	}
+	path := filepath.Join(b.TempDir(), "ids.txt")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set, err := loadIDSet(path)
+		if err != nil || len(set) != 1_000_000 {
+			b.Fatalf("%d IDs, %v", len(set), err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	idScopePerMarker = "per-marker"
+	idScopeGlobal    = "global"
+
+	collisionSuffix = "suffix"
+	collisionDrop   = "drop"
+)
+
+const idRegistryShards = 16
+
+// idRegistry tracks which marker first wrote each sequence ID to a
+// classifier's output so classify can keep IDs unique across markers. IDs
+// are stored as 64-bit hashes, so memory stays at a few words per ID; a hash
+// collision between two distinct IDs is treated as a duplicate.
+type idRegistry struct {
+	policy string
+	shards [idRegistryShards]idShard
+
+	mu         sync.Mutex
+	markers    []string
+	markerIdx  map[string]uint32
+	collisions []idCollision
+}
+
+type idShard struct {
+	mu   sync.Mutex
+	seen map[uint64]uint32 // ID hash -> index of the first marker
+}
+
+// idCollision records one decision taken for an ID already written by an
+// earlier marker.
+type idCollision struct {
+	Classifier  string `json:"classifier"`
+	ID          string `json:"id"`
+	Marker      string `json:"marker"`
+	FirstMarker string `json:"first_marker"`
+	Action      string `json:"action"`
+	NewID       string `json:"new_id,omitempty"`
+}
+
+func parseIDScope(scope, onCollision string) (*idRegistry, error) {
+	switch onCollision {
+	case collisionSuffix, collisionDrop:
+	default:
+		return nil, fmt.Errorf("unknown -on-collision %q (use suffix or drop)", onCollision)
+	}
+	switch scope {
+	case idScopePerMarker:
+		return nil, nil
+	case idScopeGlobal:
+		return newIDRegistry(onCollision), nil
+	default:
+		return nil, fmt.Errorf("unknown -unique-ids-scope %q (use per-marker or global)", scope)
+	}
+}
+
+func newIDRegistry(policy string) *idRegistry {
+	r := &idRegistry{policy: policy, markerIdx: make(map[string]uint32)}
+	for i := range r.shards {
+		r.shards[i].seen = make(map[uint64]uint32)
+	}
+	return r
+}
+
+// claim registers id for marker in classifier's output. It returns the ID to
+// write and false when the record should be dropped. Repeats within the same
+// marker are left to qc. A nil registry keeps every ID.
+func (r *idRegistry) claim(classifier, marker, id string) (string, bool) {
+	if r == nil {
+		return id, true
+	}
+	m := r.markerIndex(marker)
+	h := idHash(classifier, id)
+	shard := &r.shards[h%idRegistryShards]
+	shard.mu.Lock()
+	first, seen := shard.seen[h]
+	if !seen {
+		shard.seen[h] = m
+	}
+	shard.mu.Unlock()
+	if !seen || first == m {
+		return id, true
+	}
+
+	c := idCollision{Classifier: classifier, ID: id, Marker: marker}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c.FirstMarker = r.markers[first]
+	if r.policy == collisionDrop {
+		c.Action = "dropped"
+		r.collisions = append(r.collisions, c)
+		return "", false
+	}
+	c.Action = "renamed"
+	c.NewID = id + "__" + safeTag(marker)
+	r.collisions = append(r.collisions, c)
+	return c.NewID, true
+}
+
+func (r *idRegistry) markerIndex(marker string) uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i, ok := r.markerIdx[marker]; ok {
+		return i
+	}
+	i := uint32(len(r.markers))
+	r.markers = append(r.markers, marker)
+	r.markerIdx[marker] = i
+	return i
+}
+
+// decisions returns the recorded collisions sorted by classifier, marker,
+// and ID so reports do not depend on marker scheduling.
+func (r *idRegistry) decisions() []idCollision {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	out := append([]idCollision(nil), r.collisions...)
+	r.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Classifier != b.Classifier {
+			return a.Classifier < b.Classifier
+		}
+		if a.Marker != b.Marker {
+			return a.Marker < b.Marker
+		}
+		return a.ID < b.ID
+	})
+	return out
+}
+
+// idHash is 64-bit FNV-1a over classifier, a separator, and id.
+func idHash(classifier, id string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(classifier); i++ {
+		h ^= uint64(classifier[i])
+		h *= prime
+	}
+	h *= prime // a zero byte between classifier and id
+	for i := 0; i < len(id); i++ {
+		h ^= uint64(id[i])
+		h *= prime
+	}
+	return h
+}
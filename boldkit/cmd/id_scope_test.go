@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIDRegistryConcurrentClaims(t *testing.T) {
+	ids := newIDRegistry(collisionDrop)
+	var wg sync.WaitGroup
+	kept := make([]int, 4)
+	for m := range kept {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if _, keep := ids.claim("blast", fmt.Sprintf("M%d", m), fmt.Sprintf("P%d", i)); keep {
+					kept[m]++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for _, n := range kept {
+		total += n
+	}
+	if total != 1000 || len(ids.decisions()) != 3000 {
+		t.Fatalf("kept=%v decisions=%d", kept, len(ids.decisions()))
+	}
+	// Repeats within a marker and other classifiers are not collisions.
+	if id, keep := ids.claim("kraken2", "M0", "P1"); !keep || id != "P1" {
+		t.Fatalf("other classifier: id=%q keep=%v", id, keep)
+	}
+	if id, keep := ids.claim("kraken2", "M0", "P1"); !keep || id != "P1" {
+		t.Fatalf("same marker: id=%q keep=%v", id, keep)
+	}
+}
+
+func TestClassifyUniqueIDsAcrossMarkers(t *testing.T) {
+	markers := map[string]string{
+		"COI-5P": ">P1\nACGTACGTAC\n>P2\nACGTACGTAA\n",
+		"ITS":    ">P2\nCCGTACGTAC\n>P4\nCCGTACGTAA\n",
+	}
+	cases := []struct {
+		policy     string
+		wantIDs    []string
+		collisions []idCollision
+	}{
+		{
+			policy:     collisionSuffix,
+			wantIDs:    []string{"P1", "P2", "P2__ITS", "P4"},
+			collisions: []idCollision{{Classifier: "blast", ID: "P2", Marker: "ITS", FirstMarker: "COI-5P", Action: "renamed", NewID: "P2__ITS"}},
+		},
+		{
+			policy:     collisionDrop,
+			wantIDs:    []string{"P1", "P2", "P4"},
+			collisions: []idCollision{{Classifier: "blast", ID: "P2", Marker: "ITS", FirstMarker: "COI-5P", Action: "dropped"}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			tmp := t.TempDir()
+			writeTaxdumpFixture(t, tmp)
+			ids, err := parseIDScope(idScopeGlobal, tc.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			taxdump := newTaxDumpHandle(tmp, nil)
+			for _, marker := range []string{"COI-5P", "ITS"} {
+				input := filepath.Join(tmp, marker+".fasta")
+				if err := os.WriteFile(input, []byte(markers[marker]), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				outDir := filepath.Join(tmp, "out", marker)
+				err := classifyOne(context.Background(), input, outDir, []string{"blast"}, splitList("kingdom,phylum,class,order,family,genus,species"), tmp, "", "", "", 1, 100, 0, 0, 0, true, true, false, false, false, false, false, false, false, false, sanitizeTranslit, marker, "", ids, nil, taxdump)
+				if err != nil {
+					t.Fatalf("classify %s: %v", marker, err)
+				}
+				data, err := os.ReadFile(filepath.Join(outDir, "blast", "blast_seqid2taxid.map"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+					got = append(got, strings.SplitN(line, "\t", 2)[0])
+				}
+			}
+			if !reflect.DeepEqual(got, tc.wantIDs) {
+				t.Fatalf("ids=%q want %q", got, tc.wantIDs)
+			}
+			if d := ids.decisions(); !reflect.DeepEqual(d, tc.collisions) {
+				t.Fatalf("decisions=%+v want %+v", d, tc.collisions)
+			}
+		})
+	}
+}
+
+func TestParseIDScope(t *testing.T) {
+	if ids, err := parseIDScope(idScopePerMarker, collisionSuffix); ids != nil || err != nil {
+		t.Fatalf("per-marker: ids=%v err=%v", ids, err)
+	}
+	if _, err := parseIDScope("everywhere", collisionSuffix); err == nil {
+		t.Fatal("expected scope error")
+	}
+	if _, err := parseIDScope(idScopeGlobal, "rename"); err == nil {
+		t.Fatal("expected policy error")
+	}
+}
@@ -0,0 +1,452 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// inputConfig carries the input-validation behavior shared by the
+// row-oriented stages (extract, markers, pipeline).
+type inputConfig struct {
+	Quarantine *quarantineWriter
+	Header     headerCheckConfig
+	Tuning     *parserTuning
+	// ProvenanceDir enables per-record provenance events (empty disables).
+	ProvenanceDir string
+	// QuotedFields parses TSV input with RFC 4180-style quoting.
+	QuotedFields bool
+	// Sanitize selects how marker names are reduced to file-safe ASCII.
+	Sanitize nameSanitizer
+	// MaxErrors skips up to this many malformed lines per stage and reports
+	// them when the stage finishes (0 fails on the first).
+	MaxErrors int
+	// Sample limits the stage to a deterministic subset of the input rows.
+	Sample sampleConfig
+	// ByteProgress tracks progress by input bytes read instead of rows, for
+	// runs that skip the row count.
+	ByteProgress bool
+	// Wrap splits marker FASTA sequences into lines of this many bases (0
+	// writes each on one line).
+	Wrap int
+	// BGZip compresses .fasta.gz marker output as bgzip blocks instead of
+	// one pgzip stream, so it can be indexed.
+	BGZip bool
+	// Index writes a samtools .fai next to each marker FASTA, plus a .gzi
+	// for bgzip output.
+	Index bool
+	// MarkerAliases folds marker code variants into one FASTA (nil keeps
+	// every sanitized code).
+	MarkerAliases *markerAliases
+	// Markers selects the markers written and drops sparse ones.
+	Markers markerSelection
+	// HeaderFormat renders marker FASTA headers (nil writes the bare
+	// processid).
+	HeaderFormat *markerHeaderFormat
+	// SplitBy names an input column whose values split each marker FASTA
+	// into <marker>/<value> files (empty writes one FASTA per marker).
+	SplitBy string
+	// MaxOpenFiles caps the marker FASTAs open at once (0 is no cap).
+	MaxOpenFiles int
+	// Snapshot is the BOLD snapshot ID recorded in markers_summary.json.
+	Snapshot string
+	// WriteBuffer caps the record bytes queued to the marker writer
+	// goroutines (0 is the 256 MiB default); negative writes each record on
+	// the parser's goroutine.
+	WriteBuffer int64
+	// SeqFilter gates marker records on sequence length and ambiguity.
+	SeqFilter markerSeqFilter
+	// MaxPerMarker, when positive, keeps a reservoir sample of at most that
+	// many records per marker FASTA, drawn with SampleSeed.
+	MaxPerMarker int
+	SampleSeed   uint64
+	// SortOutput buffers marker records and writes each FASTA sorted by
+	// processid, spilling runs past SortMemory bytes (0 is the default
+	// budget) into ScratchDir (empty: the output directory).
+	SortOutput bool
+	SortMemory int64
+	ScratchDir string
+	// Resume checkpoints the marker FASTAs every CheckpointInterval to
+	// .markers.state in the output directory, and continues from a
+	// checkpoint left there by an earlier run.
+	Resume             bool
+	CheckpointInterval time.Duration
+}
+
+// Close releases resources held by the config (the quarantine file).
+func (c inputConfig) Close() error {
+	return c.Quarantine.Close()
+}
+
+type inputFlags struct {
+	quarantine      *string
+	quarantineLimit *int
+	maxLineBytes    *int
+	allowDuplicates *bool
+	expectedSchema  *string
+	schemaStrict    *bool
+	tuning          *string
+	provenanceDir   *string
+	quotedFields    *bool
+	sanitize        *string
+	maxErrors       *int
+	noCount         *bool
+}
+
+func addInputFlags(fs *flag.FlagSet) *inputFlags {
+	return &inputFlags{
+		quarantine:      fs.String("quarantine", "", "Write malformed input lines to this TSV instead of aborting"),
+		quarantineLimit: fs.Int("quarantine-limit", defaultQuarantineLimit, "Abort once more than this many lines are quarantined per stage (0 disables)"),
+		maxLineBytes:    fs.Int("max-line-bytes", 0, "Quarantine lines longer than this many bytes (0 disables; requires -quarantine)"),
+		allowDuplicates: fs.Bool("allow-duplicate-columns", false, "Warn instead of failing on duplicate header names (first match wins)"),
+		expectedSchema:  fs.String("expected-schema", "", "File listing expected column names; drift is reported before processing"),
+		schemaStrict:    fs.Bool("schema-strict", false, "Fail when the header drifts from -expected-schema"),
+		tuning:          fs.String("tuning", "", "Parser tuning JSON written by boldkit bench -tuning-out"),
+		provenanceDir:   fs.String("provenance-dir", "", "Record per-record provenance events under this directory"),
+		quotedFields:    fs.Bool("quoted-fields", false, "Honour double-quoted TSV fields containing tabs or newlines"),
+		sanitize:        fs.String("sanitize", string(defaultSanitizeMode), "Marker name sanitation: translit (fold diacritics to ASCII) or ascii (replace every non-ASCII byte)"),
+		maxErrors:       fs.Int("max-errors", 0, "Skip up to this many malformed lines and list them at the end instead of aborting (0 fails on the first)"),
+		noCount:         fs.Bool("no-count", false, "Skip counting input rows; the progress bar tracks input bytes instead"),
+	}
+}
+
+func (f *inputFlags) config() (inputConfig, error) {
+	header := headerCheckConfig{
+		AllowDuplicates: *f.allowDuplicates,
+		SchemaStrict:    *f.schemaStrict,
+	}
+	if *f.expectedSchema != "" {
+		expected, err := loadColumnSchema(*f.expectedSchema)
+		if err != nil {
+			return inputConfig{}, err
+		}
+		header.Expected = expected
+	} else if *f.schemaStrict {
+		return inputConfig{}, fmt.Errorf("-schema-strict requires -expected-schema")
+	}
+	var tuning *parserTuning
+	if *f.tuning != "" {
+		t, err := loadParserTuning(*f.tuning)
+		if err != nil {
+			return inputConfig{}, err
+		}
+		tuning = t
+	}
+	sanitize, err := parseSanitizeMode(*f.sanitize)
+	if err != nil {
+		return inputConfig{}, err
+	}
+	if *f.maxErrors < 0 {
+		return inputConfig{}, fmt.Errorf("-max-errors must be >= 0")
+	}
+	if *f.maxErrors > 0 && *f.quarantine != "" {
+		return inputConfig{}, fmt.Errorf("-max-errors and -quarantine are mutually exclusive")
+	}
+	quarantine, err := newQuarantineWriter(*f.quarantine, *f.quarantineLimit, *f.maxLineBytes)
+	if err != nil {
+		return inputConfig{}, fmt.Errorf("quarantine: %w", err)
+	}
+	return inputConfig{
+		Quarantine:    quarantine,
+		Header:        header,
+		Tuning:        tuning,
+		ProvenanceDir: *f.provenanceDir,
+		QuotedFields:  *f.quotedFields,
+		Sanitize:      sanitize,
+		MaxErrors:     *f.maxErrors,
+		ByteProgress:  *f.noCount,
+	}, nil
+}
+
+// applyMaxErrors turns on the malformed-line checks and accumulation for
+// -max-errors.
+func (c inputConfig) applyMaxErrors(opts Options) Options {
+	if c.MaxErrors <= 0 {
+		return opts
+	}
+	opts.StrictColumns = true
+	opts.RejectNUL = true
+	opts.MaxErrors = c.MaxErrors
+	return opts
+}
+
+// progress returns the progress bars for a stage reading input: rows, or
+// input bytes under ByteProgress, which Options.ByteProgress feeds for TSV
+// input. Parquet input keeps the row bar.
+func (c inputConfig) progress(stage, input string, totalRows, reportEvery int) (*progress, *byteProgress) {
+	if !c.ByteProgress || reportEvery == 0 || isParquetPath(input) {
+		return newProgress(totalRows, reportEvery), nil
+	}
+	return newProgress(0, 0), newByteProgress(fileSize(input), stage)
+}
+
+// byteProgressMode resolves a -progress-mode value to whether the bar tracks
+// input bytes. The default is bytes for .gz input, where counting rows costs
+// a full decompression, and under -no-count. Parquet input counts rows from
+// its footer and always tracks rows.
+func byteProgressMode(mode, input string, noCount bool) (bool, error) {
+	switch mode {
+	case "":
+		return !isParquetPath(input) && (noCount || strings.HasSuffix(input, ".gz")), nil
+	case "rows":
+		return false, nil
+	case "bytes":
+		return !isParquetPath(input), nil
+	}
+	return false, fmt.Errorf("-progress-mode %q: want rows or bytes", mode)
+}
+
+// sampleConfig selects every Nth input row, up to a limit, for quick
+// previews. The zero value reads every row.
+type sampleConfig struct {
+	Every int64
+	Limit int64
+}
+
+func (c sampleConfig) enabled() bool {
+	return c.Every > 1 || c.Limit > 0
+}
+
+func (c sampleConfig) apply(opts Options) Options {
+	opts.SampleEveryN = c.Every
+	opts.SampleLimit = c.Limit
+	return opts
+}
+
+func (c sampleConfig) String() string {
+	s := fmt.Sprintf("every %d rows", max(c.Every, 1))
+	if c.Limit > 0 {
+		s += fmt.Sprintf(", at most %d", c.Limit)
+	}
+	return s
+}
+
+type sampleFlags struct {
+	every *int64
+	limit *int64
+}
+
+func addSampleFlags(fs *flag.FlagSet) *sampleFlags {
+	return &sampleFlags{
+		every: fs.Int64("sample-every", 0, "Only process every Nth input row, starting with the first (0 or 1 processes all)"),
+		limit: fs.Int64("sample-limit", 0, "Stop after this many sampled rows (0 for no limit)"),
+	}
+}
+
+func (f *sampleFlags) config() (sampleConfig, error) {
+	if *f.every < 0 || *f.limit < 0 {
+		return sampleConfig{}, fmt.Errorf("-sample-every and -sample-limit must be >= 0")
+	}
+	return sampleConfig{Every: *f.every, Limit: *f.limit}, nil
+}
+
+// acceptRowErrors logs the lines a completed parse skipped under -max-errors
+// and clears the error. Any other error, including an exceeded limit, is
+// returned as is.
+func acceptRowErrors(stage string, err error) error {
+	var rowErrs *RowErrors
+	if !errors.As(err, &rowErrs) || rowErrs.Exceeded {
+		return err
+	}
+	limit := "no limit"
+	if rowErrs.Limit >= 0 {
+		limit = fmt.Sprintf("limit %d", rowErrs.Limit)
+	}
+	logf("%s: SKIPPED %d malformed lines (%s)", stage, rowErrs.skipped(), limit)
+	for _, rowErr := range rowErrs.Errors {
+		logf("%s:   %v", stage, rowErr)
+	}
+	if rowErrs.Omitted > 0 {
+		logf("%s:   and %d more", stage, rowErrs.Omitted)
+	}
+	return nil
+}
+
+// headerCheckConfig guards against duplicate columns and drift from an
+// expected column list.
+type headerCheckConfig struct {
+	AllowDuplicates bool
+	Expected        []string
+	SchemaStrict    bool
+}
+
+// check validates a header row. Duplicate names fail unless AllowDuplicates
+// is set; drift from Expected is logged and fails only under SchemaStrict.
+func (c headerCheckConfig) check(stage string, header [][]byte) error {
+	columns := make([]string, len(header))
+	for i, h := range header {
+		columns[i] = string(h)
+	}
+
+	if dups := duplicateColumns(columns); len(dups) > 0 {
+		if !c.AllowDuplicates {
+			return fmt.Errorf("duplicate column names in header: %s (use -allow-duplicate-columns to keep the first)", strings.Join(dups, ", "))
+		}
+		logf("%s: WARNING duplicate column names in header, using first match: %s", stage, strings.Join(dups, ", "))
+	}
+
+	if len(c.Expected) == 0 {
+		return nil
+	}
+	drift := diffColumns(c.Expected, columns)
+	if drift.empty() {
+		return nil
+	}
+	if len(drift.Added) > 0 {
+		logf("%s: schema drift: added columns: %s", stage, strings.Join(drift.Added, ", "))
+	}
+	if len(drift.Removed) > 0 {
+		logf("%s: schema drift: removed columns: %s", stage, strings.Join(drift.Removed, ", "))
+	}
+	if len(drift.Reordered) > 0 {
+		logf("%s: schema drift: reordered columns: %s", stage, strings.Join(drift.Reordered, ", "))
+	}
+	if c.SchemaStrict {
+		return fmt.Errorf("header does not match expected schema (added=%d removed=%d reordered=%d)", len(drift.Added), len(drift.Removed), len(drift.Reordered))
+	}
+	return nil
+}
+
+// duplicateColumns reports each repeated name with its column positions.
+func duplicateColumns(columns []string) []string {
+	positions := make(map[string][]int, len(columns))
+	var order []string
+	for i, name := range columns {
+		if _, seen := positions[name]; !seen {
+			order = append(order, name)
+		}
+		positions[name] = append(positions[name], i+1)
+	}
+	var out []string
+	for _, name := range order {
+		pos := positions[name]
+		if len(pos) < 2 {
+			continue
+		}
+		parts := make([]string, len(pos))
+		for i, p := range pos {
+			parts[i] = fmt.Sprint(p)
+		}
+		out = append(out, fmt.Sprintf("%q (columns %s)", name, strings.Join(parts, ",")))
+	}
+	return out
+}
+
+type columnDrift struct {
+	Added     []string
+	Removed   []string
+	Reordered []string
+}
+
+func (d columnDrift) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Reordered) == 0
+}
+
+// diffColumns compares column lists. Reordered lists the shared columns that
+// moved relative to the expected order.
+func diffColumns(expected, actual []string) columnDrift {
+	var drift columnDrift
+	actualSet := make(map[string]struct{}, len(actual))
+	for _, name := range actual {
+		actualSet[name] = struct{}{}
+	}
+	expectedSet := make(map[string]struct{}, len(expected))
+	for _, name := range expected {
+		expectedSet[name] = struct{}{}
+		if _, ok := actualSet[name]; !ok {
+			drift.Removed = append(drift.Removed, name)
+		}
+	}
+	var shared []string
+	seenShared := make(map[string]struct{})
+	for _, name := range actual {
+		if _, ok := expectedSet[name]; !ok {
+			drift.Added = append(drift.Added, name)
+			continue
+		}
+		if _, dup := seenShared[name]; dup {
+			continue
+		}
+		seenShared[name] = struct{}{}
+		shared = append(shared, name)
+	}
+	expectedShared := make([]string, 0, len(shared))
+	for _, name := range expected {
+		if _, ok := seenShared[name]; ok {
+			expectedShared = append(expectedShared, name)
+			delete(seenShared, name)
+		}
+	}
+	inOrder := longestCommonSubsequence(expectedShared, shared)
+	for _, name := range shared {
+		if _, ok := inOrder[name]; !ok {
+			drift.Reordered = append(drift.Reordered, name)
+		}
+	}
+	return drift
+}
+
+// longestCommonSubsequence returns the names of one LCS of a and b; columns
+// outside it are the minimal set that moved.
+func longestCommonSubsequence(a, b []string) map[string]struct{} {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+	out := make(map[string]struct{}, table[0][0])
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			out[a[i]] = struct{}{}
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
+
+// loadColumnSchema reads one column name per line; blank lines and lines
+// starting with '#' are ignored.
+func loadColumnSchema(path string) ([]string, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open expected schema: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var columns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		columns = append(columns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan expected schema: %w", err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("expected schema %s lists no columns", path)
+	}
+	return columns, nil
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+func TestHeaderCheckDuplicateColumns(t *testing.T) {
+	header := [][]byte{[]byte("processid"), []byte("species"), []byte("nuc"), []byte("species")}
+
+	err := headerCheckConfig{}.check("test", header)
+	if err == nil || !strings.Contains(err.Error(), `"species" (columns 2,4)`) {
+		t.Fatalf("expected duplicate column error, got %v", err)
+	}
+	if err := (headerCheckConfig{AllowDuplicates: true}).check("test", header); err != nil {
+		t.Fatalf("allow duplicates should warn only: %v", err)
+	}
+}
+
+func TestDiffColumns(t *testing.T) {
+	expected := []string{"processid", "bin_uri", "genus", "species", "nuc"}
+	actual := []string{"processid", "genus", "species", "bin_uri", "marker_code"}
+
+	got := diffColumns(expected, actual)
+	want := columnDrift{
+		Added:     []string{"marker_code"},
+		Removed:   []string{"nuc"},
+		Reordered: []string{"bin_uri"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diffColumns=%+v want %+v", got, want)
+	}
+	if !diffColumns(expected, expected).empty() {
+		t.Fatalf("identical headers should not drift")
+	}
+}
+
+func TestSchemaStrictFailsMarkers(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	if err := os.WriteFile(input, []byte("marker_code\tprocessid\tnuc\nCOI-5P\tP1\tACGT\n"), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	var schema bytes.Buffer
+	if err := headInput(&schema, input, 0, true); err != nil {
+		t.Fatalf("headInput: %v", err)
+	}
+	schemaPath := filepath.Join(tmp, "schema.txt")
+	expected := strings.Replace(schema.String(), "marker_code\nprocessid\n", "processid\nmarker_code\n", 1)
+	if err := os.WriteFile(schemaPath, []byte(expected), 0o644); err != nil {
+		t.Fatalf("write schema: %v", err)
+	}
+	columns, err := loadColumnSchema(schemaPath)
+	if err != nil {
+		t.Fatalf("loadColumnSchema: %v", err)
+	}
+	if !reflect.DeepEqual(columns, []string{"processid", "marker_code", "nuc"}) {
+		t.Fatalf("columns=%v", columns)
+	}
+
+	cfg := inputConfig{Header: headerCheckConfig{Expected: columns, SchemaStrict: true}}
+	err = buildMarkerFastas(context.Background(), input, tmp, false, 0, -1, 1, cfg)
+	if err == nil || !strings.Contains(err.Error(), "reordered=1") {
+		t.Fatalf("expected strict schema failure, got %v", err)
+	}
+
+	cfg.Header.SchemaStrict = false
+	if err := buildMarkerFastas(context.Background(), input, tmp, false, 0, -1, 1, cfg); err != nil {
+		t.Fatalf("non-strict drift should only warn: %v", err)
+	}
+}
+
+func TestProgressModesFinishAtTotal(t *testing.T) {
+	dir := t.TempDir()
+	var b strings.Builder
+	b.WriteString("processid\tmarker_code\tnuc\n")
+	for i := 0; i < 5000; i++ {
+		b.WriteString("P1\tCOI-5P\tACGTACGTACGT\n")
+	}
+	plain := filepath.Join(dir, "in.tsv")
+	gz := filepath.Join(dir, "in.tsv.gz")
+	if err := os.WriteFile(plain, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gz, gzipBytes(t, []byte(b.String())), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		mode, input string
+		bytes       bool
+	}{
+		{"", plain, false},
+		{"", gz, true},
+		{"rows", gz, false},
+		{"bytes", plain, true},
+	} {
+		byteMode, err := byteProgressMode(bc.mode, bc.input, false)
+		if err != nil || byteMode != bc.bytes {
+			t.Fatalf("mode %q %s: bytes=%v err=%v", bc.mode, bc.input, byteMode, err)
+		}
+		cfg := inputConfig{ByteProgress: byteMode}
+		rows, bar := cfg.progress("markers", bc.input, progressTotal(bc.input, true, byteMode), 1)
+		opts := Options{HasHeader: true, ChunkSize: 4 << 10, Progress: rows}
+		if bar != nil {
+			opts.ByteProgress = bar
+		}
+		if err := ParseRows(bc.input, opts, func(Row) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		var state progressbar.State
+		if bar != nil {
+			state = bar.bar.State()
+		} else {
+			state = rows.bar.State()
+		}
+		// Checked before finish, which would force the bar to its total.
+		if state.Max <= 0 || state.CurrentNum != state.Max {
+			t.Fatalf("mode %q %s: progress %d of %d", bc.mode, filepath.Base(bc.input), state.CurrentNum, state.Max)
+		}
+		rows.finish()
+		bar.Finish()
+	}
+	if _, err := byteProgressMode("lines", plain, false); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+}
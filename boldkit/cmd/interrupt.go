@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// exitInterrupted is the conventional exit status for a run stopped by SIGINT.
+const exitInterrupted = 130
+
+const (
+	stageStatusComplete    = "complete"
+	stageStatusInterrupted = "interrupted"
+	stageStatusFailed      = "failed"
+	stageStatusSampled     = "sampled"
+)
+
+// interruptContext returns a context that is cancelled on the first SIGINT or
+// SIGTERM so stages can flush and close their writers. A second signal exits
+// immediately with status 130. Call stop once the run is finished.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigCh:
+			logf("received %s, finishing current writes (signal again to force exit)", sig)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-sigCh:
+			logf("forced exit")
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigCh)
+		close(done)
+		cancel()
+	}
+}
+
+// stageInterrupt reports where a stage stopped after its context was
+// cancelled. It unwraps to context.Canceled. Resumable is set once the
+// stage state has been written.
+type stageInterrupt struct {
+	Stage     string
+	Line      int64
+	Resumable bool
+}
+
+func (e *stageInterrupt) Error() string {
+	msg := "interrupted during stage " + e.Stage
+	if e.Line > 0 {
+		msg = fmt.Sprintf("interrupted at row %d / stage %s", e.Line, e.Stage)
+	}
+	if e.Resumable {
+		msg += ", resumable with -resume"
+	}
+	return msg
+}
+
+func (e *stageInterrupt) Unwrap() error {
+	return context.Canceled
+}
+
+// interruptedAt converts a parse error caused by ctx cancellation into a
+// stageInterrupt; other errors pass through unchanged.
+func interruptedAt(ctx context.Context, err error, stage string, line int64) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	return &stageInterrupt{Stage: stage, Line: line}
+}
+
+// stageState is written next to a stage's output so later runs can tell a
+// complete output from one left behind by an interrupted or failed run.
+type stageState struct {
+	reportHeader
+	Stage     string `json:"stage"`
+	Status    string `json:"status"`
+	Input     string `json:"input"`
+	LastLine  int64  `json:"last_line,omitempty"`
+	Error     string `json:"error,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func stageStatePath(output string) string {
+	return filepath.Clean(output) + ".state.json"
+}
+
+// recordStage writes the stage state for output and returns err unchanged
+// (joined with any error writing the state file).
+func recordStage(output, stage, input string, err error) error {
+	return writeStageState(output, stage, input, stageStatusComplete, err)
+}
+
+// recordSampledStage is recordStage for a run over a sample of its input. A
+// completed run is recorded as sampled, so later runs rebuild the output
+// instead of skipping it.
+func recordSampledStage(output, stage, input string, err error) error {
+	return writeStageState(output, stage, input, stageStatusSampled, err)
+}
+
+func writeStageState(output, stage, input, complete string, err error) error {
+	state := stageState{
+		reportHeader: newReportHeader("stage-state"),
+		Stage:        stage,
+		Status:       complete,
+		Input:        input,
+		UpdatedAt:    time.Now().UTC().Format(time.RFC3339),
+	}
+	var interrupt *stageInterrupt
+	switch {
+	case errors.As(err, &interrupt):
+		state.Status = stageStatusInterrupted
+		state.LastLine = interrupt.Line
+	case err != nil:
+		state.Status = stageStatusFailed
+		state.Error = err.Error()
+	}
+	if writeErr := writeReportJSON(stageStatePath(output), state); writeErr != nil {
+		return errors.Join(err, fmt.Errorf("write stage state: %w", writeErr))
+	}
+	if interrupt != nil {
+		interrupt.Resumable = true
+	}
+	return err
+}
+
+// incompleteStage returns the recorded state when the previous run that wrote
+// output did not complete.
+func incompleteStage(output string) (stageState, bool) {
+	data, err := os.ReadFile(stageStatePath(output))
+	if err != nil {
+		return stageState{}, false
+	}
+	var state stageState
+	if err := decodeReport("stage-state", data, &state); err != nil {
+		return stageState{}, false
+	}
+	return state, state.Status != stageStatusComplete
+}
+
+// outputStale reports whether an existing output was left behind by an
+// interrupted, failed, or sampled run and should be rebuilt rather than
+// skipped.
+func outputStale(output string) bool {
+	state, incomplete := incompleteStage(output)
+	if !incomplete {
+		return false
+	}
+	switch state.Status {
+	case stageStatusInterrupted:
+		logf("%s: previous run was interrupted at row %d, rebuilding %s", state.Stage, state.LastLine, output)
+	case stageStatusSampled:
+		logf("%s: previous run only read a sample, rebuilding %s", state.Stage, output)
+	default:
+		logf("%s: previous run failed (%s), rebuilding %s", state.Stage, state.Error, output)
+	}
+	return true
+}
+
+// exitOnStageError exits with status 130 for interrupted runs, after logging
+// resource usage so far, and 1 for failures.
+func exitOnStageError(err error) {
+	if err == nil {
+		return
+	}
+	exitIfInterrupted(err)
+	fatalf("build failed: %v", err)
+}
+
+// exitIfInterrupted exits with status 130, after logging resource usage so
+// far, when err is an interruption; otherwise it returns.
+func exitIfInterrupted(err error) {
+	var interrupt *stageInterrupt
+	if errors.As(err, &interrupt) {
+		logf("%v", err)
+		currentRun().finish()
+		os.Exit(exitInterrupted)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordStageStatus(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.tsv")
+	cases := []struct {
+		err        error
+		sampled    bool
+		wantStatus string
+		incomplete bool
+	}{
+		{err: &stageInterrupt{Stage: "extract", Line: 42}, wantStatus: stageStatusInterrupted, incomplete: true},
+		{err: errors.New("boom"), wantStatus: stageStatusFailed, incomplete: true},
+		{err: nil, wantStatus: stageStatusComplete},
+		{err: nil, sampled: true, wantStatus: stageStatusSampled, incomplete: true},
+		{err: errors.New("boom"), sampled: true, wantStatus: stageStatusFailed, incomplete: true},
+	}
+	for _, tc := range cases {
+		record := recordStage
+		if tc.sampled {
+			record = recordSampledStage
+		}
+		if err := record(out, "extract", "in.tsv", tc.err); err != tc.err {
+			t.Fatalf("recordStage returned %v want %v", err, tc.err)
+		}
+		if _, ok := tc.err.(*stageInterrupt); ok && !strings.HasSuffix(tc.err.Error(), ", resumable with -resume") {
+			t.Fatalf("%v: no resume hint after writing the state", tc.err)
+		}
+		state, incomplete := incompleteStage(out)
+		if incomplete != tc.incomplete {
+			t.Fatalf("%v: incomplete=%v want %v", tc.err, incomplete, tc.incomplete)
+		}
+		if incomplete && state.Status != tc.wantStatus {
+			t.Fatalf("%v: status=%q want %q", tc.err, state.Status, tc.wantStatus)
+		}
+		if outputStale(out) != tc.incomplete {
+			t.Fatalf("%v: outputStale mismatch", tc.err)
+		}
+	}
+}
+
+func TestInterruptedAt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	other := errors.New("bad row")
+	if got := interruptedAt(ctx, other, "markers", 7); got != other {
+		t.Fatalf("live context should pass errors through, got %v", got)
+	}
+	cancel()
+	got := interruptedAt(ctx, context.Canceled, "markers", 7)
+	if !errors.Is(got, context.Canceled) || got.Error() != "interrupted at row 7 / stage markers" {
+		t.Fatalf("got %v", got)
+	}
+}
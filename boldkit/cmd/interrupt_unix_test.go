@@ -0,0 +1,142 @@
+//go:build unix
+
+package cmd
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestInterruptChild runs the subcommand named by BOLDKIT_INTERRUPT_CHILD in
+// a child process for the SIGINT tests.
+func TestInterruptChild(t *testing.T) {
+	run := map[string]func([]string){"markers": runMarkers, "qc": runQC}[os.Getenv("BOLDKIT_INTERRUPT_CHILD")]
+	if run == nil {
+		t.Skip("helper process")
+	}
+	run(strings.Fields(os.Getenv("BOLDKIT_INTERRUPT_ARGS")))
+	os.Exit(0)
+}
+
+// interruptChild starts subcommand reading fifo in a child process, writes
+// input into the fifo, and sends SIGINT while the fifo is still open. It
+// returns the child's stderr once it has exited with status 130.
+func interruptChild(t *testing.T, subcommand, args, fifo, input string) string {
+	t.Helper()
+	child := exec.Command(os.Args[0], "-test.run=^TestInterruptChild$")
+	child.Env = append(os.Environ(),
+		"BOLDKIT_INTERRUPT_CHILD="+subcommand,
+		"BOLDKIT_INTERRUPT_ARGS="+args,
+	)
+	var stderr strings.Builder
+	child.Stderr = &stderr
+	if err := child.Start(); err != nil {
+		t.Fatalf("start child: %v", err)
+	}
+
+	w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open fifo: %v", err)
+	}
+	if _, err := io.WriteString(w, input); err != nil {
+		t.Fatalf("write fifo: %v", err)
+	}
+
+	// Leave the writer open so the child is mid-run, then interrupt it.
+	time.Sleep(300 * time.Millisecond)
+	if err := child.Process.Signal(os.Interrupt); err != nil {
+		t.Fatalf("signal child: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	_ = w.Close()
+
+	err = child.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitInterrupted {
+		t.Fatalf("expected exit %d, got %v\n%s", exitInterrupted, err, stderr.String())
+	}
+	return stderr.String()
+}
+
+func TestMarkersInterruptedBySIGINT(t *testing.T) {
+	tmp := t.TempDir()
+	fifo := filepath.Join(tmp, "input.tsv")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("mkfifo unsupported: %v", err)
+	}
+	outDir := filepath.Join(tmp, "markers")
+
+	var rows strings.Builder
+	rows.WriteString("processid\tmarker_code\tnuc\n")
+	for i := 1; i <= 500; i++ {
+		fmt.Fprintf(&rows, "P%d\tCOI-5P\tACGTACGT\n", i)
+	}
+	stderr := interruptChild(t, "markers", "-input "+fifo+" -outdir "+outDir+" -progress=false -workers 2", fifo, rows.String())
+	if !strings.Contains(stderr, "interrupted at row 501 / stage markers, resumable with -resume") {
+		t.Fatalf("missing interrupt message:\n%s", stderr)
+	}
+
+	state, incomplete := incompleteStage(outDir)
+	if !incomplete || state.Status != stageStatusInterrupted || state.LastLine != 501 {
+		t.Fatalf("state=%+v incomplete=%v", state, incomplete)
+	}
+
+	f, err := os.Open(filepath.Join(outDir, "COI-5P.fasta.gz"))
+	if err != nil {
+		t.Fatalf("open fasta: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip header: %v", err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("partial gzip output is truncated: %v", err)
+	}
+	if got := strings.Count(string(data), ">"); got != 500 {
+		t.Fatalf("records=%d want 500", got)
+	}
+}
+
+func TestQCInterruptedBySIGINT(t *testing.T) {
+	tmp := t.TempDir()
+	fifo := filepath.Join(tmp, "input.fasta")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("mkfifo unsupported: %v", err)
+	}
+	output := filepath.Join(tmp, "qc.fasta")
+
+	var records strings.Builder
+	for i := 1; i <= 500; i++ {
+		fmt.Fprintf(&records, ">P%d\nACGTACGT\n", i)
+	}
+	stderr := interruptChild(t, "qc", "-input "+fifo+" -output "+output+" -no-taxonomy -dedupe=false -progress=false -workers 2", fifo, records.String())
+	// The last record is only complete at EOF, after the interrupt.
+	if !strings.Contains(stderr, "interrupted at row 499 / stage qc, resumable with -resume") {
+		t.Fatalf("missing interrupt message:\n%s", stderr)
+	}
+
+	state, incomplete := incompleteStage(output)
+	if !incomplete || state.Status != stageStatusInterrupted || state.LastLine != 499 {
+		t.Fatalf("state=%+v incomplete=%v", state, incomplete)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if got := strings.Count(string(data), ">"); got != 499 {
+		t.Fatalf("records=%d want 499", got)
+	}
+}
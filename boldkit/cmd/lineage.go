@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type lineageConfig struct {
+	TaxdumpDir  string
+	OutputPath  string
+	Ranks       []string
+	RankAliases string
+	// All exports every leaf node of nodes.dmp rather than the taxids of
+	// taxid.map.
+	All bool
+	// NameClasses are the names.dmp classes names are taken from, best
+	// first (nil means defaultNameClasses).
+	NameClasses []string
+	// NamesOnly writes taxid and the ';'-joined names of Ranks, as taxonkit
+	// reformat does, instead of a column per rank.
+	NamesOnly bool
+}
+
+// lineageStats counts the taxids exported and those taxid.map lists that
+// nodes.dmp lacks.
+type lineageStats struct {
+	Written    int
+	Unresolved int
+}
+
+func runLineage(args []string) {
+	fs := flag.NewFlagSet("lineage", flag.ExitOnError)
+	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Taxdump directory with nodes.dmp/names.dmp/taxid.map")
+	output := fs.String("output", "lineages.tsv", "Output lineage TSV (.gz compresses)")
+	ranks := fs.String("ranks", "kingdom,phylum,class,order,family,genus,species", "Comma-separated ranks to export, in column order")
+	rankAliases := fs.String("rank-aliases", "", rankAliasesUsage)
+	all := fs.Bool("all", false, "Export every leaf node of nodes.dmp instead of the taxids of taxid.map")
+	nameClasses := fs.String("name-classes", strings.Join(defaultNameClasses, ","), "Comma-separated names.dmp classes to name taxa from, best first")
+	namesOnly := fs.Bool("names-only", false, "Write taxid and a ';'-joined lineage string (taxonkit reformat style) instead of a column per rank")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	cfg := lineageConfig{
+		TaxdumpDir:  *taxdumpDir,
+		OutputPath:  *output,
+		Ranks:       splitList(*ranks),
+		RankAliases: *rankAliases,
+		All:         *all,
+		NameClasses: splitList(*nameClasses),
+		NamesOnly:   *namesOnly,
+	}
+	if len(cfg.Ranks) == 0 {
+		fatalf("ranks must not be empty")
+	}
+	if len(cfg.NameClasses) == 0 {
+		fatalf("name-classes must not be empty")
+	}
+	if _, err := exportLineages(cfg); err != nil {
+		fatalf("lineage failed: %v", err)
+	}
+}
+
+// exportLineages writes one row per taxid, in taxid order, with the names
+// of cfg.Ranks in its lineage, blank where the lineage lacks a rank.
+func exportLineages(cfg lineageConfig) (lineageStats, error) {
+	var stats lineageStats
+	aliases, err := parseRankAliases(cfg.RankAliases)
+	if err != nil {
+		return stats, fmt.Errorf("rank aliases: %w", err)
+	}
+	ranks := aliases.ranks(cfg.Ranks)
+	dump, err := loadTaxDumpClasses(filepath.Join(cfg.TaxdumpDir, "nodes.dmp"), filepath.Join(cfg.TaxdumpDir, "names.dmp"), aliases, cfg.NameClasses)
+	if err != nil {
+		return stats, err
+	}
+	var taxids []int
+	if cfg.All {
+		taxids = leafTaxids(dump)
+	} else {
+		taxids, err = mappedTaxids(filepath.Join(cfg.TaxdumpDir, "taxid.map"))
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	out, err := createTSVWriter(cfg.OutputPath, tsvWriterOptions{})
+	if err != nil {
+		return stats, fmt.Errorf("create lineage output: %w", err)
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+	header := append([]string{"taxid"}, ranks...)
+	if cfg.NamesOnly {
+		header = []string{"taxid", "lineage"}
+	}
+	if err := out.WriteHeader(header); err != nil {
+		return stats, err
+	}
+	row := make([][]byte, 0, len(ranks)+1)
+	var joined []byte
+	for _, taxid := range taxids {
+		cur, _ := dump.current(taxid)
+		if _, ok := dump.node(cur); !ok {
+			stats.Unresolved++
+			continue
+		}
+		lineage := dump.walkLineage(cur)
+		row = append(row[:0], strconv.AppendInt(nil, int64(taxid), 10))
+		if cfg.NamesOnly {
+			joined = joined[:0]
+			for i, rank := range ranks {
+				if i > 0 {
+					joined = append(joined, ';')
+				}
+				joined = append(joined, lineage.get(rank)...)
+			}
+			row = append(row, joined)
+		} else {
+			for _, rank := range ranks {
+				row = append(row, []byte(lineage.get(rank)))
+			}
+		}
+		if err := out.WriteRow(row); err != nil {
+			return stats, err
+		}
+		stats.Written++
+	}
+	if err := out.Close(); err != nil {
+		return stats, fmt.Errorf("close lineage output: %w", err)
+	}
+	if stats.Unresolved > 0 {
+		logf("WARNING: %d taxid.map taxids are not in nodes.dmp; skipped", stats.Unresolved)
+	}
+	logf("lineage: %d taxids -> %s", stats.Written, cfg.OutputPath)
+	return stats, nil
+}
+
+// leafTaxids returns the taxids of the nodes no other node names as parent.
+func leafTaxids(dump *taxDump) []int {
+	parents := make(map[int]bool)
+	dump.eachNode(func(taxid int, n taxNode) {
+		if int(n.parent) != taxid {
+			parents[int(n.parent)] = true
+		}
+	})
+	var leaves []int
+	dump.eachNode(func(taxid int, _ taxNode) {
+		if !parents[taxid] {
+			leaves = append(leaves, taxid)
+		}
+	})
+	return leaves
+}
+
+// mappedTaxids returns the distinct taxids of a taxid.map, sorted.
+func mappedTaxids(path string) ([]int, error) {
+	seen := make(map[int]bool)
+	err := scanTaxidMap(path, func(_ []byte, taxid int) error {
+		seen[taxid] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	taxids := make([]int, 0, len(seen))
+	for taxid := range seen {
+		taxids = append(taxids, taxid)
+	}
+	slices.Sort(taxids)
+	return taxids, nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportLineages(t *testing.T) {
+	dir := t.TempDir()
+	writeTaxdumpFixture(t, dir)
+	if err := os.WriteFile(filepath.Join(dir, "merged.dmp"), []byte("70\t|\t7\t|\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "taxid.map"), []byte("P1\t7\nP2\t8\nP3\t7\nP4\t70\nP5\t12345\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		cfg  lineageConfig
+		want string
+	}{
+		{
+			name: "columns",
+			cfg:  lineageConfig{Ranks: []string{"kingdom", "family", "genus", "species"}},
+			want: "taxid\tkingdom\tfamily\tgenus\tspecies\n" +
+				"7\tAnimalia\tCanidae\tCanis\tCanis lupus\n" +
+				"8\tAnimalia\tFelidae\t\t\n" +
+				"70\tAnimalia\tCanidae\tCanis\tCanis lupus\n",
+		},
+		{
+			name: "names-only",
+			cfg:  lineageConfig{Ranks: []string{"kingdom", "order", "genus", "species"}, NamesOnly: true},
+			want: "taxid\tlineage\n" +
+				"7\tAnimalia;Carnivora;Canis;Canis lupus\n" +
+				"8\tAnimalia;Carnivora;;\n" +
+				"70\tAnimalia;Carnivora;Canis;Canis lupus\n",
+		},
+		{
+			name: "all-leaves",
+			cfg:  lineageConfig{Ranks: []string{"family", "species"}, All: true},
+			want: "taxid\tfamily\tspecies\n" +
+				"7\tCanidae\tCanis lupus\n" +
+				"8\tFelidae\t\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.cfg.TaxdumpDir = dir
+			c.cfg.OutputPath = filepath.Join(t.TempDir(), "lineages.tsv.gz")
+			stats, err := exportLineages(c.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.cfg.All && stats.Unresolved != 1 {
+				t.Fatalf("stats = %+v", stats)
+			}
+			f, err := os.Open(c.cfg.OutputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, c.want)
+			}
+		})
+	}
+}
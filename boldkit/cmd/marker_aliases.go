@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed marker_aliases.tsv
+var defaultMarkerAliases string
+
+// markerAliasesUsage is the -marker-aliases flag help of markers and
+// pipeline.
+const markerAliasesUsage = "Marker code aliases folding variants into one FASTA: a JSON object or TSV of pattern -> canonical marker (exact names or globs, matched without case after sanitizing), \"none\" to disable (default: the embedded table folding the COI-5P synonyms)"
+
+// markerAliases maps sanitized marker codes to the marker whose FASTA they
+// are written to. Exact names are tried before globs, and globs in the order
+// given; codes matching neither keep their name.
+type markerAliases struct {
+	// source is "embedded" or the file the table was read from.
+	source string
+	exact  map[string]string // lowercased pattern -> canonical
+	globs  [][2]string       // lowercased pattern, canonical
+	seen   map[string]string // resolved codes; markers are few
+}
+
+// loadMarkerAliases reads the -marker-aliases table: "" is the embedded
+// default and "none" disables folding (nil). Canonical names are sanitized
+// like marker codes.
+func loadMarkerAliases(spec string, sanitize nameSanitizer) (*markerAliases, error) {
+	var (
+		pairs [][2]string
+		err   error
+	)
+	switch spec {
+	case "none":
+		return nil, nil
+	case "":
+		if pairs, err = parseMarkerAliasTSV(strings.NewReader(defaultMarkerAliases), "embedded"); err != nil {
+			return nil, err
+		}
+		spec = "embedded"
+	default:
+		if pairs, err = loadMarkerAliasFile(spec); err != nil {
+			return nil, err
+		}
+	}
+	a := &markerAliases{source: spec, exact: make(map[string]string), seen: make(map[string]string)}
+	for _, p := range pairs {
+		pattern := strings.TrimSpace(p[0])
+		canonical := sanitize.taxon(strings.TrimSpace(p[1]))
+		if pattern == "" || canonical == "" {
+			return nil, fmt.Errorf("marker aliases %s: empty pattern or marker in %q -> %q", spec, p[0], p[1])
+		}
+		if !strings.ContainsAny(pattern, "*?[") {
+			a.exact[strings.ToLower(sanitize.taxon(pattern))] = canonical
+			continue
+		}
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("marker aliases %s: pattern %q: %w", spec, p[0], err)
+		}
+		a.globs = append(a.globs, [2]string{pattern, canonical})
+	}
+	return a, nil
+}
+
+// loadMarkerAliasFile reads a JSON object of pattern -> canonical (.json),
+// keeping its order, or a pattern<TAB>canonical TSV with '#' comments.
+func loadMarkerAliasFile(path string) ([][2]string, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("open marker aliases: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if !strings.EqualFold(filepath.Ext(strings.TrimSuffix(path, ".gz")), ".json") {
+		return parseMarkerAliasTSV(f, path)
+	}
+
+	dec := json.NewDecoder(f)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("parse marker aliases %s: want a JSON object", path)
+	}
+	var pairs [][2]string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("parse marker aliases %s: %w", path, err)
+		}
+		var canonical string
+		if err := dec.Decode(&canonical); err != nil {
+			return nil, fmt.Errorf("parse marker aliases %s: %q: %w", path, tok, err)
+		}
+		pairs = append(pairs, [2]string{tok.(string), canonical})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("parse marker aliases %s: %w", path, err)
+	}
+	return pairs, nil
+}
+
+func parseMarkerAliasTSV(r io.Reader, name string) ([][2]string, error) {
+	var pairs [][2]string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if trimmed := strings.TrimSpace(text); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("marker aliases %s line %d: want pattern<TAB>marker, got %d fields", name, line, len(fields))
+		}
+		pairs = append(pairs, [2]string{fields[0], fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan marker aliases: %w", err)
+	}
+	return pairs, nil
+}
+
+// canonical returns the marker a sanitized code is written to. A nil table
+// keeps every code. It is not safe for concurrent use.
+func (a *markerAliases) canonical(marker string) string {
+	if a == nil {
+		return marker
+	}
+	if c, ok := a.seen[marker]; ok {
+		return c
+	}
+	c := marker
+	lower := strings.ToLower(marker)
+	if exact, ok := a.exact[lower]; ok {
+		c = exact
+	} else {
+		for _, g := range a.globs {
+			if ok, _ := path.Match(g[0], lower); ok {
+				c = g[1]
+				break
+			}
+		}
+	}
+	a.seen[marker] = c
+	return c
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// markerHeaderUsage is the -header-format flag help of markers.
+const markerHeaderUsage = "FASTA header template of marker records, with placeholders {processid}, {marker}, {bin_uri}, {species}, and {lineage} (kingdom;phylum;class;order;family;genus;species from the row); default is the bare processid"
+
+// markerLineageRanks are the taxonomy columns {lineage} joins, in order.
+var markerLineageRanks = []string{"kingdom", "phylum", "class", "order", "family", "genus", "species"}
+
+type markerHeaderField int
+
+const (
+	headerLiteral markerHeaderField = iota
+	headerProcessID
+	headerMarker
+	headerBinURI
+	headerSpecies
+	headerLineage
+)
+
+var markerHeaderFields = map[string]markerHeaderField{
+	"processid": headerProcessID,
+	"marker":    headerMarker,
+	"bin_uri":   headerBinURI,
+	"species":   headerSpecies,
+	"lineage":   headerLineage,
+}
+
+type markerHeaderPart struct {
+	field   markerHeaderField
+	literal string
+}
+
+// markerHeaderFormat renders marker FASTA headers from a -header-format
+// template. Field values are read from the same row, so no taxdump is needed.
+type markerHeaderFormat struct {
+	parts []markerHeaderPart
+	// columns are the input columns the template reads beyond processid,
+	// marker_code, and nuc, in the order bind places them.
+	columns []string
+	// Row indexes of the columns, set by bind.
+	binURI, species int
+	lineage         []int
+}
+
+// parseMarkerHeaderFormat parses a -header-format template. It returns nil
+// for "" and "{processid}", the bare processid.
+func parseMarkerHeaderFormat(tmpl string) (*markerHeaderFormat, error) {
+	if tmpl == "" || tmpl == "{processid}" {
+		return nil, nil
+	}
+	if strings.ContainsAny(tmpl, ">\r\n") {
+		return nil, fmt.Errorf("header format %q: '>' and newlines are not allowed", tmpl)
+	}
+	f := &markerHeaderFormat{binURI: -1, species: -1}
+	need := func(names ...string) {
+		for _, name := range names {
+			if !slices.Contains(f.columns, name) {
+				f.columns = append(f.columns, name)
+			}
+		}
+	}
+	rest := tmpl
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			f.parts = append(f.parts, markerHeaderPart{literal: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("header format %q: unmatched '}'", tmpl)
+		}
+		if open > 0 {
+			f.parts = append(f.parts, markerHeaderPart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("header format %q: unclosed '{'", tmpl)
+		}
+		name := rest[open+1 : open+end]
+		field, ok := markerHeaderFields[name]
+		if !ok {
+			return nil, fmt.Errorf("header format %q: unknown placeholder {%s} (available: {processid}, {marker}, {bin_uri}, {species}, {lineage})", tmpl, name)
+		}
+		switch field {
+		case headerBinURI:
+			need("bin_uri")
+		case headerSpecies:
+			need("species")
+		case headerLineage:
+			need(markerLineageRanks...)
+		}
+		f.parts = append(f.parts, markerHeaderPart{field: field})
+		rest = rest[open+end+1:]
+	}
+	return f, nil
+}
+
+// bind places the template's columns in a row from index first on.
+func (f *markerHeaderFormat) bind(first int) {
+	f.lineage = make([]int, len(markerLineageRanks))
+	for i := range f.lineage {
+		f.lineage[i] = -1
+	}
+	for i, name := range f.columns {
+		switch name {
+		case "bin_uri":
+			f.binURI = first + i
+		case "species":
+			f.species = first + i
+		}
+		if r := slices.Index(markerLineageRanks, name); r >= 0 {
+			f.lineage[r] = first + i
+		}
+	}
+}
+
+// appendHeader appends the header of a row to dst. '>' and line breaks in
+// field values become '_', as does ';' in lineage names.
+func (f *markerHeaderFormat) appendHeader(dst []byte, pid []byte, marker string, fields [][]byte) []byte {
+	for _, p := range f.parts {
+		switch p.field {
+		case headerLiteral:
+			dst = append(dst, p.literal...)
+		case headerProcessID:
+			dst = appendHeaderValue(dst, pid, false)
+		case headerMarker:
+			dst = append(dst, marker...)
+		case headerBinURI:
+			dst = appendHeaderValue(dst, normalizeBytes(fieldBytes(fields, f.binURI)), false)
+		case headerSpecies:
+			dst = appendHeaderValue(dst, normalizeBytes(fieldBytes(fields, f.species)), false)
+		case headerLineage:
+			for i, idx := range f.lineage {
+				if i > 0 {
+					dst = append(dst, ';')
+				}
+				dst = appendHeaderValue(dst, normalizeBytes(fieldBytes(fields, idx)), true)
+			}
+		}
+	}
+	return dst
+}
+
+func appendHeaderValue(dst, value []byte, lineage bool) []byte {
+	for _, c := range value {
+		if c == '>' || c == '\n' || c == '\r' || (lineage && c == ';') {
+			c = '_'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
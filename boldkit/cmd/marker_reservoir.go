@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"cmp"
+	"hash/fnv"
+	"maps"
+	"math/rand/v2"
+	"slices"
+)
+
+// markerSample is a record held in a -max-per-marker reservoir. Its byte
+// fields share one buffer.
+type markerSample struct {
+	line   int64
+	code   string // sanitized marker code, before alias folding
+	pid    []byte
+	header []byte
+	seq    []byte
+}
+
+// markerReservoir samples the records of one output file.
+type markerReservoir struct {
+	key, marker, rel, name string
+	rng                    *rand.Rand
+	seen                   int64
+	samples                []markerSample
+}
+
+// markerReservoirs keeps a uniform random sample of at most max records per
+// output FASTA, by reservoir sampling (Algorithm R), so memory stays O(max)
+// per file whatever the input size. Each file draws from its own generator,
+// seeded by seed and its key, so a sample depends only on the seed and the
+// order of that file's records.
+type markerReservoirs struct {
+	max   int
+	seed  uint64
+	byKey map[string]*markerReservoir
+}
+
+func newMarkerReservoirs(max int, seed uint64) *markerReservoirs {
+	return &markerReservoirs{max: max, seed: seed, byKey: make(map[string]*markerReservoir)}
+}
+
+// add offers a record bound for the FASTA of key, split value name (empty
+// without -split-by). It returns the processid and line of the record it
+// drops, the offered one or one it replaces, with ok false when none is. The
+// byte slices are copied.
+func (rs *markerReservoirs) add(key, marker, rel, name, code string, line int64, pid, header, seq []byte) (droppedPID []byte, droppedLine int64, ok bool) {
+	r := rs.byKey[key]
+	if r == nil {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		r = &markerReservoir{key: key, marker: marker, rel: rel, name: name, rng: rand.New(rand.NewPCG(rs.seed, h.Sum64()))}
+		rs.byKey[key] = r
+	}
+	r.seen++
+	slot := len(r.samples)
+	if slot >= rs.max {
+		j := r.rng.Int64N(r.seen)
+		if j >= int64(rs.max) {
+			return pid, line, true
+		}
+		slot = int(j)
+		old := r.samples[slot]
+		droppedPID, droppedLine, ok = old.pid, old.line, true
+	} else {
+		r.samples = append(r.samples, markerSample{})
+	}
+	data := make([]byte, 0, len(pid)+len(header)+len(seq))
+	data = append(append(append(data, pid...), header...), seq...)
+	r.samples[slot] = markerSample{
+		line:   line,
+		code:   code,
+		pid:    data[:len(pid)],
+		header: data[len(pid) : len(pid)+len(header)],
+		seq:    data[len(pid)+len(header):],
+	}
+	return droppedPID, droppedLine, ok
+}
+
+// drain calls write with the kept records of each FASTA, FASTAs in key
+// order and records in input order.
+func (rs *markerReservoirs) drain(write func(r *markerReservoir, s markerSample) error) error {
+	for _, key := range slices.Sorted(maps.Keys(rs.byKey)) {
+		r := rs.byKey[key]
+		slices.SortFunc(r.samples, func(a, b markerSample) int { return cmp.Compare(a.line, b.line) })
+		for _, s := range r.samples {
+			if err := write(r, s); err != nil {
+				return err
+			}
+		}
+		r.samples = nil
+	}
+	return nil
+}
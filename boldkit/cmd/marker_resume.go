@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// markersStateFile is the -resume checkpoint of markers, in its output
+// directory.
+const markersStateFile = ".markers.state"
+
+// defaultCheckpointInterval is how often markers -resume checkpoints.
+const defaultCheckpointInterval = time.Minute
+
+// markersState is a markers -resume checkpoint: the last input line whose
+// records are in the marker FASTAs, the committed size of each FASTA, and the
+// counts markers_summary.json is built from. A run resuming from it
+// truncates the FASTAs to those sizes and continues after that line.
+type markersState struct {
+	reportHeader
+	Input string `json:"input"`
+	// InputSize and InputModTime identify the input the checkpoint was
+	// taken over; a changed input cannot be resumed.
+	InputSize    int64  `json:"input_size"`
+	InputModTime string `json:"input_mod_time"`
+	// Options describes the flags that shape the FASTAs, which a resumed
+	// run must share.
+	Options string `json:"options"`
+	Line    int64  `json:"line"`
+	// Offset is the input byte offset of Line, where plain TSV input is
+	// resumed.
+	Offset int64 `json:"offset"`
+	// Project holds the input columns of the fields markers reads, in the
+	// order it reads them.
+	Project []int                       `json:"project"`
+	Columns map[string]int              `json:"columns"`
+	Files   map[string]markerFileState  `json:"files"`
+	Before  map[string]int64            `json:"before"`
+	After   map[string]int64            `json:"after"`
+	Skipped map[string]int64            `json:"skipped"`
+	Split   map[string]map[string]int64 `json:"split"`
+	Markers map[string]*markerStats     `json:"markers"`
+}
+
+// markerFileState is a marker FASTA in a checkpoint, by writer key.
+type markerFileState struct {
+	Marker string `json:"marker"`
+	Path   string `json:"path"` // relative to the output directory
+	Size   int64  `json:"size"`
+	// Uncompressed is the data written to bgzip output, which the blocks
+	// appended on resume continue from.
+	Uncompressed uint64 `json:"uncompressed,omitempty"`
+}
+
+// newMarkersState returns an empty checkpoint of input under options.
+func newMarkersState(input, options string) (*markersState, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, fmt.Errorf("stat input: %w", err)
+	}
+	return &markersState{
+		reportHeader: newReportHeader("markers-state"),
+		Input:        input,
+		InputSize:    info.Size(),
+		InputModTime: info.ModTime().UTC().Format(time.RFC3339Nano),
+		Options:      options,
+	}, nil
+}
+
+// markersResumeOptions describes the settings a resumed markers run must
+// share with the run that checkpointed.
+func markersResumeOptions(gzipOut bool, cfg inputConfig) string {
+	header := ""
+	if cfg.HeaderFormat != nil {
+		header = fmt.Sprintf("%v", cfg.HeaderFormat.parts)
+	}
+	aliases := "none"
+	if cfg.MarkerAliases != nil {
+		aliases = cfg.MarkerAliases.source
+	}
+	return fmt.Sprintf("gzip=%t bgzip=%t wrap=%d sanitize=%s aliases=%s markers=%v exclude=%v split-by=%q header=%s filter=%+v",
+		gzipOut, cfg.BGZip, cfg.Wrap, cfg.Sanitize, aliases,
+		slices.Sorted(maps.Keys(cfg.Markers.include)), slices.Sorted(maps.Keys(cfg.Markers.exclude)), cfg.SplitBy, header, cfg.SeqFilter)
+}
+
+// loadMarkersState reads the checkpoint in outDir, or returns nil when there
+// is none.
+func loadMarkersState(outDir string) (*markersState, error) {
+	path := filepath.Join(outDir, markersStateFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var state markersState
+	if err := decodeReport("markers-state", data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// resumableFrom reports why prev, a checkpoint in the output directory,
+// cannot be resumed by the run s describes.
+func (s *markersState) resumableFrom(prev *markersState) error {
+	switch {
+	case prev.Input != s.Input:
+		return fmt.Errorf("-resume: the checkpoint is of input %s, not %s; rerun with -force", prev.Input, s.Input)
+	case prev.InputSize != s.InputSize || prev.InputModTime != s.InputModTime:
+		return fmt.Errorf("-resume: %s changed since the checkpoint; rerun with -force", s.Input)
+	case prev.Options != s.Options:
+		return fmt.Errorf("-resume: options changed since the checkpoint (%s, now %s); rerun with -force", prev.Options, s.Options)
+	}
+	return nil
+}
+
+// write replaces the checkpoint in outDir. The new state is written aside
+// and renamed over the old, so a crash leaves one or the other.
+func (s *markersState) write(outDir string) error {
+	path := filepath.Join(outDir, markersStateFile)
+	tmp := path + ".tmp"
+	if err := writeReportJSON(tmp, s); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// seekableInput reports whether a resumed parse of path can start at a byte
+// offset: plain TSV can, gzip and Parquet are read again from the start.
+func seekableInput(path string) bool {
+	return !isParquetPath(path) && !strings.HasSuffix(path, ".gz")
+}
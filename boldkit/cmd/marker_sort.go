@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// defaultMarkerSortBudget is the memory markers -sort-output buffers records
+// in before spilling a sorted run to disk.
+const defaultMarkerSortBudget = 512 << 20
+
+// markerSortRecordOverhead approximates the memory of a buffered record
+// beyond its bytes.
+const markerSortRecordOverhead = 96
+
+// markerSortRecord is a record -sort-output holds back: its FASTA header and
+// sequence, ordered by output file, then processid, then input line.
+type markerSortRecord struct {
+	key    string
+	id     []byte
+	line   int64
+	header []byte
+	seq    []byte
+}
+
+func compareMarkerSortRecords(a, b *markerSortRecord) int {
+	if c := cmp.Compare(a.key, b.key); c != 0 {
+		return c
+	}
+	if c := bytes.Compare(a.id, b.id); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.line, b.line)
+}
+
+// markerSorter buffers marker records so they can be written sorted by
+// processid, whatever order the parser delivers them in. Past budget bytes
+// the buffer is sorted and spilled as a run file under dir; drain merges the
+// runs with what is left in memory.
+type markerSorter struct {
+	dir    string
+	budget int64
+	mem    []*markerSortRecord
+	used   int64
+	runs   []*os.File
+	files  map[string][2]string // key -> marker, path relative to the output
+	buf    []byte
+	spills int
+}
+
+func newMarkerSorter(dir string, budget int64) *markerSorter {
+	if budget <= 0 {
+		budget = defaultMarkerSortBudget
+	}
+	return &markerSorter{dir: dir, budget: budget, files: make(map[string][2]string)}
+}
+
+// add buffers a record bound for the writer of key. The byte slices are
+// copied.
+func (s *markerSorter) add(key, marker, rel string, id []byte, line int64, header, seq []byte) error {
+	if _, ok := s.files[key]; !ok {
+		s.files[key] = [2]string{marker, rel}
+	}
+	// One allocation holds the ID, header, and sequence.
+	data := make([]byte, 0, len(id)+len(header)+len(seq))
+	data = append(append(append(data, id...), header...), seq...)
+	rec := &markerSortRecord{
+		key:    key,
+		id:     data[:len(id)],
+		line:   line,
+		header: data[len(id) : len(id)+len(header)],
+		seq:    data[len(id)+len(header):],
+	}
+	s.mem = append(s.mem, rec)
+	s.used += int64(len(data)+len(key)) + markerSortRecordOverhead
+	if s.used >= s.budget {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill writes the buffered records, sorted, to a new run file: per record
+// the key, ID, header, and sequence, each after its uvarint length, and the
+// uvarint input line.
+func (s *markerSorter) spill() error {
+	slices.SortFunc(s.mem, compareMarkerSortRecords)
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("create scratch dir: %w", err)
+	}
+	f, err := os.CreateTemp(s.dir, "markers_sort_*.run")
+	if err != nil {
+		return fmt.Errorf("create sort run: %w", err)
+	}
+	s.runs = append(s.runs, f)
+	w := bufio.NewWriterSize(f, writerBufferSize)
+	for _, rec := range s.mem {
+		buf := binary.AppendUvarint(s.buf[:0], uint64(len(rec.key)))
+		buf = append(buf, rec.key...)
+		for _, field := range [][]byte{rec.id, rec.header, rec.seq} {
+			buf = binary.AppendUvarint(buf, uint64(len(field)))
+			buf = append(buf, field...)
+		}
+		buf = binary.AppendUvarint(buf, uint64(rec.line))
+		s.buf = buf
+		if _, err := w.Write(buf); err != nil {
+			return fmt.Errorf("write sort run: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write sort run: %w", err)
+	}
+	clear(s.mem)
+	s.mem, s.used = s.mem[:0], 0
+	s.spills++
+	return nil
+}
+
+// drain calls write with every record in sorted order, with the marker and
+// relative path its key was added with.
+func (s *markerSorter) drain(write func(key, marker, rel string, header, seq []byte) error) error {
+	slices.SortFunc(s.mem, compareMarkerSortRecords)
+	h := make(markerSortHeap, 0, len(s.runs)+1)
+	if len(s.mem) > 0 {
+		h = append(h, &markerSortCursor{mem: s.mem})
+	}
+	for _, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("read sort run: %w", err)
+		}
+		h = append(h, &markerSortCursor{r: bufio.NewReaderSize(f, writerBufferSize)})
+	}
+	live := h[:0]
+	for _, c := range h {
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			live = append(live, c)
+		}
+	}
+	h = live
+	heap.Init(&h)
+	for len(h) > 0 {
+		c := h[0]
+		file := s.files[c.rec.key]
+		if err := write(c.rec.key, file[0], file[1], c.rec.header, c.rec.seq); err != nil {
+			return err
+		}
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// close removes the run files. Later calls do nothing.
+func (s *markerSorter) close() error {
+	var first error
+	for _, f := range s.runs {
+		_ = f.Close()
+		if err := os.Remove(f.Name()); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.runs = nil
+	return first
+}
+
+// markerSortCursor reads the records of one run, or of the in-memory
+// buffer, in order. A record read from a run is valid until the next call.
+type markerSortCursor struct {
+	mem []*markerSortRecord
+	r   *bufio.Reader
+	rec *markerSortRecord
+	// scratch holds the fields of the current run record.
+	scratch [4][]byte
+	runRec  markerSortRecord
+}
+
+func (c *markerSortCursor) next() (bool, error) {
+	if c.r == nil {
+		if len(c.mem) == 0 {
+			return false, nil
+		}
+		c.rec, c.mem = c.mem[0], c.mem[1:]
+		return true, nil
+	}
+	for i := range c.scratch {
+		n, err := binary.ReadUvarint(c.r)
+		if err != nil {
+			if i == 0 && errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, fmt.Errorf("read sort run: %w", err)
+		}
+		if uint64(cap(c.scratch[i])) < n {
+			c.scratch[i] = make([]byte, n)
+		}
+		c.scratch[i] = c.scratch[i][:n]
+		if _, err := io.ReadFull(c.r, c.scratch[i]); err != nil {
+			return false, fmt.Errorf("read sort run: %w", err)
+		}
+	}
+	line, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return false, fmt.Errorf("read sort run: %w", err)
+	}
+	c.runRec = markerSortRecord{key: string(c.scratch[0]), id: c.scratch[1], line: int64(line), header: c.scratch[2], seq: c.scratch[3]}
+	c.rec = &c.runRec
+	return true, nil
+}
+
+// markerSortHeap orders cursors by their current record.
+type markerSortHeap []*markerSortCursor
+
+func (h markerSortHeap) Len() int { return len(h) }
+func (h markerSortHeap) Less(i, j int) bool {
+	return compareMarkerSortRecords(h[i].rec, h[j].rec) < 0
+}
+func (h markerSortHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *markerSortHeap) Push(x any)   { *h = append(*h, x.(*markerSortCursor)) }
+func (h *markerSortHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,509 @@
+package cmd
+
+import (
+	"bufio"
+	"cmp"
+	"container/list"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/pgzip"
+)
+
+// markerOutput selects how marker FASTAs are written.
+type markerOutput struct {
+	gzip        bool
+	gzipWorkers int
+	bgzip       bool
+	index       bool
+	wrap        int
+	// maxOpen caps the FASTAs open at once (<= 0 is no cap).
+	maxOpen int
+	// queueBytes caps the record bytes queued to the writer goroutines; 0
+	// is defaultMarkerQueueBytes and < 0 writes on the caller's goroutine.
+	queueBytes int64
+}
+
+// defaultMarkerQueueBytes is the markers -write-buffer default.
+const defaultMarkerQueueBytes = 256 << 20
+
+// markerQueueLen is the records or commands a writer goroutine's queue holds.
+const markerQueueLen = 256
+
+// markerOp is a record for a writer goroutine to write, or, with ack set, a
+// request to close its file and report the writer's error on ack.
+type markerOp struct {
+	data   []byte // header, then sequence
+	header int
+	ack    chan error
+}
+
+type markerWriter struct {
+	marker string
+	path   string
+	file   *meteredFile // nil while closed for another file
+	buf    *bufio.Writer
+	gz     io.Closer
+	bgzf   *bgzfWriter
+	fasta  fastaWriter
+	elem   *list.Element // in markerWriters.open while file is set
+	closed bool
+	// appending is set once the file exists, so it is reopened for append.
+	appending bool
+
+	// With a writer pool, ops reach the writer's goroutine on queue, which
+	// closes done when it returns. err is the goroutine's first error.
+	queue chan markerOp
+	done  chan struct{}
+	err   error
+}
+
+// open creates the FASTA, or reopens it for append after closeFile. Appended
+// gzip output starts a new gzip member, and appended bgzip output new blocks
+// that continue the block offsets and .fai entries kept from before.
+func (w *markerWriter) open(out markerOutput, appending bool) error {
+	var (
+		f    *meteredFile
+		err  error
+		size int64
+	)
+	if appending {
+		if f, err = appendFile(w.path); err != nil {
+			return fmt.Errorf("reopen %s: %w", w.path, err)
+		}
+		info, err := f.f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("reopen %s: %w", w.path, err)
+		}
+		size = info.Size()
+	} else {
+		// Indexes left by an earlier run would describe the old file.
+		for _, suffix := range []string{".fai", ".gzi"} {
+			if err := removeIfExists(w.path + suffix); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+			return fmt.Errorf("create %s: %w", filepath.Dir(w.path), err)
+		}
+		if f, err = createFile(w.path); err != nil {
+			return fmt.Errorf("create %s: %w", w.path, err)
+		}
+	}
+	w.file = f
+	w.appending = true
+	switch {
+	case out.bgzip:
+		z := newBGZFWriter(f)
+		if w.bgzf != nil {
+			// The earlier blocks end with an EOF block the offsets skip.
+			z.next = bgzfBlockStart{compressed: uint64(size), uncompressed: w.bgzf.next.uncompressed}
+			z.starts = w.bgzf.starts
+		}
+		w.bgzf = z
+		w.gz = z
+		w.buf = bufio.NewWriterSize(z, writerBufferSize)
+	case out.gzip:
+		gzipWorkers := out.gzipWorkers
+		if gzipWorkers <= 0 {
+			gzipWorkers = runtime.GOMAXPROCS(0)
+		}
+		pw, err := pgzip.NewWriterLevel(f, pgzip.DefaultCompression)
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("create gzip writer: %w", err)
+		}
+		// A fixed block size and a header without a name or modification
+		// time keep the output the same for any worker count, which
+		// -sort-output relies on.
+		if err := pw.SetConcurrency(1<<20, gzipWorkers); err != nil {
+			_ = pw.Close()
+			_ = f.Close()
+			return fmt.Errorf("set gzip concurrency: %w", err)
+		}
+		w.gz = pw
+		w.buf = bufio.NewWriterSize(pw, writerBufferSize)
+	default:
+		w.buf = bufio.NewWriterSize(f, writerBufferSize)
+	}
+	fai := w.fasta.fai
+	w.fasta = newFastaWriter(w.buf, out.wrap)
+	if out.index {
+		if fai == nil {
+			fai = newFaiBuilder(out.wrap)
+		}
+		w.fasta.fai = fai
+	}
+	return nil
+}
+
+// closeFile flushes and closes the open file, completing its gzip member or
+// bgzip blocks, so it can be reopened for append.
+func (w *markerWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.buf.Flush()
+	if w.gz != nil {
+		if gzErr := w.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	return err
+}
+
+// run writes the records queued to w, opening its file as needed, until the
+// queue is closed. After an error the rest are dropped, and fail is called
+// once.
+func (w *markerWriter) run(out markerOutput, budget *byteBudget, fail func(error)) {
+	defer close(w.done)
+	for op := range w.queue {
+		if op.ack != nil {
+			if err := w.closeFile(); err != nil && w.err == nil {
+				w.err = fmt.Errorf("close %s: %w", w.path, err)
+				fail(w.err)
+			}
+			op.ack <- w.err
+			continue
+		}
+		if w.err == nil {
+			if w.file == nil {
+				w.err = w.open(out, w.appending)
+			}
+			if w.err == nil {
+				if err := w.fasta.WriteBytes(op.data[:op.header], op.data[op.header:]); err != nil {
+					w.err = fmt.Errorf("write marker %s: %w", w.marker, err)
+				}
+			}
+			if w.err != nil {
+				fail(w.err)
+			}
+		}
+		budget.release(int64(len(op.data)))
+	}
+}
+
+// close finishes the FASTA and writes its indexes: the .gzi of bgzip
+// output, and the .fai under -index. Later calls do nothing.
+func (w *markerWriter) close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+	if w.bgzf != nil {
+		if err := writeGZIFile(w.path+".gzi", w.bgzf.starts); err != nil {
+			return err
+		}
+	}
+	if w.fasta.fai == nil {
+		return nil
+	}
+	return writeFai(w.path+".fai", w.fasta.fai.entries)
+}
+
+// markerWriters holds the FASTAs of a markers run, at most out.maxOpen of
+// them open. Opening another closes the least recently written, which is
+// reopened for append when written again.
+//
+// Unless out.queueBytes is negative, each FASTA is written and compressed by
+// its own goroutine, so markers compress in parallel; write only queues the
+// record. Closing a FASTA for another waits for its queue to drain, which
+// keeps the cap on open files.
+type markerWriters struct {
+	outDir string
+	out    markerOutput
+	byKey  map[string]*markerWriter
+	open   *list.List // open writers, most recently written first
+
+	budget *byteBudget // nil writes on the caller's goroutine
+	failed atomic.Pointer[error]
+}
+
+func newMarkerWriters(outDir string, out markerOutput) *markerWriters {
+	ws := &markerWriters{outDir: outDir, out: out, byKey: make(map[string]*markerWriter), open: list.New()}
+	if out.queueBytes >= 0 {
+		ws.budget = newByteBudget(cmp.Or(out.queueBytes, defaultMarkerQueueBytes))
+	}
+	return ws
+}
+
+// write writes a record of marker to the FASTA of key, rel under the output
+// directory. With a writer pool the record is copied and queued, and a
+// failed write surfaces on a later call or from close.
+func (ws *markerWriters) write(key, marker, rel string, header, seq []byte) error {
+	if p := ws.failed.Load(); p != nil {
+		return *p
+	}
+	w, err := ws.get(key, marker, rel)
+	if err != nil {
+		return err
+	}
+	if ws.budget == nil {
+		if err := w.fasta.WriteBytes(header, seq); err != nil {
+			return fmt.Errorf("write marker %s: %w", marker, err)
+		}
+		return nil
+	}
+	data := make([]byte, 0, len(header)+len(seq))
+	data = append(append(data, header...), seq...)
+	ws.budget.acquire(int64(len(data)))
+	w.queue <- markerOp{data: data, header: len(header)}
+	return nil
+}
+
+// get returns the writer of key, which writes marker records to rel under
+// the output directory, making it the most recently written. Without a
+// writer pool its file is open on return.
+func (ws *markerWriters) get(key, marker, rel string) (*markerWriter, error) {
+	w, ok := ws.byKey[key]
+	if ok && w.elem != nil {
+		ws.open.MoveToFront(w.elem)
+		return w, nil
+	}
+	if ws.out.maxOpen > 0 && ws.open.Len() >= ws.out.maxOpen {
+		if err := ws.closeFile(ws.open.Back().Value.(*markerWriter)); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		w = &markerWriter{marker: marker, path: filepath.Join(ws.outDir, rel)}
+		ws.byKey[key] = w
+	}
+	if ws.budget != nil {
+		if w.queue == nil {
+			w.queue, w.done = make(chan markerOp, markerQueueLen), make(chan struct{})
+			go w.run(ws.out, ws.budget, ws.fail)
+		}
+	} else if err := w.open(ws.out, w.appending); err != nil {
+		return nil, err
+	}
+	w.elem = ws.open.PushFront(w)
+	return w, nil
+}
+
+// closeFile closes the file of an open writer, after the records queued to
+// it, and takes it off the open list.
+func (ws *markerWriters) closeFile(w *markerWriter) error {
+	ws.open.Remove(w.elem)
+	w.elem = nil
+	if w.queue == nil {
+		if err := w.closeFile(); err != nil {
+			return fmt.Errorf("close %s: %w", w.path, err)
+		}
+		return nil
+	}
+	ack := make(chan error)
+	w.queue <- markerOp{ack: ack}
+	return <-ack
+}
+
+func (ws *markerWriters) fail(err error) {
+	ws.failed.CompareAndSwap(nil, &err)
+}
+
+// close drains the writer goroutines and finishes every FASTA, returning
+// the first error. Later calls do nothing.
+func (ws *markerWriters) close() error {
+	var first error
+	keys := slices.Sorted(maps.Keys(ws.byKey))
+	for _, key := range keys {
+		if w := ws.byKey[key]; w.queue != nil && !w.closed {
+			close(w.queue)
+		}
+	}
+	for _, key := range keys {
+		w := ws.byKey[key]
+		if w.queue != nil && !w.closed {
+			<-w.done
+			if w.err != nil && first == nil {
+				first = w.err
+			}
+		}
+		if err := w.close(); err != nil && first == nil {
+			first = fmt.Errorf("close %s: %w", w.path, err)
+		}
+		w.elem = nil
+	}
+	ws.open.Init()
+	return first
+}
+
+// checkpoint closes the open FASTAs, completing their gzip members or bgzip
+// blocks, and returns every FASTA's committed state by key. Written again,
+// the FASTAs are reopened for append.
+func (ws *markerWriters) checkpoint() (map[string]markerFileState, error) {
+	for ws.open.Len() > 0 {
+		if err := ws.closeFile(ws.open.Front().Value.(*markerWriter)); err != nil {
+			return nil, err
+		}
+	}
+	files := make(map[string]markerFileState, len(ws.byKey))
+	for key, w := range ws.byKey {
+		info, err := os.Stat(w.path)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %s: %w", w.path, err)
+		}
+		rel, err := filepath.Rel(ws.outDir, w.path)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %s: %w", w.path, err)
+		}
+		st := markerFileState{Marker: w.marker, Path: filepath.ToSlash(rel), Size: info.Size()}
+		if w.bgzf != nil {
+			st.Uncompressed = w.bgzf.next.uncompressed
+		}
+		files[key] = st
+	}
+	return files, nil
+}
+
+// restore truncates the FASTAs of a checkpoint to their committed sizes and
+// takes them on for append. Marker FASTAs the checkpoint does not list were
+// started after it and are removed.
+func (ws *markerWriters) restore(files map[string]markerFileState) error {
+	known := make(map[string]bool, len(files))
+	for _, key := range slices.Sorted(maps.Keys(files)) {
+		st := files[key]
+		path := filepath.Join(ws.outDir, filepath.FromSlash(st.Path))
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("resume %s: %w", path, err)
+		}
+		if info.Size() < st.Size {
+			return fmt.Errorf("resume %s: %d bytes, shorter than the %d checkpointed", path, info.Size(), st.Size)
+		}
+		if err := os.Truncate(path, st.Size); err != nil {
+			return fmt.Errorf("resume %s: %w", path, err)
+		}
+		w := &markerWriter{marker: st.Marker, path: path, appending: true}
+		if ws.out.bgzip {
+			starts, err := scanBGZFFile(path)
+			if err != nil {
+				return fmt.Errorf("resume %s: %w", path, err)
+			}
+			w.bgzf = &bgzfWriter{next: bgzfBlockStart{uncompressed: st.Uncompressed}, starts: starts}
+		}
+		ws.byKey[key] = w
+		known[path] = true
+	}
+	for _, pattern := range []string{"*.fasta", "*.fasta.gz", "*/*.fasta", "*/*.fasta.gz"} {
+		matches, err := filepath.Glob(filepath.Join(ws.outDir, pattern))
+		if err != nil {
+			return err
+		}
+		for _, path := range matches {
+			if known[path] {
+				continue
+			}
+			for _, p := range []string{path, path + ".fai", path + ".gzi"} {
+				if err := removeIfExists(p); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scanBGZFFile returns the block starts of the BGZF file at path.
+func scanBGZFFile(path string) ([]bgzfBlockStart, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return scanBGZFBlocks(f, info.Size())
+}
+
+// removeSparse deletes the closed FASTAs, and their indexes, of the markers
+// with fewer than minRecords records, returning those markers' counts.
+// Directories -split-by emptied are removed too.
+func (ws *markerWriters) removeSparse(counts map[string]int64, minRecords int64) (map[string]int64, error) {
+	removed := make(map[string]int64)
+	dirs := make(map[string]bool)
+	for _, key := range slices.Sorted(maps.Keys(ws.byKey)) {
+		w := ws.byKey[key]
+		if counts[w.marker] >= minRecords {
+			continue
+		}
+		for _, p := range []string{w.path, w.path + ".fai", w.path + ".gzi"} {
+			if err := removeIfExists(p); err != nil {
+				return nil, err
+			}
+		}
+		if dir := filepath.Dir(w.path); dir != filepath.Clean(ws.outDir) {
+			dirs[dir] = true
+		}
+		removed[w.marker] = counts[w.marker]
+	}
+	for dir := range dirs {
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("remove %s: %w", dir, err)
+		}
+	}
+	if len(removed) > 0 {
+		logf("markers: removed the FASTAs of %d markers with fewer than %d records: %s", len(removed), minRecords, strings.Join(slices.Sorted(maps.Keys(removed)), ","))
+	}
+	return removed, nil
+}
+
+// byteBudget bounds the bytes held by queued records. acquire blocks while
+// they would exceed the limit, but always admits a record when none are held,
+// however large.
+type byteBudget struct {
+	mu    sync.Mutex
+	cond  sync.Cond
+	used  int64
+	limit int64
+}
+
+func newByteBudget(limit int64) *byteBudget {
+	b := &byteBudget{limit: limit}
+	b.cond.L = &b.mu
+	return b
+}
+
+func (b *byteBudget) acquire(n int64) {
+	b.mu.Lock()
+	for b.used > 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+}
+
+func (b *byteBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// markerPathName makes a marker or split value safe as a path element.
+func markerPathName(name string) string {
+	if name == "." || name == ".." {
+		return strings.Repeat("_", len(name))
+	}
+	return name
+}
@@ -1,38 +1,186 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sync"
-
-	"github.com/klauspost/pgzip"
+	"strings"
+	"time"
 )
 
-type markerWriter struct {
-	file *os.File
-	buf  *bufio.Writer
-	gz   io.Closer
+// markerSelection limits which markers get a FASTA. Markers are matched
+// without case, after alias folding.
+type markerSelection struct {
+	include map[string]bool // empty keeps every marker not excluded
+	exclude map[string]bool
+	// minRecords drops marker FASTAs left with fewer records.
+	minRecords int64
+}
+
+func newMarkerSelection(include, exclude string, minRecords int, sanitize nameSanitizer) (markerSelection, error) {
+	if minRecords < 0 {
+		return markerSelection{}, errors.New("min-records must be >= 0")
+	}
+	sel := markerSelection{minRecords: int64(minRecords)}
+	set := func(raw string) map[string]bool {
+		var m map[string]bool
+		for _, marker := range splitList(raw) {
+			if m == nil {
+				m = make(map[string]bool)
+			}
+			m[strings.ToLower(sanitize.taxon(marker))] = true
+		}
+		return m
+	}
+	sel.include, sel.exclude = set(include), set(exclude)
+	for marker := range sel.exclude {
+		if sel.include[marker] {
+			return markerSelection{}, fmt.Errorf("marker %q both included and excluded", marker)
+		}
+	}
+	return sel, nil
+}
+
+// keeps reports whether a canonical marker gets a FASTA.
+func (s markerSelection) keeps(marker string) bool {
+	marker = strings.ToLower(marker)
+	if len(s.include) > 0 && !s.include[marker] {
+		return false
+	}
+	return !s.exclude[marker]
+}
+
+// markerSeqFilter gates marker records on their sequence. The zero value
+// keeps every record with a base and strips ambiguity codes.
+type markerSeqFilter struct {
+	mode seqFilterMode
+	// minLength is the fewest bases a written sequence may have.
+	minLength int
+	// maxAmbig is the largest fraction of ambiguity codes among the A, C,
+	// G, T, and ambiguity codes of the input sequence; checked when ambig
+	// is set.
+	maxAmbig float64
+	ambig    bool
+}
+
+func newMarkerSeqFilter(minLength int, maxAmbigFraction float64, keepAmbiguous bool) (markerSeqFilter, error) {
+	if minLength < 0 {
+		return markerSeqFilter{}, errors.New("min-length must be >= 0")
+	}
+	if maxAmbigFraction < 0 || maxAmbigFraction > 1 {
+		return markerSeqFilter{}, errors.New("max-ambig-fraction must be between 0 and 1")
+	}
+	f := markerSeqFilter{minLength: minLength, maxAmbig: maxAmbigFraction, ambig: maxAmbigFraction < 1}
+	if keepAmbiguous {
+		f.mode = seqKeepIUPAC
+	}
+	return f, nil
+}
+
+// ambiguous reports whether a sequence with acgt plain bases and ambiguous
+// ambiguity codes has too many of the latter.
+func (f markerSeqFilter) ambiguous(acgt, ambiguous int) bool {
+	return f.ambig && float64(ambiguous) > f.maxAmbig*float64(acgt+ambiguous)
 }
 
 func runMarkers(args []string) {
 	fs := flag.NewFlagSet("markers", flag.ExitOnError)
 	input := fs.String("input", "BOLD_Public.*/BOLD_Public.*.tsv", "BOLD input file (TSV or Parquet)")
-	outDir := fs.String("outdir", "marker_fastas", "Output directory for marker FASTAs")
+	outDir := fs.String("outdir", "", "Output directory for marker FASTAs (default: marker_fastas.<snapshot>, or "+legacyMarkerDir+" without a snapshot ID)")
+	snapshot := addSnapshotFlag(fs, "the -input filename")
 	progressOn := fs.Bool("progress", true, "Show progress bar")
 	gzipOut := fs.Bool("gzip", true, "Compress FASTA outputs to .fasta.gz")
 	force := fs.Bool("force", false, "Overwrite existing outputs")
+	progressMode := fs.String("progress-mode", "", "Progress bar unit: rows or bytes (default bytes for .gz input or with -no-count, else rows)")
 	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "Parser worker goroutines (<=0 defaults to GOMAXPROCS)")
+	inputFlags := addInputFlags(fs)
+	sampleFlags := addSampleFlags(fs)
+	wrap := fs.Int("wrap", 0, "Wrap FASTA sequences at this many bases per line (0 disables)")
+	bgzip := fs.Bool("bgzip", false, "Compress FASTA outputs as bgzip blocks, which -index can locate")
+	index := fs.Bool("index", false, "Write a samtools .fai next to each FASTA (and a .gzi with -bgzip)")
+	includeMarkers := fs.String("markers", "", "Comma-separated markers to write, after -marker-aliases folding and without case (default: all)")
+	excludeMarkers := fs.String("exclude-markers", "", "Comma-separated markers to skip, after -marker-aliases folding and without case")
+	minRecords := fs.Int("min-records", 0, "Remove marker FASTAs left with fewer than this many records (0 keeps all)")
+	markerAliasSpec := fs.String("marker-aliases", "", markerAliasesUsage)
+	headerFormat := fs.String("header-format", "", markerHeaderUsage)
+	splitBy := fs.String("split-by", "", "Input column to split each marker by, writing <outdir>/<marker>/<value>.fasta[.gz] (e.g. country/ocean or bin_uri)")
+	maxOpenFiles := fs.Int("max-open-files", 256, "Marker FASTAs kept open at once; others are closed and reopened for append as needed")
+	compression := fs.String("compression", "", compressionUsage)
+	sortOutput := fs.Bool("sort-output", false, "Write each marker FASTA sorted by processid, byte-identical across runs and -workers (buffers records, spilling past -sort-mem)")
+	sortMem := fs.String("sort-mem", "512M", "Memory budget for -sort-output before sorted runs spill to disk, e.g. 2G")
+	scratchDir := fs.String("scratch-dir", "", "Directory for -sort-output spill files (default: the output directory)")
+	resume := fs.Bool("resume", false, "Checkpoint progress to <outdir>/"+markersStateFile+" and continue an interrupted or crashed build from its last checkpoint")
+	writeBuffer := fs.String("write-buffer", "256M", "Records queued across the marker FASTA writers, which compress in parallel; 0 writes them one at a time on the parser's goroutine")
+	minLength := fs.Int("min-length", 0, "Skip records with fewer bases than this (counted as too_short; 0 keeps all)")
+	maxAmbigFraction := fs.Float64("max-ambig-fraction", 1, "Skip records whose IUPAC ambiguity codes (N included) exceed this fraction of their bases (counted as too_ambiguous; 1 disables)")
+	keepAmbiguous := fs.Bool("keep-ambiguous", false, "Write IUPAC ambiguity codes, uppercased, instead of stripping them")
+	checkpointInterval := fs.Duration("checkpoint-interval", defaultCheckpointInterval, "Time between -resume checkpoints")
+	maxPerMarker := fs.Int("max-per-marker", 0, "Keep a random sample of at most this many records per marker FASTA, per -split-by file (0 keeps all)")
+	sampleSeed := fs.Uint64("sample-seed", 1, "Seed of the -max-per-marker sample; a seed and input order always draw the same records")
 	if err := fs.Parse(args); err != nil {
 		fatalf("parse args failed: %v", err)
 	}
+	if *wrap < 0 {
+		fatalf("wrap must be >= 0")
+	}
+	if *maxPerMarker < 0 {
+		fatalf("max-per-marker must be >= 0")
+	}
+	if *bgzip && !*gzipOut {
+		fatalf("-bgzip needs -gzip")
+	}
+	legacy := compressionNone
+	if *bgzip {
+		legacy = compressionBGZF
+	} else if *gzipOut {
+		legacy = compressionGzip
+	}
+	mode, err := resolveCompression(fs, *compression, legacy)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	*gzipOut, *bgzip = mode != compressionNone, mode == compressionBGZF
+	if *index && *gzipOut && !*bgzip {
+		fatalf("-index: %v", errGzipNotIndexable)
+	}
+	if *maxOpenFiles < 1 {
+		fatalf("max-open-files must be >= 1")
+	}
+	queueBytes, err := parseByteSize(*writeBuffer)
+	if err != nil || queueBytes < 0 {
+		fatalf("invalid -write-buffer %q", *writeBuffer)
+	}
+	if queueBytes == 0 {
+		queueBytes = -1
+	}
+	if *resume {
+		switch {
+		case *force:
+			fatalf("-resume continues existing outputs; it cannot be combined with -force")
+		case *sortOutput:
+			fatalf("-resume cannot be combined with -sort-output, which writes nothing until the input is read")
+		case *index:
+			fatalf("-resume cannot be combined with -index")
+		case *maxPerMarker > 0:
+			fatalf("-resume cannot be combined with -max-per-marker, which writes nothing until the input is read")
+		}
+	}
+	sortMemory, err := parseByteSize(*sortMem)
+	if err != nil || sortMemory <= 0 {
+		fatalf("invalid -sort-mem %q", *sortMem)
+	}
+
+	snap := resolveSnapshot(*snapshot, *input)
+	if *outDir == "" {
+		*outDir = snapshotPath(legacyMarkerDir, snap)
+	}
 
-	if !*force && outputsExist(*outDir) {
+	resuming := *resume && fileExists(filepath.Join(*outDir, markersStateFile))
+	if !*force && !resuming && markerOutputsExist(*outDir) && !outputStale(*outDir) {
 		fmt.Fprintf(os.Stderr, "Marker FASTAs already exist, skipping: %s\n", *outDir)
 		return
 	}
@@ -41,188 +189,455 @@ func runMarkers(args []string) {
 		fatalf("failed to create output dir: %v", err)
 	}
 
-	totalRows := -1
-	if *progressOn {
-		count, err := RowCount(*input)
-		if err != nil {
-			fatalf("count rows failed: %v", err)
-		}
-		totalRows = int(count)
+	byteMode, err := byteProgressMode(*progressMode, *input, *inputFlags.noCount)
+	if err != nil {
+		fatalf("invalid input config: %v", err)
 	}
+	totalRows := progressTotal(*input, *progressOn, byteMode)
 
 	reportEvery := 0
 	if *progressOn {
 		reportEvery = 1
 	}
 
-	if err := buildMarkerFastas(*input, *outDir, *gzipOut, reportEvery, totalRows, *workers); err != nil {
-		fatalf("build failed: %v", err)
+	inputCfg, err := inputFlags.config()
+	if err != nil {
+		fatalf("invalid input config: %v", err)
 	}
-}
-
-func buildMarkerFastas(inputPath, outDir string, gzipOut bool, reportEvery, totalRows, workers int) error {
-	writers := make(map[string]*markerWriter)
-	defer func() {
-		for _, w := range writers {
-			_ = w.buf.Flush()
-			if w.gz != nil {
-				_ = w.gz.Close()
-			}
-			_ = w.file.Close()
+	inputCfg.ByteProgress = byteMode
+	inputCfg.Wrap = *wrap
+	inputCfg.BGZip = *bgzip
+	inputCfg.Index = *index
+	inputCfg.Snapshot = snap
+	inputCfg.SplitBy = strings.TrimSpace(*splitBy)
+	inputCfg.MaxOpenFiles = *maxOpenFiles
+	inputCfg.SortOutput, inputCfg.SortMemory, inputCfg.ScratchDir = *sortOutput, sortMemory, *scratchDir
+	inputCfg.Resume, inputCfg.CheckpointInterval = *resume, *checkpointInterval
+	inputCfg.WriteBuffer = queueBytes
+	inputCfg.MaxPerMarker, inputCfg.SampleSeed = *maxPerMarker, *sampleSeed
+	if inputCfg.MarkerAliases, err = loadMarkerAliases(*markerAliasSpec, inputCfg.Sanitize); err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	if inputCfg.HeaderFormat, err = parseMarkerHeaderFormat(*headerFormat); err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	if inputCfg.Markers, err = newMarkerSelection(*includeMarkers, *excludeMarkers, *minRecords, inputCfg.Sanitize); err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	if inputCfg.SeqFilter, err = newMarkerSeqFilter(*minLength, *maxAmbigFraction, *keepAmbiguous); err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	if inputCfg.Sample, err = sampleFlags.config(); err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	if *resume {
+		switch {
+		case inputCfg.Sample.enabled():
+			fatalf("-resume cannot be combined with sampling")
+		case inputCfg.ProvenanceDir != "":
+			fatalf("-resume cannot be combined with -provenance-dir")
+		case inputCfg.Quarantine != nil:
+			fatalf("-resume cannot be combined with -quarantine")
 		}
-	}()
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	buildErr := buildMarkerFastas(ctx, *input, *outDir, *gzipOut, reportEvery, totalRows, explicitWorkers(fs, *workers), inputCfg)
+	if err := inputCfg.Close(); err != nil && buildErr == nil {
+		buildErr = err
+	}
+	record := recordStage
+	if inputCfg.Sample.enabled() {
+		record = recordSampledStage
+	}
+	exitOnStageError(record(*outDir, "markers", *input, buildErr))
+}
 
-	progress := newProgress(totalRows, reportEvery)
-	var (
-		idxProcess = -1
-		idxMarker  = -1
-		idxNuc     = -1
-	)
+func buildMarkerFastas(ctx context.Context, inputPath, outDir string, gzipOut bool, reportEvery, totalRows, workers int, inputCfg inputConfig) error {
+	if inputCfg.Index && gzipOut && !inputCfg.BGZip {
+		return errGzipNotIndexable
+	}
+	progress, bytesBar := inputCfg.progress("markers", inputPath, totalRows, reportEvery)
+	var idxProcess, idxMarker, idxNuc int
+	var columns map[string]int
 
 	opts := DefaultOptions()
+	opts.HasHeader = true
+	if reportEvery > 0 {
+		opts.Stats = &ParseStats{}
+	}
+	// Only the three columns markers reads are split out of each row.
+	opts.ProjectNames = []string{"processid", "marker_code", "nuc"}
+	idxSplit := -1
+	if inputCfg.SplitBy != "" {
+		idxSplit = len(opts.ProjectNames)
+		opts.ProjectNames = append(opts.ProjectNames, inputCfg.SplitBy)
+	}
+	if header := inputCfg.HeaderFormat; header != nil {
+		// -header-format columns follow, and projection fails on a missing one.
+		header.bind(len(opts.ProjectNames))
+		opts.ProjectNames = append(opts.ProjectNames, header.columns...)
+	}
+	var project []int
+	projectNames := opts.ProjectNames
+	opts.OnHeader = func(h *Header) error {
+		if err := inputCfg.Header.check("markers", h.fields); err != nil {
+			return err
+		}
+		if h.Index("processid") < 0 || h.Index("marker_code") < 0 || h.Index("nuc") < 0 {
+			return errors.New("required headers missing in input TSV")
+		}
+		idxProcess, idxMarker, idxNuc = 0, 1, 2
+		columns = map[string]int{"processid": h.Index("processid"), "marker_code": h.Index("marker_code"), "nuc": h.Index("nuc")}
+		project = make([]int, len(projectNames))
+		for i, name := range projectNames {
+			project[i] = h.Index(name)
+		}
+		return nil
+	}
 	opts.StrictColumns = true
 	opts.BatchLines = 2048
-	if workers <= 0 {
-		workers = runtime.GOMAXPROCS(0)
+	opts = inputCfg.Tuning.apply(opts)
+	if workers > 0 {
+		// An explicit -workers wins over the -tuning profile.
+		opts.Workers = workers
+	}
+	out := markerOutput{gzip: gzipOut, gzipWorkers: opts.Workers, bgzip: inputCfg.BGZip, index: inputCfg.Index, wrap: inputCfg.Wrap, maxOpen: inputCfg.MaxOpenFiles, queueBytes: inputCfg.WriteBuffer}
+	ext := ".fasta"
+	if gzipOut {
+		ext += ".gz"
 	}
-	gzipWorkers := workers
-	opts.Workers = workers
+	writers := newMarkerWriters(outDir, out)
+	defer func() {
+		_ = writers.close()
+	}()
+	var sorter *markerSorter
+	if inputCfg.SortOutput {
+		dir := inputCfg.ScratchDir
+		if dir == "" {
+			dir = outDir
+		}
+		sorter = newMarkerSorter(dir, inputCfg.SortMemory)
+		defer func() {
+			_ = sorter.close()
+		}()
+	}
+	var reservoirs *markerReservoirs
+	if inputCfg.MaxPerMarker > 0 {
+		reservoirs = newMarkerReservoirs(inputCfg.MaxPerMarker, inputCfg.SampleSeed)
+	}
+	opts.QuotedFields = inputCfg.QuotedFields
 	opts.Progress = progress
-	opts.SkipProgressFirstRow = true
-
-	seqPool := sync.Pool{
-		New: func() any {
-			buf := make([]byte, 0, 2048)
-			return &buf
-		},
-	}
-	recordPool := sync.Pool{
-		New: func() any {
-			buf := make([]byte, 0, 4096)
-			return &buf
-		},
-	}
-	markerBufPool := sync.Pool{
-		New: func() any {
-			buf := make([]byte, 0, 32)
-			return &buf
-		},
-	}
-
-	err := ParseRows(inputPath, opts, func(row Row) error {
-		if idxProcess < 0 {
-			idxProcess = indexOfBytes(row.Fields, "processid")
-			idxMarker = indexOfBytes(row.Fields, "marker_code")
-			idxNuc = indexOfBytes(row.Fields, "nuc")
-			if idxProcess < 0 || idxMarker < 0 || idxNuc < 0 {
-				return errors.New("required headers missing in input TSV")
+	if bytesBar != nil {
+		opts.ByteProgress = bytesBar
+	}
+	quarantine := inputCfg.Quarantine
+	quarantine.setStage("markers")
+	opts = quarantine.apply(opts)
+	opts = inputCfg.applyMaxErrors(opts)
+	opts = inputCfg.Sample.apply(opts)
+	if inputCfg.Sample.enabled() {
+		logf("markers: sampling %s", inputCfg.Sample)
+	}
+	// Under -resume, a checkpoint left in outDir is continued: the FASTAs are
+	// truncated to it, and rows up to its line are not read again (plain
+	// TSV) or skipped (gzip and Parquet).
+	var state, resumed *markersState
+	var lineBase, skipThrough int64
+	if inputCfg.Resume {
+		var err error
+		if state, err = newMarkersState(inputPath, markersResumeOptions(gzipOut, inputCfg)); err != nil {
+			return err
+		}
+		if resumed, err = loadMarkersState(outDir); err != nil {
+			return err
+		}
+	}
+	if resumed != nil {
+		if err := state.resumableFrom(resumed); err != nil {
+			return err
+		}
+		if err := writers.restore(resumed.Files); err != nil {
+			return err
+		}
+		idxProcess, idxMarker, idxNuc = 0, 1, 2
+		columns, project = resumed.Columns, resumed.Project
+		if seekableInput(inputPath) {
+			opts.HasHeader, opts.OnHeader = false, nil
+			opts.ProjectNames, opts.ProjectColumns = nil, project
+			opts.StartOffset, lineBase = resumed.Offset, resumed.Line
+		} else {
+			skipThrough = resumed.Line
+		}
+		logf("markers: resuming after input line %d from %s", resumed.Line, filepath.Join(outDir, markersStateFile))
+	}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("parser options: %w", err)
+	}
+
+	prov, err := openProvenanceLog(inputCfg.ProvenanceDir, "markers")
+	if err != nil {
+		return err
+	}
+
+	// Rows arrive a batch at a time on one goroutine, so the scratch buffers
+	// are reused across the whole parse.
+	var (
+		lastLine      int64
+		seqBuf        = make([]byte, 0, 2048)
+		markerScratch = make([]byte, 0, 32)
+		headerBuf     = make([]byte, 0, 256)
+		splitScratch  = make([]byte, 0, 32)
+		split         = make(map[string]map[string]int64)
+		before        = make(map[string]int64)
+		after         = make(map[string]int64)
+		skipped       = make(map[string]int64)
+		keeps         = make(map[string]bool)
+		stats         = make(map[string]*markerStats)
+	)
+	if resumed != nil {
+		before, after, skipped, split, stats = resumed.Before, resumed.After, resumed.Skipped, resumed.Split, resumed.Markers
+	}
+	// doneLine and doneOffset locate the last row of the last batch written,
+	// where a checkpoint is taken.
+	var (
+		doneLine, doneOffset int64
+		checkpointed         = time.Now()
+	)
+	checkpoint := func() error {
+		files, err := writers.checkpoint()
+		if err != nil {
+			return err
+		}
+		state.Line, state.Offset, state.Files = doneLine, doneOffset, files
+		state.Project, state.Columns = project, columns
+		state.Before, state.After, state.Skipped, state.Split, state.Markers = before, after, skipped, split, stats
+		if err := state.write(outDir); err != nil {
+			return err
+		}
+		checkpointed = time.Now()
+		return nil
+	}
+	// emit counts a record as written and hands it to the sorter or the
+	// writers. sanitizedMarker is its marker code before alias folding, and
+	// name its -split-by file.
+	emit := func(sanitizedMarker, marker, key, rel, name string, line int64, pid, header, seq []byte) error {
+		before[sanitizedMarker]++
+		after[marker]++
+		stats[marker].add(len(seq))
+		if idxSplit >= 0 {
+			if split[marker] == nil {
+				split[marker] = make(map[string]int64)
 			}
-			return nil
+			split[marker][name]++
+		}
+		if sorter != nil {
+			if err := sorter.add(key, marker, rel, pid, line, header, seq); err != nil {
+				return err
+			}
+		} else if err := writers.write(key, marker, rel, header, seq); err != nil {
+			return err
 		}
 
+		if prov != nil {
+			detail := fmt.Sprintf("marker %s, %d bp", marker, len(seq))
+			if err := prov.record(string(pid), "written", detail, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	writeRow := func(row Row) error {
+		row.Line += lineBase
+		lastLine = row.Line
+		if row.Line <= skipThrough {
+			return nil
+		}
 		fields := row.Fields
 		if idxProcess >= len(fields) || idxMarker >= len(fields) || idxNuc >= len(fields) {
 			return fmt.Errorf("line %d: expected at least %d fields", row.Line, maxIndex(idxProcess, idxMarker, idxNuc)+1)
 		}
 
+		markerVal := normalizeBytes(fields[idxMarker])
+		if len(markerVal) == 0 {
+			markerVal = []byte("UNKNOWN")
+		}
+		sanitizedMarker := inputCfg.Sanitize.markerBytes(markerScratch[:0], markerVal)
+		marker := inputCfg.MarkerAliases.canonical(sanitizedMarker)
+		keep, ok := keeps[marker]
+		if !ok {
+			keep = inputCfg.Markers.keeps(marker)
+			keeps[marker] = keep
+		}
+		if !keep {
+			skipped[marker]++
+			if prov != nil {
+				return prov.record(string(fields[idxProcess]), "skipped", "marker "+marker+" not selected", row.Line)
+			}
+			return nil
+		}
+		st := stats[marker]
+		if st == nil {
+			st = &markerStats{}
+			stats[marker] = st
+		}
+
 		nuc := fields[idxNuc]
 		if len(nuc) == 0 || isNone(nuc) {
+			st.EmptyNuc++
+			if prov != nil {
+				return prov.record(string(fields[idxProcess]), "skipped", "no sequence", row.Line)
+			}
 			return nil
 		}
 
-		seqBufPtr := seqPool.Get().(*[]byte)
-		seqBuf := *seqBufPtr
-		seq := filterSeqBytes(seqBuf, nuc)
-		if len(seq) == 0 {
-			*seqBufPtr = seq[:0]
-			seqPool.Put(seqBufPtr)
+		seq, ambiguous := filterSeqBytes(seqBuf[:0], nuc, inputCfg.SeqFilter.mode)
+		seqBuf = seq[:0]
+		acgt := len(seq)
+		if inputCfg.SeqFilter.mode == seqKeepIUPAC {
+			acgt -= ambiguous
+		}
+		if acgt == 0 {
+			st.NoBases++
+			if prov != nil {
+				return prov.record(string(fields[idxProcess]), "skipped", "no ACGT bases", row.Line)
+			}
 			return nil
 		}
-
-		markerVal := normalizeBytes(fields[idxMarker])
-		if len(markerVal) == 0 {
-			markerVal = []byte("UNKNOWN")
+		if len(seq) < inputCfg.SeqFilter.minLength {
+			st.TooShort++
+			if prov != nil {
+				return prov.record(string(fields[idxProcess]), "skipped", fmt.Sprintf("%d bp, below -min-length", len(seq)), row.Line)
+			}
+			return nil
+		}
+		if inputCfg.SeqFilter.ambiguous(acgt, ambiguous) {
+			st.TooAmbiguous++
+			if prov != nil {
+				return prov.record(string(fields[idxProcess]), "skipped", fmt.Sprintf("%d of %d bases ambiguous, above -max-ambig-fraction", ambiguous, acgt+ambiguous), row.Line)
+			}
+			return nil
 		}
 
-		markerScratchPtr := markerBufPool.Get().(*[]byte)
-		markerScratch := *markerScratchPtr
-		sanitizedMarker := sanitizeMarkerBytes(markerScratch, markerVal)
-		*markerScratchPtr = markerScratch[:0]
-		markerBufPool.Put(markerScratchPtr)
+		st.Seen++
 
 		pid := fields[idxProcess]
-		w, err := getMarkerWriter(outDir, sanitizedMarker, gzipOut, gzipWorkers, writers)
+		key, rel, name := marker, markerPathName(marker)+ext, ""
+		if idxSplit >= 0 {
+			value := normalizeBytes(fields[idxSplit])
+			if len(value) == 0 {
+				value = []byte("UNKNOWN")
+			}
+			name = markerPathName(inputCfg.Sanitize.markerBytes(splitScratch[:0], value))
+			key, rel = marker+"/"+name, filepath.Join(markerPathName(marker), name+ext)
+		}
+		header := pid
+		if inputCfg.HeaderFormat != nil {
+			headerBuf = inputCfg.HeaderFormat.appendHeader(headerBuf[:0], pid, marker, fields)
+			header = headerBuf
+		}
+		if reservoirs != nil {
+			dropped, line, ok := reservoirs.add(key, marker, rel, name, sanitizedMarker, row.Line, pid, header, seq)
+			if ok && prov != nil {
+				return prov.record(string(dropped), "skipped", "not in the -max-per-marker sample", line)
+			}
+			return nil
+		}
+		return emit(sanitizedMarker, marker, key, rel, name, row.Line, pid, header, seq)
+	}
+	err = parseBatchesContext(ctx, inputPath, opts, func(rows []Row) error {
+		for _, row := range rows {
+			if err := writeRow(row); err != nil {
+				return err
+			}
+		}
+		if state == nil || len(rows) == 0 || lastLine <= skipThrough {
+			return nil
+		}
+		doneLine, doneOffset = lastLine, rows[len(rows)-1].Offset
+		if time.Since(checkpointed) < inputCfg.CheckpointInterval {
+			return nil
+		}
+		return checkpoint()
+	})
+	err = acceptRowErrors("markers", err)
+	if err != nil {
+		_ = prov.Close()
+		if state != nil && ctx.Err() != nil && doneLine > 0 {
+			// Every batch delivered was written, so the checkpoint can
+			// catch up to the last.
+			if cerr := checkpoint(); cerr != nil {
+				return errors.Join(err, cerr)
+			}
+			logf("markers: checkpointed at input line %d; rerun with -resume to continue", doneLine)
+		}
+		return interruptedAt(ctx, err, "markers", lastLine)
+	}
+	if reservoirs != nil {
+		err := reservoirs.drain(func(r *markerReservoir, rec markerSample) error {
+			return emit(rec.code, r.marker, r.key, r.rel, r.name, rec.line, rec.pid, rec.header, rec.seq)
+		})
 		if err != nil {
-			*seqBufPtr = seq[:0]
-			seqPool.Put(seqBufPtr)
 			return err
 		}
-
-		recordPtr := recordPool.Get().(*[]byte)
-		record := *recordPtr
-		record = append(record[:0], '>')
-		record = append(record, pid...)
-		record = append(record, '\n')
-		record = append(record, seq...)
-		record = append(record, '\n')
-
-		if _, err := w.buf.Write(record); err != nil {
-			*recordPtr = record[:0]
-			recordPool.Put(recordPtr)
-			*seqBufPtr = seq[:0]
-			seqPool.Put(seqBufPtr)
-			return fmt.Errorf("write marker %s: %w", sanitizedMarker, err)
+	}
+	if err := prov.Close(); err != nil {
+		return err
+	}
+	if sorter != nil {
+		if sorter.spills > 0 {
+			logf("markers: merging %d sorted runs spilled past -sort-mem", sorter.spills)
 		}
-
-		*recordPtr = record[:0]
-		recordPool.Put(recordPtr)
-		*seqBufPtr = seq[:0]
-		seqPool.Put(seqBufPtr)
-		return nil
-	})
+		if err := sorter.drain(writers.write); err != nil {
+			return err
+		}
+		if err := sorter.close(); err != nil {
+			return fmt.Errorf("remove sort runs: %w", err)
+		}
+	}
+	if err := writers.close(); err != nil {
+		return err
+	}
+	removed, err := writers.removeSparse(after, inputCfg.Markers.minRecords)
 	if err != nil {
 		return err
 	}
+	summary := newMarkersSummary(inputCfg.MarkerAliases, before, after)
+	summary.SnapshotID, summary.Columns = inputCfg.Snapshot, columns
+	summary.Skipped, summary.Removed, summary.Markers = skipped, removed, stats
+	if reservoirs != nil {
+		summary.MaxPerMarker, summary.SampleSeed = inputCfg.MaxPerMarker, inputCfg.SampleSeed
+	}
+	if idxSplit >= 0 {
+		summary.SplitBy, summary.Split = inputCfg.SplitBy, split
+	}
+	if err := summary.write(outDir); err != nil {
+		return err
+	}
+	if err := removeIfExists(filepath.Join(outDir, markersStateFile)); err != nil {
+		return err
+	}
 
 	progress.finish()
+	bytesBar.Finish()
+	logParseStats("markers", opts.Stats)
+	logMarkersSummary(summary)
+	if n := quarantine.count("markers"); n > 0 {
+		logf("markers: QUARANTINED %d malformed lines -> %s", n, quarantine.path)
+	}
 	return nil
 }
 
-func getMarkerWriter(outDir, marker string, gzipOut bool, gzipWorkers int, writers map[string]*markerWriter) (*markerWriter, error) {
-	if w, ok := writers[marker]; ok {
-		return w, nil
-	}
-	ext := ".fasta"
-	if gzipOut {
-		ext += ".gz"
+// markerOutputsExist reports whether outDir holds marker FASTAs, including
+// those -split-by writes into marker directories.
+func markerOutputsExist(outDir string) bool {
+	if outputsExist(outDir) {
+		return true
 	}
-	path := filepath.Join(outDir, marker+ext)
-	f, err := os.Create(path)
-	if err != nil {
-		return nil, fmt.Errorf("create %s: %w", path, err)
-	}
-	var gz io.Closer
-	var buf *bufio.Writer
-	if gzipOut {
-		if gzipWorkers <= 0 {
-			gzipWorkers = runtime.GOMAXPROCS(0)
+	for _, pattern := range []string{"*/*.fasta", "*/*.fasta.gz"} {
+		if files, _ := filepath.Glob(filepath.Join(outDir, pattern)); len(files) > 0 {
+			return true
 		}
-		pw, err := pgzip.NewWriterLevel(f, pgzip.DefaultCompression)
-		if err != nil {
-			_ = f.Close()
-			return nil, fmt.Errorf("create gzip writer: %w", err)
-		}
-		if err := pw.SetConcurrency(1<<20, gzipWorkers); err != nil {
-			_ = pw.Close()
-			_ = f.Close()
-			return nil, fmt.Errorf("set gzip concurrency: %w", err)
-		}
-		gz = pw
-		buf = bufio.NewWriterSize(pw, writerBufferSize)
-	} else {
-		buf = bufio.NewWriterSize(f, writerBufferSize)
-	}
-	w := &markerWriter{file: f, buf: buf, gz: gz}
-	writers[marker] = w
-	return w, nil
+	}
+	return false
 }
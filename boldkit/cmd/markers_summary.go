@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+)
+
+// markersSummary is markers_summary.json: the records written per marker
+// code before and after -marker-aliases folding, and the sequence statistics
+// of each marker FASTA.
+type markersSummary struct {
+	reportHeader
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	// Columns holds the input header indexes of the columns markers read.
+	Columns map[string]int `json:"columns"`
+	// Aliases is the alias table used: "embedded", a file, or "none".
+	Aliases string           `json:"aliases"`
+	Before  map[string]int64 `json:"before"`
+	After   map[string]int64 `json:"after"`
+	// Folded maps each code written under another name to that name.
+	Folded map[string]string `json:"folded"`
+	// Skipped counts the rows of each marker -markers or -exclude-markers
+	// left out.
+	Skipped map[string]int64 `json:"skipped,omitempty"`
+	// Removed counts the records of each marker FASTA -min-records deleted.
+	Removed map[string]int64 `json:"removed,omitempty"`
+	// SplitBy is the -split-by column, and Split the records written per
+	// marker and sanitized value of it.
+	SplitBy string                      `json:"split_by,omitempty"`
+	Split   map[string]map[string]int64 `json:"split,omitempty"`
+	// MaxPerMarker is the -max-per-marker sample size, drawn with
+	// SampleSeed.
+	MaxPerMarker int    `json:"max_per_marker,omitempty"`
+	SampleSeed   uint64 `json:"sample_seed,omitempty"`
+	// Markers holds the statistics of each selected marker, by canonical
+	// name.
+	Markers map[string]*markerStats `json:"markers"`
+}
+
+// markerStats counts the rows of one marker. Lengths are of the sequences
+// as written: ACGT only, or with IUPAC codes under -keep-ambiguous.
+type markerStats struct {
+	// Seen counts the records that passed the sequence checks, and Records
+	// those written, fewer than Seen only under -max-per-marker.
+	Seen     int64 `json:"seen"`
+	Records  int64 `json:"records"`
+	EmptyNuc int64 `json:"empty_nuc"` // empty or None nuc
+	NoBases  int64 `json:"no_bases"`  // nuc without ACGT bases
+	// TooShort and TooAmbiguous count the records -min-length and
+	// -max-ambig-fraction skipped.
+	TooShort     int64   `json:"too_short"`
+	TooAmbiguous int64   `json:"too_ambiguous"`
+	Bases        int64   `json:"bases"`
+	MinLength    int     `json:"min_length"`
+	MaxLength    int     `json:"max_length"`
+	MeanLength   float64 `json:"mean_length"`
+}
+
+func (s *markerStats) add(length int) {
+	if s.Records == 0 || length < s.MinLength {
+		s.MinLength = length
+	}
+	if length > s.MaxLength {
+		s.MaxLength = length
+	}
+	s.Records++
+	s.Bases += int64(length)
+}
+
+func (s *markerStats) finish() {
+	if s.Records > 0 {
+		s.MeanLength = float64(s.Bases) / float64(s.Records)
+	}
+}
+
+const (
+	markersSummaryFile    = "markers_summary.json"
+	markersSummaryTSVFile = "markers_summary.tsv"
+)
+
+// newMarkersSummary returns the markers_summary.json counts before and after
+// alias folding.
+func newMarkersSummary(aliases *markerAliases, before, after map[string]int64) markersSummary {
+	summary := markersSummary{
+		reportHeader: newReportHeader("markers-summary"),
+		Aliases:      "none",
+		Before:       before,
+		After:        after,
+		Folded:       make(map[string]string),
+	}
+	if aliases != nil {
+		summary.Aliases = aliases.source
+	}
+	for marker := range before {
+		if c := aliases.canonical(marker); c != marker {
+			summary.Folded[marker] = c
+		}
+	}
+	return summary
+}
+
+// write writes markers_summary.json and markers_summary.tsv into outDir.
+func (s markersSummary) write(outDir string) error {
+	for _, st := range s.Markers {
+		st.finish()
+	}
+	if err := writeReportJSON(filepath.Join(outDir, markersSummaryFile), s); err != nil {
+		return err
+	}
+	path := filepath.Join(outDir, markersSummaryTSVFile)
+	f, err := createFile(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "marker\trecords\tempty_nuc\tno_bases\ttoo_short\ttoo_ambiguous\tbases\tmin_length\tmax_length\tmean_length")
+	for _, marker := range slices.Sorted(maps.Keys(s.Markers)) {
+		st := s.Markers[marker]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.1f\n", marker, st.Records, st.EmptyNuc, st.NoBases, st.TooShort, st.TooAmbiguous, st.Bases, st.MinLength, st.MaxLength, st.MeanLength)
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeTable writes the per-marker statistics as an aligned table.
+func (s markersSummary) writeTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "marker\trecords\tempty\tno ACGT\tshort\tambiguous\tbases\tmin\tmax\tmean\t")
+	for _, marker := range slices.Sorted(maps.Keys(s.Markers)) {
+		st := s.Markers[marker]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.1f\t\n", marker, st.Records, st.EmptyNuc, st.NoBases, st.TooShort, st.TooAmbiguous, st.Bases, st.MinLength, st.MaxLength, st.MeanLength)
+	}
+	_ = tw.Flush()
+}
+
+// logMarkersSummary logs the per-marker statistics table to stderr.
+func logMarkersSummary(s markersSummary) {
+	if len(s.Markers) == 0 {
+		return
+	}
+	logf("markers: per-marker statistics")
+	s.writeTable(os.Stderr)
+}
@@ -0,0 +1,1014 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeMarkersBenchTSV writes a BOLD-shaped TSV: 80 columns of which markers
+// reads three, a handful of marker codes, and ~650 bp sequences.
+func writeMarkersBenchTSV(tb testing.TB, path string, rows int) int64 {
+	tb.Helper()
+	cols := make([]string, 80)
+	for i := range cols {
+		cols[i] = fmt.Sprintf("col%d", i)
+	}
+	cols[0], cols[20], cols[79] = "processid", "marker_code", "nuc"
+	var b strings.Builder
+	b.WriteString(strings.Join(cols, "\t") + "\n")
+	markers := []string{"COI-5P", "COI-5P", "COI-5P", "ITS", "rbcL", "matK"}
+	rng := rand.New(rand.NewSource(1))
+	seq := make([]byte, 700)
+	for r := 0; r < rows; r++ {
+		for i := range cols {
+			if i > 0 {
+				b.WriteByte('\t')
+			}
+			switch i {
+			case 0:
+				fmt.Fprintf(&b, "P%07d", r)
+			case 20:
+				b.WriteString(markers[rng.Intn(len(markers))])
+			case 79:
+				n := 600 + rng.Intn(100)
+				// Mostly clean bases, as in BOLD_Public; filterSeqBytes
+				// dominates otherwise.
+				for j := 0; j < n; j++ {
+					seq[j] = "ACGT"[rng.Intn(4)]
+				}
+				b.Write(seq[:n])
+			default:
+				b.WriteString("field")
+			}
+		}
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	return int64(b.Len())
+}
+
+func BenchmarkBuildMarkerFastas(b *testing.B) {
+	tmp := b.TempDir()
+	input := filepath.Join(tmp, "BOLD_Public.bench.tsv")
+	size := writeMarkersBenchTSV(b, input, 50_000)
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		outDir := filepath.Join(tmp, fmt.Sprint("out", i))
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			b.Fatal(err)
+		}
+		if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 0, inputConfig{}); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		_ = os.RemoveAll(outDir)
+		b.StartTimer()
+	}
+}
+
+// Wrapped marker FASTAs hold the same records as unwrapped ones.
+func TestBuildMarkerFastasWrap(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "BOLD_Public.wrap.tsv")
+	writeMarkersBenchTSV(t, input, 50)
+	read := func(wrap int) map[string][]string {
+		outDir := filepath.Join(tmp, fmt.Sprint("wrap", wrap))
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 1, inputConfig{Wrap: wrap}); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string][]string)
+		for _, marker := range []string{"COI-5P", "ITS", "rbcL", "matK"} {
+			data, err := os.ReadFile(filepath.Join(outDir, marker+".fasta"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				if wrap > 0 && len(line) > wrap && !strings.HasPrefix(line, ">") {
+					t.Fatalf("wrap %d: %s line of %d bases", wrap, marker, len(line))
+				}
+			}
+			err = parseFasta(strings.NewReader(string(data)), func(rec fastaRecord) error {
+				got[marker] = append(got[marker], rec.id+"="+string(rec.seq))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return got
+	}
+	want := read(0)
+	got := read(60)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("wrapped records differ from unwrapped ones")
+	}
+}
+
+func TestBuildMarkerFastasAliases(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	rows := "processid\tmarker_code\tnuc\n" +
+		"P1\tCOI-5P\tACGT\n" +
+		"P2\tCOI\tACGT\n" +
+		"P3\tcox1\tACGT\n" +
+		"P4\tCOI 5P\tACGT\n" +
+		"P5\tCOI-3P\tACGT\n" +
+		"P6\tITS2\tACGT\n" +
+		"P7\tITS1\tACGT\n"
+	if err := os.WriteFile(input, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	custom := filepath.Join(tmp, "aliases.json")
+	if err := os.WriteFile(custom, []byte(`{"ITS1": "ITS-1", "its*": "ITS", "coi": "COI-5P"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		spec  string
+		files map[string]string
+		after map[string]int64
+	}{
+		{
+			spec:  "",
+			files: map[string]string{"COI-5P": "P1 P2 P3 P4", "COI-3P": "P5", "ITS2": "P6", "ITS1": "P7"},
+			after: map[string]int64{"COI-5P": 4, "COI-3P": 1, "ITS2": 1, "ITS1": 1},
+		},
+		{
+			spec:  "none",
+			files: map[string]string{"COI-5P": "P1", "COI": "P2", "cox1": "P3", "COI_5P": "P4"},
+			after: map[string]int64{"COI-5P": 1, "COI": 1, "cox1": 1, "COI_5P": 1, "COI-3P": 1, "ITS2": 1, "ITS1": 1},
+		},
+		{
+			spec:  custom,
+			files: map[string]string{"COI-5P": "P1 P2", "cox1": "P3", "ITS": "P6", "ITS-1": "P7"},
+			after: map[string]int64{"COI-5P": 2, "cox1": 1, "COI_5P": 1, "COI-3P": 1, "ITS": 1, "ITS-1": 1},
+		},
+	} {
+		aliases, err := loadMarkerAliases(tc.spec, sanitizeTranslit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outDir := t.TempDir()
+		if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 1, inputConfig{Sanitize: sanitizeTranslit, MarkerAliases: aliases}); err != nil {
+			t.Fatal(err)
+		}
+		for marker, want := range tc.files {
+			data, err := os.ReadFile(filepath.Join(outDir, marker+".fasta"))
+			if err != nil {
+				t.Fatalf("%q: %v", tc.spec, err)
+			}
+			var ids []string
+			if err := parseFasta(strings.NewReader(string(data)), func(rec fastaRecord) error {
+				ids = append(ids, rec.id)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(ids, " "); got != want {
+				t.Fatalf("%q: %s = %s, want %s", tc.spec, marker, got, want)
+			}
+		}
+		var summary markersSummary
+		data, err := os.ReadFile(filepath.Join(outDir, markersSummaryFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &summary); err != nil {
+			t.Fatal(err)
+		}
+		if len(summary.Before) != 7 || summary.Before["COI_5P"] != 1 {
+			t.Fatalf("%q: before = %v", tc.spec, summary.Before)
+		}
+		if fmt.Sprint(summary.After) != fmt.Sprint(tc.after) {
+			t.Fatalf("%q: after = %v, want %v", tc.spec, summary.After, tc.after)
+		}
+	}
+
+	for _, bad := range []string{`{"COI": ""}`, `{"COI[": "COI-5P"}`, `["COI"]`} {
+		path := filepath.Join(tmp, "bad.json")
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadMarkerAliases(path, sanitizeTranslit); err == nil {
+			t.Fatalf("accepted %s", bad)
+		}
+	}
+}
+
+func TestBuildMarkerFastasSelection(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	rows := "processid\tmarker_code\tnuc\n" +
+		"P1\tCOI-5P\tACGT\n" +
+		"P2\tCOI\tACGT\n" +
+		"P3\trbcL\tACGT\n" +
+		"P4\trbcL\tACGT\n" +
+		"P5\tITS\tACGT\n" +
+		"P6\tmatK\tNNNN\n" +
+		"P7\tmatK\tACGT\n"
+	if err := os.WriteFile(input, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := loadMarkerAliases("", sanitizeTranslit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		include, exclude string
+		minRecords       int
+		files            []string
+		skipped, removed map[string]int64
+	}{
+		{
+			include: "coi-5p, RBCL,its", minRecords: 2,
+			files:   []string{"COI-5P", "rbcL"},
+			skipped: map[string]int64{"matK": 2},
+			removed: map[string]int64{"ITS": 1},
+		},
+		{
+			exclude: "MATK,coi",
+			files:   []string{"COI-5P", "ITS", "rbcL"},
+			skipped: map[string]int64{"matK": 2},
+		},
+		{
+			minRecords: 3,
+			removed:    map[string]int64{"COI-5P": 2, "rbcL": 2, "ITS": 1, "matK": 1},
+		},
+	} {
+		sel, err := newMarkerSelection(tc.include, tc.exclude, tc.minRecords, sanitizeTranslit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outDir := t.TempDir()
+		cfg := inputConfig{Sanitize: sanitizeTranslit, MarkerAliases: aliases, Markers: sel, Index: true}
+		if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 1, cfg); err != nil {
+			t.Fatal(err)
+		}
+		files, err := listMarkerFiles(outDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, strings.TrimSuffix(filepath.Base(f), ".fasta"))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.files) {
+			t.Fatalf("%+v: files = %v", tc, got)
+		}
+		if stray, _ := filepath.Glob(filepath.Join(outDir, "*.fai")); len(stray) != len(tc.files) {
+			t.Fatalf("%+v: indexes = %v", tc, stray)
+		}
+		var summary markersSummary
+		data, err := os.ReadFile(filepath.Join(outDir, markersSummaryFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &summary); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(summary.Skipped) != fmt.Sprint(tc.skipped) || fmt.Sprint(summary.Removed) != fmt.Sprint(tc.removed) {
+			t.Fatalf("%+v: skipped = %v, removed = %v", tc, summary.Skipped, summary.Removed)
+		}
+	}
+
+	if _, err := newMarkerSelection("COI-5P", "coi-5p", 0, sanitizeTranslit); err == nil {
+		t.Fatal("marker both included and excluded accepted")
+	}
+	if _, err := newMarkerSelection("", "", -1, sanitizeTranslit); err == nil {
+		t.Fatal("negative -min-records accepted")
+	}
+}
+
+func TestMarkersSummaryStats(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	rows := "nuc\textra\tmarker_code\tprocessid\n" +
+		"ACGTAC\tx\tCOI-5P\tP1\n" +
+		"AC-GT\tx\tCOI\tP2\n" +
+		"None\tx\tCOI-5P\tP3\n" +
+		"NNNN\tx\tCOI-5P\tP4\n" +
+		"ACGTACGTAC\tx\trbcL\tP5\n"
+	if err := os.WriteFile(input, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := loadMarkerAliases("", sanitizeTranslit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outDir := t.TempDir()
+	cfg := inputConfig{Sanitize: sanitizeTranslit, MarkerAliases: aliases, Snapshot: "20250101"}
+	if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 1, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var summary markersSummary
+	data, err := os.ReadFile(filepath.Join(outDir, markersSummaryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.SnapshotID != "20250101" || fmt.Sprint(summary.Columns) != "map[marker_code:2 nuc:0 processid:3]" {
+		t.Fatalf("snapshot_id = %q, columns = %v", summary.SnapshotID, summary.Columns)
+	}
+	coi := summary.Markers["COI-5P"]
+	want := markerStats{Seen: 2, Records: 2, EmptyNuc: 1, NoBases: 1, Bases: 10, MinLength: 4, MaxLength: 6, MeanLength: 5}
+	if coi == nil || *coi != want {
+		t.Fatalf("COI-5P = %+v, want %+v", coi, want)
+	}
+
+	tsv, err := os.ReadFile(filepath.Join(outDir, markersSummaryTSVFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTSV := "marker\trecords\tempty_nuc\tno_bases\ttoo_short\ttoo_ambiguous\tbases\tmin_length\tmax_length\tmean_length\n" +
+		"COI-5P\t2\t1\t1\t0\t0\t10\t4\t6\t5.0\n" +
+		"rbcL\t1\t0\t0\t0\t0\t10\t10\t10\t10.0\n"
+	if string(tsv) != wantTSV {
+		t.Fatalf("markers_summary.tsv:\n%s", tsv)
+	}
+}
+
+func TestBuildMarkerFastasHeaderFormat(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	rows := "processid\tbin_uri\tkingdom\tphylum\tclass\torder\tfamily\tgenus\tspecies\tmarker_code\tnuc\n" +
+		"P1\tBOLD:AAA0001\tAnimalia\tArthropoda\tInsecta\tLepidoptera\tNymphalidae\tDanaus\tDanaus plexippus\tCOI-5P\tACGT\n" +
+		"P2\tNone\tAnimalia\tArthropoda\tInsecta\tDiptera\tCulicidae\tAedes\tNone\tCOI\tACGT\n" +
+		"P>3\tBOLD:AAA0003\tAnimalia\tArth;ropoda\tInsecta\tDiptera\tCulicidae\tCulex\tCulex >pipiens\tCOI-5P\tACGT\n"
+	if err := os.WriteFile(input, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := loadMarkerAliases("", sanitizeTranslit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(format string) string {
+		t.Helper()
+		header, err := parseMarkerHeaderFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outDir := t.TempDir()
+		cfg := inputConfig{Sanitize: sanitizeTranslit, MarkerAliases: aliases, HeaderFormat: header, Index: true}
+		if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 1, cfg); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(outDir, "COI-5P.fasta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := read(""); got != ">P1\nACGT\n>P2\nACGT\n>P>3\nACGT\n" {
+		t.Fatalf("default headers:\n%s", got)
+	}
+	want := ">P1 Animalia;Arthropoda;Insecta;Lepidoptera;Nymphalidae;Danaus;Danaus plexippus\nACGT\n" +
+		">P2 Animalia;Arthropoda;Insecta;Diptera;Culicidae;Aedes;\nACGT\n" +
+		">P_3 Animalia;Arth_ropoda;Insecta;Diptera;Culicidae;Culex;Culex _pipiens\nACGT\n"
+	if got := read("{processid} {lineage}"); got != want {
+		t.Fatalf("lineage headers:\n%s", got)
+	}
+	want = ">P1|COI-5P|BOLD:AAA0001|Danaus plexippus\nACGT\n" +
+		">P2|COI-5P||\nACGT\n" +
+		">P_3|COI-5P|BOLD:AAA0003|Culex _pipiens\nACGT\n"
+	if got := read("{processid}|{marker}|{bin_uri}|{species}"); got != want {
+		t.Fatalf("field headers:\n%s", got)
+	}
+
+	for _, bad := range []string{"{processid} {genus}", "{processid", "processid}", "{processid}>x"} {
+		if _, err := parseMarkerHeaderFormat(bad); err == nil {
+			t.Fatalf("accepted %q", bad)
+		}
+	}
+}
+
+// BenchmarkBuildMarkerFastasWriters compares gzip output written on the
+// parser goroutine with the per-marker writer pool.
+func BenchmarkBuildMarkerFastasWriters(b *testing.B) {
+	tmp := b.TempDir()
+	input := filepath.Join(tmp, "BOLD_Public.bench.tsv")
+	size := writeMarkersBenchTSV(b, input, 50_000)
+	for _, bench := range []struct {
+		name       string
+		queueBytes int64
+	}{{"serial", -1}, {"pool", 0}} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				outDir := filepath.Join(tmp, fmt.Sprint(bench.name, i))
+				if err := os.MkdirAll(outDir, 0o755); err != nil {
+					b.Fatal(err)
+				}
+				if err := buildMarkerFastas(context.Background(), input, outDir, true, 0, -1, 0, inputConfig{WriteBuffer: bench.queueBytes}); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				_ = os.RemoveAll(outDir)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestBuildMarkerFastasSplitBy(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	var b strings.Builder
+	b.WriteString("processid\tbin_uri\tmarker_code\tnuc\n")
+	rng := rand.New(rand.NewSource(1))
+	want := make(map[string][]string) // file -> ids
+	bins := []string{"BOLD:AAA0001", "BOLD:AAA0002", "BOLD:AAA0003", "None", ".."}
+	for i := range 60 {
+		bin := bins[i%len(bins)]
+		marker := []string{"COI-5P", "rbcL"}[i/len(bins)%2]
+		seq := make([]byte, 5+rng.Intn(10))
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+		}
+		fmt.Fprintf(&b, "P%02d\t%s\t%s\t%s\n", i, bin, marker, seq)
+		name := map[string]string{"None": "UNKNOWN", "..": "__"}[bin]
+		if name == "" {
+			name = strings.ReplaceAll(bin, ":", "_")
+		}
+		file := filepath.Join(marker, name+".fasta.gz")
+		want[file] = append(want[file], fmt.Sprintf("P%02d", i))
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bgzip := range []bool{false, true} {
+		outDir := t.TempDir()
+		cfg := inputConfig{Sanitize: sanitizeTranslit, SplitBy: "bin_uri", MaxOpenFiles: 2, BGZip: bgzip, Index: bgzip, Wrap: 4}
+		if err := buildMarkerFastas(context.Background(), input, outDir, true, 0, -1, 1, cfg); err != nil {
+			t.Fatal(err)
+		}
+		files, err := listMarkerFiles(outDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != len(want) {
+			t.Fatalf("bgzip %v: files = %v", bgzip, files)
+		}
+		for file, ids := range want {
+			path := filepath.Join(outDir, file)
+			r, err := openInput(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(r)
+			_ = r.Close()
+			if err != nil {
+				t.Fatalf("bgzip %v: read %s: %v", bgzip, file, err)
+			}
+			var got []string
+			if err := parseFasta(strings.NewReader(string(data)), func(rec fastaRecord) error {
+				got = append(got, rec.id)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(ids) {
+				t.Fatalf("bgzip %v: %s = %v, want %v", bgzip, file, got, ids)
+			}
+			if !bgzip {
+				continue
+			}
+			// The reopened file's indexes must match a fresh index of it.
+			entries, err := indexFasta(strings.NewReader(string(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fai, err := readFai(path + ".fai")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(fai) != fmt.Sprint(entries) {
+				t.Fatalf("%s.fai = %v, want %v", file, fai, entries)
+			}
+			recs, missing, err := fetchSequences(path, ids)
+			if err != nil || len(missing) > 0 || len(recs) != len(ids) {
+				t.Fatalf("%s: fetch %v, missing %v: %v", file, ids, missing, err)
+			}
+		}
+
+		var summary markersSummary
+		data, err := os.ReadFile(filepath.Join(outDir, markersSummaryFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &summary); err != nil {
+			t.Fatal(err)
+		}
+		if summary.SplitBy != "bin_uri" || summary.Split["COI-5P"]["BOLD_AAA0001"] != 6 || summary.Split["rbcL"]["UNKNOWN"] != 6 {
+			t.Fatalf("split_by = %q, split = %v", summary.SplitBy, summary.Split)
+		}
+	}
+}
+
+func TestResolveCompression(t *testing.T) {
+	for _, tc := range []struct {
+		args   []string
+		want   outputCompression
+		failed bool
+	}{
+		{args: nil, want: compressionGzip},
+		{args: []string{"-compression", "BGZF"}, want: compressionBGZF},
+		{args: []string{"-compression", "none"}, want: compressionNone},
+		{args: []string{"-compression", "zstd"}, failed: true},
+		{args: []string{"-compression", "bgzf", "-gzip=false"}, failed: true},
+	} {
+		fs := flag.NewFlagSet("markers", flag.ContinueOnError)
+		fs.Bool("gzip", true, "")
+		value := fs.String("compression", "", "")
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		got, err := resolveCompression(fs, *value, compressionGzip)
+		if (err != nil) != tc.failed || got != tc.want {
+			t.Fatalf("%v: got %q, %v", tc.args, got, err)
+		}
+	}
+
+	// bgzf marker output gets a .gzi without -index.
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	writeMarkersBenchTSV(t, input, 200)
+	if err := buildMarkerFastas(context.Background(), input, tmp, true, 0, -1, 1, inputConfig{BGZip: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(filepath.Join(tmp, "COI-5P.fasta.gz.gzi")) || fileExists(filepath.Join(tmp, "COI-5P.fasta.gz.fai")) {
+		t.Fatal("want a .gzi and no .fai")
+	}
+}
+
+func TestBuildMarkerFastasSortOutput(t *testing.T) {
+	tmp := t.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	var rows []string
+	for i := range 300 {
+		seq := make([]byte, 20+rng.Intn(40))
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+		}
+		marker := []string{"COI-5P", "rbcL", "ITS"}[rng.Intn(3)]
+		rows = append(rows, fmt.Sprintf("P%04d\t%s\t%s", i, marker, seq))
+	}
+	inputs := make([]string, 2)
+	for i := range inputs {
+		if i > 0 {
+			rng.Shuffle(len(rows), func(a, b int) { rows[a], rows[b] = rows[b], rows[a] })
+		}
+		inputs[i] = filepath.Join(tmp, fmt.Sprintf("input%d.tsv", i))
+		data := "processid\tmarker_code\tnuc\n" + strings.Join(rows, "\n") + "\n"
+		if err := os.WriteFile(inputs[i], []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var want map[string][]byte
+	for i, input := range inputs {
+		for _, workers := range []int{1, 4} {
+			for _, budget := range []int64{0, 1 << 10} {
+				outDir, scratch := t.TempDir(), t.TempDir()
+				cfg := inputConfig{SortOutput: true, SortMemory: budget, ScratchDir: scratch, MaxOpenFiles: 1}
+				if err := buildMarkerFastas(context.Background(), input, outDir, true, 0, -1, workers, cfg); err != nil {
+					t.Fatal(err)
+				}
+				if left, _ := os.ReadDir(scratch); len(left) != 0 {
+					t.Fatalf("sort runs left in scratch dir: %v", left)
+				}
+				got := make(map[string][]byte)
+				for _, marker := range []string{"COI-5P", "rbcL", "ITS"} {
+					data, err := os.ReadFile(filepath.Join(outDir, marker+".fasta.gz"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					got[marker] = data
+				}
+				if want == nil {
+					want = got
+					var ids []string
+					for _, line := range readGzipLines(t, filepath.Join(outDir, "COI-5P.fasta.gz")) {
+						if strings.HasPrefix(line, ">") {
+							ids = append(ids, line[1:])
+						}
+					}
+					if len(ids) == 0 || !slices.IsSorted(ids) {
+						t.Fatalf("COI-5P records not sorted by processid: %v", ids)
+					}
+					continue
+				}
+				for marker, data := range got {
+					if string(data) != string(want[marker]) {
+						t.Fatalf("input %d, workers %d, budget %d: %s differs from the first run", i, workers, budget, marker)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBuildMarkerFastasResume(t *testing.T) {
+	tmp := t.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	b.WriteString("processid\tmarker_code\tnuc\n")
+	for i := range 6000 {
+		marker := []string{"COI-5P", "ITS"}[rng.Intn(2)]
+		if i >= 4500 && i%3 == 0 {
+			// rbcL first shows up in the third batch, where the crash is.
+			marker = "rbcL"
+		}
+		seq := make([]byte, 10+rng.Intn(20))
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+		}
+		fmt.Fprintf(&b, "P%05d\t%s\t%s\n", i, marker, seq)
+	}
+	plain := filepath.Join(tmp, "input.tsv")
+	if err := os.WriteFile(plain, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var zb strings.Builder
+	zw := gzip.NewWriter(&zb)
+	if _, err := zw.Write([]byte(b.String())); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gzInput := filepath.Join(tmp, "input.tsv.gz")
+	if err := os.WriteFile(gzInput, []byte(zb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		input string
+		gzip  bool
+		bgzip bool
+	}{
+		{"plain", plain, false, false},
+		{"gzip input and output", gzInput, true, false},
+		{"bgzip", plain, true, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ext := ".fasta"
+			if tc.gzip {
+				ext += ".gz"
+			}
+			cfg := inputConfig{BGZip: tc.bgzip, Wrap: 8}
+			clean := t.TempDir()
+			if err := buildMarkerFastas(context.Background(), tc.input, clean, tc.gzip, 0, -1, 4, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			// A directory in the way of rbcL's FASTA fails the build after
+			// two batches were checkpointed.
+			outDir := t.TempDir()
+			blocker := filepath.Join(outDir, "rbcL"+ext)
+			if err := os.Mkdir(blocker, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			cfg.Resume = true
+			if err := buildMarkerFastas(context.Background(), tc.input, outDir, tc.gzip, 0, -1, 4, cfg); err == nil {
+				t.Fatal("build with a blocked FASTA succeeded")
+			}
+			state, err := loadMarkersState(outDir)
+			if err != nil || state == nil {
+				t.Fatalf("no checkpoint after the failed build: %v", err)
+			}
+			if state.Line == 0 || state.Line >= 6001 || state.After["COI-5P"] == 0 {
+				t.Fatalf("checkpoint at line %d with counts %v", state.Line, state.After)
+			}
+
+			wrapped := cfg
+			wrapped.Wrap = 0
+			if err := buildMarkerFastas(context.Background(), tc.input, outDir, tc.gzip, 0, -1, 4, wrapped); err == nil || !strings.Contains(err.Error(), "options changed") {
+				t.Fatalf("resume with other options: %v", err)
+			}
+
+			if err := os.Remove(blocker); err != nil {
+				t.Fatal(err)
+			}
+			if err := buildMarkerFastas(context.Background(), tc.input, outDir, tc.gzip, 0, -1, 4, cfg); err != nil {
+				t.Fatal(err)
+			}
+			if fileExists(filepath.Join(outDir, markersStateFile)) {
+				t.Fatal("checkpoint left after the resumed build finished")
+			}
+			for _, name := range []string{"COI-5P" + ext, "ITS" + ext, "rbcL" + ext, markersSummaryTSVFile} {
+				want := readGzipLines(t, filepath.Join(clean, name))
+				got := readGzipLines(t, filepath.Join(outDir, name))
+				if !slices.Equal(got, want) {
+					t.Fatalf("%s: resumed output differs from a clean run (%d lines, want %d)", name, len(got), len(want))
+				}
+			}
+			if !tc.gzip {
+				want, _ := os.ReadFile(filepath.Join(clean, "COI-5P.fasta"))
+				got, _ := os.ReadFile(filepath.Join(outDir, "COI-5P.fasta"))
+				if string(got) != string(want) {
+					t.Fatal("COI-5P.fasta differs from a clean run")
+				}
+			}
+			if tc.bgzip {
+				path := filepath.Join(outDir, "COI-5P.fasta.gz")
+				f, err := os.Open(path + ".gzi")
+				if err != nil {
+					t.Fatal(err)
+				}
+				gzi, err := readGZI(f)
+				_ = f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				blocks, err := scanBGZFFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(gzi[1:], blocks[1:]) {
+					t.Fatalf(".gzi of the resumed FASTA does not match its blocks:\n%v\n%v", gzi, blocks)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterSeqBytes(t *testing.T) {
+	tests := []struct {
+		src       string
+		mode      seqFilterMode
+		want      string
+		ambiguous int
+	}{
+		{"acgt-ACGT", seqKeepACGT, "ACGTACGT", 0},
+		{"ACnGTryN-x", seqKeepACGT, "ACGT", 4},
+		{"ACnGTryN-x", seqKeepIUPAC, "ACNGTRYN", 4},
+		{"bdhvkmsw", seqKeepIUPAC, "BDHVKMSW", 8},
+		{"U?*", seqKeepIUPAC, "", 0},
+	}
+	for _, tc := range tests {
+		got, ambiguous := filterSeqBytes(nil, []byte(tc.src), tc.mode)
+		if string(got) != tc.want || ambiguous != tc.ambiguous {
+			t.Errorf("filterSeqBytes(%q, %d) = %q, %d; want %q, %d", tc.src, tc.mode, got, ambiguous, tc.want, tc.ambiguous)
+		}
+	}
+}
+
+func TestBuildMarkerFastasSeqFilter(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	rows := "processid\tmarker_code\tnuc\n" +
+		"P1\tCOI-5P\tACGTACGTAC\n" +
+		"P2\tCOI-5P\tACGT\n" +
+		"P3\tCOI-5P\tACGTNNNNAC\n" +
+		"P4\tCOI-5P\tacgtrACGTA\n" +
+		"P5\tCOI-5P\tNNNNNNNNNN\n"
+	if err := os.WriteFile(input, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		keep      bool
+		want      []string
+		short     int64
+		ambiguous int64
+	}{
+		// Stripped of its Ns, P3 is too short before it is too ambiguous.
+		{"strip", false, []string{">P1", "ACGTACGTAC", ">P4", "ACGTACGTA"}, 2, 0},
+		{"keep ambiguous", true, []string{">P1", "ACGTACGTAC", ">P4", "ACGTRACGTA"}, 1, 1},
+	}
+	for _, tc := range tests {
+		filter, err := newMarkerSeqFilter(8, 0.2, tc.keep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outDir := t.TempDir()
+		if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 1, inputConfig{SeqFilter: filter}); err != nil {
+			t.Fatal(err)
+		}
+		if got := readGzipLines(t, filepath.Join(outDir, "COI-5P.fasta")); !slices.Equal(got, tc.want) {
+			t.Fatalf("%s: COI-5P.fasta = %q, want %q", tc.name, got, tc.want)
+		}
+		var summary markersSummary
+		data, err := os.ReadFile(filepath.Join(outDir, markersSummaryFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &summary); err != nil {
+			t.Fatal(err)
+		}
+		st := summary.Markers["COI-5P"]
+		if st.TooShort != tc.short || st.TooAmbiguous != tc.ambiguous || st.NoBases != 1 {
+			t.Fatalf("%s: too_short %d, too_ambiguous %d, no_bases %d; want %d, %d, 1", tc.name, st.TooShort, st.TooAmbiguous, st.NoBases, tc.short, tc.ambiguous)
+		}
+	}
+
+	for _, bad := range []struct {
+		minLength int
+		maxAmbig  float64
+	}{{-1, 1}, {0, -0.1}, {0, 1.5}} {
+		if _, err := newMarkerSeqFilter(bad.minLength, bad.maxAmbig, false); err == nil {
+			t.Errorf("newMarkerSeqFilter(%d, %v) accepted", bad.minLength, bad.maxAmbig)
+		}
+	}
+}
+
+func TestBuildMarkerFastasWriterPool(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	rng := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	b.WriteString("processid\tcountry/ocean\tmarker_code\tnuc\n")
+	for i := range 3000 {
+		seq := make([]byte, 20+rng.Intn(200))
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+		}
+		fmt.Fprintf(&b, "P%04d\t%s\t%s\t%s\n", i, []string{"Canada", "Peru", "Kenya"}[rng.Intn(3)], []string{"COI-5P", "ITS", "rbcL", "matK"}[rng.Intn(4)], seq)
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, gzipOut := range []bool{false, true} {
+		outputs := make([]map[string][]string, 2)
+		// A serial run, then a pool with room for a few records and fewer
+		// open files than FASTAs, which blocks the parser and evicts.
+		for i, queueBytes := range []int64{-1, 512} {
+			outDir := t.TempDir()
+			cfg := inputConfig{SplitBy: "country/ocean", MaxOpenFiles: 3, WriteBuffer: queueBytes, Wrap: 60}
+			if err := buildMarkerFastas(context.Background(), input, outDir, gzipOut, 0, -1, 2, cfg); err != nil {
+				t.Fatal(err)
+			}
+			files, err := listMarkerFiles(outDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs[i] = make(map[string][]string)
+			for _, file := range files {
+				rel, _ := filepath.Rel(outDir, file)
+				outputs[i][rel] = readGzipLines(t, file)
+			}
+		}
+		if len(outputs[0]) != 12 || fmt.Sprint(outputs[0]) != fmt.Sprint(outputs[1]) {
+			t.Fatalf("gzip %t: the pool wrote other FASTAs than the serial writer (%d and %d files)", gzipOut, len(outputs[0]), len(outputs[1]))
+		}
+	}
+
+	// A failed write stops the build with its error rather than hanging.
+	outDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(outDir, "ITS.fasta"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 2, inputConfig{WriteBuffer: 512})
+	if err == nil || !strings.Contains(err.Error(), "ITS.fasta") {
+		t.Fatalf("build with a blocked FASTA: %v", err)
+	}
+}
+
+func TestBuildMarkerFastasMaxPerMarker(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	rng := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	b.WriteString("processid\tcountry/ocean\tmarker_code\tnuc\n")
+	seen := make(map[string]int64)
+	for i := range 600 {
+		marker := []string{"COI-5P", "COI-5P", "rbcL"}[rng.Intn(3)]
+		seen[marker]++
+		fmt.Fprintf(&b, "P%04d\t%s\t%s\tACGTACGT\n", i, []string{"Canada", "Peru", "Kenya"}[rng.Intn(3)], marker)
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(cfg inputConfig) (map[string][]string, markersSummary) {
+		t.Helper()
+		outDir := t.TempDir()
+		if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 2, cfg); err != nil {
+			t.Fatal(err)
+		}
+		files, err := listMarkerFiles(outDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string][]string)
+		for _, file := range files {
+			rel, _ := filepath.Rel(outDir, file)
+			out[rel] = readGzipLines(t, file)
+		}
+		var summary markersSummary
+		data, err := os.ReadFile(filepath.Join(outDir, markersSummaryFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &summary); err != nil {
+			t.Fatal(err)
+		}
+		return out, summary
+	}
+
+	// With -split-by each file holds its own sample, in input order.
+	cfg := inputConfig{SplitBy: "country/ocean", MaxPerMarker: 10, SampleSeed: 1}
+	out, summary := build(cfg)
+	if len(out) != 6 {
+		t.Fatalf("wrote %d FASTAs, want 6", len(out))
+	}
+	for rel, lines := range out {
+		var ids []string
+		for _, line := range lines {
+			if strings.HasPrefix(line, ">") {
+				ids = append(ids, line)
+			}
+		}
+		if len(ids) != 10 || !slices.IsSorted(ids) {
+			t.Fatalf("%s: kept %q, want 10 records in input order", rel, ids)
+		}
+	}
+	for marker, want := range seen {
+		st := summary.Markers[marker]
+		if st.Seen != want || st.Records != 30 {
+			t.Fatalf("%s: seen %d, records %d; want %d, 30", marker, st.Seen, st.Records, want)
+		}
+		for name, n := range summary.Split[marker] {
+			if n != 10 {
+				t.Fatalf("split %s/%s = %d, want 10", marker, name, n)
+			}
+		}
+	}
+	if summary.MaxPerMarker != 10 || summary.SampleSeed != 1 {
+		t.Fatalf("max_per_marker %d, sample_seed %d", summary.MaxPerMarker, summary.SampleSeed)
+	}
+
+	// The same seed draws the same sample; another seed, another.
+	if again, _ := build(cfg); fmt.Sprint(again) != fmt.Sprint(out) {
+		t.Fatal("the same seed drew another sample")
+	}
+	cfg.SampleSeed = 2
+	if other, _ := build(cfg); fmt.Sprint(other) == fmt.Sprint(out) {
+		t.Fatal("seeds 1 and 2 drew the same sample")
+	}
+
+	// A cap above every marker's count keeps everything, as no cap does.
+	all, allSummary := build(inputConfig{})
+	capped, cappedSummary := build(inputConfig{MaxPerMarker: 1000, SampleSeed: 1})
+	if fmt.Sprint(capped) != fmt.Sprint(all) {
+		t.Fatal("-max-per-marker above the record counts changed the FASTAs")
+	}
+	for marker, want := range seen {
+		if st := allSummary.Markers[marker]; st.Seen != want || st.Records != want {
+			t.Fatalf("%s without a cap: seen %d, records %d; want %d", marker, st.Seen, st.Records, want)
+		}
+		if cappedSummary.Markers[marker].Records != want {
+			t.Fatalf("%s under a high cap: records %d, want %d", marker, cappedSummary.Markers[marker].Records, want)
+		}
+	}
+}
+
+func TestMarkerReservoirsUniform(t *testing.T) {
+	// Over many seeds every record of a stream is kept about max/n of the
+	// time.
+	const n, max, seeds = 20, 5, 4000
+	kept := make([]int, n)
+	for seed := range uint64(seeds) {
+		rs := newMarkerReservoirs(max, seed)
+		for i := range n {
+			rs.add("COI-5P", "COI-5P", "COI-5P.fasta", "", "COI-5P", int64(i+1), nil, nil, nil)
+		}
+		_ = rs.drain(func(_ *markerReservoir, s markerSample) error {
+			kept[s.line-1]++
+			return nil
+		})
+	}
+	want := float64(seeds) * max / n
+	for i, k := range kept {
+		if float64(k) < want*0.85 || float64(k) > want*1.15 {
+			t.Fatalf("record %d kept %d times in %d seeds, want about %.0f", i+1, k, seeds, want)
+		}
+	}
+}
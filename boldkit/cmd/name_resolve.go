@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ambiguousName marks a name shared by several taxa in a nameResolver.
+const ambiguousName = -1
+
+// nameResolver maps species names to taxids for -resolve-by-name, the
+// fallback for records whose ID is not in taxid.map. It is read-only after
+// loading and so safe for concurrent use.
+type nameResolver struct {
+	taxids map[string]int // lowercased name -> taxid, or ambiguousName
+}
+
+// loadNameResolver indexes the names of names.dmp that can match a
+// binomial: scientific names, and synonyms too with synonyms set, of two
+// or more words. Matching is case-insensitive. A scientific name shadows a
+// synonym, and a name left naming several taxa resolves to none.
+func loadNameResolver(path string, synonyms bool) (*nameResolver, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open names.dmp: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	taxids := make(map[string]int, 1<<20)
+	scientific := make(map[string]bool, 1<<20)
+	scanner := bufio.NewScanner(f)
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 10*1024*1024)
+	for scanner.Scan() {
+		fields := parseDmpLine(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		isScientific := fields[3] == "scientific name"
+		if !isScientific && !(synonyms && fields[3] == "synonym") {
+			continue
+		}
+		if !strings.Contains(fields[1], " ") {
+			continue
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		name := strings.ToLower(fields[1])
+		prev, seen := taxids[name]
+		switch {
+		case !seen, isScientific && !scientific[name]:
+			taxids[name] = id
+			scientific[name] = isScientific
+		case prev != id && isScientific == scientific[name]:
+			taxids[name] = ambiguousName
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan names.dmp: %w", err)
+	}
+	return &nameResolver{taxids: taxids}, nil
+}
+
+// resolve returns the binomial of a FASTA description and its taxid. ok is
+// false when the description has no binomial or the name is unknown or
+// ambiguous.
+func (r *nameResolver) resolve(desc string) (name string, taxid int, ok bool) {
+	name = binomial(desc)
+	if name == "" {
+		return "", 0, false
+	}
+	taxid = r.taxids[strings.ToLower(name)]
+	return name, taxid, taxid > 0
+}
+
+// binomial returns the first "Genus epithet" pair of desc: a capitalized
+// word followed by a lowercase one, in words split at whitespace and the
+// '|', ';', ',', '=', and '_' separators of common FASTA headers. It returns
+// "" when there is none. Open nomenclature ("Canis sp.") does not match.
+func binomial(desc string) string {
+	words := strings.FieldsFunc(desc, func(r rune) bool {
+		switch r {
+		case ' ', '\t', '|', ';', ',', '=', '_':
+			return true
+		}
+		return false
+	})
+	for i := 0; i+1 < len(words); i++ {
+		if isGenusWord(words[i]) && isEpithetWord(words[i+1]) {
+			return words[i] + " " + words[i+1]
+		}
+	}
+	return ""
+}
+
+func isGenusWord(w string) bool {
+	if len(w) < 2 || w[0] < 'A' || w[0] > 'Z' {
+		return false
+	}
+	for i := 1; i < len(w); i++ {
+		if w[i] < 'a' || w[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// isEpithetWord accepts lowercase letters and inner hyphens ("novae-angliae").
+func isEpithetWord(w string) bool {
+	if len(w) < 2 || w[0] == '-' || w[len(w)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(w); i++ {
+		if (w[i] < 'a' || w[i] > 'z') && w[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvedTable writes the id/taxid/name pairs -resolve-by-name found,
+// without a header, so it can be appended to taxid.map.
+type resolvedTable struct {
+	w *tsvWriter
+}
+
+// openResolvedTable creates the table at path; it returns nil for "".
+func openResolvedTable(path string) (*resolvedTable, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create resolved table dir: %w", err)
+	}
+	w, err := createTSVWriter(path, tsvWriterOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("create resolved table: %w", err)
+	}
+	return &resolvedTable{w: w}, nil
+}
+
+func (r *resolvedTable) write(id string, taxid int, name string) error {
+	if r == nil {
+		return nil
+	}
+	if err := r.w.WriteRowStrings([]string{id, strconv.Itoa(taxid), name}); err != nil {
+		return fmt.Errorf("write resolved table: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the table. Later calls do nothing.
+func (r *resolvedTable) Close() error {
+	if r == nil || r.w == nil {
+		return nil
+	}
+	err := r.w.Close()
+	r.w = nil
+	if err != nil {
+		return fmt.Errorf("close resolved table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBinomial(t *testing.T) {
+	for desc, want := range map[string]string{
+		"Canis lupus":                   "Canis lupus",
+		"Canis lupus familiaris COI-5P": "Canis lupus",
+		"COI-5P|Canis_lupus|Canada":     "Canis lupus",
+		"species=Aster novae-angliae;":  "Aster novae-angliae",
+		"Lepidoptera|COI-5P":            "",
+		"Canis sp. BOLD:AAA0001":        "",
+		"canis lupus":                   "",
+		"":                              "",
+	} {
+		if got := binomial(desc); got != want {
+			t.Errorf("binomial(%q) = %q, want %q", desc, got, want)
+		}
+	}
+}
+
+func TestNameResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names.dmp")
+	names := []string{
+		"7\t|\tCanis lupus\t|\t\t|\tscientific name\t|",
+		"9\t|\tCanis\t|\t\t|\tscientific name\t|",
+		"10\t|\tPuma concolor\t|\t\t|\tscientific name\t|",
+		"10\t|\tFelis concolor\t|\t\t|\tsynonym\t|",
+		"11\t|\tCanis lupus\t|\t\t|\tsynonym\t|",
+		"12\t|\tAus bus\t|\t\t|\tscientific name\t|",
+		"13\t|\tAus bus\t|\t\t|\tscientific name\t|",
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(names, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, synonyms := range []bool{false, true} {
+		r, err := loadNameResolver(path, synonyms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		felis := 0
+		if synonyms {
+			felis = 10
+		}
+		// The scientific Canis lupus shadows the synonym, and the two Aus
+		// bus make it ambiguous. An all-caps description has no binomial.
+		for desc, want := range map[string]int{
+			"CANIS LUPUS":    0,
+			"Canis lupus":    7,
+			"Felis concolor": felis,
+			"Aus bus":        0,
+			"Canis":          0,
+		} {
+			got := 0
+			if _, taxid, ok := r.resolve(desc); ok {
+				got = taxid
+			}
+			if got != want {
+				t.Errorf("synonyms=%v resolve(%q) = %d, want %d", synonyms, desc, got, want)
+			}
+		}
+		if _, ok := r.taxids["canis"]; ok {
+			t.Error("single-word names are indexed")
+		}
+	}
+}
+
+// TestQCResolveByName checks that records missing from taxid.map are kept
+// under the taxid of their description's binomial, and that the resolved
+// table can be appended to taxid.map.
+func TestQCResolveByName(t *testing.T) {
+	dir := t.TempDir()
+	writeTaxdumpFixture(t, dir)
+	input := filepath.Join(dir, "in.fasta")
+	fasta := ">P1\nACGTACGTAC\n>X1 Canis lupus|COI-5P\nACGTACGTAA\n>X2 Vulpes vulpes|COI-5P\nACGTACGTTT\n>X1 Canis lupus\nACGTACGTCC\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := qcConfig{
+		MaxN:          -1,
+		MaxAmbig:      -1,
+		RequireRanks:  []string{"species"},
+		TaxdumpDir:    dir,
+		OutputPath:    filepath.Join(dir, "out.fasta"),
+		ResolveByName: true,
+		ResolvedTSV:   filepath.Join(dir, "resolved.tsv"),
+	}
+	stats, err := runQCFasta(context.Background(), input, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 3 || stats.ResolvedByName != 2 || stats.UnresolvedByName != 1 || stats.Dropped["missing_taxid"] != 1 {
+		t.Fatalf("%+v", stats)
+	}
+	table, err := os.ReadFile(cfg.ResolvedTSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(table) != "X1\t7\tCanis lupus\n" {
+		t.Fatalf("resolved table:\n%s", table)
+	}
+	if stats.ByRank["species"]["Canis lupus"].Kept != 3 {
+		t.Fatalf("by_rank: %+v", stats.ByRank["species"])
+	}
+
+	stats2, _, err := runFormatFasta(context.Background(), formatConfig{
+		Classifiers:   []string{"blast"},
+		RequireRanks:  []string{"species"},
+		Input:         input,
+		OutDir:        filepath.Join(dir, "formatted"),
+		TaxdumpDir:    dir,
+		ResolveByName: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats2.Written != 3 || stats2.ResolvedByName != 2 || stats2.UnresolvedByName != 1 || stats2.MissingTaxID != 1 {
+		t.Fatalf("format: %+v", stats2)
+	}
+}
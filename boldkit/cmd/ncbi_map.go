@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type ncbiMapConfig struct {
+	TaxdumpDir     string
+	NCBITaxdumpDir string
+	OutputPath     string
+	// UnmatchedPath lists the taxa whose own name has no NCBI match
+	// (empty skips it).
+	UnmatchedPath string
+	// TaxidMapOutput rewrites the bold taxid.map with NCBI taxids (empty
+	// skips it).
+	TaxidMapOutput string
+}
+
+// ncbiMapStats counts the bold taxa by the rank their NCBI taxid matched
+// at; Unmatched found none up to the root, Ambiguous had several equally
+// likely homonyms at their own rank. Mapped and Unmapped count taxid.map
+// rows.
+type ncbiMapStats struct {
+	Taxa      int
+	ByRank    map[string]int
+	Unmatched int
+	Ambiguous int
+	Mapped    int
+	Unmapped  int
+}
+
+func runNCBIMap(args []string) {
+	fs := flag.NewFlagSet("ncbi-map", flag.ExitOnError)
+	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "BOLD taxdump directory with nodes.dmp/names.dmp/taxid.map")
+	ncbiDir := fs.String("ncbi-taxdump-dir", "", "NCBI taxdump directory with nodes.dmp/names.dmp (required)")
+	output := fs.String("output", "ncbi_map.tsv", "Output bold taxid -> NCBI taxid TSV (.gz compresses)")
+	unmatched := fs.String("unmatched", "ncbi_unmatched.tsv", "Output TSV of bold taxa whose own name has no NCBI match (empty skips)")
+	taxidMap := fs.String("taxid-map-output", "", "Write the bold taxid.map with NCBI taxids here, for NCBI-based databases")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *ncbiDir == "" {
+		fatalf("ncbi-taxdump-dir is required")
+	}
+	_, err := mapToNCBI(ncbiMapConfig{
+		TaxdumpDir:     *taxdumpDir,
+		NCBITaxdumpDir: *ncbiDir,
+		OutputPath:     *output,
+		UnmatchedPath:  *unmatched,
+		TaxidMapOutput: *taxidMap,
+	})
+	if err != nil {
+		fatalf("ncbi-map failed: %v", err)
+	}
+}
+
+// normalizeTaxonName folds a scientific name for matching: lower case,
+// without "sp." and subgenus parentheses, single-spaced, so "Aus (Bus) cus"
+// matches "Aus cus" and "Aus sp. BOLD:AAA0001" matches "Aus BOLD:AAA0001".
+func normalizeTaxonName(name string) string {
+	words := strings.Fields(strings.ToLower(name))
+	out := words[:0]
+	for _, w := range words {
+		if w == "sp." || w == "sp" || (len(w) > 2 && w[0] == '(' && w[len(w)-1] == ')') {
+			continue
+		}
+		out = append(out, w)
+	}
+	return strings.Join(out, " ")
+}
+
+// ncbiMatcher finds the NCBI taxid of a bold taxon: an NCBI taxon of the
+// same rank and normalized name, the one sharing the most ancestor names
+// when there are homonyms.
+type ncbiMatcher struct {
+	bold, ncbi *taxDump
+	// index maps rank NUL normalized name to NCBI taxids.
+	index map[string][]int
+	// direct memoizes match by bold taxid: the NCBI taxid, 0 for none,
+	// -1 for an ambiguous homonym.
+	direct map[int]int
+}
+
+func newNCBIMatcher(bold, ncbi *taxDump) *ncbiMatcher {
+	ranks := make(map[string]bool)
+	bold.eachNode(func(_ int, n taxNode) {
+		ranks[bold.lineageRanks[n.rank]] = true
+	})
+	m := &ncbiMatcher{bold: bold, ncbi: ncbi, index: make(map[string][]int), direct: make(map[int]int)}
+	ncbi.eachNode(func(taxid int, n taxNode) {
+		rank := ncbi.lineageRanks[n.rank]
+		if !ranks[rank] || n.nameLen == 0 {
+			return
+		}
+		key := rank + "\x00" + normalizeTaxonName(ncbi.name(n))
+		m.index[key] = append(m.index[key], taxid)
+	})
+	return m
+}
+
+// match returns the NCBI taxid of bold taxid itself, 0 when no NCBI taxon
+// of its rank has its name, or -1 when several homonyms fit equally well.
+func (m *ncbiMatcher) match(taxid int) int {
+	if id, ok := m.direct[taxid]; ok {
+		return id
+	}
+	n, _ := m.bold.node(taxid)
+	cands := m.index[m.bold.lineageRanks[n.rank]+"\x00"+normalizeTaxonName(m.bold.name(n))]
+	id := 0
+	switch len(cands) {
+	case 0:
+	case 1:
+		id = cands[0]
+	default:
+		ancestors := ancestorNames(m.bold, int(n.parent))
+		best, tied := -1, false
+		for _, cand := range cands {
+			c, _ := m.ncbi.node(cand)
+			score := 0
+			for name := range ancestorNames(m.ncbi, int(c.parent)) {
+				if ancestors[name] {
+					score++
+				}
+			}
+			switch {
+			case score > best:
+				best, tied, id = score, false, cand
+			case score == best:
+				tied = true
+			}
+		}
+		if tied {
+			id = -1
+		}
+	}
+	m.direct[taxid] = id
+	return id
+}
+
+// ancestorNames returns the normalized names of taxid and its ancestors.
+func ancestorNames(dump *taxDump, taxid int) map[string]bool {
+	names := make(map[string]bool, 16)
+	for seen := 0; seen < 64; seen++ {
+		n, ok := dump.node(taxid)
+		if !ok {
+			break
+		}
+		if n.nameLen > 0 {
+			names[normalizeTaxonName(dump.name(n))] = true
+		}
+		if int(n.parent) == taxid {
+			break
+		}
+		taxid = int(n.parent)
+	}
+	return names
+}
+
+// resolve returns the NCBI taxid of bold taxid or of its nearest ancestor
+// that has one, and the bold node matched.
+func (m *ncbiMatcher) resolve(taxid int) (int, taxNode, bool) {
+	for seen := 0; seen < 64; seen++ {
+		n, ok := m.bold.node(taxid)
+		if !ok || int(n.parent) == taxid {
+			break
+		}
+		if id := m.match(taxid); id > 0 {
+			return id, n, true
+		}
+		taxid = int(n.parent)
+	}
+	return 0, taxNode{}, false
+}
+
+// mapToNCBI writes the NCBI taxid of every bold taxon, walking up to the
+// nearest ancestor whose name matches when its own does not, and the rank
+// it matched at.
+func mapToNCBI(cfg ncbiMapConfig) (ncbiMapStats, error) {
+	stats := ncbiMapStats{ByRank: make(map[string]int)}
+	bold, err := loadTaxDump(filepath.Join(cfg.TaxdumpDir, "nodes.dmp"), filepath.Join(cfg.TaxdumpDir, "names.dmp"), nil)
+	if err != nil {
+		return stats, fmt.Errorf("load bold taxdump: %w", err)
+	}
+	ncbi, err := loadTaxDump(filepath.Join(cfg.NCBITaxdumpDir, "nodes.dmp"), filepath.Join(cfg.NCBITaxdumpDir, "names.dmp"), nil)
+	if err != nil {
+		return stats, fmt.Errorf("load NCBI taxdump: %w", err)
+	}
+	m := newNCBIMatcher(bold, ncbi)
+
+	out, err := createTSVWriter(cfg.OutputPath, tsvWriterOptions{})
+	if err != nil {
+		return stats, fmt.Errorf("create ncbi map: %w", err)
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+	if err := out.WriteHeader([]string{"bold_taxid", "bold_rank", "bold_name", "ncbi_taxid", "ncbi_rank", "ncbi_name", "matched_rank"}); err != nil {
+		return stats, err
+	}
+	var unmatched *tsvWriter
+	if cfg.UnmatchedPath != "" {
+		if unmatched, err = createTSVWriter(cfg.UnmatchedPath, tsvWriterOptions{}); err != nil {
+			return stats, fmt.Errorf("create unmatched list: %w", err)
+		}
+		defer func() {
+			_ = unmatched.Close()
+		}()
+		if err := unmatched.WriteHeader([]string{"bold_taxid", "bold_rank", "bold_name", "reason"}); err != nil {
+			return stats, err
+		}
+	}
+
+	bold.eachNode(func(taxid int, n taxNode) {
+		if err != nil || int(n.parent) == taxid {
+			return
+		}
+		stats.Taxa++
+		boldID, rank, name := strconv.Itoa(taxid), bold.lineageRanks[n.rank], bold.name(n)
+		if direct := m.match(taxid); direct <= 0 {
+			reason := "no_match"
+			if direct < 0 {
+				reason = "ambiguous"
+				stats.Ambiguous++
+			}
+			if unmatched != nil {
+				if err = unmatched.WriteRowStrings([]string{boldID, rank, name, reason}); err != nil {
+					return
+				}
+			}
+		}
+		row := []string{boldID, rank, name, "", "", "", ""}
+		if id, at, ok := m.resolve(taxid); ok {
+			c, _ := ncbi.node(id)
+			row[3], row[4], row[5], row[6] = strconv.Itoa(id), ncbi.rank(c), ncbi.name(c), bold.lineageRanks[at.rank]
+			stats.ByRank[row[6]]++
+		} else {
+			stats.Unmatched++
+		}
+		err = out.WriteRowStrings(row)
+	})
+	if err != nil {
+		return stats, fmt.Errorf("write ncbi map: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return stats, fmt.Errorf("close ncbi map: %w", err)
+	}
+	if unmatched != nil {
+		if err := unmatched.Close(); err != nil {
+			return stats, fmt.Errorf("close unmatched list: %w", err)
+		}
+	}
+	if cfg.TaxidMapOutput != "" {
+		if err := writeNCBITaxidMap(cfg, m, &stats); err != nil {
+			return stats, err
+		}
+	}
+
+	var byRank []string
+	for _, rank := range slices.Sorted(maps.Keys(stats.ByRank)) {
+		byRank = append(byRank, fmt.Sprintf("%s=%d", rank, stats.ByRank[rank]))
+	}
+	logf("ncbi-map: %d taxa, matched at %s, %d unmatched (%d ambiguous homonyms) -> %s",
+		stats.Taxa, strings.Join(byRank, " "), stats.Unmatched, stats.Ambiguous, cfg.OutputPath)
+	return stats, nil
+}
+
+// writeNCBITaxidMap rewrites the bold taxid.map with the NCBI taxids of
+// its taxids, dropping the rows that have none.
+func writeNCBITaxidMap(cfg ncbiMapConfig, m *ncbiMatcher, stats *ncbiMapStats) error {
+	out, err := createTSVWriter(cfg.TaxidMapOutput, tsvWriterOptions{})
+	if err != nil {
+		return fmt.Errorf("create NCBI taxid.map: %w", err)
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+	row := make([][]byte, 2)
+	err = scanTaxidMap(filepath.Join(cfg.TaxdumpDir, "taxid.map"), func(pid []byte, taxid int) error {
+		taxid, _ = m.bold.current(taxid)
+		id, _, ok := m.resolve(taxid)
+		if !ok {
+			stats.Unmapped++
+			return nil
+		}
+		stats.Mapped++
+		row[0], row[1] = pid, strconv.AppendInt(row[1][:0], int64(id), 10)
+		return out.WriteRow(row)
+	})
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("close NCBI taxid.map: %w", err)
+	}
+	logf("ncbi-map: %d taxid.map rows mapped, %d without an NCBI taxid -> %s", stats.Mapped, stats.Unmapped, cfg.TaxidMapOutput)
+	return nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeDmps writes nodes.dmp and names.dmp of taxid|parent|rank|name rows.
+func writeDmps(t *testing.T, dir string, rows []string) {
+	t.Helper()
+	var nodes, names strings.Builder
+	for _, row := range rows {
+		f := strings.Split(row, "|")
+		nodes.WriteString(f[0] + "\t|\t" + f[1] + "\t|\t" + f[2] + "\t|\n")
+		names.WriteString(f[0] + "\t|\t" + f[3] + "\t|\t\t|\tscientific name\t|\n")
+	}
+	for name, body := range map[string]string{"nodes.dmp": nodes.String(), "names.dmp": names.String()} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNormalizeTaxonName(t *testing.T) {
+	cases := map[string]string{
+		"Canis lupus":          "canis lupus",
+		"Aus (Bus) cus":        "aus cus",
+		"Morus sp. BOLD:AAA01": "morus bold:aaa01",
+		"  Danaus  plexippus ": "danaus plexippus",
+	}
+	for in, want := range cases {
+		if got := normalizeTaxonName(in); got != want {
+			t.Errorf("normalizeTaxonName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMapToNCBI(t *testing.T) {
+	dir := t.TempDir()
+	bold, ncbi := filepath.Join(dir, "bold"), filepath.Join(dir, "ncbi")
+	for _, d := range []string{bold, ncbi} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeDmps(t, bold, []string{
+		"1|1|no rank|root",
+		"2|1|kingdom|Animalia",
+		"3|2|phylum|Chordata",
+		"4|3|class|Aves",
+		"5|4|order|Suliformes",
+		"6|5|family|Sulidae",
+		"7|6|genus|Morus",
+		"8|7|species|Morus bassanus",
+		"9|7|species|Morus sp. BOLD:AAA0001",
+		"10|7|species|Morus novus",
+		"11|6|genus|Zus",
+		"12|11|species|Zus yus",
+	})
+	writeDmps(t, ncbi, []string{
+		"1|1|no rank|root",
+		"2759|1|superkingdom|Eukaryota",
+		"33208|2759|kingdom|Metazoa",
+		"7711|33208|phylum|Chordata",
+		"8782|7711|class|Aves",
+		"3073813|8782|order|Suliformes",
+		"30446|3073813|family|Sulidae",
+		"37577|30446|genus|Morus",
+		"37578|37577|species|Morus bassanus",
+		"900|37577|species|Morus BOLD:AAA0001",
+		"33090|2759|kingdom|Viridiplantae",
+		"3744|33090|order|Rosales",
+		"3480|3744|family|Moraceae",
+		"3497|3480|genus|Morus",
+		"500|3480|genus|Zus",
+		"502|2759|family|Fooidae",
+		"501|502|genus|Zus",
+	})
+	if err := os.WriteFile(filepath.Join(bold, "taxid.map"), []byte("P1\t8\nP2\t10\nP3\t2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := ncbiMapConfig{
+		TaxdumpDir:     bold,
+		NCBITaxdumpDir: ncbi,
+		OutputPath:     filepath.Join(dir, "map.tsv"),
+		UnmatchedPath:  filepath.Join(dir, "unmatched.tsv"),
+		TaxidMapOutput: filepath.Join(dir, "taxid.map"),
+	}
+	stats, err := mapToNCBI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Taxa != 11 || stats.Unmatched != 1 || stats.Ambiguous != 1 || stats.Mapped != 2 || stats.Unmapped != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	wantFiles := map[string]string{
+		"map.tsv": "bold_taxid\tbold_rank\tbold_name\tncbi_taxid\tncbi_rank\tncbi_name\tmatched_rank\n" +
+			"2\tkingdom\tAnimalia\t\t\t\t\n" +
+			"3\tphylum\tChordata\t7711\tphylum\tChordata\tphylum\n" +
+			"4\tclass\tAves\t8782\tclass\tAves\tclass\n" +
+			"5\torder\tSuliformes\t3073813\torder\tSuliformes\torder\n" +
+			"6\tfamily\tSulidae\t30446\tfamily\tSulidae\tfamily\n" +
+			// The bird Morus shares Sulidae and its ancestors; the plant does not.
+			"7\tgenus\tMorus\t37577\tgenus\tMorus\tgenus\n" +
+			"8\tspecies\tMorus bassanus\t37578\tspecies\tMorus bassanus\tspecies\n" +
+			"9\tspecies\tMorus sp. BOLD:AAA0001\t900\tspecies\tMorus BOLD:AAA0001\tspecies\n" +
+			"10\tspecies\tMorus novus\t37577\tgenus\tMorus\tgenus\n" +
+			// Neither NCBI Zus is nearer, so Zus falls back to its family.
+			"11\tgenus\tZus\t30446\tfamily\tSulidae\tfamily\n" +
+			"12\tspecies\tZus yus\t30446\tfamily\tSulidae\tfamily\n",
+		"unmatched.tsv": "bold_taxid\tbold_rank\tbold_name\treason\n" +
+			"2\tkingdom\tAnimalia\tno_match\n" +
+			"10\tspecies\tMorus novus\tno_match\n" +
+			"11\tgenus\tZus\tambiguous\n" +
+			"12\tspecies\tZus yus\tno_match\n",
+		"taxid.map": "P1\t37578\nP2\t37577\n",
+	}
+	for name, want := range wantFiles {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+}
@@ -11,47 +11,63 @@ import (
 )
 
 type packageConfig struct {
-	TaxdumpDir    string
-	MarkerDir     string
-	TaxonkitOut   string
-	ReleaseDir    string
-	Snapshot      string
-	Force         bool
-	SkipManifest  bool
-	SkipChecksums bool
-	MoveInputs    bool
+	TaxdumpDir     string
+	MarkerDir      string
+	TaxonkitOut    string
+	QuarantinePath string
+	ReleaseDir     string
+	Snapshot       string
+	Force          bool
+	SkipManifest   bool
+	SkipChecksums  bool
+	MoveInputs     bool
+	// Reproducible normalizes archive entry metadata; see normalizeTarHeader.
+	Reproducible bool
 }
 
 func runPackage(args []string) {
 	fs := flag.NewFlagSet("package", flag.ExitOnError)
-	taxonkitOut := fs.String("taxonkit-output", "taxonkit_input.tsv", "Input taxonkit TSV to include")
+	taxonkitOut := fs.String("taxonkit-output", "", "Input taxonkit TSV to include (default: taxonkit_input.<snapshot>.tsv.gz with -snapshot-id, else "+legacyTaxonkitOutput+")")
 	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Input taxdump directory")
-	markerDir := fs.String("marker-dir", "marker_fastas", "Input marker FASTA directory")
+	markerDir := fs.String("marker-dir", "", "Input marker FASTA directory (default: marker_fastas.<snapshot> with -snapshot-id, else "+legacyMarkerDir+")")
 	releaseDir := fs.String("releases-dir", "releases", "Release artifacts directory")
-	snapshot := fs.String("snapshot-id", "", "Snapshot ID suffix for releases")
+	snapshot := fs.String("snapshot-id", "", "Snapshot ID suffix for releases (default: from a taxonkit_input.<snapshot>.tsv.gz -taxonkit-output, else its file name)")
 	force := fs.Bool("force", false, "Overwrite existing outputs")
 	skipManifest := fs.Bool("skip-manifest", false, "Skip manifest.json")
 	skipChecksums := fs.Bool("skip-checksums", false, "Skip SHA256SUMS.txt")
 	moveInputs := fs.Bool("move", true, "Move inputs into releases dir before packaging")
+	quarantinePath := fs.String("quarantine", "", "Optional quarantine TSV to include in the release")
+	reproducible := fs.Bool("reproducible", true, reproducibleUsage)
 	if err := fs.Parse(args); err != nil {
 		fatalf("parse args failed: %v", err)
 	}
 
 	snap := *snapshot
+	if snap == "" {
+		snap = taxonkitSnapshot(*taxonkitOut)
+	}
+	if *taxonkitOut == "" {
+		*taxonkitOut = snapshotPath(legacyTaxonkitOutput, snap)
+	}
+	if *markerDir == "" {
+		*markerDir = snapshotPath(legacyMarkerDir, snap)
+	}
 	if snap == "" {
 		snap = snapshotID(*taxonkitOut)
 	}
 
 	cfg := packageConfig{
-		TaxdumpDir:    *taxdumpDir,
-		MarkerDir:     *markerDir,
-		TaxonkitOut:   *taxonkitOut,
-		ReleaseDir:    *releaseDir,
-		Snapshot:      snap,
-		Force:         *force,
-		SkipManifest:  *skipManifest,
-		SkipChecksums: *skipChecksums,
-		MoveInputs:    *moveInputs,
+		TaxdumpDir:     *taxdumpDir,
+		MarkerDir:      *markerDir,
+		TaxonkitOut:    *taxonkitOut,
+		QuarantinePath: *quarantinePath,
+		ReleaseDir:     *releaseDir,
+		Snapshot:       snap,
+		Force:          *force,
+		SkipManifest:   *skipManifest,
+		SkipChecksums:  *skipChecksums,
+		MoveInputs:     *moveInputs,
+		Reproducible:   *reproducible,
 	}
 
 	if err := packageRelease(cfg); err != nil {
@@ -95,12 +111,12 @@ func packageRelease(cfg packageConfig) error {
 	taxdumpArchive := packageTaxdumpArchivePath(taxdumpDir, cfg.ReleaseDir, cfg.Snapshot)
 
 	logf("Package taxdump archive -> %s", taxdumpArchive)
-	if err := packageDirGzip(taxdumpDir, taxdumpArchive, cfg.Force); err != nil {
+	if err := packageDirGzip(taxdumpDir, taxdumpArchive, cfg.Force, cfg.Reproducible); err != nil {
 		return err
 	}
 
 	logf("Package marker archive -> %s", markerZip)
-	if err := packageDirGzip(markerDir, markerZip, cfg.Force); err != nil {
+	if err := packageDirGzip(markerDir, markerZip, cfg.Force, cfg.Reproducible); err != nil {
 		return err
 	}
 
@@ -116,10 +132,18 @@ func packageRelease(cfg packageConfig) error {
 		}
 	}
 
+	if cfg.QuarantinePath != "" {
+		quarantineGz := packageTaxonkitGzipPath(cfg.QuarantinePath, cfg.ReleaseDir, cfg.Snapshot)
+		logf("Package quarantine gzip -> %s", quarantineGz)
+		if err := packageTaxonkitGzip(cfg.QuarantinePath, quarantineGz, cfg.Force); err != nil {
+			return fmt.Errorf("quarantine: %w", err)
+		}
+	}
+
 	if !cfg.SkipManifest {
 		manifestPath := filepath.Join(cfg.ReleaseDir, "manifest.json")
 		logf("Write manifest -> %s", manifestPath)
-		if err := writeManifest(manifestPath, taxdumpDir, markerDir, cfg.Snapshot, cfg.Force); err != nil {
+		if err := writeManifest(manifestPath, taxdumpDir, markerDir, cfg.Snapshot, cfg.QuarantinePath, cfg.Force); err != nil {
 			return fmt.Errorf("manifest: %w", err)
 		}
 	}
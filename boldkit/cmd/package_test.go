@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelinePackageReproducible(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	content := strings.Join([]string{
+		"processid\tbin_uri\tkingdom\tphylum\tclass\torder\tfamily\tsubfamily\ttribe\tgenus\tspecies\tmarker_code\tnuc",
+		"P1\tBOLD:AAA0001\tAnimalia\tChordata\tMammalia\tCarnivora\tCanidae\t\t\tCanis\tCanis lupus\tCOI-5P\tACGTACGTAC",
+		"P2\tBOLD:AAA0002\tAnimalia\tChordata\tMammalia\tCarnivora\tFelidae\t\t\tFelis\tFelis catus\tCOI-5P\tTTGACCAGTA",
+		"P3\tBOLD:AAA0002\tPlantae\tTracheophyta\tMagnoliopsida\tRosales\tRosaceae\t\t\tRosa\t\trbcL\tGGCCTTAAGC",
+	}, "\n") + "\n"
+	if err := os.WriteFile(input, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := []string{filepath.Join(tmp, "a"), filepath.Join(tmp, "b")}
+	run := func(root string, doPackage bool) {
+		t.Helper()
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		err := pipeline(context.Background(), input,
+			filepath.Join(root, "taxonkit_input.S1.tsv"), filepath.Join(root, "bold-taxdump"),
+			filepath.Join(root, "marker_fastas.S1"), filepath.Join(root, "releases"), "",
+			0, -1, 1, true, false, doPackage, false, false, "S1",
+			extractCurationConfig{Snapshot: "S1"}.normalized(), inputConfig{Snapshot: "S1"}, false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, root := range roots {
+		run(root, false)
+	}
+	// Give the second run's outputs other times and permissions, as a later
+	// run on another machine would.
+	old := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	err := filepath.Walk(roots[1], func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := os.Chmod(path, 0o600); err != nil {
+				return err
+			}
+		}
+		return os.Chtimes(path, old, old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range roots {
+		run(root, true)
+	}
+
+	sums := make([]string, len(roots))
+	for i, root := range roots {
+		data, err := os.ReadFile(filepath.Join(root, "releases", "SHA256SUMS.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums[i] = string(data)
+	}
+	if sums[0] != sums[1] {
+		t.Fatalf("SHA256SUMS.txt differs between runs:\n%s\n---\n%s", sums[0], sums[1])
+	}
+	for _, line := range strings.Split(strings.TrimSpace(sums[0]), "\n") {
+		name := strings.Fields(line)[1]
+		f, err := os.Open(filepath.Join(roots[0], "releases", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !zr.ModTime.IsZero() || zr.Name != "" {
+			t.Fatalf("%s: gzip header has time %v, name %q", name, zr.ModTime, zr.Name)
+		}
+		_ = f.Close()
+	}
+	if !strings.Contains(sums[0], "marker_fastas.S1.tar.gz") || !strings.Contains(sums[0], "taxonkit_input.S1.tsv.gz") {
+		t.Fatalf("SHA256SUMS.txt misses artifacts:\n%s", sums[0])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bufio"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -17,16 +18,17 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"time"
 )
 
 func runPipeline(args []string) {
 	fs := flag.NewFlagSet("pipeline", flag.ExitOnError)
 	input := fs.String("input", "BOLD_Public.*/BOLD_Public.*.tsv", "BOLD input file (TSV or Parquet)")
-	taxonkitOut := fs.String("taxonkit-output", "taxonkit_input.tsv", "Output taxonkit input TSV")
+	taxonkitOut := fs.String("taxonkit-output", "", "Output taxonkit input TSV (default: taxonkit_input.<snapshot>.tsv.gz, or "+legacyTaxonkitOutput+" without a snapshot ID)")
 	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Output taxdump directory")
-	markerDir := fs.String("marker-dir", "marker_fastas", "Output marker FASTA directory")
+	markerDir := fs.String("marker-dir", "", "Output marker FASTA directory (default: marker_fastas.<snapshot>, or "+legacyMarkerDir+" without a snapshot ID)")
 	releaseDir := fs.String("releases-dir", "releases", "Release artifacts directory")
-	taxonkitBin := fs.String("taxonkit-bin", "", "Path to taxonkit binary (default: search PATH)")
+	taxonkitBin := fs.String("taxonkit-bin", "", "Build the taxdump with this taxonkit binary (create-taxdump) instead of the native builder; \"taxonkit\" searches PATH")
 	progressOn := fs.Bool("progress", true, "Show progress bar")
 	noGzip := fs.Bool("no-gzip", false, "Disable gzip for marker FASTAs")
 	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "Parser worker goroutines (<=0 defaults to GOMAXPROCS)")
@@ -34,77 +36,133 @@ func runPipeline(args []string) {
 	packageFlag := fs.Bool("package", false, "Create release zips, manifest, and checksums")
 	skipManifest := fs.Bool("skip-manifest", false, "Skip manifest.json (only when --package)")
 	skipChecksums := fs.Bool("skip-checksums", false, "Skip SHA256SUMS.txt (only when --package)")
-	snapshot := fs.String("snapshot-id", "", "Snapshot ID suffix for releases (default: derive from input filename)")
+	snapshot := addSnapshotFlag(fs, "the -input filename")
 	extractCurateProtocol := fs.String("extract-curate-protocol", extractCurationProtocolNone, "Extraction curation profile (none,bioscan-5m)")
 	extractCurateReport := fs.String("extract-curate-report", "", "Optional extraction curation JSON report path")
 	extractCurateAudit := fs.String("extract-curate-audit", "", "Optional extraction curation audit TSV path")
+	inputFlags := addInputFlags(fs)
+	packageQuarantine := fs.Bool("package-quarantine", false, "Include the quarantine file in release artifacts (only when --package)")
+	reproducible := fs.Bool("reproducible", true, reproducibleUsage+" (only when --package)")
+	wrap := fs.Int("wrap", 0, "Wrap marker FASTA sequences at this many bases per line (0 disables)")
+	markerAliasSpec := fs.String("marker-aliases", "", markerAliasesUsage)
 	if err := fs.Parse(args); err != nil {
 		fatalf("parse args failed: %v", err)
 	}
+	if *wrap < 0 {
+		fatalf("wrap must be >= 0")
+	}
+	// One snapshot ID names every stage's outputs, the release artifacts,
+	// the manifest, and the curation report.
+	snap := resolveSnapshot(*snapshot, *input)
+	if *taxonkitOut == "" {
+		*taxonkitOut = snapshotPath(legacyTaxonkitOutput, snap)
+	}
+	if *markerDir == "" {
+		*markerDir = snapshotPath(legacyMarkerDir, snap)
+	}
 	extractCfg := extractCurationConfig{
 		Protocol:   *extractCurateProtocol,
 		ReportPath: *extractCurateReport,
 		AuditPath:  *extractCurateAudit,
+		Snapshot:   snap,
 	}.normalized()
 	if err := extractCfg.validate(); err != nil {
 		fatalf("invalid extraction curation config: %v", err)
 	}
 
-	snap := *snapshot
-	if snap == "" {
-		snap = snapshotID(*input)
-	}
-
-	totalRows := -1
-	if *progressOn {
-		count, err := RowCount(*input)
-		if err != nil {
-			fatalf("count rows failed: %v", err)
-		}
-		totalRows = int(count)
-	}
+	totalRows := progressTotal(*input, *progressOn, *inputFlags.noCount)
 
 	reportEvery := 0
 	if *progressOn {
 		reportEvery = 1
 	}
 
-	if err := pipeline(*input, *taxonkitOut, *taxdumpDir, *markerDir, *releaseDir, *taxonkitBin, reportEvery, totalRows, *workers, !*noGzip, *force, *packageFlag, *skipManifest, *skipChecksums, snap, extractCfg); err != nil {
+	inputCfg, err := inputFlags.config()
+	if err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	inputCfg.Wrap = *wrap
+	inputCfg.Snapshot = snap
+	if inputCfg.MarkerAliases, err = loadMarkerAliases(*markerAliasSpec, inputCfg.Sanitize); err != nil {
+		fatalf("invalid input config: %v", err)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	if err := pipeline(ctx, *input, *taxonkitOut, *taxdumpDir, *markerDir, *releaseDir, *taxonkitBin, reportEvery, totalRows, explicitWorkers(fs, *workers), !*noGzip, *force, *packageFlag, *skipManifest, *skipChecksums, snap, extractCfg, inputCfg, *packageQuarantine, *reproducible); err != nil {
+		_ = inputCfg.Close()
+		var interrupt *stageInterrupt
+		if errors.As(err, &interrupt) {
+			logf("pipeline %v", interrupt)
+			currentRun().finish()
+			os.Exit(exitInterrupted)
+		}
 		fatalf("pipeline failed: %v", err)
 	}
 }
 
-func pipeline(input, taxonkitOut, taxdumpDir, markerDir, releaseDir, taxonkitBin string, reportEvery, totalRows, workers int, gzipOut, force, doPackage, skipManifest, skipChecksums bool, snapshot string, extractCfg extractCurationConfig) error {
+func pipeline(ctx context.Context, input, taxonkitOut, taxdumpDir, markerDir, releaseDir, taxonkitBin string, reportEvery, totalRows, workers int, gzipOut, force, doPackage, skipManifest, skipChecksums bool, snapshot string, extractCfg extractCurationConfig, inputCfg inputConfig, packageQuarantine, reproducible bool) error {
 	logf("Input format: %s", InputFormat(input))
+	run := currentRun()
 	logf("Extract taxonomy -> %s", taxonkitOut)
-	if fileExists(taxonkitOut) && !force {
+	done := run.stage("extract")
+	if fileExists(taxonkitOut) && !force && !outputStale(taxonkitOut) {
 		logf("taxonkit TSV exists, skipping (use --force to overwrite): %s", taxonkitOut)
 	} else {
-		if _, err := buildTaxonkit(input, taxonkitOut, reportEvery, totalRows, extractCfg); err != nil {
+		_, err := buildTaxonkit(ctx, input, taxonkitOut, reportEvery, totalRows, extractCfg, inputCfg)
+		if err := recordStage(taxonkitOut, "extract", input, err); err != nil {
 			return fmt.Errorf("build taxonkit TSV: %w", err)
 		}
 	}
+	done()
 
 	logf("Build taxdump -> %s", taxdumpDir)
-	if err := runTaxonkitCreate(taxonkitBin, taxonkitOut, taxdumpDir, force); err != nil {
-		return fmt.Errorf("taxonkit create-taxdump: %w", err)
+	done = run.stage("taxdump")
+	switch {
+	case !force && taxdumpExists(taxdumpDir):
+		logf("taxdump exists, skipping (use --force to overwrite): %s", taxdumpDir)
+	case taxonkitBin != "":
+		if err := runTaxonkitCreate(ctx, taxonkitBin, taxonkitOut, taxdumpDir, force); err != nil {
+			if ctx.Err() != nil {
+				return &stageInterrupt{Stage: "taxdump"}
+			}
+			return fmt.Errorf("taxonkit create-taxdump: %w", err)
+		}
+	default:
+		if _, err := buildTaxdump(ctx, taxdumpBuildConfig{Input: taxonkitOut, OutDir: taxdumpDir}); err != nil {
+			return fmt.Errorf("build taxdump: %w", err)
+		}
 	}
+	done()
 
 	logf("Build marker FASTAs -> %s", markerDir)
-	if outputsExist(markerDir) && !force {
+	done = run.stage("markers")
+	if outputsExist(markerDir) && !force && !outputStale(markerDir) {
 		logf("marker FASTAs exist, skipping (use --force to overwrite): %s", markerDir)
 	} else {
 		if err := os.MkdirAll(markerDir, 0o755); err != nil {
 			return fmt.Errorf("create marker output dir: %w", err)
 		}
-		if err := buildMarkerFastas(input, markerDir, gzipOut, reportEvery, totalRows, workers); err != nil {
+		err := buildMarkerFastas(ctx, input, markerDir, gzipOut, reportEvery, totalRows, workers, inputCfg)
+		if err := recordStage(markerDir, "markers", input, err); err != nil {
 			return fmt.Errorf("build markers: %w", err)
 		}
 	}
+	done()
+
+	quarantine := inputCfg.Quarantine
+	if err := quarantine.Close(); err != nil {
+		return err
+	}
+	if quarantine != nil {
+		logf("QUARANTINE: %d malformed lines (%s) -> %s", quarantine.total(), quarantine.summary(), quarantine.path)
+	}
 
 	if !doPackage {
 		return nil
 	}
+	if ctx.Err() != nil {
+		return &stageInterrupt{Stage: "package"}
+	}
 
 	cfg := packageConfig{
 		TaxdumpDir:    taxdumpDir,
@@ -116,11 +174,16 @@ func pipeline(input, taxonkitOut, taxdumpDir, markerDir, releaseDir, taxonkitBin
 		SkipManifest:  skipManifest,
 		SkipChecksums: skipChecksums,
 		MoveInputs:    true,
+		Reproducible:  reproducible,
+	}
+	if quarantine != nil && packageQuarantine {
+		cfg.QuarantinePath = quarantine.path
 	}
+	defer run.stage("package")()
 	return packageRelease(cfg)
 }
 
-func runTaxonkitCreate(bin, input, outputDir string, force bool) error {
+func runTaxonkitCreate(ctx context.Context, bin, input, outputDir string, force bool) error {
 	taxonkit := bin
 	if taxonkit == "" {
 		if p, err := exec.LookPath("taxonkit"); err == nil {
@@ -132,7 +195,7 @@ func runTaxonkitCreate(bin, input, outputDir string, force bool) error {
 		}
 	}
 
-	if !force && fileExists(filepath.Join(outputDir, "nodes.dmp")) && fileExists(filepath.Join(outputDir, "names.dmp")) && fileExists(filepath.Join(outputDir, "taxid.map")) {
+	if !force && taxdumpExists(outputDir) {
 		logf("taxdump exists, skipping (use --force to overwrite): %s", outputDir)
 		return nil
 	}
@@ -141,7 +204,7 @@ func runTaxonkitCreate(bin, input, outputDir string, force bool) error {
 		return fmt.Errorf("create taxdump dir: %w", err)
 	}
 
-	cmd := exec.Command(taxonkit, "create-taxdump", input, "-A", "10", "--null", "None,NULL,NA", "-O", outputDir, "--force")
+	cmd := exec.CommandContext(ctx, taxonkit, "create-taxdump", input, "-A", "10", "--null", "None,NULL,NA", "-O", outputDir, "--force")
 	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -149,7 +212,7 @@ func runTaxonkitCreate(bin, input, outputDir string, force bool) error {
 
 func packageMarkerPath(markerDir, releaseDir, snapshot string) string {
 	suffix := ""
-	if snapshot != "" {
+	if snapshot != "" && !hasSnapshotTag(filepath.Base(markerDir), snapshot) {
 		suffix = "." + safeTag(snapshot)
 	}
 	markerName := filepath.Base(markerDir) + suffix + ".tar.gz"
@@ -167,7 +230,7 @@ func packageTaxdumpArchivePath(taxdumpDir, releaseDir, snapshot string) string {
 
 func packageTaxonkitPath(taxonkitOut, releaseDir, snapshot string) string {
 	base := filepath.Base(taxonkitOut)
-	if snapshot != "" {
+	if snapshot != "" && !hasSnapshotTag(base, snapshot) {
 		ext := filepath.Ext(base)
 		name := strings.TrimSuffix(base, ext)
 		base = name + "." + safeTag(snapshot) + ext
@@ -177,7 +240,7 @@ func packageTaxonkitPath(taxonkitOut, releaseDir, snapshot string) string {
 
 func packageTaxonkitGzipPath(taxonkitOut, releaseDir, snapshot string) string {
 	base := filepath.Base(taxonkitOut)
-	if snapshot != "" {
+	if snapshot != "" && !hasSnapshotTag(base, snapshot) {
 		ext := filepath.Ext(base)
 		name := strings.TrimSuffix(base, ext)
 		base = name + "." + safeTag(snapshot) + ext
@@ -204,7 +267,7 @@ func packageTaxonkitGzip(src, dest string, force bool) error {
 		return copyFile(src, dest)
 	}
 
-	in, err := os.Open(src)
+	in, err := openFile(src)
 	if err != nil {
 		return fmt.Errorf("open taxonkit input: %w", err)
 	}
@@ -212,7 +275,7 @@ func packageTaxonkitGzip(src, dest string, force bool) error {
 		_ = in.Close()
 	}()
 
-	out, err := os.Create(dest)
+	out, err := createFile(dest)
 	if err != nil {
 		return fmt.Errorf("create taxonkit gzip: %w", err)
 	}
@@ -235,7 +298,7 @@ func packageTaxonkitGzip(src, dest string, force bool) error {
 }
 
 func copyFile(src, dest string) error {
-	in, err := os.Open(src)
+	in, err := openFile(src)
 	if err != nil {
 		return fmt.Errorf("open %s: %w", src, err)
 	}
@@ -243,7 +306,7 @@ func copyFile(src, dest string) error {
 		_ = in.Close()
 	}()
 
-	out, err := os.Create(dest)
+	out, err := createFile(dest)
 	if err != nil {
 		return fmt.Errorf("create %s: %w", dest, err)
 	}
@@ -257,7 +320,32 @@ func copyFile(src, dest string) error {
 	return nil
 }
 
-func packageDirGzip(srcDir, destTarGz string, force bool) error {
+// reproducibleUsage is the -reproducible flag help of package and pipeline.
+const reproducibleUsage = "Normalize tar entry times, owners, and permissions so identical inputs give identical archives and SHA256SUMS.txt (gzip headers never carry a name or timestamp)"
+
+// reproducibleModTime is the modification time of every entry in a
+// reproducible archive.
+var reproducibleModTime = time.Unix(0, 0)
+
+// normalizeTarHeader clears the entry metadata that differs between runs
+// over the same files: times, owners, and permission bits, which become
+// 0755 for directories and executables and 0644 otherwise.
+func normalizeTarHeader(hdr *tar.Header) {
+	mode := int64(0o644)
+	if hdr.Typeflag == tar.TypeDir || hdr.Mode&0o111 != 0 {
+		mode = 0o755
+	}
+	hdr.Mode = mode
+	hdr.ModTime = reproducibleModTime
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.Uid, hdr.Gid = 0, 0
+	hdr.Uname, hdr.Gname = "", ""
+}
+
+// packageDirGzip writes srcDir as a gzipped tar, its entries in lexical
+// order. With reproducible, entry metadata is normalized by
+// normalizeTarHeader.
+func packageDirGzip(srcDir, destTarGz string, force, reproducible bool) error {
 	if fileExists(destTarGz) && !force {
 		logf("archive exists, skipping (use --force to overwrite): %s", destTarGz)
 		return nil
@@ -267,7 +355,7 @@ func packageDirGzip(srcDir, destTarGz string, force bool) error {
 		return fmt.Errorf("create releases dir: %w", err)
 	}
 
-	out, err := os.Create(destTarGz)
+	out, err := createFile(destTarGz)
 	if err != nil {
 		return fmt.Errorf("create archive: %w", err)
 	}
@@ -298,13 +386,16 @@ func packageDirGzip(srcDir, destTarGz string, force bool) error {
 			return err
 		}
 		hdr.Name = filepath.ToSlash(filepath.Join(base, rel))
+		if reproducible {
+			normalizeTarHeader(hdr)
+		}
 		if err := tw.WriteHeader(hdr); err != nil {
 			return err
 		}
 		if info.IsDir() {
 			return nil
 		}
-		in, err := os.Open(path)
+		in, err := openFile(path)
 		if err != nil {
 			return err
 		}
@@ -353,7 +444,7 @@ func writeChecksums(releaseDir, outputFile string, force bool) error {
 	}
 	sort.Strings(files)
 
-	out, err := os.Create(outputFile)
+	out, err := createFile(outputFile)
 	if err != nil {
 		return err
 	}
@@ -374,7 +465,7 @@ func writeChecksums(releaseDir, outputFile string, force bool) error {
 }
 
 func sha256File(path string) (string, error) {
-	f, err := os.Open(path)
+	f, err := openFile(path)
 	if err != nil {
 		return "", err
 	}
@@ -389,7 +480,24 @@ func sha256File(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func writeManifest(path, taxdumpDir, markerDir, snapshot string, force bool) error {
+type manifestCounts struct {
+	Nodes                int `json:"nodes"`
+	Names                int `json:"names"`
+	TaxidMap             int `json:"taxid_map"`
+	MarkerFastaFiles     int `json:"marker_fasta_files"`
+	MarkerFastaSequences int `json:"marker_fasta_sequences"`
+}
+
+type releaseManifest struct {
+	reportHeader
+	SnapshotID  string         `json:"snapshot_id"`
+	CommitHash  string         `json:"commit_hash"`
+	Counts      manifestCounts `json:"counts"`
+	Quarantined map[string]int `json:"quarantined_lines,omitempty"`
+	Resources   *runResources  `json:"resources,omitempty"`
+}
+
+func writeManifest(path, taxdumpDir, markerDir, snapshot, quarantinePath string, force bool) error {
 	if fileExists(path) && !force {
 		logf("manifest exists, skipping (use --force to overwrite): %s", path)
 		return nil
@@ -426,25 +534,28 @@ func writeManifest(path, taxdumpDir, markerDir, snapshot string, force bool) err
 		return err
 	}
 
-	manifest := struct {
-		SnapshotID string `json:"snapshot_id"`
-		CommitHash string `json:"commit_hash"`
-		Counts     struct {
-			Nodes                int `json:"nodes"`
-			Names                int `json:"names"`
-			TaxidMap             int `json:"taxid_map"`
-			MarkerFastaFiles     int `json:"marker_fasta_files"`
-			MarkerFastaSequences int `json:"marker_fasta_sequences"`
-		} `json:"counts"`
-	}{
-		SnapshotID: snapshot,
-		CommitHash: commit,
-	}
-	manifest.Counts.Nodes = nodes
-	manifest.Counts.Names = names
-	manifest.Counts.TaxidMap = taxid
-	manifest.Counts.MarkerFastaFiles = len(markerFiles)
-	manifest.Counts.MarkerFastaSequences = markerSeqs
+	var quarantined map[string]int
+	if quarantinePath != "" {
+		quarantined, err = countQuarantine(quarantinePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest := releaseManifest{
+		reportHeader: newReportHeader("manifest"),
+		SnapshotID:   snapshot,
+		CommitHash:   commit,
+		Counts: manifestCounts{
+			Nodes:                nodes,
+			Names:                names,
+			TaxidMap:             taxid,
+			MarkerFastaFiles:     len(markerFiles),
+			MarkerFastaSequences: markerSeqs,
+		},
+		Quarantined: quarantined,
+		Resources:   currentRun().resources(),
+	}
 
 	data, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"io"
 	"os"
 	"time"
 
@@ -51,6 +52,15 @@ func (p *progress) increment() {
 	_ = p.bar.Add(1)
 }
 
+// add advances the bar by n. It is safe on a nil progress and from the
+// parser's reader goroutine.
+func (p *progress) add(n int) {
+	if p == nil || p.bar == nil || n == 0 {
+		return
+	}
+	_ = p.bar.Add(n)
+}
+
 func (p *progress) finish() {
 	if p.bar == nil {
 		return
@@ -104,6 +114,25 @@ func (b *byteProgress) Finish() {
 	_ = b.bar.Finish()
 }
 
+// byteProgressReader advances bar by the bytes counter has seen after each
+// read, for readers stacked on the counter such as a gzip reader. The read
+// that returns io.EOF catches up with the whole file.
+type byteProgressReader struct {
+	reader  io.Reader
+	bar     ByteProgress
+	counter *countReader
+	last    int64
+}
+
+func (r *byteProgressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if cur := r.counter.Count(); cur > r.last {
+		r.bar.Add(cur - r.last)
+		r.last = cur
+	}
+	return n, err
+}
+
 func updateByteProgress(bar *byteProgress, counter *countReader, last *int64) {
 	if bar == nil || counter == nil || last == nil {
 		return
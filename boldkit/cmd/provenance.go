@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Provenance directories hold one subdirectory per stage. Each stage writes
+// NDJSON events sharded by a hash of the record ID, so looking up one record
+// reads a single shard per stage:
+//
+//	<dir>/provenance.json                      provenance-meta report
+//	<dir>/<stage>/shard-<NNN>-<SSSS>.ndjson    provenance-event lines
+//
+// A shard file rolls over to the next sequence number once it exceeds the
+// per-file byte limit. Re-running a stage replaces that stage's events.
+const (
+	provenanceShards        = 16
+	provenanceMaxShardBytes = 64 << 20
+	provenanceMetaFile      = "provenance.json"
+)
+
+// provenanceStageOrder is the order stages appear in assembled histories.
+var provenanceStageOrder = []string{"extract", "markers", "qc", "split", "format"}
+
+// markerProvenanceDir is the provenance directory for one marker of a
+// classify or split run over a marker directory. Each marker gets its own
+// subdirectory, since re-opening a stage replaces its events.
+func markerProvenanceDir(dir, marker string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, safeTag(marker))
+}
+
+// provenanceEvent is one line of a provenance shard.
+type provenanceEvent struct {
+	ID     string `json:"id"`
+	Stage  string `json:"stage"`
+	Action string `json:"action"`
+	Detail string `json:"detail,omitempty"`
+	Line   int64  `json:"line,omitempty"`
+}
+
+type provenanceMeta struct {
+	reportHeader
+	EventSchemaVersion string   `json:"event_schema_version"`
+	Shards             int      `json:"shards"`
+	MaxShardBytes      int64    `json:"max_shard_bytes"`
+	Stages             []string `json:"stages"`
+}
+
+type provenanceShard struct {
+	file  *meteredFile
+	buf   *bufio.Writer
+	seq   int
+	bytes int64
+}
+
+// provenanceLog appends events for one stage. A nil log discards events, so
+// stages can record unconditionally.
+type provenanceLog struct {
+	dir      string
+	stage    string
+	shards   int
+	maxBytes int64
+
+	mu     sync.Mutex
+	files  []*provenanceShard
+	line   []byte
+	events int64
+	closed bool
+}
+
+// openProvenanceLog prepares dir/stage, removing events from a previous run
+// of the same stage. It returns nil when dir is empty.
+func openProvenanceLog(dir, stage string) (*provenanceLog, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	stageDir := filepath.Join(dir, stage)
+	if err := os.RemoveAll(stageDir); err != nil {
+		return nil, fmt.Errorf("reset provenance stage %s: %w", stage, err)
+	}
+	if err := os.MkdirAll(stageDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create provenance dir: %w", err)
+	}
+	return &provenanceLog{
+		dir:      dir,
+		stage:    stage,
+		shards:   provenanceShards,
+		maxBytes: provenanceMaxShardBytes,
+		files:    make([]*provenanceShard, provenanceShards),
+	}, nil
+}
+
+func provenanceShardIndex(id string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return int(h.Sum32() % uint32(shards))
+}
+
+func provenanceShardPrefix(shard int) string {
+	return fmt.Sprintf("shard-%03d-", shard)
+}
+
+// record appends one event. Events without an ID are dropped.
+func (p *provenanceLog) record(id, action, detail string, line int64) error {
+	if p == nil || id == "" {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data, err := json.Marshal(provenanceEvent{ID: id, Stage: p.stage, Action: action, Detail: detail, Line: line})
+	if err != nil {
+		return fmt.Errorf("encode provenance event: %w", err)
+	}
+	p.line = append(append(p.line[:0], data...), '\n')
+
+	idx := provenanceShardIndex(id, p.shards)
+	shard := p.files[idx]
+	if shard != nil && p.maxBytes > 0 && shard.bytes+int64(len(p.line)) > p.maxBytes {
+		if err := shard.close(); err != nil {
+			return err
+		}
+		next, err := p.openShard(idx, shard.seq+1)
+		if err != nil {
+			return err
+		}
+		p.files[idx] = next
+		shard = next
+	}
+	if shard == nil {
+		shard, err = p.openShard(idx, 0)
+		if err != nil {
+			return err
+		}
+		p.files[idx] = shard
+	}
+	if _, err := shard.buf.Write(p.line); err != nil {
+		return fmt.Errorf("write provenance: %w", err)
+	}
+	shard.bytes += int64(len(p.line))
+	p.events++
+	return nil
+}
+
+func (p *provenanceLog) openShard(idx, seq int) (*provenanceShard, error) {
+	path := filepath.Join(p.dir, p.stage, fmt.Sprintf("%s%04d.ndjson", provenanceShardPrefix(idx), seq))
+	f, err := createFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("create provenance shard: %w", err)
+	}
+	return &provenanceShard{file: f, buf: bufio.NewWriterSize(f, 64<<10), seq: seq}, nil
+}
+
+func (s *provenanceShard) close() error {
+	if err := s.buf.Flush(); err != nil {
+		_ = s.file.Close()
+		return fmt.Errorf("flush provenance shard: %w", err)
+	}
+	return s.file.Close()
+}
+
+// Close flushes all shards and updates the directory's provenance.json. It is
+// safe to call more than once.
+func (p *provenanceLog) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	var errs []error
+	for i, shard := range p.files {
+		if shard == nil {
+			continue
+		}
+		errs = append(errs, shard.close())
+		p.files[i] = nil
+	}
+	errs = append(errs, p.updateMeta())
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	logf("%s: provenance: %d events -> %s", p.stage, p.events, filepath.Join(p.dir, p.stage))
+	return nil
+}
+
+func (p *provenanceLog) updateMeta() error {
+	path := filepath.Join(p.dir, provenanceMetaFile)
+	meta := provenanceMeta{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := decodeReport("provenance-meta", data, &meta); err != nil {
+			return err
+		}
+	}
+	meta.reportHeader = newReportHeader("provenance-meta")
+	meta.EventSchemaVersion = provenanceEventSchemaVersion()
+	meta.Shards = p.shards
+	meta.MaxShardBytes = p.maxBytes
+	if !slices.Contains(meta.Stages, p.stage) {
+		meta.Stages = append(meta.Stages, p.stage)
+	}
+	return writeReportJSON(path, meta)
+}
+
+func provenanceEventSchemaVersion() string {
+	s, _ := lookupReportSchema("provenance-event")
+	return s.Version
+}
+
+// provenanceHistory returns every event for id in dir, ordered by stage and
+// then by the order the stage recorded them.
+func provenanceHistory(dir, id string) ([]provenanceEvent, error) {
+	data, err := os.ReadFile(filepath.Join(dir, provenanceMetaFile))
+	if err != nil {
+		return nil, fmt.Errorf("read provenance metadata: %w", err)
+	}
+	var meta provenanceMeta
+	if err := decodeReport("provenance-meta", data, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Shards <= 0 {
+		return nil, fmt.Errorf("provenance metadata has invalid shard count %d", meta.Shards)
+	}
+
+	stages := append([]string(nil), meta.Stages...)
+	sort.SliceStable(stages, func(i, j int) bool {
+		return provenanceStageRank(stages[i]) < provenanceStageRank(stages[j])
+	})
+
+	prefix := provenanceShardPrefix(provenanceShardIndex(id, meta.Shards))
+	needle := []byte(`"id":` + quoteJSON(id))
+	var events []provenanceEvent
+	for _, stage := range stages {
+		files, err := filepath.Glob(filepath.Join(dir, stage, prefix+"*.ndjson"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, path := range files {
+			found, err := scanProvenanceShard(path, id, needle)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, found...)
+		}
+	}
+	return events, nil
+}
+
+func scanProvenanceShard(path, id string, needle []byte) ([]provenanceEvent, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open provenance shard: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var out []provenanceEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.Contains(line, needle) {
+			continue
+		}
+		var ev provenanceEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if ev.ID == id {
+			out = append(out, ev)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan provenance shard: %w", err)
+	}
+	return out, nil
+}
+
+func provenanceStageRank(stage string) int {
+	for i, s := range provenanceStageOrder {
+		if s == stage {
+			return i
+		}
+	}
+	return len(provenanceStageOrder)
+}
+
+func quoteJSON(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func writeProvenanceHistory(w io.Writer, id string, events []provenanceEvent) error {
+	if len(events) == 0 {
+		_, err := fmt.Fprintf(w, "no provenance events for %s\n", id)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", id); err != nil {
+		return err
+	}
+	for _, ev := range events {
+		loc := ""
+		if ev.Line > 0 {
+			loc = fmt.Sprintf(" (line %d)", ev.Line)
+		}
+		detail := ""
+		if ev.Detail != "" {
+			detail = ": " + ev.Detail
+		}
+		if _, err := fmt.Fprintf(w, "  %-8s %s%s%s\n", ev.Stage, ev.Action, detail, loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runProvenance(args []string) {
+	fs := flag.NewFlagSet("provenance", flag.ExitOnError)
+	id := fs.String("id", "", "Record ID (processid) to trace")
+	dir := fs.String("dir", "", "Provenance directory written with -provenance-dir")
+	jsonOut := fs.Bool("json", false, "Print events as NDJSON instead of a summary")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *id == "" || *dir == "" {
+		fatalf("provenance requires -id and -dir")
+	}
+	events, err := provenanceHistory(*dir, *id)
+	if err != nil {
+		fatalf("provenance failed: %v", err)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		for _, ev := range events {
+			if err := enc.Encode(ev); err != nil {
+				fatalf("write events: %v", err)
+			}
+		}
+		return
+	}
+	if err := writeProvenanceHistory(os.Stdout, *id, events); err != nil {
+		fatalf("write history: %v", err)
+	}
+}
+
+// lineageChanges describes rank values changed between two records.
+func lineageChanges(before, after extractTaxonRecord) string {
+	pairs := []struct {
+		rank     string
+		from, to string
+	}{
+		{"kingdom", before.Kingdom, after.Kingdom},
+		{"phylum", before.Phylum, after.Phylum},
+		{"class", before.Class, after.Class},
+		{"order", before.Order, after.Order},
+		{"family", before.Family, after.Family},
+		{"subfamily", before.Subfamily, after.Subfamily},
+		{"tribe", before.Tribe, after.Tribe},
+		{"genus", before.Genus, after.Genus},
+		{"species", before.Species, after.Species},
+	}
+	var parts []string
+	for _, p := range pairs {
+		if p.from != p.to {
+			parts = append(parts, fmt.Sprintf("%s %q -> %q", p.rank, p.from, p.to))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
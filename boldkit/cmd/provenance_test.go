@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvenanceTracesRecordAcrossStages(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "bold.tsv")
+	content := strings.Join([]string{
+		"processid\tbin_uri\tkingdom\tphylum\tclass\torder\tfamily\tsubfamily\ttribe\tgenus\tspecies\tmarker_code\tnuc",
+		"P1\tBOLD:AAA1\tAnimalia\tChordata\tMammalia\tCarnivora\tCanidae\tNone\tNone\tCanis\tNone\tCOI-5P\tACGTACGTAC",
+		"P2\tBOLD:AAA2\tAnimalia\tChordata\tMammalia\tCarnivora\tCanidae\tNone\tNone\tCanis\tCanis lupus\tCOI-5P\tNone",
+		"P3\tBOLD:AAA3\tAnimalia\tChordata\tMammalia\tCarnivora\tFelidae\tNone\tNone\tFelis\tFelis catus\tCOI-5P\tACG",
+	}, "\n") + "\n"
+	if err := os.WriteFile(input, []byte(content), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	provDir := filepath.Join(tmp, "prov")
+	inputCfg := inputConfig{ProvenanceDir: provDir}
+
+	if _, err := buildTaxonkit(context.Background(), input, filepath.Join(tmp, "taxonkit.tsv"), 0, -1, extractCurationConfig{}.normalized(), inputCfg); err != nil {
+		t.Fatalf("buildTaxonkit: %v", err)
+	}
+	markerDir := filepath.Join(tmp, "markers")
+	if err := os.MkdirAll(markerDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := buildMarkerFastas(context.Background(), input, markerDir, false, 0, -1, 1, inputCfg); err != nil {
+		t.Fatalf("buildMarkerFastas: %v", err)
+	}
+	qcCfg := qcConfig{MinLen: 5, MaxN: -1, MaxAmbig: -1, OutputPath: filepath.Join(tmp, "qc.fasta"), ProvenanceDir: provDir}
+	if err := qcFasta(context.Background(), filepath.Join(markerDir, "COI-5P.fasta"), qcCfg); err != nil {
+		t.Fatalf("qcFasta: %v", err)
+	}
+	formatCfg := formatConfig{Classifiers: []string{"blast"}, Input: qcCfg.OutputPath, OutDir: filepath.Join(tmp, "format"), NoTaxonomy: true, ProvenanceDir: provDir}
+	if err := formatFasta(context.Background(), formatCfg); err != nil {
+		t.Fatalf("formatFasta: %v", err)
+	}
+
+	cases := []struct {
+		id   string
+		want []string
+	}{
+		{id: "P1", want: []string{
+			"extract/species_placeholder/Canis sp. BOLD:AAA1",
+			"extract/extracted/",
+			"markers/written/marker COI-5P, 10 bp",
+			"qc/kept/10 bp",
+			"format/written/blast",
+		}},
+		{id: "P2", want: []string{"extract/extracted/", "markers/skipped/no sequence"}},
+		{id: "P3", want: []string{"extract/extracted/", "markers/written/marker COI-5P, 3 bp", "qc/dropped/too_short"}},
+	}
+	for _, tc := range cases {
+		events, err := provenanceHistory(provDir, tc.id)
+		if err != nil {
+			t.Fatalf("provenanceHistory(%s): %v", tc.id, err)
+		}
+		var got []string
+		for _, ev := range events {
+			got = append(got, ev.Stage+"/"+ev.Action+"/"+ev.Detail)
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Fatalf("%s history:\n%s\nwant:\n%s", tc.id, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+
+	var buf bytes.Buffer
+	events, _ := provenanceHistory(provDir, "P1")
+	if err := writeProvenanceHistory(&buf, "P1", events); err != nil {
+		t.Fatalf("writeProvenanceHistory: %v", err)
+	}
+	if !strings.Contains(buf.String(), "markers  written: marker COI-5P, 10 bp (line 2)") {
+		t.Fatalf("unexpected history output:\n%s", buf.String())
+	}
+}
+
+func TestProvenanceShardRollover(t *testing.T) {
+	dir := t.TempDir()
+	prov, err := openProvenanceLog(dir, "qc")
+	if err != nil {
+		t.Fatalf("openProvenanceLog: %v", err)
+	}
+	prov.maxBytes = 256
+	for i := 0; i < 20; i++ {
+		if err := prov.record("P1", "kept", fmt.Sprintf("event %d", i), 0); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	if err := prov.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "qc", "*.ndjson"))
+	if len(files) < 2 {
+		t.Fatalf("expected shard rollover, got %v", files)
+	}
+	for _, f := range files {
+		if info, _ := os.Stat(f); info.Size() > 256 {
+			t.Fatalf("%s exceeds shard limit: %d bytes", f, info.Size())
+		}
+	}
+	events, err := provenanceHistory(dir, "P1")
+	if err != nil {
+		t.Fatalf("provenanceHistory: %v", err)
+	}
+	if len(events) != 20 || events[19].Detail != "event 19" {
+		t.Fatalf("events=%d last=%+v", len(events), events[len(events)-1])
+	}
+}
@@ -2,53 +2,223 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 type qcConfig struct {
-	MinLen       int
-	MaxLen       int
-	MaxN         int
-	MaxAmbig     int
-	MaxInvalid   int
-	DedupeSeqs   bool
-	DedupeIDs    bool
+	MinLen     int
+	MaxLen     int
+	MaxN       int
+	MaxAmbig   int
+	MaxInvalid int
+	DedupeSeqs bool
+	DedupeIDs  bool
+	// RequireRanks are -require-ranks entries (see parseRankRules).
 	RequireRanks []string
+	// RankAliases is the -rank-aliases value (see parseRankAliases).
+	RankAliases  string
 	TaxdumpDir   string
 	TaxidMapPath string
 	OutputPath   string
 	ReportPath   string
-	Progress     bool
+	// ReportFormat is json (or empty) or tsv. ReportMaxTaxa caps the taxa
+	// per rank in the breakdown; the rest are summed under _other.
+	ReportFormat  string
+	ReportMaxTaxa int
+	Progress      bool
+	FilterOrder   []string
+	// Workers is the number of goroutines preparing records; dedupe and
+	// output stay in input order on one goroutine (<=0 uses GOMAXPROCS).
+	Workers int
+	// ProvenanceDir enables per-record provenance events (empty disables).
+	ProvenanceDir string
+	// DedupeMemLimit is the memory budget in bytes for the dedupe sets;
+	// past it they spill hashed keys to disk (0 keeps every key in memory).
+	DedupeMemLimit int64
+	// DedupeExact verifies sequence dedupe digest matches against the full
+	// sequences, which are then kept in memory.
+	DedupeExact bool
+	// DedupeSpill uses spilling dedupe sets even without DedupeMemLimit,
+	// with defaultDedupeSpillBudget.
+	DedupeSpill bool
+	// ScratchDir holds dedupe spill files (empty: the output directory).
+	ScratchDir string
+	// MaxErrors is how many malformed taxid.map lines are skipped and listed
+	// before qc fails; -1 skips them all, listing the first few.
+	MaxErrors int
+	// TaxidMapColumn is the 1-based taxid.map column holding the taxid (0
+	// means defaultTaxidMapColumn).
+	TaxidMapColumn int
+	// ResolveByName gives records missing from taxid.map the taxid of the
+	// binomial in their description, looked up in the scientific names of
+	// names.dmp, and its synonyms too with ResolveSynonyms. ResolvedTSV
+	// receives the id/taxid/name pairs found (empty disables).
+	ResolveByName   bool
+	ResolveSynonyms bool
+	ResolvedTSV     string
+	// TaxidBloomFPP builds a Bloom filter over taxid.map keys with this
+	// false-positive rate and consults it before each lookup (0 disables).
+	TaxidBloomFPP float64
+	// Cache reuses qc output from an earlier identical run (nil disables).
+	// It is bypassed when ProvenanceDir or a rejects output is set.
+	Cache *artifactCache
+	// Taxdump shares the taxdump of TaxdumpDir with other runs (nil loads
+	// it for this run alone).
+	Taxdump *taxDumpHandle
+	// NoTaxonomy skips taxid.map and the taxdump entirely; the taxid and
+	// rank checks do not run and empty IDs count as missing_id.
+	NoTaxonomy bool
+	// Snapshot is recorded in the report (empty omits it).
+	Snapshot string
+	// SampleEvery keeps only every Nth input record and SampleLimit stops
+	// after that many kept records (0 disables either).
+	SampleEvery int64
+	SampleLimit int64
+	// Wrap splits output sequences into lines of this many bases (0 writes
+	// each on one line).
+	Wrap int
+	// BGZF writes the .gz output as bgzip blocks, with a .gzi index next to
+	// it.
+	BGZF bool
+	// KeepDesc writes each record's header description after its ID.
+	KeepDesc bool
+	// HeaderInclude keeps only records whose description matches this
+	// regexp, and HeaderExclude drops those whose description matches it
+	// (empty disables either).
+	HeaderInclude string
+	HeaderExclude string
+	// ExcludeIDs drops records whose ID is listed in the file, and
+	// IncludeIDs keeps only those listed (empty disables either).
+	ExcludeIDs string
+	IncludeIDs string
+	// OrientationMode is off (or empty), canonical, which keeps the
+	// lexicographically smaller of each sequence and its reverse complement,
+	// or reference, which orients each sequence to share the most minimizers
+	// with the OrientationRef FASTA.
+	OrientationMode string
+	OrientationRef  string
+	// CheckORF drops records with a stop codon in every forward frame
+	// under translation table ORFTable, a pseudogene (NUMT) signal.
+	CheckORF bool
+	ORFTable int
+	// MaxHomopolymer drops records whose cleaned sequence has a single-base
+	// run longer than this, and MinComplexity those whose 3-mer Shannon
+	// entropy is below this many bits (0 disables either).
+	MaxHomopolymer int
+	MinComplexity  float64
+	// TrimPrimers is a FASTA of primers to cut, with everything outside
+	// them, from the first and last primerWindow bases of each cleaned
+	// sequence, allowing PrimerMismatches substitutions (empty disables).
+	// PrimerRequired drops sequences with no primer at either end.
+	TrimPrimers      string
+	PrimerMismatches int
+	PrimerRequired   bool
+	// TrimHead and TrimTail cut that many bases from each end of the cleaned
+	// sequence, and TrimToMax then cuts the overhang beyond MaxLen evenly
+	// from both ends instead of dropping the record as too_long.
+	TrimHead  int
+	TrimTail  int
+	TrimToMax bool
+	// DedupePolicy picks which duplicate survives: first (or empty) keeps
+	// the first in input order, longest keeps the longest cleaned sequence
+	// per ID, and per-taxon keeps the MaxPerTaxon longest per taxid.
+	DedupePolicy string
+	MaxPerTaxon  int
+	// MinPerTaxon drops the records of taxa with fewer kept records, counted
+	// after dedupe (0 disables). Taxa are taxids, or the names at
+	// MinPerRank when set.
+	MinPerTaxon int
+	MinPerRank  string
+	// SplitTaxonomyCheck screens for chimeras: sequences whose halves match
+	// different families in a k-mer index of the input (see chimeraIndex).
+	// Flagged records are listed in SplitTaxonomyTSV (default: next to the
+	// output) and dropped only with SplitTaxonomyDrop. A half is assigned
+	// when it shares SplitTaxonomyVotes hashes with a family, and each
+	// family's sketch holds at most SplitTaxonomySketch hashes.
+	SplitTaxonomyCheck  bool
+	SplitTaxonomyTSV    string
+	SplitTaxonomyDrop   bool
+	SplitTaxonomyVotes  int
+	SplitTaxonomySketch int
+	// DryRun runs every filter and writes the report and side outputs, but
+	// not the output FASTA. Explain prints each filter's verdict and the
+	// values it tested for the records with this ID, to qcExplainOut.
+	DryRun  bool
+	Explain string
+	// RejectsOutput and RejectsTSV receive every dropped record, as FASTA
+	// (gzipped for .gz) and as an id/reason/detail table (empty disables
+	// either).
+	RejectsOutput string
+	RejectsTSV    string
 }
 
+// qcStats counts records seen and written plus drops per filter counter
+// (see qcLegacyCounters for the built-in names). Under NoTaxonomy the
+// qcTaxonomyCounters are not applicable and reported as null. Orientation
+// is the -orientation mode ("" when off) and Flipped the written records
+// it reverse-complemented. TrimPrimers is set under -trim-primers, and
+// TrimmedStart and TrimmedEnd count the written records cut at each end.
+// TrimLength is set under -trim-head, -trim-tail, or -trim-to-max, and
+// Trimmed and TrimmedBases count the written records they cut and the
+// bases cut from them. ResolveByName is set under -resolve-by-name, and
+// ResolvedByName and UnresolvedByName count the records missing from
+// taxid.map whose description did and did not resolve to a taxid.
+// MergedTaxID counts the records whose taxid merged.dmp replaced, when a
+// taxdump is loaded; records with a taxid in delnodes.dmp are dropped as
+// deleted_taxid by the rank check.
+// ByRank breaks the counts down by taxon at each of cfg.reportRanks when a
+// taxdump is loaded. MissingRanksByRule splits missing_ranks by the first
+// required rank rule a record failed, and MissingOptionalRanks counts the
+// written records that fail each optional rule.
 type qcStats struct {
-	Total          int `json:"total"`
-	Written        int `json:"written"`
-	MissingTaxID   int `json:"missing_taxid"`
-	MissingRanks   int `json:"missing_ranks"`
-	TooShort       int `json:"too_short"`
-	TooLong        int `json:"too_long"`
-	TooManyN       int `json:"too_many_n"`
-	TooManyAmbig   int `json:"too_many_ambig"`
-	TooManyInvalid int `json:"too_many_invalid"`
-	DupeSeq        int `json:"duplicate_sequence"`
-	DupeID         int `json:"duplicate_id"`
+	Total        int
+	Written      int
+	Dropped      map[string]int
+	NoTaxonomy   bool
+	Orientation  string
+	Flipped      int
+	TrimPrimers  bool
+	TrimmedStart int
+	TrimmedEnd   int
+	TrimLength   bool
+	Trimmed      int
+	TrimmedBases int
+	ByRank       rankBreakdown `json:",omitempty"`
+
+	ResolveByName    bool
+	ResolvedByName   int
+	UnresolvedByName int
+	MergedTaxID      int
+
+	MissingRanksByRule   map[string]int `json:",omitempty"`
+	MissingOptionalRanks map[string]int `json:",omitempty"`
 }
 
 func runQC(args []string) {
 	fs := flag.NewFlagSet("qc", flag.ExitOnError)
-	input := fs.String("input", "", "Input FASTA/FASTA.gz")
-	output := fs.String("output", "", "Output FASTA path")
+	input := fs.String("input", "", "Input FASTA, plain or gzip/bgzip (- reads stdin)")
+	output := fs.String("output", "", "Output FASTA path, gzipped when it ends in .gz (default: qc.<snapshot>/<marker>.fasta; required without a snapshot ID)")
+	gzipOutput := fs.Bool("gzip", false, "Gzip the output FASTA, adding .gz to -output when missing")
+	compression := fs.String("compression", "", compressionUsage+"; gzip and bgzf add .gz to -output when missing")
+	snapshot := addSnapshotFlag(fs, "a marker_fastas.<snapshot> -input directory")
 	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Taxdump directory with nodes.dmp/names.dmp/taxid.map")
 	taxidMap := fs.String("taxid-map", "", "Optional taxid.map override")
-	requireRanks := fs.String("require-ranks", "kingdom,phylum,class,order,family,genus,species", "Comma-separated ranks required to keep a sequence (empty disables)")
+	requireRanks := fs.String("require-ranks", "kingdom,phylum,class,order,family,genus,species", "Comma-separated ranks required to keep a sequence: a|b accepts either rank, ?rank is reported but not required (empty disables)")
+	rankAliasSpec := fs.String("rank-aliases", "", rankAliasesUsage)
 	minLen := fs.Int("min-length", 0, "Minimum cleaned sequence length (0 disables)")
 	maxLen := fs.Int("max-length", 0, "Maximum cleaned sequence length (0 disables)")
 	maxN := fs.Int("max-n", -1, "Maximum N count allowed (-1 disables)")
@@ -57,14 +227,90 @@ func runQC(args []string) {
 	dedupeSeqs := fs.Bool("dedupe", true, "Drop duplicate sequences (cleaned)")
 	dedupeIDs := fs.Bool("dedupe-ids", true, "Drop duplicate sequence IDs")
 	progressOn := fs.Bool("progress", true, "Show progress bar (approximate)")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "Worker goroutines cleaning and checking records (<=0 defaults to GOMAXPROCS)")
 	report := fs.String("report", "", "Optional JSON report output path")
+	reportFormat := fs.String("report-format", qcReportJSON, "Report format: json, or tsv for a flat per-rank table")
+	reportMaxTaxa := fs.Int("report-max-taxa", defaultReportMaxTaxa, "Taxa listed per rank in the report breakdown; the rest are summed under _other")
+	rejectsOutput := fs.String("rejects-output", "", "Write dropped records to this FASTA (.gz compresses), with reason=<counter> in the header")
+	rejectsTSV := fs.String("rejects-tsv", "", "Write an id, reason, detail table of dropped records to this path")
+	provenanceDir := fs.String("provenance-dir", "", "Record per-record provenance events under this directory")
+	cacheDir := fs.String("cache-dir", "", "Reuse qc output from identical earlier runs cached in this directory")
+	taxidBloomFPP := fs.Float64("taxid-bloom-fpp", 0, "Prefilter taxid.map lookups with a Bloom filter at this false-positive rate, e.g. 0.01 (0 disables)")
+	memLimit := fs.String("mem-limit", "", "Memory budget for dedupe state, e.g. 2G; past it hashed keys spill to disk (empty keeps all keys in memory)")
+	dedupePolicy := fs.String("dedupe-policy", dedupeFirst, "Which records survive dedupe: first (input order), longest (longest sequence per ID), or per-taxon (the -max-per-taxon longest per taxid)")
+	maxPerTaxon := fs.Int("max-per-taxon", 1, "Sequences kept per taxid under -dedupe-policy per-taxon")
+	minPerTaxon := fs.Int("min-per-taxon", 0, "Drop taxa with fewer than this many kept sequences, counted after dedupe, e.g. 2 (0 disables)")
+	splitCheck := fs.Bool("split-taxonomy-check", false, "Flag likely chimeras: sequences whose 5' and 3' halves match different families in a k-mer index of the input (reads the input twice)")
+	splitTSV := fs.String("split-taxonomy-tsv", "", "Table of flagged sequences and their two families (default: <output>.split_taxonomy.tsv)")
+	splitDrop := fs.Bool("split-taxonomy-drop", false, "Drop the sequences -split-taxonomy-check flags instead of only listing them")
+	splitVotes := fs.Int("split-taxonomy-votes", defaultSplitTaxonomyVotes, "k-mer hashes a half must share with a family to be assigned to it")
+	splitSketch := fs.Int("split-taxonomy-sketch", defaultSplitTaxonomySketch, "Maximum k-mer hashes kept per family, bounding the index memory")
+	minPerRank := fs.String("min-per-rank", "", "Rank whose taxa -min-per-taxon counts, e.g. species (default: taxid)")
+	dedupeExact := fs.Bool("dedupe-exact", false, "Confirm duplicate sequences by full comparison instead of trusting 128-bit hashes (keeps every sequence in memory)")
+	dedupeSpill := fs.Bool("dedupe-spill", false, "Keep dedupe state as hashed keys that spill to disk (budget -mem-limit, default 256M)")
+	scratchDir := fs.String("scratch-dir", "", "Directory for dedupe spill files (default: the output directory)")
+	maxErrors := fs.Int("max-errors", -1, "Skip up to this many malformed taxid.map lines and list them, failing on one more (-1 skips all, listing the first few)")
+	taxidMapColumn := fs.Int("taxid-map-column", defaultTaxidMapColumn, "1-based taxid.map column holding the taxid (the ID is column 1)")
+	resolveByName := fs.Bool("resolve-by-name", false, "Give IDs missing from taxid.map the taxid of the species name (binomial) in their header description, from names.dmp")
+	resolveSynonyms := fs.Bool("resolve-synonyms", false, "With -resolve-by-name, also match names.dmp synonyms")
+	resolvedTSV := fs.String("resolved-tsv", "", "With -resolve-by-name, write the resolved id/taxid/name pairs here, ready to append to taxid.map")
+	noTaxonomy := fs.Bool("no-taxonomy", false, "Skip taxid.map and the taxdump: no taxid or rank checks")
+	wrap := fs.Int("wrap", 0, "Wrap output sequences at this many bases per line (0 disables)")
+	keepDesc := fs.Bool("keep-desc", false, "Keep the header description after the ID in the output")
+	headerInclude := fs.String("header-include", "", "Keep only records whose header description matches this regexp")
+	headerExclude := fs.String("header-exclude", "", "Drop records whose header description matches this regexp")
+	excludeIDs := fs.String("exclude-ids", "", "Drop records whose ID is listed in this file (one per line, .gz ok, # comments)")
+	includeIDs := fs.String("include-ids", "", "Keep only records whose ID is listed in this file (one per line, .gz ok, # comments)")
+	orientation := fs.String("orientation", orientationOff, "Sequence orientation before dedupe and output: off, canonical (smaller of sequence and reverse complement), or reference (match -orientation-ref)")
+	checkORF := fs.Bool("check-orf", false, "Drop sequences with a stop codon in all three forward frames (NUMT check)")
+	orfTable := fs.Int("orf-table", defaultORFTable, "Translation table for -check-orf (supported: "+orfTables()+")")
+	maxHomopolymer := fs.Int("max-homopolymer", 0, "Drop sequences with a single-base run longer than this, e.g. 12 (0 disables)")
+	minComplexity := fs.Float64("min-complexity", 0, "Drop sequences whose 3-mer Shannon entropy is below this many bits (max 6), e.g. 3 (0 disables)")
+	trimPrimers := fs.String("trim-primers", "", "FASTA of primers (IUPAC codes ok) to cut from the first and last 40 bases of each sequence, either strand")
+	primerMismatches := fs.Int("primer-mismatches", defaultPrimerMismatches, "Substitutions allowed in a -trim-primers match")
+	primerRequired := fs.Bool("primer-required", false, "Drop sequences with no -trim-primers match at either end (amplicon-only workflows)")
+	orientationRef := fs.String("orientation-ref", "", "Reference FASTA in the wanted orientation for -orientation reference")
+	trimHead := fs.Int("trim-head", 0, "Bases to cut from the start of each cleaned sequence, before the length filters and dedupe")
+	trimTail := fs.Int("trim-tail", 0, "Bases to cut from the end of each cleaned sequence, before the length filters and dedupe")
+	trimToMax := fs.Bool("trim-to-max", false, "Trim sequences longer than -max-length to their central max-length bases instead of dropping them")
+	sampleFlags := addSampleFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Filter and write the report without writing the output FASTA, for tuning thresholds")
+	explain := fs.String("explain", "", "Print which filters the records with this ID pass or fail, with the values each tested")
+	filterOrder := fs.String("filter-order", "", "Comma-separated qc filters to run first, in order (available: "+strings.Join(qcFilterNames(), ",")+")")
 	if err := fs.Parse(args); err != nil {
 		fatalf("parse args failed: %v", err)
 	}
 
+	snap := markerStageSnapshot(*snapshot, *input, "")
+	if *output == "" && *input != "" && snap != "" {
+		*output = filepath.Join(snapshotPath(legacyQCOutDir, snap), qcBaseName(*input)+".fasta")
+	}
 	if *input == "" || *output == "" {
 		fatalf("input and output are required")
 	}
+	legacy := compressionNone
+	if *gzipOutput || strings.HasSuffix(*output, ".gz") {
+		legacy = compressionGzip
+	}
+	mode, err := resolveCompression(fs, *compression, legacy)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if mode == compressionNone && strings.HasSuffix(*output, ".gz") {
+		fatalf("-compression none: -output %s ends in .gz", *output)
+	}
+	if mode != compressionNone && !strings.HasSuffix(*output, ".gz") {
+		*output += ".gz"
+	}
+	if *noTaxonomy {
+		if err := checkNoTaxonomyFlags(fs); err != nil {
+			fatalf("%v", err)
+		}
+		*requireRanks = ""
+	}
+	if _, err := parseRankRules(splitList(*requireRanks)); err != nil {
+		fatalf("invalid -require-ranks: %v", err)
+	}
 	if *minLen < 0 || *maxLen < 0 {
 		fatalf("min-length and max-length must be >= 0")
 	}
@@ -74,32 +320,367 @@ func runQC(args []string) {
 	if *maxInvalid < 0 {
 		fatalf("max-invalid must be >= 0")
 	}
+	if *wrap < 0 {
+		fatalf("wrap must be >= 0")
+	}
+	for _, expr := range []string{*headerInclude, *headerExclude} {
+		if _, err := regexp.Compile(expr); err != nil {
+			fatalf("invalid header regexp: %v", err)
+		}
+	}
+	if !slices.Contains(orientationModes, *orientation) {
+		fatalf("orientation must be one of %s", strings.Join(orientationModes, ", "))
+	}
+	if (*orientation == orientationReference) != (*orientationRef != "") {
+		fatalf("orientation-ref is required with -orientation reference and only used with it")
+	}
+	if !slices.Contains(qcReportFormats, *reportFormat) {
+		fatalf("report-format must be one of %s", strings.Join(qcReportFormats, ", "))
+	}
+	if *reportMaxTaxa < 1 {
+		fatalf("report-max-taxa must be >= 1")
+	}
+	if *primerMismatches < 0 {
+		fatalf("primer-mismatches must be >= 0")
+	}
+	if *primerRequired && *trimPrimers == "" {
+		fatalf("primer-required needs -trim-primers")
+	}
+	if *trimHead < 0 || *trimTail < 0 {
+		fatalf("trim-head and trim-tail must be >= 0")
+	}
+	if *trimToMax && *maxLen == 0 {
+		fatalf("trim-to-max needs -max-length")
+	}
+	if *maxHomopolymer < 0 {
+		fatalf("max-homopolymer must be >= 0")
+	}
+	if *minComplexity < 0 || *minComplexity > maxKmerEntropy {
+		fatalf("min-complexity must be in [0, %g]", maxKmerEntropy)
+	}
+	if _, ok := stopCodons[*orfTable]; !ok {
+		fatalf("orf-table must be one of %s", orfTables())
+	}
+	var dedupeMemLimit int64
+	if *memLimit != "" {
+		n, err := parseByteSize(*memLimit)
+		if err != nil {
+			fatalf("invalid -mem-limit: %v", err)
+		}
+		dedupeMemLimit = n
+	}
+	if !slices.Contains(dedupePolicies, *dedupePolicy) {
+		fatalf("dedupe-policy must be one of %s", strings.Join(dedupePolicies, ", "))
+	}
+	if *dedupePolicy == dedupeLongest && !*dedupeIDs {
+		fatalf("dedupe-policy longest picks among duplicate IDs; it needs -dedupe-ids")
+	}
+	if *dedupePolicy == dedupePerTaxon && *noTaxonomy {
+		fatalf("dedupe-policy per-taxon needs taxid.map; it cannot be combined with -no-taxonomy")
+	}
+	if *maxPerTaxon < 1 {
+		fatalf("max-per-taxon must be >= 1")
+	}
+	if *minPerTaxon < 0 {
+		fatalf("min-per-taxon must be >= 0")
+	}
+	if *minPerRank != "" && *minPerTaxon == 0 {
+		fatalf("min-per-rank needs -min-per-taxon")
+	}
+	if *minPerTaxon > 0 && *noTaxonomy {
+		fatalf("min-per-taxon needs taxid.map; it cannot be combined with -no-taxonomy")
+	}
+	if (*splitTSV != "" || *splitDrop) && !*splitCheck {
+		fatalf("split-taxonomy-tsv and split-taxonomy-drop need -split-taxonomy-check")
+	}
+	if *splitCheck && *noTaxonomy {
+		fatalf("split-taxonomy-check assigns families; it cannot be combined with -no-taxonomy")
+	}
+	if *splitCheck && *input == stdinPath {
+		fatalf("split-taxonomy-check reads the input twice; it cannot read standard input")
+	}
+	if *dryRun && *provenanceDir != "" {
+		fatalf("dry-run writes no output; provenance would record records as kept that are not written")
+	}
+	if *splitVotes < 1 || *splitSketch < 1 {
+		fatalf("split-taxonomy-votes and split-taxonomy-sketch must be >= 1")
+	}
+	if *dedupeExact && (dedupeMemLimit > 0 || *dedupeSpill) {
+		fatalf("dedupe-exact keeps sequences in memory; it cannot be combined with -mem-limit or -dedupe-spill")
+	}
+	if *maxErrors < -1 {
+		fatalf("max-errors must be >= -1")
+	}
+	if *taxidMapColumn < 2 {
+		fatalf("taxid-map-column must be >= 2")
+	}
+	if (*resolveSynonyms || *resolvedTSV != "") && !*resolveByName {
+		fatalf("resolve-synonyms and resolved-tsv need -resolve-by-name")
+	}
+	if *taxidBloomFPP < 0 || *taxidBloomFPP >= 1 {
+		fatalf("taxid-bloom-fpp must be in [0, 1)")
+	}
+	sample, err := sampleFlags.config()
+	if err != nil {
+		fatalf("%v", err)
+	}
 
 	cfg := qcConfig{
-		MinLen:       *minLen,
-		MaxLen:       *maxLen,
-		MaxN:         *maxN,
-		MaxAmbig:     *maxAmbig,
-		MaxInvalid:   *maxInvalid,
-		DedupeSeqs:   *dedupeSeqs,
-		DedupeIDs:    *dedupeIDs,
-		RequireRanks: splitList(*requireRanks),
-		TaxdumpDir:   *taxdumpDir,
-		TaxidMapPath: *taxidMap,
-		OutputPath:   *output,
-		ReportPath:   *report,
-		Progress:     *progressOn,
-	}
-
-	if err := qcFasta(*input, cfg); err != nil {
+		MinLen:              *minLen,
+		MaxLen:              *maxLen,
+		MaxN:                *maxN,
+		MaxAmbig:            *maxAmbig,
+		MaxInvalid:          *maxInvalid,
+		DedupeSeqs:          *dedupeSeqs,
+		DedupeIDs:           *dedupeIDs,
+		DedupeExact:         *dedupeExact,
+		DedupePolicy:        *dedupePolicy,
+		MaxPerTaxon:         *maxPerTaxon,
+		MinPerTaxon:         *minPerTaxon,
+		MinPerRank:          *minPerRank,
+		SplitTaxonomyCheck:  *splitCheck,
+		SplitTaxonomyTSV:    *splitTSV,
+		SplitTaxonomyDrop:   *splitDrop,
+		SplitTaxonomyVotes:  *splitVotes,
+		SplitTaxonomySketch: *splitSketch,
+		DryRun:              *dryRun,
+		Explain:             *explain,
+		RequireRanks:        splitList(*requireRanks),
+		RankAliases:         *rankAliasSpec,
+		TaxdumpDir:          *taxdumpDir,
+		TaxidMapPath:        *taxidMap,
+		OutputPath:          *output,
+		ReportPath:          *report,
+		ReportFormat:        *reportFormat,
+		ReportMaxTaxa:       *reportMaxTaxa,
+		Progress:            *progressOn,
+		ProvenanceDir:       *provenanceDir,
+		FilterOrder:         splitList(*filterOrder),
+		Workers:             *workers,
+		MaxErrors:           *maxErrors,
+		TaxidMapColumn:      *taxidMapColumn,
+		ResolveByName:       *resolveByName,
+		ResolveSynonyms:     *resolveSynonyms,
+		ResolvedTSV:         *resolvedTSV,
+		TaxidBloomFPP:       *taxidBloomFPP,
+		DedupeMemLimit:      dedupeMemLimit,
+		DedupeSpill:         *dedupeSpill,
+		ScratchDir:          *scratchDir,
+		NoTaxonomy:          *noTaxonomy,
+		Snapshot:            snap,
+		SampleEvery:         sample.Every,
+		SampleLimit:         sample.Limit,
+		Wrap:                *wrap,
+		BGZF:                mode == compressionBGZF,
+		KeepDesc:            *keepDesc,
+		HeaderInclude:       *headerInclude,
+		HeaderExclude:       *headerExclude,
+		ExcludeIDs:          *excludeIDs,
+		IncludeIDs:          *includeIDs,
+		OrientationMode:     *orientation,
+		OrientationRef:      *orientationRef,
+		CheckORF:            *checkORF,
+		ORFTable:            *orfTable,
+		MaxHomopolymer:      *maxHomopolymer,
+		MinComplexity:       *minComplexity,
+		TrimPrimers:         *trimPrimers,
+		TrimHead:            *trimHead,
+		TrimTail:            *trimTail,
+		TrimToMax:           *trimToMax,
+		PrimerMismatches:    *primerMismatches,
+		PrimerRequired:      *primerRequired,
+		RejectsOutput:       *rejectsOutput,
+		RejectsTSV:          *rejectsTSV,
+	}
+	if _, err := orderedQCFilters(cfg.FilterOrder); err != nil {
+		fatalf("invalid -filter-order: %v", err)
+	}
+	if cfg, _, err = cfg.withRankAliases(); err != nil {
+		fatalf("invalid -rank-aliases: %v", err)
+	}
+	cache, err := openArtifactCache(*cacheDir)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	cfg.Cache = cache
+
+	ctx, stop := interruptContext()
+	defer stop()
+	err = qcFasta(ctx, *input, cfg)
+	if !cfg.DryRun {
+		err = recordStage(cfg.OutputPath, "qc", *input, err)
+	}
+	exitIfInterrupted(err)
+	if err != nil {
 		fatalf("qc failed: %v", err)
 	}
 }
 
-func qcFasta(input string, cfg qcConfig) error {
-	in, counter, err := openInputWithCounter(input)
+func qcFasta(ctx context.Context, input string, cfg qcConfig) error {
+	var key string
+	// Standard input cannot be hashed ahead of the run, so it is not cached.
+	// Provenance, rejects, and the split-taxonomy and resolved tables are
+	// side outputs the cache does not keep, and -explain prints during the
+	// run.
+	uncached := cfg.ProvenanceDir != "" || cfg.RejectsOutput != "" || cfg.RejectsTSV != "" || cfg.SplitTaxonomyCheck || cfg.Explain != "" || cfg.ResolvedTSV != ""
+	if cfg.Cache != nil && !uncached && input != stdinPath {
+		var err error
+		if key, err = qcCacheKey(input, cfg); err != nil {
+			return err
+		}
+		if e, ok := cfg.Cache.lookup(key); ok {
+			var stats qcStats
+			if err := json.Unmarshal(e.Stats, &stats); err != nil {
+				return fmt.Errorf("cache entry %s: %w", key, err)
+			}
+			// A dry run only needs the cached counts.
+			if !cfg.DryRun {
+				for _, path := range qcOutputFiles(cfg) {
+					if err := e.restore(filepath.Base(path), path); err != nil {
+						return err
+					}
+				}
+			}
+			logf("qc: cache hit %s", key[:12])
+			return finishQC(cfg, stats)
+		}
+	}
+
+	stats, err := runQCFasta(ctx, input, cfg)
+	if err != nil {
+		return err
+	}
+	if key != "" && !cfg.DryRun {
+		if err := cfg.Cache.store(key, "qc", "", qcOutputFiles(cfg), stats); err != nil {
+			logf("qc: WARNING not cached: %v", err)
+		}
+	}
+	return finishQC(cfg, stats)
+}
+
+// qcOutputFiles lists the files a qc run writes that the cache keeps: the
+// output FASTA and its .gzi under BGZF.
+func qcOutputFiles(cfg qcConfig) []string {
+	if cfg.BGZF {
+		return []string{cfg.OutputPath, cfg.OutputPath + ".gzi"}
+	}
+	return []string{cfg.OutputPath}
+}
+
+// qcCacheKey covers every qc option that affects the output, the registered
+// filters, and the reference files the run loads.
+func qcCacheKey(input string, cfg qcConfig) (string, error) {
+	opts := cacheOptions(cfg, qcUncachedOptions...)
+	opts["registered_filters"] = qcFilterNames()
+	// The output path is not part of the key, but whether it is gzipped is.
+	opts["gzip_output"] = strings.HasSuffix(cfg.OutputPath, ".gz")
+	refs := make(map[string]string)
+	for name, path := range map[string]string{"orientation_ref": cfg.OrientationRef, "exclude_ids": cfg.ExcludeIDs, "include_ids": cfg.IncludeIDs, "trim_primers": cfg.TrimPrimers} {
+		if path != "" {
+			refs[name] = path
+		}
+	}
+	if cfg.NoTaxonomy {
+		return cfg.Cache.key("qc", input, opts, refs)
+	}
+	if err := addRankAliasOption(opts, cfg.RankAliases); err != nil {
+		return "", err
+	}
+	if cfg.needsTaxidMap() {
+		refs["taxid.map"] = cfg.TaxidMapPath
+		if refs["taxid.map"] == "" {
+			refs["taxid.map"] = filepath.Join(cfg.TaxdumpDir, "taxid.map")
+		}
+	}
+	if cfg.needsTaxDump() {
+		refs["nodes.dmp"] = filepath.Join(cfg.TaxdumpDir, "nodes.dmp")
+		addOptionalDmpRefs(refs, cfg.TaxdumpDir)
+	}
+	if cfg.needsTaxDump() || cfg.ResolveByName {
+		refs["names.dmp"] = filepath.Join(cfg.TaxdumpDir, "names.dmp")
+	}
+	return cfg.Cache.key("qc", input, opts, refs)
+}
+
+// qcUncachedOptions do not change the qc output: paths are replaced by the
+// content hashes of the files they name, RankAliases by the aliases it
+// parses to, a shared Taxdump is the one TaxdumpDir names, the spill,
+// Bloom, and worker options only trade memory for speed, and a dry run or
+// -explain only changes what is written.
+var qcUncachedOptions = []string{"TaxdumpDir", "TaxidMapPath", "OutputPath", "ReportPath", "ReportFormat", "Progress", "Workers", "ProvenanceDir", "DedupeMemLimit", "DedupeSpill", "ScratchDir", "TaxidBloomFPP", "Cache", "Snapshot", "OrientationRef", "ExcludeIDs", "IncludeIDs", "TrimPrimers", "SplitTaxonomyTSV", "DryRun", "Explain", "ResolvedTSV", "RankAliases", "Taxdump"}
+
+// needsTaxidMap reports whether qc loads taxid.map: for the rank check, an
+// explicit -taxid-map, -resolve-by-name, per-taxon dedupe, -min-per-taxon,
+// or the split-taxonomy screen.
+func (cfg qcConfig) needsTaxidMap() bool {
+	return len(cfg.RequireRanks) > 0 || cfg.TaxidMapPath != "" || cfg.ResolveByName || cfg.DedupePolicy == dedupePerTaxon || cfg.MinPerTaxon > 0 || cfg.SplitTaxonomyCheck
+}
+
+// needsTaxDump reports whether qc loads nodes.dmp and names.dmp: for the
+// rank check, to count -min-per-taxon at a rank, or for the families of the
+// split-taxonomy screen.
+func (cfg qcConfig) needsTaxDump() bool {
+	return len(cfg.RequireRanks) > 0 || cfg.MinPerRank != "" || cfg.SplitTaxonomyCheck
+}
+
+// withRankAliases returns cfg with the ranks of RequireRanks and MinPerRank
+// under their RankAliases names, and the aliases. It is idempotent.
+func (cfg qcConfig) withRankAliases() (qcConfig, rankAliases, error) {
+	aliases, err := parseRankAliases(cfg.RankAliases)
 	if err != nil {
-		return fmt.Errorf("open input: %w", err)
+		return cfg, nil, err
+	}
+	cfg.RequireRanks = aliases.ruleSpecs(cfg.RequireRanks)
+	if cfg.MinPerRank != "" {
+		cfg.MinPerRank = aliases.canonical(cfg.MinPerRank)
+	}
+	return cfg, aliases, nil
+}
+
+// reportRanks are the ranks of the report breakdown: every rank the rank
+// rules name, then the -min-per-rank rank when it is not one of them.
+func (cfg qcConfig) reportRanks() []string {
+	rules, _ := parseRankRules(cfg.RequireRanks)
+	ranks := rules.ranks()
+	if cfg.MinPerRank == "" || slices.Contains(ranks, cfg.MinPerRank) {
+		return ranks
+	}
+	return append(ranks, cfg.MinPerRank)
+}
+
+func finishQC(cfg qcConfig, stats qcStats) error {
+	switch {
+	case cfg.ReportPath == "":
+	case cfg.ReportFormat == qcReportTSV:
+		if err := writeQCReportTSV(cfg.ReportPath, stats, cfg.reportRanks()); err != nil {
+			return err
+		}
+	default:
+		if err := writeQCReport(cfg.ReportPath, stats, cfg.Snapshot); err != nil {
+			return err
+		}
+	}
+	var edits string
+	if stats.Orientation != "" {
+		edits += fmt.Sprintf(" flipped=%d", stats.Flipped)
+	}
+	if stats.TrimPrimers {
+		edits += fmt.Sprintf(" trimmed-start=%d trimmed-end=%d", stats.TrimmedStart, stats.TrimmedEnd)
+	}
+	if stats.TrimLength {
+		edits += fmt.Sprintf(" trimmed=%d trimmed-bases=%d", stats.Trimmed, stats.TrimmedBases)
+	}
+	if stats.ResolveByName {
+		edits += fmt.Sprintf(" resolved-by-name=%d unresolved=%d", stats.ResolvedByName, stats.UnresolvedByName)
+	}
+	logf("qc: total=%d kept=%d%s drop %s", stats.Total, stats.Written, edits, stats.dropSummary())
+	return nil
+}
+
+func runQCFasta(ctx context.Context, input string, cfg qcConfig) (qcStats, error) {
+	in, counter, err := openFastaInput(input)
+	if err != nil {
+		return qcStats{}, fmt.Errorf("open input: %w", err)
 	}
 	defer func() {
 		_ = in.Close()
@@ -112,152 +693,341 @@ func qcFasta(input string, cfg qcConfig) error {
 		bar = newByteProgress(total, "qc (approx)")
 	}
 
-	if err := os.MkdirAll(filepath.Dir(cfg.OutputPath), 0o755); err != nil {
-		return fmt.Errorf("create output dir: %w", err)
+	// The tsvWriter supplies buffered, .gz-aware output; its Close flushes
+	// the buffer, then finishes the gzip stream, then closes the file. A dry
+	// run has no output.
+	workers := cmp.Or(max(cfg.Workers, 0), runtime.GOMAXPROCS(0))
+	var out *tsvWriter
+	var fasta fastaWriter
+	if !cfg.DryRun {
+		if err := os.MkdirAll(filepath.Dir(cfg.OutputPath), 0o755); err != nil {
+			return qcStats{}, fmt.Errorf("create output dir: %w", err)
+		}
+		// The old output may be hardlinked into the cache; never rewrite it.
+		if err := removeIfExists(cfg.OutputPath); err != nil {
+			return qcStats{}, err
+		}
+		for _, p := range []string{cfg.OutputPath + ".gzi", cfg.OutputPath + ".fai"} {
+			if err := removeIfExists(p); err != nil {
+				return qcStats{}, err
+			}
+		}
+		if out, err = createTSVWriter(cfg.OutputPath, tsvWriterOptions{GzipWorkers: workers, BGZF: cfg.BGZF}); err != nil {
+			return qcStats{}, fmt.Errorf("create output: %w", err)
+		}
+		defer func() {
+			_ = out.Close()
+		}()
+		fasta = newFastaWriter(out.buf, cfg.Wrap)
 	}
-	out, err := os.Create(cfg.OutputPath)
+
+	cfg, aliases, err := cfg.withRankAliases()
 	if err != nil {
-		return fmt.Errorf("create output: %w", err)
+		return qcStats{}, fmt.Errorf("rank aliases: %w", err)
 	}
-	defer func() {
-		_ = out.Close()
-	}()
-	writer := bufio.NewWriterSize(out, writerBufferSize)
-	defer func() {
-		_ = writer.Flush()
-	}()
-
 	var taxidMap map[string]int
 	var dump *taxDump
-	if len(cfg.RequireRanks) > 0 || cfg.TaxidMapPath != "" {
+	if cfg.NoTaxonomy {
+		cfg.RequireRanks = nil
+	} else if cfg.needsTaxidMap() {
 		taxidPath := cfg.TaxidMapPath
 		if taxidPath == "" {
 			taxidPath = filepath.Join(cfg.TaxdumpDir, "taxid.map")
 		}
-		taxidMap, err = loadTaxidMap(taxidPath)
-		if err != nil {
-			return err
+		taxidMap, err = loadTaxidMap(taxidPath, cfg.TaxidMapColumn, cfg.MaxErrors)
+		if err = acceptRowErrors("qc: taxid.map", err); err != nil {
+			return qcStats{}, err
 		}
 	}
-	if len(cfg.RequireRanks) > 0 {
-		nodesPath := filepath.Join(cfg.TaxdumpDir, "nodes.dmp")
-		namesPath := filepath.Join(cfg.TaxdumpDir, "names.dmp")
-		dump, err = loadTaxDump(nodesPath, namesPath)
+	if cfg.needsTaxDump() {
+		dump, err = cfg.Taxdump.load(cfg.TaxdumpDir, aliases)
 		if err != nil {
-			return err
+			return qcStats{}, err
 		}
 	}
+	// The name index roughly doubles the memory of names.dmp, so it is only
+	// built for -resolve-by-name.
+	var resolver *nameResolver
+	if cfg.ResolveByName {
+		if resolver, err = loadNameResolver(filepath.Join(cfg.TaxdumpDir, "names.dmp"), cfg.ResolveSynonyms); err != nil {
+			return qcStats{}, err
+		}
+		logf("qc: resolve-by-name index: %d names", len(resolver.taxids))
+	}
+	resolvedTable, err := openResolvedTable(cfg.ResolvedTSV)
+	if err != nil {
+		return qcStats{}, err
+	}
+	defer func() {
+		_ = resolvedTable.Close()
+	}()
+
+	prov, err := openProvenanceLog(cfg.ProvenanceDir, "qc")
+	if err != nil {
+		return qcStats{}, err
+	}
+	defer func() {
+		_ = prov.Close()
+	}()
 
-	stats := qcStats{}
-	seenSeqs := make(map[string]struct{})
-	seenIDs := make(map[string]struct{})
+	rejects, err := openQCRejects(cfg)
+	if err != nil {
+		return qcStats{}, err
+	}
+	defer func() {
+		_ = rejects.Close()
+	}()
 
-	err = parseFasta(in, func(rec fastaRecord) error {
-		stats.Total++
-		if rec.id == "" {
-			stats.MissingTaxID++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
+	var bloom *bloomFilter
+	if cfg.TaxidBloomFPP > 0 && taxidMap != nil {
+		if bloom, err = taxidBloom(taxidMap, cfg.TaxidBloomFPP); err != nil {
+			return qcStats{}, err
 		}
-		if cfg.DedupeIDs {
-			if _, ok := seenIDs[rec.id]; ok {
-				stats.DupeID++
-				updateByteProgress(bar, counter, &lastCount)
-				return nil
-			}
-			seenIDs[rec.id] = struct{}{}
+		logf("qc: taxid bloom filter %s, %d hashes (fpp %g)", formatMiB(uint64(bloom.sizeBytes())), bloom.k, cfg.TaxidBloomFPP)
+	}
+
+	orient, err := newSeqOrienter(cfg)
+	if err != nil {
+		return qcStats{}, err
+	}
+	var primers *primerTrimmer
+	if cfg.TrimPrimers != "" {
+		if primers, err = loadPrimerTrimmer(cfg.TrimPrimers, cfg.PrimerMismatches); err != nil {
+			return qcStats{}, err
 		}
+		logf("qc: trimming %d primers (%d mismatches allowed)", len(primers.patterns)/2, cfg.PrimerMismatches)
+	}
+	rules, err := parseRankRules(cfg.RequireRanks)
+	if err != nil {
+		return qcStats{}, fmt.Errorf("require ranks: %w", err)
+	}
+	env := &QCFilterEnv{cfg: cfg, taxidMap: taxidMap, taxidBloom: bloom, dump: dump, rankRules: rules, orient: orient, primers: primers, lengthTrim: newLengthTrimmer(cfg), resolver: resolver}
+	if resolver != nil {
+		env.resolved = make(map[string]int)
+	}
+	defer func() {
+		_ = env.close()
+	}()
+	var chimeras *chimeraTable
+	if cfg.SplitTaxonomyCheck {
+		if env.chimeras, err = buildChimeraIndex(input, env); err != nil {
+			return qcStats{}, err
+		}
+		logf("qc: split-taxonomy index: %d families, %d k-mer hashes", len(env.chimeras.families), len(env.chimeras.hits))
+		if chimeras, err = openChimeraTable(cfg); err != nil {
+			return qcStats{}, err
+		}
+		defer func() {
+			_ = chimeras.Close()
+		}()
+	}
+	chain, err := newQCChain(env, cfg.FilterOrder)
+	if err != nil {
+		return qcStats{}, err
+	}
+	stats := chain.newStats()
+	sel, err := newQCSelector(env)
+	if err != nil {
+		return qcStats{}, err
+	}
+	defer func() {
+		_ = sel.close()
+	}()
+	if sel != nil {
+		for _, name := range sel.counters() {
+			stats.Dropped[name] = 0
+		}
+	}
 
-		var taxid int
-		if taxidMap != nil {
-			var ok bool
-			taxid, ok = taxidMap[rec.id]
-			if !ok {
-				stats.MissingTaxID++
-				updateByteProgress(bar, counter, &lastCount)
-				return nil
+	drop := func(rec *QCRecord, reason string) error {
+		stats.Dropped[reason]++
+		if reason == "missing_ranks" && stats.MissingRanksByRule != nil {
+			if i := rules.firstFailed(rec.rankLineage()); i >= 0 {
+				stats.MissingRanksByRule[rules[i].String()]++
 			}
 		}
-
-		if len(cfg.RequireRanks) > 0 && dump != nil {
-			lineage := dump.lineage(taxid)
-			if !hasAllRanks(lineage, cfg.RequireRanks) {
-				stats.MissingRanks++
-				updateByteProgress(bar, counter, &lastCount)
-				return nil
+		if stats.ByRank != nil {
+			stats.ByRank.add(env.lineageOf(rec.ID), reason)
+		}
+		if err := rejects.write(rec, reason); err != nil {
+			return err
+		}
+		return prov.record(rec.ID, "dropped", reason, 0)
+	}
+	keep := func(id, header string, clean []byte, edits seqEdits, trimmed int) error {
+		if out != nil {
+			if err := fasta.Write(header, clean); err != nil {
+				return err
 			}
 		}
-
-		clean, counts := cleanSequence(rec.seq)
-		if len(clean) == 0 {
-			stats.TooShort++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
+		stats.Written++
+		if stats.ByRank != nil || stats.MissingOptionalRanks != nil {
+			lineage := env.lineageOf(id)
+			stats.ByRank.add(lineage, "")
+			for _, r := range rules {
+				if r.optional && !r.met(lineage) {
+					stats.MissingOptionalRanks[r.String()]++
+				}
+			}
 		}
-		if cfg.MinLen > 0 && len(clean) < cfg.MinLen {
-			stats.TooShort++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
+		if edits&editFlipped != 0 {
+			stats.Flipped++
 		}
-		if cfg.MaxLen > 0 && len(clean) > cfg.MaxLen {
-			stats.TooLong++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
+		if edits&editTrimmedStart != 0 {
+			stats.TrimmedStart++
 		}
-		if cfg.MaxN >= 0 && counts.n > cfg.MaxN {
-			stats.TooManyN++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
+		if edits&editTrimmedEnd != 0 {
+			stats.TrimmedEnd++
 		}
-		if cfg.MaxAmbig >= 0 && counts.ambig > cfg.MaxAmbig {
-			stats.TooManyAmbig++
-			updateByteProgress(bar, counter, &lastCount)
-			return nil
+		if trimmed > 0 {
+			stats.Trimmed++
+			stats.TrimmedBases += trimmed
 		}
-		if counts.invalid > cfg.MaxInvalid {
-			stats.TooManyInvalid++
-			updateByteProgress(bar, counter, &lastCount)
+		if prov == nil {
 			return nil
 		}
-		if cfg.DedupeSeqs {
-			key := string(clean)
-			if _, ok := seenSeqs[key]; ok {
-				stats.DupeSeq++
-				updateByteProgress(bar, counter, &lastCount)
-				return nil
+		detail := fmt.Sprintf("%d bp", len(clean))
+		if edits&(editTrimmedStart|editTrimmedEnd) != 0 {
+			detail += ", primers trimmed"
+		}
+		if trimmed > 0 {
+			detail += fmt.Sprintf(", %d bases trimmed", trimmed)
+		}
+		if edits&editFlipped != 0 {
+			detail += ", reverse-complemented"
+		}
+		return prov.record(id, "kept", detail, 0)
+	}
+
+	sample := sampleConfig{Every: cfg.SampleEvery, Limit: cfg.SampleLimit}
+	if sample.enabled() {
+		logf("qc: sampling %s", sample)
+	}
+	explained := 0
+	err = chain.parseRecords(ctx, in, workers, sample, func(qrec *QCRecord) error {
+		stats.Total++
+		defer updateByteProgress(bar, counter, &lastCount)
+		var reason string
+		if cfg.Explain != "" && qrec.ID == cfg.Explain {
+			steps, r := chain.explain(qrec)
+			explained++
+			if err := writeQCExplain(qcExplainOut, qrec, explained, steps, r); err != nil {
+				return fmt.Errorf("explain %s: %w", qrec.ID, err)
 			}
-			seenSeqs[key] = struct{}{}
+			reason = r
+		} else {
+			reason = chain.check(qrec)
+		}
+		if err := env.dedupeErr(); err != nil {
+			return err
+		}
+		switch {
+		case qrec.byName != "":
+			stats.ResolvedByName++
+			if _, seen := env.resolved[qrec.ID]; !seen {
+				env.resolved[qrec.ID] = qrec.taxid
+				if err := resolvedTable.write(qrec.ID, qrec.taxid, qrec.byName); err != nil {
+					return err
+				}
+			}
+		case qrec.nameMissed:
+			stats.UnresolvedByName++
+		}
+		if qrec.merged {
+			stats.MergedTaxID++
+		}
+		// Flagged records dropped for another reason need no review.
+		if qrec.chimera != nil && (reason == "" || reason == "split_taxonomy") {
+			if err := chimeras.write(qrec.ID, qrec.chimera); err != nil {
+				return err
+			}
+		}
+		if reason != "" {
+			return drop(qrec, reason)
 		}
 
-		if _, err := writer.WriteString(">" + rec.id + "\n"); err != nil {
-			return fmt.Errorf("write header: %w", err)
+		header := qrec.ID
+		if cfg.KeepDesc && qrec.Desc != "" {
+			header += " " + qrec.Desc
 		}
-		if _, err := writer.Write(clean); err != nil {
-			return fmt.Errorf("write seq: %w", err)
+		if sel == nil {
+			return keep(qrec.ID, header, qrec.Clean(), qrec.edits(), qrec.TrimmedBases())
 		}
-		if _, err := writer.WriteString("\n"); err != nil {
-			return fmt.Errorf("write newline: %w", err)
+		reason, displaced, err := sel.offer(qrec, header)
+		if displaced {
+			stats.Dropped["displaced"]++
 		}
-		stats.Written++
-		updateByteProgress(bar, counter, &lastCount)
-		return nil
+		if err != nil || reason == "" {
+			return err
+		}
+		return drop(qrec, reason)
 	})
 	if err != nil {
-		return err
+		return qcStats{}, interruptedAt(ctx, err, "qc", int64(stats.Total))
+	}
+	if sel != nil {
+		// Displaced records were counted when displaced; the replay writes
+		// the winners of taxa with enough records and reports the rest.
+		err := sel.replay(func(id, header string, seq []byte, edits seqEdits, trimmed int, reason string) error {
+			if reason == "" {
+				return keep(id, header, seq, edits, trimmed)
+			}
+			if reason != "displaced" {
+				stats.Dropped[reason]++
+			}
+			if err := rejects.write(&QCRecord{ID: id, Seq: seq}, reason); err != nil {
+				return err
+			}
+			if stats.ByRank != nil {
+				stats.ByRank.add(env.lineageOf(id), reason)
+			}
+			return prov.record(id, "dropped", reason, 0)
+		})
+		if err != nil {
+			return qcStats{}, err
+		}
+		if err := sel.close(); err != nil {
+			return qcStats{}, fmt.Errorf("remove dedupe candidates: %w", err)
+		}
+	}
+	if cfg.Explain != "" && explained == 0 {
+		logf("qc: WARNING -explain %s: no record has this ID", cfg.Explain)
+	}
+	if out != nil {
+		if err := out.Close(); err != nil {
+			return qcStats{}, fmt.Errorf("write output: %w", err)
+		}
+		if blocks, ok := out.bgzfBlocks(); ok {
+			if err := writeGZIFile(cfg.OutputPath+".gzi", blocks); err != nil {
+				return qcStats{}, err
+			}
+		}
+	}
+	if err := prov.Close(); err != nil {
+		return qcStats{}, err
+	}
+	if err := rejects.Close(); err != nil {
+		return qcStats{}, err
+	}
+	if err := resolvedTable.Close(); err != nil {
+		return qcStats{}, err
+	}
+	if chimeras != nil {
+		if err := chimeras.Close(); err != nil {
+			return qcStats{}, err
+		}
+		logf("qc: split-taxonomy flagged %d sequences -> %s", chimeras.flagged, chimeras.path)
+	}
+	if err := env.close(); err != nil {
+		return qcStats{}, fmt.Errorf("remove dedupe spill files: %w", err)
 	}
 	updateByteProgress(bar, counter, &lastCount)
 	if bar != nil {
 		bar.Finish()
 	}
+	stats.ByRank.capTaxa(cmp.Or(cfg.ReportMaxTaxa, defaultReportMaxTaxa))
 
-	if cfg.ReportPath != "" {
-		if err := writeQCReport(cfg.ReportPath, stats); err != nil {
-			return err
-		}
-	}
-	logf("qc: total=%d kept=%d drop taxid=%d ranks=%d short=%d long=%d n=%d ambig=%d invalid=%d dup-seq=%d dup-id=%d",
-		stats.Total, stats.Written, stats.MissingTaxID, stats.MissingRanks, stats.TooShort, stats.TooLong, stats.TooManyN, stats.TooManyAmbig, stats.TooManyInvalid, stats.DupeSeq, stats.DupeID)
-	return nil
+	return stats, nil
 }
 
 type seqCounts struct {
@@ -290,7 +1060,7 @@ func cleanSequence(seq []byte) ([]byte, seqCounts) {
 	return clean, counts
 }
 
-func hasAllRanks(lineage map[string]string, required []string) bool {
+func hasAllRanks(lineage taxLineage, required []string) bool {
 	if len(required) == 0 {
 		return true
 	}
@@ -298,15 +1068,29 @@ func hasAllRanks(lineage map[string]string, required []string) bool {
 		if rank == "" {
 			continue
 		}
-		if lineage[rank] == "" {
+		if lineage.get(rank) == "" {
 			return false
 		}
 	}
 	return true
 }
 
-func loadTaxidMap(path string) (map[string]int, error) {
-	f, err := os.Open(path)
+// defaultTaxidMapColumn is the 1-based taxid.map column holding the taxid.
+const defaultTaxidMapColumn = 2
+
+// loadTaxidMap reads taxid.map, plain or gzipped: the ID in the first
+// column and the taxid in column (1-based; 0 means defaultTaxidMapColumn),
+// tab-separated, or space-separated when a line has no tabs. Other columns
+// are ignored, and a first line whose taxid column is not an integer is
+// taken as a header. maxErrors bounds the malformed lines (no taxid column
+// or a non-integer taxid) that are skipped: -1 skips them all, otherwise
+// one more fails the load. Skipped lines come back as a *RowErrors
+// alongside the map, so callers can report them with acceptRowErrors.
+func loadTaxidMap(path string, column, maxErrors int) (map[string]int, error) {
+	if column == 0 {
+		column = defaultTaxidMapColumn
+	}
+	f, err := openInput(path)
 	if err != nil {
 		return nil, fmt.Errorf("open taxid.map: %w", err)
 	}
@@ -314,10 +1098,13 @@ func loadTaxidMap(path string) (map[string]int, error) {
 		_ = f.Close()
 	}()
 	out := make(map[string]int, 1<<20)
+	rowErrs := &RowErrors{Limit: maxErrors}
 	scanner := bufio.NewScanner(f)
 	buf := make([]byte, 0, 1024*1024)
 	scanner.Buffer(buf, 10*1024*1024)
+	var lineNum int64
 	for scanner.Scan() {
+		lineNum++
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
@@ -326,40 +1113,290 @@ func loadTaxidMap(path string) (map[string]int, error) {
 		if len(fields) < 2 {
 			fields = strings.Fields(line)
 		}
-		if len(fields) < 2 {
-			continue
+		reason := ""
+		var taxid int
+		if len(fields) < column {
+			reason = "missing taxid column"
+		} else if taxid, err = strconv.Atoi(strings.TrimSpace(fields[column-1])); err != nil {
+			if lineNum == 1 {
+				continue
+			}
+			reason = fmt.Sprintf("invalid taxid %q", fields[column-1])
 		}
-		id := fields[0]
-		taxid, err := strconv.Atoi(fields[1])
-		if err != nil {
+		if reason != "" {
+			if !rowErrs.add(RowError{Line: lineNum, Reason: reason}) {
+				return nil, fmt.Errorf("taxid.map: %w", rowErrs)
+			}
 			continue
 		}
-		out[id] = taxid
+		out[fields[0]] = taxid
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("scan taxid.map: %w", err)
 	}
 	if len(out) == 0 {
-		return nil, errors.New("taxid.map is empty")
+		return nil, fmt.Errorf("taxid.map %s has no entries: it needs the ID in column 1 and an integer taxid in column %d (set by -taxid-map-column), tab-separated; check the path, the column, and that a .gz file has the .gz suffix", path, column)
+	}
+	if rowErrs.skipped() > 0 {
+		return out, fmt.Errorf("taxid.map: %w", rowErrs)
 	}
 	return out, nil
 }
 
-func writeQCReport(path string, stats qcStats) error {
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		return fmt.Errorf("create report dir: %w", err)
+// qcReport is written flat: header fields, total, written, orientation and
+// flipped when orienting, trimmed_start and trimmed_end when trimming
+// primers, trimmed and trimmed_bases when trimming to length,
+// resolved_by_name and unresolved_by_name under -resolve-by-name,
+// merged_taxid when a taxdump is loaded, snapshot_id when set, one integer
+// per drop counter in counterNames order, then missing_ranks_by_rule,
+// missing_optional_ranks, by_rank, cache, and resources when present.
+type qcReport struct {
+	reportHeader
+	qcStats
+	Snapshot  string
+	Cache     *cacheCounters
+	Resources *runResources
+}
+
+func (r qcReport) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	write := func(key string, value any) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(quoteJSON(key))
+		buf.WriteByte(':')
+		buf.Write(data)
+		return nil
 	}
-	f, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("create report: %w", err)
+	fields := []struct {
+		key   string
+		value any
+	}{
+		{"schema_version", r.SchemaVersion},
+		{"tool_version", r.ToolVersion},
+		{"total", r.Total},
+		{"written", r.Written},
 	}
-	defer func() {
-		_ = f.Close()
-	}()
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(stats); err != nil {
-		return fmt.Errorf("write report: %w", err)
+	for _, f := range fields {
+		if err := write(f.key, f.value); err != nil {
+			return nil, err
+		}
+	}
+	if r.Orientation != "" {
+		if err := write("orientation", r.Orientation); err != nil {
+			return nil, err
+		}
+		if err := write("flipped", r.Flipped); err != nil {
+			return nil, err
+		}
+	}
+	if r.TrimPrimers {
+		if err := write("trimmed_start", r.TrimmedStart); err != nil {
+			return nil, err
+		}
+		if err := write("trimmed_end", r.TrimmedEnd); err != nil {
+			return nil, err
+		}
+	}
+	if r.TrimLength {
+		if err := write("trimmed", r.Trimmed); err != nil {
+			return nil, err
+		}
+		if err := write("trimmed_bases", r.TrimmedBases); err != nil {
+			return nil, err
+		}
+	}
+	if r.ResolveByName {
+		if err := write("resolved_by_name", r.ResolvedByName); err != nil {
+			return nil, err
+		}
+		if err := write("unresolved_by_name", r.UnresolvedByName); err != nil {
+			return nil, err
+		}
+	}
+	if r.ByRank != nil {
+		if err := write("merged_taxid", r.MergedTaxID); err != nil {
+			return nil, err
+		}
+	}
+	if r.Snapshot != "" {
+		if err := write("snapshot_id", r.Snapshot); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range r.counterNames() {
+		var value any = r.Dropped[name]
+		if r.notApplicable(name) {
+			value = nil
+		}
+		if err := write(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if r.MissingRanksByRule != nil {
+		if err := write("missing_ranks_by_rule", r.MissingRanksByRule); err != nil {
+			return nil, err
+		}
+	}
+	if r.MissingOptionalRanks != nil {
+		if err := write("missing_optional_ranks", r.MissingOptionalRanks); err != nil {
+			return nil, err
+		}
+	}
+	if r.ByRank != nil {
+		if err := write("by_rank", r.ByRank); err != nil {
+			return nil, err
+		}
+	}
+	if r.Cache != nil {
+		if err := write("cache", r.Cache); err != nil {
+			return nil, err
+		}
+	}
+	if r.Resources != nil {
+		if err := write("resources", r.Resources); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (r *qcReport) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = qcReport{qcStats: qcStats{Dropped: make(map[string]int)}}
+	for key, value := range raw {
+		var err error
+		switch key {
+		case "schema_version":
+			err = json.Unmarshal(value, &r.SchemaVersion)
+		case "tool_version":
+			err = json.Unmarshal(value, &r.ToolVersion)
+		case "total":
+			err = json.Unmarshal(value, &r.Total)
+		case "written":
+			err = json.Unmarshal(value, &r.Written)
+		case "snapshot_id":
+			err = json.Unmarshal(value, &r.Snapshot)
+		case "orientation":
+			err = json.Unmarshal(value, &r.Orientation)
+		case "flipped":
+			err = json.Unmarshal(value, &r.Flipped)
+		case "trimmed_start":
+			r.TrimPrimers = true
+			err = json.Unmarshal(value, &r.TrimmedStart)
+		case "trimmed_end":
+			r.TrimPrimers = true
+			err = json.Unmarshal(value, &r.TrimmedEnd)
+		case "trimmed":
+			r.TrimLength = true
+			err = json.Unmarshal(value, &r.Trimmed)
+		case "trimmed_bases":
+			r.TrimLength = true
+			err = json.Unmarshal(value, &r.TrimmedBases)
+		case "resolved_by_name":
+			r.ResolveByName = true
+			err = json.Unmarshal(value, &r.ResolvedByName)
+		case "unresolved_by_name":
+			r.ResolveByName = true
+			err = json.Unmarshal(value, &r.UnresolvedByName)
+		case "merged_taxid":
+			err = json.Unmarshal(value, &r.MergedTaxID)
+		case "missing_ranks_by_rule":
+			err = json.Unmarshal(value, &r.MissingRanksByRule)
+		case "missing_optional_ranks":
+			err = json.Unmarshal(value, &r.MissingOptionalRanks)
+		case "by_rank":
+			err = json.Unmarshal(value, &r.ByRank)
+		case "cache":
+			err = json.Unmarshal(value, &r.Cache)
+		case "resources":
+			err = json.Unmarshal(value, &r.Resources)
+		default:
+			// Other keys are drop counters, except that a later minor
+			// version may add fields of any type: keep integers, skip the
+			// rest.
+			if string(value) == "null" {
+				if slices.Contains(qcTaxonomyCounters, key) {
+					r.NoTaxonomy = true
+					r.Dropped[key] = 0
+				}
+				continue
+			}
+			var n int
+			if json.Unmarshal(value, &n) == nil {
+				r.Dropped[key] = n
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("qc report field %s: %w", key, err)
+		}
 	}
 	return nil
 }
+
+// jsonSchema describes the flattened report for generateReportSchema.
+func (qcReport) jsonSchema() map[string]any {
+	props := map[string]any{
+		"schema_version":     map[string]any{"type": "string"},
+		"tool_version":       map[string]any{"type": "string"},
+		"total":              map[string]any{"type": "integer"},
+		"written":            map[string]any{"type": "integer"},
+		"snapshot_id":        map[string]any{"type": "string"},
+		"orientation":        map[string]any{"type": "string", "enum": []string{orientationCanonical, orientationReference}},
+		"flipped":            map[string]any{"type": "integer"},
+		"trimmed_start":      map[string]any{"type": "integer"},
+		"trimmed_end":        map[string]any{"type": "integer"},
+		"trimmed":            map[string]any{"type": "integer"},
+		"trimmed_bases":      map[string]any{"type": "integer"},
+		"resolved_by_name":   map[string]any{"type": "integer"},
+		"unresolved_by_name": map[string]any{"type": "integer"},
+		"merged_taxid":       map[string]any{"type": "integer"},
+	}
+	required := []string{"schema_version", "tool_version", "total", "written"}
+	for _, name := range qcLegacyCounters {
+		props[name] = map[string]any{"type": "integer"}
+		if slices.Contains(qcTaxonomyCounters, name) {
+			props[name] = nullableIntegerSchema
+		}
+		required = append(required, name)
+	}
+	for _, name := range []string{"missing_ranks_by_rule", "missing_optional_ranks"} {
+		props[name] = map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "integer"}}
+	}
+	props["by_rank"] = map[string]any{
+		"type": "object",
+		"additionalProperties": map[string]any{
+			"type":                 "object",
+			"additionalProperties": jsonSchemaFor(reflect.TypeOf(taxonTally{})),
+		},
+	}
+	props["cache"] = jsonSchemaFor(reflect.TypeOf(cacheCounters{}))
+	props["resources"] = jsonSchemaFor(reflect.TypeOf(runResources{}))
+	sort.Strings(required)
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": map[string]any{"type": "integer"},
+	}
+}
+
+func writeQCReport(path string, stats qcStats, snapshot string) error {
+	return writeReportJSON(path, qcReport{
+		reportHeader: newReportHeader("qc-report"),
+		qcStats:      stats,
+		Snapshot:     snapshot,
+		Cache:        cacheStats(),
+		Resources:    currentRun().resources(),
+	})
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// qc -report-format values.
+const (
+	qcReportJSON = "json"
+	qcReportTSV  = "tsv"
+)
+
+var qcReportFormats = []string{qcReportJSON, qcReportTSV}
+
+// defaultReportMaxTaxa is the -report-max-taxa default.
+const defaultReportMaxTaxa = 1000
+
+// Breakdown buckets for records with no name at a rank and for the taxa
+// past the -report-max-taxa cap.
+const (
+	taxonUnassigned = "_unassigned"
+	taxonOther      = "_other"
+)
+
+// taxonTally counts the records of one taxon.
+type taxonTally struct {
+	Total   int            `json:"total"`
+	Kept    int            `json:"kept"`
+	Dropped map[string]int `json:"dropped_by_reason"`
+}
+
+func (t *taxonTally) add(o *taxonTally) {
+	t.Total += o.Total
+	t.Kept += o.Kept
+	for reason, n := range o.Dropped {
+		t.Dropped[reason] += n
+	}
+}
+
+// rankBreakdown maps rank -> taxon name -> tally.
+type rankBreakdown map[string]map[string]*taxonTally
+
+// newRankBreakdown returns an empty breakdown over ranks, or nil when there
+// are none.
+func newRankBreakdown(ranks []string) rankBreakdown {
+	b := make(rankBreakdown)
+	for _, rank := range ranks {
+		if rank != "" {
+			b[rank] = make(map[string]*taxonTally)
+		}
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// add counts a record with lineage at every rank, as kept when reason is
+// empty and as dropped under reason otherwise.
+func (b rankBreakdown) add(lineage taxLineage, reason string) {
+	for rank, taxa := range b {
+		name := lineage.get(rank)
+		if name == "" {
+			name = taxonUnassigned
+		}
+		t := taxa[name]
+		if t == nil {
+			t = &taxonTally{Dropped: make(map[string]int)}
+			taxa[name] = t
+		}
+		t.Total++
+		if reason == "" {
+			t.Kept++
+		} else {
+			t.Dropped[reason]++
+		}
+	}
+}
+
+// capTaxa keeps the maxTaxa largest taxa of each rank and sums the rest
+// under taxonOther.
+func (b rankBreakdown) capTaxa(maxTaxa int) {
+	for _, taxa := range b {
+		if len(taxa) <= maxTaxa {
+			continue
+		}
+		other := &taxonTally{Dropped: make(map[string]int)}
+		for _, name := range sortedTaxa(taxa)[maxTaxa:] {
+			other.add(taxa[name])
+			delete(taxa, name)
+		}
+		taxa[taxonOther] = other
+	}
+}
+
+// sortedTaxa orders taxa by descending total, then name, with taxonOther
+// last.
+func sortedTaxa(taxa map[string]*taxonTally) []string {
+	return slices.SortedFunc(maps.Keys(taxa), func(a, b string) int {
+		if (a == taxonOther) != (b == taxonOther) {
+			if a == taxonOther {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Or(cmp.Compare(taxa[b].Total, taxa[a].Total), cmp.Compare(a, b))
+	})
+}
+
+// writeQCReportTSV writes the report as a table for spreadsheets: a row of
+// run totals (rank and taxon "all"), then one row per taxon of each rank in
+// ranks order. Drop counters follow total and kept as columns; counters
+// that do not apply are left empty.
+func writeQCReportTSV(path string, stats qcStats, ranks []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create report dir: %w", err)
+	}
+	w, err := createTSVWriter(path, tsvWriterOptions{})
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+	counters := stats.counterNames()
+	row := func(rank, taxon string, t *taxonTally) error {
+		fields := []string{rank, taxon, strconv.Itoa(t.Total), strconv.Itoa(t.Kept)}
+		for _, name := range counters {
+			if stats.notApplicable(name) {
+				fields = append(fields, "")
+				continue
+			}
+			fields = append(fields, strconv.Itoa(t.Dropped[name]))
+		}
+		return w.WriteRowStrings(fields)
+	}
+	err = w.WriteHeader(append([]string{"rank", "taxon", "total", "kept"}, counters...))
+	if err == nil {
+		err = row("all", "all", &taxonTally{Total: stats.Total, Kept: stats.Written, Dropped: stats.Dropped})
+	}
+	for _, rank := range ranks {
+		taxa := stats.ByRank[rank]
+		for _, name := range sortedTaxa(taxa) {
+			if err != nil {
+				break
+			}
+			err = row(rank, name, taxa[name])
+		}
+	}
+	if err != nil {
+		_ = w.Close()
+		return fmt.Errorf("write report: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRankBreakdownCap(t *testing.T) {
+	b := newRankBreakdown([]string{"family", ""})
+	for _, rec := range []struct{ family, reason string }{
+		{"Canidae", ""}, {"Canidae", "too_short"}, {"Felidae", ""}, {"Ursidae", "too_long"}, {"", "missing_taxid"},
+	} {
+		b.add(lineageFromMap(map[string]string{"family": rec.family}), rec.reason)
+	}
+	if len(b) != 1 {
+		t.Fatalf("ranks %v", b)
+	}
+	b.capTaxa(2)
+	taxa := b["family"]
+	if got := strings.Join(sortedTaxa(taxa), ","); got != "Canidae,Felidae,_other" {
+		t.Fatalf("taxa %s", got)
+	}
+	if c := taxa["Canidae"]; c.Total != 2 || c.Kept != 1 || c.Dropped["too_short"] != 1 {
+		t.Fatalf("Canidae %+v", c)
+	}
+	// Ursidae and the unassigned record fold into _other.
+	if o := taxa[taxonOther]; o.Total != 2 || o.Kept != 0 || o.Dropped["too_long"] != 1 || o.Dropped["missing_taxid"] != 1 {
+		t.Fatalf("_other %+v", o)
+	}
+}
+
+func TestQCReportTSV(t *testing.T) {
+	cfg := qcConfig{
+		MaxN:          -1,
+		MaxAmbig:      -1,
+		DedupeSeqs:    true,
+		DedupeIDs:     true,
+		RequireRanks:  splitList("family,genus"),
+		ReportFormat:  qcReportTSV,
+		ReportMaxTaxa: 1,
+	}
+	lines := strings.Split(strings.TrimSuffix(string(runQCFixture(t, cfg)), "\n"), "\n")
+	want := []string{
+		"rank\ttaxon\ttotal\tkept\tmissing_taxid\tmissing_ranks\ttoo_short\ttoo_long\ttoo_many_n\ttoo_many_ambig\ttoo_many_invalid\tduplicate_sequence\tduplicate_id",
+		"all\tall\t11\t4\t1\t1\t0\t0\t0\t0\t1\t3\t1",
+		"family\tCanidae\t9\t4\t0\t0\t0\t0\t0\t0\t1\t3\t1",
+		"family\t_other\t2\t0\t1\t1\t0\t0\t0\t0\t0\t0\t0",
+		"genus\tCanis\t9\t4\t0\t0\t0\t0\t0\t0\t1\t3\t1",
+		"genus\t_other\t2\t0\t1\t1\t0\t0\t0\t0\t0\t0\t0",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("report:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Split-taxonomy screen parameters: canonical 14-mers, of which those with a
+// hash in the lowest quarter are sketched (FracMinHash at scale 4), so a
+// 650 bp barcode contributes about 160 hashes.
+const (
+	chimeraK          = 14
+	chimeraScaleShift = 2
+)
+
+// Defaults of -split-taxonomy-votes and -split-taxonomy-sketch.
+const (
+	defaultSplitTaxonomyVotes  = 5
+	defaultSplitTaxonomySketch = 20000
+)
+
+// chimeraRank is the rank the two halves of a sequence are assigned at.
+const chimeraRank = "family"
+
+const noFamily = math.MaxUint32
+
+// chimeraHit is one family's share of a sketched hash: how many of the
+// family's indexed sequences contain it.
+type chimeraHit struct {
+	family uint32
+	seqs   uint32
+}
+
+// chimeraIndex maps sketched k-mer hashes of the input to families for the
+// split-taxonomy screen. Each family's sketch is capped at a number of
+// hashes; a family past the cap keeps its smallest hashes and records the
+// largest in thresholds, so memory is bounded by families times the cap. It
+// is read-only once built, so qc workers share it.
+type chimeraIndex struct {
+	families   []string
+	ids        map[string]uint32
+	thresholds []uint64 // per family: the largest hash its sketch may hold
+	hits       map[uint64][]chimeraHit
+	minVotes   int
+}
+
+// buildChimeraIndex reads input once, ahead of the qc pass, and sketches the
+// cleaned sequence of every record with a family in its lineage. It needs
+// env's taxid map and taxdump.
+func buildChimeraIndex(input string, env *QCFilterEnv) (*chimeraIndex, error) {
+	in, _, err := openFastaInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("open input: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	maxSketch := env.cfg.SplitTaxonomySketch
+	x := &chimeraIndex{ids: make(map[string]uint32), minVotes: env.cfg.SplitTaxonomyVotes}
+	var sketches []map[uint64]uint32
+	var hashes []uint64
+	err = parseFasta(in, func(rec fastaRecord) error {
+		qrec := QCRecord{ID: rec.id, Seq: rec.seq, env: env}
+		name := qrec.rankLineage().get(chimeraRank)
+		if name == "" {
+			return nil
+		}
+		f, ok := x.ids[name]
+		if !ok {
+			f = uint32(len(x.families))
+			x.ids[name] = f
+			x.families = append(x.families, name)
+			x.thresholds = append(x.thresholds, math.MaxUint64)
+			sketches = append(sketches, make(map[uint64]uint32))
+		}
+		hashes = chimeraHashes(qrec.Clean(), hashes[:0])
+		for _, h := range hashes {
+			if h > x.thresholds[f] {
+				break
+			}
+			sketches[f][h]++
+		}
+		// Pruning at twice the cap keeps the sort amortized.
+		if len(sketches[f]) > 2*maxSketch {
+			x.thresholds[f] = pruneSketch(sketches[f], maxSketch)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("index input: %w", err)
+	}
+	x.hits = make(map[uint64][]chimeraHit)
+	for f, sketch := range sketches {
+		if len(sketch) > maxSketch {
+			x.thresholds[f] = pruneSketch(sketch, maxSketch)
+		}
+		for h, n := range sketch {
+			x.hits[h] = append(x.hits[h], chimeraHit{family: uint32(f), seqs: n})
+		}
+	}
+	return x, nil
+}
+
+// pruneSketch keeps the n smallest hashes of sketch and returns the largest
+// kept.
+func pruneSketch(sketch map[uint64]uint32, n int) uint64 {
+	keys := make([]uint64, 0, len(sketch))
+	for h := range sketch {
+		keys = append(keys, h)
+	}
+	slices.Sort(keys)
+	for _, h := range keys[n:] {
+		delete(sketch, h)
+	}
+	return keys[n-1]
+}
+
+// chimeraHashes appends the sorted, distinct sketched hashes of the canonical
+// k-mers of an ACGT sequence to dst. Canonical k-mers (the smaller of a
+// k-mer and its reverse complement) match whichever strand was submitted.
+func chimeraHashes(seq []byte, dst []uint64) []uint64 {
+	const mask = 1<<(2*chimeraK) - 1
+	var fwd, rev uint64
+	start := len(dst)
+	for i, c := range seq {
+		fwd = (fwd<<2 | baseCode[c]) & mask
+		rev = rev>>2 | (3-baseCode[c])<<(2*(chimeraK-1))
+		if i < chimeraK-1 {
+			continue
+		}
+		if h := mixHash(min(fwd, rev)); h>>(64-chimeraScaleShift) == 0 {
+			dst = append(dst, h)
+		}
+	}
+	slices.Sort(dst[start:])
+	return append(dst[:start], slices.Compact(dst[start:])...)
+}
+
+// chimeraFlag describes a sequence whose halves were assigned to different
+// families.
+type chimeraFlag struct {
+	family                  string // the record's own family, "" when it has none
+	first, second           string
+	firstVotes, secondVotes int
+}
+
+// check assigns both halves of rec's cleaned sequence and returns a flag
+// when they land in different families, or nil.
+func (x *chimeraIndex) check(rec *QCRecord) *chimeraFlag {
+	seq := rec.Clean()
+	if len(seq) < 2*chimeraK {
+		return nil
+	}
+	own := uint32(noFamily)
+	family := rec.rankLineage().get(chimeraRank)
+	if f, ok := x.ids[family]; ok {
+		own = f
+	}
+	mid := len(seq) / 2
+	first, firstVotes := x.assign(chimeraHashes(seq[:mid], nil), own)
+	if first == noFamily {
+		return nil
+	}
+	second, secondVotes := x.assign(chimeraHashes(seq[mid:], nil), own)
+	if second == noFamily || second == first {
+		return nil
+	}
+	return &chimeraFlag{family: family, first: x.families[first], second: x.families[second], firstVotes: firstVotes, secondVotes: secondVotes}
+}
+
+// assign returns the family that best contains the sorted hashes of one
+// half and the number of hashes it shares, or noFamily when no family
+// shares minVotes. Families are scored by the share of the hashes their
+// sketch could hold that it does hold, so capped families are not
+// penalized. The record itself was indexed under own; a hash counts for
+// own only when another sequence of own contains it.
+func (x *chimeraIndex) assign(hashes []uint64, own uint32) (family uint32, votes int) {
+	counts := make(map[uint32]int)
+	for _, h := range hashes {
+		for _, hit := range x.hits[h] {
+			if hit.family != own || hit.seqs > 1 {
+				counts[hit.family]++
+			}
+		}
+	}
+	family = noFamily
+	best := 0.0
+	for f, n := range counts {
+		if n < x.minVotes {
+			continue
+		}
+		t := x.thresholds[f]
+		eligible := sort.Search(len(hashes), func(i int) bool { return hashes[i] > t })
+		score := float64(n) / float64(eligible)
+		if family == noFamily || score > best || score == best && (n > votes || n == votes && x.families[f] < x.families[family]) {
+			family, votes, best = f, n, score
+		}
+	}
+	return family, votes
+}
+
+// chimeraTable writes the records flagged by the split-taxonomy screen as an
+// id/family/first_half/first_votes/second_half/second_votes table for
+// manual review.
+type chimeraTable struct {
+	path    string
+	w       *tsvWriter
+	flagged int
+}
+
+// openChimeraTable creates cfg's split-taxonomy table; it returns nil when
+// the screen is off.
+func openChimeraTable(cfg qcConfig) (*chimeraTable, error) {
+	if !cfg.SplitTaxonomyCheck {
+		return nil, nil
+	}
+	path := cmp.Or(cfg.SplitTaxonomyTSV, defaultSplitTaxonomyTSV(cfg.OutputPath))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create split-taxonomy dir: %w", err)
+	}
+	w, err := createTSVWriter(path, tsvWriterOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("create split-taxonomy table: %w", err)
+	}
+	if err := w.WriteHeader([]string{"id", "family", "first_half", "first_votes", "second_half", "second_votes"}); err != nil {
+		_ = w.Close()
+		return nil, fmt.Errorf("write split-taxonomy table: %w", err)
+	}
+	return &chimeraTable{path: path, w: w}, nil
+}
+
+func (r *chimeraTable) write(id string, flag *chimeraFlag) error {
+	if r == nil {
+		return nil
+	}
+	r.flagged++
+	row := []string{id, flag.family, flag.first, strconv.Itoa(flag.firstVotes), flag.second, strconv.Itoa(flag.secondVotes)}
+	if err := r.w.WriteRowStrings(row); err != nil {
+		return fmt.Errorf("write split-taxonomy table: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the table. Later calls do nothing.
+func (r *chimeraTable) Close() error {
+	if r == nil || r.w == nil {
+		return nil
+	}
+	err := r.w.Close()
+	r.w = nil
+	if err != nil {
+		return fmt.Errorf("close split-taxonomy table: %w", err)
+	}
+	return nil
+}
+
+// defaultSplitTaxonomyTSV is the -split-taxonomy-tsv default: the output
+// path with its .fasta (and .gz) extension replaced.
+func defaultSplitTaxonomyTSV(output string) string {
+	base := strings.TrimSuffix(output, ".gz")
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ".split_taxonomy.tsv"
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChimeraHashes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seq := make([]byte, 650)
+	for i := range seq {
+		seq[i] = "ACGT"[rng.Intn(4)]
+	}
+	hashes := chimeraHashes(seq, nil)
+	if !slices.IsSorted(hashes) || len(slices.Compact(slices.Clone(hashes))) != len(hashes) {
+		t.Fatal("hashes not sorted and distinct")
+	}
+	// About a quarter of the 637 k-mers are sketched.
+	if len(hashes) < 100 || len(hashes) > 220 {
+		t.Fatalf("%d hashes", len(hashes))
+	}
+	rc := slices.Clone(seq)
+	reverseComplement(rc)
+	if !slices.Equal(chimeraHashes(rc, nil), hashes) {
+		t.Fatal("reverse complement sketches differently")
+	}
+	if got := chimeraHashes(seq[:chimeraK-1], []uint64{7}); !slices.Equal(got, []uint64{7}) {
+		t.Fatalf("short sequence: %v", got)
+	}
+}
+
+func TestQCSplitTaxonomy(t *testing.T) {
+	tmp := t.TempDir()
+	writeTaxdumpFixture(t, tmp)
+	rng := rand.New(rand.NewSource(2))
+	barcode := func() []byte {
+		seq := make([]byte, 600)
+		for i := range seq {
+			seq[i] = "ACGT"[rng.Intn(4)]
+		}
+		return seq
+	}
+	// A few substitutions per copy, as between sequences of one family.
+	variant := func(seq []byte) string {
+		out := slices.Clone(seq)
+		for range 6 {
+			out[rng.Intn(len(out))] = "ACGT"[rng.Intn(4)]
+		}
+		return string(out)
+	}
+	canid, felid := barcode(), barcode()
+	var fasta, taxids strings.Builder
+	for i := 1; i <= 4; i++ {
+		fmt.Fprintf(&fasta, ">A%d\n%s\n>B%d\n%s\n", i, variant(canid), i, variant(felid))
+		fmt.Fprintf(&taxids, "A%d\t7\nB%d\t8\n", i, i)
+	}
+	// X1 is filed under Canidae, but its 3' half is a felid's.
+	fmt.Fprintf(&fasta, ">X1\n%s%s\n", variant(canid)[:300], variant(felid)[300:])
+	taxids.WriteString("X1\t7\n")
+	input := filepath.Join(tmp, "in.fasta")
+	if err := os.WriteFile(input, []byte(fasta.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "taxid.map"), []byte(taxids.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, drop := range []bool{false, true} {
+		cfg := qcConfig{
+			MaxN:                -1,
+			MaxAmbig:            -1,
+			TaxdumpDir:          tmp,
+			OutputPath:          filepath.Join(tmp, fmt.Sprint(drop), "out.fasta"),
+			Workers:             2,
+			SplitTaxonomyCheck:  true,
+			SplitTaxonomyDrop:   drop,
+			SplitTaxonomyVotes:  defaultSplitTaxonomyVotes,
+			SplitTaxonomySketch: defaultSplitTaxonomySketch,
+		}
+		stats, err := runQCFasta(context.Background(), input, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table, err := os.ReadFile(filepath.Join(tmp, fmt.Sprint(drop), "out.split_taxonomy.tsv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := strings.Split(strings.TrimSpace(string(table)), "\n")
+		if len(rows) != 2 || !strings.HasPrefix(rows[1], "X1\tCanidae\tCanidae\t") || !strings.Contains(rows[1], "\tFelidae\t") {
+			t.Fatalf("drop=%v table:\n%s", drop, table)
+		}
+		if want := map[bool]int{false: 9, true: 8}[drop]; stats.Written != want || stats.Dropped["split_taxonomy"] != 9-want {
+			t.Fatalf("drop=%v: %+v", drop, stats)
+		}
+	}
+}
+
+// TestChimeraIndexCap checks that a capped family sketch still assigns its
+// sequences: it is scored on the hashes below its threshold.
+func TestChimeraIndexCap(t *testing.T) {
+	tmp := t.TempDir()
+	writeTaxdumpFixture(t, tmp)
+	rng := rand.New(rand.NewSource(3))
+	var fasta, taxids strings.Builder
+	seqs := make([][]byte, 20)
+	for i := range seqs {
+		seqs[i] = make([]byte, 600)
+		for j := range seqs[i] {
+			seqs[i][j] = "ACGT"[rng.Intn(4)]
+		}
+		fmt.Fprintf(&fasta, ">A%d\n%s\n>A%d_copy\n%s\n", i, seqs[i], i, seqs[i])
+		fmt.Fprintf(&taxids, "A%d\t7\nA%d_copy\t7\n", i, i)
+	}
+	input := filepath.Join(tmp, "in.fasta")
+	if err := os.WriteFile(input, []byte(fasta.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "taxid.map"), []byte(taxids.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := qcConfig{TaxdumpDir: tmp, SplitTaxonomyVotes: 2, SplitTaxonomySketch: 500}
+	env := &QCFilterEnv{cfg: cfg}
+	var err error
+	if env.taxidMap, err = loadTaxidMap(filepath.Join(tmp, "taxid.map"), 0, -1); err != nil {
+		t.Fatal(err)
+	}
+	if env.dump, err = loadTaxDump(filepath.Join(tmp, "nodes.dmp"), filepath.Join(tmp, "names.dmp"), nil); err != nil {
+		t.Fatal(err)
+	}
+	x, err := buildChimeraIndex(input, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.hits) != 500 || x.thresholds[0] == ^uint64(0) {
+		t.Fatalf("%d hashes, threshold %x", len(x.hits), x.thresholds[0])
+	}
+	// The last sequence's hashes are mostly above the threshold; it is
+	// assigned from the few below it.
+	hashes := chimeraHashes(seqs[19], nil)
+	if f, votes := x.assign(hashes, noFamily); f != 0 || votes < 2 {
+		t.Fatalf("assigned %d with %d votes", f, votes)
+	}
+}
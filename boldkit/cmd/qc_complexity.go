@@ -0,0 +1,43 @@
+package cmd
+
+import "math"
+
+// maxKmerEntropy is the 3-mer entropy of a sequence using all 64 3-mers
+// equally often, in bits.
+const maxKmerEntropy = 6.0
+
+// sequenceComplexity returns the longest single-base run of an ACGT
+// sequence and the Shannon entropy of its 3-mer frequencies in bits, in one
+// pass. A poly-A stretch scores 0, an (AT)n repeat 1, and barcode
+// sequences usually above 5. A sequence shorter than 3 bases has no 3-mers
+// and an entropy of 0.
+func sequenceComplexity(seq []byte) (longestRun int, entropy float64) {
+	var counts [64]int
+	run := 0
+	var kmer uint64
+	for i, c := range seq {
+		if i > 0 && c == seq[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		longestRun = max(longestRun, run)
+		kmer = (kmer<<2 | baseCode[c]) & 63
+		if i >= 2 {
+			counts[kmer]++
+		}
+	}
+	n := len(seq) - 2
+	if n <= 0 {
+		return longestRun, 0
+	}
+	// H = log2(n) - sum(c log2 c) / n
+	sum := 0.0
+	for _, c := range counts {
+		if c > 1 {
+			sum += float64(c) * math.Log2(float64(c))
+		}
+	}
+	// Rounding can leave a single repeated 3-mer just below zero.
+	return longestRun, max(0, math.Log2(float64(n))-sum/float64(n))
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// orfFixtureSeq returns the COI_clean barcode of testdata/qc/orf.fasta.
+func orfFixtureSeq(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "qc", "orf.fasta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := strings.Split(string(data), ">")[1]
+	_, seq, _ := strings.Cut(record, "\n")
+	return strings.ReplaceAll(seq, "\n", "")
+}
+
+func TestSequenceComplexity(t *testing.T) {
+	coi := orfFixtureSeq(t)
+	cases := []struct {
+		name    string
+		seq     string
+		run     int
+		entropy float64 // checked to 0.01 bits; -1 means above 5
+	}{
+		{"empty", "", 0, 0},
+		{"short", "AC", 1, 0},
+		{"poly-A", strings.Repeat("A", 40), 40, 0},
+		{"(AT)n", strings.Repeat("AT", 50), 1, 1},
+		{"(ACG)n", strings.Repeat("ACG", 40), 1, math.Log2(3)},
+		{"COI", coi, 6, -1},
+		{"COI with a poly-A tail", coi + strings.Repeat("A", 25), 25, -1},
+	}
+	for _, tc := range cases {
+		run, entropy := sequenceComplexity([]byte(tc.seq))
+		if run != tc.run {
+			t.Errorf("%s: longest run %d, want %d", tc.name, run, tc.run)
+		}
+		if tc.entropy < 0 && entropy <= 5 || tc.entropy >= 0 && math.Abs(entropy-tc.entropy) > 0.01 {
+			t.Errorf("%s: entropy %.3f, want %.3f", tc.name, entropy, tc.entropy)
+		}
+	}
+	seq := []byte(coi)
+	if allocs := testing.AllocsPerRun(10, func() { sequenceComplexity(seq) }); allocs != 0 {
+		t.Fatalf("%v allocations per call", allocs)
+	}
+}
+
+func TestQCComplexity(t *testing.T) {
+	dir := t.TempDir()
+	coi := orfFixtureSeq(t)
+	input := filepath.Join(dir, "in.fasta")
+	fasta := ">COI\n" + coi + "\n>polyA\n" + coi[:300] + strings.Repeat("A", 30) + "\n>ATn\n" + strings.Repeat("AT", 150) + "\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.fasta")
+	stats, err := runQCFasta(context.Background(), input, qcConfig{
+		NoTaxonomy: true, MaxN: -1, MaxAmbig: -1, OutputPath: out, MaxHomopolymer: 12, MinComplexity: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != ">COI\n"+coi+"\n" {
+		t.Fatalf("output %q", got)
+	}
+	if stats.Dropped["homopolymer"] != 1 || stats.Dropped["low_complexity"] != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
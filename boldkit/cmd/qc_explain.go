@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// qcExplainOut receives the -explain output.
+var qcExplainOut io.Writer = os.Stdout
+
+// QCExplainer is implemented by filters that can describe the values they
+// test for a record, for qc -explain. The built-in filters are described by
+// qcMeasures instead.
+type QCExplainer interface {
+	Explain(rec *QCRecord) string
+}
+
+// qcMeasures describe the values each built-in filter tests, by filter name.
+var qcMeasures = map[string]func(env *QCFilterEnv, rec *QCRecord) string{
+	"header": func(_ *QCFilterEnv, rec *QCRecord) string {
+		return fmt.Sprintf("description %q", rec.Desc)
+	},
+	"taxid": func(_ *QCFilterEnv, rec *QCRecord) string {
+		if taxid, ok := rec.TaxID(); ok {
+			return "taxid " + strconv.Itoa(taxid)
+		}
+		return "not in taxid.map"
+	},
+	"ranks": func(env *QCFilterEnv, rec *QCRecord) string {
+		lineage := rec.rankLineage()
+		parts := make([]string, 0, len(env.rankRules))
+		for _, r := range env.rankRules {
+			found := "-"
+			for _, rank := range r.ranks {
+				if name := lineage.get(rank); name != "" {
+					found = rank + ":" + name
+					break
+				}
+			}
+			label := r.String()
+			if r.optional {
+				label = "?" + label
+			}
+			parts = append(parts, label+"="+found)
+		}
+		return strings.Join(parts, " ")
+	},
+	"length": func(env *QCFilterEnv, rec *QCRecord) string {
+		measured := fmt.Sprintf("length %d (min %s, max %s)", len(rec.Clean()), limitString(env.cfg.MinLen, 0), limitString(env.cfg.MaxLen, 0))
+		if trimmed := rec.TrimmedBases(); trimmed > 0 {
+			measured += fmt.Sprintf(", %d bases trimmed", trimmed)
+		}
+		return measured
+	},
+	"n": func(env *QCFilterEnv, rec *QCRecord) string {
+		n, _, _ := rec.Counts()
+		return fmt.Sprintf("N %d (max %d)", n, env.cfg.MaxN)
+	},
+	"ambig": func(env *QCFilterEnv, rec *QCRecord) string {
+		_, ambig, _ := rec.Counts()
+		return fmt.Sprintf("ambiguous %d (max %d)", ambig, env.cfg.MaxAmbig)
+	},
+	"invalid": func(env *QCFilterEnv, rec *QCRecord) string {
+		_, _, invalid := rec.Counts()
+		return fmt.Sprintf("invalid %d (max %d)", invalid, env.cfg.MaxInvalid)
+	},
+	"primer": func(_ *QCFilterEnv, rec *QCRecord) string {
+		start, end := rec.TrimmedPrimers()
+		return fmt.Sprintf("primer at start %t, at end %t", start, end)
+	},
+	"complexity": func(env *QCFilterEnv, rec *QCRecord) string {
+		run, entropy := sequenceComplexity(rec.Clean())
+		return fmt.Sprintf("longest run %d (max %s), 3-mer entropy %.2f bits (min %g)", run, limitString(env.cfg.MaxHomopolymer, 0), entropy, env.cfg.MinComplexity)
+	},
+	"orf": func(env *QCFilterEnv, rec *QCRecord) string {
+		stops, _ := newStopCodonSet(env.cfg.ORFTable)
+		if stops.hasOpenFrame(rec.Clean()) {
+			return fmt.Sprintf("open forward frame (table %d)", env.cfg.ORFTable)
+		}
+		return fmt.Sprintf("stop codons in all forward frames (table %d)", env.cfg.ORFTable)
+	},
+	"split_taxonomy": func(_ *QCFilterEnv, rec *QCRecord) string {
+		if f := rec.chimera; f != nil {
+			return fmt.Sprintf("5' half %s (%d hashes), 3' half %s (%d hashes)", f.first, f.firstVotes, f.second, f.secondVotes)
+		}
+		return "halves not assigned to different families"
+	},
+}
+
+// limitString formats a threshold, or "off" when it is the disabled value.
+func limitString(limit, off int) string {
+	if limit == off {
+		return "off"
+	}
+	return strconv.Itoa(limit)
+}
+
+// qcExplainStep is one filter's verdict on a record for -explain.
+type qcExplainStep struct {
+	filter   string
+	verdict  string
+	measured string
+}
+
+// explain runs every filter on rec, where check stops at the first drop,
+// and records each verdict with the values the filter tested. It returns
+// the counter check would. Stateful filters after the first drop are not
+// run: the record never reaches them, and running them would change what
+// they decide for later records.
+func (c *qcChain) explain(rec *QCRecord) ([]qcExplainStep, string) {
+	rec.env = c.env
+	steps := make([]qcExplainStep, 0, len(c.filters))
+	reason := ""
+	for i, f := range c.filters {
+		step := qcExplainStep{filter: f.Name()}
+		if reason != "" && !c.concurrent[i] {
+			step.verdict = "not run"
+			steps = append(steps, step)
+			continue
+		}
+		verdict, detail := f.Check(rec)
+		step.verdict = "pass"
+		if verdict == QCDrop {
+			counter := dropCounter(f, detail)
+			step.verdict = "drop " + counter
+			if reason == "" {
+				reason = counter
+			}
+		}
+		if measure := qcMeasures[f.Name()]; measure != nil {
+			step.measured = measure(c.env, rec)
+		} else if e, ok := f.(QCExplainer); ok {
+			step.measured = e.Explain(rec)
+		}
+		steps = append(steps, step)
+	}
+	return steps, reason
+}
+
+// writeQCExplain prints the steps of the nth record with rec's ID.
+func writeQCExplain(w io.Writer, rec *QCRecord, n int, steps []qcExplainStep, reason string) error {
+	result := "passed every filter"
+	if reason != "" {
+		result = "dropped as " + reason
+	}
+	if _, err := fmt.Fprintf(w, "%s (record %d with this ID): %s\n", rec.ID, n, result); err != nil {
+		return err
+	}
+	for _, s := range steps {
+		line := strings.TrimRight(fmt.Sprintf("  %-20s %-24s %s", s.filter, s.verdict, s.measured), " ")
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestQCDryRun checks that a dry run reports what a full run does and writes
+// no output, including when the selector spills only IDs.
+func TestQCDryRun(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fasta")
+	fasta := strings.Join([]string{
+		">A1", "ACGTAC",
+		">B1", "CCCCCCCC",
+		">B1", "CCCCCCCCCC",
+		">B2", "GGGGGGGG",
+		">C1", "AANNNA",
+	}, "\n") + "\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	taxidMap := filepath.Join(dir, "taxid.map")
+	if err := os.WriteFile(taxidMap, []byte("A1\t10\nB1\t20\nB2\t20\nC1\t30\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := qcConfig{MaxN: 1, MaxAmbig: -1, DedupeSeqs: true, DedupeIDs: true, DedupePolicy: dedupeLongest, TaxidMapPath: taxidMap, MinPerTaxon: 2}
+	cfg.OutputPath = filepath.Join(dir, "full", "out.fasta")
+	want, err := runQCFasta(context.Background(), input, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.OutputPath = filepath.Join(dir, "dry", "out.fasta")
+	cfg.DryRun = true
+	got, err := runQCFasta(context.Background(), input, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("dry run %+v, full run %+v", got, want)
+	}
+	if _, err := os.Stat(cfg.OutputPath); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote its output: %v", err)
+	}
+}
+
+func TestQCExplain(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fasta")
+	fasta := ">A1\nACGTNNAC\n>B1\nACGTACGTAC\n>A1\nACGTACGGAA\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	qcExplainOut = &buf
+	t.Cleanup(func() { qcExplainOut = os.Stdout })
+	cfg := qcConfig{MinLen: 9, MaxN: 1, MaxAmbig: -1, DedupeIDs: true, NoTaxonomy: true, DryRun: true, Explain: "A1"}
+	stats, err := runQCFasta(context.Background(), input, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 1 || stats.Dropped["duplicate_id"] != 1 {
+		t.Fatalf("%+v", stats)
+	}
+	want := strings.Join([]string{
+		"A1 (record 1 with this ID): dropped as too_short",
+		"  id                   pass",
+		"  duplicate_id         pass",
+		"  length               drop too_short           length 6 (min 9, max off)",
+		"  n                    drop too_many_n          N 2 (max 1)",
+		"  invalid              pass                     invalid 0 (max 0)",
+		"A1 (record 2 with this ID): dropped as duplicate_id",
+		"  id                   pass",
+		"  duplicate_id         drop duplicate_id",
+		"  length               pass                     length 10 (min 9, max off)",
+		"  n                    pass                     N 0 (max 1)",
+		"  invalid              pass                     invalid 0 (max 0)",
+	}, "\n") + "\n"
+	if buf.String() != want {
+		t.Fatalf("explain output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
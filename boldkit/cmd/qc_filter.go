@@ -0,0 +1,868 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// QCVerdict is a filter's decision for one record.
+type QCVerdict int
+
+const (
+	// QCPass lets the record continue down the chain.
+	QCPass QCVerdict = iota
+	// QCDrop removes the record; the filter's detail names the counter.
+	QCDrop
+)
+
+// QCRecord is the record handed to each filter. Derived values (cleaned
+// sequence, taxid, lineage) are computed on first use and shared by the
+// filters after it.
+type QCRecord struct {
+	ID string
+	// Desc is the header after the ID, trimmed ("" when there is none).
+	Desc string
+	Seq  []byte
+
+	env         *QCFilterEnv
+	cleaned     bool
+	clean       []byte
+	counts      seqCounts
+	flipped     bool
+	trimStart   bool
+	trimEnd     bool
+	trimmed     int          // bases cut by the lengthTrimmer
+	chimera     *chimeraFlag // set by the split_taxonomy filter when flagged
+	taxidDone   bool
+	taxidFound  bool
+	taxid       int
+	merged      bool   // the taxid was replaced per merged.dmp
+	byName      string // the binomial the taxid was resolved from
+	nameMissed  bool   // -resolve-by-name found no taxid
+	lineage     taxLineage
+	lineageDone bool
+
+	// Set by qcChain.prepare: prepDrop indexes the first concurrent filter
+	// that drops the record (len(filters) for none) and prepReason is its
+	// counter.
+	prepared   bool
+	prepDrop   int
+	prepReason string
+}
+
+// Clean returns the uppercased ACGT-only sequence, with primers cut by
+// -trim-primers, cut to length by -trim-head, -trim-tail, and -trim-to-max,
+// and in the orientation chosen by -orientation.
+func (r *QCRecord) Clean() []byte {
+	r.ensureClean()
+	return r.clean
+}
+
+// Counts returns the number of N, IUPAC ambiguity, and invalid characters.
+func (r *QCRecord) Counts() (n, ambig, invalid int) {
+	r.ensureClean()
+	return r.counts.n, r.counts.ambig, r.counts.invalid
+}
+
+// Flipped reports whether Clean is the reverse complement of the input.
+func (r *QCRecord) Flipped() bool {
+	r.ensureClean()
+	return r.flipped
+}
+
+// TrimmedPrimers reports whether a primer was cut from the start and end of
+// the input.
+func (r *QCRecord) TrimmedPrimers() (start, end bool) {
+	r.ensureClean()
+	return r.trimStart, r.trimEnd
+}
+
+// TrimmedBases returns the number of bases -trim-head, -trim-tail, and
+// -trim-to-max cut from the cleaned sequence.
+func (r *QCRecord) TrimmedBases() int {
+	r.ensureClean()
+	return r.trimmed
+}
+
+// seqEdits records how qc changed a kept sequence.
+type seqEdits uint8
+
+const (
+	editFlipped seqEdits = 1 << iota
+	editTrimmedStart
+	editTrimmedEnd
+)
+
+func (r *QCRecord) edits() seqEdits {
+	r.ensureClean()
+	var e seqEdits
+	if r.flipped {
+		e |= editFlipped
+	}
+	if r.trimStart {
+		e |= editTrimmedStart
+	}
+	if r.trimEnd {
+		e |= editTrimmedEnd
+	}
+	return e
+}
+
+func (r *QCRecord) ensureClean() {
+	if !r.cleaned {
+		r.clean, r.counts = cleanSequence(r.Seq)
+		if r.env != nil && r.env.primers != nil {
+			r.clean, r.trimStart, r.trimEnd = r.env.primers.trim(r.clean)
+		}
+		if r.env != nil && r.env.lengthTrim != nil {
+			r.clean, r.trimmed = r.env.lengthTrim.trim(r.clean)
+		}
+		if r.env != nil && r.env.orient != nil {
+			r.flipped = r.env.orient(r.clean)
+		}
+		r.cleaned = true
+	}
+}
+
+// TaxID looks the record up in the taxid map, then under -resolve-by-name
+// resolves the binomial of its description. A taxid merged into another is
+// replaced by it when a taxdump is loaded. ok is true when no map is loaded
+// (taxid is then 0).
+func (r *QCRecord) TaxID() (taxid int, ok bool) {
+	if !r.taxidDone {
+		r.taxidFound = true
+		switch {
+		case r.env == nil || r.env.taxidMap == nil:
+		case r.env.taxidBloom != nil && !r.env.taxidBloom.mayContain(r.ID):
+			r.taxidFound = false
+		default:
+			r.taxid, r.taxidFound = r.env.taxidMap[r.ID]
+		}
+		if !r.taxidFound && r.env.resolver != nil {
+			var name string
+			if name, r.taxid, r.taxidFound = r.env.resolver.resolve(r.Desc); r.taxidFound {
+				r.byName = name
+			} else {
+				r.taxid, r.nameMissed = 0, true
+			}
+		}
+		if r.taxidFound && r.env != nil && r.env.dump != nil {
+			r.taxid, r.merged = r.env.dump.current(r.taxid)
+		}
+		r.taxidDone = true
+	}
+	return r.taxid, r.taxidFound
+}
+
+// Lineage returns the rank -> name map for the record's taxid, or nil when no
+// taxdump is loaded.
+func (r *QCRecord) Lineage() map[string]string {
+	if r.env == nil || r.env.dump == nil {
+		return nil
+	}
+	return r.rankLineage().toMap()
+}
+
+// rankLineage returns the lineage of the record's taxid without building
+// the map of Lineage; it is empty when no taxdump is loaded.
+func (r *QCRecord) rankLineage() taxLineage {
+	if !r.lineageDone && r.env != nil && r.env.dump != nil {
+		taxid, _ := r.TaxID()
+		r.lineage, r.lineageDone = r.env.dump.lineage(taxid), true
+	}
+	return r.lineage
+}
+
+// lineageOf returns the lineage of the record with id, empty when no
+// taxdump is loaded.
+func (env *QCFilterEnv) lineageOf(id string) taxLineage {
+	if env.dump == nil {
+		return taxLineage{}
+	}
+	return env.dump.lineage(env.taxidOf(id))
+}
+
+// taxidOf returns the taxid of the record with id from taxid.map, or as
+// resolved by name, or 0, after merges. Only the consumer may call it, since
+// it reads env.resolved.
+func (env *QCFilterEnv) taxidOf(id string) int {
+	taxid, ok := env.taxidMap[id]
+	if !ok {
+		taxid = env.resolved[id]
+	}
+	if env.dump != nil {
+		taxid, _ = env.dump.current(taxid)
+	}
+	return taxid
+}
+
+// QCFilter is one step of the qc chain. Counters lists the report counters
+// the filter may return as its drop detail so they appear in the report even
+// when zero.
+type QCFilter interface {
+	Name() string
+	Counters() []string
+	Check(rec *QCRecord) (QCVerdict, string)
+}
+
+// QCFilterEnv carries the loaded qc configuration and reference data to
+// filter factories.
+type QCFilterEnv struct {
+	cfg        qcConfig
+	taxidMap   map[string]int
+	taxidBloom *bloomFilter // optional prefilter over taxidMap keys
+	dump       *taxDump
+	rankRules  rankRules      // parsed cfg.RequireRanks
+	orient     seqOrienter    // nil when -orientation is off
+	primers    *primerTrimmer // nil without -trim-primers
+	lengthTrim *lengthTrimmer // nil without length trimming
+	chimeras   *chimeraIndex  // nil without -split-taxonomy-check
+	resolver   *nameResolver  // nil without -resolve-by-name
+	resolved   map[string]int // IDs resolved by name so far, by the consumer
+
+	scratchDir string      // spill directory, created on first use
+	spillSets  []*spillSet // spilling dedupe sets to check and close
+}
+
+// spillBudget returns the in-memory budget of each dedupe set, or 0 when
+// they keep their keys in memory.
+func (env *QCFilterEnv) spillBudget() int64 {
+	budget := env.cfg.DedupeMemLimit
+	if budget == 0 {
+		if !env.cfg.DedupeSpill {
+			return 0
+		}
+		budget = defaultDedupeSpillBudget
+	}
+	if env.cfg.DedupeSeqs && env.cfg.DedupeIDs {
+		budget = max(budget/2, 1)
+	}
+	return budget
+}
+
+// newDedupeSet returns the set a duplicate filter records keys in: a spill
+// set under -mem-limit or -dedupe-spill, else for hashed keys a hashSet (an
+// exactSet under -dedupe-exact) and otherwise a memSet.
+func (env *QCFilterEnv) newDedupeSet(name string, hashed bool) (dedupeSet, error) {
+	budget := env.spillBudget()
+	switch {
+	case budget > 0:
+	case !hashed:
+		return memSet{}, nil
+	case env.cfg.DedupeExact:
+		return newExactSet(), nil
+	default:
+		return newHashSet(), nil
+	}
+	dir, err := env.scratch()
+	if err != nil {
+		return nil, err
+	}
+	set := newSpillSet(dir, name, budget)
+	env.spillSets = append(env.spillSets, set)
+	return set, nil
+}
+
+// scratch returns the run's scratch directory for dedupe spill files,
+// creating it on first use under -scratch-dir or the output directory.
+func (env *QCFilterEnv) scratch() (string, error) {
+	if env.scratchDir == "" {
+		parent := env.cfg.ScratchDir
+		if parent == "" {
+			parent = filepath.Dir(env.cfg.OutputPath)
+		}
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return "", fmt.Errorf("create scratch dir: %w", err)
+		}
+		dir, err := os.MkdirTemp(parent, "boldkit-qc-dedupe-")
+		if err != nil {
+			return "", fmt.Errorf("create scratch dir: %w", err)
+		}
+		env.scratchDir = dir
+	}
+	return env.scratchDir, nil
+}
+
+// dedupeErr returns the first spill error of any dedupe set.
+func (env *QCFilterEnv) dedupeErr() error {
+	for _, set := range env.spillSets {
+		if err := set.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close removes the spill runs and the scratch directory.
+func (env *QCFilterEnv) close() error {
+	var first error
+	for _, set := range env.spillSets {
+		if set.spills > 0 {
+			logf("qc: %s dedupe spilled %d runs to disk (%d compactions)", set.name, set.spills, set.compactions)
+		}
+		if err := set.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	env.spillSets = nil
+	if env.scratchDir != "" {
+		if err := os.RemoveAll(env.scratchDir); err != nil && first == nil {
+			first = err
+		}
+		env.scratchDir = ""
+	}
+	return first
+}
+
+// QCFilterFactory builds a filter for a run. Returning a nil filter disables
+// it for that run (e.g. a threshold left at its "off" value).
+type QCFilterFactory func(env *QCFilterEnv) (QCFilter, error)
+
+type qcFilterEntry struct {
+	name    string
+	factory QCFilterFactory
+}
+
+var (
+	qcFilterMu       sync.Mutex
+	qcFilterRegistry []qcFilterEntry
+)
+
+// RegisterQCFilter adds a filter to the default chain after the filters
+// already registered. Names must be unique.
+func RegisterQCFilter(name string, factory QCFilterFactory) error {
+	qcFilterMu.Lock()
+	defer qcFilterMu.Unlock()
+	for _, e := range qcFilterRegistry {
+		if e.name == name {
+			return fmt.Errorf("qc filter %q already registered", name)
+		}
+	}
+	qcFilterRegistry = append(qcFilterRegistry, qcFilterEntry{name: name, factory: factory})
+	return nil
+}
+
+func mustRegisterQCFilter(name string, factory QCFilterFactory) {
+	if err := RegisterQCFilter(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+func qcFilterNames() []string {
+	qcFilterMu.Lock()
+	defer qcFilterMu.Unlock()
+	names := make([]string, len(qcFilterRegistry))
+	for i, e := range qcFilterRegistry {
+		names[i] = e.name
+	}
+	return names
+}
+
+// orderedQCFilters returns registry entries with the names in order first
+// (in that order) followed by the rest in registration order.
+func orderedQCFilters(order []string) ([]qcFilterEntry, error) {
+	qcFilterMu.Lock()
+	entries := append([]qcFilterEntry(nil), qcFilterRegistry...)
+	qcFilterMu.Unlock()
+
+	byName := make(map[string]qcFilterEntry, len(entries))
+	for _, e := range entries {
+		byName[e.name] = e
+	}
+	var out []qcFilterEntry
+	used := make(map[string]bool, len(order))
+	for _, name := range order {
+		e, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown qc filter %q (available: %s)", name, strings.Join(qcFilterNames(), ","))
+		}
+		if used[name] {
+			return nil, fmt.Errorf("qc filter %q listed twice in -filter-order", name)
+		}
+		used[name] = true
+		out = append(out, e)
+	}
+	for _, e := range entries {
+		if !used[e.name] {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// qcChain runs filters in order and tallies drops per counter.
+type qcChain struct {
+	env        *QCFilterEnv
+	filters    []QCFilter
+	concurrent []bool // filters prepare may run on worker goroutines
+}
+
+func newQCChain(env *QCFilterEnv, order []string) (*qcChain, error) {
+	entries, err := orderedQCFilters(order)
+	if err != nil {
+		return nil, err
+	}
+	chain := &qcChain{env: env}
+	for _, e := range entries {
+		f, err := e.factory(env)
+		if err != nil {
+			return nil, fmt.Errorf("qc filter %s: %w", e.name, err)
+		}
+		if f != nil {
+			c, ok := f.(concurrentQCFilter)
+			chain.filters = append(chain.filters, f)
+			chain.concurrent = append(chain.concurrent, ok && c.concurrent())
+		}
+	}
+	return chain, nil
+}
+
+func (c *qcChain) names() []string {
+	out := make([]string, len(c.filters))
+	for i, f := range c.filters {
+		out[i] = f.Name()
+	}
+	return out
+}
+
+// newStats returns stats with every counter of every registered filter
+// present (at zero), so reports keep a stable shape.
+func (c *qcChain) newStats() qcStats {
+	stats := qcStats{Dropped: make(map[string]int), NoTaxonomy: c.env.cfg.NoTaxonomy}
+	if c.env.orient != nil {
+		stats.Orientation = c.env.cfg.OrientationMode
+	}
+	stats.TrimPrimers = c.env.primers != nil
+	stats.TrimLength = c.env.lengthTrim != nil
+	stats.ResolveByName = c.env.resolver != nil
+	if c.env.dump != nil {
+		stats.ByRank = newRankBreakdown(c.env.cfg.reportRanks())
+		for _, r := range c.env.rankRules {
+			counts := &stats.MissingRanksByRule
+			if r.optional {
+				counts = &stats.MissingOptionalRanks
+			}
+			if *counts == nil {
+				*counts = make(map[string]int)
+			}
+			(*counts)[r.String()] = 0
+		}
+	}
+	for _, name := range qcLegacyCounters {
+		stats.Dropped[name] = 0
+	}
+	for _, f := range c.filters {
+		for _, name := range f.Counters() {
+			if _, ok := stats.Dropped[name]; !ok {
+				stats.Dropped[name] = 0
+			}
+		}
+	}
+	return stats
+}
+
+// check returns the counter of the first filter that drops rec, or "". The
+// verdicts of concurrent filters come from prepare when it has run.
+func (c *qcChain) check(rec *QCRecord) string {
+	rec.env = c.env
+	for i, f := range c.filters {
+		if rec.prepared && c.concurrent[i] {
+			if i == rec.prepDrop {
+				return rec.prepReason
+			}
+			continue
+		}
+		if verdict, detail := f.Check(rec); verdict == QCDrop {
+			return dropCounter(f, detail)
+		}
+	}
+	return ""
+}
+
+// prepare does the per-record work that needs no shared state, so qc workers
+// can run it ahead of check: cleaning, the taxid and lineage lookups, and
+// the concurrent filters up to the first that drops rec. Those verdicts do
+// not depend on the stateful filters, so check reaches the same result.
+func (c *qcChain) prepare(rec *QCRecord) {
+	rec.env = c.env
+	rec.ensureClean()
+	rec.TaxID()
+	rec.rankLineage()
+	rec.prepDrop = len(c.filters)
+	for i, f := range c.filters {
+		if !c.concurrent[i] {
+			continue
+		}
+		if verdict, detail := f.Check(rec); verdict == QCDrop {
+			rec.prepDrop, rec.prepReason = i, dropCounter(f, detail)
+			break
+		}
+	}
+	rec.prepared = true
+}
+
+func dropCounter(f QCFilter, detail string) string {
+	if detail == "" {
+		return f.Name()
+	}
+	return detail
+}
+
+// qcLegacyCounters are the drop counters of the original qc report, in
+// report order. Counters from other filters follow alphabetically.
+var qcLegacyCounters = []string{
+	"missing_taxid",
+	"missing_ranks",
+	"too_short",
+	"too_long",
+	"too_many_n",
+	"too_many_ambig",
+	"too_many_invalid",
+	"duplicate_sequence",
+	"duplicate_id",
+}
+
+// qcTaxonomyCounters only apply when qc loads taxonomy.
+var qcTaxonomyCounters = []string{"missing_taxid", "missing_ranks"}
+
+func (s qcStats) notApplicable(counter string) bool {
+	return s.NoTaxonomy && slices.Contains(qcTaxonomyCounters, counter)
+}
+
+func (s qcStats) counterNames() []string {
+	names := append([]string(nil), qcLegacyCounters...)
+	var extra []string
+	for name := range s.Dropped {
+		if !slices.Contains(qcLegacyCounters, name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	return append(names, extra...)
+}
+
+// qcCounterLabels are the short names used in the qc log line.
+var qcCounterLabels = map[string]string{
+	"missing_taxid":       "taxid",
+	"missing_ranks":       "ranks",
+	"deleted_taxid":       "deleted",
+	"too_short":           "short",
+	"too_long":            "long",
+	"too_many_n":          "n",
+	"too_many_ambig":      "ambig",
+	"too_many_invalid":    "invalid",
+	"duplicate_sequence":  "dup-seq",
+	"duplicate_id":        "dup-id",
+	"missing_id":          "id",
+	"header_not_included": "header-include",
+	"header_excluded":     "header-exclude",
+	"stop_codon":          "stop",
+	"taxon_limit":         "taxon-limit",
+	"not_included":        "not-included",
+	"low_complexity":      "low-complexity",
+	"no_primer":           "no-primer",
+	"below_min_per_taxon": "min-per-taxon",
+	"split_taxonomy":      "split-taxonomy",
+}
+
+func (s qcStats) dropSummary() string {
+	parts := make([]string, 0, len(s.Dropped))
+	for _, name := range s.counterNames() {
+		label := qcCounterLabels[name]
+		if label == "" {
+			label = name
+		}
+		if s.notApplicable(name) {
+			parts = append(parts, label+"=n/a")
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%d", label, s.Dropped[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// qcFunc adapts a function to QCFilter for the built-in filters. Unless
+// stateful, check may run on several goroutines at once.
+type qcFunc struct {
+	name     string
+	counters []string
+	stateful bool
+	check    func(rec *QCRecord) (QCVerdict, string)
+}
+
+// concurrentQCFilter is implemented by filters whose Check is safe to run on
+// qc worker goroutines, ahead of and alongside the other records. Filters
+// without it, including all registered with RegisterQCFilter, run in input
+// order on one goroutine.
+type concurrentQCFilter interface {
+	concurrent() bool
+}
+
+func (f qcFunc) concurrent() bool { return !f.stateful }
+
+func (f qcFunc) Name() string                            { return f.name }
+func (f qcFunc) Counters() []string                      { return f.counters }
+func (f qcFunc) Check(rec *QCRecord) (QCVerdict, string) { return f.check(rec) }
+
+func qcDrop(counter string) (QCVerdict, string) { return QCDrop, counter }
+
+// Built-in filters, registered in the order qc has always applied them.
+func init() {
+	mustRegisterQCFilter("id", func(env *QCFilterEnv) (QCFilter, error) {
+		// An empty ID has no taxid either; without taxonomy it gets its own
+		// counter.
+		counter := "missing_taxid"
+		if env.cfg.NoTaxonomy {
+			counter = "missing_id"
+		}
+		return qcFunc{name: "id", counters: []string{counter}, check: func(rec *QCRecord) (QCVerdict, string) {
+			if rec.ID == "" {
+				return qcDrop(counter)
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	// Before duplicate_id, so a record the header or ID list filters drop
+	// does not claim its ID.
+	mustRegisterQCFilter("header", func(env *QCFilterEnv) (QCFilter, error) {
+		if env.cfg.HeaderInclude == "" && env.cfg.HeaderExclude == "" {
+			return nil, nil
+		}
+		var include, exclude *regexp.Regexp
+		var err error
+		if env.cfg.HeaderInclude != "" {
+			if include, err = regexp.Compile(env.cfg.HeaderInclude); err != nil {
+				return nil, fmt.Errorf("header include: %w", err)
+			}
+		}
+		if env.cfg.HeaderExclude != "" {
+			if exclude, err = regexp.Compile(env.cfg.HeaderExclude); err != nil {
+				return nil, fmt.Errorf("header exclude: %w", err)
+			}
+		}
+		var counters []string
+		if include != nil {
+			counters = append(counters, "header_not_included")
+		}
+		if exclude != nil {
+			counters = append(counters, "header_excluded")
+		}
+		return qcFunc{name: "header", counters: counters, check: func(rec *QCRecord) (QCVerdict, string) {
+			if include != nil && !include.MatchString(rec.Desc) {
+				return qcDrop("header_not_included")
+			}
+			if exclude != nil && exclude.MatchString(rec.Desc) {
+				return qcDrop("header_excluded")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("id_list", func(env *QCFilterEnv) (QCFilter, error) {
+		if env.cfg.ExcludeIDs == "" && env.cfg.IncludeIDs == "" {
+			return nil, nil
+		}
+		var exclude, include map[string]struct{}
+		var counters []string
+		var err error
+		if env.cfg.ExcludeIDs != "" {
+			if exclude, err = loadIDSet(env.cfg.ExcludeIDs); err != nil {
+				return nil, err
+			}
+			logf("qc: excluding %d IDs listed in %s", len(exclude), env.cfg.ExcludeIDs)
+			counters = append(counters, "excluded")
+		}
+		if env.cfg.IncludeIDs != "" {
+			if include, err = loadIDSet(env.cfg.IncludeIDs); err != nil {
+				return nil, err
+			}
+			logf("qc: keeping only the %d IDs listed in %s", len(include), env.cfg.IncludeIDs)
+			counters = append(counters, "not_included")
+		}
+		return qcFunc{name: "id_list", counters: counters, check: func(rec *QCRecord) (QCVerdict, string) {
+			if _, ok := exclude[rec.ID]; ok {
+				return qcDrop("excluded")
+			}
+			if _, ok := include[rec.ID]; include != nil && !ok {
+				return qcDrop("not_included")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("duplicate_id", func(env *QCFilterEnv) (QCFilter, error) {
+		// Under -dedupe-policy longest the selector settles duplicate IDs.
+		if !env.cfg.DedupeIDs || env.cfg.DedupePolicy == dedupeLongest {
+			return nil, nil
+		}
+		seen, err := env.newDedupeSet("duplicate_id", false)
+		if err != nil {
+			return nil, err
+		}
+		return qcFunc{name: "duplicate_id", counters: []string{"duplicate_id"}, stateful: true, check: func(rec *QCRecord) (QCVerdict, string) {
+			if seen.add(rec.ID) {
+				return qcDrop("duplicate_id")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("taxid", func(env *QCFilterEnv) (QCFilter, error) {
+		if env.taxidMap == nil {
+			return nil, nil
+		}
+		return qcFunc{name: "taxid", counters: []string{"missing_taxid"}, check: func(rec *QCRecord) (QCVerdict, string) {
+			if _, ok := rec.TaxID(); !ok {
+				return qcDrop("missing_taxid")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("ranks", func(env *QCFilterEnv) (QCFilter, error) {
+		if !env.rankRules.required() || env.dump == nil {
+			return nil, nil
+		}
+		counters := []string{"missing_ranks"}
+		if len(env.dump.deleted) > 0 {
+			counters = append(counters, "deleted_taxid")
+		}
+		return qcFunc{name: "ranks", counters: counters, check: func(rec *QCRecord) (QCVerdict, string) {
+			if env.rankRules.firstFailed(rec.rankLineage()) < 0 {
+				return QCPass, ""
+			}
+			// A deleted taxid has no lineage at all; say so.
+			if taxid, _ := rec.TaxID(); env.dump.isDeleted(taxid) {
+				return qcDrop("deleted_taxid")
+			}
+			return qcDrop("missing_ranks")
+		}}, nil
+	})
+	mustRegisterQCFilter("length", func(env *QCFilterEnv) (QCFilter, error) {
+		minLen, maxLen := env.cfg.MinLen, env.cfg.MaxLen
+		return qcFunc{name: "length", counters: []string{"too_short", "too_long"}, check: func(rec *QCRecord) (QCVerdict, string) {
+			n := len(rec.Clean())
+			switch {
+			case n == 0, minLen > 0 && n < minLen:
+				return qcDrop("too_short")
+			case maxLen > 0 && n > maxLen:
+				return qcDrop("too_long")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("n", func(env *QCFilterEnv) (QCFilter, error) {
+		if env.cfg.MaxN < 0 {
+			return nil, nil
+		}
+		return qcFunc{name: "n", counters: []string{"too_many_n"}, check: func(rec *QCRecord) (QCVerdict, string) {
+			if n, _, _ := rec.Counts(); n > env.cfg.MaxN {
+				return qcDrop("too_many_n")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("ambig", func(env *QCFilterEnv) (QCFilter, error) {
+		if env.cfg.MaxAmbig < 0 {
+			return nil, nil
+		}
+		return qcFunc{name: "ambig", counters: []string{"too_many_ambig"}, check: func(rec *QCRecord) (QCVerdict, string) {
+			if _, ambig, _ := rec.Counts(); ambig > env.cfg.MaxAmbig {
+				return qcDrop("too_many_ambig")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("invalid", func(env *QCFilterEnv) (QCFilter, error) {
+		return qcFunc{name: "invalid", counters: []string{"too_many_invalid"}, check: func(rec *QCRecord) (QCVerdict, string) {
+			if _, _, invalid := rec.Counts(); invalid > env.cfg.MaxInvalid {
+				return qcDrop("too_many_invalid")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("primer", func(env *QCFilterEnv) (QCFilter, error) {
+		if !env.cfg.PrimerRequired || env.primers == nil {
+			return nil, nil
+		}
+		return qcFunc{name: "primer", counters: []string{"no_primer"}, check: func(rec *QCRecord) (QCVerdict, string) {
+			if start, end := rec.TrimmedPrimers(); !start && !end {
+				return qcDrop("no_primer")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("complexity", func(env *QCFilterEnv) (QCFilter, error) {
+		maxRun, minEntropy := env.cfg.MaxHomopolymer, env.cfg.MinComplexity
+		if maxRun <= 0 && minEntropy <= 0 {
+			return nil, nil
+		}
+		var counters []string
+		if maxRun > 0 {
+			counters = append(counters, "homopolymer")
+		}
+		if minEntropy > 0 {
+			counters = append(counters, "low_complexity")
+		}
+		return qcFunc{name: "complexity", counters: counters, check: func(rec *QCRecord) (QCVerdict, string) {
+			run, entropy := sequenceComplexity(rec.Clean())
+			if maxRun > 0 && run > maxRun {
+				return qcDrop("homopolymer")
+			}
+			if minEntropy > 0 && entropy < minEntropy {
+				return qcDrop("low_complexity")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("orf", func(env *QCFilterEnv) (QCFilter, error) {
+		if !env.cfg.CheckORF {
+			return nil, nil
+		}
+		stops, ok := newStopCodonSet(env.cfg.ORFTable)
+		if !ok {
+			return nil, fmt.Errorf("unsupported translation table %d (supported: %s)", env.cfg.ORFTable, orfTables())
+		}
+		return qcFunc{name: "orf", counters: []string{"stop_codon"}, check: func(rec *QCRecord) (QCVerdict, string) {
+			if !stops.hasOpenFrame(rec.Clean()) {
+				return qcDrop("stop_codon")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("split_taxonomy", func(env *QCFilterEnv) (QCFilter, error) {
+		if env.chimeras == nil {
+			return nil, nil
+		}
+		// Flagged records are dropped only under -split-taxonomy-drop; either
+		// way runQCFasta lists them for review.
+		var counters []string
+		if env.cfg.SplitTaxonomyDrop {
+			counters = []string{"split_taxonomy"}
+		}
+		return qcFunc{name: "split_taxonomy", counters: counters, check: func(rec *QCRecord) (QCVerdict, string) {
+			rec.chimera = env.chimeras.check(rec)
+			if rec.chimera != nil && env.cfg.SplitTaxonomyDrop {
+				return qcDrop("split_taxonomy")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+	mustRegisterQCFilter("duplicate_sequence", func(env *QCFilterEnv) (QCFilter, error) {
+		if !env.cfg.DedupeSeqs {
+			return nil, nil
+		}
+		seen, err := env.newDedupeSet("duplicate_sequence", true)
+		if err != nil {
+			return nil, err
+		}
+		return qcFunc{name: "duplicate_sequence", counters: []string{"duplicate_sequence"}, stateful: true, check: func(rec *QCRecord) (QCVerdict, string) {
+			if seen.add(string(rec.Clean())) {
+				return qcDrop("duplicate_sequence")
+			}
+			return QCPass, ""
+		}}, nil
+	})
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultORFTable is the NCBI invertebrate mitochondrial code, under which
+// TGA is tryptophan and only TAA and TAG stop.
+const defaultORFTable = 5
+
+// stopCodons lists the stop codons of the NCBI genetic codes used for
+// barcoding markers, by translation table number.
+var stopCodons = map[int][]string{
+	1:  {"TAA", "TAG", "TGA"},        // standard
+	2:  {"TAA", "TAG", "AGA", "AGG"}, // vertebrate mitochondrial
+	3:  {"TAA", "TAG"},               // yeast mitochondrial
+	4:  {"TAA", "TAG"},               // mold, protozoan, and coelenterate mitochondrial
+	5:  {"TAA", "TAG"},               // invertebrate mitochondrial
+	9:  {"TAA", "TAG"},               // echinoderm and flatworm mitochondrial
+	11: {"TAA", "TAG", "TGA"},        // bacterial, archaeal, and plant plastid
+	13: {"TAA", "TAG"},               // ascidian mitochondrial
+	14: {"TAG"},                      // alternative flatworm mitochondrial
+	21: {"TAA", "TAG"},               // trematode mitochondrial
+}
+
+// orfTables returns the supported translation tables, for flag help and
+// errors.
+func orfTables() string {
+	tables := make([]int, 0, len(stopCodons))
+	for t := range stopCodons {
+		tables = append(tables, t)
+	}
+	slices.Sort(tables)
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = strconv.Itoa(t)
+	}
+	return strings.Join(names, ",")
+}
+
+// stopCodonSet indexes the stop codons of a table by their 6-bit code.
+type stopCodonSet [64]bool
+
+func newStopCodonSet(table int) (stopCodonSet, bool) {
+	var set stopCodonSet
+	codons, ok := stopCodons[table]
+	for _, c := range codons {
+		set[codonCode(c[0], c[1], c[2])] = true
+	}
+	return set, ok
+}
+
+func codonCode(a, b, c byte) int {
+	return int(baseCode[a]<<4 | baseCode[b]<<2 | baseCode[c])
+}
+
+// hasOpenFrame reports whether any of the three forward frames of an ACGT
+// sequence is free of stop codons. A sequence too short for a codon has
+// open frames.
+func (s *stopCodonSet) hasOpenFrame(seq []byte) bool {
+	for frame := 0; frame < 3; frame++ {
+		open := true
+		for i := frame; i+3 <= len(seq); i += 3 {
+			if s[codonCode(seq[i], seq[i+1], seq[i+2])] {
+				open = false
+				break
+			}
+		}
+		if open {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHasOpenFrame(t *testing.T) {
+	stops, ok := newStopCodonSet(defaultORFTable)
+	if !ok {
+		t.Fatal("table 5 missing")
+	}
+	cases := []struct {
+		seq  string
+		open bool
+	}{
+		{"", true},
+		{"T", true},
+		{"TA", true},
+		// Frames 2 and 3 hold no complete codon.
+		{"TAA", true},
+		{"TAGTAG", true},
+		// Stops in TAG|CTA|AGT|AG, T|AGC|TAA|GTA|G and TA|GCT|AAG|TAG.
+		{"TAGCTAAGTAG", false},
+		{"TAGCTAAGTAC", true},
+		{"TGATGATGA", true},
+	}
+	for _, tc := range cases {
+		if got := stops.hasOpenFrame([]byte(tc.seq)); got != tc.open {
+			t.Errorf("hasOpenFrame(%q) = %v, want %v", tc.seq, got, tc.open)
+		}
+	}
+	std, _ := newStopCodonSet(1)
+	if !std[codonCode('T', 'G', 'A')] || stops[codonCode('T', 'G', 'A')] {
+		t.Fatal("TGA stops under table 1 only")
+	}
+	if _, ok := newStopCodonSet(7); ok {
+		t.Fatal("table 7 does not exist")
+	}
+}
+
+func TestQCCheckORF(t *testing.T) {
+	dir := t.TempDir()
+	run := func(table int) (qcStats, string) {
+		t.Helper()
+		out := filepath.Join(dir, "out.fasta")
+		stats, err := runQCFasta(context.Background(), filepath.Join("testdata", "qc", "orf.fasta"), qcConfig{
+			NoTaxonomy: true, MaxN: -1, MaxAmbig: -1, OutputPath: out, CheckORF: true, ORFTable: table,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats, string(got)
+	}
+
+	// The clean record reads through in frame 1 under the invertebrate
+	// mitochondrial code; the pseudogene has stops in every frame.
+	stats, got := run(defaultORFTable)
+	if stats.Written != 1 || stats.Dropped["stop_codon"] != 1 || !strings.HasPrefix(got, ">COI_clean\n") {
+		t.Fatalf("table 5: %+v\n%s", stats, got)
+	}
+	// Its TGA tryptophan codons are stops in the standard code.
+	if stats, _ := run(1); stats.Written != 0 || stats.Dropped["stop_codon"] != 2 {
+		t.Fatalf("table 1: %+v", stats)
+	}
+}
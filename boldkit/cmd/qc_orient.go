@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+)
+
+// qc -orientation modes.
+const (
+	orientationOff       = "off"
+	orientationCanonical = "canonical"
+	orientationReference = "reference"
+)
+
+var orientationModes = []string{orientationOff, orientationCanonical, orientationReference}
+
+// Minimizer sketch parameters for reference orientation: 15-mers, the
+// smallest hash of every window of 10 consecutive k-mers.
+const (
+	orientK      = 15
+	orientWindow = 10
+)
+
+// seqOrienter rewrites a cleaned (ACGT-only) sequence in place into its
+// chosen orientation and reports whether it was reverse-complemented.
+type seqOrienter func(seq []byte) bool
+
+// newSeqOrienter returns the orienter of cfg.OrientationMode, or nil when
+// orientation is off.
+func newSeqOrienter(cfg qcConfig) (seqOrienter, error) {
+	switch cfg.OrientationMode {
+	case "", orientationOff:
+		return nil, nil
+	case orientationCanonical:
+		return orientCanonical, nil
+	case orientationReference:
+		if cfg.OrientationRef == "" {
+			return nil, errors.New("orientation reference requires a reference FASTA")
+		}
+		sketch, err := loadOrientationSketch(cfg.OrientationRef)
+		if err != nil {
+			return nil, err
+		}
+		return sketch.orient, nil
+	default:
+		return nil, fmt.Errorf("unknown orientation mode %q", cfg.OrientationMode)
+	}
+}
+
+var complementBase = func() (t [256]byte) {
+	t['A'], t['C'], t['G'], t['T'] = 'T', 'G', 'C', 'A'
+	return t
+}()
+
+// reverseComplement reverse-complements an ACGT sequence in place.
+func reverseComplement(seq []byte) {
+	for i, j := 0, len(seq)-1; i <= j; i, j = i+1, j-1 {
+		seq[i], seq[j] = complementBase[seq[j]], complementBase[seq[i]]
+	}
+}
+
+// orientCanonical keeps the lexicographically smaller of seq and its
+// reverse complement, so both strands of a sequence dedupe together.
+func orientCanonical(seq []byte) bool {
+	n := len(seq)
+	for i := 0; i < n; i++ {
+		rc := complementBase[seq[n-1-i]]
+		if seq[i] == rc {
+			continue
+		}
+		if rc < seq[i] {
+			reverseComplement(seq)
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// orientationSketch holds the minimizers of the reference sequences, in
+// their given orientation.
+type orientationSketch map[uint64]struct{}
+
+func loadOrientationSketch(path string) (orientationSketch, error) {
+	in, _, err := openFastaInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("open orientation reference: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	sketch := make(orientationSketch)
+	err = parseFasta(in, func(rec fastaRecord) error {
+		clean, _ := cleanSequence(rec.seq)
+		forEachMinimizer(clean, func(h uint64) {
+			sketch[h] = struct{}{}
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read orientation reference: %w", err)
+	}
+	if len(sketch) == 0 {
+		return nil, fmt.Errorf("orientation reference %s has no sequence of at least %d bases", path, orientK)
+	}
+	return sketch, nil
+}
+
+// orient flips seq when its reverse complement shares more minimizers with
+// the reference than seq does. Ties, including no hits at all, keep seq.
+func (s orientationSketch) orient(seq []byte) bool {
+	forward := s.hits(seq)
+	reverseComplement(seq)
+	if s.hits(seq) > forward {
+		return true
+	}
+	reverseComplement(seq)
+	return false
+}
+
+func (s orientationSketch) hits(seq []byte) int {
+	n := 0
+	forEachMinimizer(seq, func(h uint64) {
+		if _, ok := s[h]; ok {
+			n++
+		}
+	})
+	return n
+}
+
+// baseCode is the 2-bit code of each base; cleaned sequences hold no other
+// bytes.
+var baseCode = func() (t [256]uint64) {
+	t['C'], t['G'], t['T'] = 1, 2, 3
+	return t
+}()
+
+// forEachMinimizer calls fn with the minimizer of each window of
+// orientWindow consecutive k-mers of an ACGT sequence, skipping a repeat of
+// the previous window's minimizer. k-mers are 2-bit packed and hashed so the
+// minimizers are not biased toward poly-A.
+func forEachMinimizer(seq []byte, fn func(uint64)) {
+	if len(seq) < orientK {
+		return
+	}
+	hashes := make([]uint64, 0, len(seq)-orientK+1)
+	const mask = 1<<(2*orientK) - 1
+	var kmer uint64
+	for i, c := range seq {
+		kmer = (kmer<<2 | baseCode[c]) & mask
+		if i >= orientK-1 {
+			hashes = append(hashes, mixHash(kmer))
+		}
+	}
+	window := min(orientWindow, len(hashes))
+	var last uint64
+	for i := 0; i+window <= len(hashes); i++ {
+		m := hashes[i]
+		for _, h := range hashes[i+1 : i+window] {
+			m = min(m, h)
+		}
+		if i == 0 || m != last {
+			fn(m)
+		}
+		last = m
+	}
+}
+
+// mixHash is the splitmix64 finalizer.
+func mixHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func randomACGT(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = "ACGT"[rng.Intn(4)]
+	}
+	return string(b)
+}
+
+func revComp(s string) string {
+	b := []byte(s)
+	reverseComplement(b)
+	return string(b)
+}
+
+func TestReverseComplement(t *testing.T) {
+	for in, want := range map[string]string{"": "", "A": "T", "ACG": "CGT", "AACCGGTT": "AACCGGTT", "GATTACA": "TGTAATC"} {
+		if got := revComp(in); got != want {
+			t.Errorf("reverseComplement(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOrientCanonical(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		seq := randomACGT(rng, 1+rng.Intn(40))
+		fwd, rc := []byte(seq), []byte(revComp(seq))
+		flippedFwd, flippedRC := orientCanonical(fwd), orientCanonical(rc)
+		if string(fwd) != string(rc) {
+			t.Fatalf("%s: strands canonicalize to %s and %s", seq, fwd, rc)
+		}
+		if want := min(seq, revComp(seq)); string(fwd) != want {
+			t.Fatalf("%s: canonical %s, want %s", seq, fwd, want)
+		}
+		if flippedFwd != (seq > revComp(seq)) || (flippedFwd && flippedRC) {
+			t.Fatalf("%s: flipped %v/%v", seq, flippedFwd, flippedRC)
+		}
+	}
+}
+
+func TestQCOrientation(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	ref := randomACGT(rng, 600)
+	dir := t.TempDir()
+	refPath := filepath.Join(dir, "ref.fasta")
+	if err := os.WriteFile(refPath, []byte(">ref\n"+ref+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// P2 is P1 deposited on the other strand; P3 is a mutated, reversed
+	// fragment of the reference; P4 shares nothing with it.
+	frag := []byte(ref[100:500])
+	for i := 0; i < len(frag); i += 40 {
+		frag[i] = "ACGT"[(strings.IndexByte("ACGT", frag[i])+1)%4]
+	}
+	unrelated := randomACGT(rng, 300)
+	input := filepath.Join(dir, "in.fasta")
+	fasta := ">P1\n" + ref[:400] + "\n>P2\n" + revComp(ref[:400]) + "\n>P3\n" + revComp(string(frag)) + "\n>P4\n" + unrelated + "\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(mode, refPath string) (qcStats, string) {
+		t.Helper()
+		out := filepath.Join(dir, mode+".fasta")
+		stats, err := runQCFasta(context.Background(), input, qcConfig{
+			NoTaxonomy: true, MaxN: -1, MaxAmbig: -1, DedupeSeqs: true, DedupeIDs: true,
+			OutputPath: out, OrientationMode: mode, OrientationRef: refPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats, string(got)
+	}
+
+	stats, _ := run(orientationOff, "")
+	if stats.Written != 4 || stats.Orientation != "" {
+		t.Fatalf("off: %+v", stats)
+	}
+
+	stats, _ = run(orientationCanonical, "")
+	if stats.Written != 3 || stats.Dropped["duplicate_sequence"] != 1 || stats.Orientation != orientationCanonical {
+		t.Fatalf("canonical: %+v", stats)
+	}
+
+	stats, got := run(orientationReference, refPath)
+	want := ">P1\n" + ref[:400] + "\n>P3\n" + string(frag) + "\n>P4\n" + unrelated + "\n"
+	if got != want {
+		t.Fatalf("reference output:\n%s\nwant:\n%s", got, want)
+	}
+	if stats.Flipped != 1 || stats.Dropped["duplicate_sequence"] != 1 {
+		t.Fatalf("reference: %+v", stats)
+	}
+
+	empty := filepath.Join(dir, "empty.fasta")
+	if err := os.WriteFile(empty, []byte(">short\nACGT\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSeqOrienter(qcConfig{OrientationMode: orientationReference, OrientationRef: empty}); err == nil {
+		t.Fatal("reference without k-mers: want error")
+	}
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// qcBatchRecords is the number of records a qc worker prepares at a time.
+const qcBatchRecords = 256
+
+// qcBatch is a run of consecutive input records, numbered by seq.
+type qcBatch struct {
+	seq  int64
+	recs []QCRecord
+}
+
+// parseRecords reads the FASTA records of r, keeping those sample selects,
+// and calls onRecord with each in input order. A reader goroutine batches
+// the records, workers goroutines prepare them (see qcChain.prepare), and
+// the calling goroutine reorders the batches, so onRecord and the stateful
+// filters it runs see the same sequence as a serial pass. Cancelling parent
+// stops the reader; the records already read are still delivered, then
+// parent's error is returned.
+func (c *qcChain) parseRecords(parent context.Context, r io.Reader, workers int, sample sampleConfig, onRecord func(*QCRecord) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := make(chan *qcBatch, workers*2)
+	results := make(chan *qcBatch, workers*2)
+	readErrCh := make(chan error, 1)
+
+	go func() {
+		defer close(batches)
+		batch := &qcBatch{recs: make([]QCRecord, 0, qcBatchRecords)}
+		send := func() error {
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			batch = &qcBatch{seq: batch.seq + 1, recs: make([]QCRecord, 0, qcBatchRecords)}
+			return nil
+		}
+		var seen, sampled int64
+		err := parseFastaContext(parent, r, func(rec fastaRecord) error {
+			if seen++; sample.Every > 1 && (seen-1)%sample.Every != 0 {
+				return nil
+			}
+			if sample.Limit > 0 && sampled >= sample.Limit {
+				return errStopRows
+			}
+			sampled++
+			batch.recs = append(batch.recs, QCRecord{ID: rec.id, Desc: rec.desc, Seq: rec.seq})
+			if len(batch.recs) == qcBatchRecords {
+				return send()
+			}
+			return nil
+		})
+		switch {
+		case err == nil || errors.Is(err, errStopRows):
+			err = nil
+			if len(batch.recs) > 0 {
+				err = send()
+			}
+		case parent.Err() != nil && len(batch.recs) > 0:
+			// Deliver what was read before the interruption.
+			if sendErr := send(); sendErr != nil {
+				err = sendErr
+			}
+		}
+		readErrCh <- err
+	}()
+
+	var workerWG sync.WaitGroup
+	for range workers {
+		workerWG.Add(1)
+		go func() {
+			defer workerWG.Done()
+			for batch := range batches {
+				// After a failure, pass batches through unprepared so the
+				// reader can finish promptly.
+				if ctx.Err() == nil {
+					for i := range batch.recs {
+						c.prepare(&batch.recs[i])
+					}
+				}
+				results <- batch
+			}
+		}()
+	}
+	go func() {
+		workerWG.Wait()
+		close(results)
+	}()
+
+	var err error
+	next := int64(0)
+	pending := make(map[int64]*qcBatch)
+	for batch := range results {
+		if err != nil {
+			continue
+		}
+		pending[batch.seq] = batch
+		for err == nil {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			for i := range ready.recs {
+				if err = onRecord(&ready.recs[i]); err != nil {
+					cancel()
+					break
+				}
+			}
+		}
+	}
+	readErr := <-readErrCh
+	if err != nil {
+		return err
+	}
+	return readErr
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeQCCorpus writes a few thousand records spanning many worker batches,
+// with repeated IDs and sequences, N and ambiguity codes, and taxids that
+// pass, lack ranks, or are missing.
+func writeQCCorpus(t *testing.T, dir string) string {
+	t.Helper()
+	writeTaxdumpFixture(t, dir)
+	rng := rand.New(rand.NewSource(1))
+	pool := make([]string, 300)
+	for i := range pool {
+		seq := make([]byte, 3+rng.Intn(40))
+		for j := range seq {
+			seq[j] = "ACGTACGTACGTacgtNR"[rng.Intn(18)]
+		}
+		pool[i] = string(seq)
+	}
+	var fasta, taxids strings.Builder
+	for i := 0; i < 5000; i++ {
+		id := fmt.Sprintf("R%d", i)
+		if i%97 == 0 && i > 0 {
+			id = fmt.Sprintf("R%d", i-1)
+		}
+		fmt.Fprintf(&fasta, ">%s sample %d\n%s\n", id, i, pool[rng.Intn(len(pool))])
+		switch i % 10 {
+		case 0:
+		case 1:
+			fmt.Fprintf(&taxids, "%s\t8\n", id)
+		default:
+			fmt.Fprintf(&taxids, "%s\t7\n", id)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "taxid.map"), []byte(taxids.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "corpus.fasta")
+	if err := os.WriteFile(input, []byte(fasta.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return input
+}
+
+func TestQCWorkersMatchSerial(t *testing.T) {
+	dir := t.TempDir()
+	input := writeQCCorpus(t, dir)
+	base := qcConfig{
+		MaxN:         -1,
+		MaxAmbig:     -1,
+		DedupeSeqs:   true,
+		DedupeIDs:    true,
+		RequireRanks: splitList("kingdom,phylum,class,order,family,genus,species"),
+		TaxdumpDir:   dir,
+	}
+	strict := base
+	strict.MinLen, strict.MaxLen, strict.MaxN, strict.MaxAmbig = 10, 35, 1, 0
+	strict.FilterOrder = []string{"duplicate_sequence", "length"}
+	longest := base
+	longest.OrientationMode = orientationCanonical
+	longest.DedupePolicy = dedupeLongest
+	longest.KeepDesc = true
+	perTaxon := base
+	perTaxon.DedupePolicy = dedupePerTaxon
+	perTaxon.MaxPerTaxon = 50
+	sampled := base
+	sampled.NoTaxonomy = true
+	sampled.SampleEvery = 3
+	sampled.SampleLimit = 1200
+	cases := map[string]qcConfig{"defaults": base, "strict": strict, "longest": longest, "per-taxon": perTaxon, "sampled": sampled}
+
+	for name, cfg := range cases {
+		t.Run(name, func(t *testing.T) {
+			var outputs [][]byte
+			var reports []qcStats
+			for _, workers := range []int{1, 7} {
+				cfg := cfg
+				cfg.Workers = workers
+				cfg.OutputPath = filepath.Join(dir, name, fmt.Sprintf("w%d.fasta", workers))
+				cfg.RejectsTSV = filepath.Join(dir, name, fmt.Sprintf("w%d.rejects.tsv", workers))
+				stats, err := runQCFasta(context.Background(), input, cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				out, err := os.ReadFile(cfg.OutputPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rejects, err := os.ReadFile(cfg.RejectsTSV)
+				if err != nil {
+					t.Fatal(err)
+				}
+				outputs = append(outputs, append(out, rejects...))
+				reports = append(reports, stats)
+			}
+			if reports[0].Written == 0 || reports[0].Written == reports[0].Total {
+				t.Fatalf("corpus does not exercise the filters: %+v", reports[0])
+			}
+			if !bytes.Equal(outputs[0], outputs[1]) {
+				t.Fatal("outputs differ between 1 and 7 workers")
+			}
+			if !reflect.DeepEqual(reports[0], reports[1]) {
+				t.Fatalf("stats differ:\n%+v\n%+v", reports[0], reports[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// primerWindow is how far into each end of a cleaned sequence qc looks for
+// a primer.
+const primerWindow = 40
+
+// defaultPrimerMismatches is the -primer-mismatches default.
+const defaultPrimerMismatches = 2
+
+// iupacBases is the set of bases each IUPAC code matches, one bit per
+// baseCode (A=1, C=2, G=4, T=8).
+var iupacBases = func() (t [256]uint8) {
+	for code, set := range map[byte]uint8{
+		'A': 1, 'C': 2, 'G': 4, 'T': 8, 'U': 8,
+		'R': 1 | 4, 'Y': 2 | 8, 'S': 2 | 4, 'W': 1 | 8, 'K': 4 | 8, 'M': 1 | 2,
+		'B': 2 | 4 | 8, 'D': 1 | 4 | 8, 'H': 1 | 2 | 8, 'V': 1 | 2 | 4, 'N': 15,
+	} {
+		t[code], t[code+32] = set, set
+	}
+	return t
+}()
+
+// complementBases swaps A with T and C with G in a base set.
+func complementBases(set uint8) uint8 {
+	return set&1<<3 | set&8>>3 | set&2<<1 | set&4>>1
+}
+
+// primerPattern is a primer (or its reverse complement) compiled for bitap:
+// bit i of masks[b] is set when position i matches the base of code b.
+type primerPattern struct {
+	name   string
+	length int
+	masks  [4]uint64
+}
+
+func newPrimerPattern(name string, sets []uint8) primerPattern {
+	p := primerPattern{name: name, length: len(sets)}
+	for i, set := range sets {
+		for b := range p.masks {
+			if set&(1<<b) != 0 {
+				p.masks[b] |= 1 << i
+			}
+		}
+	}
+	return p
+}
+
+// best returns the end offset and mismatch count of the match of p in an
+// ACGT window with the fewest (at most k) substitutions; ties go to the
+// last match when preferLast is set and to the first otherwise. r is
+// scratch space for k+1 states.
+func (p *primerPattern) best(window []byte, k int, r []uint64, preferLast bool) (end, mismatches int, ok bool) {
+	clear(r)
+	hit := uint64(1) << (p.length - 1)
+	for i, c := range window {
+		m := p.masks[baseCode[c]]
+		prev := r[0]
+		r[0] = (r[0]<<1 | 1) & m
+		for d := 1; d <= k; d++ {
+			cur := r[d]
+			r[d] = (cur<<1|1)&m | (prev<<1 | 1)
+			prev = cur
+		}
+		for d := 0; d <= k; d++ {
+			if r[d]&hit == 0 {
+				continue
+			}
+			if !ok || d < mismatches || d == mismatches && preferLast {
+				end, mismatches, ok = i+1, d, true
+			}
+			break
+		}
+	}
+	return end, mismatches, ok
+}
+
+// primerTrimmer finds primers near the ends of cleaned sequences and cuts
+// them off. It is read-only once loaded, so qc workers share it.
+type primerTrimmer struct {
+	patterns      []primerPattern // each primer, then its reverse complement
+	maxMismatches int
+}
+
+// loadPrimerTrimmer reads the primers of a FASTA file. Primers may use
+// IUPAC codes and must fit the search window.
+func loadPrimerTrimmer(path string, maxMismatches int) (*primerTrimmer, error) {
+	in, _, err := openFastaInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("open primers: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	t := &primerTrimmer{maxMismatches: maxMismatches}
+	err = parseFasta(in, func(rec fastaRecord) error {
+		seq := strings.Join(strings.Fields(string(rec.seq)), "")
+		sets := make([]uint8, len(seq))
+		for i := range seq {
+			if sets[i] = iupacBases[seq[i]]; sets[i] == 0 {
+				return fmt.Errorf("primer %s: invalid base %q", rec.id, seq[i])
+			}
+		}
+		switch {
+		case len(seq) > primerWindow:
+			return fmt.Errorf("primer %s is %d bases; primers must fit the %d-base search window", rec.id, len(seq), primerWindow)
+		case len(seq) <= maxMismatches:
+			return fmt.Errorf("primer %s is %d bases, no longer than the %d mismatches allowed", rec.id, len(seq), maxMismatches)
+		}
+		rc := make([]uint8, len(sets))
+		for i, set := range sets {
+			rc[len(sets)-1-i] = complementBases(set)
+		}
+		t.patterns = append(t.patterns, newPrimerPattern(rec.id, sets), newPrimerPattern(rec.id+" (reverse complement)", rc))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read primers: %w", err)
+	}
+	if len(t.patterns) == 0 {
+		return nil, errors.New("primer file has no primers")
+	}
+	return t, nil
+}
+
+// trim cuts the best primer match in the first primerWindow bases, and
+// everything before it, and likewise the best match in the last
+// primerWindow bases and everything after it. Either strand of any primer
+// may match at either end. A sequence whose matches overlap is cut to
+// nothing.
+func (t *primerTrimmer) trim(seq []byte) (out []byte, start, end bool) {
+	state := make([]uint64, t.maxMismatches+1)
+	w := min(primerWindow, len(seq))
+	from, to := 0, len(seq)
+	bestFrom, bestTo := t.maxMismatches+1, t.maxMismatches+1
+	for i := range t.patterns {
+		p := &t.patterns[i]
+		if e, d, ok := p.best(seq[:w], t.maxMismatches, state, false); ok && (d < bestFrom || d == bestFrom && e < from) {
+			from, bestFrom, start = e, d, true
+		}
+		if e, d, ok := p.best(seq[len(seq)-w:], t.maxMismatches, state, true); ok {
+			if s := len(seq) - w + e - p.length; d < bestTo || d == bestTo && s > to {
+				to, bestTo, end = s, d, true
+			}
+		}
+	}
+	return seq[from:max(from, to)], start, end
+}
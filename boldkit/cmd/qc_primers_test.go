@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Folmer et al. (1994) COI primers.
+const (
+	primerLCO1490 = "GGTCAACAAATCATAAAGATATTGG"
+	primerHCO2198 = "TAAACTTCAGGGTGACCAAAAAATCA"
+)
+
+func writePrimers(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "primers.fasta")
+	if err := os.WriteFile(path, []byte(">LCO1490\n"+primerLCO1490+"\n>HCO2198\n"+primerHCO2198+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrimerPatternBest(t *testing.T) {
+	sets := func(s string) []uint8 {
+		out := make([]uint8, len(s))
+		for i := range s {
+			out[i] = iupacBases[s[i]]
+		}
+		return out
+	}
+	p := newPrimerPattern("p", sets("ACGTRY"))
+	r := make([]uint64, 3)
+	cases := []struct {
+		window     string
+		end, mism  int
+		ok         bool
+		preferLast bool
+	}{
+		{"TTACGTAC", 8, 0, true, false},
+		{"TTACGTGT", 8, 0, true, false},
+		{"TTCCGTAC", 8, 1, true, false},
+		{"TTCCCTAC", 8, 2, true, false},
+		{"TTCCCAAC", 0, 0, false, false},
+		{"ACGTAC", 6, 0, true, false},
+		{"ACGTA", 0, 0, false, false},
+		// The exact match wins over the earlier one with a mismatch.
+		{"ACGAACACGTAC", 12, 0, true, false},
+		{"ACGTACACGTAC", 6, 0, true, false},
+		{"ACGTACACGTAC", 12, 0, true, true},
+	}
+	for _, tc := range cases {
+		end, mism, ok := p.best([]byte(tc.window), 2, r, tc.preferLast)
+		if ok != tc.ok || ok && (end != tc.end || mism != tc.mism) {
+			t.Errorf("%s: end %d, %d mismatches, %v; want %d, %d, %v", tc.window, end, mism, ok, tc.end, tc.mism, tc.ok)
+		}
+	}
+}
+
+func TestPrimerTrimmer(t *testing.T) {
+	trimmer, err := loadPrimerTrimmer(writePrimers(t, t.TempDir()), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coi := orfFixtureSeq(t)
+	hcoRC := revComp(primerHCO2198)
+	// Two substitutions in the forward primer.
+	lcoMismatched := "GGTCTACAAATCATAAAGATATAGG"
+	cases := []struct {
+		name       string
+		seq        string
+		want       string
+		start, end bool
+	}{
+		{"no primers", coi, coi, false, false},
+		{"both primers", primerLCO1490 + coi + hcoRC, coi, true, true},
+		{"reverse strand", revComp(primerLCO1490 + coi + hcoRC), revComp(coi), true, true},
+		{"adapter remnants", "ACGTT" + primerLCO1490 + coi + hcoRC + "TTG", coi, true, true},
+		{"mismatches", lcoMismatched + coi, coi, true, false},
+		{"primer past the window", strings.Repeat("C", 20) + primerLCO1490 + coi, strings.Repeat("C", 20) + primerLCO1490 + coi, false, false},
+		{"primer only", primerLCO1490, "", true, true},
+	}
+	for _, tc := range cases {
+		out, start, end := trimmer.trim([]byte(tc.seq))
+		if string(out) != tc.want || start != tc.start || end != tc.end {
+			t.Errorf("%s: %d bases, start %v, end %v; want %d bases, %v, %v", tc.name, len(out), start, end, len(tc.want), tc.start, tc.end)
+		}
+	}
+
+	dir := t.TempDir()
+	for name, primers := range map[string]string{
+		"too long":  ">p\n" + strings.Repeat("A", primerWindow+1) + "\n",
+		"too short": ">p\nAC\n",
+		"invalid":   ">p\nACGTX\n",
+		"empty":     "",
+	} {
+		path := filepath.Join(dir, "primers.fasta")
+		if err := os.WriteFile(path, []byte(primers), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadPrimerTrimmer(path, 2); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
+
+func TestQCTrimPrimers(t *testing.T) {
+	dir := t.TempDir()
+	coi := orfFixtureSeq(t)
+	input := filepath.Join(dir, "in.fasta")
+	fasta := ">P1\n" + primerLCO1490 + coi + revComp(primerHCO2198) + "\n" +
+		">P2\n" + coi[:400] + revComp(primerHCO2198) + "\n" +
+		">P3\n" + coi[100:] + "\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.fasta")
+	report := filepath.Join(dir, "report.json")
+	for _, policy := range []string{dedupeFirst, dedupeLongest} {
+		cfg := qcConfig{
+			NoTaxonomy: true, MaxN: -1, MaxAmbig: -1, DedupeIDs: true, DedupePolicy: policy, OutputPath: out,
+			TrimPrimers: writePrimers(t, dir), PrimerMismatches: 2, PrimerRequired: true, ReportPath: report,
+		}
+		stats, err := runQCFasta(context.Background(), input, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != ">P1\n"+coi+"\n>P2\n"+coi[:400]+"\n" {
+			t.Fatalf("%s: output %q", policy, got)
+		}
+		if stats.TrimmedStart != 1 || stats.TrimmedEnd != 2 || stats.Dropped["no_primer"] != 1 {
+			t.Fatalf("%s: stats %+v", policy, stats)
+		}
+		if err := finishQC(cfg, stats); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded qcReport
+		if err := decodeReport("qc-report", data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.TrimPrimers || decoded.TrimmedStart != 1 || decoded.TrimmedEnd != 2 || decoded.Dropped["no_primer"] != 1 {
+			t.Fatalf("%s: report %s", policy, data)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// rankRule is one -require-ranks entry. A lineage meets it when it names
+// any of ranks ("phylum|division"). An optional rule ("?tribe") is reported
+// but never drops a record.
+type rankRule struct {
+	ranks    []string
+	optional bool
+}
+
+// String returns the rule as written, without the optional prefix.
+func (r rankRule) String() string {
+	return strings.Join(r.ranks, "|")
+}
+
+func (r rankRule) met(lineage taxLineage) bool {
+	for _, rank := range r.ranks {
+		if lineage.get(rank) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// rankRules are the parsed -require-ranks entries, in order.
+type rankRules []rankRule
+
+// parseRankRules parses -require-ranks entries: a rank, alternatives joined
+// by '|', either prefixed with '?' to make the rule optional. Empty entries
+// are skipped.
+func parseRankRules(specs []string) (rankRules, error) {
+	var rules rankRules
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		rule := rankRule{}
+		if rest, ok := strings.CutPrefix(spec, "?"); ok {
+			rule.optional, spec = true, rest
+		}
+		for _, rank := range strings.Split(spec, "|") {
+			rank = strings.TrimSpace(rank)
+			switch {
+			case rank == "":
+				return nil, fmt.Errorf("rank rule %q has an empty rank", spec)
+			case strings.HasPrefix(rank, "?"):
+				return nil, fmt.Errorf("rank rule %q: '?' must prefix the whole rule", spec)
+			}
+			rule.ranks = append(rule.ranks, rank)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// firstFailed returns the index of the first required rule lineage does not
+// meet, or -1 when it meets them all.
+func (rules rankRules) firstFailed(lineage taxLineage) int {
+	for i, r := range rules {
+		if !r.optional && !r.met(lineage) {
+			return i
+		}
+	}
+	return -1
+}
+
+// required reports whether any rule can drop a record.
+func (rules rankRules) required() bool {
+	return slices.ContainsFunc(rules, func(r rankRule) bool { return !r.optional })
+}
+
+// ranks returns every rank the rules name, in order, once each.
+func (rules rankRules) ranks() []string {
+	var out []string
+	for _, r := range rules {
+		for _, rank := range r.ranks {
+			if !slices.Contains(out, rank) {
+				out = append(out, rank)
+			}
+		}
+	}
+	return out
+}
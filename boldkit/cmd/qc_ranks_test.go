@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRankRules(t *testing.T) {
+	rules, err := parseRankRules([]string{"kingdom", " phylum | division ", "", "?tribe", "?subfamily|tribe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rankRules{
+		{ranks: []string{"kingdom"}},
+		{ranks: []string{"phylum", "division"}},
+		{ranks: []string{"tribe"}, optional: true},
+		{ranks: []string{"subfamily", "tribe"}, optional: true},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("rules = %+v, want %+v", rules, want)
+	}
+	if got := strings.Join(rules.ranks(), ","); got != "kingdom,phylum,division,tribe,subfamily" {
+		t.Fatalf("ranks = %s", got)
+	}
+	if rules[1].String() != "phylum|division" || rules[3].String() != "subfamily|tribe" {
+		t.Fatalf("strings %q %q", rules[1], rules[3])
+	}
+	for _, spec := range []string{"?", "phylum|", "|division", "phylum||division", "phylum|?division", "??tribe"} {
+		if _, err := parseRankRules([]string{spec}); err == nil {
+			t.Fatalf("%q parsed", spec)
+		}
+	}
+}
+
+// lineageFromMap builds the taxLineage of a one-node-per-rank taxdump with
+// the names of m; empty names are left out, as loadNames leaves them.
+func lineageFromMap(m map[string]string) taxLineage {
+	dump := &taxDump{}
+	var names strings.Builder
+	l := taxLineage{dump: dump}
+	for rank, name := range m {
+		if name == "" {
+			continue
+		}
+		i := l.spans.n
+		dump.ranks = append(dump.ranks, rank)
+		dump.lineageRanks = append(dump.lineageRanks, rank)
+		l.spans.rank[i], l.spans.off[i], l.spans.len[i] = taxRank(i), uint32(names.Len()), uint16(len(name))
+		l.spans.n++
+		names.WriteString(name)
+	}
+	dump.names = names.String()
+	return l
+}
+
+func TestRankRulesFirstFailed(t *testing.T) {
+	rules, err := parseRankRules([]string{"kingdom", "phylum|division", "?tribe", "species"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		lineage map[string]string
+		want    int
+	}{
+		{"all", map[string]string{"kingdom": "k", "phylum": "p", "tribe": "t", "species": "s"}, -1},
+		{"alternative", map[string]string{"kingdom": "k", "division": "d", "species": "s"}, -1},
+		{"optional missing", map[string]string{"kingdom": "k", "phylum": "p", "species": "s"}, -1},
+		{"plain missing", map[string]string{"phylum": "p", "species": "s"}, 0},
+		{"no alternative", map[string]string{"kingdom": "k", "class": "c", "species": "s"}, 1},
+		{"first failure wins", map[string]string{"kingdom": "k"}, 1},
+		{"empty name", map[string]string{"kingdom": "k", "phylum": "", "division": "", "species": "s"}, 1},
+		{"no lineage", nil, 0},
+	}
+	for _, tc := range cases {
+		if got := rules.firstFailed(lineageFromMap(tc.lineage)); got != tc.want {
+			t.Errorf("%s: firstFailed = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+	if !rules.required() {
+		t.Fatal("required() = false")
+	}
+	optional, _ := parseRankRules([]string{"?tribe", "?genus|species"})
+	if optional.required() || optional.firstFailed(taxLineage{}) != -1 {
+		t.Fatal("optional rules drop records")
+	}
+}
+
+func TestQCRankRules(t *testing.T) {
+	tmp := t.TempDir()
+	writeTaxdumpFixture(t, tmp)
+	// P1 is Canis lupus; P3 is Felidae, which has no genus or species.
+	input := filepath.Join(tmp, "in.fasta")
+	if err := os.WriteFile(input, []byte(">P1\nACGTACGTAC\n>P3\nCCGGTTAACC\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(ranks string) qcStats {
+		t.Helper()
+		cfg := qcConfig{MaxN: -1, MaxAmbig: -1, TaxdumpDir: tmp, RequireRanks: splitList(ranks), OutputPath: filepath.Join(tmp, "out.fasta")}
+		stats, err := runQCFasta(context.Background(), input, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	stats := run("kingdom,family,?genus|species")
+	if stats.Written != 2 || stats.Dropped["missing_ranks"] != 0 {
+		t.Fatalf("optional rule dropped records: %+v", stats)
+	}
+	if want := map[string]int{"genus|species": 1}; !reflect.DeepEqual(stats.MissingOptionalRanks, want) {
+		t.Fatalf("missing optional = %v, want %v", stats.MissingOptionalRanks, want)
+	}
+	if stats.ByRank["genus"] == nil || stats.ByRank["species"] == nil {
+		t.Fatalf("breakdown ranks %v", stats.ByRank)
+	}
+
+	stats = run("kingdom,genus|division,species")
+	if want := map[string]int{"kingdom": 0, "genus|division": 1, "species": 0}; stats.Written != 1 || !reflect.DeepEqual(stats.MissingRanksByRule, want) {
+		t.Fatalf("missing by rule = %v, want %v (written %d)", stats.MissingRanksByRule, want, stats.Written)
+	}
+	if stats.MissingOptionalRanks != nil {
+		t.Fatalf("missing optional = %v without optional rules", stats.MissingOptionalRanks)
+	}
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// qcRejects writes the records qc drops, for auditing: a FASTA of the input
+// sequences with "reason=<counter> <detail>" after the ID, and an
+// id/reason/detail table. Reasons are the qc report's drop counters.
+type qcRejects struct {
+	// fastaOut supplies the buffered, .gz-aware output file of the FASTA.
+	fastaOut *tsvWriter
+	fasta    fastaWriter
+	table    *tsvWriter
+}
+
+// openQCRejects creates the rejects outputs of cfg; it returns nil when
+// neither is requested.
+func openQCRejects(cfg qcConfig) (*qcRejects, error) {
+	if cfg.RejectsOutput == "" && cfg.RejectsTSV == "" {
+		return nil, nil
+	}
+	r := &qcRejects{}
+	create := func(path string) (*tsvWriter, error) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("create rejects dir: %w", err)
+		}
+		if err := removeIfExists(path); err != nil {
+			return nil, err
+		}
+		w, err := createTSVWriter(path, tsvWriterOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("create rejects: %w", err)
+		}
+		return w, nil
+	}
+	var err error
+	if cfg.RejectsOutput != "" {
+		if r.fastaOut, err = create(cfg.RejectsOutput); err != nil {
+			return nil, err
+		}
+		r.fasta = newFastaWriter(r.fastaOut.buf, cfg.Wrap)
+	}
+	if cfg.RejectsTSV != "" {
+		if r.table, err = create(cfg.RejectsTSV); err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		if err := r.table.WriteHeader([]string{"id", "reason", "detail"}); err != nil {
+			_ = r.Close()
+			return nil, fmt.Errorf("write rejects: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// write records a record dropped for reason. The detail gives the cleaned
+// length and, for the character count filters, the offending count.
+func (r *qcRejects) write(rec *QCRecord, reason string) error {
+	if r == nil {
+		return nil
+	}
+	detail := "len=" + strconv.Itoa(len(rec.Clean()))
+	n, ambig, invalid := rec.Counts()
+	switch reason {
+	case "too_many_n":
+		detail += " n=" + strconv.Itoa(n)
+	case "too_many_ambig":
+		detail += " ambig=" + strconv.Itoa(ambig)
+	case "too_many_invalid":
+		detail += " invalid=" + strconv.Itoa(invalid)
+	}
+	if r.fastaOut != nil {
+		if err := r.fasta.Write(rec.ID+" reason="+reason+" "+detail, rec.Seq); err != nil {
+			return fmt.Errorf("write rejects: %w", err)
+		}
+	}
+	if r.table != nil {
+		if err := r.table.WriteRowStrings([]string{rec.ID, reason, detail}); err != nil {
+			return fmt.Errorf("write rejects: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes both outputs. Later calls do nothing.
+func (r *qcRejects) Close() error {
+	if r == nil {
+		return nil
+	}
+	var first error
+	for _, w := range []*tsvWriter{r.fastaOut, r.table} {
+		if w == nil {
+			continue
+		}
+		if err := w.Close(); err != nil && first == nil {
+			first = fmt.Errorf("close rejects: %w", err)
+		}
+	}
+	return first
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQCRejectsReconcile(t *testing.T) {
+	tmp := t.TempDir()
+	rejectsFasta := filepath.Join(tmp, "rejects", "rejects.fasta.gz")
+	rejectsTSV := filepath.Join(tmp, "rejects", "rejects.tsv")
+	cfg := qcConfig{
+		MinLen:        5,
+		MaxLen:        15,
+		MaxN:          2,
+		MaxAmbig:      1,
+		DedupeSeqs:    true,
+		DedupeIDs:     true,
+		RequireRanks:  splitList("kingdom,phylum,class,order,family,genus,species"),
+		RejectsOutput: rejectsFasta,
+		RejectsTSV:    rejectsTSV,
+	}
+	var report qcReport
+	if err := decodeReport("qc-report", runQCFixture(t, cfg), &report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	table, err := os.ReadFile(rejectsTSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(table), "\n"), "\n")
+	if lines[0] != "id\treason\tdetail" {
+		t.Fatalf("header %q", lines[0])
+	}
+	tally := make(map[string]int)
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], "len=") {
+			t.Fatalf("row %q", line)
+		}
+		tally[fields[1]]++
+	}
+	for name, n := range report.Dropped {
+		if tally[name] != n {
+			t.Errorf("%s: %d rejects rows, report says %d", name, tally[name], n)
+		}
+		delete(tally, name)
+	}
+	if len(tally) > 0 {
+		t.Errorf("reasons not in the report: %v", tally)
+	}
+	if len(lines)-1 != report.Total-report.Written {
+		t.Errorf("%d rejects rows for %d dropped", len(lines)-1, report.Total-report.Written)
+	}
+
+	in, _, err := openFastaInput(rejectsFasta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	var headers []string
+	err = parseFasta(in, func(rec fastaRecord) error {
+		headers = append(headers, rec.id+" "+rec.desc)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != len(lines)-1 {
+		t.Fatalf("%d rejects records, %d rows", len(headers), len(lines)-1)
+	}
+	for _, want := range []string{"P4 reason=too_short len=3", "P6 reason=too_many_n len=10 n=3", "P7 reason=too_many_ambig len=10 ambig=2"} {
+		found := false
+		for _, h := range headers {
+			found = found || h == want
+		}
+		if !found {
+			t.Errorf("no rejects header %q in %q", want, headers)
+		}
+	}
+}
+
+func TestClassifyQCRejects(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "COI-5P.fasta")
+	if err := os.WriteFile(input, []byte(">P1\nACGTACGTAC\n>P2\nACGTACGTAC\n>P3\nACG\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(tmp, "out")
+	err := classifyOne(context.Background(), input, outDir, []string{"blast"}, nil, filepath.Join(tmp, "no-taxdump"), "", "", "", 5, 100, 0, 0, 0, true, true, false, false, true, true, false, false, false, true, sanitizeTranslit, "COI-5P", "", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, "qc", "COI-5P.rejects.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id\treason\tdetail\nP2\tduplicate_sequence\tlen=10\nP3\ttoo_short\tlen=3\n"; string(got) != want {
+		t.Fatalf("rejects.tsv = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "qc", "COI-5P.rejects.fasta")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// qc -dedupe-policy values.
+const (
+	dedupeFirst    = "first"
+	dedupeLongest  = "longest"
+	dedupePerTaxon = "per-taxon"
+)
+
+var dedupePolicies = []string{dedupeFirst, dedupeLongest, dedupePerTaxon}
+
+// qcSelector applies the longest and per-taxon dedupe policies, where a
+// later record can displace one already accepted, and -min-per-taxon, where
+// a record is only kept once its taxon has enough records. Records that
+// pass the filter chain are offered in input order. Only the length and
+// number of each group's current winners stay in memory; the records
+// themselves are spilled to a scratch file, which replay reads back in
+// input order once the winners and taxon counts are settled. The input is
+// read once, so gzip and standard input need no second pass.
+type qcSelector struct {
+	policy      string
+	maxPerTaxon int
+	byID        map[string]qcCandidate
+	byTaxon     map[int]*qcCandidateHeap
+	displaced   []uint64 // bitset over candidate numbers
+	n           int
+	quota       *taxonQuota // nil without -min-per-taxon
+	idsOnly     bool        // a dry run without rejects never reads the records back
+
+	file *os.File
+	w    *bufio.Writer
+	buf  []byte
+}
+
+// qcCandidate is an accepted record: its candidate number and cleaned
+// length.
+type qcCandidate struct {
+	n      int
+	length int
+}
+
+// better reports whether c displaces o: it is strictly longer, so ties keep
+// the earlier record.
+func (c qcCandidate) better(o qcCandidate) bool {
+	return c.length > o.length
+}
+
+// newQCSelector returns the selector of cfg.DedupePolicy, or nil under the
+// first policy, which the duplicate filters apply as they stream, unless
+// -min-per-taxon is set.
+func newQCSelector(env *QCFilterEnv) (*qcSelector, error) {
+	cfg := env.cfg
+	if cfg.DedupePolicy != dedupeLongest && cfg.DedupePolicy != dedupePerTaxon && cfg.MinPerTaxon == 0 {
+		return nil, nil
+	}
+	dir, err := env.scratch()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "select-*.bin")
+	if err != nil {
+		return nil, fmt.Errorf("create dedupe candidates: %w", err)
+	}
+	return &qcSelector{
+		policy:      cfg.DedupePolicy,
+		maxPerTaxon: cfg.MaxPerTaxon,
+		byID:        make(map[string]qcCandidate),
+		byTaxon:     make(map[int]*qcCandidateHeap),
+		quota:       newTaxonQuota(env),
+		idsOnly:     cfg.DryRun && cfg.RejectsOutput == "" && cfg.RejectsTSV == "",
+		file:        f,
+		w:           bufio.NewWriterSize(f, writerBufferSize),
+	}, nil
+}
+
+// counters are the drop counters the selector adds to the report.
+func (s *qcSelector) counters() []string {
+	var counters []string
+	switch s.policy {
+	case dedupeLongest:
+		counters = []string{"displaced"}
+	case dedupePerTaxon:
+		counters = []string{"displaced", "taxon_limit"}
+	}
+	if s.quota != nil {
+		counters = append(counters, "below_min_per_taxon")
+	}
+	return counters
+}
+
+// offer considers rec, written as header when it wins. It returns the
+// counter rec is dropped under, or "" when it is accepted, and whether
+// accepting it displaced an earlier record.
+func (s *qcSelector) offer(rec *QCRecord, header string) (reason string, displaced bool, err error) {
+	cand := qcCandidate{n: s.n, length: len(rec.Clean())}
+	switch s.policy {
+	case dedupeLongest:
+		best, ok := s.byID[rec.ID]
+		if ok && !cand.better(best) {
+			return "duplicate_id", false, nil
+		}
+		if ok {
+			s.displace(best.n)
+			displaced = true
+		}
+		s.byID[rec.ID] = cand
+	case dedupePerTaxon:
+		taxid, _ := rec.TaxID()
+		h := s.byTaxon[taxid]
+		if h == nil {
+			h = &qcCandidateHeap{}
+			s.byTaxon[taxid] = h
+		}
+		switch {
+		case h.Len() < s.maxPerTaxon:
+			heap.Push(h, cand)
+		case cand.better((*h)[0]):
+			s.displace((*h)[0].n)
+			displaced = true
+			(*h)[0] = cand
+			heap.Fix(h, 0)
+		default:
+			return "taxon_limit", false, nil
+		}
+	}
+	// A displaced record shares rec's ID or taxid, and so its taxon: the
+	// count only grows when rec adds a record.
+	if !displaced {
+		s.quota.count(rec.ID)
+	}
+	return "", displaced, s.spill(rec, header)
+}
+
+func (s *qcSelector) displace(n int) {
+	s.displaced[n/64] |= 1 << (n % 64)
+}
+
+func (s *qcSelector) isDisplaced(n int) bool {
+	return s.displaced[n/64]&(1<<(n%64)) != 0
+}
+
+// spill appends the candidate: its seqEdits byte and uvarint trimmed bases,
+// then the ID, header, and cleaned sequence, each after its uvarint length.
+// With idsOnly the header and sequence are spilled empty.
+func (s *qcSelector) spill(rec *QCRecord, header string) error {
+	if s.n%64 == 0 {
+		s.displaced = append(s.displaced, 0)
+	}
+	s.n++
+	clean := rec.Clean()
+	if s.idsOnly {
+		header, clean = "", nil
+	}
+	buf := append(s.buf[:0], byte(rec.edits()))
+	buf = binary.AppendUvarint(buf, uint64(rec.TrimmedBases()))
+	buf = binary.AppendUvarint(buf, uint64(len(rec.ID)))
+	buf = append(buf, rec.ID...)
+	buf = binary.AppendUvarint(buf, uint64(len(header)))
+	buf = append(buf, header...)
+	buf = binary.AppendUvarint(buf, uint64(len(clean)))
+	buf = append(buf, clean...)
+	s.buf = buf
+	if _, err := s.w.Write(buf); err != nil {
+		return fmt.Errorf("spill dedupe candidate: %w", err)
+	}
+	return nil
+}
+
+// replay calls fn with each candidate in input order and the counter it is
+// dropped under: "displaced", "below_min_per_taxon", or "" when it is kept.
+// seq is only valid during the call.
+func (s *qcSelector) replay(fn func(id, header string, seq []byte, edits seqEdits, trimmed int, reason string) error) error {
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("spill dedupe candidate: %w", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("read dedupe candidates: %w", err)
+	}
+	r := bufio.NewReaderSize(s.file, writerBufferSize)
+	var field []byte
+	read := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(cap(field)) < n {
+			field = make([]byte, n)
+		}
+		field = field[:n]
+		_, err = io.ReadFull(r, field)
+		return field, err
+	}
+	for i := 0; i < s.n; i++ {
+		edits, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("read dedupe candidates: %w", err)
+		}
+		trimmed, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("read dedupe candidates: %w", err)
+		}
+		id, err := read()
+		if err != nil {
+			return fmt.Errorf("read dedupe candidates: %w", err)
+		}
+		idStr := string(id)
+		header, err := read()
+		if err != nil {
+			return fmt.Errorf("read dedupe candidates: %w", err)
+		}
+		headerStr := string(header)
+		seq, err := read()
+		if err != nil {
+			return fmt.Errorf("read dedupe candidates: %w", err)
+		}
+		var reason string
+		switch {
+		case s.isDisplaced(i):
+			reason = "displaced"
+		case !s.quota.met(idStr):
+			reason = "below_min_per_taxon"
+		}
+		if err := fn(idStr, headerStr, seq, seqEdits(edits), int(trimmed), reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close removes the candidate file. Later calls do nothing.
+func (s *qcSelector) close() error {
+	if s == nil || s.file == nil {
+		return nil
+	}
+	_ = s.file.Close()
+	err := os.Remove(s.file.Name())
+	s.file = nil
+	return err
+}
+
+// taxonQuota counts the records kept per taxon for -min-per-taxon: per
+// taxid, or per name at cfg.MinPerRank. Records with no name at that rank
+// are not counted and always meet the quota.
+type taxonQuota struct {
+	env    *QCFilterEnv
+	min    int
+	rank   string
+	counts map[string]int
+}
+
+// newTaxonQuota returns the quota of env's -min-per-taxon, or nil when it
+// is off.
+func newTaxonQuota(env *QCFilterEnv) *taxonQuota {
+	if env.cfg.MinPerTaxon == 0 {
+		return nil
+	}
+	return &taxonQuota{env: env, min: env.cfg.MinPerTaxon, rank: env.cfg.MinPerRank, counts: make(map[string]int)}
+}
+
+// taxon returns the taxon the record with id counts toward, or "" when it
+// has none.
+func (q *taxonQuota) taxon(id string) string {
+	if q.rank == "" {
+		if taxid := q.env.taxidOf(id); taxid != 0 {
+			return strconv.Itoa(taxid)
+		}
+		return ""
+	}
+	return q.env.lineageOf(id).get(q.rank)
+}
+
+func (q *taxonQuota) count(id string) {
+	if q == nil {
+		return
+	}
+	if taxon := q.taxon(id); taxon != "" {
+		q.counts[taxon]++
+	}
+}
+
+// met reports whether the taxon of the record with id reached the minimum.
+// It is only meaningful once every record is counted.
+func (q *taxonQuota) met(id string) bool {
+	if q == nil {
+		return true
+	}
+	taxon := q.taxon(id)
+	return taxon == "" || q.counts[taxon] >= q.min
+}
+
+// qcCandidateHeap is a min-heap with the candidate to displace next on top:
+// the shortest, and among equally short the latest.
+type qcCandidateHeap []qcCandidate
+
+func (h qcCandidateHeap) Len() int { return len(h) }
+func (h qcCandidateHeap) Less(i, j int) bool {
+	if h[i].length != h[j].length {
+		return h[i].length < h[j].length
+	}
+	return h[i].n > h[j].n
+}
+func (h qcCandidateHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *qcCandidateHeap) Push(x any)   { *h = append(*h, x.(qcCandidate)) }
+func (h *qcCandidateHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQCDedupePolicies(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fasta")
+	fasta := strings.Join([]string{
+		">A1", "ACGTAC",
+		">B1", "CCCCCCCC",
+		">A1", "ACGTACGTAC", // longer: displaces the first A1
+		">A1", "TTTTTTTTTT", // as long, not longer
+		">B2", "GGGGGGGGGGGG",
+		">B3", "ACACACACAC",
+		">B4", "TGTGTGTGTGTGTG",
+		">C1", "AAAAA",
+		">B5", "ACA",
+	}, "\n") + "\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	taxidMap := filepath.Join(dir, "taxid.map")
+	if err := os.WriteFile(taxidMap, []byte("A1\t10\nB1\t20\nB2\t20\nB3\t20\nB4\t20\nB5\t20\nC1\t30\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(cfg qcConfig) (qcStats, string, string) {
+		t.Helper()
+		cfg.MaxN, cfg.MaxAmbig = -1, -1
+		cfg.DedupeSeqs, cfg.DedupeIDs = true, true
+		cfg.OutputPath = filepath.Join(dir, "out", cfg.DedupePolicy+".fasta")
+		cfg.RejectsTSV = filepath.Join(dir, "out", cfg.DedupePolicy+".rejects.tsv")
+		stats, err := runQCFasta(context.Background(), input, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(cfg.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rejects, err := os.ReadFile(cfg.RejectsTSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries, _ := os.ReadDir(filepath.Join(dir, "out")); len(entries) != 2 {
+			t.Fatalf("%s: scratch left behind: %v", cfg.DedupePolicy, entries)
+		}
+		for _, name := range []string{cfg.OutputPath, cfg.RejectsTSV} {
+			_ = os.Remove(name)
+		}
+		return stats, string(out), string(rejects)
+	}
+	ids := func(fasta string) string {
+		var out []string
+		for _, line := range strings.Split(fasta, "\n") {
+			if strings.HasPrefix(line, ">") {
+				out = append(out, line[1:])
+			}
+		}
+		return strings.Join(out, ",")
+	}
+
+	stats, out, _ := run(qcConfig{NoTaxonomy: true, DedupePolicy: dedupeFirst})
+	if got := ids(out); got != "A1,B1,B2,B3,B4,C1,B5" || stats.Dropped["duplicate_id"] != 2 {
+		t.Fatalf("first: %s %+v", got, stats)
+	}
+
+	stats, out, rejects := run(qcConfig{NoTaxonomy: true, DedupePolicy: dedupeLongest})
+	if got := ids(out); got != "B1,A1,B2,B3,B4,C1,B5" || !strings.Contains(out, ">A1\nACGTACGTAC\n") {
+		t.Fatalf("longest output:\n%s", out)
+	}
+	if stats.Written != 7 || stats.Dropped["displaced"] != 1 || stats.Dropped["duplicate_id"] != 1 {
+		t.Fatalf("longest: %+v", stats)
+	}
+	if !strings.Contains(rejects, "A1\tduplicate_id\tlen=10\n") || !strings.Contains(rejects, "A1\tdisplaced\tlen=6\n") {
+		t.Fatalf("longest rejects:\n%s", rejects)
+	}
+
+	// Taxon 20 keeps its two longest: B3 displaces B1, B4 displaces B3,
+	// and B5 is shorter than both.
+	stats, out, rejects = run(qcConfig{TaxidMapPath: taxidMap, DedupePolicy: dedupePerTaxon, MaxPerTaxon: 2})
+	if got := ids(out); got != "A1,B2,B4,C1" {
+		t.Fatalf("per-taxon output: %s", got)
+	}
+	if stats.Dropped["displaced"] != 2 || stats.Dropped["taxon_limit"] != 1 || stats.Dropped["duplicate_id"] != 2 {
+		t.Fatalf("per-taxon: %+v", stats)
+	}
+	if !strings.Contains(rejects, "B1\tdisplaced\tlen=8\n") || !strings.Contains(rejects, "B3\tdisplaced\tlen=10\n") || !strings.Contains(rejects, "B5\ttaxon_limit\tlen=3\n") {
+		t.Fatalf("per-taxon rejects:\n%s", rejects)
+	}
+	dropped := 0
+	for _, n := range stats.Dropped {
+		dropped += n
+	}
+	if stats.Total != stats.Written+dropped {
+		t.Fatalf("per-taxon: total %d, written %d, dropped %d", stats.Total, stats.Written, dropped)
+	}
+}
+
+func TestQCMinPerTaxon(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fasta")
+	fasta := strings.Join([]string{
+		">A1", "ACGTAC",
+		">B1", "CCCCCCCC",
+		">C1", "AAAAA",
+		">B2", "GGGGGGGG",
+		">C2", "ACGTAC", // duplicate sequence: taxon 30 keeps one record
+		">D1", "TTTT",
+		">D1", "TTTTTTTT", // duplicate ID: taxon 40 keeps one record
+	}, "\n") + "\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	taxidMap := filepath.Join(dir, "taxid.map")
+	if err := os.WriteFile(taxidMap, []byte("A1\t10\nB1\t20\nB2\t20\nC1\t30\nC2\t30\nD1\t40\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []string{dedupeFirst, dedupeLongest} {
+		cfg := qcConfig{MaxN: -1, MaxAmbig: -1, DedupeSeqs: true, DedupeIDs: true, DedupePolicy: policy, TaxidMapPath: taxidMap, MinPerTaxon: 2}
+		cfg.OutputPath = filepath.Join(dir, policy, "out.fasta")
+		cfg.RejectsTSV = filepath.Join(dir, policy, "rejects.tsv")
+		stats, err := runQCFasta(context.Background(), input, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(cfg.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != ">B1\nCCCCCCCC\n>B2\nGGGGGGGG\n" {
+			t.Fatalf("%s output:\n%s", policy, out)
+		}
+		if stats.Dropped["below_min_per_taxon"] != 3 || stats.Dropped["duplicate_sequence"] != 1 {
+			t.Fatalf("%s: %+v", policy, stats)
+		}
+		dropped := 0
+		for _, n := range stats.Dropped {
+			dropped += n
+		}
+		if stats.Total != stats.Written+dropped {
+			t.Fatalf("%s: total %d, written %d, dropped %d", policy, stats.Total, stats.Written, dropped)
+		}
+		rejects, err := os.ReadFile(cfg.RejectsTSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The D1 left after dedupe is the first under first and the longer
+		// under longest.
+		d1 := map[string]string{dedupeFirst: "len=4", dedupeLongest: "len=8"}[policy]
+		if !strings.Contains(string(rejects), "C1\tbelow_min_per_taxon\t") || !strings.Contains(string(rejects), "D1\tbelow_min_per_taxon\t"+d1+"\n") {
+			t.Fatalf("%s rejects:\n%s", policy, rejects)
+		}
+	}
+}
+
+func TestQCMinPerRank(t *testing.T) {
+	tmp := t.TempDir()
+	writeTaxdumpFixture(t, tmp)
+	input := filepath.Join(tmp, "in.fasta")
+	if err := os.WriteFile(input, []byte(">P1\nACGTACGTAC\n>P3\nCCGGTTAACC\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// P3 (Felidae) has no species, so only a family count can drop it.
+	for rank, want := range map[string]string{"species": ">P3\n", "family": ""} {
+		cfg := qcConfig{MaxN: -1, MaxAmbig: -1, TaxdumpDir: tmp, MinPerTaxon: 2, MinPerRank: rank}
+		cfg.OutputPath = filepath.Join(tmp, rank+".fasta")
+		stats, err := runQCFasta(context.Background(), input, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(cfg.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var headers string
+		for _, line := range strings.SplitAfter(string(out), "\n") {
+			if strings.HasPrefix(line, ">") {
+				headers += line
+			}
+		}
+		if headers != want {
+			t.Fatalf("%s output:\n%s", rank, out)
+		}
+		if stats.ByRank[rank] == nil || stats.ByRank[rank]["Canidae"] == nil && stats.ByRank[rank]["Canis lupus"] == nil {
+			t.Fatalf("%s: no breakdown at %s: %+v", rank, rank, stats.ByRank)
+		}
+	}
+}
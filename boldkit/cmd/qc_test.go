@@ -0,0 +1,503 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite golden qc reports")
+
+// writeTaxdumpFixture writes a tiny taxdump: taxid 7 (Canis lupus) has a full
+// lineage, taxid 8 (Felidae) stops at family.
+func writeTaxdumpFixture(t *testing.T, dir string) {
+	t.Helper()
+	nodes := []string{
+		"1\t|\t1\t|\tno rank\t|",
+		"2\t|\t1\t|\tkingdom\t|",
+		"3\t|\t2\t|\tphylum\t|",
+		"4\t|\t3\t|\tclass\t|",
+		"5\t|\t4\t|\torder\t|",
+		"6\t|\t5\t|\tfamily\t|",
+		"9\t|\t6\t|\tgenus\t|",
+		"7\t|\t9\t|\tspecies\t|",
+		"8\t|\t5\t|\tfamily\t|",
+	}
+	names := []string{
+		"1\t|\troot\t|\t\t|\tscientific name\t|",
+		"2\t|\tAnimalia\t|\t\t|\tscientific name\t|",
+		"3\t|\tChordata\t|\t\t|\tscientific name\t|",
+		"4\t|\tMammalia\t|\t\t|\tscientific name\t|",
+		"5\t|\tCarnivora\t|\t\t|\tscientific name\t|",
+		"6\t|\tCanidae\t|\t\t|\tscientific name\t|",
+		"9\t|\tCanis\t|\t\t|\tscientific name\t|",
+		"7\t|\tCanis lupus\t|\t\t|\tscientific name\t|",
+		"8\t|\tFelidae\t|\t\t|\tscientific name\t|",
+	}
+	taxid := []string{"P1\t7", "P2\t7", "P3\t8", "P4\t7", "P5\t7", "P6\t7", "P7\t7", "P8\t7", "P9\t7"}
+	files := map[string][]string{"nodes.dmp": nodes, "names.dmp": names, "taxid.map": taxid}
+	for name, lines := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+const qcFixtureFasta = `>P1
+ACGTACGTAC
+>P1
+ACGTACGTAA
+>P2
+ACGTACGTAC
+>P3
+ACGTACGTAC
+>PX
+ACGTACGTAC
+>P4
+ACG
+>P5
+ACGTACGTACGTACGTACGT
+>P6
+ACGTNNNACGTAC
+>P7
+ACGTRYACGTAC
+>P8
+ACGT!!ACGTAC
+>P9
+ccggttaacc
+`
+
+func runQCFixture(t *testing.T, cfg qcConfig) []byte {
+	t.Helper()
+	tmp := t.TempDir()
+	writeTaxdumpFixture(t, tmp)
+	input := filepath.Join(tmp, "in.fasta")
+	if err := os.WriteFile(input, []byte(qcFixtureFasta), 0o644); err != nil {
+		t.Fatalf("write fasta: %v", err)
+	}
+	cfg.TaxdumpDir = tmp
+	cfg.OutputPath = filepath.Join(tmp, "out.fasta")
+	cfg.ReportPath = filepath.Join(tmp, "report.json")
+	if err := qcFasta(context.Background(), input, cfg); err != nil {
+		t.Fatalf("qcFasta: %v", err)
+	}
+	report, err := os.ReadFile(cfg.ReportPath)
+	if err != nil {
+		t.Fatalf("read report: %v", err)
+	}
+	return report
+}
+
+func TestQCGoldenReports(t *testing.T) {
+	defaults := qcConfig{
+		MaxN:         -1,
+		MaxAmbig:     -1,
+		DedupeSeqs:   true,
+		DedupeIDs:    true,
+		RequireRanks: splitList("kingdom,phylum,class,order,family,genus,species"),
+	}
+	strict := defaults
+	strict.MinLen = 5
+	strict.MaxLen = 15
+	strict.MaxN = 2
+	strict.MaxAmbig = 1
+	noTaxonomy := defaults
+	noTaxonomy.NoTaxonomy = true
+	canonical := defaults
+	canonical.OrientationMode = orientationCanonical
+	cases := []struct {
+		name string
+		cfg  qcConfig
+	}{
+		{name: "defaults", cfg: defaults},
+		{name: "strict", cfg: strict},
+		{name: "no-taxonomy", cfg: noTaxonomy},
+		{name: "canonical", cfg: canonical},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := runQCFixture(t, tc.cfg)
+			path := filepath.Join("testdata", "qc", tc.name+".report.json")
+			if *updateGolden {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatalf("write golden: %v", err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("report mismatch\n got: %s\nwant: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestOrderedQCFiltersRejectsUnknown(t *testing.T) {
+	cases := []struct {
+		name  string
+		order []string
+		want  string
+	}{
+		{name: "unknown", order: []string{"gc"}, want: `unknown qc filter "gc"`},
+		{name: "duplicate", order: []string{"length", "length"}, want: "listed twice"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := orderedQCFilters(tc.order)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err=%v want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestQCFilterOrder(t *testing.T) {
+	entries, err := orderedQCFilters([]string{"duplicate_sequence", "length"})
+	if err != nil {
+		t.Fatalf("orderedQCFilters: %v", err)
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.name
+	}
+	got := strings.Join(names, ",")
+	want := "duplicate_sequence,length,id,header,id_list,duplicate_id,taxid,ranks,n,ambig,invalid,primer,complexity,orf,split_taxonomy"
+	if got != want {
+		t.Fatalf("order=%s want %s", got, want)
+	}
+
+	// Deduping first keeps the first copy of each sequence, even the one the
+	// length filter would have dropped.
+	cfg := qcConfig{
+		MinLen:       5,
+		MaxN:         -1,
+		MaxAmbig:     -1,
+		DedupeSeqs:   true,
+		RequireRanks: splitList("kingdom,phylum,class,order,family,genus,species"),
+		FilterOrder:  []string{"duplicate_sequence"},
+	}
+	var report qcReport
+	if err := decodeReport("qc-report", runQCFixture(t, cfg), &report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Total != 11 {
+		t.Fatalf("total=%d", report.Total)
+	}
+	if report.Dropped["duplicate_sequence"] == 0 {
+		t.Fatalf("duplicate_sequence should run first: %+v", report.Dropped)
+	}
+}
+
+func TestRegisterQCFilter(t *testing.T) {
+	qcFilterMu.Lock()
+	saved := append([]qcFilterEntry(nil), qcFilterRegistry...)
+	qcFilterMu.Unlock()
+	t.Cleanup(func() {
+		qcFilterMu.Lock()
+		qcFilterRegistry = saved
+		qcFilterMu.Unlock()
+	})
+
+	err := RegisterQCFilter("no_cc", func(env *QCFilterEnv) (QCFilter, error) {
+		return qcFunc{
+			name:     "no_cc",
+			counters: []string{"has_cc"},
+			check: func(rec *QCRecord) (QCVerdict, string) {
+				if bytes.Contains(rec.Clean(), []byte("CC")) {
+					return qcDrop("has_cc")
+				}
+				return QCPass, ""
+			},
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := RegisterQCFilter("no_cc", nil); err == nil {
+		t.Fatal("expected duplicate registration error")
+	}
+
+	cfg := qcConfig{
+		MaxN:         -1,
+		MaxAmbig:     -1,
+		RequireRanks: splitList("kingdom,phylum,class,order,family,genus,species"),
+	}
+	data := runQCFixture(t, cfg)
+	var report qcReport
+	if err := decodeReport("qc-report", data, &report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Dropped["has_cc"] != 1 {
+		t.Fatalf("has_cc=%d\n%s", report.Dropped["has_cc"], data)
+	}
+	if !bytes.Contains(data, []byte("\"duplicate_id\": 0,\n  \"has_cc\": 1,\n  \"missing_ranks_by_rule\": {")) {
+		t.Fatalf("custom counter should follow built-in counters:\n%s", data)
+	}
+}
+
+func TestLoadTaxidMapMaxErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "taxid.map")
+	if err := os.WriteFile(path, []byte("P1\t7\nP2\nP3\t8\nP4\tx\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var rowErrs *RowErrors
+	if m, err := loadTaxidMap(path, 0, -1); !errors.As(err, &rowErrs) || rowErrs.Exceeded || len(m) != 2 {
+		t.Fatalf("skip all: m=%v err=%v", m, err)
+	}
+	m, err := loadTaxidMap(path, 0, 2)
+	if !errors.As(err, &rowErrs) || rowErrs.Exceeded || len(m) != 2 {
+		t.Fatalf("within limit: m=%v err=%v", m, err)
+	}
+	if got := err.Error(); got != `taxid.map: 2 malformed lines skipped: line 2: missing taxid column; line 4: invalid taxid "x"` {
+		t.Fatalf("message %q", got)
+	}
+	if _, err := loadTaxidMap(path, 0, 1); !errors.As(err, &rowErrs) || !rowErrs.Exceeded {
+		t.Fatalf("over limit: err=%v", err)
+	}
+}
+
+func TestLoadTaxidMapFormats(t *testing.T) {
+	dir := t.TempDir()
+	// A header, a name column, and the taxid in column 3 of the second map.
+	plain := "processid\ttaxid\tname\nP1\t7\tCanis lupus\nP2\t8\tFelidae\n"
+	moved := "processid\tname\ttaxid\nP1\tCanis lupus\t7\nP2\tFelidae\t8\n"
+	for _, c := range []struct {
+		name, data string
+		column     int
+	}{
+		{"taxid.map", plain, 0},
+		{"taxid.map.gz", plain, 2},
+		{"moved.map", moved, 3},
+	} {
+		path := filepath.Join(dir, c.name)
+		data := []byte(c.data)
+		if strings.HasSuffix(c.name, ".gz") {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			if _, err := zw.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			data = buf.Bytes()
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := loadTaxidMap(path, c.column, 0)
+		if err != nil || len(m) != 2 || m["P1"] != 7 || m["P2"] != 8 {
+			t.Fatalf("%s: m=%v err=%v", c.name, m, err)
+		}
+	}
+	// Only the first line may be a header.
+	path := filepath.Join(dir, "late-header.map")
+	if err := os.WriteFile(path, []byte("P1\t7\nid\ttaxid\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var rowErrs *RowErrors
+	if _, err := loadTaxidMap(path, 0, 0); !errors.As(err, &rowErrs) || rowErrs.Errors[0].Line != 2 {
+		t.Fatalf("late header: %v", err)
+	}
+	// The taxid in the wrong column leaves nothing but a hint.
+	if _, err := loadTaxidMap(filepath.Join(dir, "moved.map"), 0, -1); err == nil || !strings.Contains(err.Error(), "-taxid-map-column") {
+		t.Fatalf("wrong column: %v", err)
+	}
+}
+
+func TestQCHeaderFilters(t *testing.T) {
+	input := ">P1 Lepidoptera|COI-5P\nACGTACGT\n" +
+		">P2\tDiptera\t|COI-5P\nACGTACGA\n" +
+		">P3  Lepidoptera  |  ITS\nACGTACGC\n" +
+		">P4\nACGTACGG\n" +
+		// A duplicate ID with a different description is still a duplicate.
+		">P1 Lepidoptera|COI-5P second copy\nACGTTTTT\n"
+	dir := t.TempDir()
+	path := filepath.Join(dir, "in.fasta")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name             string
+		include, exclude string
+		keepDesc         bool
+		want             string
+		dropped          map[string]int
+	}{
+		{
+			name: "keep desc", keepDesc: true,
+			want:    ">P1 Lepidoptera|COI-5P\nACGTACGT\n>P2 Diptera\t|COI-5P\nACGTACGA\n>P3 Lepidoptera  |  ITS\nACGTACGC\n>P4\nACGTACGG\n",
+			dropped: map[string]int{"duplicate_id": 1},
+		},
+		{
+			name: "include", include: `\|\s*COI-5P`,
+			want:    ">P1\nACGTACGT\n>P2\nACGTACGA\n",
+			dropped: map[string]int{"header_not_included": 2, "duplicate_id": 1},
+		},
+		{
+			name: "exclude", exclude: `^Lepidoptera\b`, keepDesc: true,
+			want:    ">P2 Diptera\t|COI-5P\nACGTACGA\n>P4\nACGTACGG\n",
+			dropped: map[string]int{"header_excluded": 3},
+		},
+	} {
+		out := filepath.Join(dir, tc.name+".fasta")
+		cfg := qcConfig{
+			NoTaxonomy: true, MaxErrors: -1, MaxN: -1, MaxAmbig: -1, DedupeIDs: true,
+			OutputPath: out, KeepDesc: tc.keepDesc, HeaderInclude: tc.include, HeaderExclude: tc.exclude,
+		}
+		stats, err := runQCFasta(context.Background(), path, cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Fatalf("%s: output\n%q\nwant\n%q", tc.name, got, tc.want)
+		}
+		for counter, n := range tc.dropped {
+			if stats.Dropped[counter] != n {
+				t.Fatalf("%s: %s=%d want %d (%v)", tc.name, counter, stats.Dropped[counter], n, stats.Dropped)
+			}
+		}
+	}
+}
+
+func TestQCGzipOutput(t *testing.T) {
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(3))
+	var fasta strings.Builder
+	for i := 0; i < 30000; i++ {
+		seq := make([]byte, 120)
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+		}
+		fmt.Fprintf(&fasta, ">S%d\n%s\n", i, seq)
+	}
+	input := filepath.Join(dir, "in.fasta")
+	if err := os.WriteFile(input, []byte(fasta.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(output string, workers int) []byte {
+		t.Helper()
+		cfg := qcConfig{NoTaxonomy: true, MaxN: -1, MaxAmbig: -1, DedupeSeqs: true, OutputPath: output, Workers: workers}
+		if _, err := runQCFasta(context.Background(), input, cfg); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	plain := run(filepath.Join(dir, "out.fasta"), 1)
+	if len(plain) < 3<<20 {
+		t.Fatalf("output of %d bytes spans too few gzip blocks", len(plain))
+	}
+	// One worker uses compress/gzip, more use pgzip.
+	for _, workers := range []int{1, 4} {
+		compressed := run(filepath.Join(dir, fmt.Sprintf("out%d.fasta.gz", workers)), workers)
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A single member: reading past its end fails on a truncated or
+		// concatenated stream.
+		zr.Multistream(false)
+		got, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("%d workers: gzip output holds %d bytes, want %d", workers, len(got), len(plain))
+		}
+	}
+}
+
+// TestQCBGZFOutput seeks into the middle of BGZF qc output through its .gzi
+// and a .fai built from the decompressed records.
+func TestQCBGZFOutput(t *testing.T) {
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(5))
+	var fasta strings.Builder
+	seqs := make(map[string]string)
+	for i := 0; i < 5000; i++ {
+		seq := make([]byte, 100+rng.Intn(100))
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+		}
+		id := fmt.Sprintf("S%d", i)
+		seqs[id] = string(seq)
+		fmt.Fprintf(&fasta, ">%s\n%s\n", id, seq)
+	}
+	input := filepath.Join(dir, "in.fasta")
+	if err := os.WriteFile(input, []byte(fasta.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.fasta.gz")
+	cfg := qcConfig{NoTaxonomy: true, MaxN: -1, MaxAmbig: -1, OutputPath: output, BGZF: true, Wrap: 60}
+	if _, err := runQCFasta(context.Background(), input, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := scanBGZFBlocks(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzi, err := os.Open(output + ".gzi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	starts, err := readGZI(gzi)
+	_ = gzi.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(starts) < 10 || fmt.Sprint(starts) != fmt.Sprint(blocks) {
+		t.Fatalf(".gzi holds %d blocks, the file %d", len(starts), len(blocks))
+	}
+	zr, err := gzip.NewReader(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := indexFasta(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFai(output+".fai", entries); err != nil {
+		t.Fatal(err)
+	}
+	recs, missing, err := fetchSequences(output, []string{"S2500", "S4999"})
+	if err != nil || len(missing) > 0 {
+		t.Fatalf("fetch: missing %v: %v", missing, err)
+	}
+	for _, rec := range recs {
+		if string(rec.seq) != seqs[rec.id] {
+			t.Fatalf("%s = %s, want %s", rec.id, rec.seq, seqs[rec.id])
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package cmd
+
+// lengthTrimmer cuts cleaned sequences to length for -trim-head, -trim-tail,
+// and -trim-to-max, ahead of the length filters and dedupe so trimmed
+// duplicates collapse.
+type lengthTrimmer struct {
+	head, tail int
+	max        int // 0 unless -trim-to-max
+}
+
+// newLengthTrimmer returns the trimmer of cfg, or nil when no trimming is
+// set.
+func newLengthTrimmer(cfg qcConfig) *lengthTrimmer {
+	t := &lengthTrimmer{head: cfg.TrimHead, tail: cfg.TrimTail}
+	if cfg.TrimToMax {
+		t.max = cfg.MaxLen
+	}
+	if t.head == 0 && t.tail == 0 && t.max == 0 {
+		return nil
+	}
+	return t
+}
+
+// trim cuts head bases from the start and tail from the end of seq, then
+// splits any overhang beyond max evenly between the ends, the odd base
+// coming off the end. It returns the kept window and the number of bases
+// cut. Sequences no longer than head+tail are cut to nothing.
+func (t *lengthTrimmer) trim(seq []byte) ([]byte, int) {
+	n := len(seq)
+	start := min(t.head, n)
+	end := max(n-t.tail, start)
+	if over := end - start - t.max; t.max > 0 && over > 0 {
+		start += over / 2
+		end -= over - over/2
+	}
+	return seq[start:end], n - (end - start)
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLengthTrimmer(t *testing.T) {
+	cases := []struct {
+		head, tail, max int
+		seq             string
+		want            string
+		trimmed         int
+	}{
+		{max: 4, seq: "AACCGGTT", want: "CCGG", trimmed: 4},
+		// An odd overhang leaves the extra base on the end.
+		{max: 4, seq: "ACCGGTT", want: "CCGG", trimmed: 3},
+		{max: 4, seq: "AAACCGGTTTT", want: "CCGG", trimmed: 7},
+		{max: 4, seq: "ACGT", want: "ACGT"},
+		{max: 4, seq: "ACG", want: "ACG"},
+		{head: 2, seq: "AACCGG", want: "CCGG", trimmed: 2},
+		{tail: 3, seq: "AACCGG", want: "AAC", trimmed: 3},
+		// Head and tail are cut before the overhang is measured.
+		{head: 1, tail: 2, max: 3, seq: "TAACCGGTA", want: "ACC", trimmed: 6},
+		{head: 3, tail: 4, seq: "ACGTAC", want: "", trimmed: 6},
+	}
+	for _, c := range cases {
+		tr := newLengthTrimmer(qcConfig{TrimHead: c.head, TrimTail: c.tail, TrimToMax: c.max > 0, MaxLen: c.max})
+		got, trimmed := tr.trim([]byte(c.seq))
+		if string(got) != c.want || trimmed != c.trimmed {
+			t.Errorf("head=%d tail=%d max=%d %s: got %q, %d trimmed; want %q, %d", c.head, c.tail, c.max, c.seq, got, trimmed, c.want, c.trimmed)
+		}
+	}
+	if newLengthTrimmer(qcConfig{MaxLen: 4}) != nil {
+		t.Error("max-length alone trims")
+	}
+}
+
+// TestQCTrimToMax checks that trimmed records are kept rather than dropped
+// as too_long, and that records equal once trimmed are deduplicated.
+func TestQCTrimToMax(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.fasta")
+	fasta := strings.Join([]string{
+		">A1", "GACGTACGTC",
+		">B1", "TTACGTACGTAAA", // the same central 8 bases as A1
+		">C1", "CCCCGGGG",
+		">D1", "ACGT",
+		">D1", "TTTTTGGGGGCCCCC",
+	}, "\n") + "\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []string{dedupeFirst, dedupeLongest} {
+		cfg := qcConfig{MaxLen: 8, TrimToMax: true, MaxN: -1, MaxAmbig: -1, DedupeSeqs: true, DedupeIDs: true, DedupePolicy: policy, NoTaxonomy: true}
+		cfg.OutputPath = filepath.Join(dir, policy, "out.fasta")
+		stats, err := runQCFasta(context.Background(), input, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(cfg.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Under longest, the trimmed D1 (8 bases) displaces the first.
+		want := map[string]string{
+			dedupeFirst:   ">A1\nACGTACGT\n>C1\nCCCCGGGG\n>D1\nACGT\n",
+			dedupeLongest: ">A1\nACGTACGT\n>C1\nCCCCGGGG\n>D1\nTTGGGGGC\n",
+		}[policy]
+		if string(out) != want {
+			t.Fatalf("%s output:\n%s", policy, out)
+		}
+		if stats.Dropped["too_long"] != 0 || stats.Dropped["duplicate_sequence"] != 1 || !stats.TrimLength {
+			t.Fatalf("%s: %+v", policy, stats)
+		}
+		// A1 loses 2 bases; under longest, the second D1 loses 7.
+		wantTrimmed := map[string][2]int{dedupeFirst: {1, 2}, dedupeLongest: {2, 9}}[policy]
+		if stats.Trimmed != wantTrimmed[0] || stats.TrimmedBases != wantTrimmed[1] {
+			t.Fatalf("%s: trimmed %d records, %d bases", policy, stats.Trimmed, stats.TrimmedBases)
+		}
+	}
+}
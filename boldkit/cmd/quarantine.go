@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultQuarantineLimit = 1000
+
+// quarantineWriter records malformed input lines instead of aborting a run.
+// A nil *quarantineWriter means quarantine is disabled.
+type quarantineWriter struct {
+	path         string
+	limit        int
+	maxLineBytes int
+	stage        string
+	file         *meteredFile
+	buf          *bufio.Writer
+	counts       map[string]int
+}
+
+func newQuarantineWriter(path string, limit, maxLineBytes int) (*quarantineWriter, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("quarantine limit must be >= 0")
+	}
+	if maxLineBytes < 0 {
+		return nil, fmt.Errorf("max line bytes must be >= 0")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create quarantine dir: %w", err)
+	}
+	f, err := createFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("create quarantine file: %w", err)
+	}
+	q := &quarantineWriter{
+		path:         path,
+		limit:        limit,
+		maxLineBytes: maxLineBytes,
+		file:         f,
+		buf:          bufio.NewWriterSize(f, writerBufferSize),
+		counts:       make(map[string]int),
+	}
+	if _, err := q.buf.WriteString("stage\tline\treason\traw\n"); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("write quarantine header: %w", err)
+	}
+	return q, nil
+}
+
+// setStage labels subsequent entries; the limit applies per stage.
+func (q *quarantineWriter) setStage(stage string) {
+	if q == nil {
+		return
+	}
+	q.stage = stage
+}
+
+// apply switches opts into lenient mode so malformed lines land in quarantine.
+func (q *quarantineWriter) apply(opts Options) Options {
+	if q == nil {
+		return opts
+	}
+	opts.StrictColumns = true
+	opts.RejectNUL = true
+	if q.maxLineBytes > 0 {
+		opts.MaxLineBytes = q.maxLineBytes
+	}
+	opts.OnRowError = q.add
+	return opts
+}
+
+func (q *quarantineWriter) add(rowErr RowError) error {
+	q.counts[q.stage]++
+	if q.limit > 0 && q.counts[q.stage] > q.limit {
+		return fmt.Errorf("quarantine limit %d exceeded (%s): %w", q.limit, q.stage, rowErr)
+	}
+	line := q.stage + "\t" + strconv.FormatInt(rowErr.Line, 10) + "\t" + auditField(rowErr.Reason) + "\t"
+	if _, err := q.buf.WriteString(line); err != nil {
+		return fmt.Errorf("write quarantine: %w", err)
+	}
+	if _, err := q.buf.Write(bytes.TrimRight(rowErr.Raw, "\r\n")); err != nil {
+		return fmt.Errorf("write quarantine: %w", err)
+	}
+	if err := q.buf.WriteByte('\n'); err != nil {
+		return fmt.Errorf("write quarantine: %w", err)
+	}
+	return nil
+}
+
+func (q *quarantineWriter) count(stage string) int {
+	if q == nil {
+		return 0
+	}
+	return q.counts[stage]
+}
+
+func (q *quarantineWriter) total() int {
+	if q == nil {
+		return 0
+	}
+	n := 0
+	for _, c := range q.counts {
+		n += c
+	}
+	return n
+}
+
+// summary renders per-stage counts, e.g. "extract=3 markers=3".
+func (q *quarantineWriter) summary() string {
+	if q == nil {
+		return ""
+	}
+	return formatQuarantineCounts(q.counts)
+}
+
+func (q *quarantineWriter) Close() error {
+	if q == nil || q.file == nil {
+		return nil
+	}
+	flushErr := q.buf.Flush()
+	closeErr := q.file.Close()
+	q.file = nil
+	if flushErr != nil {
+		return fmt.Errorf("flush quarantine: %w", flushErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("close quarantine: %w", closeErr)
+	}
+	return nil
+}
+
+func formatQuarantineCounts(counts map[string]int) string {
+	stages := make([]string, 0, len(counts))
+	for stage := range counts {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	parts := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		parts = append(parts, stage+"="+strconv.Itoa(counts[stage]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// countQuarantine tallies quarantined lines per stage from a quarantine file.
+func countQuarantine(path string) (map[string]int, error) {
+	in, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("open quarantine: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(in)
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 64*1024*1024)
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		stage, _, _ := strings.Cut(line, "\t")
+		counts[stage]++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan quarantine: %w", err)
+	}
+	return counts, nil
+}
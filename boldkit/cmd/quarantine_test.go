@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeQuarantineFixture(t *testing.T, dir string) string {
+	t.Helper()
+	input := filepath.Join(dir, "input.tsv")
+	content := strings.Join([]string{
+		"processid\tmarker_code\tnuc",
+		"P1\tCOI-5P\tACGT",
+		"P2\tCOI-5P",
+		"P3\tCOI-5P\tAC\x00GT",
+		"P4\tCOI-5P\t" + strings.Repeat("A", 200),
+		"P5\tCOI-5P\tGGCC",
+	}, "\n") + "\n"
+	if err := os.WriteFile(input, []byte(content), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	return input
+}
+
+func TestBuildMarkerFastasQuarantine(t *testing.T) {
+	tmp := t.TempDir()
+	input := writeQuarantineFixture(t, tmp)
+	outDir := filepath.Join(tmp, "markers")
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	qPath := filepath.Join(tmp, "quarantine.tsv")
+
+	q, err := newQuarantineWriter(qPath, 10, 100)
+	if err != nil {
+		t.Fatalf("newQuarantineWriter: %v", err)
+	}
+	if err := buildMarkerFastas(context.Background(), input, outDir, false, 0, -1, 2, inputConfig{Quarantine: q}); err != nil {
+		t.Fatalf("buildMarkerFastas: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("close quarantine: %v", err)
+	}
+
+	fasta, err := os.ReadFile(filepath.Join(outDir, "COI-5P.fasta"))
+	if err != nil {
+		t.Fatalf("read fasta: %v", err)
+	}
+	if got, want := string(fasta), ">P1\nACGT\n>P5\nGGCC\n"; got != want {
+		t.Fatalf("fasta=%q want %q", got, want)
+	}
+
+	data, err := os.ReadFile(qPath)
+	if err != nil {
+		t.Fatalf("read quarantine: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected header + 3 quarantined lines, got:\n%s", data)
+	}
+	wantReasons := []string{"expected 3 columns, got 2", "NUL byte in line", "exceeds limit 100"}
+	for i, want := range wantReasons {
+		if !strings.HasPrefix(lines[i+1], "markers\t") || !strings.Contains(lines[i+1], want) {
+			t.Fatalf("line %d=%q want reason containing %q", i+1, lines[i+1], want)
+		}
+	}
+	if !strings.HasSuffix(lines[1], "\tP2\tCOI-5P") {
+		t.Fatalf("expected raw line preserved, got %q", lines[1])
+	}
+
+	counts, err := countQuarantine(qPath)
+	if err != nil {
+		t.Fatalf("countQuarantine: %v", err)
+	}
+	if counts["markers"] != 3 {
+		t.Fatalf("counts=%v want markers=3", counts)
+	}
+}
+
+func TestBuildTaxonkitQuarantineLimit(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "input.tsv")
+	content := strings.Join([]string{
+		"processid\tbin_uri\tkingdom\tphylum\tclass\torder\tfamily\tsubfamily\ttribe\tgenus\tspecies",
+		"P1\tBOLD:A\tAnimalia\tChordata",
+		"P2\tBOLD:B\tAnimalia",
+		"P3\t\tAnimalia\tChordata\tMammalia\tCarnivora\tCanidae\t\t\tCanis\tCanis lupus",
+	}, "\n") + "\n"
+	if err := os.WriteFile(input, []byte(content), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	q, err := newQuarantineWriter(filepath.Join(tmp, "q.tsv"), 1, 0)
+	if err != nil {
+		t.Fatalf("newQuarantineWriter: %v", err)
+	}
+	defer func() {
+		_ = q.Close()
+	}()
+	_, err = buildTaxonkit(context.Background(), input, filepath.Join(tmp, "out.tsv"), 0, -1, extractCurationConfig{}.normalized(), inputConfig{Quarantine: q})
+	if err == nil || !strings.Contains(err.Error(), "quarantine limit 1 exceeded") {
+		t.Fatalf("expected quarantine limit error, got %v", err)
+	}
+}
+
+func TestParseTSVFailFastWithoutQuarantine(t *testing.T) {
+	opts := DefaultOptions()
+	opts.RejectNUL = true
+	err := ParseTSV(strings.NewReader("a\tb\nc\x00\td\n"), opts, func(Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2: NUL byte in line") {
+		t.Fatalf("expected NUL rejection, got %v", err)
+	}
+}
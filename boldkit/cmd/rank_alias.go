@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultRankAliases are the rank renames every taxdump gets: the NCBI
+// superkingdom and the SILVA/GTDB domain both read as kingdom.
+var defaultRankAliases = map[string]string{
+	"superkingdom": "kingdom",
+	"domain":       "kingdom",
+}
+
+// rankAliasPresets are the named alias sets -rank-aliases accepts inline.
+var rankAliasPresets = map[string]map[string]string{
+	// botanical: divisions are the phyla of the botanical code.
+	"botanical": {"division": "phylum"},
+}
+
+// rankAliasesUsage is the -rank-aliases flag help of qc and format.
+const rankAliasesUsage = "Rank renames applied to taxdump lineages over the defaults (superkingdom and domain read as kingdom): comma-separated from=to pairs and presets (botanical: division=phylum), or a JSON/TSV file of pairs; from= drops a default"
+
+// addRankAliasOption adds the aliases spec parses to to cache key options,
+// which covers the content of an alias file and equivalent specs alike.
+func addRankAliasOption(opts map[string]any, spec string) error {
+	aliases, err := parseRankAliases(spec)
+	if err != nil {
+		return fmt.Errorf("rank aliases: %w", err)
+	}
+	opts["rank_aliases"] = map[string]string(aliases)
+	return nil
+}
+
+// rankAliases maps taxdump ranks to the rank names lineages, rank
+// requirements, and reports use. It is read-only once parsed.
+type rankAliases map[string]string
+
+// parseRankAliases returns the default aliases overlaid with spec, the
+// -rank-aliases value: comma-separated from=to pairs and preset names
+// ("botanical"), or the path of a JSON object or a from<TAB>to TSV file.
+// An empty to ("domain=") drops a default alias.
+func parseRankAliases(spec string) (rankAliases, error) {
+	aliases := rankAliases(maps.Clone(defaultRankAliases))
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return aliases, nil
+	}
+	pairs, err := rankAliasPairs(spec)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pairs {
+		from, to := strings.TrimSpace(p[0]), strings.TrimSpace(p[1])
+		switch {
+		case from == "":
+			return nil, fmt.Errorf("rank alias %q has an empty rank", p[0]+"="+p[1])
+		case to == "" || to == from:
+			delete(aliases, from)
+		default:
+			aliases[from] = to
+		}
+	}
+	// Aliases apply once, so a target must not be renamed again.
+	for _, from := range slices.Sorted(maps.Keys(aliases)) {
+		if next, ok := aliases[aliases[from]]; ok {
+			return nil, fmt.Errorf("rank alias %s=%s: %s is itself aliased to %s", from, aliases[from], aliases[from], next)
+		}
+	}
+	return aliases, nil
+}
+
+// isRankAliasFile reports whether a -rank-aliases value names a file rather
+// than inline pairs and presets.
+func isRankAliasFile(spec string) bool {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.Contains(spec, "=") {
+		return false
+	}
+	for _, name := range strings.Split(spec, ",") {
+		if _, ok := rankAliasPresets[strings.TrimSpace(name)]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rankAliasPairs returns the from/to pairs of spec, in order.
+func rankAliasPairs(spec string) ([][2]string, error) {
+	if isRankAliasFile(spec) {
+		return loadRankAliasFile(spec)
+	}
+	var pairs [][2]string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if from, to, ok := strings.Cut(item, "="); ok {
+			pairs = append(pairs, [2]string{from, to})
+			continue
+		}
+		preset := rankAliasPresets[item]
+		for _, from := range slices.Sorted(maps.Keys(preset)) {
+			pairs = append(pairs, [2]string{from, preset[from]})
+		}
+	}
+	return pairs, nil
+}
+
+// loadRankAliasFile reads a JSON object of from -> to ranks (.json) or a
+// from<TAB>to TSV with '#' comments.
+func loadRankAliasFile(path string) ([][2]string, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("open rank aliases: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if strings.EqualFold(filepath.Ext(strings.TrimSuffix(path, ".gz")), ".json") {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("read rank aliases: %w", err)
+		}
+		var m map[string]string
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("parse rank aliases %s: %w", path, err)
+		}
+		var pairs [][2]string
+		for _, from := range slices.Sorted(maps.Keys(m)) {
+			pairs = append(pairs, [2]string{from, m[from]})
+		}
+		return pairs, nil
+	}
+
+	var pairs [][2]string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if trimmed := strings.TrimSpace(text); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("rank aliases %s line %d: want from<TAB>to, got %d fields", path, line, len(fields))
+		}
+		pairs = append(pairs, [2]string{fields[0], fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan rank aliases: %w", err)
+	}
+	return pairs, nil
+}
+
+// canonical returns the rank rank reads as.
+func (a rankAliases) canonical(rank string) string {
+	if alias, ok := a[rank]; ok {
+		return alias
+	}
+	return rank
+}
+
+// ranks returns ranks under their canonical names, once each.
+func (a rankAliases) ranks(ranks []string) []string {
+	out := make([]string, 0, len(ranks))
+	for _, rank := range ranks {
+		if rank = a.canonical(rank); !slices.Contains(out, rank) {
+			out = append(out, rank)
+		}
+	}
+	return out
+}
+
+// ruleSpecs rewrites -require-ranks entries (see parseRankRules) under
+// canonical rank names, dropping alternatives that become repeats.
+func (a rankAliases) ruleSpecs(specs []string) []string {
+	out := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		prefix := ""
+		if rest, ok := strings.CutPrefix(spec, "?"); ok {
+			prefix, spec = "?", rest
+		}
+		parts := strings.Split(spec, "|")
+		for i, part := range parts {
+			parts[i] = strings.TrimSpace(part)
+		}
+		out = append(out, prefix+strings.Join(a.ranks(parts), "|"))
+	}
+	return out
+}
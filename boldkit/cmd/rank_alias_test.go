@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseRankAliases(t *testing.T) {
+	dir := t.TempDir()
+	tsv := filepath.Join(dir, "aliases.tsv")
+	if err := os.WriteFile(tsv, []byte("# from\tto\ninfraorder\torder\ndomain\t\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jsonPath := filepath.Join(dir, "aliases.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"division": "phylum"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		spec string
+		want map[string]string
+	}{
+		{"", map[string]string{"superkingdom": "kingdom", "domain": "kingdom"}},
+		{"botanical", map[string]string{"superkingdom": "kingdom", "domain": "kingdom", "division": "phylum"}},
+		{"infraorder=order, superkingdom=", map[string]string{"domain": "kingdom", "infraorder": "order"}},
+		{tsv, map[string]string{"superkingdom": "kingdom", "infraorder": "order"}},
+		{jsonPath, map[string]string{"superkingdom": "kingdom", "domain": "kingdom", "division": "phylum"}},
+	}
+	for _, c := range cases {
+		got, err := parseRankAliases(c.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", c.spec, err)
+		}
+		if !maps.Equal(got, c.want) {
+			t.Errorf("%q: got %v, want %v", c.spec, got, c.want)
+		}
+	}
+	for _, spec := range []string{"=order", "kingdom=domain", filepath.Join(dir, "missing.tsv")} {
+		if _, err := parseRankAliases(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+
+	a, _ := parseRankAliases("botanical")
+	got := a.ruleSpecs([]string{"domain", "?phylum|division", "class"})
+	if want := []string{"kingdom", "?phylum", "class"}; !slices.Equal(got, want) {
+		t.Errorf("ruleSpecs = %v, want %v", got, want)
+	}
+}
+
+// TestRankAliasesDomainTaxdump runs qc and format over a SILVA-style
+// taxdump naming domain, division, and infraorder ranks.
+func TestRankAliasesDomainTaxdump(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]string{
+		"nodes.dmp": {
+			"1\t|\t1\t|\tno rank\t|",
+			"2\t|\t1\t|\tdomain\t|",
+			"3\t|\t2\t|\tdivision\t|",
+			"4\t|\t3\t|\tinfraorder\t|",
+			"5\t|\t4\t|\tspecies\t|",
+		},
+		"names.dmp": {
+			"1\t|\troot\t|\t\t|\tscientific name\t|",
+			"2\t|\tEukaryota\t|\t\t|\tscientific name\t|",
+			"3\t|\tRhodophyta\t|\t\t|\tscientific name\t|",
+			"4\t|\tCeramiales\t|\t\t|\tscientific name\t|",
+			"5\t|\tCeramium rubrum\t|\t\t|\tscientific name\t|",
+		},
+		"taxid.map": {"A1\t5", "A2\t5"},
+		"in.fasta":  {">A1", "ACGTACGTAC", ">A2", "ACGTACGTAA"},
+	}
+	for name, lines := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	input := filepath.Join(dir, "in.fasta")
+	ranks := []string{"kingdom", "phylum", "order", "species"}
+	for _, c := range []struct {
+		aliases string
+		ranks   []string
+		written int
+	}{
+		{"", ranks, 0},
+		{"botanical,infraorder=order", ranks, 2},
+		// Required ranks are read under their aliases too.
+		{"botanical,infraorder=order", []string{"domain", "division", "infraorder", "species"}, 2},
+	} {
+		cfg := qcConfig{
+			MaxN:         -1,
+			MaxAmbig:     -1,
+			RequireRanks: c.ranks,
+			RankAliases:  c.aliases,
+			TaxdumpDir:   dir,
+			OutputPath:   filepath.Join(dir, "out.fasta"),
+		}
+		stats, err := runQCFasta(context.Background(), input, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Written != c.written {
+			t.Fatalf("qc %q %v: %+v", c.aliases, c.ranks, stats)
+		}
+		if c.written > 0 && stats.ByRank["phylum"]["Rhodophyta"].Kept != 2 {
+			t.Fatalf("qc %q %v by_rank: %+v", c.aliases, c.ranks, stats.ByRank)
+		}
+
+		outDir := filepath.Join(dir, "formatted")
+		fstats, _, err := runFormatFasta(context.Background(), formatConfig{
+			Classifiers:  []string{"sintax"},
+			RequireRanks: c.ranks,
+			RankAliases:  c.aliases,
+			Input:        input,
+			OutDir:       outDir,
+			TaxdumpDir:   dir,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fstats.Written != c.written {
+			t.Fatalf("format %q %v: %+v", c.aliases, c.ranks, fstats)
+		}
+		if c.written == 0 {
+			continue
+		}
+		sintax, err := os.ReadFile(filepath.Join(outDir, "sintax.fasta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each lineage names all four ranks, under their own prefixes.
+		if !strings.Contains(string(sintax), ";tax=k:Eukaryota,p:Rhodophyta,o:Ceramiales,s:Ceramium_rubrum;\n") {
+			t.Fatalf("sintax:\n%s", sintax)
+		}
+	}
+}
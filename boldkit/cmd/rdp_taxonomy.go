@@ -32,16 +32,20 @@ var rdpRankAliases = map[string]string{
 	"kingdom":      "domain",
 }
 
+// rdpRankLabel returns the RDP label of a source rank.
+func rdpRankLabel(rank string) string {
+	if alias, ok := rdpRankAliases[rank]; ok {
+		return alias
+	}
+	return rank
+}
+
 // newRdpTaxonomyBuilder creates a new taxonomy builder with the given rank order
 func newRdpTaxonomyBuilder(ranks []string) *rdpTaxonomyBuilder {
 	// Map ranks to RDP labels
 	rdpRanks := make([]string, len(ranks))
 	for i, r := range ranks {
-		if alias, ok := rdpRankAliases[r]; ok {
-			rdpRanks[i] = alias
-		} else {
-			rdpRanks[i] = r
-		}
+		rdpRanks[i] = rdpRankLabel(r)
 	}
 	return &rdpTaxonomyBuilder{
 		nodes:     make(map[string]*rdpTaxonNode),
@@ -83,20 +87,42 @@ func (b *rdpTaxonomyBuilder) addLineage(names []string) []string {
 		rank := b.ranks[i]
 		depth := i + 1
 
-		// Handle empty names
-		if name == "" || name == "-" {
-			name = parentName + "_unclassified_" + rank
+		// A rank gap gets a placeholder named for the nearest named
+		// ancestor, so every leaf sits at the same depth as RDP requires.
+		gap := name == "" || name == "-"
+		if gap {
+			name = rdpPlaceholder(rank, parentName)
 		}
 
 		key := b.resolveName(name, rank, parentKey, depth)
 		keys = append(keys, key)
 		parentKey = key
-		parentName = b.nodes[key].name
+		if !gap {
+			parentName = b.nodes[key].name
+		}
 	}
 
 	return keys
 }
 
+// rdpLineageNames returns the sanitized names lineage gives ranks, "" for
+// each rank it lacks.
+func rdpLineageNames(lineage taxLineage, ranks []string, sanitize nameSanitizer) []string {
+	names := make([]string, len(ranks))
+	for i, rank := range ranks {
+		if name := lineage.get(rank); name != "" {
+			names[i] = sanitize.taxon(name)
+		}
+	}
+	return names
+}
+
+// rdpPlaceholder names the node standing in for a missing rank under the
+// named ancestor parent, such as "Family_incertae_sedis_Insecta".
+func rdpPlaceholder(rank, parent string) string {
+	return strings.ToUpper(rank[:1]) + rank[1:] + "_incertae_sedis_" + parent
+}
+
 // resolveName resolves a name to a unique node key, handling conflicts
 func (b *rdpTaxonomyBuilder) resolveName(name, rank, parentKey string, depth int) string {
 	nameLower := strings.ToLower(name)
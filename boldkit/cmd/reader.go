@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"path/filepath"
 	"strings"
 )
@@ -11,10 +12,36 @@ func isParquetPath(path string) bool {
 }
 
 func ParseRows(path string, opts Options, onRow func(Row) error) error {
+	return parseRowsContext(context.Background(), path, opts, onRow)
+}
+
+func parseRowsContext(ctx context.Context, path string, opts Options, onRow func(Row) error) error {
 	if isParquetPath(path) {
-		return parseParquet(path, opts, onRow)
+		return parseParquet(ctx, path, opts, onRow)
+	}
+	return parseTSVRows(ctx, path, opts, onRow)
+}
+
+// parseBatchesContext is parseRowsContext for ParseTSVBatches callbacks.
+// Parquet inputs are decoded a row at a time and come as one-row batches.
+func parseBatchesContext(ctx context.Context, path string, opts Options, onBatch func([]Row) error) error {
+	if isParquetPath(path) {
+		batch := make([]Row, 1)
+		return parseParquet(ctx, path, opts, func(row Row) error {
+			batch[0] = row
+			return onBatch(batch)
+		})
+	}
+	return parseTSVBatches(ctx, path, opts, onBatch)
+}
+
+// logParseStats logs the stats of a TSV parse. Parquet inputs do not fill
+// them and are skipped.
+func logParseStats(stage string, stats *ParseStats) {
+	if stats == nil || stats.WorkerRows == nil {
+		return
 	}
-	return parseTSVRows(path, opts, onRow)
+	logf("%s: parsed %s", stage, stats)
 }
 
 func RowCount(path string) (int64, error) {
@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 
 	"github.com/apache/arrow/go/v18/arrow"
 	"github.com/apache/arrow/go/v18/arrow/array"
@@ -13,8 +13,14 @@ import (
 	"github.com/apache/arrow/go/v18/parquet/pqarrow"
 )
 
-func parseParquet(path string, opts Options, onRow func(Row) error) error {
-	f, err := os.Open(path)
+func parseParquet(ctx context.Context, path string, opts Options, onRow func(Row) error) error {
+	if opts.StartOffset != 0 || opts.EndOffset != 0 {
+		return errors.New("byte offsets are not supported for parquet input")
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	f, err := openFile(path)
 	if err != nil {
 		return fmt.Errorf("open parquet %s: %w", path, err)
 	}
@@ -30,11 +36,19 @@ func parseParquet(path string, opts Options, onRow func(Row) error) error {
 	schema := pf.MetaData().Schema
 	numCols := schema.NumColumns()
 
+	if opts.HasHeader {
+		onRow = headerRows(opts, onRow)
+	}
+
 	header := make([][]byte, numCols)
 	for i := 0; i < numCols; i++ {
 		header[i] = []byte(schema.Column(i).Name())
 	}
-	if err := onRow(Row{Line: 0, Fields: header}); err != nil {
+	if err := onRow(Row{Line: 0, Fields: header, first: true}); err != nil {
+		return err
+	}
+	proj, err := resolveProjection(opts, header)
+	if err != nil {
 		return err
 	}
 
@@ -43,14 +57,43 @@ func parseParquet(path string, opts Options, onRow func(Row) error) error {
 		return fmt.Errorf("create arrow file reader: %w", err)
 	}
 
-	ctx := context.Background()
-	colIndices := make([]int, numCols)
-	for i := range colIndices {
-		colIndices[i] = i
+	// With a projection only the requested columns are read; outCols maps
+	// each output field to its table column (-1 when out of range).
+	var colIndices []int
+	var outCols []int
+	if proj == nil {
+		colIndices = make([]int, numCols)
+		outCols = make([]int, numCols)
+		for i := range colIndices {
+			colIndices[i] = i
+			outCols[i] = i
+		}
+	} else {
+		tableCol := make(map[int]int, len(proj.cols))
+		for _, i := range proj.order {
+			c := proj.cols[i]
+			if _, ok := tableCol[c]; ok || c >= numCols {
+				continue
+			}
+			tableCol[c] = len(colIndices)
+			colIndices = append(colIndices, c)
+		}
+		outCols = make([]int, len(proj.cols))
+		for i, c := range proj.cols {
+			if t, ok := tableCol[c]; ok {
+				outCols[i] = t
+			} else {
+				outCols[i] = -1
+			}
+		}
 	}
 
 	lineNum := int64(0)
+	var sampled int64
 	for rgIdx := 0; rgIdx < pf.NumRowGroups(); rgIdx++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		tbl, err := fr.ReadRowGroups(ctx, colIndices, []int{rgIdx})
 		if err != nil {
 			return fmt.Errorf("read row group %d: %w", rgIdx, err)
@@ -68,14 +111,25 @@ func parseParquet(path string, opts Options, onRow func(Row) error) error {
 
 		for r := 0; r < nRows; r++ {
 			lineNum++
-			fields := make([][]byte, numCols)
-			for c := 0; c < nCols; c++ {
-				if cols[c] != nil {
-					fields[c] = columnStringValue(cols[c], r)
+			if lineNum%4096 == 0 && ctx.Err() != nil {
+				tbl.Release()
+				return ctx.Err()
+			}
+			if opts.SampleEveryN > 1 && (lineNum-1)%opts.SampleEveryN != 0 {
+				opts.Progress.add(1)
+				continue
+			}
+			fields := make([][]byte, len(outCols))
+			for i, c := range outCols {
+				switch {
+				case c < 0:
+					fields[i] = []byte{}
+				case c < nCols && cols[c] != nil:
+					fields[i] = columnStringValue(cols[c], r)
 				}
 			}
 			if opts.Progress != nil {
-				if !opts.SkipProgressFirstRow || lineNum != 1 {
+				if !opts.SkipProgressFirstRow || opts.HasHeader || lineNum != 1 {
 					opts.Progress.increment()
 				}
 			}
@@ -83,6 +137,10 @@ func parseParquet(path string, opts Options, onRow func(Row) error) error {
 				tbl.Release()
 				return err
 			}
+			if sampled++; opts.SampleLimit > 0 && sampled >= opts.SampleLimit {
+				tbl.Release()
+				return nil
+			}
 		}
 		tbl.Release()
 	}
@@ -109,7 +167,7 @@ func columnStringValue(col arrow.Array, row int) []byte {
 }
 
 func parquetRowCount(path string) (int64, error) {
-	f, err := os.Open(path)
+	f, err := openFile(path)
 	if err != nil {
 		return 0, err
 	}
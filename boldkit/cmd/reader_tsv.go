@@ -1,14 +1,47 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"strings"
 )
 
-func parseTSVRows(path string, opts Options, onRow func(Row) error) error {
-	in, err := openInput(path)
+func parseTSVRows(ctx context.Context, path string, opts Options, onRow func(Row) error) error {
+	in, opts, err := openTSVInput(path, opts)
 	if err != nil {
 		return fmt.Errorf("open input %s: %w", path, err)
 	}
 	defer func() { _ = in.Close() }()
-	return ParseTSV(in, opts, onRow)
+	return ParseTSVContext(ctx, in, opts, onRow)
+}
+
+func parseTSVBatches(ctx context.Context, path string, opts Options, onBatch func([]Row) error) error {
+	in, opts, err := openTSVInput(path, opts)
+	if err != nil {
+		return fmt.Errorf("open input %s: %w", path, err)
+	}
+	defer func() { _ = in.Close() }()
+	return ParseTSVBatchesContext(ctx, in, opts, onBatch)
+}
+
+// openTSVInput opens path for parsing. The parser feeds Options.ByteProgress
+// with the bytes it reads, which for a plain file add up to its size. A .gz
+// file's bar is sized by the compressed file, so the compressed bytes read
+// feed it instead and the returned options leave the parser out.
+func openTSVInput(path string, opts Options) (io.ReadCloser, Options, error) {
+	if opts.ByteProgress == nil || !strings.HasSuffix(path, ".gz") {
+		in, err := openInputWorkers(path, opts.DecompressWorkers)
+		return in, opts, err
+	}
+	in, counter, err := openInputWithCounter(path, opts.DecompressWorkers)
+	if err != nil {
+		return nil, opts, err
+	}
+	bar := opts.ByteProgress
+	opts.ByteProgress = nil
+	return readCloser{
+		reader: &byteProgressReader{reader: in, bar: bar, counter: counter},
+		close:  in.Close,
+	}, opts, nil
 }
@@ -0,0 +1,341 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	redactSaltEnv             = "BOLDKIT_REDACT_SALT"
+	defaultRedactCoordColumns = "coord,lat,lon,latitude,longitude,decimallatitude,decimallongitude"
+
+	redactActionDrop     = "drop"
+	redactActionHash     = "hash"
+	redactActionTruncate = "truncate"
+	redactActionKeep     = "keep"
+)
+
+type redactConfig struct {
+	InputPath     string
+	OutputPath    string
+	ManifestPath  string
+	Drop          []string
+	Hash          []string
+	CoordColumns  []string
+	CoordDecimals int // <0 disables truncation
+	Salt          string
+	SaltSource    string
+}
+
+// redactColumn records the action taken on one input column.
+type redactColumn struct {
+	Name    string `json:"name"`
+	Action  string `json:"action"`
+	Changed int64  `json:"changed"`
+}
+
+type redactionManifest struct {
+	reportHeader
+	Input           string         `json:"input"`
+	Output          string         `json:"output"`
+	Rows            int64          `json:"rows"`
+	Dropped         []string       `json:"dropped"`
+	Hashed          []string       `json:"hashed"`
+	Truncated       []string       `json:"truncated"`
+	CoordDecimals   int            `json:"coord_decimals"`
+	HashAlgorithm   string         `json:"hash_algorithm,omitempty"`
+	SaltSource      string         `json:"salt_source,omitempty"`
+	SaltFingerprint string         `json:"salt_fingerprint,omitempty"`
+	Columns         []redactColumn `json:"columns"`
+	Resources       *runResources  `json:"resources,omitempty"`
+}
+
+func runRedact(args []string) {
+	fs := flag.NewFlagSet("redact", flag.ExitOnError)
+	input := fs.String("input", "", "BOLD TSV/TSV.gz input")
+	output := fs.String("out", "", "Redacted TSV output (.gz to compress)")
+	manifest := fs.String("manifest", "", "Redaction manifest JSON (default: redaction_manifest.json next to -out)")
+	drop := fs.String("drop", "", "Comma-separated columns to remove")
+	hash := fs.String("hash", "", "Comma-separated columns to replace with salted SHA-256 hashes")
+	truncate := fs.Int("truncate-coords", -1, "Truncate coordinates to this many decimals (-1 disables)")
+	coordColumns := fs.String("coord-columns", defaultRedactCoordColumns, "Columns treated as coordinates when present")
+	salt := fs.String("salt", "", "Hash salt (default: $"+redactSaltEnv+")")
+	force := fs.Bool("force", false, "Overwrite existing outputs")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *input == "" || *output == "" {
+		fatalf("redact requires -input and -out")
+	}
+	if !*force && fileExists(*output) && !outputStale(*output) {
+		fmt.Fprintf(os.Stderr, "Output exists, skipping: %s\n", *output)
+		return
+	}
+
+	cfg := redactConfig{
+		InputPath:     *input,
+		OutputPath:    *output,
+		ManifestPath:  *manifest,
+		Drop:          splitList(*drop),
+		Hash:          splitList(*hash),
+		CoordColumns:  splitList(*coordColumns),
+		CoordDecimals: *truncate,
+		Salt:          *salt,
+		SaltSource:    "flag",
+	}
+	if cfg.Salt == "" {
+		cfg.Salt = os.Getenv(redactSaltEnv)
+		cfg.SaltSource = "env"
+	}
+	if cfg.ManifestPath == "" {
+		cfg.ManifestPath = filepath.Join(filepath.Dir(*output), "redaction_manifest.json")
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	err := recordStage(*output, "redact", *input, redactTSV(ctx, cfg))
+	exitIfInterrupted(err)
+	if err != nil {
+		fatalf("redact failed: %v", err)
+	}
+}
+
+func (c redactConfig) validate() error {
+	if len(c.Drop) == 0 && len(c.Hash) == 0 && c.CoordDecimals < 0 {
+		return errors.New("nothing to do: set -drop, -hash, or -truncate-coords")
+	}
+	if len(c.Hash) > 0 && c.Salt == "" {
+		return fmt.Errorf("-hash requires -salt or $%s", redactSaltEnv)
+	}
+	drop := make(map[string]struct{}, len(c.Drop))
+	for _, name := range c.Drop {
+		drop[name] = struct{}{}
+	}
+	for _, name := range c.Hash {
+		if _, ok := drop[name]; ok {
+			return fmt.Errorf("column %q is listed in both -drop and -hash", name)
+		}
+	}
+	return nil
+}
+
+// redactPlan maps each input column to its action.
+type redactPlan struct {
+	columns []redactColumn
+	keep    []int
+}
+
+func newRedactPlan(cfg redactConfig, header [][]byte) (*redactPlan, error) {
+	actions := make(map[string]string)
+	for _, name := range cfg.Drop {
+		actions[name] = redactActionDrop
+	}
+	for _, name := range cfg.Hash {
+		actions[name] = redactActionHash
+	}
+	coords := make(map[string]struct{}, len(cfg.CoordColumns))
+	for _, name := range cfg.CoordColumns {
+		coords[strings.ToLower(name)] = struct{}{}
+	}
+
+	plan := &redactPlan{columns: make([]redactColumn, len(header))}
+	seen := make(map[string]struct{}, len(header))
+	for i, h := range header {
+		name := string(h)
+		seen[name] = struct{}{}
+		action, ok := actions[name]
+		if !ok {
+			action = redactActionKeep
+			if _, coord := coords[strings.ToLower(name)]; coord && cfg.CoordDecimals >= 0 {
+				action = redactActionTruncate
+			}
+		}
+		plan.columns[i] = redactColumn{Name: name, Action: action}
+		if action != redactActionDrop {
+			plan.keep = append(plan.keep, i)
+		}
+	}
+	var missing []string
+	for _, name := range append(append([]string(nil), cfg.Drop...), cfg.Hash...) {
+		if _, ok := seen[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("columns not found in header: %s", strings.Join(missing, ", "))
+	}
+	return plan, nil
+}
+
+func (p *redactPlan) names(action string) []string {
+	out := []string{}
+	for _, c := range p.columns {
+		if c.Action == action {
+			out = append(out, c.Name)
+		}
+	}
+	return out
+}
+
+func redactTSV(ctx context.Context, cfg redactConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	out, err := createOutput(cfg.OutputPath)
+	if err != nil {
+		return fmt.Errorf("create output: %w", err)
+	}
+	writer := bufio.NewWriterSize(out, writerBufferSize)
+
+	var (
+		plan    *redactPlan
+		rows    int64
+		scratch []byte
+	)
+	opts := DefaultOptions()
+	opts.StrictColumns = true
+	err = parseRowsContext(ctx, cfg.InputPath, opts, func(row Row) error {
+		if plan == nil {
+			p, err := newRedactPlan(cfg, row.Fields)
+			if err != nil {
+				return err
+			}
+			plan = p
+			for i, idx := range plan.keep {
+				if i > 0 {
+					_ = writer.WriteByte('\t')
+				}
+				_, _ = writer.Write(row.Fields[idx])
+			}
+			return writer.WriteByte('\n')
+		}
+		rows++
+		for i, idx := range plan.keep {
+			if i > 0 {
+				_ = writer.WriteByte('\t')
+			}
+			value := row.Fields[idx]
+			col := &plan.columns[idx]
+			switch col.Action {
+			case redactActionHash:
+				if len(value) > 0 {
+					scratch = saltedHash(scratch[:0], cfg.Salt, value)
+					value = scratch
+					col.Changed++
+				}
+			case redactActionTruncate:
+				scratch = truncateDecimals(scratch[:0], value, cfg.CoordDecimals)
+				if string(scratch) != string(value) {
+					col.Changed++
+				}
+				value = scratch
+			}
+			if _, err := writer.Write(value); err != nil {
+				return fmt.Errorf("write row: %w", err)
+			}
+		}
+		return writer.WriteByte('\n')
+	})
+	if err == nil && plan == nil {
+		err = errors.New("input has no header row")
+	}
+	err = interruptedAt(ctx, err, "redact", rows+1)
+	if flushErr := writer.Flush(); err == nil && flushErr != nil {
+		err = fmt.Errorf("flush output: %w", flushErr)
+	}
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close output: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range plan.columns {
+		col := &plan.columns[i]
+		if col.Action == redactActionDrop {
+			col.Changed = rows
+		}
+		if col.Action != redactActionKeep {
+			logf("redact: %s %s (%d values)", col.Action, col.Name, col.Changed)
+		}
+	}
+
+	manifest := redactionManifest{
+		reportHeader:  newReportHeader("redaction-manifest"),
+		Input:         cfg.InputPath,
+		Output:        cfg.OutputPath,
+		Rows:          rows,
+		Dropped:       plan.names(redactActionDrop),
+		Hashed:        plan.names(redactActionHash),
+		Truncated:     plan.names(redactActionTruncate),
+		CoordDecimals: cfg.CoordDecimals,
+		Columns:       plan.columns,
+		Resources:     currentRun().resources(),
+	}
+	if len(manifest.Hashed) > 0 {
+		manifest.HashAlgorithm = "sha256(salt + 0x00 + value), first 16 bytes hex"
+		manifest.SaltSource = cfg.SaltSource
+		manifest.SaltFingerprint = saltFingerprint(cfg.Salt)
+	}
+	if err := writeReportJSON(cfg.ManifestPath, manifest); err != nil {
+		return err
+	}
+	logf("redact: %d rows -> %s (manifest %s)", rows, cfg.OutputPath, cfg.ManifestPath)
+	return nil
+}
+
+// saltedHash appends the hex of the first 16 bytes of sha256(salt 0x00 value).
+func saltedHash(dst []byte, salt string, value []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(salt))
+	h.Write([]byte{0})
+	h.Write(value)
+	sum := h.Sum(nil)
+	return hex.AppendEncode(dst, sum[:16])
+}
+
+// saltFingerprint lets collaborators confirm they share a salt without
+// recording the salt itself.
+func saltFingerprint(salt string) string {
+	sum := sha256.Sum256([]byte("boldkit-redact-salt\x00" + salt))
+	return hex.EncodeToString(sum[:4])
+}
+
+// truncateDecimals copies src to dst, cutting every decimal number to at most
+// n fractional digits (truncation, not rounding).
+func truncateDecimals(dst, src []byte, n int) []byte {
+	for i := 0; i < len(src); {
+		b := src[i]
+		if b != '.' || i == 0 || !isDigit(src[i-1]) || i+1 >= len(src) || !isDigit(src[i+1]) {
+			dst = append(dst, b)
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(src) && isDigit(src[j]) {
+			j++
+		}
+		if n > 0 {
+			end := i + 1 + n
+			if end > j {
+				end = j
+			}
+			dst = append(dst, src[i:end]...)
+		}
+		i = j
+	}
+	return dst
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRedactFixture(t *testing.T, path string) {
+	t.Helper()
+	content := strings.Join([]string{
+		"processid\tsampleid\tcollectors\tinst\tcoord\tlat",
+		"P1\tS1\tJane Doe\tMuseum A\t[12.345678, -45.6789]\t12.345678",
+		"P2\t\tJohn Roe\tMuseum B\tNone\t-3.5",
+	}, "\n") + "\n"
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create fixture: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := io.WriteString(gz, content); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close fixture: %v", err)
+	}
+}
+
+func readGzipLines(t *testing.T, path string) []string {
+	t.Helper()
+	in, err := openInput(path)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	data, err := io.ReadAll(in)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestRedactTSV(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "bold.tsv.gz")
+	writeRedactFixture(t, input)
+
+	cfg := redactConfig{
+		InputPath:     input,
+		OutputPath:    filepath.Join(tmp, "redacted.tsv.gz"),
+		ManifestPath:  filepath.Join(tmp, "redaction_manifest.json"),
+		Drop:          []string{"collectors", "inst"},
+		Hash:          []string{"sampleid"},
+		CoordColumns:  splitList(defaultRedactCoordColumns),
+		CoordDecimals: 2,
+		Salt:          "pepper",
+		SaltSource:    "flag",
+	}
+	if err := redactTSV(context.Background(), cfg); err != nil {
+		t.Fatalf("redactTSV: %v", err)
+	}
+
+	lines := readGzipLines(t, cfg.OutputPath)
+	if len(lines) != 3 {
+		t.Fatalf("lines=%q", lines)
+	}
+	if lines[0] != "processid\tsampleid\tcoord\tlat" {
+		t.Fatalf("header=%q", lines[0])
+	}
+	hashed := string(saltedHash(nil, "pepper", []byte("S1")))
+	if want := "P1\t" + hashed + "\t[12.34, -45.67]\t12.34"; lines[1] != want {
+		t.Fatalf("row1=%q want %q", lines[1], want)
+	}
+	if want := "P2\t\tNone\t-3.5"; lines[2] != want {
+		t.Fatalf("row2=%q want %q", lines[2], want)
+	}
+	if hashed == string(saltedHash(nil, "other", []byte("S1"))) {
+		t.Fatalf("hash does not depend on salt")
+	}
+
+	data, err := os.ReadFile(cfg.ManifestPath)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var manifest redactionManifest
+	if err := decodeReport("redaction-manifest", data, &manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if manifest.Rows != 2 || strings.Join(manifest.Dropped, ",") != "collectors,inst" ||
+		strings.Join(manifest.Hashed, ",") != "sampleid" || strings.Join(manifest.Truncated, ",") != "coord,lat" {
+		t.Fatalf("manifest=%+v", manifest)
+	}
+	changed := map[string]int64{}
+	for _, c := range manifest.Columns {
+		changed[c.Name] = c.Changed
+	}
+	if changed["sampleid"] != 1 || changed["coord"] != 1 || changed["lat"] != 1 || changed["collectors"] != 2 {
+		t.Fatalf("changed=%v", changed)
+	}
+	if strings.Contains(string(data), "pepper") {
+		t.Fatalf("manifest leaks the salt")
+	}
+}
+
+func TestRedactConfigErrors(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "bold.tsv.gz")
+	writeRedactFixture(t, input)
+	base := redactConfig{InputPath: input, OutputPath: filepath.Join(tmp, "out.tsv"), ManifestPath: filepath.Join(tmp, "m.json"), CoordDecimals: -1}
+
+	cases := []struct {
+		name string
+		edit func(*redactConfig)
+		want string
+	}{
+		{name: "nothing", edit: func(*redactConfig) {}, want: "nothing to do"},
+		{name: "no salt", edit: func(c *redactConfig) { c.Hash = []string{"sampleid"} }, want: "requires -salt"},
+		{name: "both", edit: func(c *redactConfig) { c.Drop = []string{"inst"}; c.Hash = []string{"inst"}; c.Salt = "s" }, want: "both -drop and -hash"},
+		{name: "missing", edit: func(c *redactConfig) { c.Drop = []string{"nope"} }, want: "columns not found in header: nope"},
+	}
+	for _, tc := range cases {
+		cfg := base
+		tc.edit(&cfg)
+		err := redactTSV(context.Background(), cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: err=%v want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestTruncateDecimals(t *testing.T) {
+	cases := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{in: "12.345678", n: 1, want: "12.3"},
+		{in: "-45.6", n: 3, want: "-45.6"},
+		{in: "(1.999, -2.001)", n: 0, want: "(1, -2)"},
+		{in: "v1.2-beta", n: 0, want: "v1-beta"},
+		{in: "None", n: 2, want: "None"},
+	}
+	for _, tc := range cases {
+		if got := string(truncateDecimals(nil, []byte(tc.in), tc.n)); got != tc.want {
+			t.Fatalf("truncateDecimals(%q,%d)=%q want %q", tc.in, tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package cmd
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Report schema versions follow major.minor: adding a field bumps the minor
+// version, removing or renaming one bumps the major version. Every bump must
+// append a note to the schema's History so the change is documented.
+
+//go:embed schemas/*.schema.json
+var embeddedSchemas embed.FS
+
+// reportHeader is embedded at the top level of every JSON report.
+type reportHeader struct {
+	SchemaVersion string `json:"schema_version"`
+	ToolVersion   string `json:"tool_version"`
+}
+
+type reportSchema struct {
+	Name     string
+	Version  string
+	Title    string
+	newValue func() any
+	History  []string
+}
+
+var reportSchemas = []reportSchema{
+	{
+		Name:     "qc-report",
+		Version:  "1.12",
+		Title:    "BoldKit qc report",
+		newValue: func() any { return &qcReport{} },
+		History: []string{
+			"1.0: add schema_version and tool_version",
+			"1.1: registered qc filters may add integer drop counters",
+			"1.2: add optional resources (wall/CPU time, peak RSS, bytes read and written)",
+			"1.3: add optional cache (derived-artifact cache hits and misses)",
+			"1.4: missing_taxid and missing_ranks are null under -no-taxonomy",
+			"1.5: add optional snapshot_id",
+			"1.6: add optional orientation and flipped (qc -orientation)",
+			"1.7: add optional trimmed_start and trimmed_end (qc -trim-primers)",
+			"1.8: add optional by_rank (per-taxon totals, kept, and drops at each required rank)",
+			"1.9: add optional missing_ranks_by_rule and missing_optional_ranks (qc -require-ranks alternatives and optional ranks)",
+			"1.10: add optional trimmed and trimmed_bases (qc -trim-head, -trim-tail, -trim-to-max)",
+			"1.11: add optional resolved_by_name and unresolved_by_name (qc -resolve-by-name)",
+			"1.12: add optional merged_taxid (taxids replaced per merged.dmp); the rank check may add deleted_taxid",
+		},
+	},
+	{
+		Name:     "format-report",
+		Version:  "1.10",
+		Title:    "BoldKit format report",
+		newValue: func() any { return &formatReport{} },
+		History: []string{
+			"1.0: add schema_version and tool_version; drop qc-only counters",
+			"1.1: add optional resources (wall/CPU time, peak RSS, bytes read and written)",
+			"1.2: add sanitize (taxon name sanitation mode)",
+			"1.3: add optional cache (derived-artifact cache hits and misses)",
+			"1.4: add optional no_taxonomy; missing_taxid and missing_ranks are null when it is set",
+			"1.5: add optional resolved_by_name and unresolved_by_name (format -resolve-by-name)",
+			"1.6: add optional merged_taxid and deleted_taxid (merged.dmp and delnodes.dmp)",
+			"1.7: add optional sintax_no_kingdom",
+			"1.8: add optional mothur_missing_ranks",
+			"1.9: add optional blast_ids_sanitized",
+			"1.10: add optional centrifuge_unmapped (format -centrifuge-ncbi-map)",
+		},
+	},
+	{
+		Name:     "split-report",
+		Version:  "1.2",
+		Title:    "BoldKit split report",
+		newValue: func() any { return &splitReport{} },
+		History: []string{
+			"1.0: add schema_version and tool_version",
+			"1.1: add optional resources (wall/CPU time, peak RSS, bytes read and written)",
+			"1.2: add sanitize (taxon name sanitation mode)",
+		},
+	},
+	{
+		Name:     "classify-report",
+		Version:  "1.2",
+		Title:    "BoldKit classify report",
+		newValue: func() any { return &classifyReport{} },
+		History: []string{
+			"1.0: initial version",
+			"1.1: add optional cache (derived-artifact cache hits and misses)",
+			"1.2: add optional snapshot_id",
+		},
+	},
+	{
+		Name:     "curation-report",
+		Version:  "1.2",
+		Title:    "BoldKit extraction curation report",
+		newValue: func() any { return &bioscanCurationReport{} },
+		History: []string{
+			"1.0: add schema_version and tool_version",
+			"1.1: add optional resources (wall/CPU time, peak RSS, bytes read and written)",
+			"1.2: add optional snapshot_id",
+		},
+	},
+	{
+		Name:     "manifest",
+		Version:  "1.1",
+		Title:    "BoldKit release manifest",
+		newValue: func() any { return &releaseManifest{} },
+		History: []string{
+			"1.0: add schema_version and tool_version",
+			"1.1: add optional resources (wall/CPU time, peak RSS, bytes read and written)",
+		},
+	},
+	{
+		Name:     "tuning",
+		Version:  "1.0",
+		Title:    "BoldKit parser tuning",
+		newValue: func() any { return &parserTuning{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "stage-state",
+		Version:  "1.0",
+		Title:    "BoldKit stage state",
+		newValue: func() any { return &stageState{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "markers-state",
+		Version:  "1.2",
+		Title:    "BoldKit markers resume checkpoint",
+		newValue: func() any { return &markersState{} },
+		History: []string{
+			"1.0: initial version",
+			"1.1: add too_short and too_ambiguous to markers",
+			"1.2: add seen to markers",
+		},
+	},
+	{
+		Name:     "rowcount",
+		Version:  "1.0",
+		Title:    "BoldKit input row count cache",
+		newValue: func() any { return &rowCountSidecar{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "redaction-manifest",
+		Version:  "1.1",
+		Title:    "BoldKit redaction manifest",
+		newValue: func() any { return &redactionManifest{} },
+		History: []string{
+			"1.0: initial version",
+			"1.1: add optional resources (wall/CPU time, peak RSS, bytes read and written)",
+		},
+	},
+	{
+		Name:     "provenance-meta",
+		Version:  "1.0",
+		Title:    "BoldKit provenance directory metadata",
+		newValue: func() any { return &provenanceMeta{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "provenance-event",
+		Version:  "1.0",
+		Title:    "BoldKit provenance event (one NDJSON line)",
+		newValue: func() any { return &provenanceEvent{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "stats-report",
+		Version:  "1.0",
+		Title:    "BoldKit stats report",
+		newValue: func() any { return &statsReport{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "taxdump-validation",
+		Version:  "1.1",
+		Title:    "BoldKit validate-taxdump report",
+		newValue: func() any { return &taxdumpValidation{} },
+		History: []string{
+			"1.0: initial version",
+			"1.1: add no_scientific_name and unnamed node counts",
+		},
+	},
+	{
+		Name:     "taxdump-subset-report",
+		Version:  "1.0",
+		Title:    "BoldKit taxdump-subset report",
+		newValue: func() any { return &taxdumpSubsetReport{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "taxdump-diff-report",
+		Version:  "1.0",
+		Title:    "BoldKit taxdump-diff report",
+		newValue: func() any { return &taxdumpDiffReport{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "dada2-report",
+		Version:  "1.0",
+		Title:    "BoldKit format DADA2 reference report",
+		newValue: func() any { return &dada2Report{} },
+		History:  []string{"1.0: initial version"},
+	},
+	{
+		Name:     "markers-summary",
+		Version:  "1.5",
+		Title:    "BoldKit markers summary",
+		newValue: func() any { return &markersSummary{} },
+		History: []string{
+			"1.0: initial version",
+			"1.1: add optional skipped and removed (markers -markers, -exclude-markers, -min-records)",
+			"1.2: add columns, markers (per-marker records, skipped rows, and lengths), and optional snapshot_id",
+			"1.3: add optional split_by and split (markers -split-by)",
+			"1.4: add too_short and too_ambiguous to markers (markers -min-length, -max-ambig-fraction)",
+			"1.5: add seen to markers, and optional max_per_marker and sample_seed (markers -max-per-marker, -sample-seed)",
+		},
+	},
+}
+
+func lookupReportSchema(name string) (reportSchema, bool) {
+	for _, s := range reportSchemas {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return reportSchema{}, false
+}
+
+func reportSchemaNames() []string {
+	names := make([]string, 0, len(reportSchemas))
+	for _, s := range reportSchemas {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func newReportHeader(name string) reportHeader {
+	s, ok := lookupReportSchema(name)
+	if !ok {
+		panic("unknown report schema: " + name)
+	}
+	return reportHeader{SchemaVersion: s.Version, ToolVersion: toolVersion()}
+}
+
+func toolVersion() string {
+	if appVersion == "" {
+		return "dev"
+	}
+	return appVersion
+}
+
+// checkSchemaVersion accepts any minor version of the supported major. Reports
+// written before versioning (empty schema_version) are treated as compatible.
+func checkSchemaVersion(name, got string) error {
+	s, ok := lookupReportSchema(name)
+	if !ok {
+		return fmt.Errorf("unknown report schema %q", name)
+	}
+	if got == "" {
+		return nil
+	}
+	wantMajor, _, _ := strings.Cut(s.Version, ".")
+	gotMajor, _, _ := strings.Cut(got, ".")
+	if gotMajor != wantMajor {
+		return fmt.Errorf("%s schema_version %s is incompatible with supported %s", name, got, s.Version)
+	}
+	return nil
+}
+
+// decodeReport parses a report and verifies its schema version.
+func decodeReport(name string, data []byte, v any) error {
+	var header reportHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("decode %s: %w", name, err)
+	}
+	if err := checkSchemaVersion(name, header.SchemaVersion); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s: %w", name, err)
+	}
+	return nil
+}
+
+// embeddedReportSchema returns the checked-in JSON Schema document for name.
+func embeddedReportSchema(name string) ([]byte, error) {
+	if _, ok := lookupReportSchema(name); !ok {
+		return nil, fmt.Errorf("unknown report schema %q (available: %s)", name, strings.Join(reportSchemaNames(), ","))
+	}
+	return embeddedSchemas.ReadFile("schemas/" + name + ".schema.json")
+}
+
+// generateReportSchema derives a JSON Schema document from the report struct.
+func generateReportSchema(s reportSchema) ([]byte, error) {
+	major, _, _ := strings.Cut(s.Version, ".")
+	doc := jsonSchemaFor(reflect.TypeOf(s.newValue()).Elem())
+	doc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	doc["title"] = s.Title
+	doc["description"] = s.Name + " schema_version " + s.Version
+	doc["$comment"] = strings.Join(s.History, "\n")
+	if props, ok := doc["properties"].(map[string]any); ok && props["schema_version"] != nil {
+		props["schema_version"] = map[string]any{
+			"type":    "string",
+			"pattern": "^" + major + "\\.[0-9]+$",
+		}
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// schemaProvider lets types with custom JSON encoding describe themselves.
+type schemaProvider interface {
+	jsonSchema() map[string]any
+}
+
+var schemaProviderType = reflect.TypeOf((*schemaProvider)(nil)).Elem()
+
+// nullableIntegerSchema types counters that are null when their check did
+// not run (-no-taxonomy).
+var nullableIntegerSchema = map[string]any{"type": []string{"integer", "null"}}
+
+func jsonSchemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Implements(schemaProviderType) {
+		return reflect.Zero(t).Interface().(schemaProvider).jsonSchema()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]any)
+		var required []string
+		collectSchemaFields(t, props, &required)
+		sort.Strings(required)
+		out := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			out["required"] = required
+		}
+		return out
+	default:
+		return map[string]any{}
+	}
+}
+
+func collectSchemaFields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			collectSchemaFields(f.Type, props, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = jsonSchemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func writeReportJSON(path string, report any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create report dir: %w", err)
+	}
+	f, err := createFile(path)
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
+
+func runSchema(args []string) {
+	if len(args) == 0 || args[0] == "-list" || args[0] == "--list" {
+		for _, s := range reportSchemas {
+			fmt.Printf("%s\t%s\n", s.Name, s.Version)
+		}
+		return
+	}
+	if args[0] == "-h" || args[0] == "--help" {
+		fmt.Println("Usage: boldkit schema [-list | <name>]")
+		fmt.Println("Available:", strings.Join(reportSchemaNames(), ", "))
+		return
+	}
+	data, err := embeddedReportSchema(args[0])
+	if err != nil {
+		fatalf("schema: %v", err)
+	}
+	fmt.Print(string(data))
+}
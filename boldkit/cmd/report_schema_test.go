@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateSchemas = flag.Bool("update-schemas", false, "rewrite embedded report schemas from the Go structs")
+
+func TestReportSchemasMatchEmbedded(t *testing.T) {
+	for _, s := range reportSchemas {
+		t.Run(s.Name, func(t *testing.T) {
+			got, err := generateReportSchema(s)
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+			path := filepath.Join("schemas", s.Name+".schema.json")
+			if *updateSchemas {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatalf("write %s: %v", path, err)
+				}
+				return
+			}
+			want, err := embeddedReportSchema(s.Name)
+			if err != nil {
+				t.Fatalf("embedded schema: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s is stale; bump the schema version, note it in History, and rerun with -update-schemas", path)
+			}
+		})
+	}
+}
+
+func TestReportSchemaHistoryMatchesVersion(t *testing.T) {
+	for _, s := range reportSchemas {
+		if len(s.History) == 0 {
+			t.Fatalf("%s: empty history", s.Name)
+		}
+		last := s.History[len(s.History)-1]
+		if !strings.HasPrefix(last, s.Version+":") {
+			t.Fatalf("%s: latest history entry %q does not describe version %s", s.Name, last, s.Version)
+		}
+	}
+}
+
+func TestDecodeLegacyReports(t *testing.T) {
+	var qc qcReport
+	decodeFixture(t, "qc-report", "qc_report_v0.json", &qc)
+	if qc.Total != 10 || qc.Written != 7 || qc.Dropped["duplicate_sequence"] != 1 {
+		t.Fatalf("unexpected qc report: %+v", qc)
+	}
+
+	var format formatReport
+	decodeFixture(t, "format-report", "format_report_v0.json", &format)
+	if format.Total != 5 || format.Written != 4 || format.MissingRanks != 1 {
+		t.Fatalf("unexpected format report: %+v", format)
+	}
+
+	var split splitReport
+	decodeFixture(t, "split-report", "split_report_v0.json", &split)
+	if split.Stats.TotalRecords != 20 || len(split.Classifiers) != 2 {
+		t.Fatalf("unexpected split report: %+v", split)
+	}
+
+	var curation bioscanCurationReport
+	decodeFixture(t, "curation-report", "curation_report_v0.json", &curation)
+	if curation.Protocol != extractCurationProtocolBioscan5M || curation.Stats.RowsTotal != 3 {
+		t.Fatalf("unexpected curation report: %+v", curation)
+	}
+
+	var manifest releaseManifest
+	decodeFixture(t, "manifest", "manifest_v0.json", &manifest)
+	if manifest.SnapshotID != "BOLD_Public.26-Sep-2025" || manifest.Counts.MarkerFastaSequences != 42 {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+}
+
+func TestDecodeReportRejectsNewerMajor(t *testing.T) {
+	var qc qcReport
+	err := decodeReport("qc-report", []byte(`{"schema_version":"2.0","total":1}`), &qc)
+	if err == nil {
+		t.Fatalf("expected incompatible major version error")
+	}
+	if err := decodeReport("qc-report", []byte(`{"schema_version":"1.7","total":1}`), &qc); err != nil {
+		t.Fatalf("newer minor version should decode: %v", err)
+	}
+	// A later minor version may add fields that are not drop counters.
+	later := `{"schema_version":"1.99","total":3,"too_short":2,"notes":"x","thresholds":{"min":5},"ratio":0.5,"extra":null}`
+	if err := decodeReport("qc-report", []byte(later), &qc); err != nil {
+		t.Fatalf("unknown fields should decode: %v", err)
+	}
+	if qc.Total != 3 || !maps.Equal(qc.Dropped, map[string]int{"too_short": 2}) {
+		t.Fatalf("unexpected qc report: %+v", qc)
+	}
+}
+
+func decodeFixture(t *testing.T, name, file string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "reports", file))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	if err := decodeReport(name, data, v); err != nil {
+		t.Fatalf("decode %s: %v", file, err)
+	}
+}
@@ -15,9 +15,31 @@ func Execute(args []string, version string) {
 		os.Exit(1)
 	}
 
+	switch args[0] {
+	case "version", "-v", "--version":
+		fmt.Println("boldkit", appVersion)
+		return
+	case "-h", "--help", "help":
+		printUsage()
+		return
+	}
+
+	run := startRunMetrics(args[0])
 	switch args[0] {
 	case "extract":
 		runExtract(args[1:])
+	case "taxdump":
+		runTaxdump(args[1:])
+	case "taxdump-diff":
+		runTaxdumpDiff(args[1:])
+	case "taxdump-subset":
+		runTaxdumpSubset(args[1:])
+	case "validate-taxdump":
+		runValidateTaxdump(args[1:])
+	case "lineage":
+		runLineage(args[1:])
+	case "ncbi-map":
+		runNCBIMap(args[1:])
 	case "markers":
 		runMarkers(args[1:])
 	case "package":
@@ -32,15 +54,30 @@ func Execute(args []string, version string) {
 		runQC(args[1:])
 	case "format":
 		runFormat(args[1:])
-	case "version", "-v", "--version":
-		fmt.Println("boldkit", appVersion)
-	case "-h", "--help", "help":
-		printUsage()
+	case "schema":
+		runSchema(args[1:])
+	case "bench":
+		runBench(args[1:])
+	case "redact":
+		runRedact(args[1:])
+	case "provenance":
+		runProvenance(args[1:])
+	case "head":
+		runHead(args[1:])
+	case "cache":
+		runCache(args[1:])
+	case "faidx":
+		runFaidx(args[1:])
+	case "subset":
+		runSubset(args[1:])
+	case "stats":
+		runStats(args[1:])
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", args[0])
 		printUsage()
 		os.Exit(1)
 	}
+	run.finish()
 }
 
 func printUsage() {
@@ -51,6 +88,12 @@ func printUsage() {
 	fmt.Fprintln(os.Stderr)
 	fmt.Fprintln(os.Stderr, "Commands:")
 	fmt.Fprintln(os.Stderr, "  extract    Build taxonkit_input.tsv")
+	fmt.Fprintln(os.Stderr, "  taxdump    Build an NCBI-style taxdump from taxonkit_input.tsv")
+	fmt.Fprintln(os.Stderr, "  taxdump-diff      Report taxonomy changes between two taxdumps")
+	fmt.Fprintln(os.Stderr, "  taxdump-subset    Cut a taxdump (and marker FASTAs) down to chosen subtrees")
+	fmt.Fprintln(os.Stderr, "  validate-taxdump  Check a taxdump's tree, ranks, names, and taxid.map")
+	fmt.Fprintln(os.Stderr, "  lineage    Export taxid -> ranked lineage TSV from a taxdump")
+	fmt.Fprintln(os.Stderr, "  ncbi-map   Map bold taxids onto NCBI taxids by scientific name")
 	fmt.Fprintln(os.Stderr, "  markers    Build per-marker FASTA files")
 	fmt.Fprintln(os.Stderr, "  package    Package release artifacts")
 	fmt.Fprintln(os.Stderr, "  pipeline   Full pipeline: extract -> taxdump -> markers -> package (optional)")
@@ -58,6 +101,15 @@ func printUsage() {
 	fmt.Fprintln(os.Stderr, "  split      QC + open/closed-world split + taxdump prune")
 	fmt.Fprintln(os.Stderr, "  qc         QC filter a FASTA against length/ambiguity/taxonomy rules")
 	fmt.Fprintln(os.Stderr, "  format     Generate classifier-specific FASTA/map outputs")
+	fmt.Fprintln(os.Stderr, "  faidx      Write a samtools .fai (and .gzi for bgzip) index of a FASTA")
+	fmt.Fprintln(os.Stderr, "  subset     Extract sequences by ID from an indexed FASTA")
+	fmt.Fprintln(os.Stderr, "  stats      Length, GC and N50 statistics of FASTA inputs")
+	fmt.Fprintln(os.Stderr, "  head       Print the first rows or the column schema of a BOLD input")
+	fmt.Fprintln(os.Stderr, "  bench      Benchmark parser options and recommend a tuning")
+	fmt.Fprintln(os.Stderr, "  redact     Drop, hash, or coarsen columns for shareable subsets")
+	fmt.Fprintln(os.Stderr, "  provenance Print the recorded history of one record")
+	fmt.Fprintln(os.Stderr, "  cache gc   Prune the derived-artifact cache (-cache-dir) to -max-size")
+	fmt.Fprintln(os.Stderr, "  schema     Print the JSON Schema of a report (qc-report, manifest, ...)")
 	fmt.Fprintln(os.Stderr)
 	fmt.Fprintln(os.Stderr, "Run 'boldkit <command> -h' for command-specific options.")
 }
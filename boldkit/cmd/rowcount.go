@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"os"
+	"time"
+)
+
+// rowCountSidecar caches RowCount next to a TSV input so later subcommands
+// size their progress bars without another pass over the file. It is keyed
+// by the input's size and modification time.
+type rowCountSidecar struct {
+	reportHeader
+	Size    int64  `json:"size"`
+	ModTime string `json:"mtime"`
+	Rows    int64  `json:"rows"`
+}
+
+func rowCountSidecarPath(input string) string {
+	return input + ".rowcount.json"
+}
+
+// cachedRowCount is RowCount that reuses and refreshes the sidecar. Parquet
+// inputs keep their count in the footer and are counted directly. A sidecar
+// that cannot be written only costs the next run a recount.
+func cachedRowCount(input string) (int64, error) {
+	info, err := os.Stat(input)
+	if err != nil || isParquetPath(input) {
+		return RowCount(input)
+	}
+	key := rowCountSidecar{Size: info.Size(), ModTime: info.ModTime().UTC().Format(time.RFC3339Nano)}
+	path := rowCountSidecarPath(input)
+	if data, err := os.ReadFile(path); err == nil {
+		var cached rowCountSidecar
+		if decodeReport("rowcount", data, &cached) == nil && cached.Size == key.Size && cached.ModTime == key.ModTime {
+			return cached.Rows, nil
+		}
+	}
+	rows, err := RowCount(input)
+	if err != nil {
+		return 0, err
+	}
+	key.reportHeader = newReportHeader("rowcount")
+	key.Rows = rows
+	if err := writeReportJSON(path, key); err != nil {
+		logf("WARNING row count not cached: %v", err)
+	}
+	return rows, nil
+}
+
+// progressTotal returns the row total for a progress bar over input: -1
+// without a bar or with -no-count, else the cached or counted rows.
+func progressTotal(input string, progressOn, noCount bool) int {
+	if !progressOn || noCount {
+		return -1
+	}
+	count, err := cachedRowCount(input)
+	if err != nil {
+		fatalf("count rows failed: %v", err)
+	}
+	return int(count)
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCachedRowCount(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "BOLD_Public.S1.tsv")
+	if err := os.WriteFile(input, []byte("processid\tnuc\nP1\tA\nP2\tC\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := cachedRowCount(input); err != nil || n != 2 {
+		t.Fatalf("rows=%d err=%v", n, err)
+	}
+	sidecar := rowCountSidecarPath(input)
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cached rowCountSidecar
+	if err := decodeReport("rowcount", data, &cached); err != nil || cached.Rows != 2 {
+		t.Fatalf("sidecar %s err=%v", data, err)
+	}
+
+	// An unchanged input is not recounted.
+	cached.Rows = 99
+	if err := writeReportJSON(sidecar, cached); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := cachedRowCount(input); n != 99 {
+		t.Fatalf("rows=%d, want the cached 99", n)
+	}
+
+	// Same size, new mtime: recounted.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := cachedRowCount(input); n != 2 {
+		t.Fatalf("rows=%d after touching the input", n)
+	}
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Bytes moved through openFile/createFile (and so every helper built on them).
+// Work done by child processes such as taxonkit is not included.
+var (
+	ioBytesRead    atomic.Int64
+	ioBytesWritten atomic.Int64
+)
+
+// resourceSample is a point-in-time reading of process counters.
+type resourceSample struct {
+	at      time.Time
+	user    time.Duration
+	system  time.Duration
+	read    int64
+	written int64
+}
+
+func sampleResources() resourceSample {
+	user, system := processCPUTimes()
+	return resourceSample{
+		at:      time.Now(),
+		user:    user,
+		system:  system,
+		read:    ioBytesRead.Load(),
+		written: ioBytesWritten.Load(),
+	}
+}
+
+// resourceUsage is the difference between two samples. Peak RSS is the
+// process high-water mark at the end of the interval, not per interval.
+type resourceUsage struct {
+	Stage            string  `json:"stage,omitempty"`
+	WallSeconds      float64 `json:"wall_seconds"`
+	UserCPUSeconds   float64 `json:"user_cpu_seconds"`
+	SystemCPUSeconds float64 `json:"system_cpu_seconds"`
+	PeakRSSBytes     int64   `json:"peak_rss_bytes"`
+	PeakRSSSource    string  `json:"peak_rss_source"`
+	BytesRead        int64   `json:"bytes_read"`
+	BytesWritten     int64   `json:"bytes_written"`
+	ReadMBPerSec     float64 `json:"read_mb_per_sec"`
+	WriteMBPerSec    float64 `json:"write_mb_per_sec"`
+}
+
+func usageBetween(stage string, from, to resourceSample) resourceUsage {
+	rss, source := peakRSS()
+	u := resourceUsage{
+		Stage:            stage,
+		WallSeconds:      to.at.Sub(from.at).Seconds(),
+		UserCPUSeconds:   (to.user - from.user).Seconds(),
+		SystemCPUSeconds: (to.system - from.system).Seconds(),
+		PeakRSSBytes:     rss,
+		PeakRSSSource:    source,
+		BytesRead:        to.read - from.read,
+		BytesWritten:     to.written - from.written,
+	}
+	if u.WallSeconds > 0 {
+		u.ReadMBPerSec = float64(u.BytesRead) / (1 << 20) / u.WallSeconds
+		u.WriteMBPerSec = float64(u.BytesWritten) / (1 << 20) / u.WallSeconds
+	}
+	return u
+}
+
+// runResources is embedded in JSON reports as "resources".
+type runResources struct {
+	Command string          `json:"command"`
+	Total   resourceUsage   `json:"total"`
+	Stages  []resourceUsage `json:"stages,omitempty"`
+}
+
+// runMetrics collects resource usage for one subcommand run. Execute starts
+// it; stages within the run (pipeline) record their own intervals.
+type runMetrics struct {
+	command string
+	start   resourceSample
+
+	mu     sync.Mutex
+	stages []resourceUsage
+}
+
+var activeRun atomic.Pointer[runMetrics]
+
+func startRunMetrics(command string) *runMetrics {
+	m := &runMetrics{command: command, start: sampleResources()}
+	activeRun.Store(m)
+	return m
+}
+
+func currentRun() *runMetrics {
+	return activeRun.Load()
+}
+
+// stage starts timing a named stage; call the returned func when it ends.
+// It is a no-op on a nil run (tests call stages without Execute).
+func (m *runMetrics) stage(name string) func() {
+	if m == nil {
+		return func() {}
+	}
+	from := sampleResources()
+	return func() {
+		u := usageBetween(name, from, sampleResources())
+		m.mu.Lock()
+		m.stages = append(m.stages, u)
+		m.mu.Unlock()
+	}
+}
+
+// resources returns usage so far, or nil when no run is active, so reports
+// written from tests stay deterministic.
+func (m *runMetrics) resources() *runResources {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	stages := append([]resourceUsage(nil), m.stages...)
+	m.mu.Unlock()
+	return &runResources{
+		Command: m.command,
+		Total:   usageBetween("", m.start, sampleResources()),
+		Stages:  stages,
+	}
+}
+
+// finish logs the run summary, with a per-stage table when stages were
+// recorded.
+func (m *runMetrics) finish() {
+	if m == nil {
+		return
+	}
+	activeRun.CompareAndSwap(m, nil)
+	r := m.resources()
+	if len(r.Stages) > 0 {
+		writeResourceTable(os.Stderr, r)
+	}
+	logf("%s: resources: %s", r.Command, r.Total.summary())
+}
+
+func (u resourceUsage) summary() string {
+	return fmt.Sprintf("wall=%s cpu=%s user + %s sys peak_rss=%s read=%s (%.1f MB/s) written=%s (%.1f MB/s)",
+		formatSeconds(u.WallSeconds),
+		formatSeconds(u.UserCPUSeconds),
+		formatSeconds(u.SystemCPUSeconds),
+		formatRSS(u.PeakRSSBytes, u.PeakRSSSource),
+		formatMiB(uint64(u.BytesRead)), u.ReadMBPerSec,
+		formatMiB(uint64(u.BytesWritten)), u.WriteMBPerSec,
+	)
+}
+
+func writeResourceTable(w io.Writer, r *runResources) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\twall\tuser\tsys\tpeak rss\tread\twritten\tMB/s in\tMB/s out\t")
+	row := func(name string, u resourceUsage) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%.1f\t%.1f\t\n",
+			name,
+			formatSeconds(u.WallSeconds),
+			formatSeconds(u.UserCPUSeconds),
+			formatSeconds(u.SystemCPUSeconds),
+			formatRSS(u.PeakRSSBytes, u.PeakRSSSource),
+			formatMiB(uint64(u.BytesRead)),
+			formatMiB(uint64(u.BytesWritten)),
+			u.ReadMBPerSec,
+			u.WriteMBPerSec,
+		)
+	}
+	for _, u := range r.Stages {
+		row(u.Stage, u)
+	}
+	row("total", r.Total)
+	_ = tw.Flush()
+}
+
+func formatSeconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+func formatRSS(bytes int64, source string) string {
+	if bytes <= 0 {
+		return "n/a"
+	}
+	if source != peakRSSSourceRusage {
+		return "~" + formatMiB(uint64(bytes))
+	}
+	return formatMiB(uint64(bytes))
+}
+
+// goRuntimePeak approximates peak RSS with the memory the Go runtime has
+// obtained from the OS. It is the fallback where getrusage is unavailable.
+func goRuntimePeak() (int64, string) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.Sys), "go-runtime"
+}
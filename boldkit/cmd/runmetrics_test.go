@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMeteredIOCountsBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.tsv")
+	payload := strings.Repeat("P1\tCOI-5P\tACGT\n", 1000)
+
+	before := sampleResources()
+	out, err := createOutput(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := io.WriteString(out, payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	in, err := openInput(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, in); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = in.Close()
+	u := usageBetween("", before, sampleResources())
+
+	want := int64(len(payload))
+	if u.BytesWritten < want || u.BytesRead < want {
+		t.Fatalf("read=%d written=%d want >= %d", u.BytesRead, u.BytesWritten, want)
+	}
+	if u.PeakRSSBytes <= 0 || u.PeakRSSSource == "" {
+		t.Fatalf("peak rss=%d source=%q", u.PeakRSSBytes, u.PeakRSSSource)
+	}
+}
+
+func TestRunMetricsStagesAndReports(t *testing.T) {
+	if got := currentRun().resources(); got != nil {
+		t.Fatalf("resources without a run = %+v", got)
+	}
+
+	run := startRunMetrics("qc")
+	t.Cleanup(func() { activeRun.Store(nil) })
+	done := run.stage("extract")
+	f, err := createFile(filepath.Join(t.TempDir(), "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(bytes.Repeat([]byte("A"), 4096)); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	done()
+	run.stage("markers")()
+
+	r := currentRun().resources()
+	if r == nil || r.Command != "qc" || len(r.Stages) != 2 {
+		t.Fatalf("resources=%+v", r)
+	}
+	if r.Stages[0].Stage != "extract" || r.Stages[0].BytesWritten != 4096 || r.Stages[1].Stage != "markers" {
+		t.Fatalf("stages=%+v", r.Stages)
+	}
+
+	var table strings.Builder
+	writeResourceTable(&table, r)
+	for _, want := range []string{"stage", "extract", "markers", "total"} {
+		if !strings.Contains(table.String(), want) {
+			t.Fatalf("table missing %q:\n%s", want, table.String())
+		}
+	}
+
+	// Reports written during a run carry the resources block and still
+	// decode against the current schema.
+	path := filepath.Join(t.TempDir(), "qc.json")
+	if err := writeQCReport(path, qcStats{Total: 3, Written: 2, Dropped: map[string]int{"too_short": 1}}, ""); err != nil {
+		t.Fatalf("write report: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report qcReport
+	if err := decodeReport("qc-report", raw, &report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Resources == nil || report.Resources.Command != "qc" || report.Dropped["too_short"] != 1 {
+		t.Fatalf("report=%+v", report)
+	}
+	if _, ok := report.Dropped["resources"]; ok {
+		t.Fatal("resources decoded as a drop counter")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package cmd
+
+import "time"
+
+const peakRSSSourceRusage = "getrusage"
+
+// processCPUTimes is unavailable without getrusage.
+func processCPUTimes() (user, system time.Duration) {
+	return 0, 0
+}
+
+func peakRSS() (int64, string) {
+	return goRuntimePeak()
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package cmd
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+const peakRSSSourceRusage = "getrusage"
+
+// processCPUTimes returns user and system CPU time of this process and its
+// waited-for children (taxonkit).
+func processCPUTimes() (user, system time.Duration) {
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var ru syscall.Rusage
+		if err := syscall.Getrusage(who, &ru); err != nil {
+			continue
+		}
+		user += time.Duration(ru.Utime.Nano())
+		system += time.Duration(ru.Stime.Nano())
+	}
+	return user, system
+}
+
+// peakRSS returns the process high-water resident set size in bytes.
+func peakRSS() (int64, string) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return goRuntimePeak()
+	}
+	maxrss := int64(ru.Maxrss)
+	// Darwin reports bytes; Linux and the BSDs report kilobytes.
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		maxrss *= 1024
+	}
+	return maxrss, peakRSSSourceRusage
+}
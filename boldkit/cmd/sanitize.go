@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// nameSanitizer reduces taxon and marker names to [A-Za-z0-9._-] for FASTA
+// headers, classifier taxonomies, and file names. The mode is independent of
+// the process locale.
+type nameSanitizer string
+
+const (
+	// sanitizeASCII replaces every other byte with '_' (the original
+	// behaviour; "münsteri" becomes "m__nsteri").
+	sanitizeASCII nameSanitizer = "ascii"
+	// sanitizeTranslit folds Latin diacritics to ASCII ("münsteri" becomes
+	// "munsteri") and collapses runs of replaced characters into one '_'.
+	sanitizeTranslit nameSanitizer = "translit"
+
+	defaultSanitizeMode = sanitizeTranslit
+)
+
+func parseSanitizeMode(s string) (nameSanitizer, error) {
+	switch m := nameSanitizer(s); m {
+	case sanitizeASCII, sanitizeTranslit:
+		return m, nil
+	case "":
+		return defaultSanitizeMode, nil
+	default:
+		return "", fmt.Errorf("unknown -sanitize mode %q (use ascii or translit)", s)
+	}
+}
+
+// latinFolds covers letters that have no canonical decomposition.
+var latinFolds = map[rune]string{
+	'ß': "ss", 'ẞ': "SS",
+	'æ': "ae", 'Æ': "AE",
+	'œ': "oe", 'Œ': "OE",
+	'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D",
+	'ð': "d", 'Ð': "D",
+	'ł': "l", 'Ł': "L",
+	'þ': "th", 'Þ': "Th",
+	'ı': "i",
+	'×': "x", // hybrid sign, as in "Salix × rubens"
+}
+
+func sanitizeKeep(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '.' || c == '_' || c == '-'
+}
+
+// appendName appends the sanitized form of src to dst.
+func (m nameSanitizer) appendName(dst []byte, src []byte) []byte {
+	if m == sanitizeASCII {
+		for _, c := range src {
+			if sanitizeKeep(c) {
+				dst = append(dst, c)
+			} else {
+				dst = append(dst, '_')
+			}
+		}
+		return dst
+	}
+
+	ascii := true
+	for _, c := range src {
+		if c >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if !ascii {
+		src = norm.NFD.Bytes(src)
+	}
+	replaced := false
+	for len(src) > 0 {
+		r, size := utf8.DecodeRune(src)
+		src = src[size:]
+		if r < utf8.RuneSelf && sanitizeKeep(byte(r)) {
+			dst = append(dst, byte(r))
+			replaced = false
+			continue
+		}
+		if fold, ok := latinFolds[r]; ok {
+			dst = append(dst, fold...)
+			replaced = false
+			continue
+		}
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if !replaced {
+			dst = append(dst, '_')
+			replaced = true
+		}
+	}
+	return dst
+}
+
+func (m nameSanitizer) taxon(name string) string {
+	if name == "" {
+		return ""
+	}
+	return string(m.appendName(make([]byte, 0, len(name)), []byte(name)))
+}
+
+// markerBytes sanitizes a marker code into dst's storage.
+func (m nameSanitizer) markerBytes(dst []byte, src []byte) string {
+	return string(m.appendName(dst[:0], src))
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import "testing"
+
+func TestNameSanitizer(t *testing.T) {
+	cases := []struct {
+		name     string
+		translit string
+		ascii    string
+	}{
+		{"Rhyacophila münsteri", "Rhyacophila_munsteri", "Rhyacophila_m__nsteri"},
+		{"Coenonympha glycerion bertolis", "Coenonympha_glycerion_bertolis", "Coenonympha_glycerion_bertolis"},
+		{"Ochlerotatus (Finlaya) köchi", "Ochlerotatus_Finlaya_kochi", "Ochlerotatus__Finlaya__k__chi"},
+		{"Dasineura pérez-iñiguezi", "Dasineura_perez-iniguezi", "Dasineura_p__rez-i__iguezi"},
+		{"Cryptocephalus ørstedi", "Cryptocephalus_orstedi", "Cryptocephalus___rstedi"},
+		{"Bombus (Alpinobombus) balteatus Dahlbom, 1832", "Bombus_Alpinobombus_balteatus_Dahlbom_1832", "Bombus__Alpinobombus__balteatus_Dahlbom__1832"},
+		{"Salix × rubens", "Salix_x_rubens", "Salix____rubens"},
+		{"Aedes straßburgeri", "Aedes_strassburgeri", "Aedes_stra__burgeri"},
+		{"Ceratophyllus łomnickii", "Ceratophyllus_lomnickii", "Ceratophyllus___omnickii"},
+		{"Æschnidae", "AEschnidae", "__schnidae"},
+		{"Lycaena ∂elta", "Lycaena_elta", "Lycaena____elta"},
+		{"Psychodidae sp. BOLD:AAA1234", "Psychodidae_sp._BOLD_AAA1234", "Psychodidae_sp._BOLD_AAA1234"},
+		{"", "", ""},
+	}
+	for _, tc := range cases {
+		if got := sanitizeTranslit.taxon(tc.name); got != tc.translit {
+			t.Errorf("translit %q = %q, want %q", tc.name, got, tc.translit)
+		}
+		if got := sanitizeASCII.taxon(tc.name); got != tc.ascii {
+			t.Errorf("ascii %q = %q, want %q", tc.name, got, tc.ascii)
+		}
+	}
+}
+
+func TestParseSanitizeMode(t *testing.T) {
+	if m, err := parseSanitizeMode(""); err != nil || m != sanitizeTranslit {
+		t.Fatalf("default = %q, %v", m, err)
+	}
+	if m, err := parseSanitizeMode("ascii"); err != nil || m != sanitizeASCII {
+		t.Fatalf("ascii = %q, %v", m, err)
+	}
+	if _, err := parseSanitizeMode("utf8"); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+func TestSanitizeMarkerBytes(t *testing.T) {
+	buf := make([]byte, 0, 4)
+	if got := sanitizeTranslit.markerBytes(buf, []byte("COI-5P")); got != "COI-5P" {
+		t.Fatalf("got %q", got)
+	}
+	if got := sanitizeTranslit.markerBytes(buf, []byte("matK / rbcL")); got != "matK_rbcL" {
+		t.Fatalf("got %q", got)
+	}
+	if got := sanitizeASCII.markerBytes(buf, []byte("matK / rbcL")); got != "matK___rbcL" {
+		t.Fatalf("got %q", got)
+	}
+}
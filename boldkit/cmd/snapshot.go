@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotID derives a snapshot ID from a BOLD input file name.
+func snapshotID(inputPath string) string {
+	base := filepath.Base(inputPath)
+	if strings.HasSuffix(base, ".parquet") {
+		return strings.TrimSuffix(base, ".parquet")
+	}
+	if strings.HasSuffix(base, ".parq") {
+		return strings.TrimSuffix(base, ".parq")
+	}
+	if strings.HasSuffix(base, ".tsv.gz") {
+		return strings.TrimSuffix(base, ".tsv.gz")
+	}
+	if strings.HasSuffix(base, ".tsv") {
+		return strings.TrimSuffix(base, ".tsv")
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// Default output names. Without a snapshot ID the stages keep these legacy
+// names; with one, snapshotPath tags them so that two snapshots processed in
+// one directory do not overwrite each other.
+const (
+	legacyTaxonkitOutput = "taxonkit_input.tsv"
+	legacyMarkerDir      = "marker_fastas"
+	legacyClassifyOutDir = "classifier_outputs"
+	legacyQCOutDir       = "qc"
+	legacySplitOutDir    = "libraries"
+)
+
+// addSnapshotFlag registers -snapshot-id; from names what the ID is derived
+// from when the flag is empty.
+func addSnapshotFlag(fs *flag.FlagSet, from string) *string {
+	return fs.String("snapshot-id", "", "Snapshot ID tagging default output names (default: derive from "+from+")")
+}
+
+// resolveSnapshot returns snap, or the ID snapshotID derives from a BOLD
+// input when snap is empty. An empty input or an unexpanded glob pattern
+// yields no ID.
+func resolveSnapshot(snap, input string) string {
+	if snap != "" || input == "" || strings.ContainsAny(input, "*?[") {
+		return snap
+	}
+	return snapshotID(input)
+}
+
+// markerSnapshot returns the snapshot ID of a marker FASTA or marker
+// directory written under snapshot-tagged names (marker_fastas.<snap>), or ""
+// for the legacy layout.
+func markerSnapshot(path string) string {
+	for _, name := range []string{filepath.Base(path), filepath.Base(filepath.Dir(path))} {
+		if snap, ok := strings.CutPrefix(name, legacyMarkerDir+"."); ok && snap != "" {
+			return snap
+		}
+	}
+	return ""
+}
+
+// markerStageSnapshot resolves the snapshot ID of a stage reading marker
+// FASTAs: snap when set, else the tag on input, or on markerDir when input is
+// empty.
+func markerStageSnapshot(snap, input, markerDir string) string {
+	switch {
+	case snap != "":
+		return snap
+	case input != "":
+		return markerSnapshot(input)
+	default:
+		return markerSnapshot(markerDir)
+	}
+}
+
+// taxonkitSnapshot returns the snapshot ID of a taxonkit TSV written under a
+// snapshot-tagged name (taxonkit_input.<snap>.tsv.gz), or "".
+func taxonkitSnapshot(path string) string {
+	rest, ok := strings.CutPrefix(filepath.Base(path), strings.TrimSuffix(legacyTaxonkitOutput, ".tsv")+".")
+	if !ok {
+		return ""
+	}
+	snap, ok := strings.CutSuffix(strings.TrimSuffix(rest, ".gz"), ".tsv")
+	if !ok {
+		return ""
+	}
+	return snap
+}
+
+// snapshotPath tags name with snap: taxonkit_input.tsv becomes
+// taxonkit_input.<snap>.tsv.gz and a directory gains a .<snap> suffix. It
+// returns name unchanged when snap is empty.
+func snapshotPath(name, snap string) string {
+	if snap == "" {
+		return name
+	}
+	tag := safeTag(snap)
+	if stem, ok := strings.CutSuffix(name, ".tsv"); ok {
+		return stem + "." + tag + ".tsv.gz"
+	}
+	return name + "." + tag
+}
+
+// hasSnapshotTag reports whether a file or directory name already carries
+// the tag snapshotPath adds.
+func hasSnapshotTag(name, snap string) bool {
+	if snap == "" {
+		return false
+	}
+	tag := "." + safeTag(snap)
+	return strings.HasSuffix(name, tag) || strings.Contains(name, tag+".")
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotPaths(t *testing.T) {
+	for _, tc := range []struct {
+		name, snap, want string
+	}{
+		{legacyTaxonkitOutput, "", "taxonkit_input.tsv"},
+		{legacyTaxonkitOutput, "BOLD_Public.19-Jul-2024", "taxonkit_input.BOLD_Public.19-Jul-2024.tsv.gz"},
+		{legacyMarkerDir, "BOLD_Public.19-Jul-2024", "marker_fastas.BOLD_Public.19-Jul-2024"},
+		{legacyClassifyOutDir, "snap 1", "classifier_outputs.snap_1"},
+		{legacyMarkerDir, "", "marker_fastas"},
+	} {
+		if got := snapshotPath(tc.name, tc.snap); got != tc.want {
+			t.Errorf("snapshotPath(%q, %q) = %q, want %q", tc.name, tc.snap, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		snap, input, want string
+	}{
+		{"", "data/BOLD_Public.19-Jul-2024.tsv", "BOLD_Public.19-Jul-2024"},
+		{"", "BOLD_Public.19-Jul-2024.parquet", "BOLD_Public.19-Jul-2024"},
+		{"given", "BOLD_Public.19-Jul-2024.tsv", "given"},
+		// The unexpanded default input names no snapshot.
+		{"", "BOLD_Public.*/BOLD_Public.*.tsv", ""},
+		{"", "", ""},
+	} {
+		if got := resolveSnapshot(tc.snap, tc.input); got != tc.want {
+			t.Errorf("resolveSnapshot(%q, %q) = %q, want %q", tc.snap, tc.input, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		snap, input, markerDir, want string
+	}{
+		{"", "marker_fastas.S1/COI-5P.fasta.gz", "", "S1"},
+		{"", "marker_fastas/COI-5P.fasta.gz", "", ""},
+		{"", "", "out/marker_fastas.S2", "S2"},
+		{"", "", "", ""},
+		{"given", "marker_fastas.S1/COI-5P.fasta.gz", "", "given"},
+	} {
+		if got := markerStageSnapshot(tc.snap, tc.input, tc.markerDir); got != tc.want {
+			t.Errorf("markerStageSnapshot(%q, %q, %q) = %q, want %q", tc.snap, tc.input, tc.markerDir, got, tc.want)
+		}
+	}
+
+	for path, want := range map[string]string{
+		"taxonkit_input.S1.tsv.gz":     "S1",
+		"dir/taxonkit_input.S1.tsv":    "S1",
+		"taxonkit_input.tsv":           "",
+		"taxonkit_input.tsv.gz":        "",
+		"other_input.S1.tsv.gz":        "",
+		"taxonkit_input.a.b.c.tsv.gz":  "a.b.c",
+		"taxonkit_input.S1.parquet.gz": "",
+	} {
+		if got := taxonkitSnapshot(path); got != want {
+			t.Errorf("taxonkitSnapshot(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// Release names must not repeat a snapshot tag the stage outputs already
+// carry, and keep tagging legacy names as before.
+func TestPackagePathsSnapshotTag(t *testing.T) {
+	snap := "S1"
+	for _, tc := range []struct {
+		got, want string
+	}{
+		{packageTaxonkitGzipPath(snapshotPath(legacyTaxonkitOutput, snap), "rel", snap), filepath.Join("rel", "taxonkit_input.S1.tsv.gz")},
+		{packageTaxonkitGzipPath(legacyTaxonkitOutput, "rel", snap), filepath.Join("rel", "taxonkit_input.S1.tsv.gz")},
+		{packageTaxonkitPath(legacyTaxonkitOutput, "rel", snap), filepath.Join("rel", "taxonkit_input.S1.tsv")},
+		{packageMarkerPath(snapshotPath(legacyMarkerDir, snap), "rel", snap), filepath.Join("rel", "marker_fastas.S1.tar.gz")},
+		{packageMarkerPath(legacyMarkerDir, "rel", snap), filepath.Join("rel", "marker_fastas.S1.tar.gz")},
+		{packageMarkerPath(legacyMarkerDir, "rel", ""), filepath.Join("rel", "marker_fastas.tar.gz")},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("got %q, want %q", tc.got, tc.want)
+		}
+	}
+}
+
+func TestBuildTaxonkitGzipOutput(t *testing.T) {
+	tmp := t.TempDir()
+	input := filepath.Join(tmp, "BOLD_Public.S1.tsv")
+	content := "processid\tbin_uri\tkingdom\tphylum\tclass\torder\tfamily\tsubfamily\ttribe\tgenus\tspecies\n" +
+		"P1\tBOLD:A\tAnimalia\tChordata\tMammalia\tCarnivora\tCanidae\t\t\tCanis\tCanis lupus\n"
+	if err := os.WriteFile(input, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(tmp, snapshotPath(legacyTaxonkitOutput, resolveSnapshot("", input)))
+	if _, err := buildTaxonkit(context.Background(), input, output, 0, -1, extractCurationConfig{}.normalized(), inputConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(output) != "taxonkit_input.BOLD_Public.S1.tsv.gz" {
+		t.Fatalf("output %s", output)
+	}
+	in, err := openInput(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	data, err := io.ReadAll(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "\tCanis\tCanis lupus\tP1\n") {
+		t.Fatalf("output:\n%s", data)
+	}
+}
+
+func TestQCReportSnapshotID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qc.json")
+	if err := writeQCReport(path, qcStats{Total: 1, Written: 1, Dropped: map[string]int{}}, "S1"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report qcReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Snapshot != "S1" {
+		t.Fatalf("snapshot_id %q in %s", report.Snapshot, data)
+	}
+	if _, ok := report.Dropped["snapshot_id"]; ok {
+		t.Fatal("snapshot_id parsed as a drop counter")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package cmd
+
+import "os"
+
+// mapRunFile is unavailable without mmap; runs are read with ReadAt.
+func mapRunFile(*os.File, int64) ([]byte, error) {
+	return nil, nil
+}
+
+func unmapRunFile([]byte) error {
+	return nil
+}
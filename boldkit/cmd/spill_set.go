@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/zeebo/xxh3"
+)
+
+const (
+	// defaultDedupeSpillBudget is the in-memory budget of a spilling dedupe
+	// set when -dedupe-spill is given without -mem-limit.
+	defaultDedupeSpillBudget = 256 << 20
+	// spillEntryBytes approximates the memory of one digest in the in-memory
+	// map, including map overhead.
+	spillEntryBytes = 48
+	// spillMaxRuns is how many on-disk runs accumulate before they are
+	// merged into one; every membership check probes each run.
+	spillMaxRuns = 8
+)
+
+// dedupeSet remembers keys for qc's duplicate filters.
+type dedupeSet interface {
+	// add inserts key and reports whether it was already present.
+	add(key string) bool
+}
+
+// memSet keeps the keys themselves; it is the default for sequence IDs,
+// which are about as short as a digest.
+type memSet map[string]struct{}
+
+func (s memSet) add(key string) bool {
+	if _, ok := s[key]; ok {
+		return true
+	}
+	s[key] = struct{}{}
+	return false
+}
+
+// hashSet keeps 128-bit xxh3 digests instead of the keys: 16 bytes plus map
+// overhead per key, against some 650 for a COI-5P sequence. It is the
+// default for sequences. Two distinct keys share a digest with probability
+// 2^-128, so the chance of any false duplicate among n keys is about
+// n^2 / 2^129: 5e-26 for 6M sequences.
+type hashSet struct {
+	hash   func(string) xxh3.Uint128
+	hashes map[xxh3.Uint128]struct{}
+}
+
+func newHashSet() *hashSet {
+	return &hashSet{hash: xxh3.HashString128, hashes: make(map[xxh3.Uint128]struct{})}
+}
+
+func (s *hashSet) add(key string) bool {
+	h := s.hash(key)
+	if _, ok := s.hashes[h]; ok {
+		return true
+	}
+	s.hashes[h] = struct{}{}
+	return false
+}
+
+// exactSet is a hashSet that verifies every digest match against the key
+// (-dedupe-exact). Each digest keeps the first key that produced it, so
+// memory is that of memSet; a different key with the same digest goes to
+// the collision side table, which in practice stays empty.
+type exactSet struct {
+	hash       func(string) xxh3.Uint128
+	first      map[xxh3.Uint128]string
+	collisions map[string]struct{}
+}
+
+func newExactSet() *exactSet {
+	return &exactSet{hash: xxh3.HashString128, first: make(map[xxh3.Uint128]string), collisions: make(map[string]struct{})}
+}
+
+func (s *exactSet) add(key string) bool {
+	h := s.hash(key)
+	first, ok := s.first[h]
+	if !ok {
+		s.first[h] = key
+		return false
+	}
+	if first == key {
+		return true
+	}
+	if _, ok := s.collisions[key]; ok {
+		return true
+	}
+	s.collisions[key] = struct{}{}
+	return false
+}
+
+type spillKey [16]byte
+
+// spillSet keeps 128-bit SHA-256 prefixes of its keys. Up to a budget they
+// sit in a hash map; past it the map is sorted and written to a run file
+// under dir, and lookups binary-search the runs (memory-mapped where the
+// platform allows). Runs are merged once spillMaxRuns accumulate. Membership
+// does not depend on when spills happen, so results match memSet.
+type spillSet struct {
+	dir    string
+	name   string
+	maxMem int
+	mem    map[spillKey]struct{}
+	runs   []*spillRun
+	seq    int
+	err    error
+
+	spills      int
+	compactions int
+}
+
+func newSpillSet(dir, name string, budget int64) *spillSet {
+	maxMem := int(budget / spillEntryBytes)
+	if maxMem < 1 {
+		maxMem = 1
+	}
+	return &spillSet{dir: dir, name: name, maxMem: maxMem, mem: make(map[spillKey]struct{})}
+}
+
+func spillDigest(key string) spillKey {
+	sum := sha256.Sum256([]byte(key))
+	var k spillKey
+	copy(k[:], sum[:])
+	return k
+}
+
+// add implements dedupeSet. A failed spill keeps the keys in memory; the
+// error is reported by Err.
+func (s *spillSet) add(key string) bool {
+	k := spillDigest(key)
+	if _, ok := s.mem[k]; ok {
+		return true
+	}
+	for _, r := range s.runs {
+		if r.contains(k) {
+			return true
+		}
+	}
+	s.mem[k] = struct{}{}
+	if len(s.mem) >= s.maxMem && s.err == nil {
+		s.err = s.spill()
+	}
+	return false
+}
+
+// Err returns the first spill error.
+func (s *spillSet) Err() error {
+	return s.err
+}
+
+func (s *spillSet) spill() error {
+	keys := make([]spillKey, 0, len(s.mem))
+	for k := range s.mem {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+	run, err := s.writeRun(len(keys), func(i int) spillKey { return keys[i] })
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	s.mem = make(map[spillKey]struct{})
+	s.spills++
+	if len(s.runs) >= spillMaxRuns {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact merges every run into one. Runs never share a key, since add
+// checks them before inserting.
+func (s *spillSet) compact() error {
+	total := 0
+	for _, r := range s.runs {
+		total += r.n
+	}
+	pos := make([]int, len(s.runs))
+	var readErr error
+	next := func(int) spillKey {
+		best := -1
+		var bestKey spillKey
+		for i, r := range s.runs {
+			if pos[i] >= r.n {
+				continue
+			}
+			k, err := r.key(pos[i])
+			if err != nil && readErr == nil {
+				readErr = err
+			}
+			if best < 0 || bytes.Compare(k[:], bestKey[:]) < 0 {
+				best, bestKey = i, k
+			}
+		}
+		pos[best]++
+		return bestKey
+	}
+	merged, err := s.writeRun(total, next)
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		if merged != nil {
+			_ = merged.close()
+		}
+		return err
+	}
+	for _, r := range s.runs {
+		if err := r.close(); err != nil {
+			return err
+		}
+	}
+	s.runs = []*spillRun{merged}
+	s.compactions++
+	return nil
+}
+
+// writeRun writes n sorted keys from key to a new run file and opens it.
+func (s *spillSet) writeRun(n int, key func(i int) spillKey) (*spillRun, error) {
+	path := filepath.Join(s.dir, fmt.Sprintf("%s-%04d.run", s.name, s.seq))
+	s.seq++
+	f, err := createFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("create spill run: %w", err)
+	}
+	run := &spillRun{path: path, n: n}
+	w := bufio.NewWriterSize(f, 1<<20)
+	bucket := 0
+	for i := 0; i < n; i++ {
+		k := key(i)
+		for bucket <= int(k[0]) {
+			run.index[bucket] = i
+			bucket++
+		}
+		if _, err := w.Write(k[:]); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("write spill run: %w", err)
+		}
+	}
+	for ; bucket < len(run.index); bucket++ {
+		run.index[bucket] = n
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("write spill run: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("close spill run: %w", err)
+	}
+	if err := run.open(); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Close releases and removes the run files.
+func (s *spillSet) Close() error {
+	var first error
+	for _, r := range s.runs {
+		if err := r.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.runs = nil
+	return first
+}
+
+// spillRun is one sorted run of keys. index[b] is the position of the first
+// key whose leading byte is >= b, so a lookup only searches its bucket.
+type spillRun struct {
+	path  string
+	n     int
+	index [257]int
+	file  *os.File
+	data  []byte // mapped file, or nil to read through file
+}
+
+func (r *spillRun) open() error {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return fmt.Errorf("open spill run: %w", err)
+	}
+	data, err := mapRunFile(f, int64(r.n)*int64(len(spillKey{})))
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("map spill run: %w", err)
+	}
+	r.file, r.data = f, data
+	return nil
+}
+
+func (r *spillRun) key(i int) (spillKey, error) {
+	var k spillKey
+	off := i * len(k)
+	if r.data != nil {
+		copy(k[:], r.data[off:off+len(k)])
+		return k, nil
+	}
+	if _, err := r.file.ReadAt(k[:], int64(off)); err != nil {
+		return k, fmt.Errorf("read spill run: %w", err)
+	}
+	return k, nil
+}
+
+// contains reports whether k is in the run. A read error counts as absent;
+// it can only happen on the unmapped fallback.
+func (r *spillRun) contains(k spillKey) bool {
+	lo, hi := r.index[k[0]], r.index[int(k[0])+1]
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		got, err := r.key(mid)
+		if err != nil {
+			return false
+		}
+		switch c := bytes.Compare(got[:], k[:]); {
+		case c == 0:
+			return true
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false
+}
+
+func (r *spillRun) close() error {
+	err := unmapRunFile(r.data)
+	r.data = nil
+	if cerr := r.file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(r.path); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
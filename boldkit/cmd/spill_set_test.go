@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/xxh3"
+)
+
+func TestSpillSetMatchesMemory(t *testing.T) {
+	dir := t.TempDir()
+	// Sixteen keys in memory per run forces spills and, every eight
+	// runs, a compaction.
+	spill := newSpillSet(dir, "test", 16*spillEntryBytes)
+	mem := memSet{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("K%d", rng.Intn(2000))
+		if got, want := spill.add(key), mem.add(key); got != want {
+			t.Fatalf("add %d (%s): spill=%v memory=%v", i, key, got, want)
+		}
+	}
+	if err := spill.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if spill.spills == 0 || spill.compactions == 0 {
+		t.Fatalf("spills=%d compactions=%d", spill.spills, spill.compactions)
+	}
+
+	// The ReadAt fallback used where runs cannot be mapped answers the same.
+	for _, r := range spill.runs {
+		if err := unmapRunFile(r.data); err != nil {
+			t.Fatal(err)
+		}
+		r.data = nil
+	}
+	for key := range mem {
+		if !spill.add(key) {
+			t.Fatalf("%s missing after unmap", key)
+		}
+	}
+
+	if err := spill.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("run files left behind: %d", len(entries))
+	}
+}
+
+func TestQCDedupeSpillMatchesMemory(t *testing.T) {
+	// A one-byte limit shared by both sets still spills on every key.
+	env := &QCFilterEnv{cfg: qcConfig{DedupeSeqs: true, DedupeIDs: true, DedupeMemLimit: 1}}
+	if got := env.spillBudget(); got != 1 {
+		t.Fatalf("spill budget %d, want 1", got)
+	}
+
+	tmp := t.TempDir()
+	writeTaxdumpFixture(t, tmp)
+	input := filepath.Join(tmp, "in.fasta")
+	if err := os.WriteFile(input, []byte(qcFixtureFasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var outputs [2][]byte
+	for i, limit := range []int64{0, 1} {
+		outDir := filepath.Join(tmp, fmt.Sprint("out", i))
+		cfg := qcConfig{
+			MaxN:           -1,
+			MaxAmbig:       -1,
+			DedupeSeqs:     true,
+			DedupeIDs:      true,
+			RequireRanks:   splitList("kingdom,phylum,class,order,family,genus,species"),
+			TaxdumpDir:     tmp,
+			OutputPath:     filepath.Join(outDir, "qc.fasta"),
+			DedupeMemLimit: limit,
+		}
+		if err := qcFasta(context.Background(), input, cfg); err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(cfg.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[i] = out
+		if entries, _ := os.ReadDir(outDir); len(entries) != 1 {
+			t.Fatalf("limit %d: scratch left in output dir: %v", limit, entries)
+		}
+	}
+	if string(outputs[0]) != string(outputs[1]) {
+		t.Fatalf("spilled output differs:\n got %q\nwant %q", outputs[1], outputs[0])
+	}
+}
+
+// BenchmarkDedupeSet documents the cost of spilling: hashing every key, then
+// probing the on-disk runs once the budget is exceeded. Half the adds are
+// repeats.
+func BenchmarkDedupeSet(b *testing.B) {
+	const distinct = 200_000
+	keys := make([]string, 2*distinct)
+	rng := rand.New(rand.NewSource(1))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%0650d", rng.Intn(distinct))
+	}
+	for _, bc := range []struct {
+		name   string
+		budget int64
+	}{
+		{name: "memory"},
+		{name: "spill-unbounded", budget: 1 << 40},
+		{name: "spill-1MiB", budget: 1 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var set dedupeSet = memSet{}
+			var spill *spillSet
+			for i := 0; i < b.N; i++ {
+				if i%len(keys) == 0 {
+					if spill != nil {
+						_ = spill.Close()
+					}
+					if bc.budget > 0 {
+						spill = newSpillSet(b.TempDir(), "bench", bc.budget)
+						set = spill
+					} else {
+						set = memSet{}
+					}
+				}
+				set.add(keys[i%len(keys)])
+			}
+			if spill != nil {
+				_ = spill.Close()
+			}
+		})
+	}
+}
+
+func TestHashedDedupeSets(t *testing.T) {
+	// Near duplicates: one substitution, one base appended or removed.
+	base := strings.Repeat("ACGTTGCA", 80)
+	keys := []string{base, "T" + base[1:], base + "A", base[:len(base)-1], base}
+	want := []bool{false, false, false, false, true}
+	for name, set := range map[string]dedupeSet{"hash": newHashSet(), "exact": newExactSet()} {
+		for i, key := range keys {
+			if got := set.add(key); got != want[i] {
+				t.Errorf("%s: add %d = %v, want %v", name, i, got, want[i])
+			}
+		}
+	}
+
+	// A crafted digest that ignores the first base makes the first two keys
+	// collide: the hash set takes the second for a duplicate, the exact set
+	// verifies it and keeps it in the collision side table.
+	collide := func(key string) xxh3.Uint128 { return xxh3.HashString128(key[1:]) }
+	hashed, exact := newHashSet(), newExactSet()
+	hashed.hash, exact.hash = collide, collide
+	for i, tc := range []struct {
+		key          string
+		hashed, dupe bool
+	}{
+		{base, false, false},
+		{"T" + base[1:], true, false},
+		{"T" + base[1:], true, true},
+		{base, true, true},
+	} {
+		if got := hashed.add(tc.key); got != tc.hashed {
+			t.Errorf("hash set add %d = %v, want %v", i, got, tc.hashed)
+		}
+		if got := exact.add(tc.key); got != tc.dupe {
+			t.Errorf("exact set add %d = %v, want %v", i, got, tc.dupe)
+		}
+	}
+	if len(exact.collisions) != 1 {
+		t.Fatalf("collision side table holds %d keys, want 1", len(exact.collisions))
+	}
+}
+
+// BenchmarkDedupeSetMemory reports the heap retained per distinct 650 bp
+// sequence by each in-memory dedupe set. Scaled to 6M COI-5P sequences,
+// memSet and exactSet need over 4 GiB (about 740 bytes each) and hashSet
+// about 0.2 GiB (35 bytes each).
+func BenchmarkDedupeSetMemory(b *testing.B) {
+	const n = 100_000
+	rng := rand.New(rand.NewSource(1))
+	seq := []byte(strings.Repeat("ACGT", 650/4+1)[:650])
+	keys := make([]string, n)
+	for i := range keys {
+		for j := 0; j < 16; j++ {
+			seq[rng.Intn(len(seq))] = "ACGT"[rng.Intn(4)]
+		}
+		keys[i] = string(seq)
+	}
+	for _, bc := range []struct {
+		name string
+		set  func() dedupeSet
+	}{
+		{name: "memory", set: func() dedupeSet { return memSet{} }},
+		{name: "hash", set: func() dedupeSet { return newHashSet() }},
+		{name: "exact", set: func() dedupeSet { return newExactSet() }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var perKey float64
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				set := bc.set()
+				for _, k := range keys {
+					// A fresh copy per key, as qc converts each cleaned
+					// sequence, so sets that keep keys retain the bytes.
+					set.add(string([]byte(k)))
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				perKey = float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)) / n
+				runtime.KeepAlive(set)
+			}
+			b.ReportMetric(perKey, "B/seq")
+		})
+	}
+}
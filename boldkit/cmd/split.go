@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"flag"
@@ -40,11 +41,14 @@ type splitStats struct {
 }
 
 type splitReport struct {
-	Input       string     `json:"input"`
-	OutDir      string     `json:"out_dir"`
-	Classifiers []string   `json:"classifiers"`
-	PrunedTaxa  int        `json:"pruned_taxids"`
-	Stats       splitStats `json:"stats"`
+	reportHeader
+	Input       string        `json:"input"`
+	OutDir      string        `json:"out_dir"`
+	Classifiers []string      `json:"classifiers"`
+	PrunedTaxa  int           `json:"pruned_taxids"`
+	Stats       splitStats    `json:"stats"`
+	Sanitize    nameSanitizer `json:"sanitize"`
+	Resources   *runResources `json:"resources,omitempty"`
 }
 
 type splitQCConfig struct {
@@ -83,14 +87,15 @@ type splitTarget struct {
 
 func runSplit(args []string) {
 	fs := flag.NewFlagSet("split", flag.ExitOnError)
-	input := fs.String("input", "", "Input FASTA/FASTA.gz")
-	outDir := fs.String("outdir", "libraries", "Output directory")
-	markerDir := fs.String("marker-dir", "marker_fastas", "Marker FASTA directory (used when -input is empty)")
+	input := fs.String("input", "", "Input FASTA, plain or gzip/bgzip (- reads stdin)")
+	outDir := fs.String("outdir", "", "Output directory (default: libraries.<snapshot>, or "+legacySplitOutDir+" without a snapshot ID)")
+	markerDir := fs.String("marker-dir", "", "Marker FASTA directory used when -input is empty (default: marker_fastas.<snapshot>, or "+legacyMarkerDir+" without a snapshot ID)")
+	snapshot := addSnapshotFlag(fs, "a marker_fastas.<snapshot> -input or -marker-dir")
 	markers := fs.String("markers", "COI-5P", "Comma-separated markers to process (used when -input is empty)")
 	classifiers := fs.String("classifier", "blast,kraken2,sintax", "Comma-separated classifiers for final reference formatting")
 	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Taxdump directory with nodes.dmp/names.dmp/taxid.map")
 	taxidMap := fs.String("taxid-map", "", "Optional taxid.map override")
-	taxonkitIn := fs.String("taxonkit-input", "taxonkit_input.tsv", "Taxonkit TSV with processid/species labels")
+	taxonkitIn := fs.String("taxonkit-input", "", "Taxonkit TSV with processid/species labels (default: taxonkit_input.<snapshot>.tsv.gz, or "+legacyTaxonkitOutput+" without a snapshot ID)")
 	requireRanks := fs.String("require-ranks", "kingdom,phylum,class,order,family,genus,species", "Comma-separated ranks required to keep a sequence (empty disables)")
 	runQC := fs.Bool("run-qc", true, "Run QC before splitting")
 	qcMin := fs.Int("qc-min-length", 200, "QC minimum cleaned length")
@@ -102,15 +107,35 @@ func runSplit(args []string) {
 	qcDedupeIDs := fs.Bool("qc-dedupe-ids", true, "QC drop duplicate IDs")
 	qcProgress := fs.Bool("qc-progress", true, "Show QC progress bar (approximate)")
 	formatProgress := fs.Bool("format-progress", true, "Show format progress bar (approximate)")
+	sanitize := fs.String("sanitize", string(defaultSanitizeMode), "Taxon/marker name sanitation: translit (fold diacritics to ASCII) or ascii (replace every non-ASCII byte)")
+	provenanceDir := fs.String("provenance-dir", "", "Record per-record provenance events under this directory (one subdirectory per marker when -input is empty)")
 	if err := fs.Parse(args); err != nil {
 		fatalf("parse args failed: %v", err)
 	}
 
+	snap := markerStageSnapshot(*snapshot, *input, *markerDir)
+	if *markerDir == "" {
+		*markerDir = snapshotPath(legacyMarkerDir, snap)
+	}
+	if *taxonkitIn == "" {
+		*taxonkitIn = snapshotPath(legacyTaxonkitOutput, snap)
+	}
+	if *outDir == "" {
+		*outDir = snapshotPath(legacySplitOutDir, snap)
+	}
+
 	ranks := splitList(*requireRanks)
 	classifierList := splitList(*classifiers)
 	if len(classifierList) == 0 {
 		fatalf("classifier must not be empty")
 	}
+	if _, err := lookupClassifiers(classifierList); err != nil {
+		fatalf("%v", err)
+	}
+	sanitizeMode, err := parseSanitizeMode(*sanitize)
+	if err != nil {
+		fatalf("%v", err)
+	}
 	qcCfg := splitQCConfig{
 		Enabled:    *runQC,
 		MinLen:     *qcMin,
@@ -123,6 +148,19 @@ func runSplit(args []string) {
 		Progress:   *qcProgress,
 	}
 
+	ctx, stop := interruptContext()
+	defer stop()
+	stageInput := *input
+	if stageInput == "" {
+		stageInput = *markerDir
+	}
+	// failed records the interrupted or failed run before exiting.
+	failed := func(err error, format string, args ...any) {
+		err = recordStage(*outDir, "split", stageInput, err)
+		exitIfInterrupted(err)
+		fatalf(format, append(args, err)...)
+	}
+
 	if *input == "" {
 		markerList := splitList(*markers)
 		if len(markerList) == 0 {
@@ -134,43 +172,52 @@ func runSplit(args []string) {
 				fatalf("marker %s: %v", marker, err)
 			}
 			baseOut := filepath.Join(*outDir, safeTag(marker))
-			if err := splitOne(markerInput, baseOut, *taxonkitIn, ranks, classifierList, *taxdumpDir, *taxidMap, qcCfg, *formatProgress); err != nil {
-				fatalf("split %s failed: %v", marker, err)
+			if err := splitOne(ctx, markerInput, baseOut, *taxonkitIn, ranks, classifierList, *taxdumpDir, *taxidMap, qcCfg, *formatProgress, sanitizeMode, markerProvenanceDir(*provenanceDir, marker)); err != nil {
+				failed(err, "split %s failed: %v", marker)
 			}
 		}
-		return
+	} else if err := splitOne(ctx, *input, *outDir, *taxonkitIn, ranks, classifierList, *taxdumpDir, *taxidMap, qcCfg, *formatProgress, sanitizeMode, *provenanceDir); err != nil {
+		failed(err, "split failed: %v")
 	}
-
-	if err := splitOne(*input, *outDir, *taxonkitIn, ranks, classifierList, *taxdumpDir, *taxidMap, qcCfg, *formatProgress); err != nil {
-		fatalf("split failed: %v", err)
+	if err := recordStage(*outDir, "split", stageInput, nil); err != nil {
+		fatalf("%v", err)
 	}
 }
 
-func splitOne(input, outDir, taxonkitIn string, ranks, classifiers []string, taxdumpDir, taxidMap string, qcCfg splitQCConfig, formatProgress bool) error {
+func splitOne(ctx context.Context, input, outDir, taxonkitIn string, ranks, classifiers []string, taxdumpDir, taxidMap string, qcCfg splitQCConfig, formatProgress bool, sanitize nameSanitizer, provenanceDir string) error {
 	splitInput := input
 	if qcCfg.Enabled {
 		qcOut := filepath.Join(outDir, "qc", qcBaseName(input)+".fasta")
 		logf("split: QC -> %s", qcOut)
-		if err := qcFasta(input, qcConfig{
-			MinLen:       qcCfg.MinLen,
-			MaxLen:       qcCfg.MaxLen,
-			MaxN:         qcCfg.MaxN,
-			MaxAmbig:     qcCfg.MaxAmbig,
-			MaxInvalid:   qcCfg.MaxInvalid,
-			DedupeSeqs:   qcCfg.DedupeSeqs,
-			DedupeIDs:    qcCfg.DedupeIDs,
-			RequireRanks: ranks,
-			TaxdumpDir:   taxdumpDir,
-			TaxidMapPath: taxidMap,
-			OutputPath:   qcOut,
-			Progress:     qcCfg.Progress,
+		if err := qcFasta(ctx, input, qcConfig{
+			MinLen:        qcCfg.MinLen,
+			MaxLen:        qcCfg.MaxLen,
+			MaxN:          qcCfg.MaxN,
+			MaxAmbig:      qcCfg.MaxAmbig,
+			MaxInvalid:    qcCfg.MaxInvalid,
+			DedupeSeqs:    qcCfg.DedupeSeqs,
+			DedupeIDs:     qcCfg.DedupeIDs,
+			RequireRanks:  ranks,
+			TaxdumpDir:    taxdumpDir,
+			TaxidMapPath:  taxidMap,
+			OutputPath:    qcOut,
+			MaxErrors:     -1,
+			Progress:      qcCfg.Progress,
+			ProvenanceDir: provenanceDir,
 		}); err != nil {
 			return fmt.Errorf("qc failed: %w", err)
 		}
 		splitInput = qcOut
 	}
+	// The passes below each read the input; without QC standard input is
+	// spooled to a file first.
+	readInput, cleanup, err := spoolStdin(splitInput)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
-	fastaIDs, err := collectFastaIDs(splitInput)
+	fastaIDs, err := collectFastaIDs(ctx, readInput)
 	if err != nil {
 		return err
 	}
@@ -179,12 +226,12 @@ func splitOne(input, outDir, taxonkitIn string, ranks, classifiers []string, tax
 		return err
 	}
 
-	plan, stats, err := buildSplitPlan(splitInput, labels, invalidIDs)
+	plan, stats, err := buildSplitPlan(ctx, readInput, labels, invalidIDs)
 	if err != nil {
 		return err
 	}
 
-	writeStats, seenTrainIDs, err := writeSplitFastas(splitInput, outDir, plan, labels)
+	writeStats, seenTrainIDs, err := writeSplitFastas(ctx, readInput, outDir, plan, labels, provenanceDir)
 	if err != nil {
 		return err
 	}
@@ -205,14 +252,16 @@ func splitOne(input, outDir, taxonkitIn string, ranks, classifiers []string, tax
 	seenTrain := filepath.Join(outDir, "seen_train.fasta")
 	formatOut := filepath.Join(outDir, "formatted")
 	logf("split: format references from %s -> %s", seenTrain, formatOut)
-	if err := formatFasta(formatConfig{
-		Classifiers:  classifiers,
-		RequireRanks: ranks,
-		Input:        seenTrain,
-		OutDir:       formatOut,
-		TaxdumpDir:   prunedDir,
-		TaxidMapPath: filepath.Join(prunedDir, "taxid.map"),
-		Progress:     formatProgress,
+	if err := formatFasta(ctx, formatConfig{
+		Classifiers:   classifiers,
+		RequireRanks:  ranks,
+		Input:         seenTrain,
+		OutDir:        formatOut,
+		TaxdumpDir:    prunedDir,
+		TaxidMapPath:  filepath.Join(prunedDir, "taxid.map"),
+		Progress:      formatProgress,
+		Sanitize:      sanitize,
+		ProvenanceDir: provenanceDir,
 	}); err != nil {
 		return fmt.Errorf("format references: %w", err)
 	}
@@ -221,11 +270,14 @@ func splitOne(input, outDir, taxonkitIn string, ranks, classifiers []string, tax
 	logf("split: pruned taxdump -> %s (kept_taxids=%d)", prunedDir, keptTaxids)
 	reportPath := filepath.Join(outDir, "split_report.json")
 	if err := writeSplitReport(reportPath, splitReport{
-		Input:       splitInput,
-		OutDir:      outDir,
-		Classifiers: classifiers,
-		PrunedTaxa:  keptTaxids,
-		Stats:       stats,
+		reportHeader: newReportHeader("split-report"),
+		Input:        splitInput,
+		OutDir:       outDir,
+		Classifiers:  classifiers,
+		PrunedTaxa:   keptTaxids,
+		Stats:        stats,
+		Sanitize:     sanitize,
+		Resources:    currentRun().resources(),
 	}); err != nil {
 		return err
 	}
@@ -233,8 +285,8 @@ func splitOne(input, outDir, taxonkitIn string, ranks, classifiers []string, tax
 	return nil
 }
 
-func collectFastaIDs(input string) (map[string]struct{}, error) {
-	in, err := openInput(input)
+func collectFastaIDs(ctx context.Context, input string) (map[string]struct{}, error) {
+	in, _, err := openFastaInput(input)
 	if err != nil {
 		return nil, fmt.Errorf("open input: %w", err)
 	}
@@ -243,7 +295,7 @@ func collectFastaIDs(input string) (map[string]struct{}, error) {
 	}()
 
 	ids := make(map[string]struct{}, 1<<20)
-	err = parseFasta(in, func(rec fastaRecord) error {
+	err = parseFastaContext(ctx, in, func(rec fastaRecord) error {
 		if rec.id == "" {
 			return fmt.Errorf("found FASTA record with empty ID")
 		}
@@ -254,7 +306,7 @@ func collectFastaIDs(input string) (map[string]struct{}, error) {
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, interruptedAt(ctx, err, "split", int64(len(ids)))
 	}
 	if len(ids) == 0 {
 		return nil, fmt.Errorf("input FASTA appears empty: %s", input)
@@ -336,8 +388,8 @@ func loadProcessLabelMap(path string, wantedIDs map[string]struct{}) (map[string
 	return labels, invalid, nil
 }
 
-func buildSplitPlan(input string, labels map[string]string, invalidIDs map[string]struct{}) (splitPlan, splitStats, error) {
-	in, err := openInput(input)
+func buildSplitPlan(ctx context.Context, input string, labels map[string]string, invalidIDs map[string]struct{}) (splitPlan, splitStats, error) {
+	in, _, err := openFastaInput(input)
 	if err != nil {
 		return splitPlan{}, splitStats{}, fmt.Errorf("open input: %w", err)
 	}
@@ -348,7 +400,7 @@ func buildSplitPlan(input string, labels map[string]string, invalidIDs map[strin
 	barcodeGroups := make(map[[16]byte]barcodeGroup, 1<<20)
 	stats := splitStats{}
 
-	err = parseFasta(in, func(rec fastaRecord) error {
+	err = parseFastaContext(ctx, in, func(rec fastaRecord) error {
 		stats.TotalRecords++
 		if _, bad := invalidIDs[rec.id]; bad {
 			return nil
@@ -371,7 +423,7 @@ func buildSplitPlan(input string, labels map[string]string, invalidIDs map[strin
 		return nil
 	})
 	if err != nil {
-		return splitPlan{}, splitStats{}, err
+		return splitPlan{}, splitStats{}, interruptedAt(ctx, err, "split", int64(stats.TotalRecords))
 	}
 
 	seqBucket := make(map[[16]byte]string, len(barcodeGroups))
@@ -459,7 +511,7 @@ func assignUnits(seqBucket map[[16]byte]string, units []barcodeUnit, targets []s
 	}
 }
 
-func writeSplitFastas(input, outDir string, plan splitPlan, labels map[string]string) (map[string]int, map[string]struct{}, error) {
+func writeSplitFastas(ctx context.Context, input, outDir string, plan splitPlan, labels map[string]string, provenanceDir string) (map[string]int, map[string]struct{}, error) {
 	if err := os.MkdirAll(outDir, 0o755); err != nil {
 		return nil, nil, fmt.Errorf("create output dir: %w", err)
 	}
@@ -476,12 +528,12 @@ func writeSplitFastas(input, outDir string, plan splitPlan, labels map[string]st
 	}
 
 	type splitWriter struct {
-		file *os.File
+		file *meteredFile
 		buf  *bufio.Writer
 	}
 	writers := make(map[string]splitWriter, len(paths))
 	for key, path := range paths {
-		f, err := os.Create(path)
+		f, err := createFile(path)
 		if err != nil {
 			return nil, nil, fmt.Errorf("create %s: %w", path, err)
 		}
@@ -497,7 +549,7 @@ func writeSplitFastas(input, outDir string, plan splitPlan, labels map[string]st
 		}
 	}()
 
-	in, err := openInput(input)
+	in, _, err := openFastaInput(input)
 	if err != nil {
 		return nil, nil, fmt.Errorf("open input: %w", err)
 	}
@@ -505,9 +557,18 @@ func writeSplitFastas(input, outDir string, plan splitPlan, labels map[string]st
 		_ = in.Close()
 	}()
 
+	prov, err := openProvenanceLog(provenanceDir, "split")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = prov.Close()
+	}()
+
 	counts := make(map[string]int)
 	seenTrainIDs := make(map[string]struct{})
-	err = parseFasta(in, func(rec fastaRecord) error {
+	written := 0
+	err = parseFastaContext(ctx, in, func(rec fastaRecord) error {
 		bucket := bucketPretrain
 		if _, bad := plan.invalidIDs[rec.id]; !bad {
 			if _, ok := labels[rec.id]; ok {
@@ -524,16 +585,20 @@ func writeSplitFastas(input, outDir string, plan splitPlan, labels map[string]st
 		if !ok {
 			return fmt.Errorf("unknown split bucket %s", bucket)
 		}
-		if err := writeFasta(w.buf, rec.id, rec.seq); err != nil {
+		if err := newFastaWriter(w.buf, 0).Write(rec.id, rec.seq); err != nil {
 			return err
 		}
 		counts[bucket]++
+		written++
 		if bucket == bucketSeenTrain {
 			seenTrainIDs[rec.id] = struct{}{}
 		}
-		return nil
+		return prov.record(rec.id, "assigned", bucket, 0)
 	})
 	if err != nil {
+		return nil, nil, interruptedAt(ctx, err, "split", int64(written))
+	}
+	if err := prov.Close(); err != nil {
 		return nil, nil, err
 	}
 
@@ -572,7 +637,7 @@ func minInt(a, b int) int {
 }
 
 func writeSplitReport(path string, report splitReport) error {
-	f, err := os.Create(path)
+	f, err := createFile(path)
 	if err != nil {
 		return fmt.Errorf("create split report: %w", err)
 	}
@@ -595,14 +660,14 @@ func pruneTaxdumpForSeenTrain(seenTrainIDs map[string]struct{}, taxdumpDir, taxi
 	if taxidMapPath == "" {
 		taxidMapPath = filepath.Join(taxdumpDir, "taxid.map")
 	}
-	pidToTaxid, err := loadTaxidMap(taxidMapPath)
-	if err != nil {
+	pidToTaxid, err := loadTaxidMap(taxidMapPath, 0, -1)
+	if err = acceptRowErrors("split: taxid.map", err); err != nil {
 		return "", 0, err
 	}
 
 	nodesPath := filepath.Join(taxdumpDir, "nodes.dmp")
 	namesPath := filepath.Join(taxdumpDir, "names.dmp")
-	dump, err := loadTaxDump(nodesPath, namesPath)
+	dump, err := loadTaxDump(nodesPath, namesPath, nil)
 	if err != nil {
 		return "", 0, err
 	}
@@ -614,6 +679,8 @@ func pruneTaxdumpForSeenTrain(seenTrainIDs map[string]struct{}, taxdumpDir, taxi
 		if !ok {
 			return "", 0, fmt.Errorf("taxid not found for seen_train processid %s", pid)
 		}
+		// The pruned dump has no merged.dmp, so write the current taxid.
+		taxid, _ = dump.current(taxid)
 		seenTrainTaxids[pid] = taxid
 
 		cur := taxid
@@ -622,14 +689,14 @@ func pruneTaxdumpForSeenTrain(seenTrainIDs map[string]struct{}, taxdumpDir, taxi
 				break
 			}
 			keep[cur] = struct{}{}
-			node, ok := dump.nodes[cur]
+			node, ok := dump.node(cur)
 			if !ok {
 				break
 			}
-			if node.parent == cur || node.parent <= 0 {
+			if int(node.parent) == cur || node.parent <= 0 {
 				break
 			}
-			cur = node.parent
+			cur = int(node.parent)
 		}
 	}
 
@@ -638,10 +705,10 @@ func pruneTaxdumpForSeenTrain(seenTrainIDs map[string]struct{}, taxdumpDir, taxi
 		return "", 0, fmt.Errorf("create pruned taxdump dir: %w", err)
 	}
 
-	if err := writePrunedNodes(filepath.Join(prunedDir, "nodes.dmp"), dump.nodes, keep); err != nil {
+	if err := writePrunedNodes(filepath.Join(prunedDir, "nodes.dmp"), dump, keep); err != nil {
 		return "", 0, err
 	}
-	if err := writePrunedNames(filepath.Join(prunedDir, "names.dmp"), dump.nodes, keep); err != nil {
+	if err := writePrunedNames(filepath.Join(prunedDir, "names.dmp"), dump, keep); err != nil {
 		return "", 0, err
 	}
 	if err := writePrunedTaxidMap(filepath.Join(prunedDir, "taxid.map"), seenTrainTaxids); err != nil {
@@ -651,9 +718,9 @@ func pruneTaxdumpForSeenTrain(seenTrainIDs map[string]struct{}, taxdumpDir, taxi
 	return prunedDir, len(keep), nil
 }
 
-func writePrunedNodes(path string, nodes map[int]taxNode, keep map[int]struct{}) error {
+func writePrunedNodes(path string, dump *taxDump, keep map[int]struct{}) error {
 	ids := sortedIntSet(keep)
-	f, err := os.Create(path)
+	f, err := createFile(path)
 	if err != nil {
 		return fmt.Errorf("create nodes.dmp: %w", err)
 	}
@@ -667,20 +734,20 @@ func writePrunedNodes(path string, nodes map[int]taxNode, keep map[int]struct{})
 	}()
 
 	for _, id := range ids {
-		node, ok := nodes[id]
+		node, ok := dump.node(id)
 		if !ok {
 			continue
 		}
-		if _, err := w.WriteString(strconv.Itoa(id) + "\t|\t" + strconv.Itoa(node.parent) + "\t|\t" + node.rank + "\t|\n"); err != nil {
+		if _, err := w.WriteString(strconv.Itoa(id) + "\t|\t" + strconv.Itoa(int(node.parent)) + "\t|\t" + dump.rank(node) + "\t|\n"); err != nil {
 			return fmt.Errorf("write nodes.dmp: %w", err)
 		}
 	}
 	return nil
 }
 
-func writePrunedNames(path string, nodes map[int]taxNode, keep map[int]struct{}) error {
+func writePrunedNames(path string, dump *taxDump, keep map[int]struct{}) error {
 	ids := sortedIntSet(keep)
-	f, err := os.Create(path)
+	f, err := createFile(path)
 	if err != nil {
 		return fmt.Errorf("create names.dmp: %w", err)
 	}
@@ -694,11 +761,11 @@ func writePrunedNames(path string, nodes map[int]taxNode, keep map[int]struct{})
 	}()
 
 	for _, id := range ids {
-		node, ok := nodes[id]
-		if !ok || node.name == "" {
+		node, ok := dump.node(id)
+		if !ok || node.nameLen == 0 {
 			continue
 		}
-		if _, err := w.WriteString(strconv.Itoa(id) + "\t|\t" + node.name + "\t|\t\t|\tscientific name\t|\n"); err != nil {
+		if _, err := w.WriteString(strconv.Itoa(id) + "\t|\t" + dump.name(node) + "\t|\t\t|\tscientific name\t|\n"); err != nil {
 			return fmt.Errorf("write names.dmp: %w", err)
 		}
 	}
@@ -712,7 +779,7 @@ func writePrunedTaxidMap(path string, pidTaxid map[string]int) error {
 	}
 	sort.Strings(pids)
 
-	f, err := os.Create(path)
+	f, err := createFile(path)
 	if err != nil {
 		return fmt.Errorf("create taxid.map: %w", err)
 	}
@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type statsReport struct {
+	reportHeader
+	BinWidth int            `json:"bin_width"`
+	Inputs   []fastaSummary `json:"inputs"`
+}
+
+// fastaSummary describes the records of one FASTA file. Percentages are of
+// all bases, except GC which is of the A, C, G and T bases.
+type fastaSummary struct {
+	Input        string      `json:"input"`
+	Marker       string      `json:"marker,omitempty"`
+	Records      int64       `json:"records"`
+	Bases        int64       `json:"bases"`
+	MinLength    int         `json:"min_length"`
+	MedianLength float64     `json:"median_length"`
+	MeanLength   float64     `json:"mean_length"`
+	MaxLength    int         `json:"max_length"`
+	N50          int         `json:"n50"`
+	GCPercent    float64     `json:"gc_percent"`
+	NPercent     float64     `json:"n_percent"`
+	AmbigPercent float64     `json:"ambiguous_percent"`
+	Histogram    []lengthBin `json:"histogram"`
+}
+
+// lengthBin counts the records with start <= length <= end. Empty bins are
+// left out.
+type lengthBin struct {
+	Start int   `json:"start"`
+	End   int   `json:"end"`
+	Count int64 `json:"count"`
+}
+
+const (
+	baseOther byte = iota
+	baseAT
+	baseGC
+	baseN
+	baseAmbig
+)
+
+// baseClass sorts sequence bytes the way cleanSequence counts them: N apart
+// from the other IUPAC ambiguity codes. Gaps and invalid bytes are bases of
+// no class.
+var baseClass = func() (t [256]byte) {
+	set := func(chars string, class byte) {
+		for i := 0; i < len(chars); i++ {
+			t[chars[i]] = class
+			t[chars[i]|0x20] = class
+		}
+	}
+	set("AT", baseAT)
+	set("GC", baseGC)
+	set("N", baseN)
+	set("RYSWKMBDHV", baseAmbig)
+	return t
+}()
+
+// seqStats accumulates a fastaSummary in one pass. Lengths are counted per
+// distinct length, so memory is bounded by the length range, not the
+// number of records, and the median and N50 are exact.
+type seqStats struct {
+	records int64
+	bases   int64
+	classes [baseAmbig + 1]int64
+	lengths map[int]int64
+}
+
+func newSeqStats() *seqStats {
+	return &seqStats{lengths: make(map[int]int64)}
+}
+
+func (s *seqStats) add(seq []byte) {
+	s.records++
+	s.bases += int64(len(seq))
+	s.lengths[len(seq)]++
+	for _, c := range seq {
+		s.classes[baseClass[c]]++
+	}
+}
+
+func (s *seqStats) summary(binWidth int) fastaSummary {
+	var out fastaSummary
+	out.Records = s.records
+	out.Bases = s.bases
+	if s.records == 0 {
+		return out
+	}
+	lens := make([]int, 0, len(s.lengths))
+	for l := range s.lengths {
+		lens = append(lens, l)
+	}
+	sort.Ints(lens)
+	out.MinLength = lens[0]
+	out.MaxLength = lens[len(lens)-1]
+	out.MeanLength = round2(float64(s.bases) / float64(s.records))
+	out.MedianLength = (float64(s.nthLength(lens, (s.records-1)/2)) + float64(s.nthLength(lens, s.records/2))) / 2
+
+	// N50: the length at which the longest records first cover half the bases.
+	var covered int64
+	for i := len(lens) - 1; i >= 0; i-- {
+		covered += int64(lens[i]) * s.lengths[lens[i]]
+		if 2*covered >= s.bases {
+			out.N50 = lens[i]
+			break
+		}
+	}
+
+	out.GCPercent = percent(s.classes[baseGC], s.classes[baseGC]+s.classes[baseAT])
+	out.NPercent = percent(s.classes[baseN], s.bases)
+	out.AmbigPercent = percent(s.classes[baseAmbig], s.bases)
+
+	for _, l := range lens {
+		start := l / binWidth * binWidth
+		if n := len(out.Histogram); n > 0 && out.Histogram[n-1].Start == start {
+			out.Histogram[n-1].Count += s.lengths[l]
+			continue
+		}
+		out.Histogram = append(out.Histogram, lengthBin{Start: start, End: start + binWidth - 1, Count: s.lengths[l]})
+	}
+	return out
+}
+
+// nthLength returns the length of the record at rank i (0-based) in length
+// order.
+func (s *seqStats) nthLength(lens []int, i int64) int {
+	for _, l := range lens {
+		if i < s.lengths[l] {
+			return l
+		}
+		i -= s.lengths[l]
+	}
+	return lens[len(lens)-1]
+}
+
+func percent(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return round2(100 * float64(part) / float64(whole))
+}
+
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+func runStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	input := fs.String("input", "", "Input FASTA, plain or gzip/bgzip (- reads stdin), or a marker_fastas directory with -per-marker")
+	binWidth := fs.Int("bin-width", 50, "Length histogram bin width")
+	jsonOut := fs.Bool("json", false, "Print a stats-report JSON document instead of tables")
+	perMarker := fs.Bool("per-marker", false, "Summarize each *.fasta and *.fasta.gz file of the -input directory")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *input == "" {
+		fatalf("input is required")
+	}
+	if *binWidth <= 0 {
+		fatalf("bin-width must be > 0")
+	}
+
+	report, err := fastaStats(*input, *binWidth, *perMarker)
+	if err != nil {
+		fatalf("stats failed: %v", err)
+	}
+	if *jsonOut {
+		err = writeStatsJSON(os.Stdout, report)
+	} else {
+		err = writeStatsText(os.Stdout, report, *perMarker)
+	}
+	if err != nil {
+		fatalf("stats failed: %v", err)
+	}
+}
+
+func fastaStats(input string, binWidth int, perMarker bool) (statsReport, error) {
+	report := statsReport{reportHeader: newReportHeader("stats-report"), BinWidth: binWidth}
+	paths := []string{input}
+	isDir := false
+	if input != stdinPath {
+		info, err := os.Stat(input)
+		if err != nil {
+			return report, err
+		}
+		isDir = info.IsDir()
+	}
+	switch {
+	case perMarker && !isDir:
+		return report, fmt.Errorf("-per-marker needs a marker_fastas directory, got %s", input)
+	case !perMarker && isDir:
+		return report, fmt.Errorf("%s is a directory; use -per-marker", input)
+	case perMarker:
+		plain, _ := filepath.Glob(filepath.Join(input, "*.fasta"))
+		gz, _ := filepath.Glob(filepath.Join(input, "*.fasta.gz"))
+		paths = append(plain, gz...)
+		sort.Strings(paths)
+		if len(paths) == 0 {
+			return report, fmt.Errorf("no *.fasta or *.fasta.gz files in %s", input)
+		}
+	}
+
+	for _, path := range paths {
+		sum, err := summarizeFasta(path, binWidth)
+		if err != nil {
+			return report, err
+		}
+		if perMarker {
+			sum.Marker = markerFromFastaPath(path)
+		}
+		report.Inputs = append(report.Inputs, sum)
+	}
+	return report, nil
+}
+
+func summarizeFasta(path string, binWidth int) (fastaSummary, error) {
+	in, _, err := openFastaInput(path)
+	if err != nil {
+		return fastaSummary{}, fmt.Errorf("open input: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	stats := newSeqStats()
+	err = parseFasta(in, func(rec fastaRecord) error {
+		stats.add(rec.seq)
+		return nil
+	})
+	if err != nil {
+		return fastaSummary{}, fmt.Errorf("read %s: %w", path, err)
+	}
+	sum := stats.summary(binWidth)
+	sum.Input = path
+	return sum, nil
+}
+
+func markerFromFastaPath(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, ".gz")
+	return strings.TrimSuffix(name, ".fasta")
+}
+
+func writeStatsJSON(w io.Writer, report statsReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
+
+// histogramBarWidth is the length of the longest histogram bar.
+const histogramBarWidth = 40
+
+// writeStatsText prints one summary and its histogram per input, or with
+// perMarker one table row per marker file.
+func writeStatsText(w io.Writer, report statsReport, perMarker bool) error {
+	if perMarker {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "marker\trecords\tbases\tmin\tmedian\tmean\tmax\tN50\tGC%\tN%\tambig%\t")
+		for _, s := range report.Inputs {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f\t%.1f\t%d\t%d\t%.2f\t%.2f\t%.2f\t\n",
+				s.Marker, s.Records, s.Bases, s.MinLength, s.MedianLength, s.MeanLength,
+				s.MaxLength, s.N50, s.GCPercent, s.NPercent, s.AmbigPercent)
+		}
+		return tw.Flush()
+	}
+
+	for i, s := range report.Inputs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "input\t%s\n", s.Input)
+		fmt.Fprintf(tw, "records\t%d\n", s.Records)
+		fmt.Fprintf(tw, "bases\t%d\n", s.Bases)
+		fmt.Fprintf(tw, "length min/median/mean/max\t%d / %.1f / %.1f / %d\n", s.MinLength, s.MedianLength, s.MeanLength, s.MaxLength)
+		fmt.Fprintf(tw, "N50\t%d\n", s.N50)
+		fmt.Fprintf(tw, "GC%%\t%.2f\n", s.GCPercent)
+		fmt.Fprintf(tw, "N%%\t%.2f\n", s.NPercent)
+		fmt.Fprintf(tw, "ambiguous%%\t%.2f\n", s.AmbigPercent)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if len(s.Histogram) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nlength histogram (bin width %d)\n", report.BinWidth)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		var peak int64
+		for _, b := range s.Histogram {
+			peak = max(peak, b.Count)
+		}
+		for _, b := range s.Histogram {
+			bar := int(math.Ceil(float64(b.Count) / float64(peak) * histogramBarWidth))
+			fmt.Fprintf(tw, "%d-%d\t%d\t%s\n", b.Start, b.End, b.Count, strings.Repeat("#", bar))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
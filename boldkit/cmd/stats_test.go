@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSeqStatsSummary(t *testing.T) {
+	s := newSeqStats()
+	for _, seq := range []string{"ACGT", "GGCCNN", "ACGTACGTRY", "aattggcc", "ACGTACGTACGTACGTACGT"} {
+		s.add([]byte(seq))
+	}
+	got := s.summary(5)
+	want := fastaSummary{
+		Records:      5,
+		Bases:        48,
+		MinLength:    4,
+		MedianLength: 8,
+		MeanLength:   9.6,
+		MaxLength:    20,
+		// 20 < 24 <= 20+10.
+		N50:          10,
+		GCPercent:    round2(100 * 24.0 / 44),
+		NPercent:     round2(100 * 2.0 / 48),
+		AmbigPercent: round2(100 * 2.0 / 48),
+		Histogram: []lengthBin{
+			{Start: 0, End: 4, Count: 1},
+			{Start: 5, End: 9, Count: 2},
+			{Start: 10, End: 14, Count: 1},
+			{Start: 20, End: 24, Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+
+	s.add([]byte("ACG"))
+	if got := s.summary(5).MedianLength; got != 7 {
+		t.Fatalf("even median = %v, want 7", got)
+	}
+	if got := newSeqStats().summary(5); got.Records != 0 || got.Histogram != nil {
+		t.Fatalf("empty summary = %+v", got)
+	}
+}
+
+func TestFastaStatsPerMarker(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "COI-5P.fasta"), []byte(">A\nACGT\n>B\nAC\nGT\nNN\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ITS.fasta.gz"), gzipBytes(t, []byte(">C\nGGGCCC\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := fastaStats(dir, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Inputs) != 2 {
+		t.Fatalf("got %d rows, want 2", len(report.Inputs))
+	}
+	coi, its := report.Inputs[0], report.Inputs[1]
+	if coi.Marker != "COI-5P" || coi.Records != 2 || coi.Bases != 10 || coi.MaxLength != 6 {
+		t.Fatalf("COI-5P row = %+v", coi)
+	}
+	if its.Marker != "ITS" || its.Records != 1 || its.GCPercent != 100 {
+		t.Fatalf("ITS row = %+v", its)
+	}
+
+	var text bytes.Buffer
+	if err := writeStatsText(&text, report, true); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(text.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "COI-5P") || !strings.Contains(lines[2], "ITS") {
+		t.Fatalf("table:\n%s", text.String())
+	}
+
+	var js bytes.Buffer
+	if err := writeStatsJSON(&js, report); err != nil {
+		t.Fatal(err)
+	}
+	var decoded statsReport
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.SchemaVersion == "" || decoded.BinWidth != 10 || !reflect.DeepEqual(decoded.Inputs, report.Inputs) {
+		t.Fatalf("json round trip = %+v", decoded)
+	}
+
+	if _, err := fastaStats(dir, 10, false); err == nil {
+		t.Fatal("directory without -per-marker: want error")
+	}
+	if _, err := fastaStats(filepath.Join(dir, "COI-5P.fasta"), 10, true); err == nil {
+		t.Fatal("-per-marker on a file: want error")
+	}
+}
+
+func TestFastaStatsStdin(t *testing.T) {
+	pipeStdin(t, gzipBytes(t, []byte(">A\nACGTACGT\n")))
+	report, err := fastaStats(stdinPath, 50, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text bytes.Buffer
+	if err := writeStatsText(&text, report, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"records", "N50", "GC%", "0-49", "########"} {
+		if !strings.Contains(text.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, text.String())
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func runSubset(args []string) {
+	fs := flag.NewFlagSet("subset", flag.ExitOnError)
+	input := fs.String("input", "", "Indexed FASTA: <input>.fai from boldkit faidx or markers -index (and <input>.gzi for bgzip)")
+	idsPath := fs.String("ids", "", "File of sequence IDs, one per line (first word; blank and # lines skipped)")
+	output := fs.String("output", "", "Output FASTA (default: stdout)")
+	wrap := fs.Int("wrap", 0, "Wrap FASTA sequences at this many bases per line (0 disables)")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *input == "" || *idsPath == "" {
+		fatalf("input and ids are required")
+	}
+	if *wrap < 0 {
+		fatalf("wrap must be >= 0")
+	}
+	if err := subsetFasta(*input, *idsPath, *output, *wrap); err != nil {
+		fatalf("subset failed: %v", err)
+	}
+}
+
+func subsetFasta(input, idsPath, output string, wrap int) error {
+	ids, err := readIDList(idsPath)
+	if err != nil {
+		return err
+	}
+	recs, missing, err := fetchSequences(input, ids)
+	if err != nil {
+		return err
+	}
+
+	var dst io.Writer = os.Stdout
+	var f *meteredFile
+	if output != "" {
+		if f, err = createFile(output); err != nil {
+			return fmt.Errorf("create output: %w", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		dst = f
+	}
+	buf := bufio.NewWriterSize(dst, writerBufferSize)
+	fasta := newFastaWriter(buf, wrap)
+	for _, rec := range recs {
+		if err := fasta.Write(rec.id, rec.seq); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("close output: %w", err)
+		}
+	}
+
+	logf("subset: %d of %d IDs from %s", len(recs), len(ids), input)
+	if len(missing) > 0 {
+		shown := missing[:min(len(missing), 5)]
+		logf("subset: WARNING %d IDs not in the index: %s", len(missing), strings.Join(shown, ", "))
+	}
+	return nil
+}
@@ -2,115 +2,488 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 )
 
+// taxRank is an interned nodes.dmp rank, an index into taxDump.ranks.
+type taxRank uint8
+
+// nameClass records which names.dmp class named a node: absentNode marks a
+// gap in the dense table, unnamedNode a node names.dmp gives no name, and
+// firstNameClass+i the taxDump's nameClasses[i].
+type nameClass uint8
+
+const (
+	absentNode nameClass = iota
+	unnamedNode
+	firstNameClass
+)
+
+// defaultNameClasses are the names.dmp classes a node's name is taken from,
+// best first, so a node whose scientific name is missing still has a name
+// and its rank still shows in lineages.
+var defaultNameClasses = []string{"scientific name", "equivalent name", "synonym", "common name"}
+
+// taxNode is one nodes.dmp entry in 12 bytes. Its name is
+// names[nameOff:nameOff+nameLen] of the taxDump's arena, of names.dmp
+// class class.
 type taxNode struct {
-	parent int
-	rank   string
-	name   string
+	parent  int32
+	nameOff uint32
+	nameLen uint16
+	rank    taxRank
+	class   nameClass
 }
 
+// taxDump is safe for concurrent use: the node tables, names, merged,
+// deleted, and alias are read-only after loading, and the lineage cache
+// locks its shards.
+//
+// Nodes live in dense, indexed by taxid, with the few taxids far past the
+// node count in sparse, so a dump costs a dozen bytes per taxid plus its
+// names rather than a map entry and two strings per node.
 type taxDump struct {
-	nodes map[int]taxNode
-	cache map[int]map[string]string
-	alias map[string]string
+	dense  []taxNode
+	sparse map[int]taxNode
+	// ranks are the distinct nodes.dmp ranks by taxRank, and lineageRanks
+	// the same under their aliases; canonRanks maps each taxRank to the
+	// first with its lineage rank.
+	ranks        []string
+	lineageRanks []string
+	canonRanks   []taxRank
+	names        string // node names, sliced by taxNode
+	// nameClasses are the names.dmp classes names come from, best first.
+	nameClasses []string
+	merged      map[int]int // old -> new taxid from merged.dmp
+	deleted     map[int]bool
+	cache       *lineageCache
+	alias       rankAliases
+}
+
+// loadTaxDump loads nodes.dmp and names.dmp, and merged.dmp and
+// delnodes.dmp from the same directory when present. Lineages name ranks
+// as aliases maps them (nil means defaultRankAliases).
+func loadTaxDump(nodesPath, namesPath string, aliases rankAliases) (*taxDump, error) {
+	return loadTaxDumpClasses(nodesPath, namesPath, aliases, nil)
 }
 
-func loadTaxDump(nodesPath, namesPath string) (*taxDump, error) {
-	names, err := loadNames(namesPath)
+// loadTaxDumpClasses is loadTaxDump taking each node's name from the first
+// of classes names.dmp has for it (nil means defaultNameClasses).
+func loadTaxDumpClasses(nodesPath, namesPath string, aliases rankAliases, classes []string) (*taxDump, error) {
+	if aliases == nil {
+		aliases = defaultRankAliases
+	}
+	if classes == nil {
+		classes = defaultNameClasses
+	}
+	if len(classes) > math.MaxUint8-int(firstNameClass) {
+		return nil, fmt.Errorf("too many name classes: %d", len(classes))
+	}
+	t := &taxDump{cache: newLineageCache(defaultLineageCacheEntries), alias: aliases, nameClasses: classes}
+	if err := t.loadNodes(nodesPath); err != nil {
+		return nil, err
+	}
+	if err := t.loadNames(namesPath); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(nodesPath)
+	merged, err := loadMerged(filepath.Join(dir, "merged.dmp"))
 	if err != nil {
 		return nil, err
 	}
-	nodes, err := loadNodes(nodesPath, names)
+	deleted, err := loadDelnodes(filepath.Join(dir, "delnodes.dmp"))
 	if err != nil {
 		return nil, err
 	}
-	return &taxDump{
-		nodes: nodes,
-		cache: make(map[int]map[string]string),
-		alias: map[string]string{
-			"superkingdom": "kingdom",
-		},
-	}, nil
+	t.merged, t.deleted = merged, deleted
+	return t, nil
+}
+
+// taxDumpHandle shares one taxdump, and so its lineage cache, among the qc
+// and format runs of a command that processes several inputs. It loads the
+// dump on first use, so runs that all hit the artifact cache never do.
+type taxDumpHandle struct {
+	dir     string
+	aliases rankAliases
+	once    sync.Once
+	dump    *taxDump
+	err     error
+}
+
+// newTaxDumpHandle returns a handle on the taxdump in dir read with aliases
+// (nil means defaultRankAliases).
+func newTaxDumpHandle(dir string, aliases rankAliases) *taxDumpHandle {
+	if aliases == nil {
+		aliases = defaultRankAliases
+	}
+	return &taxDumpHandle{dir: dir, aliases: aliases}
+}
+
+// load returns the taxdump in dir read with aliases: the shared one when h
+// is on that dump, otherwise, as for a nil h, a private copy.
+func (h *taxDumpHandle) load(dir string, aliases rankAliases) (*taxDump, error) {
+	nodesPath := filepath.Join(dir, "nodes.dmp")
+	namesPath := filepath.Join(dir, "names.dmp")
+	if h == nil || filepath.Clean(dir) != filepath.Clean(h.dir) || !maps.Equal(aliases, h.aliases) {
+		return loadTaxDump(nodesPath, namesPath, aliases)
+	}
+	h.once.Do(func() {
+		h.dump, h.err = loadTaxDump(nodesPath, namesPath, h.aliases)
+	})
+	return h.dump, h.err
+}
+
+// optionalDmpFiles are the taxdump files loadTaxDump reads when present.
+var optionalDmpFiles = []string{"merged.dmp", "delnodes.dmp"}
+
+// addOptionalDmpRefs adds the optionalDmpFiles present in dir to cache key
+// refs.
+func addOptionalDmpRefs(refs map[string]string, dir string) {
+	for _, name := range optionalDmpFiles {
+		if path := filepath.Join(dir, name); fileExists(path) {
+			refs[name] = path
+		}
+	}
+}
+
+// loadMerged reads the old -> new taxid pairs of merged.dmp. A missing file
+// is logged and yields no pairs.
+func loadMerged(path string) (map[int]int, error) {
+	merged := make(map[int]int)
+	err := scanDmp(path, func(fields []string) {
+		if len(fields) < 2 {
+			return
+		}
+		old, err1 := strconv.Atoi(fields[0])
+		cur, err2 := strconv.Atoi(fields[1])
+		if err1 == nil && err2 == nil {
+			merged[old] = cur
+		}
+	})
+	return merged, err
 }
 
-func loadNames(path string) (map[int]string, error) {
-	f, err := os.Open(path)
+// loadDelnodes reads the deleted taxids of delnodes.dmp. A missing file is
+// logged and yields none.
+func loadDelnodes(path string) (map[int]bool, error) {
+	deleted := make(map[int]bool)
+	err := scanDmp(path, func(fields []string) {
+		if len(fields) < 1 {
+			return
+		}
+		if id, err := strconv.Atoi(fields[0]); err == nil {
+			deleted[id] = true
+		}
+	})
+	return deleted, err
+}
+
+// scanDmp calls fn with the fields of each line of an optional .dmp file.
+func scanDmp(path string, fn func(fields []string)) error {
+	f, err := openFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		logf("taxdump: no %s; taxids it lists will not resolve", filepath.Base(path))
+		return nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("open names.dmp: %w", err)
+		return fmt.Errorf("open %s: %w", filepath.Base(path), err)
 	}
 	defer func() {
 		_ = f.Close()
 	}()
-
-	names := make(map[int]string, 1<<20)
 	scanner := bufio.NewScanner(f)
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 10*1024*1024)
 	for scanner.Scan() {
-		fields := parseDmpLine(scanner.Text())
-		if len(fields) < 4 {
-			continue
+		fn(parseDmpLine(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scan %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// scanTaxidMap calls fn with the id and taxid of each taxid.map row. The
+// id is only valid during the call.
+func scanTaxidMap(path string, fn func(id []byte, taxid int) error) error {
+	line := 0
+	return scanDmpBytes(path, "taxid.map", func(text []byte) error {
+		line++
+		fields := bytes.Fields(text)
+		if len(fields) == 0 {
+			return nil
 		}
-		if fields[3] != "scientific name" {
-			continue
+		taxid, ok := 0, len(fields) == 2
+		if ok {
+			taxid, ok = parseDmpInt(fields[1])
 		}
-		id, err := strconv.Atoi(fields[0])
-		if err != nil {
-			continue
+		if !ok || taxid > 1<<31-1 {
+			return fmt.Errorf("%s line %d: want id and taxid", path, line)
 		}
-		if fields[1] == "" {
-			continue
+		return fn(fields[0], taxid)
+	})
+}
+
+// current returns the taxid that replaced taxid in merged.dmp, following
+// chains of merges, and whether there was one.
+func (t *taxDump) current(taxid int) (int, bool) {
+	cur, ok := t.merged[taxid]
+	if !ok {
+		return taxid, false
+	}
+	for range 8 {
+		next, ok := t.merged[cur]
+		if !ok {
+			break
 		}
-		names[id] = fields[1]
+		cur = next
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("scan names.dmp: %w", err)
+	return cur, true
+}
+
+// isDeleted reports whether delnodes.dmp lists taxid and it was not
+// re-added to nodes.dmp.
+func (t *taxDump) isDeleted(taxid int) bool {
+	if !t.deleted[taxid] {
+		return false
+	}
+	_, ok := t.node(taxid)
+	return !ok
+}
+
+// maxDenseSlack bounds the dense node table at this many times the node
+// count; taxids beyond it go to the sparse map.
+const maxDenseSlack = 2
+
+// loadNodes reads nodes.dmp into the node tables, interning its ranks.
+func (t *taxDump) loadNodes(path string) error {
+	type entry struct {
+		id     int
+		parent int32
+		rank   taxRank
+	}
+	var entries []entry
+	rankIdx := make(map[string]taxRank)
+	maxID := 0
+	var fields [][]byte
+	err := scanDmpBytes(path, "nodes.dmp", func(line []byte) error {
+		if fields = appendDmpFields(fields[:0], line, 3); len(fields) < 3 {
+			return nil
+		}
+		id, ok1 := parseDmpInt(fields[0])
+		parent, ok2 := parseDmpInt(fields[1])
+		if !ok1 || !ok2 || parent > math.MaxInt32 {
+			return nil
+		}
+		rank, ok := rankIdx[string(fields[2])]
+		if !ok {
+			if len(t.ranks) > math.MaxUint8 {
+				return fmt.Errorf("nodes.dmp has more than %d distinct ranks", math.MaxUint8+1)
+			}
+			rank = taxRank(len(t.ranks))
+			rankIdx[string(fields[2])] = rank
+			t.ranks = append(t.ranks, string(fields[2]))
+		}
+		entries = append(entries, entry{id: id, parent: int32(parent), rank: rank})
+		maxID = max(maxID, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	t.dense = make([]taxNode, min(maxID+1, maxDenseSlack*len(entries)+1024))
+	t.sparse = make(map[int]taxNode)
+	for _, e := range entries {
+		n := taxNode{parent: e.parent, rank: e.rank, class: unnamedNode}
+		if e.id < len(t.dense) {
+			t.dense[e.id] = n
+		} else {
+			t.sparse[e.id] = n
+		}
+	}
+	t.lineageRanks = make([]string, len(t.ranks))
+	t.canonRanks = make([]taxRank, len(t.ranks))
+	canon := make(map[string]taxRank, len(t.ranks))
+	for i, rank := range t.ranks {
+		t.lineageRanks[i] = t.alias.canonical(rank)
+		if _, ok := canon[t.lineageRanks[i]]; !ok {
+			canon[t.lineageRanks[i]] = taxRank(i)
+		}
+		t.canonRanks[i] = canon[t.lineageRanks[i]]
 	}
-	return names, nil
+	return nil
 }
 
-func loadNodes(path string, names map[int]string) (map[int]taxNode, error) {
-	f, err := os.Open(path)
+// loadNames stores in the arena the name of each node of the best class
+// names.dmp has for it, the first entry of that class winning. A name a
+// better class later replaces stays in the arena unused.
+func (t *taxDump) loadNames(path string) error {
+	var arena strings.Builder
+	arena.Grow(16 << 20)
+	classIdx := make(map[string]nameClass, len(t.nameClasses))
+	for i, class := range t.nameClasses {
+		if _, dup := classIdx[class]; !dup {
+			classIdx[class] = firstNameClass + nameClass(i)
+		}
+	}
+	var fields [][]byte
+	err := scanDmpBytes(path, "names.dmp", func(line []byte) error {
+		if fields = appendDmpFields(fields[:0], line, 4); len(fields) < 4 || len(fields[1]) == 0 {
+			return nil
+		}
+		class, ok := classIdx[string(fields[3])]
+		if !ok {
+			return nil
+		}
+		id, ok := parseDmpInt(fields[0])
+		if !ok {
+			return nil
+		}
+		n, ok := t.node(id)
+		if !ok || (n.class != unnamedNode && n.class <= class) {
+			return nil
+		}
+		if len(fields[1]) > math.MaxUint16 || uint64(arena.Len()+len(fields[1])) > math.MaxUint32 {
+			return fmt.Errorf("names.dmp: name of taxid %d does not fit the name arena", id)
+		}
+		n.nameOff, n.nameLen, n.class = uint32(arena.Len()), uint16(len(fields[1])), class
+		arena.Write(fields[1])
+		if id < len(t.dense) {
+			t.dense[id] = n
+		} else {
+			t.sparse[id] = n
+		}
+		return nil
+	})
+	t.names = arena.String()
+	return err
+}
+
+// scanDmpBytes calls fn with each line of a required .dmp file. The line is
+// only valid during the call.
+func scanDmpBytes(path, name string, fn func(line []byte) error) error {
+	f, err := openFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("open nodes.dmp: %w", err)
+		return fmt.Errorf("open %s: %w", name, err)
 	}
 	defer func() {
 		_ = f.Close()
 	}()
 
-	nodes := make(map[int]taxNode, 1<<20)
 	scanner := bufio.NewScanner(f)
 	buf := make([]byte, 0, 1024*1024)
 	scanner.Buffer(buf, 10*1024*1024)
 	for scanner.Scan() {
-		fields := parseDmpLine(scanner.Text())
-		if len(fields) < 3 {
-			continue
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
 		}
-		id, err := strconv.Atoi(fields[0])
-		if err != nil {
-			continue
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scan %s: %w", name, err)
+	}
+	return nil
+}
+
+// appendDmpFields appends the first max fields of a .dmp line to out, as
+// parseDmpLine splits them, without allocating.
+func appendDmpFields(out [][]byte, line []byte, max int) [][]byte {
+	for len(out) < max {
+		i := bytes.IndexByte(line, '|')
+		field := line
+		if i >= 0 {
+			field = line[:i]
 		}
-		parent, err := strconv.Atoi(fields[1])
-		if err != nil {
-			continue
+		if field = bytes.TrimSpace(field); len(field) > 0 || len(out) > 0 {
+			out = append(out, field)
 		}
-		name := names[id]
-		nodes[id] = taxNode{
-			parent: parent,
-			rank:   fields[2],
-			name:   name,
+		if i < 0 {
+			break
 		}
+		line = line[i+1:]
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("scan nodes.dmp: %w", err)
+	return out
+}
+
+// parseDmpInt parses a non-negative decimal taxid.
+func parseDmpInt(b []byte) (int, bool) {
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+// node returns the nodes.dmp entry of taxid.
+func (t *taxDump) node(taxid int) (taxNode, bool) {
+	if taxid >= 0 && taxid < len(t.dense) {
+		n := t.dense[taxid]
+		return n, n.class != absentNode
+	}
+	n, ok := t.sparse[taxid]
+	return n, ok
+}
+
+// eachNode calls fn with every node in taxid order.
+func (t *taxDump) eachNode(fn func(taxid int, n taxNode)) {
+	for id, n := range t.dense {
+		if n.class != absentNode {
+			fn(id, n)
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(t.sparse)) {
+		fn(id, t.sparse[id])
+	}
+}
+
+// name returns the name of n ("" when names.dmp has none), a substring of
+// the arena.
+func (t *taxDump) name(n taxNode) string {
+	return t.names[n.nameOff : n.nameOff+uint32(n.nameLen)]
+}
+
+// lineagePath returns the names from below the root down to taxid, joined
+// by ';', as taxonkit lineage prints them.
+func (t *taxDump) lineagePath(taxid int) string {
+	var names []string
+	for seen := 0; seen < 64; seen++ {
+		n, ok := t.node(taxid)
+		if !ok || int(n.parent) == taxid {
+			break
+		}
+		names = append(names, t.name(n))
+		taxid = int(n.parent)
 	}
-	return nodes, nil
+	slices.Reverse(names)
+	return strings.Join(names, ";")
+}
+
+// nameClass returns the names.dmp class of the name of n, "" when it has
+// none.
+func (t *taxDump) nameClass(n taxNode) string {
+	if n.class < firstNameClass {
+		return ""
+	}
+	return t.nameClasses[n.class-firstNameClass]
+}
+
+// rank returns the rank of n as nodes.dmp spells it.
+func (t *taxDump) rank(n taxNode) string {
+	return t.ranks[n.rank]
 }
 
 func parseDmpLine(line string) []string {
@@ -125,36 +498,51 @@ func parseDmpLine(line string) []string {
 	return out
 }
 
-func (t *taxDump) lineage(taxid int) map[string]string {
+// lineage returns the lineage of taxid, or of the taxid it was merged
+// into, from the cache when it holds it.
+func (t *taxDump) lineage(taxid int) taxLineage {
 	if taxid <= 0 {
-		return nil
+		return taxLineage{}
 	}
-	if cached, ok := t.cache[taxid]; ok {
-		return cached
+	taxid, _ = t.current(taxid)
+	l := taxLineage{dump: t}
+	if t.cache.get(taxid, &l.spans) {
+		return l
 	}
-	lineage := make(map[string]string, 8)
+	t.walkSpans(taxid, &l.spans)
+	t.cache.put(taxid, &l.spans)
+	return l
+}
+
+// walkLineage builds the lineage of taxid without the cache, for callers
+// that visit each taxid once.
+func (t *taxDump) walkLineage(taxid int) taxLineage {
+	l := taxLineage{dump: t}
+	t.walkSpans(taxid, &l.spans)
+	return l
+}
+
+// walkSpans fills spans with the ranked, named nodes from taxid up to the
+// root, the nearest winning where two share a lineage rank.
+func (t *taxDump) walkSpans(taxid int, spans *lineageSpans) {
+	spans.n = 0
 	cur := taxid
-	seen := 0
-	for cur > 0 && seen < 64 {
-		seen++
-		node, ok := t.nodes[cur]
+	for seen := 0; cur > 0 && seen < 64; seen++ {
+		node, ok := t.node(cur)
 		if !ok {
 			break
 		}
-		rank := node.rank
-		if alias, ok := t.alias[rank]; ok {
-			rank = alias
-		}
-		if rank != "" && rank != "no rank" && node.name != "" {
-			if _, exists := lineage[rank]; !exists {
-				lineage[rank] = node.name
+		rank := t.lineageRanks[node.rank]
+		if rank != "" && rank != "no rank" && node.nameLen > 0 && int(spans.n) < maxLineageRanks {
+			canon := t.canonRanks[node.rank]
+			if !slices.Contains(spans.rank[:spans.n], canon) {
+				spans.rank[spans.n], spans.off[spans.n], spans.len[spans.n] = canon, node.nameOff, node.nameLen
+				spans.n++
 			}
 		}
-		if node.parent == cur {
+		if int(node.parent) == cur {
 			break
 		}
-		cur = node.parent
+		cur = int(node.parent)
 	}
-	t.cache[taxid] = lineage
-	return lineage
 }
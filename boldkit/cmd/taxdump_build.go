@@ -0,0 +1,424 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultTaxidAssignments is the file in the taxdump directory that keeps
+// the taxids of earlier builds.
+const defaultTaxidAssignments = "taxid_assignments.tsv"
+
+// rootTaxid is the root of a built taxdump; other taxa count up from it.
+const rootTaxid = 1
+
+type taxdumpBuildConfig struct {
+	Input  string
+	OutDir string
+	// Assignments holds the taxid of every taxon built so far; it is read
+	// when present and rewritten, so rebuilds keep taxids (empty means
+	// OutDir/defaultTaxidAssignments).
+	Assignments string
+}
+
+// taxdumpBuildStats counts the rows read and the taxa built. NoLineage
+// rows name no taxon and MissingID rows no processid; neither is mapped.
+// Homonyms are taxa sharing a rank and name with another under a different
+// parent, and Deleted the earlier taxa this build no longer has.
+type taxdumpBuildStats struct {
+	Rows      int
+	Mapped    int
+	NoLineage int
+	MissingID int
+	Taxa      int
+	New       int
+	Homonyms  int
+	Deleted   int
+}
+
+func runTaxdump(args []string) {
+	fs := flag.NewFlagSet("taxdump", flag.ExitOnError)
+	input := fs.String("input", legacyTaxonkitOutput, "Extract output with rank columns (kingdom..species) and processid, plain or gzip")
+	outDir := fs.String("outdir", "bold-taxdump", "Output taxdump directory")
+	assignments := fs.String("assignments", "", "Taxid assignments reused and updated so rebuilds keep taxids (default: <outdir>/"+defaultTaxidAssignments+")")
+	force := fs.Bool("force", false, "Overwrite an existing taxdump")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if !*force && taxdumpExists(*outDir) {
+		logf("taxdump exists, skipping (use --force to overwrite): %s", *outDir)
+		return
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	_, err := buildTaxdump(ctx, taxdumpBuildConfig{Input: *input, OutDir: *outDir, Assignments: *assignments})
+	exitOnStageError(err)
+}
+
+// taxdumpExists reports whether dir holds a complete taxdump. taxid.map is
+// written last, so an interrupted build does not count.
+func taxdumpExists(dir string) bool {
+	return fileExists(filepath.Join(dir, "nodes.dmp")) && fileExists(filepath.Join(dir, "names.dmp")) && fileExists(filepath.Join(dir, "taxid.map"))
+}
+
+// isNullTaxon reports whether a rank value names no taxon: empty, or one of
+// the placeholders taxonkit create-taxdump was given as --null.
+func isNullTaxon(b []byte) bool {
+	return len(b) == 0 || isNone(b) || string(b) == "NULL" || string(b) == "NA"
+}
+
+// builtTaxon is one taxon of a build, or of an earlier one.
+type builtTaxon struct {
+	taxid  int32
+	parent int32
+	rank   string
+	name   string
+}
+
+// taxdumpBuilder assigns taxids to the taxa of the rows it is fed. A taxon
+// is a rank, a name, and a parent taxon, so homonyms under different
+// parents are distinct taxa. A taxon takes the taxid an earlier build gave
+// the same rank and name, preferring the one under the same parent, or else
+// the next unused taxid, so taxids depend only on the assignments and the
+// row order. Memory grows with the taxa, not the rows.
+type taxdumpBuilder struct {
+	ranks []string
+	// ids maps a taxon key (see key) to its taxid.
+	ids   map[string]int32
+	taxa  []builtTaxon
+	prior []builtTaxon
+	// priorByName lists the prior indexes of each rank and name, in taxid
+	// order; claimed marks those this build has taken.
+	priorByName map[string][]int
+	claimed     []bool
+	next        int32
+	keyBuf      []byte
+}
+
+func newTaxdumpBuilder(ranks []string, prior []builtTaxon) *taxdumpBuilder {
+	b := &taxdumpBuilder{
+		ranks:       ranks,
+		ids:         make(map[string]int32),
+		prior:       prior,
+		priorByName: make(map[string][]int, len(prior)),
+		claimed:     make([]bool, len(prior)),
+		next:        rootTaxid + 1,
+	}
+	slices.SortFunc(b.prior, func(x, y builtTaxon) int { return int(x.taxid) - int(y.taxid) })
+	for i, t := range b.prior {
+		k := t.rank + "\x00" + t.name
+		b.priorByName[k] = append(b.priorByName[k], i)
+		b.next = max(b.next, t.taxid+1)
+	}
+	return b
+}
+
+// taxon returns the taxid of the taxon named name at ranks[rank] under
+// parent, assigning one when it is new.
+func (b *taxdumpBuilder) taxon(rank int, name []byte, parent int32) int32 {
+	b.keyBuf = append(b.keyBuf[:0], byte(rank))
+	b.keyBuf = binary.LittleEndian.AppendUint32(b.keyBuf, uint32(parent))
+	b.keyBuf = append(b.keyBuf, name...)
+	if id, ok := b.ids[string(b.keyBuf)]; ok {
+		return id
+	}
+	t := builtTaxon{parent: parent, rank: b.ranks[rank], name: string(name)}
+	t.taxid = b.reuse(t)
+	if t.taxid == 0 {
+		t.taxid = b.next
+		b.next++
+	}
+	b.ids[string(b.keyBuf)] = t.taxid
+	b.taxa = append(b.taxa, t)
+	return t.taxid
+}
+
+// reuse claims the earlier taxid of t's rank and name, preferring one under
+// the same parent, or returns 0 when there is none left.
+func (b *taxdumpBuilder) reuse(t builtTaxon) int32 {
+	cands := b.priorByName[t.rank+"\x00"+t.name]
+	pick := -1
+	for _, i := range cands {
+		if !b.claimed[i] && b.prior[i].parent == t.parent {
+			pick = i
+			break
+		}
+	}
+	if pick < 0 {
+		for _, i := range cands {
+			if !b.claimed[i] {
+				pick = i
+				break
+			}
+		}
+	}
+	if pick < 0 {
+		return 0
+	}
+	b.claimed[pick] = true
+	return b.prior[pick].taxid
+}
+
+// buildTaxdump builds nodes.dmp, names.dmp, taxid.map, and delnodes.dmp and
+// an empty merged.dmp, in the NCBI layout, from the extract output.
+func buildTaxdump(ctx context.Context, cfg taxdumpBuildConfig) (taxdumpBuildStats, error) {
+	var stats taxdumpBuildStats
+	if cfg.Assignments == "" {
+		cfg.Assignments = filepath.Join(cfg.OutDir, defaultTaxidAssignments)
+	}
+	prior, err := loadTaxidAssignments(cfg.Assignments)
+	if err != nil {
+		return stats, err
+	}
+	if err := os.MkdirAll(cfg.OutDir, 0o755); err != nil {
+		return stats, fmt.Errorf("create taxdump dir: %w", err)
+	}
+	// taxid.map marks a complete build, so it goes first and returns last.
+	mapPath := filepath.Join(cfg.OutDir, "taxid.map")
+	if err := removeIfExists(mapPath); err != nil {
+		return stats, err
+	}
+	mapTmp := mapPath + ".tmp"
+	mapOut, err := createTSVWriter(mapTmp, tsvWriterOptions{})
+	if err != nil {
+		return stats, fmt.Errorf("create taxid.map: %w", err)
+	}
+	defer func() {
+		_ = mapOut.Close()
+		_ = os.Remove(mapTmp)
+	}()
+
+	in, err := openInput(cfg.Input)
+	if err != nil {
+		return stats, fmt.Errorf("open taxdump input: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	var b *taxdumpBuilder
+	var rankCols []int
+	idxProcess := -1
+	var lastLine int64
+	opts := DefaultOptions()
+	opts.HasHeader = true
+	opts.OnHeader = func(h *Header) error {
+		var ranks []string
+		for i, name := range h.fields {
+			if string(name) == "processid" {
+				idxProcess = i
+				continue
+			}
+			ranks = append(ranks, string(name))
+			rankCols = append(rankCols, i)
+		}
+		switch {
+		case idxProcess < 0:
+			return fmt.Errorf("taxdump input has no processid column")
+		case len(ranks) == 0:
+			return fmt.Errorf("taxdump input has no rank columns")
+		case len(ranks) > 255:
+			return fmt.Errorf("taxdump input has %d rank columns; at most 255 are supported", len(ranks))
+		}
+		b = newTaxdumpBuilder(ranks, prior)
+		return nil
+	}
+	idBuf := make([]byte, 0, 16)
+	err = ParseTSVContext(ctx, in, opts, func(row Row) error {
+		lastLine = row.Line
+		stats.Rows++
+		if len(row.Fields) <= max(idxProcess, rankCols[len(rankCols)-1]) {
+			return fmt.Errorf("line %d: expected %d fields, got %d", row.Line, len(b.ranks)+1, len(row.Fields))
+		}
+		pid := row.Fields[idxProcess]
+		if len(pid) == 0 {
+			stats.MissingID++
+			return nil
+		}
+		taxid := int32(rootTaxid)
+		for rank, col := range rankCols {
+			if name := row.Fields[col]; !isNullTaxon(name) {
+				taxid = b.taxon(rank, name, taxid)
+			}
+		}
+		if taxid == rootTaxid {
+			stats.NoLineage++
+			return nil
+		}
+		stats.Mapped++
+		idBuf = strconv.AppendInt(idBuf[:0], int64(taxid), 10)
+		return mapOut.WriteRow([][]byte{pid, idBuf})
+	})
+	if err != nil {
+		return stats, interruptedAt(ctx, fmt.Errorf("read taxdump input: %w", err), "taxdump", lastLine)
+	}
+	if b == nil {
+		return stats, fmt.Errorf("taxdump input %s is empty", cfg.Input)
+	}
+
+	stats.Taxa = len(b.taxa)
+	deleted := b.unclaimed()
+	stats.Deleted = len(deleted)
+	stats.New = len(b.taxa) - (len(b.prior) - len(deleted))
+	stats.Homonyms = countHomonyms(b.taxa)
+	slices.SortFunc(b.taxa, func(x, y builtTaxon) int { return int(x.taxid) - int(y.taxid) })
+	if err := writeBuiltTaxdump(cfg.OutDir, b.taxa, deleted); err != nil {
+		return stats, err
+	}
+	if err := writeTaxidAssignments(cfg.Assignments, b.taxa, deleted); err != nil {
+		return stats, err
+	}
+	if err := mapOut.Close(); err != nil {
+		return stats, fmt.Errorf("close taxid.map: %w", err)
+	}
+	if err := os.Rename(mapTmp, mapPath); err != nil {
+		return stats, fmt.Errorf("rename taxid.map: %w", err)
+	}
+	logf("taxdump: rows=%d mapped=%d no-lineage=%d no-id=%d taxa=%d new=%d homonyms=%d deleted=%d -> %s",
+		stats.Rows, stats.Mapped, stats.NoLineage, stats.MissingID, stats.Taxa, stats.New, stats.Homonyms, stats.Deleted, cfg.OutDir)
+	return stats, nil
+}
+
+// unclaimed returns the earlier taxa this build did not reuse, in taxid
+// order.
+func (b *taxdumpBuilder) unclaimed() []builtTaxon {
+	var out []builtTaxon
+	for i, t := range b.prior {
+		if !b.claimed[i] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// countHomonyms counts the taxa beyond the first of each rank and name.
+func countHomonyms(taxa []builtTaxon) int {
+	seen := make(map[string]bool, len(taxa))
+	n := 0
+	for _, t := range taxa {
+		k := t.rank + "\x00" + t.name
+		if seen[k] {
+			n++
+		}
+		seen[k] = true
+	}
+	return n
+}
+
+// writeBuiltTaxdump writes the dmp files of taxa, sorted by taxid, under
+// the root, with the deleted taxids in delnodes.dmp.
+func writeBuiltTaxdump(dir string, taxa, deleted []builtTaxon) error {
+	root := builtTaxon{taxid: rootTaxid, parent: rootTaxid, rank: "no rank", name: "root"}
+	all := append([]builtTaxon{root}, taxa...)
+	files := []struct {
+		name string
+		rows []builtTaxon
+		line func(t builtTaxon) string
+	}{
+		{"nodes.dmp", all, func(t builtTaxon) string {
+			return fmt.Sprintf("%d\t|\t%d\t|\t%s\t|\n", t.taxid, t.parent, t.rank)
+		}},
+		{"names.dmp", all, func(t builtTaxon) string {
+			return fmt.Sprintf("%d\t|\t%s\t|\t\t|\tscientific name\t|\n", t.taxid, t.name)
+		}},
+		{"delnodes.dmp", deleted, func(t builtTaxon) string {
+			return fmt.Sprintf("%d\t|\n", t.taxid)
+		}},
+		{"merged.dmp", nil, nil},
+	}
+	for _, f := range files {
+		if err := writeLines(filepath.Join(dir, f.name), f.rows, f.line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLines writes line(row) for each of rows to path.
+func writeLines[T any](path string, rows []T, line func(T) string) error {
+	f, err := createFile(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Base(path), err)
+	}
+	w := bufio.NewWriterSize(f, writerBufferSize)
+	for _, row := range rows {
+		if _, err := w.WriteString(line(row)); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// taxidAssignmentsHeader is the header of the assignments file.
+var taxidAssignmentsHeader = []string{"taxid", "parent", "rank", "name"}
+
+// loadTaxidAssignments reads an assignments file; a missing one has none.
+func loadTaxidAssignments(path string) ([]builtTaxon, error) {
+	if !fileExists(path) {
+		return nil, nil
+	}
+	in, err := openInput(path)
+	if err != nil {
+		return nil, fmt.Errorf("open taxid assignments: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	var out []builtTaxon
+	r := bufio.NewReader(in)
+	for line := 1; ; line++ {
+		text, err := r.ReadString('\n')
+		if text = strings.TrimRight(text, "\r\n"); text != "" && line > 1 {
+			fields := strings.Split(text, "\t")
+			if len(fields) != len(taxidAssignmentsHeader) {
+				return nil, fmt.Errorf("taxid assignments %s line %d: want %d fields, got %d", path, line, len(taxidAssignmentsHeader), len(fields))
+			}
+			taxid, err1 := strconv.ParseInt(fields[0], 10, 32)
+			parent, err2 := strconv.ParseInt(fields[1], 10, 32)
+			if err1 != nil || err2 != nil || taxid <= rootTaxid {
+				return nil, fmt.Errorf("taxid assignments %s line %d: bad taxid", path, line)
+			}
+			out = append(out, builtTaxon{taxid: int32(taxid), parent: int32(parent), rank: fields[2], name: fields[3]})
+		}
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read taxid assignments: %w", err)
+		}
+	}
+}
+
+// writeTaxidAssignments writes the taxa of this build and the deleted ones,
+// so a deleted taxon that returns gets its taxid back.
+func writeTaxidAssignments(path string, taxa, deleted []builtTaxon) error {
+	all := slices.Concat(taxa, deleted)
+	slices.SortFunc(all, func(x, y builtTaxon) int { return int(x.taxid) - int(y.taxid) })
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create taxid assignments dir: %w", err)
+	}
+	header := strings.Join(taxidAssignmentsHeader, "\t") + "\n"
+	return writeLines(path, append([]builtTaxon{{}}, all...), func(t builtTaxon) string {
+		if t.taxid == 0 {
+			return header
+		}
+		return fmt.Sprintf("%d\t%d\t%s\t%s\n", t.taxid, t.parent, t.rank, t.name)
+	})
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import "sync"
+
+// maxLineageRanks bounds the ranks a lineage holds; in a deeper lineage the
+// ranks nearest the root are dropped.
+const maxLineageRanks = 32
+
+// defaultLineageCacheEntries bounds a taxDump's lineage cache at about
+// 60 MB; a miss costs one walk up the node tables.
+const defaultLineageCacheEntries = 1 << 18
+
+// lineageSpans is a lineage without pointers, so cached lineages cost the
+// garbage collector nothing: the canonical rank of each ranked node from
+// the taxid up, and the span of its name in the taxDump's arena.
+type lineageSpans struct {
+	n    uint8
+	rank [maxLineageRanks]taxRank
+	off  [maxLineageRanks]uint32
+	len  [maxLineageRanks]uint16
+}
+
+// taxLineage is the rank -> name lineage of a taxid. The zero value is an
+// empty lineage.
+type taxLineage struct {
+	dump  *taxDump
+	spans lineageSpans
+}
+
+// get returns the name the lineage gives rank, "" for none. It does not
+// allocate: the name is a substring of the arena.
+func (l taxLineage) get(rank string) string {
+	if l.dump == nil {
+		return ""
+	}
+	s := &l.spans
+	for i := range int(s.n) {
+		if l.dump.lineageRanks[s.rank[i]] == rank {
+			return l.dump.names[s.off[i] : s.off[i]+uint32(s.len[i])]
+		}
+	}
+	return ""
+}
+
+// toMap returns the lineage as a rank -> name map.
+func (l taxLineage) toMap() map[string]string {
+	m := make(map[string]string, l.spans.n)
+	if l.dump == nil {
+		return m
+	}
+	s := &l.spans
+	for i := range int(s.n) {
+		m[l.dump.lineageRanks[s.rank[i]]] = l.dump.names[s.off[i] : s.off[i]+uint32(s.len[i])]
+	}
+	return m
+}
+
+// lineageCacheShards splits the lineage cache so concurrent lineage calls
+// rarely contend for a lock.
+const lineageCacheShards = 64
+
+// lineageCache maps taxids to their lineageSpans. Each shard holds at most
+// its share of the entry bound, evicting by the clock algorithm: a hit sets
+// the slot's reference bit, and the hand clears set bits until it finds a
+// slot to replace.
+type lineageCache struct {
+	shards [lineageCacheShards]lineageShard
+}
+
+type lineageShard struct {
+	mu    sync.Mutex
+	index map[int]int32 // taxid -> slot
+	slots []lineageSlot
+	hand  int
+	limit int // 0 is unbounded
+}
+
+type lineageSlot struct {
+	taxid int
+	ref   bool
+	spans lineageSpans
+}
+
+// newLineageCache returns a cache of at most entries lineages (0 means
+// unbounded).
+func newLineageCache(entries int) *lineageCache {
+	c := &lineageCache{}
+	limit := 0
+	if entries > 0 {
+		limit = max(1, (entries+lineageCacheShards-1)/lineageCacheShards)
+	}
+	for i := range c.shards {
+		c.shards[i].index = make(map[int]int32)
+		c.shards[i].limit = limit
+	}
+	return c
+}
+
+func (c *lineageCache) shard(taxid int) *lineageShard {
+	return &c.shards[uint64(taxid)*0x9E3779B97F4A7C15>>58]
+}
+
+// get copies the cached lineage of taxid into spans.
+func (c *lineageCache) get(taxid int, spans *lineageSpans) bool {
+	s := c.shard(taxid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.index[taxid]
+	if ok {
+		s.slots[i].ref = true
+		*spans = s.slots[i].spans
+	}
+	return ok
+}
+
+// put caches the lineage of taxid unless another goroutine already has.
+func (c *lineageCache) put(taxid int, spans *lineageSpans) {
+	s := c.shard(taxid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[taxid]; ok {
+		return
+	}
+	if s.limit == 0 || len(s.slots) < s.limit {
+		s.index[taxid] = int32(len(s.slots))
+		s.slots = append(s.slots, lineageSlot{taxid: taxid, spans: *spans})
+		return
+	}
+	for s.slots[s.hand].ref {
+		s.slots[s.hand].ref = false
+		s.hand = (s.hand + 1) % len(s.slots)
+	}
+	slot := &s.slots[s.hand]
+	delete(s.index, slot.taxid)
+	slot.taxid, slot.spans = taxid, *spans
+	s.index[taxid] = int32(s.hand)
+	s.hand = (s.hand + 1) % len(s.slots)
+}
+
+// len returns the number of cached lineages.
+func (c *lineageCache) len() int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		n += len(s.slots)
+		s.mu.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Taxdump change kinds, in report order. processid_added and
+// processid_removed are only counted.
+var taxdumpChangeKinds = []string{
+	"added",
+	"removed",
+	"merged",
+	"renamed",
+	"reparented",
+	"rank_changed",
+	"taxid_changed",
+	"processid_added",
+	"processid_removed",
+}
+
+// taxdumpChange is one difference: ID is a taxid, or a processid for
+// taxid_changed. Old and New are names, lineages (reparented), ranks, or
+// taxids (taxid_changed); a merged taxid has its old name and new taxid.
+type taxdumpChange struct {
+	Change string `json:"change"`
+	ID     string `json:"id"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// taxdumpDiffReport is the taxdump-diff -json report.
+type taxdumpDiffReport struct {
+	reportHeader
+	OldDir  string          `json:"old_dir"`
+	NewDir  string          `json:"new_dir"`
+	Summary map[string]int  `json:"summary"`
+	Changes []taxdumpChange `json:"changes"`
+}
+
+// diffExamples bounds the changes the text summary lists per kind.
+const diffExamples = 5
+
+func runTaxdumpDiff(args []string) {
+	fs := flag.NewFlagSet("taxdump-diff", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Print a taxdump-diff JSON report with every change")
+	tsvOut := fs.Bool("tsv", false, "Print every change as change/id/old/new TSV rows")
+	output := fs.String("output", "", "Write the output here instead of stdout (.gz compresses)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: boldkit taxdump-diff [options] old-dir new-dir")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *jsonOut && *tsvOut {
+		fatalf("-json and -tsv are exclusive")
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		out, err := createOutput(*output)
+		if err != nil {
+			fatalf("create output: %v", err)
+		}
+		defer func() {
+			if err := out.Close(); err != nil {
+				fatalf("close output: %v", err)
+			}
+		}()
+		w = out
+	}
+	if err := writeTaxdumpDiff(w, fs.Arg(0), fs.Arg(1), *jsonOut, *tsvOut); err != nil {
+		fatalf("taxdump-diff failed: %v", err)
+	}
+}
+
+// writeTaxdumpDiff diffs the taxdumps and writes a text summary, a JSON
+// report, or TSV change rows to w.
+func writeTaxdumpDiff(w io.Writer, oldDir, newDir string, jsonOut, tsvOut bool) error {
+	report := taxdumpDiffReport{
+		reportHeader: newReportHeader("taxdump-diff-report"),
+		OldDir:       oldDir,
+		NewDir:       newDir,
+		Changes:      []taxdumpChange{},
+	}
+	var emit func(taxdumpChange) error
+	var tw *tsvWriter
+	switch {
+	case jsonOut:
+		emit = func(c taxdumpChange) error {
+			report.Changes = append(report.Changes, c)
+			return nil
+		}
+	case tsvOut:
+		var err error
+		if tw, err = newTSVWriter(w, tsvWriterOptions{}); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader([]string{"change", "id", "old", "new"}); err != nil {
+			return err
+		}
+		emit = func(c taxdumpChange) error {
+			return tw.WriteRowStrings([]string{c.Change, c.ID, c.Old, c.New})
+		}
+	default:
+		seen := make(map[string]int)
+		emit = func(c taxdumpChange) error {
+			if seen[c.Change] < diffExamples {
+				report.Changes = append(report.Changes, c)
+			}
+			seen[c.Change]++
+			return nil
+		}
+	}
+
+	summary, err := diffTaxdumps(oldDir, newDir, emit)
+	if err != nil {
+		return err
+	}
+	report.Summary = summary
+	switch {
+	case jsonOut:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case tsvOut:
+		return tw.Flush()
+	}
+	return writeTaxdumpDiffText(w, report)
+}
+
+// diffTaxdumps calls emit with each difference between the taxdumps, in
+// taxid order within each pass, and returns the count of each kind. Both
+// dumps are held in the compact node tables; the old taxid.map is held by
+// processid while the new one streams past it.
+func diffTaxdumps(oldDir, newDir string, emit func(taxdumpChange) error) (map[string]int, error) {
+	summary := make(map[string]int, len(taxdumpChangeKinds))
+	for _, kind := range taxdumpChangeKinds {
+		summary[kind] = 0
+	}
+	oldDump, err := loadTaxDump(filepath.Join(oldDir, "nodes.dmp"), filepath.Join(oldDir, "names.dmp"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("load old taxdump: %w", err)
+	}
+	newDump, err := loadTaxDump(filepath.Join(newDir, "nodes.dmp"), filepath.Join(newDir, "names.dmp"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("load new taxdump: %w", err)
+	}
+	var emitErr error
+	add := func(kind string, id int, oldValue, newValue string) {
+		summary[kind]++
+		if emitErr == nil {
+			emitErr = emit(taxdumpChange{Change: kind, ID: strconv.Itoa(id), Old: oldValue, New: newValue})
+		}
+	}
+
+	oldDump.eachNode(func(taxid int, o taxNode) {
+		n, ok := newDump.node(taxid)
+		if !ok {
+			if cur, merged := newDump.current(taxid); merged {
+				add("merged", taxid, oldDump.name(o), strconv.Itoa(cur))
+			} else {
+				add("removed", taxid, oldDump.name(o), "")
+			}
+			return
+		}
+		if oldDump.name(o) != newDump.name(n) {
+			add("renamed", taxid, oldDump.name(o), newDump.name(n))
+		}
+		if o.parent != n.parent {
+			add("reparented", taxid, oldDump.lineagePath(taxid), newDump.lineagePath(taxid))
+		}
+		if oldDump.rank(o) != newDump.rank(n) {
+			add("rank_changed", taxid, oldDump.rank(o), newDump.rank(n))
+		}
+	})
+	newDump.eachNode(func(taxid int, n taxNode) {
+		if _, ok := oldDump.node(taxid); !ok {
+			add("added", taxid, "", newDump.name(n))
+		}
+	})
+	if emitErr != nil {
+		return nil, emitErr
+	}
+	if err := diffTaxidMaps(oldDir, newDir, summary, emit); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// diffTaxidMaps emits taxid_changed for each processid the taxid.maps give
+// different taxids, and counts those only one has. A taxdump without
+// taxid.map skips the comparison.
+func diffTaxidMaps(oldDir, newDir string, summary map[string]int, emit func(taxdumpChange) error) error {
+	oldPath, newPath := filepath.Join(oldDir, "taxid.map"), filepath.Join(newDir, "taxid.map")
+	if !fileExists(oldPath) || !fileExists(newPath) {
+		logf("taxdump-diff: taxid.map missing from one taxdump; skipping the processid comparison")
+		return nil
+	}
+	old := make(map[string]int32)
+	err := scanTaxidMap(oldPath, func(id []byte, taxid int) error {
+		old[string(id)] = int32(taxid)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = scanTaxidMap(newPath, func(id []byte, taxid int) error {
+		prev, ok := old[string(id)]
+		if !ok {
+			summary["processid_added"]++
+			return nil
+		}
+		delete(old, string(id))
+		if int(prev) == taxid {
+			return nil
+		}
+		summary["taxid_changed"]++
+		return emit(taxdumpChange{Change: "taxid_changed", ID: string(id), Old: strconv.Itoa(int(prev)), New: strconv.Itoa(taxid)})
+	})
+	if err != nil {
+		return err
+	}
+	summary["processid_removed"] = len(old)
+	return nil
+}
+
+func writeTaxdumpDiffText(w io.Writer, report taxdumpDiffReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "old\t%s\n", report.OldDir)
+	fmt.Fprintf(tw, "new\t%s\n", report.NewDir)
+	for _, kind := range taxdumpChangeKinds {
+		fmt.Fprintf(tw, "%s\t%d\n", kind, report.Summary[kind])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, kind := range taxdumpChangeKinds {
+		first := true
+		for _, c := range report.Changes {
+			if c.Change != kind {
+				continue
+			}
+			if first {
+				fmt.Fprintf(w, "\n%s (first %d):\n", kind, diffExamples)
+				first = false
+			}
+			switch {
+			case c.Old == "":
+				fmt.Fprintf(w, "  %s  %s\n", c.ID, c.New)
+			case c.New == "":
+				fmt.Fprintf(w, "  %s  %s\n", c.ID, c.Old)
+			default:
+				fmt.Fprintf(w, "  %s  %s -> %s\n", c.ID, c.Old, c.New)
+			}
+		}
+	}
+	return nil
+}
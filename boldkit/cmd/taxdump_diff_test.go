@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffTaxdumps(t *testing.T) {
+	dir := t.TempDir()
+	oldDir, newDir := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	for _, d := range []string{oldDir, newDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeDmps(t, oldDir, []string{
+		"1|1|no rank|root",
+		"2|1|kingdom|Animalia",
+		"3|2|family|Canidae",
+		"4|2|family|Felidae",
+		"5|3|genus|Canis",
+		"6|5|species|Canis lupus",
+		"7|5|species|Canis lupsu",
+		"8|3|genus|Vulpes",
+	})
+	writeDmps(t, newDir, []string{
+		"1|1|no rank|root",
+		"2|1|kingdom|Metazoa", // renamed
+		"3|2|family|Canidae",
+		"4|2|family|Felidae",
+		"5|4|genus|Canis", // re-parented
+		"6|5|species|Canis lupus",
+		"8|3|subgenus|Vulpes", // rank changed
+		"9|3|genus|Urocyon",   // added
+		"10|9|species|Urocyon cinereoargenteus",
+	})
+	files := map[string]string{
+		filepath.Join(newDir, "merged.dmp"): "7\t|\t6\t|\n",
+		filepath.Join(oldDir, "taxid.map"):  "P1\t6\nP2\t7\nP3\t8\n",
+		filepath.Join(newDir, "taxid.map"):  "P1\t6\nP2\t6\nP4\t10\n",
+	}
+	for path, body := range files {
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var changes []taxdumpChange
+	summary, err := diffTaxdumps(oldDir, newDir, func(c taxdumpChange) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		"added": 2, "removed": 0, "merged": 1, "renamed": 1, "reparented": 1, "rank_changed": 1,
+		"taxid_changed": 1, "processid_added": 1, "processid_removed": 1,
+	}
+	if !maps.Equal(summary, want) {
+		t.Fatalf("summary = %v, want %v", summary, want)
+	}
+	wantChanges := []taxdumpChange{
+		{"renamed", "2", "Animalia", "Metazoa"},
+		{"reparented", "5", "Animalia;Canidae;Canis", "Metazoa;Felidae;Canis"},
+		{"merged", "7", "Canis lupsu", "6"},
+		{"rank_changed", "8", "genus", "subgenus"},
+		{"added", "9", "", "Urocyon"},
+		{"added", "10", "", "Urocyon cinereoargenteus"},
+		{"taxid_changed", "P2", "7", "6"},
+	}
+	if len(changes) != len(wantChanges) {
+		t.Fatalf("changes = %v", changes)
+	}
+	for i := range changes {
+		if changes[i] != wantChanges[i] {
+			t.Errorf("change %d = %v, want %v", i, changes[i], wantChanges[i])
+		}
+	}
+
+	var tsv, js bytes.Buffer
+	if err := writeTaxdumpDiff(&tsv, oldDir, newDir, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tsv.String(), "change\tid\told\tnew\nrenamed\t2\tAnimalia\tMetazoa\nreparented\t5\tAnimalia;Canidae;Canis\tMetazoa;Felidae;Canis\n") {
+		t.Fatalf("tsv:\n%s", tsv.String())
+	}
+	if err := writeTaxdumpDiff(&js, oldDir, newDir, true, false); err != nil {
+		t.Fatal(err)
+	}
+	var report taxdumpDiffReport
+	if err := json.Unmarshal(js.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != len(wantChanges) || report.Summary["merged"] != 1 {
+		t.Fatalf("json: %+v", report)
+	}
+}
@@ -0,0 +1,374 @@
+package cmd
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// listFlag is a repeatable flag of comma-separated values.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, splitList(value)...)
+	return nil
+}
+
+type taxdumpSubsetConfig struct {
+	TaxdumpDir string
+	OutDir     string
+	// RootTaxa are names or taxids; RootTaxids pick among homonyms.
+	RootTaxa   []string
+	RootTaxids []int
+	// FilterFastas is a marker_fastas directory whose FASTAs are filtered
+	// to the subset into FastaOutDir (empty skips it).
+	FilterFastas string
+	FastaOutDir  string
+	ReportPath   string
+}
+
+// taxdumpSubsetReport is the taxdump-subset report: the roots selected,
+// and the nodes, taxid.map rows, and sequences kept.
+type taxdumpSubsetReport struct {
+	reportHeader
+	TaxdumpDir    string              `json:"taxdump_dir"`
+	OutDir        string              `json:"outdir"`
+	Roots         []subsetRoot        `json:"roots"`
+	Nodes         int                 `json:"nodes"`
+	NodesKept     int                 `json:"nodes_kept"`
+	SubtreeNodes  int                 `json:"subtree_nodes"`
+	TaxidRows     int                 `json:"taxid_rows"`
+	TaxidRowsKept int                 `json:"taxid_rows_kept"`
+	Fastas        []subsetFastaCounts `json:"fastas,omitempty"`
+}
+
+type subsetRoot struct {
+	Taxid int    `json:"taxid"`
+	Name  string `json:"name"`
+	Rank  string `json:"rank"`
+	// NestedIn is the selected root this one lies under, whose subtree
+	// already holds it.
+	NestedIn int `json:"nested_in,omitempty"`
+}
+
+type subsetFastaCounts struct {
+	File    string `json:"file"`
+	Records int    `json:"records"`
+	Kept    int    `json:"kept"`
+}
+
+func runTaxdumpSubset(args []string) {
+	fs := flag.NewFlagSet("taxdump-subset", flag.ExitOnError)
+	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Taxdump directory with nodes.dmp/names.dmp/taxid.map")
+	outDir := fs.String("outdir", "", "Output taxdump directory (required)")
+	var rootTaxa, rootTaxids listFlag
+	fs.Var(&rootTaxa, "root-taxon", "Subtree root by scientific name or taxid (repeatable, comma-separated)")
+	fs.Var(&rootTaxids, "root-taxid", "Subtree root by taxid, to pick among homonyms (repeatable, comma-separated)")
+	filterFastas := fs.String("filter-fastas", "", "marker_fastas directory whose FASTAs are filtered to sequences inside the subtrees")
+	fastaOutDir := fs.String("fasta-outdir", "", "Output directory of the filtered FASTAs (default: <outdir>/marker_fastas)")
+	report := fs.String("report", "", "Output JSON report (default: <outdir>/subset_report.json)")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+	if *outDir == "" {
+		fatalf("outdir is required")
+	}
+	cfg := taxdumpSubsetConfig{
+		TaxdumpDir:   *taxdumpDir,
+		OutDir:       *outDir,
+		RootTaxa:     rootTaxa,
+		FilterFastas: *filterFastas,
+		FastaOutDir:  *fastaOutDir,
+		ReportPath:   *report,
+	}
+	for _, v := range rootTaxids {
+		taxid, err := strconv.Atoi(v)
+		if err != nil {
+			fatalf("invalid -root-taxid %q", v)
+		}
+		cfg.RootTaxids = append(cfg.RootTaxids, taxid)
+	}
+	if len(cfg.RootTaxa)+len(cfg.RootTaxids) == 0 {
+		fatalf("at least one -root-taxon or -root-taxid is required")
+	}
+	if _, err := subsetTaxdump(cfg); err != nil {
+		fatalf("taxdump-subset failed: %v", err)
+	}
+}
+
+// subsetTaxdump writes the nodes of the selected subtrees and their
+// ancestors up to the root, with the taxid.map rows inside the subtrees,
+// and filters the marker FASTAs to those rows when asked.
+func subsetTaxdump(cfg taxdumpSubsetConfig) (taxdumpSubsetReport, error) {
+	report := taxdumpSubsetReport{
+		reportHeader: newReportHeader("taxdump-subset-report"),
+		TaxdumpDir:   cfg.TaxdumpDir,
+		OutDir:       cfg.OutDir,
+	}
+	if cfg.FastaOutDir == "" {
+		cfg.FastaOutDir = filepath.Join(cfg.OutDir, "marker_fastas")
+	}
+	if cfg.ReportPath == "" {
+		cfg.ReportPath = filepath.Join(cfg.OutDir, "subset_report.json")
+	}
+	dump, err := loadTaxDump(filepath.Join(cfg.TaxdumpDir, "nodes.dmp"), filepath.Join(cfg.TaxdumpDir, "names.dmp"), nil)
+	if err != nil {
+		return report, err
+	}
+	roots, err := resolveSubsetRoots(dump, cfg.RootTaxa, cfg.RootTaxids)
+	if err != nil {
+		return report, err
+	}
+	inside := subtreeMembership(dump, roots)
+	keep := make(map[int]struct{})
+	dump.eachNode(func(taxid int, _ taxNode) {
+		report.Nodes++
+		if inside(taxid) {
+			keep[taxid] = struct{}{}
+			report.SubtreeNodes++
+		}
+	})
+	for _, root := range roots {
+		n, _ := dump.node(root)
+		r := subsetRoot{Taxid: root, Name: dump.name(n), Rank: dump.rank(n)}
+		// Keep the ancestors up to the root of the tree.
+		for cur, depth := root, 0; int(n.parent) != cur && depth < 128; depth++ {
+			cur = int(n.parent)
+			keep[cur] = struct{}{}
+			if slices.Contains(roots, cur) && r.NestedIn == 0 {
+				r.NestedIn = cur
+				logf("taxdump-subset: root %s (%d) lies under root %d", r.Name, root, cur)
+			}
+			var ok bool
+			if n, ok = dump.node(cur); !ok {
+				break
+			}
+		}
+		report.Roots = append(report.Roots, r)
+	}
+	report.NodesKept = len(keep)
+
+	if err := os.MkdirAll(cfg.OutDir, 0o755); err != nil {
+		return report, fmt.Errorf("create subset dir: %w", err)
+	}
+	if err := writePrunedNodes(filepath.Join(cfg.OutDir, "nodes.dmp"), dump, keep); err != nil {
+		return report, err
+	}
+	if err := writePrunedNames(filepath.Join(cfg.OutDir, "names.dmp"), dump, keep); err != nil {
+		return report, err
+	}
+	ids, err := writeSubsetTaxidMap(cfg, dump, inside, &report)
+	if err != nil {
+		return report, err
+	}
+	if cfg.FilterFastas != "" {
+		if report.Fastas, err = filterMarkerFastas(cfg.FilterFastas, cfg.FastaOutDir, ids); err != nil {
+			return report, err
+		}
+	}
+	if err := writeReportJSON(cfg.ReportPath, report); err != nil {
+		return report, err
+	}
+	records, kept := 0, 0
+	for _, f := range report.Fastas {
+		records += f.Records
+		kept += f.Kept
+	}
+	logf("taxdump-subset: kept %d of %d nodes (%d in subtrees), %d of %d taxid.map rows, %d of %d sequences -> %s",
+		report.NodesKept, report.Nodes, report.SubtreeNodes, report.TaxidRowsKept, report.TaxidRows, kept, records, cfg.OutDir)
+	return report, nil
+}
+
+// resolveSubsetRoots returns the taxids of the root taxa, each once. A
+// numeric taxon is a taxid; a name must name one node, case-insensitively,
+// unless rootTaxids picks among its homonyms.
+func resolveSubsetRoots(dump *taxDump, taxa []string, rootTaxids []int) ([]int, error) {
+	var roots []int
+	add := func(taxid int) {
+		if !slices.Contains(roots, taxid) {
+			roots = append(roots, taxid)
+		}
+	}
+	for _, taxid := range rootTaxids {
+		if _, ok := dump.node(taxid); !ok {
+			return nil, fmt.Errorf("root taxid %d is not in nodes.dmp", taxid)
+		}
+		add(taxid)
+	}
+	for _, taxon := range taxa {
+		if taxid, err := strconv.Atoi(taxon); err == nil {
+			if _, ok := dump.node(taxid); !ok {
+				return nil, fmt.Errorf("root taxid %d is not in nodes.dmp", taxid)
+			}
+			add(taxid)
+			continue
+		}
+		var matches []int
+		dump.eachNode(func(taxid int, n taxNode) {
+			if strings.EqualFold(dump.name(n), taxon) {
+				matches = append(matches, taxid)
+			}
+		})
+		switch picked := slices.DeleteFunc(slices.Clone(matches), func(id int) bool { return !slices.Contains(rootTaxids, id) }); {
+		case len(matches) == 0:
+			return nil, fmt.Errorf("root taxon %q is not in names.dmp", taxon)
+		case len(matches) == 1:
+			add(matches[0])
+		case len(picked) == 0:
+			return nil, fmt.Errorf("root taxon %q is a homonym (%s); pick one with -root-taxid", taxon, describeHomonyms(dump, matches))
+		}
+	}
+	return roots, nil
+}
+
+// describeHomonyms lists taxids with their rank and parent name.
+func describeHomonyms(dump *taxDump, taxids []int) string {
+	parts := make([]string, 0, len(taxids))
+	for _, taxid := range taxids {
+		n, _ := dump.node(taxid)
+		parent, _ := dump.node(int(n.parent))
+		parts = append(parts, fmt.Sprintf("%d %s under %s", taxid, dump.rank(n), dump.name(parent)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// subtreeMembership returns a func reporting whether a taxid lies in the
+// subtree of one of roots, memoizing each path it walks.
+func subtreeMembership(dump *taxDump, roots []int) func(taxid int) bool {
+	memo := make(map[int]bool)
+	for _, root := range roots {
+		memo[root] = true
+	}
+	var path []int
+	return func(taxid int) bool {
+		path = path[:0]
+		in := false
+		for cur, depth := taxid, 0; depth < 128; depth++ {
+			if v, ok := memo[cur]; ok {
+				in = v
+				break
+			}
+			path = append(path, cur)
+			n, ok := dump.node(cur)
+			if !ok || int(n.parent) == cur {
+				break
+			}
+			cur = int(n.parent)
+		}
+		for _, id := range path {
+			memo[id] = in
+		}
+		return in
+	}
+}
+
+// writeSubsetTaxidMap writes the taxid.map rows inside the subtrees, under
+// their current taxids as the subset has no merged.dmp, and returns their
+// IDs.
+func writeSubsetTaxidMap(cfg taxdumpSubsetConfig, dump *taxDump, inside func(int) bool, report *taxdumpSubsetReport) (map[string]struct{}, error) {
+	f, err := createFile(filepath.Join(cfg.OutDir, "taxid.map"))
+	if err != nil {
+		return nil, fmt.Errorf("create taxid.map: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	w := bufio.NewWriterSize(f, writerBufferSize)
+	ids := make(map[string]struct{})
+	err = scanTaxidMap(filepath.Join(cfg.TaxdumpDir, "taxid.map"), func(pid []byte, taxid int) error {
+		report.TaxidRows++
+		taxid, _ = dump.current(taxid)
+		if !inside(taxid) {
+			return nil
+		}
+		report.TaxidRowsKept++
+		ids[string(pid)] = struct{}{}
+		_, err := w.WriteString(string(pid) + "\t" + strconv.Itoa(taxid) + "\n")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("write taxid.map: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("close taxid.map: %w", err)
+	}
+	return ids, nil
+}
+
+// filterMarkerFastas copies the records of each *.fasta and *.fasta.gz file
+// of dir whose ID is in ids to outDir, under the same name.
+func filterMarkerFastas(dir, outDir string, ids map[string]struct{}) ([]subsetFastaCounts, error) {
+	plain, _ := filepath.Glob(filepath.Join(dir, "*.fasta"))
+	gz, _ := filepath.Glob(filepath.Join(dir, "*.fasta.gz"))
+	paths := append(plain, gz...)
+	slices.Sort(paths)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.fasta or *.fasta.gz files in %s", dir)
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create fasta output dir: %w", err)
+	}
+	var counts []subsetFastaCounts
+	for _, path := range paths {
+		c, err := filterFasta(path, filepath.Join(outDir, filepath.Base(path)), ids)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+func filterFasta(input, output string, ids map[string]struct{}) (subsetFastaCounts, error) {
+	counts := subsetFastaCounts{File: filepath.Base(input)}
+	in, _, err := openFastaInput(input)
+	if err != nil {
+		return counts, fmt.Errorf("open %s: %w", input, err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := createOutput(output)
+	if err != nil {
+		return counts, fmt.Errorf("create %s: %w", output, err)
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+	buf := bufio.NewWriterSize(out, writerBufferSize)
+	fasta := newFastaWriter(buf, 0)
+	err = parseFasta(in, func(rec fastaRecord) error {
+		counts.Records++
+		if _, ok := ids[rec.id]; !ok {
+			return nil
+		}
+		counts.Kept++
+		header := rec.id
+		if rec.desc != "" {
+			header += " " + rec.desc
+		}
+		return fasta.Write(header, rec.seq)
+	})
+	if err != nil {
+		return counts, fmt.Errorf("read %s: %w", input, err)
+	}
+	if err := buf.Flush(); err != nil {
+		return counts, fmt.Errorf("write %s: %w", output, err)
+	}
+	if err := out.Close(); err != nil {
+		return counts, fmt.Errorf("close %s: %w", output, err)
+	}
+	return counts, nil
+}
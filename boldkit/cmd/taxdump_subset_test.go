@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSubsetTaxdump(t *testing.T) {
+	dir := t.TempDir()
+	taxdump := filepath.Join(dir, "taxdump")
+	if _, err := buildTaxdump(context.Background(), taxdumpBuildConfig{Input: filepath.Join("testdata", "taxdump", "input.tsv"), OutDir: taxdump}); err != nil {
+		t.Fatal(err)
+	}
+	markers := filepath.Join(dir, "marker_fastas")
+	if err := os.MkdirAll(markers, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fasta := ">P1\nACGT\n>P2\nACGT\n>P3 extra words\nACGT\n>P4\nACGT\n>P5\nACGT\n>P9\nACGT\n"
+	if err := os.WriteFile(filepath.Join(markers, "COI-5P.fasta"), []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := subsetTaxdump(taxdumpSubsetConfig{TaxdumpDir: taxdump, OutDir: filepath.Join(dir, "morus"), RootTaxa: []string{"Morus"}})
+	if err == nil || !strings.Contains(err.Error(), "-root-taxid") {
+		t.Fatalf("a homonym root: %v", err)
+	}
+
+	// Aves lies under Chordata.
+	out := filepath.Join(dir, "chordata")
+	report, err := subsetTaxdump(taxdumpSubsetConfig{
+		TaxdumpDir:   taxdump,
+		OutDir:       out,
+		RootTaxa:     []string{"chordata", "Aves"},
+		FilterFastas: markers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Nodes != 29 || report.SubtreeNodes != 12 || report.NodesKept != 14 || report.TaxidRows != 7 || report.TaxidRowsKept != 4 {
+		t.Fatalf("report: %+v", report)
+	}
+	if len(report.Roots) != 2 || report.Roots[0].NestedIn != 0 || report.Roots[1].NestedIn != report.Roots[0].Taxid {
+		t.Fatalf("roots: %+v", report.Roots)
+	}
+	if len(report.Fastas) != 1 || report.Fastas[0].Records != 6 || report.Fastas[0].Kept != 3 {
+		t.Fatalf("fastas: %+v", report.Fastas)
+	}
+	got, err := os.ReadFile(filepath.Join(out, "marker_fastas", "COI-5P.fasta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ">P1\nACGT\n>P3 extra words\nACGT\n>P5\nACGT\n"; string(got) != want {
+		t.Fatalf("filtered fasta:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(out, "subset_report.json")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := validateTaxdump(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.total() != 0 {
+		t.Fatalf("subset taxdump: %v %v", v.Violations, v.Examples)
+	}
+
+	// -root-taxid picks the plant Morus.
+	plant := filepath.Join(dir, "plant")
+	report, err = subsetTaxdump(taxdumpSubsetConfig{TaxdumpDir: taxdump, OutDir: plant, RootTaxa: []string{"Morus"}, RootTaxids: []int{27}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taxidMap, err := os.ReadFile(filepath.Join(plant, "taxid.map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(taxidMap) != "P4\t28\n" || report.SubtreeNodes != 2 {
+		t.Fatalf("plant Morus: %+v\n%s", report, taxidMap)
+	}
+}
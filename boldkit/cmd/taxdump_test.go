@@ -0,0 +1,571 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestTaxDumpMergedAndDeleted checks that a taxid merged (twice) into another
+// takes its lineage, and that a deleted taxid is reported as such rather than
+// as missing ranks, by both qc and format.
+func TestTaxDumpMergedAndDeleted(t *testing.T) {
+	dir := t.TempDir()
+	writeTaxdumpFixture(t, dir)
+	dmps := map[string]string{
+		"merged.dmp":   "70\t|\t7\t|\n71\t|\t70\t|\n",
+		"delnodes.dmp": "99\t|\n",
+		"taxid.map":    "P1\t7\nM1\t71\nD1\t99\nX1\t12345\n",
+	}
+	for name, body := range dmps {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dump, err := loadTaxDump(filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur, merged := dump.current(71); cur != 7 || !merged {
+		t.Fatalf("current(71) = %d, %v", cur, merged)
+	}
+	if !dump.isDeleted(99) || dump.isDeleted(7) {
+		t.Fatal("isDeleted")
+	}
+	if got := dump.lineage(71).get("species"); got != "Canis lupus" {
+		t.Fatalf("lineage(71) species = %q", got)
+	}
+
+	input := filepath.Join(dir, "in.fasta")
+	fasta := ">P1\nACGTACGTAC\n>M1\nACGTACGTAA\n>D1\nACGTACGTTT\n>X1\nACGTACGTCC\n"
+	if err := os.WriteFile(input, []byte(fasta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := runQCFasta(context.Background(), input, qcConfig{
+		MaxN:         -1,
+		MaxAmbig:     -1,
+		RequireRanks: []string{"species"},
+		TaxdumpDir:   dir,
+		OutputPath:   filepath.Join(dir, "out.fasta"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 2 || stats.MergedTaxID != 1 || stats.Dropped["deleted_taxid"] != 1 || stats.Dropped["missing_ranks"] != 1 {
+		t.Fatalf("%+v", stats)
+	}
+	if stats.ByRank["species"]["Canis lupus"].Kept != 2 {
+		t.Fatalf("by_rank: %+v", stats.ByRank["species"])
+	}
+
+	stats2, _, err := runFormatFasta(context.Background(), formatConfig{
+		Classifiers:  []string{"blast"},
+		RequireRanks: []string{"species"},
+		Input:        input,
+		OutDir:       filepath.Join(dir, "formatted"),
+		TaxdumpDir:   dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats2.Written != 2 || stats2.MergedTaxID != 1 || stats2.DeletedTaxID != 1 || stats2.MissingRanks != 1 {
+		t.Fatalf("format: %+v", stats2)
+	}
+}
+
+// writeSyntheticTaxdump writes an NCBI-shaped nodes.dmp and names.dmp of n
+// nodes to dir: a seven-rank tree whose taxids leave gaps, as NCBI's do.
+func writeSyntheticTaxdump(tb testing.TB, dir string, n int) {
+	tb.Helper()
+	ranks := []struct {
+		rank  string
+		count int
+	}{{"kingdom", 5}, {"phylum", 50}, {"class", 500}, {"order", 5_000}, {"family", 25_000}, {"genus", 120_000}, {"species", n}}
+	var nodes, names strings.Builder
+	fmt.Fprintf(&nodes, "1\t|\t1\t|\tno rank\t|\t\t|\n")
+	fmt.Fprintf(&names, "1\t|\troot\t|\t\t|\tscientific name\t|\n")
+	taxid := func(i int) int { return 2 + i + i/3 }
+	start, prevStart, prevCount := 0, -1, 0
+	written := 1
+	for _, r := range ranks {
+		for j := 0; j < r.count && written < n; j++ {
+			i := start + j
+			parent := 1
+			if prevStart >= 0 {
+				parent = taxid(prevStart + j%prevCount)
+			}
+			fmt.Fprintf(&nodes, "%d\t|\t%d\t|\t%s\t|\t\t|\n", taxid(i), parent, r.rank)
+			fmt.Fprintf(&names, "%d\t|\t%s%d\t|\t\t|\tscientific name\t|\n", taxid(i), strings.ToUpper(r.rank[:1])+r.rank[1:], i)
+			fmt.Fprintf(&names, "%d\t|\tsyn%d\t|\t\t|\tsynonym\t|\n", taxid(i), i)
+			written++
+		}
+		prevStart, prevCount = start, r.count
+		start += r.count
+	}
+	for name, body := range map[string]string{"nodes.dmp": nodes.String(), "names.dmp": names.String()} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLoadTaxDump loads a million-node taxdump and reports the heap
+// it retains.
+func BenchmarkLoadTaxDump(b *testing.B) {
+	dir := b.TempDir()
+	writeSyntheticTaxdump(b, dir, 1_000_000)
+	nodes, names := filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp")
+	b.ResetTimer()
+	var retained float64
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		b.StartTimer()
+		dump, err := loadTaxDump(nodes, names, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		retained = float64(int64(after.HeapAlloc) - int64(before.HeapAlloc))
+		// Node 200000 is a species.
+		if dump.lineage(2+200_000+200_000/3).get("kingdom") == "" {
+			b.Fatal("no lineage")
+		}
+		runtime.KeepAlive(dump)
+		b.StartTimer()
+	}
+	b.ReportMetric(retained/1_000_000, "B/node")
+}
+
+// TestTaxDumpLineageConcurrent hammers lineage from 16 goroutines through a
+// cache small enough to evict constantly; run it with -race.
+func TestTaxDumpLineageConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	const n = 20_000
+	writeSyntheticTaxdump(t, dir, n)
+	dump, err := loadTaxDump(filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump.cache = newLineageCache(1_000)
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for g := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 3 * n {
+				j := (i*7 + g*131) % (n - 1)
+				taxid := 2 + j + j/3
+				got, want := dump.lineage(taxid), dump.walkLineage(taxid)
+				if !maps.Equal(got.toMap(), want.toMap()) {
+					errs <- fmt.Errorf("lineage(%d) = %v, want %v", taxid, got.toMap(), want.toMap())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if got := dump.cache.len(); got > 1_000+lineageCacheShards {
+		t.Fatalf("cache holds %d lineages, want at most about 1000", got)
+	}
+}
+
+// lineageSink keeps the benchmarked maps on the heap, as a cache held them.
+var lineageSink map[string]string
+
+// BenchmarkTaxDumpLineage looks up species lineages through the cache, and
+// with the rank -> name map the cache used to hold per taxid for
+// comparison of allocations.
+func BenchmarkTaxDumpLineage(b *testing.B) {
+	dir := b.TempDir()
+	const n, firstSpecies = 400_000, 150_555
+	writeSyntheticTaxdump(b, dir, n)
+	dump, err := loadTaxDump(filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	taxid := func(i int) int {
+		j := firstSpecies + i%(n-1-firstSpecies)
+		return 2 + j + j/3
+	}
+	b.Run("spans", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if dump.lineage(taxid(i)).get("species") == "" {
+				b.Fatal("no species")
+			}
+		}
+	})
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			lineageSink = dump.lineage(taxid(i)).toMap()
+			if lineageSink["species"] == "" {
+				b.Fatal("no species")
+			}
+		}
+	})
+}
+
+// TestTaxDumpSparseTaxids checks lineages through taxids far past the node
+// count, which the dense table leaves to the sparse map.
+func TestTaxDumpSparseTaxids(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"nodes.dmp": "1\t|\t1\t|\tno rank\t|\n900000000\t|\t1\t|\tsuperkingdom\t|\n3\t|\t900000000\t|\tgenus\t|\n900000001\t|\t3\t|\tspecies\t|\n",
+		"names.dmp": "900000000\t|\tBacteria\t|\t\t|\tscientific name\t|\n3\t|\tAus\t|\t\t|\tscientific name\t|\n900000001\t|\tAus bus\t|\t\t|\tscientific name\t|\n900000001\t|\tAus cus\t|\t\t|\tsynonym\t|\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dump, err := loadTaxDump(filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.sparse) != 2 {
+		t.Fatalf("%d sparse nodes, want 2", len(dump.sparse))
+	}
+	got := dump.lineage(900000001).toMap()
+	want := map[string]string{"kingdom": "Bacteria", "genus": "Aus", "species": "Aus bus"}
+	if !maps.Equal(got, want) {
+		t.Fatalf("lineage = %v, want %v", got, want)
+	}
+	if _, ok := dump.node(2); ok {
+		t.Fatal("a gap in the dense table reads as a node")
+	}
+}
+
+// TestTaxDumpHandle checks that a handle loads its taxdump once and shares
+// it, and that other directories and aliases get a private copy.
+func TestTaxDumpHandle(t *testing.T) {
+	dir := t.TempDir()
+	writeTaxdumpFixture(t, dir)
+	h := newTaxDumpHandle(dir, nil)
+	if h.dump != nil {
+		t.Fatal("the handle loaded before first use")
+	}
+	first, err := h.load(dir+"/", defaultRankAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := h.load(dir, defaultRankAliases)
+	if err != nil || second != first {
+		t.Fatalf("second load returned another dump (%v)", err)
+	}
+	botanical, err := parseRankAliases("botanical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, err := h.load(dir, botanical); err != nil || other == first {
+		t.Fatalf("other aliases shared the dump (%v)", err)
+	}
+	var none *taxDumpHandle
+	if dump, err := none.load(dir, defaultRankAliases); err != nil || dump == first {
+		t.Fatalf("a nil handle shared the dump (%v)", err)
+	}
+}
+
+// taxdumpBuildOutputs are the files buildTaxdump writes, golden-checked in
+// testdata/taxdump.
+var taxdumpBuildOutputs = []string{"nodes.dmp", "names.dmp", "merged.dmp", "delnodes.dmp", "taxid.map", defaultTaxidAssignments}
+
+// TestBuildTaxdumpGolden builds a taxdump holding a genus homonym (Morus
+// under Sulidae and Moraceae), null ranks, and rows without a lineage or a
+// processid, and checks it builds the same twice and loads.
+func TestBuildTaxdumpGolden(t *testing.T) {
+	input := filepath.Join("testdata", "taxdump", "input.tsv")
+	var first map[string][]byte
+	for run := 0; run < 2; run++ {
+		dir := t.TempDir()
+		stats, err := buildTaxdump(context.Background(), taxdumpBuildConfig{Input: input, OutDir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := taxdumpBuildStats{Rows: 9, Mapped: 7, NoLineage: 1, MissingID: 1, Taxa: 28, New: 28, Homonyms: 1}
+		if stats != want {
+			t.Fatalf("stats = %+v, want %+v", stats, want)
+		}
+		got := readTaxdumpOutputs(t, dir)
+		if run == 1 {
+			if !maps.EqualFunc(got, first, bytes.Equal) {
+				t.Fatal("a second build differs from the first")
+			}
+			continue
+		}
+		first = got
+		for _, name := range taxdumpBuildOutputs {
+			path := filepath.Join("testdata", "taxdump", name)
+			if *updateGolden {
+				if err := os.WriteFile(path, got[name], 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got[name], want) {
+				t.Errorf("%s mismatch\n got:\n%s\nwant:\n%s", name, got[name], want)
+			}
+		}
+
+		dump, err := loadTaxDump(filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lineages := map[string]map[string]string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(got["taxid.map"])), "\n") {
+			pid, taxid, _ := strings.Cut(line, "\t")
+			id, err := strconv.Atoi(taxid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lineages[pid] = dump.lineage(id).toMap()
+		}
+		if lineages["P3"]["family"] != "Sulidae" || lineages["P4"]["family"] != "Moraceae" || lineages["P3"]["genus"] != "Morus" {
+			t.Fatalf("homonym lineages: %v / %v", lineages["P3"], lineages["P4"])
+		}
+		if _, ok := lineages["P1"]["subfamily"]; ok {
+			t.Fatalf("a None subfamily was built: %v", lineages["P1"])
+		}
+	}
+}
+
+// TestTaxDumpNameClasses loads a taxdump whose nodes lack a scientific name
+// and checks the fallback through synonym, common and equivalent names, the
+// scientific-only load, and the validation counts.
+func TestTaxDumpNameClasses(t *testing.T) {
+	dir := filepath.Join("testdata", "taxdump_synonyms")
+	dump, err := loadTaxDump(filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int][2]string{
+		3: {"Oldidae", "synonym"},
+		4: {"Aus", "scientific name"},
+		5: {"bus fly", "common name"},
+		6: {"Aus cus", "equivalent name"},
+		7: {"", ""},
+		8: {"", ""},
+	}
+	for taxid, w := range want {
+		n, _ := dump.node(taxid)
+		if got := [2]string{dump.name(n), dump.nameClass(n)}; got != w {
+			t.Errorf("taxid %d: name, class = %q, want %q", taxid, got, w)
+		}
+	}
+	if got := dump.lineage(5).toMap(); got["family"] != "Oldidae" || got["species"] != "bus fly" {
+		t.Fatalf("lineage(5) = %v", got)
+	}
+
+	scientific, err := loadTaxDumpClasses(filepath.Join(dir, "nodes.dmp"), filepath.Join(dir, "names.dmp"), nil, []string{"scientific name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scientific.lineage(5).toMap(); !maps.Equal(got, map[string]string{"kingdom": "Animalia", "genus": "Aus"}) {
+		t.Fatalf("scientific-only lineage(5) = %v", got)
+	}
+
+	v, err := validateTaxdump(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.NoScientificName != 5 || v.Unnamed != 2 || v.total() != 0 {
+		t.Fatalf("validation: %+v", v)
+	}
+}
+
+// TestBuildTaxdumpKeepsTaxids rebuilds from a later input and checks that
+// shared taxa keep their taxids, a taxon moved to a new parent too, and the
+// dropped ones land in delnodes.dmp.
+func TestBuildTaxdumpKeepsTaxids(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.tsv")
+	second := filepath.Join(dir, "second.tsv")
+	header := "kingdom\tgenus\tspecies\tprocessid\n"
+	inputs := map[string]string{
+		first:  header + "Animalia\tCanis\tCanis lupus\tP1\nAnimalia\tVulpes\tVulpes vulpes\tP2\nPlantae\tQuercus\tQuercus robur\tP3\n",
+		second: header + "Animalia\tUrsus\tUrsus arctos\tP4\nAnimalia\tCanis\tCanis lupus\tP1\nFungi\tQuercus\tQuercus robur\tP3\n",
+	}
+	for path, body := range inputs {
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outDir := filepath.Join(dir, "taxdump")
+	if _, err := buildTaxdump(context.Background(), taxdumpBuildConfig{Input: first, OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	before := readTaxidMap(t, outDir)
+	stats, err := buildTaxdump(context.Background(), taxdumpBuildConfig{Input: second, OutDir: outDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.New != 3 || stats.Deleted != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	after := readTaxidMap(t, outDir)
+	if after["P1"] != before["P1"] || after["P3"] != before["P3"] {
+		t.Fatalf("taxids moved: %v -> %v", before, after)
+	}
+	if after["P4"] <= before["P2"] {
+		t.Fatalf("new taxon P4 reused an old taxid: %v -> %v", before, after)
+	}
+	dump, err := loadTaxDump(filepath.Join(outDir, "nodes.dmp"), filepath.Join(outDir, "names.dmp"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dump.isDeleted(before["P2"]) || dump.isDeleted(before["P1"]) {
+		t.Fatalf("delnodes.dmp: %v", dump.deleted)
+	}
+	if got := dump.lineage(after["P3"]).get("kingdom"); got != "Fungi" {
+		t.Fatalf("P3 kingdom = %q", got)
+	}
+
+	// A third build from the first input takes the deleted taxids back.
+	if _, err := buildTaxdump(context.Background(), taxdumpBuildConfig{Input: first, OutDir: outDir}); err != nil {
+		t.Fatal(err)
+	}
+	if again := readTaxidMap(t, outDir); again["P2"] != before["P2"] {
+		t.Fatalf("P2 returned as %d, want %d", again["P2"], before["P2"])
+	}
+}
+
+func readTaxdumpOutputs(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	out := make(map[string][]byte, len(taxdumpBuildOutputs))
+	for _, name := range taxdumpBuildOutputs {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[name] = data
+	}
+	return out
+}
+
+func readTaxidMap(t *testing.T, dir string) map[string]int {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "taxid.map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		pid, taxid, _ := strings.Cut(line, "\t")
+		id, err := strconv.Atoi(taxid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[pid] = id
+	}
+	return out
+}
+
+// TestValidateTaxdump checks a taxdump with one violation of each kind, and
+// that a built taxdump and the synthetic one pass.
+func TestValidateTaxdump(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"nodes.dmp": strings.Join([]string{
+			"1\t|\t1\t|\tno rank\t|",
+			"2\t|\t1\t|\tkingdom\t|",
+			"3\t|\t2\t|\tgenus\t|",
+			"4\t|\t3\t|\tspecies\t|",
+			"5\t|\t4\t|\tgenus\t|", // a genus under a species breaks the order once
+			"6\t|\t5\t|\tno rank\t|",
+			"7\t|\t6\t|\tspecies\t|",  // the species below it is in order
+			"8\t|\t99\t|\tspecies\t|", // missing parent
+			"9\t|\t10\t|\tno rank\t|", // 9 -> 10 -> 11 -> 9
+			"10\t|\t11\t|\tno rank\t|",
+			"11\t|\t9\t|\tno rank\t|",
+			"12\t|\t11\t|\tspecies\t|", // hangs off the cycle
+			"4\t|\t3\t|\tspecies\t|",   // duplicate
+			"13\t|\t13\t|\tno rank\t|", // a second root
+			"oops",
+		}, "\n") + "\n",
+		"names.dmp":  "1\t|\troot\t|\t\t|\tscientific name\t|\n4\t|\tAus bus\t|\t\t|\tscientific name\t|\n42\t|\tLost\t|\t\t|\tscientific name\t|\n",
+		"merged.dmp": "50\t|\t4\t|\n",
+		"taxid.map":  "A\t4\nB\t50\nC\t77\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := validateTaxdump(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		violationMalformed:       1,
+		violationDuplicateTaxid:  1,
+		violationRoot:            1,
+		violationMissingParent:   1,
+		violationCycle:           1,
+		violationRankOrder:       1,
+		violationOrphanName:      1,
+		violationUnresolvedTaxid: 1,
+	}
+	if !maps.Equal(v.Violations, want) {
+		t.Fatalf("violations = %v, want %v\n%v", v.Violations, want, v.Examples)
+	}
+	if v.Nodes != 13 || v.Names != 3 || v.Mapped != 3 {
+		t.Fatalf("counts: %+v", v)
+	}
+	if got := v.Examples[violationCycle][0]; got != "cycle of 3 taxids: 9 -> 10 -> 11 -> 9" {
+		t.Fatalf("cycle example = %q", got)
+	}
+
+	built := t.TempDir()
+	if _, err := buildTaxdump(context.Background(), taxdumpBuildConfig{Input: filepath.Join("testdata", "taxdump", "input.tsv"), OutDir: built}); err != nil {
+		t.Fatal(err)
+	}
+	synthetic := t.TempDir()
+	writeSyntheticTaxdump(t, synthetic, 200_000)
+	for _, dir := range []string{built, synthetic} {
+		v, err := validateTaxdump(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.total() != 0 {
+			t.Fatalf("%s: %v\n%v", dir, v.Violations, v.Examples)
+		}
+	}
+}
+
+// BenchmarkValidateTaxdump validates a million-node taxdump.
+func BenchmarkValidateTaxdump(b *testing.B) {
+	dir := b.TempDir()
+	writeSyntheticTaxdump(b, dir, 1_000_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v, err := validateTaxdump(dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if v.total() != 0 {
+			b.Fatalf("%v", v.Violations)
+		}
+	}
+}
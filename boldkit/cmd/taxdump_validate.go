@@ -0,0 +1,451 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Taxdump violation kinds, in report order.
+const (
+	violationMalformed       = "malformed_line"
+	violationDuplicateTaxid  = "duplicate_taxid"
+	violationRoot            = "root"
+	violationMissingParent   = "missing_parent"
+	violationCycle           = "cycle"
+	violationRankOrder       = "rank_order"
+	violationOrphanName      = "orphan_name"
+	violationUnresolvedTaxid = "unresolved_taxid"
+)
+
+var taxdumpViolationKinds = []string{
+	violationMalformed,
+	violationDuplicateTaxid,
+	violationRoot,
+	violationMissingParent,
+	violationCycle,
+	violationRankOrder,
+	violationOrphanName,
+	violationUnresolvedTaxid,
+}
+
+// maxViolationExamples bounds the examples kept per violation kind.
+const maxViolationExamples = 5
+
+// taxRankOrder lists ranks from the highest down; a ranked node must sit
+// below its nearest ranked ancestor. Ranks on one line share a level, and
+// ranks not listed ("no rank", "clade", ...) are not checked.
+var taxRankOrder = [][]string{
+	{"cellular root", "acellular root"},
+	{"superkingdom", "domain", "realm"},
+	{"kingdom"},
+	{"subkingdom"},
+	{"superphylum"},
+	{"phylum", "division"},
+	{"subphylum", "subdivision"},
+	{"superclass"},
+	{"class"},
+	{"subclass"},
+	{"infraclass"},
+	{"cohort"},
+	{"subcohort"},
+	{"superorder"},
+	{"order"},
+	{"suborder"},
+	{"infraorder"},
+	{"parvorder"},
+	{"superfamily"},
+	{"family"},
+	{"subfamily"},
+	{"tribe"},
+	{"subtribe"},
+	{"genus"},
+	{"subgenus"},
+	{"section"},
+	{"subsection"},
+	{"series"},
+	{"subseries"},
+	{"species group"},
+	{"species subgroup"},
+	{"species"},
+	{"forma specialis", "subspecies"},
+	{"varietas", "variety"},
+	{"subvariety"},
+	{"forma", "form"},
+	{"serogroup"},
+	{"serotype"},
+	{"strain", "isolate"},
+}
+
+// taxRankLevels maps each rank of taxRankOrder to its level, 0 highest.
+var taxRankLevels = func() map[string]int8 {
+	levels := make(map[string]int8)
+	for level, ranks := range taxRankOrder {
+		for _, rank := range ranks {
+			levels[rank] = int8(level)
+		}
+	}
+	return levels
+}()
+
+// taxdumpValidation is the validate-taxdump report: the entries checked and
+// the violations found, by kind, with the first few of each described.
+type taxdumpValidation struct {
+	reportHeader
+	TaxdumpDir string `json:"taxdump_dir"`
+	Nodes      int    `json:"nodes"`
+	Names      int    `json:"names"`
+	Mapped     int    `json:"mapped"`
+	// NoScientificName counts the nodes names.dmp gives no scientific
+	// name, and Unnamed those it gives no name of defaultNameClasses, so
+	// that lineages skip their rank.
+	NoScientificName int                 `json:"no_scientific_name"`
+	Unnamed          int                 `json:"unnamed"`
+	Violations       map[string]int      `json:"violations"`
+	Examples         map[string][]string `json:"examples,omitempty"`
+}
+
+// total returns the number of violations of every kind.
+func (v *taxdumpValidation) total() int {
+	n := 0
+	for _, count := range v.Violations {
+		n += count
+	}
+	return n
+}
+
+func (v *taxdumpValidation) add(kind, format string, args ...any) {
+	v.Violations[kind]++
+	if len(v.Examples[kind]) < maxViolationExamples {
+		v.Examples[kind] = append(v.Examples[kind], fmt.Sprintf(format, args...))
+	}
+}
+
+func runValidateTaxdump(args []string) {
+	fs := flag.NewFlagSet("validate-taxdump", flag.ExitOnError)
+	taxdumpDir := fs.String("taxdump-dir", "bold-taxdump", "Taxdump directory with nodes.dmp, names.dmp, and taxid.map")
+	jsonOut := fs.Bool("json", false, "Print a taxdump-validation JSON document instead of a summary")
+	warnOnly := fs.Bool("warn-only", false, "Exit zero even when the taxdump has violations")
+	if err := fs.Parse(args); err != nil {
+		fatalf("parse args failed: %v", err)
+	}
+
+	report, err := validateTaxdump(*taxdumpDir)
+	if err != nil {
+		fatalf("validate-taxdump failed: %v", err)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeTaxdumpValidationText(os.Stdout, report)
+	}
+	if err != nil {
+		fatalf("validate-taxdump failed: %v", err)
+	}
+	if n := report.total(); n > 0 {
+		if *warnOnly {
+			logf("WARNING: taxdump %s has %d violations", *taxdumpDir, n)
+			return
+		}
+		fatalf("taxdump %s has %d violations", *taxdumpDir, n)
+	}
+}
+
+// taxdumpCheck holds the nodes.dmp tree by index: parent the index of the
+// parent node (-1 when nodes.dmp has none) and level the taxRankOrder
+// level (-1 when unranked).
+type taxdumpCheck struct {
+	taxids []int
+	parent []int32
+	level  []int8
+	index  map[int]int32
+	// parents holds the parent taxids while nodes.dmp is read.
+	parents []int
+}
+
+// validateTaxdump checks the taxdump in dir: nodes.dmp has root taxid 1,
+// every parent exists, there are no cycles, and ranks descend along every
+// path; every names.dmp entry names a node; and every taxid.map taxid
+// resolves to a node, directly or through merged.dmp. nodes.dmp is held
+// in memory, the other files are streamed.
+func validateTaxdump(dir string) (*taxdumpValidation, error) {
+	v := &taxdumpValidation{
+		reportHeader: newReportHeader("taxdump-validation"),
+		TaxdumpDir:   dir,
+		Violations:   make(map[string]int, len(taxdumpViolationKinds)),
+		Examples:     make(map[string][]string),
+	}
+	for _, kind := range taxdumpViolationKinds {
+		v.Violations[kind] = 0
+	}
+	c, err := loadTaxdumpCheck(filepath.Join(dir, "nodes.dmp"), v)
+	if err != nil {
+		return nil, err
+	}
+	v.Nodes = len(c.taxids)
+	c.checkTree(v)
+	if err := c.checkNames(filepath.Join(dir, "names.dmp"), v); err != nil {
+		return nil, err
+	}
+	if err := c.checkTaxidMap(dir, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func loadTaxdumpCheck(path string, v *taxdumpValidation) (*taxdumpCheck, error) {
+	c := &taxdumpCheck{index: make(map[int]int32)}
+	var fields [][]byte
+	line := 0
+	err := scanDmpBytes(path, "nodes.dmp", func(text []byte) error {
+		line++
+		fields = appendDmpFields(fields[:0], text, 3)
+		if len(fields) < 3 {
+			v.add(violationMalformed, "nodes.dmp line %d: want taxid, parent, and rank", line)
+			return nil
+		}
+		id, ok1 := parseDmpInt(fields[0])
+		parent, ok2 := parseDmpInt(fields[1])
+		if !ok1 || !ok2 || parent > math.MaxInt32 {
+			v.add(violationMalformed, "nodes.dmp line %d: bad taxid", line)
+			return nil
+		}
+		if _, dup := c.index[id]; dup {
+			v.add(violationDuplicateTaxid, "nodes.dmp line %d: taxid %d listed again", line, id)
+			return nil
+		}
+		if len(c.taxids) >= math.MaxInt32 {
+			return fmt.Errorf("nodes.dmp has more than %d nodes", math.MaxInt32)
+		}
+		level, ok := taxRankLevels[string(fields[2])]
+		if !ok {
+			level = -1
+		}
+		c.index[id] = int32(len(c.taxids))
+		c.taxids = append(c.taxids, id)
+		c.parents = append(c.parents, parent)
+		c.level = append(c.level, level)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.parent = make([]int32, len(c.taxids))
+	for i, p := range c.parents {
+		if j, ok := c.index[p]; ok {
+			c.parent[i] = j
+			continue
+		}
+		c.parent[i] = -1
+		v.add(violationMissingParent, "taxid %d: parent %d is not in nodes.dmp", c.taxids[i], p)
+	}
+	c.parents = nil
+	return c, nil
+}
+
+// checkTree checks the root, cycles, and rank order in one pass: each walk
+// climbs from an unvisited node until it meets a checked node, the root, a
+// missing parent, or a node of its own path (a cycle), then checks ranks
+// back down the path, so every node is walked once.
+func (c *taxdumpCheck) checkTree(v *taxdumpValidation) {
+	if i, ok := c.index[rootTaxid]; !ok {
+		v.add(violationRoot, "taxid %d is not in nodes.dmp", rootTaxid)
+	} else if p := c.parent[i]; p >= 0 && p != i {
+		v.add(violationRoot, "root taxid %d has parent %d", rootTaxid, c.taxids[p])
+	}
+
+	const (
+		unvisited = iota
+		onPath
+		checked
+	)
+	state := make([]uint8, len(c.taxids))
+	// ranked is the level of each checked node's nearest ranked ancestor
+	// or itself, -1 when none.
+	ranked := make([]int8, len(c.taxids))
+	var path []int32
+	for start := range c.taxids {
+		if state[start] != unvisited {
+			continue
+		}
+		path = path[:0]
+		above := int8(-1)
+		for j := int32(start); ; {
+			state[j] = onPath
+			path = append(path, j)
+			p := c.parent[j]
+			if p == j && c.taxids[j] != rootTaxid {
+				v.add(violationRoot, "taxid %d is its own parent", c.taxids[j])
+			}
+			if p < 0 || p == j {
+				break
+			}
+			if state[p] == checked {
+				above = ranked[p]
+				break
+			}
+			if state[p] == onPath {
+				v.add(violationCycle, "%s", c.describeCycle(path, p))
+				break
+			}
+			j = p
+		}
+		for k := len(path) - 1; k >= 0; k-- {
+			n := path[k]
+			if level := c.level[n]; level >= 0 {
+				if above >= level {
+					v.add(violationRankOrder, "taxid %d (%s) is under a %s", c.taxids[n], taxRankOrder[level][0], taxRankOrder[above][0])
+				}
+				above = level
+			}
+			ranked[n] = above
+			state[n] = checked
+		}
+	}
+}
+
+// describeCycle lists the taxids of the cycle that closes when the last
+// node of path has parent p, itself on path.
+func (c *taxdumpCheck) describeCycle(path []int32, p int32) string {
+	from := len(path) - 1
+	for from > 0 && path[from] != p {
+		from--
+	}
+	ids := make([]string, 0, min(len(path)-from, 8)+2)
+	for _, n := range path[from:] {
+		if len(ids) == 8 {
+			ids = append(ids, "...")
+			break
+		}
+		ids = append(ids, strconv.Itoa(c.taxids[n]))
+	}
+	ids = append(ids, strconv.Itoa(c.taxids[p]))
+	return fmt.Sprintf("cycle of %d taxids: %s", len(path)-from, strings.Join(ids, " -> "))
+}
+
+// checkNames streams names.dmp, checking each entry names a node, and
+// counts the nodes without a scientific name or any name a taxDump loads.
+func (c *taxdumpCheck) checkNames(path string, v *taxdumpValidation) error {
+	const (
+		hasScientific = 1 << iota
+		hasName
+	)
+	named := make([]uint8, len(c.taxids))
+	var fields [][]byte
+	line := 0
+	err := scanDmpBytes(path, "names.dmp", func(text []byte) error {
+		line++
+		fields = appendDmpFields(fields[:0], text, 4)
+		if len(fields) < 2 {
+			v.add(violationMalformed, "names.dmp line %d: want taxid and name", line)
+			return nil
+		}
+		id, ok := parseDmpInt(fields[0])
+		if !ok {
+			v.add(violationMalformed, "names.dmp line %d: bad taxid", line)
+			return nil
+		}
+		v.Names++
+		i, ok := c.index[id]
+		if !ok {
+			v.add(violationOrphanName, "names.dmp line %d: %q names taxid %d, which is not in nodes.dmp", line, fields[1], id)
+			return nil
+		}
+		if len(fields) < 4 {
+			return nil
+		}
+		if class := string(fields[3]); class == "scientific name" {
+			named[i] |= hasScientific | hasName
+		} else if slices.Contains(defaultNameClasses, class) {
+			named[i] |= hasName
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, flags := range named {
+		if flags&hasScientific == 0 {
+			v.NoScientificName++
+		}
+		if flags&hasName == 0 {
+			v.Unnamed++
+		}
+	}
+	return nil
+}
+
+// checkTaxidMap streams taxid.map, checking each taxid resolves to a node,
+// directly or through merged.dmp. A taxdump without taxid.map is logged
+// and passes.
+func (c *taxdumpCheck) checkTaxidMap(dir string, v *taxdumpValidation) error {
+	path := filepath.Join(dir, "taxid.map")
+	if !fileExists(path) {
+		logf("validate-taxdump: no taxid.map in %s; skipping the taxid check", dir)
+		return nil
+	}
+	merged, err := loadMerged(filepath.Join(dir, "merged.dmp"))
+	if err != nil {
+		return err
+	}
+	dump := &taxDump{merged: merged}
+	line := 0
+	return scanDmpBytes(path, "taxid.map", func(text []byte) error {
+		line++
+		fields := bytes.Fields(text)
+		if len(fields) == 0 {
+			return nil
+		}
+		var id int
+		ok := len(fields) == 2
+		if ok {
+			id, ok = parseDmpInt(fields[1])
+		}
+		if !ok {
+			v.add(violationMalformed, "taxid.map line %d: want id and taxid", line)
+			return nil
+		}
+		v.Mapped++
+		if _, ok := c.index[id]; ok {
+			return nil
+		}
+		if cur, merged := dump.current(id); merged {
+			if _, ok := c.index[cur]; ok {
+				return nil
+			}
+		}
+		v.add(violationUnresolvedTaxid, "taxid.map line %d: %s maps to taxid %d, which is not in nodes.dmp", line, fields[0], id)
+		return nil
+	})
+}
+
+func writeTaxdumpValidationText(w io.Writer, v *taxdumpValidation) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "taxdump %s: %d nodes, %d names, %d mapped ids\n", v.TaxdumpDir, v.Nodes, v.Names, v.Mapped)
+	if v.NoScientificName > 0 {
+		fmt.Fprintf(bw, "%d nodes lack a scientific name (%d have no name at all)\n", v.NoScientificName, v.Unnamed)
+	}
+	for _, kind := range taxdumpViolationKinds {
+		fmt.Fprintf(bw, "%-18s %d\n", kind, v.Violations[kind])
+		for _, example := range v.Examples[kind] {
+			fmt.Fprintf(bw, "  %s\n", example)
+		}
+	}
+	if n := v.total(); n == 0 {
+		fmt.Fprintln(bw, "OK")
+	} else {
+		fmt.Fprintf(bw, "%d violations\n", n)
+	}
+	return bw.Flush()
+}
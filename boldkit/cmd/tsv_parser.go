@@ -2,10 +2,17 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"math"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,28 +22,369 @@ const (
 	defaultBufferSize = 1 << 20 // 1 MiB
 	defaultChunkSize  = 8 << 20 // 8 MiB
 	defaultBatchLines = 1024
+	// maxWorkers caps Options.Workers; past it goroutines and their queued
+	// batches only add memory.
+	maxWorkers = 1024
 )
 
+// minChunkSize is the smallest ChunkSize Validate accepts; smaller chunks
+// spend more time refilling than splitting lines. Tests lower it to cross
+// chunk boundaries on small inputs.
+var minChunkSize = 64 << 10
+
+// ErrUTF16Input is returned for input that starts with a UTF-16 byte order
+// mark; the parser only reads UTF-8.
+var ErrUTF16Input = errors.New("input appears to be UTF-16; re-export as UTF-8")
+
+// errBatchGap reports that an ordered parse lost a batch.
+var errBatchGap = errors.New("parsed batches out of sequence")
+
+// ByteProgress receives the number of input bytes the parser has read,
+// independent of how many rows they hold. Add is called from the reader
+// goroutine only.
+type ByteProgress interface {
+	Add(delta int64)
+}
+
+// ErrInvalidOptions is wrapped by the errors of Options.Validate.
+var ErrInvalidOptions = errors.New("invalid parser options")
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 // Options controls TSV parsing performance characteristics.
 type Options struct {
-	BufferSize           int  // Size of the bufio.Reader buffer
-	ChunkSize            int  // Bytes to read per chunk before splitting into lines
+	BufferSize           int  // Size of the bufio.Reader buffer; at most ChunkSize (default 1 MiB, or ChunkSize if smaller)
+	ChunkSize            int  // Bytes to read per chunk before splitting into lines; at least 64 KiB
 	BatchLines           int  // How many lines to hand to a worker at once
-	Workers              int  // Number of parsing workers
+	Workers              int  // Number of parsing workers; 1 with PreserveOrder parses on the calling goroutine
 	StrictColumns        bool // Enforce a fixed column count (first row if ExpectedColumns == 0)
 	ExpectedColumns      int  // Expected column count when StrictColumns is true (0 to infer from first row)
 	PreserveOrder        bool // Deliver rows in file order
 	AllowCRLF            bool // Trim trailing \r when present
+	MaxLineBytes         int  // Reject lines longer than this many bytes (0 disables)
+	RejectNUL            bool // Reject lines containing NUL bytes
+	QuotedFields         bool // Honour RFC 4180 quotes; Row.Line is then the record's first physical line
+	HasHeader            bool // Consume the first line as a Header; rows then support Field(name)
+	SkipEmptyLines       bool // Drop empty lines before parsing; they still count toward Row.Line
+	Delimiter            byte // Field separator; 0 means '\t'. '\n' and '\r' are rejected
 	Progress             *progress
+	ByteProgress         ByteProgress // Advanced by the input bytes read, once per chunk
 	SkipProgressFirstRow bool
 	Timeout              time.Duration
+	// CommentPrefix drops lines starting with it, like SkipEmptyLines (empty
+	// keeps every line). With QuotedFields, quotes inside a comment line
+	// still open a quoted field.
+	CommentPrefix string
+	// OnRowError enables lenient mode: malformed lines are handed to it instead
+	// of aborting the parse. Returning an error from it still aborts.
+	OnRowError func(RowError) error
+	// MaxErrors enables error accumulation: up to this many malformed lines
+	// are skipped and the parse keeps going, then returns a *RowErrors
+	// listing them. One more aborts the parse. 0 fails on the first.
+	MaxErrors int
+	// OnError is called for each line skipped under MaxErrors, in delivery
+	// order. The RowError's Raw is only valid for the duration of the call.
+	OnError func(line int64, err error)
+	// OnHeader is called once with the header when HasHeader is set, before
+	// any data row. Returning an error aborts the parse.
+	OnHeader func(*Header) error
+	// ProjectColumns limits Row.Fields to these column indexes, in this
+	// order. Columns past the end of a line come back empty. The header row
+	// is never projected.
+	ProjectColumns []int
+	// ProjectNames is ProjectColumns by column name; it requires HasHeader
+	// and fails the parse when a name is not in the header.
+	ProjectNames []string
+	// SampleEveryN keeps only every Nth data line, starting with the first;
+	// the rest are dropped by the reader before they are split. The header
+	// is always kept under HasHeader, and Row.Line still counts every line.
+	// 0 or 1 keeps every line.
+	SampleEveryN int64
+	// SampleLimit stops the parse after this many sampled data lines (0 for
+	// no limit). The rest of the input is not read.
+	SampleLimit int64
+	// StartOffset and EndOffset restrict the parse to a byte range of the
+	// input, for splitting one file across machines. The reader seeks to
+	// StartOffset and, unless it is 0, discards the partial line there; it
+	// stops at the first line starting past EndOffset (0 for the end of the
+	// input), so a line belongs to the range it starts in. Row.Line then
+	// counts from the range start and Row.Offset stays absolute. Offsets need
+	// an io.Seeker (such as *os.File) or io.ReaderAt input, and StartOffset
+	// rules out HasHeader and QuotedFields.
+	StartOffset int64
+	EndOffset   int64
+	// DecompressWorkers bounds the goroutines decompressing a .gz input
+	// opened by path (ParseRows); <= 0 means GOMAXPROCS and 1 keeps the
+	// single-threaded reader. ParseTSV itself reads r as given.
+	DecompressWorkers int
+	// Stats, when set, is reset at the start of the parse and filled in as
+	// it runs.
+	Stats *ParseStats
+}
+
+// ParseStats describes a finished or running parse. Counters are updated
+// atomically, so they may be sampled from another goroutine with
+// sync/atomic loads; after the parse returns they can be read directly.
+type ParseStats struct {
+	Rows    int64 `json:"rows"`    // rows delivered to the callback, header included
+	Bytes   int64 `json:"bytes"`   // bytes read from the input
+	Batches int64 `json:"batches"` // line batches handed to the workers
+	// MaxPendingBatches is the most batches the consumer held at once while
+	// waiting for an earlier one; 0 means batches arrived in order.
+	MaxPendingBatches int64         `json:"max_pending_batches"`
+	WallTime          time.Duration `json:"wall_time_ns"`
+	// WorkerRows counts the lines each worker split, indexed by worker.
+	WorkerRows []int64 `json:"worker_rows"`
+}
+
+func (s *ParseStats) reset(workers int) {
+	*s = ParseStats{WorkerRows: make([]int64, workers)}
+}
+
+func (s *ParseStats) notePending(n int) {
+	if s != nil && int64(n) > atomic.LoadInt64(&s.MaxPendingBatches) {
+		atomic.StoreInt64(&s.MaxPendingBatches, int64(n))
+	}
+}
+
+// String summarises the stats for a log line.
+func (s *ParseStats) String() string {
+	return fmt.Sprintf("%d rows, %s in %d batches (max %d pending) in %s; rows per worker %v",
+		s.Rows, formatMiB(uint64(s.Bytes)), s.Batches, s.MaxPendingBatches, s.WallTime.Round(time.Millisecond), s.WorkerRows)
 }
 
 // Row is a view over a TSV line. Fields point into an internal buffer and are
 // only valid for the duration of the callback in ParseTSV.
 type Row struct {
-	Line   int64
+	Line   int64 // 1-based, counted from StartOffset when it is set
+	Offset int64 // byte offset of the line in the (uncompressed) input; 0 for parquet
 	Fields [][]byte
+
+	raw    []byte
+	reject string
+	header *Header
+	width  int  // columns in the line when Fields is projected, else 0
+	first  bool // first line handed to the workers: the header under HasHeader
+}
+
+// columns returns the number of columns in the line, before projection.
+func (r Row) columns() int {
+	if r.width > 0 {
+		return r.width
+	}
+	return len(r.Fields)
+}
+
+// Header maps column names to indexes. When a name repeats, the first column
+// wins. A Header is safe to keep after the parse.
+type Header struct {
+	fields [][]byte
+	index  map[string]int
+	// projected maps a column index to its position in a projected row.
+	projected map[int]int
+}
+
+func newHeader(fields [][]byte) *Header {
+	h := &Header{
+		fields: make([][]byte, len(fields)),
+		index:  make(map[string]int, len(fields)),
+	}
+	for i, f := range fields {
+		h.fields[i] = append([]byte(nil), f...)
+		name := string(f)
+		if _, ok := h.index[name]; !ok {
+			h.index[name] = i
+		}
+	}
+	return h
+}
+
+// Index returns the column index of name in the file, or -1 when absent.
+// With a projection this differs from the name's position in Row.Fields.
+func (h *Header) Index(name string) int {
+	if h == nil {
+		return -1
+	}
+	if i, ok := h.index[name]; ok {
+		return i
+	}
+	return -1
+}
+
+// Names returns the column names in file order.
+func (h *Header) Names() []string {
+	if h == nil {
+		return nil
+	}
+	names := make([]string, len(h.fields))
+	for i, f := range h.fields {
+		names[i] = string(f)
+	}
+	return names
+}
+
+// Len returns the number of columns.
+func (h *Header) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// Header returns the parsed header, or nil without Options.HasHeader.
+func (r Row) Header() *Header {
+	return r.header
+}
+
+// Field returns the value of the named column, or nil when the column is
+// absent, not projected, or the row is short.
+func (r Row) Field(name string) []byte {
+	return fieldBytes(r.Fields, r.header.position(name))
+}
+
+// position returns where name lands in Row.Fields, or -1.
+func (h *Header) position(name string) int {
+	i := h.Index(name)
+	if i < 0 || h.projected == nil {
+		return i
+	}
+	if p, ok := h.projected[i]; ok {
+		return p
+	}
+	return -1
+}
+
+// headerRows wraps onRow for Options.HasHeader: the first row becomes the
+// header, which must be the first line of the input, and later rows carry it.
+func headerRows(opts Options, onRow func(Row) error) func(Row) error {
+	var header *Header
+	return func(row Row) error {
+		if header != nil {
+			row.header = header
+			return onRow(row)
+		}
+		var err error
+		header, err = parseHeader(opts, row)
+		return err
+	}
+}
+
+// headerBatches is headerRows for ParseTSVBatches.
+func headerBatches(opts Options, onBatch func([]Row) error) func([]Row) error {
+	var header *Header
+	return func(rows []Row) error {
+		if header == nil {
+			var err error
+			if header, err = parseHeader(opts, rows[0]); err != nil {
+				return err
+			}
+			rows = rows[1:]
+			if len(rows) == 0 {
+				return nil
+			}
+		}
+		for i := range rows {
+			rows[i].header = header
+		}
+		return onBatch(rows)
+	}
+}
+
+func parseHeader(opts Options, row Row) (*Header, error) {
+	if !row.first {
+		return nil, fmt.Errorf("line %d: header row was rejected", row.Line)
+	}
+	header := newHeader(row.Fields)
+	if opts.OnHeader != nil {
+		if err := opts.OnHeader(header); err != nil {
+			return nil, err
+		}
+	}
+	proj, err := resolveProjection(opts, row.Fields)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", row.Line, err)
+	}
+	if proj != nil {
+		header.projected = make(map[int]int, len(proj.cols))
+		for i, c := range proj.cols {
+			if _, dup := header.projected[c]; !dup {
+				header.projected[c] = i
+			}
+		}
+	}
+	return header, nil
+}
+
+// RowError describes a malformed line. Raw points into an internal buffer and
+// is only valid for the duration of the OnRowError callback.
+type RowError struct {
+	Line   int64
+	Offset int64 // absolute byte offset of the line, as Row.Offset
+	Reason string
+	Raw    []byte
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// RowErrors is the aggregate error returned under Options.MaxErrors. Errors
+// are in input order and carry no Raw bytes.
+type RowErrors struct {
+	Errors []RowError
+	Limit  int
+	// Exceeded is set when the parse stopped because more than Limit lines
+	// were malformed.
+	Exceeded bool
+	// Omitted counts the malformed lines past the first maxListedRowErrors
+	// when Limit is negative (no limit), which are counted but not kept.
+	Omitted int
+}
+
+// maxListedRowErrors caps how many lines Error spells out.
+const maxListedRowErrors = 10
+
+// add records a malformed line and reports whether the parse may go on:
+// false once more than Limit lines are malformed. A negative Limit never
+// stops the parse.
+func (e *RowErrors) add(rowErr RowError) bool {
+	if e.Limit < 0 && len(e.Errors) >= maxListedRowErrors {
+		e.Omitted++
+		return true
+	}
+	e.Errors = append(e.Errors, rowErr)
+	if e.Limit >= 0 && len(e.Errors) > e.Limit {
+		e.Exceeded = true
+		return false
+	}
+	return true
+}
+
+// skipped returns the number of malformed lines.
+func (e *RowErrors) skipped() int {
+	return len(e.Errors) + e.Omitted
+}
+
+func (e *RowErrors) Error() string {
+	var b strings.Builder
+	if e.Exceeded {
+		fmt.Fprintf(&b, "more than %d malformed lines", e.Limit)
+	} else {
+		fmt.Fprintf(&b, "%d malformed lines skipped", e.skipped())
+	}
+	listed := e.Errors[:min(len(e.Errors), maxListedRowErrors)]
+	for i, rowErr := range listed {
+		sep := ": "
+		if i > 0 {
+			sep = "; "
+		}
+		b.WriteString(sep)
+		b.WriteString(rowErr.Error())
+	}
+	if more := e.skipped() - len(listed); more > 0 {
+		fmt.Fprintf(&b, "; and %d more", more)
+	}
+	return b.String()
 }
 
 type bufferRef struct {
@@ -67,6 +415,11 @@ type lineBatch struct {
 	buf      *bufferRef
 	lines    [][]byte
 	lineNums []int64
+	offsets  []int64
+	proj     *projection
+	// columns is the column count StrictColumns enforces, 0 when it is off
+	// or not known yet (every earlier line was rejected).
+	columns int
 }
 
 type parseResult struct {
@@ -85,6 +438,7 @@ func DefaultOptions() Options {
 		Workers:       runtime.GOMAXPROCS(0),
 		PreserveOrder: true,
 		AllowCRLF:     true,
+		Delimiter:     '\t',
 	}
 }
 
@@ -100,35 +454,171 @@ func (o Options) WithAllowCRLF(allow bool) Options {
 	return o
 }
 
+// withDefaults fills unset options. It does not check them; Validate
+// rejects a delimiter the line scanner or quote handling would misread.
 func (o Options) withDefaults() Options {
-	if o.BufferSize <= 0 {
-		o.BufferSize = defaultBufferSize
-	}
 	if o.ChunkSize <= 0 {
 		o.ChunkSize = defaultChunkSize
 	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = min(defaultBufferSize, o.ChunkSize)
+	}
 	if o.BatchLines <= 0 {
 		o.BatchLines = defaultBatchLines
 	}
 	if o.Workers <= 0 {
 		o.Workers = runtime.GOMAXPROCS(0)
 	}
+	if o.Delimiter == 0 {
+		o.Delimiter = '\t'
+	}
 	return o
 }
 
+// Validate reports options the parser cannot honour. Zero values select the
+// defaults and are always valid. Errors wrap ErrInvalidOptions and name the
+// offending field; ParseTSV and its variants return them before starting
+// any goroutine.
+func (o Options) Validate() error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+	}
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"BufferSize", int64(o.BufferSize)},
+		{"ChunkSize", int64(o.ChunkSize)},
+		{"BatchLines", int64(o.BatchLines)},
+		{"Workers", int64(o.Workers)},
+		{"ExpectedColumns", int64(o.ExpectedColumns)},
+		{"MaxLineBytes", int64(o.MaxLineBytes)},
+		{"MaxErrors", int64(o.MaxErrors)},
+		{"Timeout", int64(o.Timeout)},
+		{"SampleEveryN", o.SampleEveryN},
+		{"SampleLimit", o.SampleLimit},
+		{"StartOffset", o.StartOffset},
+		{"EndOffset", o.EndOffset},
+	} {
+		if f.value < 0 {
+			return invalid("%s %d must not be negative", f.name, f.value)
+		}
+	}
+	switch {
+	case o.Workers > maxWorkers:
+		return invalid("Workers %d exceeds the limit of %d", o.Workers, maxWorkers)
+	case o.ChunkSize > 0 && o.BatchLines > o.ChunkSize:
+		// Every line takes at least its '\n'.
+		return invalid("BatchLines %d can never fill from a %d-byte ChunkSize", o.BatchLines, o.ChunkSize)
+	case o.ChunkSize > 0 && o.ChunkSize < minChunkSize:
+		return invalid("ChunkSize %d is below the minimum of %d bytes", o.ChunkSize, minChunkSize)
+	case cmp.Or(o.ChunkSize, defaultChunkSize) < o.BufferSize:
+		// Each chunk is one Read through the buffer; buffering more than a
+		// chunk only holds memory.
+		return invalid("ChunkSize %d is smaller than BufferSize %d", cmp.Or(o.ChunkSize, defaultChunkSize), o.BufferSize)
+	case o.EndOffset > 0 && o.EndOffset < o.StartOffset:
+		return invalid("EndOffset %d is before StartOffset %d", o.EndOffset, o.StartOffset)
+	case o.StartOffset > 0 && o.HasHeader:
+		return invalid("HasHeader needs StartOffset 0: the header is the first line of the input")
+	case o.StartOffset > 0 && o.QuotedFields:
+		return invalid("QuotedFields needs StartOffset 0: a range may start inside a quoted field")
+	case o.Delimiter == '\n' || o.Delimiter == '\r':
+		return invalid("Delimiter %q is a line terminator", o.Delimiter)
+	case o.Delimiter == '"' && o.QuotedFields:
+		return invalid("Delimiter %q is the quote character of QuotedFields", o.Delimiter)
+	}
+	if err := checkProjection(o); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+	if err := checkRowErrorOptions(o); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+	return nil
+}
+
 // ParseTSV streams a TSV from r, invoking onRow for each line. It keeps memory
 // bounded by reusing chunk buffers; row data is only valid inside onRow.
 func ParseTSV(r io.Reader, opts Options, onRow func(Row) error) error {
-	opts = opts.withDefaults()
+	return ParseTSVContext(context.Background(), r, opts, onRow)
+}
 
+// ParseTSVContext is ParseTSV with external cancellation. Cancelling ctx stops
+// the reader, workers, and consumer, releases pooled buffers, and returns
+// ctx.Err(). A Read already blocked in r is not interrupted; cancellation
+// takes effect once it returns.
+func ParseTSVContext(parent context.Context, r io.Reader, opts Options, onRow func(Row) error) error {
+	return parseTSV(parent, r, opts, func(opts Options) func(context.Context, []Row) error {
+		if opts.HasHeader {
+			onRow = headerRows(opts, onRow)
+		}
+		return func(ctx context.Context, rows []Row) error {
+			for _, row := range rows {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err := onRow(row); err != nil {
+					return err
+				}
+				if opts.Stats != nil {
+					atomic.AddInt64(&opts.Stats.Rows, 1)
+				}
+			}
+			return nil
+		}
+	})
+}
+
+// ParseTSVBatches is ParseTSV handing onBatch all the valid rows of a worker
+// batch at once, saving a call per row. The slice and the rows' data are
+// only valid for the duration of the call, and the slice may be modified.
+// Rows are delivered in the same order as ParseTSV would deliver them;
+// rejected lines split a batch so error callbacks keep their place.
+func ParseTSVBatches(r io.Reader, opts Options, onBatch func(rows []Row) error) error {
+	return ParseTSVBatchesContext(context.Background(), r, opts, onBatch)
+}
+
+// ParseTSVBatchesContext is ParseTSVBatches with external cancellation, as
+// for ParseTSVContext.
+func ParseTSVBatchesContext(parent context.Context, r io.Reader, opts Options, onBatch func(rows []Row) error) error {
+	return parseTSV(parent, r, opts, func(opts Options) func(context.Context, []Row) error {
+		if opts.HasHeader {
+			onBatch = headerBatches(opts, onBatch)
+		}
+		return func(_ context.Context, rows []Row) error {
+			if err := onBatch(rows); err != nil {
+				return err
+			}
+			if opts.Stats != nil {
+				atomic.AddInt64(&opts.Stats.Rows, int64(len(rows)))
+			}
+			return nil
+		}
+	})
+}
+
+// parseTSV runs the parse behind ParseTSVContext and ParseTSVBatchesContext.
+// deliver builds the consumer callback from the defaulted options; it gets
+// the parse's own context, which also ends on Timeout and on errors.
+func parseTSV(parent context.Context, r io.Reader, opts Options, deliver func(Options) func(context.Context, []Row) error) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	opts = opts.withDefaults()
+	if opts.Stats != nil {
+		opts.Stats.reset(opts.Workers)
+		start := time.Now()
+		defer func() {
+			atomic.StoreInt64((*int64)(&opts.Stats.WallTime), int64(time.Since(start)))
+		}()
+	}
 	var (
 		ctx    context.Context
 		cancel context.CancelFunc
 	)
 	if opts.Timeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), opts.Timeout)
+		ctx, cancel = context.WithTimeout(parent, opts.Timeout)
 	} else {
-		ctx, cancel = context.WithCancel(context.Background())
+		ctx, cancel = context.WithCancel(parent)
 	}
 	defer cancel()
 
@@ -138,23 +628,40 @@ func ParseTSV(r io.Reader, opts Options, onRow func(Row) error) error {
 		},
 	}
 
+	r, err := seekInput(r, opts)
+	if err != nil {
+		return err
+	}
+	onBatch := deliver(opts)
+	if opts.Workers == 1 && opts.PreserveOrder {
+		return parseSync(ctx, r, opts, bufPool, cancel, onBatch)
+	}
+
 	batches := make(chan *lineBatch, opts.Workers*2)
 	results := make(chan parseResult, opts.Workers*2)
 	readErrCh := make(chan error, 1)
 
 	go func() {
 		reader := bufio.NewReaderSize(r, opts.BufferSize)
-		readErrCh <- readBatches(ctx, reader, opts, bufPool, batches)
+		readErrCh <- readBatches(ctx, reader, opts, bufPool, func(batch *lineBatch) error {
+			select {
+			case batches <- batch:
+				return nil
+			case <-ctx.Done():
+				batch.buf.release()
+				return context.Canceled
+			}
+		})
 		close(batches)
 	}()
 
 	var workerWG sync.WaitGroup
 	for i := 0; i < opts.Workers; i++ {
 		workerWG.Add(1)
-		go func() {
+		go func(id int) {
 			defer workerWG.Done()
-			workerLoop(opts, batches, results)
-		}()
+			workerLoop(ctx, opts, id, batches, results)
+		}(i)
 	}
 
 	go func() {
@@ -162,7 +669,9 @@ func ParseTSV(r io.Reader, opts Options, onRow func(Row) error) error {
 		close(results)
 	}()
 
-	err := consumeResults(ctx, opts, results, cancel, onRow)
+	err = consumeResults(ctx, opts, results, cancel, func(rows []Row) error {
+		return onBatch(ctx, rows)
+	})
 	if err != nil {
 		cancel()
 	}
@@ -184,14 +693,15 @@ func ParseTSV(r io.Reader, opts Options, onRow func(Row) error) error {
 // consumer safe from buffer reuse. Errors are sent on errCh after the rows
 // channel closes.
 func ParseTSVChan(ctx context.Context, r io.Reader, opts Options) (<-chan Row, <-chan error) {
-	opts = opts.withDefaults()
+	// Invalid options are reported on errCh by ParseTSVContext.
+	sized := opts.withDefaults()
 
-	rowsCh := make(chan Row, opts.BatchLines)
+	rowsCh := make(chan Row, sized.BatchLines)
 	errCh := make(chan error, 1)
 
 	go func() {
 		defer close(rowsCh)
-		errCh <- ParseTSV(r, opts, func(row Row) error {
+		errCh <- ParseTSVContext(ctx, r, opts, func(row Row) error {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
@@ -205,10 +715,38 @@ func ParseTSVChan(ctx context.Context, r io.Reader, opts Options) (<-chan Row, <
 	return rowsCh, errCh
 }
 
+// Rows returns an iterator over the rows of r for range-over-func loops.
+// Rows are copied as with ParseTSVChan, so they stay valid in and after the
+// loop body. A parse error is yielded once, with a zero Row, after the last
+// row. Breaking out of the loop cancels the parse and returns only once its
+// goroutines have exited and their buffers are released.
+func Rows(r io.Reader, opts Options) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		rowsCh, errCh := ParseTSVChan(ctx, r, opts)
+		for row := range rowsCh {
+			if !yield(row, nil) {
+				cancel()
+				for range rowsCh {
+				}
+				<-errCh
+				return
+			}
+		}
+		if err := <-errCh; err != nil {
+			yield(Row{}, err)
+		}
+	}
+}
+
 func copyRow(row Row) Row {
 	copied := Row{
 		Line:   row.Line,
+		Offset: row.Offset,
 		Fields: make([][]byte, len(row.Fields)),
+		header: row.header,
+		width:  row.width,
 	}
 	for i, f := range row.Fields {
 		dst := make([]byte, len(f))
@@ -218,11 +756,202 @@ func copyRow(row Row) Row {
 	return copied
 }
 
-func readBatches(ctx context.Context, r *bufio.Reader, opts Options, pool *sync.Pool, batches chan<- *lineBatch) error {
+// quoteState tracks RFC 4180 quoting while scanning for record ends. A quote
+// only opens a quoted field at the start of a field; elsewhere it is literal.
+type quoteState uint8
+
+const (
+	quoteFieldStart quoteState = iota
+	quoteUnquoted
+	quoteQuoted
+	quoteClosing // saw '"' in a quoted field: the closing quote or half of ""
+)
+
+// next advances over b and reports whether b terminates the record. delim is
+// the field separator.
+func (s *quoteState) next(b, delim byte) bool {
+	switch *s {
+	case quoteQuoted:
+		if b == '"' {
+			*s = quoteClosing
+		}
+		return false
+	case quoteClosing:
+		if b == '"' {
+			*s = quoteQuoted
+			return false
+		}
+	case quoteFieldStart:
+		if b == '"' {
+			*s = quoteQuoted
+			return false
+		}
+	}
+	switch b {
+	case '\n':
+		*s = quoteFieldStart
+		return true
+	case delim:
+		*s = quoteFieldStart
+	default:
+		*s = quoteUnquoted
+	}
+	return false
+}
+
+// consumed accounts n input bytes read by the reader goroutine in Stats and
+// ByteProgress.
+func (o *Options) consumed(n int64) {
+	if n == 0 {
+		return
+	}
+	if o.Stats != nil {
+		atomic.AddInt64(&o.Stats.Bytes, n)
+	}
+	if o.ByteProgress != nil {
+		o.ByteProgress.Add(n)
+	}
+}
+
+// skipBOM discards a UTF-8 byte order mark, which would otherwise stick to
+// the first header name, and rejects UTF-16 input before it turns into
+// column errors.
+func skipBOM(r *bufio.Reader, opts Options) (int64, error) {
+	head, _ := r.Peek(len(utf8BOM))
+	switch {
+	case bytes.HasPrefix(head, utf8BOM):
+		_, _ = r.Discard(len(utf8BOM))
+		opts.consumed(int64(len(utf8BOM)))
+		return int64(len(utf8BOM)), nil
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}), bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return 0, ErrUTF16Input
+	}
+	return 0, nil
+}
+
+// skipPartialLine discards the rest of the line StartOffset falls in, even
+// when the range starts right at its first byte: the previous range reads
+// past its EndOffset to finish it.
+func skipPartialLine(r *bufio.Reader, opts Options) (int64, error) {
+	var n int64
+	for {
+		chunk, err := r.ReadSlice('\n')
+		n += int64(len(chunk))
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		opts.consumed(n)
+		if err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// seekInput positions r at StartOffset when a byte range is set.
+func seekInput(r io.Reader, opts Options) (io.Reader, error) {
+	if opts.StartOffset == 0 && opts.EndOffset == 0 {
+		return r, nil
+	}
+	switch in := r.(type) {
+	case io.Seeker:
+		if _, err := in.Seek(opts.StartOffset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seek to offset %d: %w", opts.StartOffset, err)
+		}
+		return r, nil
+	case io.ReaderAt:
+		return io.NewSectionReader(in, opts.StartOffset, math.MaxInt64-opts.StartOffset), nil
+	}
+	return nil, errors.New("StartOffset and EndOffset need an io.Seeker or io.ReaderAt input")
+}
+
+// readBatches splits r into line batches and hands them to emit in file
+// order. emit owns each batch and its buffer reference; an error from it
+// stops the read.
+func readBatches(ctx context.Context, r *bufio.Reader, opts Options, pool *sync.Pool, emit func(*lineBatch) error) error {
+	// base is the input offset of the first byte of tail, which starts the
+	// next chunk.
+	base := opts.StartOffset
+	if opts.StartOffset == 0 {
+		n, err := skipBOM(r, opts)
+		if err != nil {
+			return err
+		}
+		base += n
+	} else {
+		n, err := skipPartialLine(r, opts)
+		if err != nil {
+			return err
+		}
+		base += n
+	}
+	// pastEnd reports whether a line starting at off is past EndOffset, which
+	// ends the parse.
+	endReached := false
+	pastEnd := func(off int64) bool {
+		endReached = opts.EndOffset > 0 && off > opts.EndOffset
+		return endReached
+	}
 	tail := make([]byte, 0, 1024)
 	var seq int64
 	var lineNum int64
 
+	// With QuotedFields a record may span chunks. The quote state and the
+	// number of tail bytes already scanned carry over so the tail is not
+	// rescanned, and embedded counts newlines inside the pending record.
+	var (
+		quote    quoteState
+		scanned  int
+		embedded int64
+	)
+
+	// A projection by name waits for the header on line 1. If it cannot be
+	// resolved, rows are split in full and headerRows reports the error.
+	proj, _ := newProjection(opts.ProjectColumns)
+	projPending := len(opts.ProjectNames) > 0
+
+	// The reader sees lines in file order, so it fixes the StrictColumns
+	// count from the first line the workers will accept, whatever order
+	// the workers finish in.
+	columns := 0
+	if opts.StrictColumns {
+		columns = opts.ExpectedColumns
+	}
+	inferColumns := func(lines [][]byte) {
+		if !opts.StrictColumns || columns > 0 {
+			return
+		}
+		for _, line := range lines {
+			if n, ok := lineColumns(opts, line); ok {
+				columns = n
+				return
+			}
+		}
+	}
+
+	// Sampling drops data lines here, like skipLine, so they are never
+	// split. sampled reaches SampleLimit once the reader may stop.
+	var dataLines, sampled int64
+	headerPending := opts.HasHeader
+	keepLine := func() bool {
+		if headerPending {
+			headerPending = false
+			return true
+		}
+		if opts.SampleLimit > 0 && sampled >= opts.SampleLimit {
+			return false
+		}
+		dataLines++
+		if opts.SampleEveryN > 1 && (dataLines-1)%opts.SampleEveryN != 0 {
+			return false
+		}
+		sampled++
+		return true
+	}
+	limitReached := func() bool {
+		return opts.SampleLimit > 0 && sampled >= opts.SampleLimit
+	}
+
 	for {
 		if ctx.Err() != nil {
 			return context.Canceled
@@ -237,6 +966,7 @@ func readBatches(ctx context.Context, r *bufio.Reader, opts Options, pool *sync.
 
 		copy(buf, tail)
 		n, err := r.Read(buf[len(tail):needed])
+		opts.consumed(int64(n))
 		if n == 0 && err == io.EOF {
 			slot.buf = buf[:cap(buf)]
 			pool.Put(slot)
@@ -252,25 +982,72 @@ func readBatches(ctx context.Context, r *bufio.Reader, opts Options, pool *sync.
 		data := buf[:dataLen]
 		lines := make([][]byte, 0, opts.BatchLines*2)
 		lineNums := make([]int64, 0, opts.BatchLines*2)
+		offsets := make([]int64, 0, opts.BatchLines*2)
+		skipped := 0
 
 		start := 0
-		for i, b := range data {
-			if b == '\n' {
-				line := data[start:i]
-				if opts.AllowCRLF && len(line) > 0 && line[len(line)-1] == '\r' {
-					line = line[:len(line)-1]
+		if opts.QuotedFields {
+			for i := scanned; i < len(data); i++ {
+				b := data[i]
+				if !quote.next(b, opts.Delimiter) {
+					if b == '\n' {
+						embedded++
+					}
+					continue
+				}
+				off := base + int64(start)
+				if pastEnd(off) {
+					break
 				}
+				line := trimCR(opts, data[start:i])
 				lineNum++
-				lines = append(lines, line)
-				lineNums = append(lineNums, lineNum)
+				if skipLine(opts, line) || !keepLine() {
+					skipped++
+				} else {
+					lines = append(lines, line)
+					lineNums = append(lineNums, lineNum)
+					offsets = append(offsets, off)
+				}
+				lineNum += embedded
+				embedded = 0
 				start = i + 1
 			}
+			scanned = len(data) - start
+		} else {
+			for i, b := range data {
+				if b == '\n' {
+					off := base + int64(start)
+					if pastEnd(off) {
+						break
+					}
+					line := trimCR(opts, data[start:i])
+					lineNum++
+					start = i + 1
+					if skipLine(opts, line) || !keepLine() {
+						skipped++
+						continue
+					}
+					lines = append(lines, line)
+					lineNums = append(lineNums, lineNum)
+					offsets = append(offsets, off)
+				}
+			}
 		}
 
 		tail = tail[:0]
 		if start < len(data) {
 			tail = append(tail, data[start:]...)
 		}
+		base += int64(start)
+		// Skipped lines never reach the consumer; count them here so bars
+		// sized from countLines still reach 100%.
+		opts.Progress.add(skipped)
+
+		if projPending && len(lines) > 0 {
+			proj, _ = resolveProjection(opts, splitHeaderLine(opts, lines[0]))
+			projPending = false
+		}
+		inferColumns(lines)
 
 		if len(lines) > 0 {
 			batchSize := opts.BatchLines
@@ -297,14 +1074,17 @@ func readBatches(ctx context.Context, r *bufio.Reader, opts Options, pool *sync.
 					buf:      ref,
 					lines:    lines[startIdx:endIdx],
 					lineNums: lineNums[startIdx:endIdx],
+					offsets:  offsets[startIdx:endIdx],
+					proj:     proj,
+					columns:  columns,
 				}
 				seq++
 
-				select {
-				case batches <- batch:
-				case <-ctx.Done():
-					ref.release()
-					return context.Canceled
+				if err := emit(batch); err != nil {
+					return err
+				}
+				if opts.Stats != nil {
+					atomic.AddInt64(&opts.Stats.Batches, 1)
 				}
 			}
 		} else {
@@ -312,7 +1092,7 @@ func readBatches(ctx context.Context, r *bufio.Reader, opts Options, pool *sync.
 			pool.Put(slot)
 		}
 
-		if err == io.EOF {
+		if err == io.EOF || limitReached() || endReached {
 			break
 		}
 		if err != nil {
@@ -320,6 +1100,13 @@ func readBatches(ctx context.Context, r *bufio.Reader, opts Options, pool *sync.
 		}
 	}
 
+	if limitReached() || endReached || pastEnd(base) {
+		tail = tail[:0]
+	}
+	if len(tail) > 0 && (skipLine(opts, trimCR(opts, tail)) || !keepLine()) {
+		opts.Progress.add(1)
+		tail = tail[:0]
+	}
 	if len(tail) > 0 {
 		slot := pool.Get().(*pooledBuf)
 		buf := slot.buf
@@ -333,92 +1120,292 @@ func readBatches(ctx context.Context, r *bufio.Reader, opts Options, pool *sync.
 			slot: slot,
 			ref:  1,
 		}
+		// The last line has no '\n', but a CRLF file may still end in '\r'.
 		lineNum++
+		lines := [][]byte{trimCR(opts, ref.buf)}
+		inferColumns(lines)
 		batch := &lineBatch{
 			seq:      seq,
 			buf:      ref,
-			lines:    [][]byte{ref.buf},
+			lines:    lines,
 			lineNums: []int64{lineNum},
+			offsets:  []int64{base},
+			proj:     proj,
+			columns:  columns,
 		}
-		select {
-		case batches <- batch:
-		case <-ctx.Done():
-			ref.release()
-			return context.Canceled
+		if err := emit(batch); err != nil {
+			return err
+		}
+		if opts.Stats != nil {
+			atomic.AddInt64(&opts.Stats.Batches, 1)
 		}
 	}
 
 	return nil
 }
 
-func workerLoop(opts Options, batches <-chan *lineBatch, results chan<- parseResult) {
+// skipLine reports whether line is dropped by SkipEmptyLines or
+// CommentPrefix.
+func skipLine(opts Options, line []byte) bool {
+	if opts.SkipEmptyLines && len(line) == 0 {
+		return true
+	}
+	return opts.CommentPrefix != "" && bytes.HasPrefix(line, []byte(opts.CommentPrefix))
+}
+
+// trimCR drops the '\r' of a CRLF line ending when AllowCRLF is set. The
+// '\r' may have arrived in the previous chunk: it is carried in the tail, so
+// line always holds the whole record.
+func trimCR(opts Options, line []byte) []byte {
+	if opts.AllowCRLF && len(line) > 0 && line[len(line)-1] == '\r' {
+		return line[:len(line)-1]
+	}
+	return line
+}
+
+func workerLoop(ctx context.Context, opts Options, id int, batches <-chan *lineBatch, results chan<- parseResult) {
 	for batch := range batches {
-		rows := make([]Row, 0, len(batch.lines))
-		for i, line := range batch.lines {
-			fields := splitFields(line, opts.ExpectedColumns)
+		if ctx.Err() != nil {
+			// Drain without parsing so the reader can finish promptly.
+			batch.buf.release()
+			continue
+		}
+		results <- parseBatch(opts, id, batch)
+	}
+}
+
+// parseBatch splits the lines of batch into rows on worker id.
+func parseBatch(opts Options, id int, batch *lineBatch) parseResult {
+	rows := make([]Row, 0, len(batch.lines))
+	for i, line := range batch.lines {
+		if reason := rejectLine(opts, line); reason != "" {
 			rows = append(rows, Row{
 				Line:   batch.lineNums[i],
-				Fields: fields,
+				Offset: batch.offsets[i],
+				raw:    line,
+				reject: reason,
 			})
+			continue
+		}
+		proj := batch.proj
+		if opts.HasHeader && batch.seq == 0 && i == 0 {
+			proj = nil
+		}
+		var fields [][]byte
+		width := 0
+		if opts.QuotedFields {
+			var ok bool
+			if fields, ok = splitQuotedFields(line, opts.ExpectedColumns, opts.Delimiter); !ok {
+				rows = append(rows, Row{
+					Line:   batch.lineNums[i],
+					Offset: batch.offsets[i],
+					raw:    line,
+					reject: "unterminated quoted field",
+				})
+				continue
+			}
+			if proj != nil {
+				width = len(fields)
+				fields = proj.pick(fields)
+			}
+		} else if proj != nil {
+			fields = proj.split(line, opts.Delimiter)
+			if opts.StrictColumns {
+				width = bytes.Count(line, []byte{opts.Delimiter}) + 1
+			}
+		} else {
+			fields = splitFields(line, opts.ExpectedColumns, opts.Delimiter)
+		}
+		row := Row{
+			Line:   batch.lineNums[i],
+			Offset: batch.offsets[i],
+			Fields: fields,
+			raw:    line,
+			width:  width,
 		}
-		results <- parseResult{
-			seq:  batch.seq,
-			rows: rows,
-			buf:  batch.buf,
+		if batch.columns > 0 && row.columns() != batch.columns {
+			row.reject = fmt.Sprintf("expected %d columns, got %d", batch.columns, row.columns())
 		}
+		rows = append(rows, row)
+	}
+	if batch.seq == 0 && len(rows) > 0 {
+		rows[0].first = true
+	}
+	if opts.Stats != nil {
+		atomic.AddInt64(&opts.Stats.WorkerRows[id], int64(len(rows)))
+	}
+	return parseResult{
+		seq:  batch.seq,
+		rows: rows,
+		buf:  batch.buf,
 	}
 }
 
-func consumeResults(ctx context.Context, opts Options, results <-chan parseResult, cancel context.CancelFunc, onRow func(Row) error) error {
-	expectedSeq := int64(0)
-	pending := make(map[int64]parseResult)
-	var err error
-	expectedColumns := opts.ExpectedColumns
-	var rowsSeen int64
+// lineColumns counts the columns of line the way workerLoop splits it. ok is
+// false for a line the workers reject.
+func lineColumns(opts Options, line []byte) (int, bool) {
+	if rejectLine(opts, line) != "" {
+		return 0, false
+	}
+	if opts.QuotedFields {
+		fields, ok := splitQuotedFields(line, 0, opts.Delimiter)
+		return len(fields), ok
+	}
+	return bytes.Count(line, []byte{opts.Delimiter}) + 1, true
+}
+
+func rejectLine(opts Options, line []byte) string {
+	if opts.MaxLineBytes > 0 && len(line) > opts.MaxLineBytes {
+		return fmt.Sprintf("line length %d exceeds limit %d", len(line), opts.MaxLineBytes)
+	}
+	if opts.RejectNUL && bytes.IndexByte(line, 0) >= 0 {
+		return "NUL byte in line"
+	}
+	return ""
+}
+
+// resultConsumer delivers parsed rows to the callback in the order results
+// are handed to process, turning rejected lines into row errors.
+type resultConsumer struct {
+	ctx      context.Context
+	opts     Options
+	cancel   context.CancelFunc
+	onBatch  func([]Row) error
+	err      error
+	rowsSeen int64
+	rowErrs  *RowErrors
+}
+
+func newResultConsumer(ctx context.Context, opts Options, cancel context.CancelFunc, onBatch func([]Row) error) *resultConsumer {
+	c := &resultConsumer{ctx: ctx, opts: opts, cancel: cancel, onBatch: onBatch}
+	if opts.MaxErrors > 0 {
+		c.rowErrs = &RowErrors{Limit: opts.MaxErrors}
+	}
+	return c
+}
+
+func (c *resultConsumer) rowError(row Row, reason string) error {
+	opts := c.opts
+	rowErr := RowError{Line: row.Line, Offset: row.Offset, Reason: reason, Raw: row.raw}
+	if opts.OnRowError != nil {
+		return opts.OnRowError(rowErr)
+	}
+	if c.rowErrs == nil {
+		return rowErr
+	}
+	if opts.OnError != nil {
+		opts.OnError(row.Line, rowErr)
+	}
+	rowErr.Raw = nil
+	c.rowErrs.Errors = append(c.rowErrs.Errors, rowErr)
+	if len(c.rowErrs.Errors) > opts.MaxErrors {
+		c.rowErrs.Exceeded = true
+		return c.rowErrs
+	}
+	return nil
+}
+
+// process delivers the rows of res and releases its buffer. After the first
+// error it only releases.
+func (c *resultConsumer) process(res parseResult) {
+	opts := c.opts
+	if res.err != nil && c.err == nil {
+		c.err = res.err
+		c.cancel()
+	}
+	if c.err != nil {
+		res.buf.release()
+		return
+	}
 
-	processResult := func(res parseResult) {
-		if res.err != nil && err == nil {
-			err = res.err
-			cancel()
+	// Valid rows are compacted in place and handed over in runs, so a
+	// rejected line is reported between the rows around it.
+	valid := res.rows[:0]
+	flush := func() {
+		if len(valid) > 0 && c.err == nil {
+			c.err = c.onBatch(valid)
 		}
-		if err != nil {
-			res.buf.release()
-			return
+		valid = valid[len(valid):]
+	}
+	for _, row := range res.rows {
+		if c.ctx.Err() != nil {
+			c.err = c.ctx.Err()
+			break
 		}
-
-		for _, row := range res.rows {
-			if ctx.Err() != nil {
-				err = ctx.Err()
-				break
-			}
-			if opts.StrictColumns {
-				if expectedColumns == 0 {
-					expectedColumns = len(row.Fields)
-				} else if len(row.Fields) != expectedColumns {
-					err = fmt.Errorf("line %d: expected %d columns, got %d", row.Line, expectedColumns, len(row.Fields))
-					break
-				}
+		if opts.Progress != nil {
+			if !(opts.SkipProgressFirstRow || opts.HasHeader) || c.rowsSeen != 0 {
+				opts.Progress.increment()
 			}
-			if opts.Progress != nil {
-				if !opts.SkipProgressFirstRow || rowsSeen != 0 {
-					opts.Progress.increment()
-				}
+		}
+		c.rowsSeen++
+		if row.reject != "" {
+			if flush(); c.err != nil {
+				break
 			}
-			rowsSeen++
-			if cbErr := onRow(row); cbErr != nil {
-				err = cbErr
+			if c.err = c.rowError(row, row.reject); c.err != nil {
 				break
 			}
+			continue
 		}
-		res.buf.release()
-		if err != nil {
-			cancel()
+		valid = append(valid, row)
+	}
+	flush()
+	res.buf.release()
+	if c.err != nil {
+		c.cancel()
+	}
+}
+
+// result returns the parse error, or the accumulated row errors under
+// MaxErrors.
+func (c *resultConsumer) result() error {
+	rowErrs := c.rowErrs
+	if rowErrs != nil && len(rowErrs.Errors) > 0 && (c.err == nil || c.err == error(rowErrs)) {
+		// Batches arrive out of order without PreserveOrder.
+		sort.Slice(rowErrs.Errors, func(i, j int) bool {
+			return rowErrs.Errors[i].Line < rowErrs.Errors[j].Line
+		})
+		return rowErrs
+	}
+	return c.err
+}
+
+// parseSync is the parse for one worker in file order: the calling
+// goroutine reads each chunk, splits its batches and delivers their rows
+// before reading on, so no goroutine or channel is involved. It returns the
+// same rows and errors as the concurrent path.
+func parseSync(ctx context.Context, r io.Reader, opts Options, pool *sync.Pool, cancel context.CancelFunc, onBatch func(context.Context, []Row) error) error {
+	c := newResultConsumer(ctx, opts, cancel, func(rows []Row) error {
+		return onBatch(ctx, rows)
+	})
+	readErr := readBatches(ctx, bufio.NewReaderSize(r, opts.BufferSize), opts, pool, func(batch *lineBatch) error {
+		if ctx.Err() != nil {
+			batch.buf.release()
+			return context.Canceled
 		}
+		c.process(parseBatch(opts, 0, batch))
+		return c.err
+	})
+	if err := c.result(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if readErr != nil && readErr != context.Canceled {
+		return readErr
 	}
+	return nil
+}
+
+func consumeResults(ctx context.Context, opts Options, results <-chan parseResult, cancel context.CancelFunc, onBatch func([]Row) error) error {
+	c := newResultConsumer(ctx, opts, cancel, onBatch)
+	expectedSeq := int64(0)
+	pending := make(map[int64]parseResult)
 
 	if opts.PreserveOrder {
 		for res := range results {
-			if err != nil {
+			if c.err != nil {
 				res.buf.release()
 				continue
 			}
@@ -430,37 +1417,60 @@ func consumeResults(ctx context.Context, opts Options, results <-chan parseResul
 					break
 				}
 				delete(pending, expectedSeq)
-				processResult(next)
+				c.process(next)
 				expectedSeq++
-				if err != nil {
+				if c.err != nil {
 					break
 				}
 			}
+			opts.Stats.notePending(len(pending))
 		}
 
-		if err != nil {
-			for _, res := range pending {
-				res.buf.release()
-			}
-		} else if len(pending) > 0 {
-			for _, res := range pending {
-				processResult(res)
+		// Leftovers mean batch expectedSeq never arrived: delivering them
+		// would skip its rows, so they are dropped and the gap reported.
+		for _, res := range pending {
+			res.buf.release()
+		}
+		if len(pending) > 0 && c.err == nil {
+			if c.err = ctx.Err(); c.err == nil {
+				c.err = fmt.Errorf("%w: batch %d missing, %d later batches dropped", errBatchGap, expectedSeq, len(pending))
 			}
 		}
 	} else {
+		// The header is the first line, so with HasHeader later batches wait
+		// until batch 0 has been delivered.
+		headerDone := !opts.HasHeader
 		for res := range results {
-			if err != nil {
+			if c.err != nil {
 				res.buf.release()
 				continue
 			}
-			processResult(res)
+			if !headerDone && res.seq != 0 {
+				pending[res.seq] = res
+				opts.Stats.notePending(len(pending))
+				continue
+			}
+			c.process(res)
+			if !headerDone {
+				headerDone = true
+				for seq, held := range pending {
+					delete(pending, seq)
+					if c.err != nil {
+						held.buf.release()
+						continue
+					}
+					c.process(held)
+				}
+			}
+		}
+		for _, res := range pending {
+			res.buf.release()
 		}
 	}
-
-	return err
+	return c.result()
 }
 
-func splitFields(line []byte, expected int) [][]byte {
+func splitFields(line []byte, expected int, delim byte) [][]byte {
 	// expected guides capacity to reduce slice growth.
 	capacity := expected
 	if capacity == 0 {
@@ -470,7 +1480,7 @@ func splitFields(line []byte, expected int) [][]byte {
 
 	start := 0
 	for i, b := range line {
-		if b == '\t' {
+		if b == delim {
 			fields = append(fields, line[start:i])
 			start = i + 1
 		}
@@ -478,3 +1488,186 @@ func splitFields(line []byte, expected int) [][]byte {
 	fields = append(fields, line[start:])
 	return fields
 }
+
+// splitQuotedFields splits a record produced with QuotedFields. Quoted
+// fields lose their surrounding quotes; fields containing "" are copied so
+// the escape can be removed without touching the shared buffer. It returns
+// false when a quoted field is not closed.
+func splitQuotedFields(line []byte, expected int, delim byte) ([][]byte, bool) {
+	capacity := expected
+	if capacity == 0 {
+		capacity = 8
+	}
+	fields := make([][]byte, 0, capacity)
+
+	i := 0
+	for {
+		if i < len(line) && line[i] == '"' {
+			j := i + 1
+			escaped := false
+			for {
+				k := bytes.IndexByte(line[j:], '"')
+				if k < 0 {
+					return nil, false
+				}
+				j += k
+				if j+1 < len(line) && line[j+1] == '"' {
+					escaped = true
+					j += 2
+					continue
+				}
+				break
+			}
+			// j is the closing quote. Anything before the next delimiter is
+			// malformed; keep such a field verbatim rather than guessing.
+			end := j + 1
+			if end < len(line) && line[end] != delim {
+				k := bytes.IndexByte(line[end:], delim)
+				if k < 0 {
+					k = len(line) - end
+				}
+				fields = append(fields, line[i:end+k])
+				i = end + k
+			} else {
+				field := line[i+1 : j]
+				if escaped {
+					field = bytes.ReplaceAll(field, []byte(`""`), []byte(`"`))
+				}
+				fields = append(fields, field)
+				i = end
+			}
+		} else {
+			k := bytes.IndexByte(line[i:], delim)
+			if k < 0 {
+				k = len(line) - i
+			}
+			fields = append(fields, line[i:i+k])
+			i += k
+		}
+		if i >= len(line) {
+			return fields, true
+		}
+		i++ // skip the delimiter
+	}
+}
+
+// projection selects and reorders columns for Options.ProjectColumns.
+type projection struct {
+	cols  []int // requested columns, in output order
+	order []int // positions in cols, sorted by column
+}
+
+func newProjection(cols []int) (*projection, error) {
+	if len(cols) == 0 {
+		return nil, nil
+	}
+	for _, c := range cols {
+		if c < 0 {
+			return nil, fmt.Errorf("project columns: negative column index %d", c)
+		}
+	}
+	p := &projection{
+		cols:  append([]int(nil), cols...),
+		order: make([]int, len(cols)),
+	}
+	for i := range p.order {
+		p.order[i] = i
+	}
+	sort.SliceStable(p.order, func(a, b int) bool {
+		return p.cols[p.order[a]] < p.cols[p.order[b]]
+	})
+	return p, nil
+}
+
+// checkRowErrorOptions rejects conflicting malformed-line handling.
+func checkRowErrorOptions(opts Options) error {
+	if opts.MaxErrors < 0 {
+		return fmt.Errorf("negative MaxErrors %d", opts.MaxErrors)
+	}
+	if opts.MaxErrors > 0 && opts.OnRowError != nil {
+		return fmt.Errorf("set OnRowError or MaxErrors, not both")
+	}
+	return nil
+}
+
+// checkProjection rejects projection options that can never resolve.
+func checkProjection(opts Options) error {
+	if len(opts.ProjectNames) == 0 {
+		_, err := newProjection(opts.ProjectColumns)
+		return err
+	}
+	if len(opts.ProjectColumns) > 0 {
+		return fmt.Errorf("project columns: set ProjectColumns or ProjectNames, not both")
+	}
+	if !opts.HasHeader {
+		return fmt.Errorf("project columns: ProjectNames requires HasHeader")
+	}
+	return nil
+}
+
+// resolveProjection builds the projection for opts, looking names up in
+// header. It returns nil when no projection is requested.
+func resolveProjection(opts Options, header [][]byte) (*projection, error) {
+	if len(opts.ProjectNames) == 0 {
+		return newProjection(opts.ProjectColumns)
+	}
+	cols := make([]int, len(opts.ProjectNames))
+	for i, name := range opts.ProjectNames {
+		cols[i] = indexOfBytes(header, name)
+		if cols[i] < 0 {
+			return nil, fmt.Errorf("project columns: no column %q in header", name)
+		}
+	}
+	return newProjection(cols)
+}
+
+// splitHeaderLine splits line 1 the way the workers will.
+func splitHeaderLine(opts Options, line []byte) [][]byte {
+	if opts.QuotedFields {
+		fields, _ := splitQuotedFields(line, 0, opts.Delimiter)
+		return fields
+	}
+	return splitFields(line, 0, opts.Delimiter)
+}
+
+// split extracts the projected columns from a line separated by delim. It
+// stops scanning once the last requested column has been found.
+func (p *projection) split(line []byte, delim byte) [][]byte {
+	fields := make([][]byte, len(p.cols))
+	col, start, k := 0, 0, 0
+	for k < len(p.order) {
+		end := bytes.IndexByte(line[start:], delim)
+		last := end < 0
+		if last {
+			end = len(line)
+		} else {
+			end += start
+		}
+		for k < len(p.order) && p.cols[p.order[k]] == col {
+			fields[p.order[k]] = line[start:end]
+			k++
+		}
+		if last {
+			break
+		}
+		col++
+		start = end + 1
+	}
+	for ; k < len(p.order); k++ {
+		fields[p.order[k]] = line[len(line):]
+	}
+	return fields
+}
+
+// pick projects fields that were already split in full.
+func (p *projection) pick(fields [][]byte) [][]byte {
+	out := make([][]byte, len(p.cols))
+	for i, c := range p.cols {
+		if c < len(fields) {
+			out[i] = fields[c]
+		} else {
+			out[i] = []byte{}
+		}
+	}
+	return out
+}
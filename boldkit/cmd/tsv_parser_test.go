@@ -0,0 +1,1402 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// Most parser tests use chunks far below minChunkSize to cross chunk
+// boundaries on small inputs; TestOptionsValidate restores the floor.
+func TestMain(m *testing.M) {
+	minChunkSize = 1
+	os.Exit(m.Run())
+}
+
+// endlessTSV yields the same line forever, standing in for a multi-GB input.
+type endlessTSV struct {
+	line []byte
+	off  int
+}
+
+func (r *endlessTSV) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.line[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.line)
+	}
+	return n, nil
+}
+
+func waitForGoroutines(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > want {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked: have %d, want <= %d", runtime.NumGoroutine(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseTSVContextCancelMidParse(t *testing.T) {
+	for _, preserve := range []bool{true, false} {
+		before := runtime.NumGoroutine()
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := DefaultOptions().WithPreserveOrder(preserve)
+		opts.Workers = 4
+		opts.BufferSize, opts.ChunkSize = 1<<16, 1<<16
+
+		var rows atomic.Int64
+		start := time.Now()
+		done := make(chan error, 1)
+		go func() {
+			done <- ParseTSVContext(ctx, &endlessTSV{line: []byte("P1\tCOI-5P\tACGTACGT\n")}, opts, func(Row) error {
+				if rows.Add(1) == 50_000 {
+					cancel()
+				}
+				return nil
+			})
+		}()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("preserve=%v: err=%v want context.Canceled", preserve, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("preserve=%v: parse did not stop after cancel", preserve)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("preserve=%v: cancellation took %s", preserve, elapsed)
+		}
+		waitForGoroutines(t, before)
+	}
+}
+
+func TestParseTSVContextPreCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	err := ParseTSVContext(ctx, strings.NewReader("a\tb\n"), DefaultOptions(), func(Row) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || called {
+		t.Fatalf("err=%v called=%v", err, called)
+	}
+}
+
+func TestParseTSVContextParentDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := ParseTSVContext(ctx, &endlessTSV{line: []byte("a\tb\n")}, DefaultOptions(), func(Row) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err=%v want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRowsIterator(t *testing.T) {
+	var got []string
+	var kept []Row
+	for row, err := range Rows(strings.NewReader("a\tb\nc\td\ne\tf\n"), DefaultOptions()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", row.Line, row.Fields[1]))
+		kept = append(kept, row)
+	}
+	if want := []string{"1:b", "2:d", "3:f"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows %v want %v", got, want)
+	}
+	// Yielded rows are copies and outlive the loop.
+	if string(kept[0].Fields[0]) != "a" || string(kept[2].Fields[0]) != "e" {
+		t.Fatalf("kept rows %q %q", kept[0].Fields, kept[2].Fields)
+	}
+}
+
+func TestRowsIteratorError(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictColumns = true
+	var rows int
+	var last error
+	for _, err := range Rows(strings.NewReader("a\tb\nc\n"), opts) {
+		if err != nil {
+			last = err
+			continue
+		}
+		rows++
+	}
+	if last == nil || !strings.Contains(last.Error(), "line 2") {
+		t.Fatalf("err=%v", last)
+	}
+	if rows > 1 {
+		t.Fatalf("rows=%d after error", rows)
+	}
+}
+
+func TestRowsIteratorBreakNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	opts := DefaultOptions()
+	opts.Workers = 4
+	opts.BufferSize, opts.ChunkSize = 1<<16, 1<<16
+	n := 0
+	for row, err := range Rows(&endlessTSV{line: []byte("P1\tCOI-5P\tACGTACGT\n")}, opts) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(row.Fields[0]) != "P1" {
+			t.Fatalf("row %d: %q", n, row.Fields)
+		}
+		if n++; n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Fatalf("rows=%d", n)
+	}
+	// The iterator returns only after the parser has shut down, so nothing
+	// may linger beyond the usual scheduling slack.
+	waitForGoroutines(t, before)
+}
+
+type parsedRow struct {
+	line   int64
+	fields []string
+}
+
+func parseQuoted(t *testing.T, r io.Reader, opts Options) ([]parsedRow, []RowError) {
+	t.Helper()
+	var rows []parsedRow
+	var rejects []RowError
+	opts.QuotedFields = true
+	opts.OnRowError = func(e RowError) error {
+		rejects = append(rejects, e)
+		return nil
+	}
+	err := ParseTSV(r, opts, func(row Row) error {
+		fields := make([]string, len(row.Fields))
+		for i, f := range row.Fields {
+			fields[i] = string(f)
+		}
+		rows = append(rows, parsedRow{line: row.Line, fields: fields})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ParseTSV: %v", err)
+	}
+	return rows, rejects
+}
+
+func TestParseTSVQuotedFields(t *testing.T) {
+	input := "processid\tnotes\tnuc\r\n" +
+		"P1\t\"tab\there\"\tACGT\r\n" +
+		"P2\t\"line one\nline two\"\tACGT\n" +
+		"P3\t\"say \"\"hi\"\"\"\tACGT\n" +
+		"P4\t5\" long\tACGT\n" +
+		"P5\t\"\"\tACGT"
+	want := []parsedRow{
+		{1, []string{"processid", "notes", "nuc"}},
+		{2, []string{"P1", "tab\there", "ACGT"}},
+		{3, []string{"P2", "line one\nline two", "ACGT"}},
+		{5, []string{"P3", `say "hi"`, "ACGT"}},
+		{6, []string{"P4", `5" long`, "ACGT"}},
+		{7, []string{"P5", "", "ACGT"}},
+	}
+	cases := []struct {
+		name string
+		r    func() io.Reader
+		opts Options
+	}{
+		{name: "default chunks", r: func() io.Reader { return strings.NewReader(input) }, opts: DefaultOptions()},
+		// One byte per read and a tiny chunk: every quoted field spans
+		// several chunk reads.
+		{name: "one byte reads", r: func() io.Reader { return iotest.OneByteReader(strings.NewReader(input)) }, opts: Options{ChunkSize: 7, BatchLines: 1, Workers: 3, PreserveOrder: true, AllowCRLF: true, StrictColumns: true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, rejects := parseQuoted(t, tc.r(), tc.opts)
+			if len(rejects) > 0 {
+				t.Fatalf("unexpected rejects: %v", rejects)
+			}
+			if !reflect.DeepEqual(rows, want) {
+				t.Fatalf("rows:\n got %q\nwant %q", rows, want)
+			}
+		})
+	}
+}
+
+func TestParseTSVQuotedFieldSpansChunks(t *testing.T) {
+	note := strings.Repeat("x\ty\n", 64)
+	input := "id\tnote\n1\t\"" + note + "\"\n2\tplain\n"
+	opts := Options{ChunkSize: 32, Workers: 2, PreserveOrder: true, StrictColumns: true}
+	rows, rejects := parseQuoted(t, strings.NewReader(input), opts)
+	if len(rejects) > 0 {
+		t.Fatalf("unexpected rejects: %v", rejects)
+	}
+	if len(rows) != 3 || rows[1].fields[1] != note || rows[2].fields[0] != "2" || rows[2].line != 67 {
+		t.Fatalf("rows=%q", rows)
+	}
+}
+
+func TestParseTSVUnterminatedQuote(t *testing.T) {
+	input := "id\tnote\n1\t\"never closed\n2\tplain\n"
+	rows, rejects := parseQuoted(t, strings.NewReader(input), DefaultOptions())
+	if len(rows) != 1 || len(rejects) != 1 || rejects[0].Reason != "unterminated quoted field" || rejects[0].Line != 2 {
+		t.Fatalf("rows=%q rejects=%v", rows, rejects)
+	}
+}
+
+func TestParseTSVQuotesIgnoredByDefault(t *testing.T) {
+	var got [][]string
+	err := ParseTSV(strings.NewReader("a\t\"b\tc\"\n"), DefaultOptions(), func(row Row) error {
+		fields := make([]string, len(row.Fields))
+		for i, f := range row.Fields {
+			fields[i] = string(f)
+		}
+		got = append(got, fields)
+		return nil
+	})
+	if err != nil || len(got) != 1 || len(got[0]) != 3 {
+		t.Fatalf("got %q err=%v", got, err)
+	}
+}
+
+func TestParseTSVHeader(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("processid\tnuc\tprocessid\tmarker_code\n")
+	for i := 1; i <= 500; i++ {
+		fmt.Fprintf(&b, "P%d\tACGT\tdup\tCOI-5P\n", i)
+	}
+	input := b.String()
+
+	for _, preserve := range []bool{true, false} {
+		t.Run(fmt.Sprintf("preserve=%v", preserve), func(t *testing.T) {
+			opts := Options{ChunkSize: 64, BatchLines: 1, Workers: 8, PreserveOrder: preserve, StrictColumns: true, HasHeader: true}
+			var header *Header
+			opts.OnHeader = func(h *Header) error {
+				header = h
+				return nil
+			}
+			var rows atomic.Int64
+			err := ParseTSV(strings.NewReader(input), opts, func(row Row) error {
+				if header == nil || row.Header() != header {
+					return fmt.Errorf("line %d delivered before header", row.Line)
+				}
+				if row.Line == 1 {
+					return errors.New("header delivered as a row")
+				}
+				if got, want := string(row.Field("processid")), fmt.Sprintf("P%d", row.Line-1); got != want {
+					return fmt.Errorf("line %d processid=%q want %q", row.Line, got, want)
+				}
+				if row.Field("missing") != nil {
+					return errors.New("missing column returned data")
+				}
+				rows.Add(1)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows.Load() != 500 {
+				t.Fatalf("rows=%d", rows.Load())
+			}
+			if header.Index("processid") != 0 || header.Index("marker_code") != 3 || header.Index("missing") != -1 || header.Len() != 4 {
+				t.Fatalf("header=%v", header.Names())
+			}
+		})
+	}
+}
+
+func TestParseTSVHeaderRejected(t *testing.T) {
+	opts := DefaultOptions()
+	opts.HasHeader = true
+	opts.MaxLineBytes = 8
+	opts.OnRowError = func(RowError) error { return nil }
+	err := ParseTSV(strings.NewReader("processid\tnuc\nP1\tA\n"), opts, func(Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2: header row was rejected") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestParseTSVProjectColumns(t *testing.T) {
+	input := "a\tb\tc\td\n1\t2\t3\t4\n5\t6\n7\t8\t9\t10\textra\n"
+	cases := []struct {
+		name   string
+		opts   Options
+		want   [][]string
+		header bool
+	}{
+		{
+			name: "by index",
+			opts: Options{Workers: 2, PreserveOrder: true, ProjectColumns: []int{2, 0, 7}},
+			want: [][]string{{"c", "a", ""}, {"3", "1", ""}, {"", "5", ""}, {"9", "7", ""}},
+		},
+		{
+			name:   "by name",
+			opts:   Options{Workers: 2, PreserveOrder: true, HasHeader: true, ProjectNames: []string{"d", "b", "d"}},
+			want:   [][]string{{"4", "2", "4"}, {"", "6", ""}, {"10", "8", "10"}},
+			header: true,
+		},
+		{
+			name:   "quoted by name",
+			opts:   Options{Workers: 2, PreserveOrder: true, HasHeader: true, QuotedFields: true, ProjectNames: []string{"c"}},
+			want:   [][]string{{"3"}, {""}, {"9"}},
+			header: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got [][]string
+			err := ParseTSV(strings.NewReader(input), tc.opts, func(row Row) error {
+				fields := make([]string, len(row.Fields))
+				for i, f := range row.Fields {
+					if f == nil {
+						return fmt.Errorf("line %d field %d is nil", row.Line, i)
+					}
+					fields[i] = string(f)
+				}
+				got = append(got, fields)
+				if name := tc.opts.ProjectNames; tc.header && string(row.Field(name[0])) != fields[0] {
+					return fmt.Errorf("line %d Field(%s)=%q", row.Line, name[0], row.Field(name[0]))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTSVProjectStrictColumns(t *testing.T) {
+	opts := Options{Workers: 1, PreserveOrder: true, StrictColumns: true, HasHeader: true, ProjectNames: []string{"a"}}
+	var rejects []RowError
+	opts.OnRowError = func(e RowError) error {
+		rejects = append(rejects, e)
+		return nil
+	}
+	err := ParseTSV(strings.NewReader("a\tb\tc\n1\t2\t3\n4\t5\n"), opts, func(Row) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejects) != 1 || rejects[0].Line != 3 || rejects[0].Reason != "expected 3 columns, got 2" {
+		t.Fatalf("rejects=%v", rejects)
+	}
+}
+
+func TestParseTSVProjectErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{name: "names without header", opts: Options{ProjectNames: []string{"a"}}, want: "requires HasHeader"},
+		{name: "both", opts: Options{HasHeader: true, ProjectNames: []string{"a"}, ProjectColumns: []int{0}}, want: "not both"},
+		{name: "negative", opts: Options{ProjectColumns: []int{-1}}, want: "negative column index"},
+		{name: "unknown name", opts: Options{HasHeader: true, ProjectNames: []string{"zz"}}, want: `no column "zz" in header`},
+	}
+	for _, tc := range cases {
+		err := ParseTSV(strings.NewReader("a\tb\n1\t2\n"), tc.opts, func(Row) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: err=%v", tc.name, err)
+		}
+	}
+}
+
+// BenchmarkParseTSVProjection compares full splitting of an 80-column input
+// with projecting the three columns markers reads.
+func BenchmarkParseTSVProjection(b *testing.B) {
+	data, err := io.ReadAll(newSyntheticTSV(syntheticSpec{Rows: 20_000, Cols: 80}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name    string
+		cols    []int
+		batches bool
+	}{
+		{name: "full"},
+		{name: "project3", cols: []int{0, 3, 7}},
+		{name: "project3-batches", cols: []int{0, 3, 7}, batches: true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.ProjectColumns = bc.cols
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var err error
+				if bc.batches {
+					err = ParseTSVBatches(bytes.NewReader(data), opts, func([]Row) error { return nil })
+				} else {
+					err = ParseTSV(bytes.NewReader(data), opts, func(Row) error { return nil })
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// boldShapedTSV returns rows of the synthetic 80-column dump, header
+// included.
+func boldShapedTSV(tb testing.TB, rows int64) []byte {
+	tb.Helper()
+	data, err := io.ReadAll(newSyntheticTSV(syntheticSpec{Rows: rows, Cols: 80}))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkSplitFields(b *testing.B) {
+	data := boldShapedTSV(b, 2_000)
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}), []byte{'\n'})
+	for _, expected := range []int{0, 80} {
+		b.Run(fmt.Sprintf("expected=%d", expected), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, line := range lines {
+					_ = splitFields(line, expected, '\t')
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReadBatches measures the reader alone: chunking and line
+// splitting, with batches released as soon as they are emitted.
+func BenchmarkReadBatches(b *testing.B) {
+	data := boldShapedTSV(b, 20_000)
+	for _, chunk := range []int{64 << 10, 1 << 20, 8 << 20} {
+		for _, batchLines := range []int{256, 1024, 4096} {
+			b.Run(fmt.Sprintf("chunk=%dK/batch=%d", chunk>>10, batchLines), func(b *testing.B) {
+				opts := Options{ChunkSize: chunk, BatchLines: batchLines}.withDefaults()
+				pool := &sync.Pool{New: func() any { return &pooledBuf{buf: make([]byte, opts.ChunkSize)} }}
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					release := func(batch *lineBatch) error {
+						batch.buf.release()
+						return nil
+					}
+					err := readBatches(context.Background(), bufio.NewReaderSize(bytes.NewReader(data), opts.BufferSize), opts, pool, release)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// fuzzOptions derives parser options from the fuzzer's knobs. Chunks stay
+// tiny so short inputs still cross chunk and batch boundaries.
+func fuzzOptions(knobs uint32) Options {
+	chunk := int(knobs&63) + 1
+	return Options{
+		ChunkSize:      chunk,
+		BatchLines:     int(knobs>>6&7)%chunk + 1,
+		Workers:        int(knobs>>9&3) + 1,
+		PreserveOrder:  knobs&(1<<11) != 0,
+		AllowCRLF:      knobs&(1<<12) != 0,
+		StrictColumns:  knobs&(1<<13) != 0,
+		RejectNUL:      knobs&(1<<14) != 0,
+		SkipEmptyLines: knobs&(1<<15) != 0,
+		MaxLineBytes:   int(knobs >> 16 & 127),
+		Delimiter:      "\t,|;"[knobs>>23&3],
+	}
+}
+
+// FuzzParseTSV feeds arbitrary bytes through ParseTSV with random options.
+// Malformed lines go to OnRowError, so every line of the input comes back
+// exactly once, as a row or a rejection, and its fields and separators
+// rebuild the line's bytes at its offset.
+func FuzzParseTSV(f *testing.F) {
+	rows := int64(50)
+	if testing.Short() {
+		rows = 5
+	}
+	bold := boldShapedTSV(f, rows)
+	for i, seed := range [][]byte{
+		nil,
+		[]byte("\n"),
+		[]byte("a\tb\nc\td"),
+		[]byte("a\tb\r\n\r\n\nc\x00d\te\r"),
+		[]byte("\xef\xbb\xbfid\tx\n1\t2\n"),
+		[]byte("x,y|z;w\n,,\n|;\n"),
+		bold,
+	} {
+		for _, knobs := range []uint32{0, 0xffffffff, 0x9e3779b9, uint32(i) * 0x01000193} {
+			f.Add(seed, knobs)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte, knobs uint32) {
+		opts := fuzzOptions(knobs)
+		// Each chunk recopies the pending partial line, so a long line over
+		// tiny chunks is quadratic; the floor bounds a mutated input's cost.
+		opts.ChunkSize = max(opts.ChunkSize, len(data)/64)
+		type emitted struct {
+			offset int64
+			text   string
+		}
+		got := make(map[int64]emitted)
+		var order []int64
+		record := func(line, offset int64, text string) error {
+			if _, dup := got[line]; dup {
+				return fmt.Errorf("line %d emitted twice", line)
+			}
+			got[line] = emitted{offset, text}
+			order = append(order, line)
+			return nil
+		}
+		opts.OnRowError = func(e RowError) error {
+			return record(e.Line, e.Offset, string(e.Raw))
+		}
+		err := ParseTSV(bytes.NewReader(data), opts, func(row Row) error {
+			return record(row.Line, row.Offset, string(bytes.Join(row.Fields, []byte{opts.Delimiter})))
+		})
+		if bytes.HasPrefix(data, []byte{0xFF, 0xFE}) || bytes.HasPrefix(data, []byte{0xFE, 0xFF}) {
+			if !errors.Is(err, ErrUTF16Input) {
+				t.Fatalf("UTF-16 input: err=%v", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("opts %+v: %v", opts, err)
+		}
+
+		body, offset := data, int64(0)
+		if bytes.HasPrefix(body, utf8BOM) {
+			body, offset = body[len(utf8BOM):], int64(len(utf8BOM))
+		}
+		var line int64
+		for len(body) > 0 {
+			text, rest, _ := bytes.Cut(body, []byte{'\n'})
+			line++
+			if opts.AllowCRLF {
+				text = bytes.TrimSuffix(text, []byte{'\r'})
+			}
+			e, ok := got[line]
+			switch {
+			case !ok && !(opts.SkipEmptyLines && len(text) == 0):
+				t.Fatalf("opts %+v: line %d (%q) never emitted", opts, line, text)
+			case ok && (e.offset != offset || e.text != string(text)):
+				t.Fatalf("opts %+v: line %d at %d is %q, emitted %q at %d", opts, line, offset, text, e.text, e.offset)
+			}
+			delete(got, line)
+			offset += int64(len(body) - len(rest))
+			body = rest
+		}
+		if len(got) > 0 {
+			t.Fatalf("opts %+v: %d lines past the end of the input", opts, len(got))
+		}
+		if opts.PreserveOrder {
+			for i := 1; i < len(order); i++ {
+				if order[i] <= order[i-1] {
+					t.Fatalf("opts %+v: line %d delivered after line %d", opts, order[i], order[i-1])
+				}
+			}
+		}
+	})
+}
+
+func TestParseTSVCRLFAcrossChunks(t *testing.T) {
+	// Every chunk size from 1 up puts some chunk boundary between a '\r'
+	// and its '\n'; the last line ends in a bare '\r'.
+	input := "id\tnote\r\nP1\tab\r\nP2\tcd\r\nP3\tef\r"
+	want := []string{"note", "ab", "cd", "ef"}
+	for _, quoted := range []bool{false, true} {
+		for chunk := 1; chunk <= len(input)+1; chunk++ {
+			opts := Options{ChunkSize: chunk, Workers: 2, PreserveOrder: true, AllowCRLF: true, StrictColumns: true, QuotedFields: quoted}
+			var got []string
+			err := ParseTSV(iotest.OneByteReader(strings.NewReader(input)), opts, func(row Row) error {
+				got = append(got, string(row.Fields[1]))
+				return nil
+			})
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Fatalf("quoted=%v chunk=%d: got %q err=%v", quoted, chunk, got, err)
+			}
+		}
+	}
+}
+
+func TestParseTSVMaxErrors(t *testing.T) {
+	// 200 rows, every 20th short by one column, spread across many batches
+	// delivered out of order.
+	var b strings.Builder
+	var wantLines []int64
+	for i := 1; i <= 200; i++ {
+		if i%20 == 0 {
+			fmt.Fprintf(&b, "P%d\n", i)
+			wantLines = append(wantLines, int64(i))
+			continue
+		}
+		fmt.Fprintf(&b, "P%d\tx\n", i)
+	}
+	base := Options{Workers: 4, BatchLines: 3, ChunkSize: 64, StrictColumns: true, ExpectedColumns: 2}
+
+	opts := base
+	opts.MaxErrors = 10
+	var hooked atomic.Int64
+	opts.OnError = func(line int64, err error) {
+		if !strings.Contains(err.Error(), "expected 2 columns") {
+			t.Errorf("line %d: %v", line, err)
+		}
+		hooked.Add(1)
+	}
+	var rows atomic.Int64
+	err := ParseTSV(strings.NewReader(b.String()), opts, func(Row) error {
+		rows.Add(1)
+		return nil
+	})
+	var rowErrs *RowErrors
+	if !errors.As(err, &rowErrs) || rowErrs.Exceeded {
+		t.Fatalf("err=%v", err)
+	}
+	var gotLines []int64
+	for _, e := range rowErrs.Errors {
+		gotLines = append(gotLines, e.Line)
+		if e.Raw != nil {
+			t.Fatalf("line %d kept raw bytes", e.Line)
+		}
+	}
+	if !reflect.DeepEqual(gotLines, wantLines) || hooked.Load() != 10 || rows.Load() != 190 {
+		t.Fatalf("lines=%v hooked=%d rows=%d", gotLines, hooked.Load(), rows.Load())
+	}
+
+	opts = base
+	opts.MaxErrors = 3
+	err = ParseTSV(strings.NewReader(b.String()), opts, func(Row) error { return nil })
+	if !errors.As(err, &rowErrs) || !rowErrs.Exceeded || len(rowErrs.Errors) != 4 {
+		t.Fatalf("limit: err=%v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "more than 3 malformed lines: line ") {
+		t.Fatalf("message %q", err)
+	}
+
+	opts = base
+	opts.MaxErrors = 3
+	opts.OnRowError = func(RowError) error { return nil }
+	if err := ParseTSV(strings.NewReader(b.String()), opts, func(Row) error { return nil }); err == nil || !strings.Contains(err.Error(), "not both") {
+		t.Fatalf("conflict: err=%v", err)
+	}
+}
+
+// parseTranscript runs ParseTSV and records everything it reports: rows,
+// row errors, the header, the final error, and progress and stats.
+func parseTranscript(t *testing.T, input string, opts Options, stopAfter int) []string {
+	t.Helper()
+	var out []string
+	progress := newProgress(1<<20, 1)
+	stats := &ParseStats{}
+	opts.Progress = progress
+	opts.Stats = stats
+	if opts.OnRowError != nil {
+		opts.OnRowError = func(e RowError) error {
+			out = append(out, fmt.Sprintf("reject %d@%d %s %q", e.Line, e.Offset, e.Reason, e.Raw))
+			return nil
+		}
+	}
+	if opts.MaxErrors > 0 {
+		opts.OnError = func(line int64, err error) {
+			out = append(out, fmt.Sprintf("skip %d %v", line, err))
+		}
+	}
+	if opts.HasHeader {
+		opts.OnHeader = func(h *Header) error {
+			out = append(out, fmt.Sprintf("header %q", h.fields))
+			return nil
+		}
+	}
+	delivered := 0
+	err := ParseTSV(strings.NewReader(input), opts, func(row Row) error {
+		out = append(out, fmt.Sprintf("row %d@%d %q", row.Line, row.Offset, row.Fields))
+		if delivered++; delivered == stopAfter {
+			return errors.New("stop")
+		}
+		return nil
+	})
+	out = append(out, fmt.Sprintf("err %v", err))
+	// An aborted concurrent parse has read ahead, so input-side counts only
+	// match on a clean run.
+	if err == nil {
+		out = append(out,
+			fmt.Sprintf("progress %d", progress.bar.State().CurrentNum),
+			fmt.Sprintf("stats rows=%d bytes=%d", stats.Rows, stats.Bytes))
+	}
+	_ = progress.bar.Finish()
+	return out
+}
+
+// The single-worker path runs without goroutines; it must report exactly
+// what the concurrent path does.
+func TestParseTSVSyncMatchesConcurrent(t *testing.T) {
+	bold, err := io.ReadAll(newSyntheticTSV(syntheticSpec{Rows: 200, Cols: 12}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := map[string]string{
+		"mixed":     "id\tname\r\nP1\ta\r\nP2\tb\tc\r\n\r\n# note\nP3\t\"q\tx\"\nP4\x00\td\nP5\te",
+		"bom":       "\xef\xbb\xbfid\tname\nP1\ta\nP2\tb\n",
+		"quoted":    "id\tnote\n\"P1\"\t\"two\nlines\"\nP2\t\"open\n",
+		"synthetic": string(bold),
+		"empty":     "",
+	}
+	variants := map[string]Options{
+		"strict":  {StrictColumns: true, AllowCRLF: true},
+		"header":  {HasHeader: true, StrictColumns: true, ProjectNames: []string{"name", "id"}},
+		"skip":    {SkipEmptyLines: true, CommentPrefix: "#", AllowCRLF: true, StrictColumns: true},
+		"quoted":  {QuotedFields: true, HasHeader: true},
+		"max-err": {MaxErrors: 2, StrictColumns: true, RejectNUL: true},
+		"lenient": {OnRowError: func(RowError) error { return nil }, StrictColumns: true, RejectNUL: true, MaxLineBytes: 20},
+		"sample":  {HasHeader: true, SampleEveryN: 2, SampleLimit: 3},
+		"comma":   {Delimiter: ','},
+	}
+	for inputName, input := range inputs {
+		for variantName, variant := range variants {
+			for _, size := range []struct{ chunk, batch int }{{7, 1}, {64, 4}, {1 << 20, 0}} {
+				for _, stopAfter := range []int{0, 3} {
+					opts := variant
+					opts.ChunkSize, opts.BatchLines, opts.PreserveOrder = size.chunk, size.batch, true
+					opts.Workers = 1
+					sync := parseTranscript(t, input, opts, stopAfter)
+					opts.Workers = 3
+					concurrent := parseTranscript(t, input, opts, stopAfter)
+					if !reflect.DeepEqual(sync, concurrent) {
+						t.Fatalf("%s/%s chunk=%d stop=%d:\n sync %q\nconc %q", inputName, variantName, size.chunk, stopAfter, sync, concurrent)
+					}
+				}
+			}
+		}
+	}
+
+	// Rows reach the callback on the calling goroutine.
+	before := runtime.NumGoroutine()
+	err = ParseTSV(strings.NewReader(string(bold)), Options{Workers: 1, PreserveOrder: true, ChunkSize: 256, Timeout: time.Minute}, func(Row) error {
+		if n := runtime.NumGoroutine(); n != before {
+			return fmt.Errorf("%d goroutines during the parse, %d before", n, before)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A read error mid-file ends the parse with that error after a strict,
+// ordered prefix of the rows.
+func TestParseTSVReaderErrorDeliversPrefix(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 2000; i++ {
+		fmt.Fprintf(&b, "%d\tP%d\n", i, i)
+	}
+	input := b.String()
+	readErr := errors.New("disk gone")
+	for _, cut := range []int{0, 1, 100, len(input) / 2, len(input) - 1} {
+		for _, workers := range []int{1, 2, 8} {
+			for _, chunk := range []int{16, 256, 4 << 10} {
+				r := io.MultiReader(strings.NewReader(input[:cut]), iotest.ErrReader(readErr))
+				opts := Options{ChunkSize: chunk, BatchLines: 4, Workers: workers, PreserveOrder: true}
+				var last int64
+				err := ParseTSV(r, opts, func(row Row) error {
+					if row.Line != last+1 || string(row.Fields[0]) != strconv.FormatInt(row.Line, 10) {
+						return fmt.Errorf("line %d (%q) after line %d", row.Line, row.Fields[0], last)
+					}
+					last = row.Line
+					return nil
+				})
+				if !errors.Is(err, readErr) {
+					t.Fatalf("cut=%d workers=%d chunk=%d: err=%v after %d rows", cut, workers, chunk, err, last)
+				}
+			}
+		}
+	}
+}
+
+// A batch that never arrives stops ordered delivery at the gap instead of
+// handing over the later batches.
+func TestConsumeResultsReportsGap(t *testing.T) {
+	results := make(chan parseResult, 3)
+	for _, seq := range []int64{0, 2, 3} {
+		results <- parseResult{seq: seq, rows: []Row{{Line: seq + 1, Fields: [][]byte{[]byte("x")}}}}
+	}
+	close(results)
+	var lines []int64
+	err := consumeResults(context.Background(), Options{PreserveOrder: true}, results, func() {}, func(rows []Row) error {
+		for _, row := range rows {
+			lines = append(lines, row.Line)
+		}
+		return nil
+	})
+	if !errors.Is(err, errBatchGap) || !reflect.DeepEqual(lines, []int64{1}) {
+		t.Fatalf("lines %v err=%v", lines, err)
+	}
+}
+
+func TestParseTSVSkipEmptyAndComments(t *testing.T) {
+	input := "# exported 2024-01-01\nid\tname\n\nP1\ta\r\n# note\r\n\r\nP2\tb\n#tail"
+	for _, preserve := range []bool{true, false} {
+		for chunk := 1; chunk <= len(input)+1; chunk++ {
+			progress := newProgress(7, 1) // countLines minus the header
+			opts := Options{
+				ChunkSize:      chunk,
+				BatchLines:     1,
+				Workers:        3,
+				PreserveOrder:  preserve,
+				AllowCRLF:      true,
+				StrictColumns:  true,
+				HasHeader:      true,
+				SkipEmptyLines: true,
+				CommentPrefix:  "#",
+				Progress:       progress,
+			}
+			var mu sync.Mutex
+			got := map[int64]string{}
+			err := ParseTSV(strings.NewReader(input), opts, func(row Row) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got[row.Line] = string(row.Field("name"))
+				return nil
+			})
+			want := map[int64]string{4: "a", 7: "b"}
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Fatalf("preserve=%v chunk=%d: got %v err=%v", preserve, chunk, got, err)
+			}
+			if n := progress.bar.State().CurrentNum; n != 7 {
+				t.Fatalf("preserve=%v chunk=%d: progress %d, want 7", preserve, chunk, n)
+			}
+		}
+	}
+
+	// Without the options a blank line is a one-field row.
+	err := ParseTSV(strings.NewReader("a\tb\n\nc\td\n"), Options{StrictColumns: true}, func(Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2: expected 2 columns, got 1") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestParseTSVDelimiter(t *testing.T) {
+	input := "id,name,note\nP1,\"Apis, mellifera\",x\nP2,Bombus,\n"
+	for _, bc := range []struct {
+		name string
+		opts Options
+		want [][]string
+	}{
+		{
+			name: "quoted",
+			opts: Options{Delimiter: ',', QuotedFields: true, StrictColumns: true, HasHeader: true},
+			want: [][]string{{"P1", "Apis, mellifera", "x"}, {"P2", "Bombus", ""}},
+		},
+		{
+			name: "projected",
+			opts: Options{Delimiter: ',', QuotedFields: true, HasHeader: true, ProjectNames: []string{"note", "id"}},
+			want: [][]string{{"x", "P1"}, {"", "P2"}},
+		},
+	} {
+		t.Run(bc.name, func(t *testing.T) {
+			bc.opts.Workers = 2
+			bc.opts.PreserveOrder = true
+			var got [][]string
+			err := ParseTSV(strings.NewReader(input), bc.opts, func(row Row) error {
+				fields := make([]string, len(row.Fields))
+				for i, f := range row.Fields {
+					fields[i] = string(f)
+				}
+				got = append(got, fields)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, bc.want) {
+				t.Fatalf("rows %q want %q", got, bc.want)
+			}
+		})
+	}
+
+	// Without QuotedFields the comma inside quotes still splits, so line 2
+	// is four columns wide.
+	err := ParseTSV(strings.NewReader(input), Options{Delimiter: ',', StrictColumns: true}, func(Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2: expected 3 columns, got 4") {
+		t.Fatalf("err=%v", err)
+	}
+
+	var pipes []string
+	err = ParseTSV(strings.NewReader("a|b\tc|d\n"), Options{Delimiter: '|'}, func(row Row) error {
+		for _, f := range row.Fields {
+			pipes = append(pipes, string(f))
+		}
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(pipes, []string{"a", "b\tc", "d"}) {
+		t.Fatalf("pipes %q err=%v", pipes, err)
+	}
+}
+
+func TestParseTSVDelimiterRejected(t *testing.T) {
+	for _, bc := range []struct {
+		opts Options
+		want string
+	}{
+		{opts: Options{Delimiter: '\n'}, want: `Delimiter '\n' is a line terminator`},
+		{opts: Options{Delimiter: '\r'}, want: `Delimiter '\r' is a line terminator`},
+		{opts: Options{Delimiter: '"', QuotedFields: true}, want: "quote character"},
+	} {
+		called := false
+		err := ParseTSV(strings.NewReader("a\tb\n"), bc.opts, func(Row) error {
+			called = true
+			return nil
+		})
+		if err == nil || !strings.Contains(err.Error(), bc.want) || called {
+			t.Fatalf("delimiter %q: err=%v called=%v", bc.opts.Delimiter, err, called)
+		}
+	}
+	if err := (Options{Delimiter: '"'}).Validate(); err != nil {
+		t.Fatalf("quote delimiter without QuotedFields: %v", err)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	defer func(n int) { minChunkSize = n }(minChunkSize)
+	minChunkSize = 64 << 10
+	for _, bc := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{ExpectedColumns: -1, StrictColumns: true}, "ExpectedColumns -1"},
+		{Options{Workers: -2}, "Workers -2"},
+		{Options{Workers: maxWorkers + 1}, "exceeds the limit"},
+		{Options{Timeout: -time.Second}, "Timeout"},
+		{Options{ChunkSize: -1}, "ChunkSize -1"},
+		{Options{ChunkSize: 64, BatchLines: 65}, "BatchLines 65"},
+		{Options{ChunkSize: 4 << 10}, "ChunkSize 4096 is below the minimum of 65536"},
+		{Options{ChunkSize: 64 << 10, BufferSize: 128 << 10}, "ChunkSize 65536 is smaller than BufferSize 131072"},
+		{Options{BufferSize: 16 << 20}, "ChunkSize 8388608 is smaller than BufferSize 16777216"},
+		{Options{StartOffset: 10, EndOffset: 5}, "EndOffset 5 is before StartOffset 10"},
+		{Options{MaxErrors: -1}, "MaxErrors"},
+		{Options{ProjectNames: []string{"id"}}, "HasHeader"},
+	} {
+		err := bc.opts.Validate()
+		if !errors.Is(err, ErrInvalidOptions) || !strings.Contains(err.Error(), bc.want) {
+			t.Fatalf("%+v: err=%v, want %q", bc.opts, err, bc.want)
+		}
+		// The parse fails before reading or starting workers.
+		before := runtime.NumGoroutine()
+		r := iotest.ErrReader(errors.New("input read"))
+		if err := ParseTSV(r, bc.opts, func(Row) error { return nil }); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("%+v: ParseTSV err=%v", bc.opts, err)
+		}
+		waitForGoroutines(t, before)
+	}
+	for _, opts := range []Options{
+		{},
+		{ChunkSize: 64 << 10, BatchLines: 1},
+		{ChunkSize: 1 << 20, BufferSize: 1 << 20},
+		{BufferSize: defaultChunkSize},
+		{Workers: maxWorkers, DecompressWorkers: -1},
+		{ExpectedColumns: 3},
+	} {
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+	}
+}
+
+func TestParseTSVStats(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("id\tmarker\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "P%d\tCOI-5P\n", i)
+	}
+	b.WriteString("bad\n")
+	input := b.String()
+
+	for _, preserve := range []bool{true, false} {
+		stats := &ParseStats{Rows: 99}
+		opts := Options{ChunkSize: 256, BatchLines: 8, Workers: 4, PreserveOrder: preserve, StrictColumns: true, HasHeader: true, Stats: stats}
+		opts.OnRowError = func(RowError) error { return nil }
+		if err := ParseTSV(strings.NewReader(input), opts, func(Row) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		// The header is delivered too; the malformed line is split but not
+		// delivered.
+		if stats.Rows != 1001 || stats.Bytes != int64(len(input)) {
+			t.Fatalf("preserve=%v: rows=%d bytes=%d", preserve, stats.Rows, stats.Bytes)
+		}
+		if len(stats.WorkerRows) != 4 {
+			t.Fatalf("preserve=%v: worker rows %v", preserve, stats.WorkerRows)
+		}
+		var split int64
+		for _, n := range stats.WorkerRows {
+			split += n
+		}
+		if split != 1002 || stats.Batches < 1002/8 || stats.WallTime <= 0 {
+			t.Fatalf("preserve=%v: stats %s", preserve, stats)
+		}
+	}
+
+	data, err := json.Marshal(&ParseStats{Rows: 1, WorkerRows: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"rows":1,"bytes":0,"batches":0,"max_pending_batches":0,"wall_time_ns":0,"worker_rows":[1]}`
+	if string(data) != want {
+		t.Fatalf("json %s, want %s", data, want)
+	}
+}
+
+func TestParseTSVStrictColumnsUnordered(t *testing.T) {
+	const bad = 25_000
+	var b strings.Builder
+	for i := 1; i <= 50_000; i++ {
+		if i == bad {
+			b.WriteString("P\tshort\n")
+			continue
+		}
+		fmt.Fprintf(&b, "P%d\tCOI-5P\tACGT\n", i)
+	}
+	input := b.String()
+
+	for run := 0; run < 20; run++ {
+		opts := Options{ChunkSize: 4 << 10, BatchLines: 16, Workers: 8, StrictColumns: true}
+		err := ParseTSV(strings.NewReader(input), opts, func(Row) error { return nil })
+		var rowErr RowError
+		if !errors.As(err, &rowErr) || rowErr.Line != bad || rowErr.Reason != "expected 3 columns, got 2" {
+			t.Fatalf("run %d: err=%v", run, err)
+		}
+	}
+
+	// The count comes from line 1, not from whichever row arrives first.
+	opts := Options{Workers: 4, StrictColumns: true, OnRowError: func(RowError) error { return nil }}
+	var widths []int
+	err := ParseTSV(strings.NewReader("a\tb\na\nc\td\ne\n"), opts, func(row Row) error {
+		widths = append(widths, len(row.Fields))
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(widths, []int{2, 2}) {
+		t.Fatalf("widths %v err=%v", widths, err)
+	}
+}
+
+func TestParseTSVBOM(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "bom", "utf8.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	opts := DefaultOptions()
+	opts.HasHeader = true
+	var ids []string
+	err = ParseTSV(f, opts, func(row Row) error {
+		ids = append(ids, string(row.Field("processid")))
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(ids, []string{"P1"}) {
+		t.Fatalf("ids %q err=%v", ids, err)
+	}
+
+	for _, name := range []string{"utf16le.tsv", "utf16be.tsv"} {
+		input := filepath.Join("testdata", "bom", name)
+		out := filepath.Join(t.TempDir(), "taxonkit_input.tsv")
+		_, err := buildTaxonkit(context.Background(), input, out, 0, -1, extractCurationConfig{}.normalized(), inputConfig{})
+		if !errors.Is(err, ErrUTF16Input) {
+			t.Fatalf("%s: extract err=%v", name, err)
+		}
+	}
+
+	// extract and markers read the UTF-8 fixture without flags.
+	tmp := t.TempDir()
+	input := filepath.Join("testdata", "bom", "utf8.tsv")
+	out := filepath.Join(tmp, "taxonkit_input.tsv")
+	if _, err := buildTaxonkit(context.Background(), input, out, 0, -1, extractCurationConfig{}.normalized(), inputConfig{}); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	markerDir := filepath.Join(tmp, "markers")
+	if err := os.MkdirAll(markerDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := buildMarkerFastas(context.Background(), input, markerDir, false, 0, -1, 1, inputConfig{}); err != nil {
+		t.Fatalf("markers: %v", err)
+	}
+	fasta, err := os.ReadFile(filepath.Join(markerDir, "COI-5P.fasta"))
+	if err != nil || string(fasta) != ">P1\nACGTACGT\n" {
+		t.Fatalf("markers output %q err=%v", fasta, err)
+	}
+}
+
+// ParseTSVBatches delivers what ParseTSV does, in the same order, with
+// rejected lines reported between the rows around them.
+func TestParseTSVBatches(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("processid\tnuc\n")
+	for i := 1; i <= 2000; i++ {
+		if i%97 == 0 {
+			b.WriteString("bad\n")
+			continue
+		}
+		fmt.Fprintf(&b, "P%d\tACGT\n", i)
+	}
+	input := b.String()
+
+	parse := func(batches bool) ([]string, ParseStats) {
+		var events []string
+		var stats ParseStats
+		opts := Options{ChunkSize: 256, BatchLines: 16, Workers: 4, PreserveOrder: true, StrictColumns: true, HasHeader: true, Stats: &stats}
+		opts.OnRowError = func(e RowError) error {
+			events = append(events, fmt.Sprintf("reject %d", e.Line))
+			return nil
+		}
+		row := func(row Row) {
+			events = append(events, fmt.Sprintf("%d %s", row.Line, row.Field("processid")))
+		}
+		var err error
+		if batches {
+			err = ParseTSVBatches(strings.NewReader(input), opts, func(rows []Row) error {
+				if len(rows) == 0 {
+					return errors.New("empty batch")
+				}
+				for _, r := range rows {
+					row(r)
+				}
+				return nil
+			})
+		} else {
+			err = ParseTSV(strings.NewReader(input), opts, func(r Row) error {
+				row(r)
+				return nil
+			})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events, stats
+	}
+	want, wantStats := parse(false)
+	got, gotStats := parse(true)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseTSVBatches delivered %d events, ParseTSV %d, in a different order", len(got), len(want))
+	}
+	if gotStats.Rows != wantStats.Rows || gotStats.Rows != 2000-20+1 {
+		t.Fatalf("rows %d, ParseTSV %d", gotStats.Rows, wantStats.Rows)
+	}
+
+	stop := errors.New("stop")
+	var calls int
+	err := ParseTSVBatches(strings.NewReader(input), Options{Workers: 2, HasHeader: true}, func([]Row) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("err=%v calls=%d", err, calls)
+	}
+}
+
+func TestParseTSVSample(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("processid\tnuc\n")
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(&b, "P%d\tACGT\n", i)
+		if i%10 == 0 {
+			b.WriteString("# comment\n")
+		}
+	}
+	input := strings.TrimSuffix(b.String(), "\n")
+
+	for _, tc := range []struct {
+		every, limit int64
+		want         []string
+	}{
+		{every: 25, want: []string{"P1", "P26", "P51", "P76"}},
+		{every: 30, limit: 2, want: []string{"P1", "P31"}},
+		{limit: 3, want: []string{"P1", "P2", "P3"}},
+		{every: 99, want: []string{"P1", "P100"}},
+	} {
+		for _, chunk := range []int{7, 64, 1 << 16} {
+			var stats ParseStats
+			opts := Options{ChunkSize: chunk, BatchLines: 4, Workers: 4, PreserveOrder: true, HasHeader: true,
+				CommentPrefix: "#", SampleEveryN: tc.every, SampleLimit: tc.limit, Stats: &stats}
+			var header []string
+			opts.OnHeader = func(h *Header) error {
+				header = h.Names()
+				return nil
+			}
+			var got []string
+			err := ParseTSV(strings.NewReader(input), opts, func(row Row) error {
+				got = append(got, string(row.Field("processid")))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) || len(header) != 2 {
+				t.Fatalf("every %d limit %d chunk %d: got %v header %v", tc.every, tc.limit, chunk, got, header)
+			}
+			// Dropped lines are never split.
+			var split int64
+			for _, n := range stats.WorkerRows {
+				split += n
+			}
+			if split != int64(len(tc.want)+1) {
+				t.Fatalf("every %d limit %d chunk %d: workers split %d lines", tc.every, tc.limit, chunk, split)
+			}
+		}
+	}
+
+	err := ParseTSV(strings.NewReader(input), Options{SampleEveryN: -1}, func(Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+// Byte ranges cut anywhere cover every line exactly once, like
+// Hadoop-style input splits.
+func TestParseTSVByteRanges(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 300; i++ {
+		fmt.Fprintf(&b, "P%d\t%s\n", i, strings.Repeat("A", i%17))
+	}
+	input := strings.TrimSuffix(b.String(), "\n")
+
+	for _, splits := range []int{1, 2, 3, 7, 50} {
+		size := int64(len(input)) / int64(splits)
+		var got []string
+		for s := 0; s < splits; s++ {
+			opts := Options{ChunkSize: 37, BatchLines: 8, Workers: 4, PreserveOrder: true, StartOffset: int64(s) * size}
+			if s < splits-1 {
+				opts.EndOffset = int64(s+1) * size
+			}
+			var line int64
+			err := ParseTSV(strings.NewReader(input), opts, func(row Row) error {
+				if line++; row.Line != line {
+					return fmt.Errorf("line %d, want %d relative to the range", row.Line, line)
+				}
+				if !strings.HasPrefix(input[row.Offset:], string(row.Fields[0])+"\t") {
+					return fmt.Errorf("offset %d does not start %s", row.Offset, row.Fields[0])
+				}
+				got = append(got, string(row.Fields[0]))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%d splits, range %d: %v", splits, s, err)
+			}
+		}
+		if len(got) != 300 || got[0] != "P1" || got[299] != "P300" {
+			t.Fatalf("%d splits: %d lines, %v ... %v", splits, len(got), got[:1], got[len(got)-1:])
+		}
+		for i, id := range got {
+			if id != fmt.Sprintf("P%d", i+1) {
+				t.Fatalf("%d splits: line %d is %s", splits, i, id)
+			}
+		}
+	}
+
+	// *os.File seeks; other readers need io.Seeker or io.ReaderAt.
+	path := filepath.Join(t.TempDir(), "in.tsv")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var rows []Row
+	err = ParseTSV(f, Options{StartOffset: 100, EndOffset: 120, PreserveOrder: true}, func(row Row) error {
+		rows = append(rows, copyRow(row))
+		return nil
+	})
+	if err != nil || len(rows) == 0 || rows[0].Offset <= 100 || rows[len(rows)-1].Offset > 120 {
+		t.Fatalf("rows %+v err=%v", rows, err)
+	}
+	err = ParseTSV(io.MultiReader(strings.NewReader(input)), Options{StartOffset: 100}, func(Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "io.Seeker") {
+		t.Fatalf("err=%v", err)
+	}
+	err = ParseTSV(strings.NewReader(input), Options{StartOffset: 10, HasHeader: true}, func(Row) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "StartOffset 0") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+// Row.Offset locates the line in the file: seeking there reads it back.
+func TestParseTSVRowOffset(t *testing.T) {
+	var b strings.Builder
+	b.Write(utf8BOM)
+	b.WriteString("processid\tnote\r\n")
+	for i := 1; i <= 2000; i++ {
+		if i%250 == 0 {
+			fmt.Fprintf(&b, "P%d\t\"two\r\nlines\"\r\n", i)
+			continue
+		}
+		fmt.Fprintf(&b, "P%d\t%s\r\n", i, strings.Repeat("x", i%13))
+	}
+	path := filepath.Join(t.TempDir(), "in.tsv")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = in.Close() }()
+
+	offsets := make(map[string]int64)
+	opts := Options{ChunkSize: 61, BatchLines: 7, Workers: 4, AllowCRLF: true, QuotedFields: true, HasHeader: true}
+	err = ParseTSV(in, opts, func(row Row) error {
+		offsets[string(row.Fields[0])] = row.Offset
+		return nil
+	})
+	if err != nil || len(offsets) != 2000 {
+		t.Fatalf("%d rows, err=%v", len(offsets), err)
+	}
+
+	for _, id := range []string{"P1", "P249", "P250", "P251", "P1000", "P2000"} {
+		if _, err := in.Seek(offsets[id], io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(in).ReadString('\t')
+		if err != nil || line != id+"\t" {
+			t.Fatalf("%s at offset %d reads %q err=%v", id, offsets[id], line, err)
+		}
+	}
+}
+
+func TestRowErrorsUnlimited(t *testing.T) {
+	e := &RowErrors{Limit: -1}
+	for i := 1; i <= 15; i++ {
+		if !e.add(RowError{Line: int64(i), Reason: "bad"}) {
+			t.Fatal("an unlimited parse stopped")
+		}
+	}
+	if len(e.Errors) != maxListedRowErrors || e.Omitted != 5 || e.skipped() != 15 {
+		t.Fatalf("%d kept, %d omitted", len(e.Errors), e.Omitted)
+	}
+	if got := e.Error(); !strings.HasPrefix(got, "15 malformed lines skipped: line 1: bad;") || !strings.HasSuffix(got, "line 10: bad; and 5 more") {
+		t.Fatalf("message %q", got)
+	}
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/pgzip"
+)
+
+// tsvWriterOptions configures a tsvWriter.
+type tsvWriterOptions struct {
+	// Delimiter separates fields; 0 means '\t'.
+	Delimiter byte
+	// Quote writes a field holding the delimiter, a line break, or a leading
+	// '"' as an RFC 4180 quoted field, which Options.QuotedFields reads back
+	// unchanged. Without it fields are written verbatim.
+	Quote bool
+	// GzipWorkers > 1 compresses .gz output with pgzip on that many
+	// goroutines; otherwise compress/gzip is used.
+	GzipWorkers int
+	// BGZF compresses .gz output as bgzip blocks instead; bgzfBlocks
+	// returns their starts for a .gzi index.
+	BGZF bool
+}
+
+// tsvScratch holds the row buffers of closed writers.
+var tsvScratch = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+// tsvWriter writes delimited rows. Each row is assembled in a scratch buffer
+// and handed to one bufio.Writer, so a row costs one copy and no
+// allocation.
+type tsvWriter struct {
+	buf     *bufio.Writer
+	gz      io.WriteCloser
+	file    io.Closer
+	scratch *[]byte
+	opts    tsvWriterOptions
+}
+
+// createTSVWriter creates path, compressing when it ends in .gz.
+func createTSVWriter(path string, opts tsvWriterOptions) (*tsvWriter, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	f, err := createFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		w, _ := newTSVWriter(f, opts)
+		w.file = f
+		return w, nil
+	}
+	var gz io.WriteCloser
+	if opts.BGZF {
+		gz = newBGZFWriter(f)
+	} else if opts.GzipWorkers > 1 {
+		pw := pgzip.NewWriter(f)
+		if err := pw.SetConcurrency(1<<20, opts.GzipWorkers); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("set gzip concurrency: %w", err)
+		}
+		gz = pw
+	} else {
+		gz = gzip.NewWriter(f)
+	}
+	w, _ := newTSVWriter(gz, opts)
+	w.gz, w.file = gz, f
+	return w, nil
+}
+
+// newTSVWriter writes to w, which the writer does not close.
+func newTSVWriter(w io.Writer, opts tsvWriterOptions) (*tsvWriter, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	if opts.Delimiter == 0 {
+		opts.Delimiter = '\t'
+	}
+	return &tsvWriter{
+		buf:     bufio.NewWriterSize(w, writerBufferSize),
+		scratch: tsvScratch.Get().(*[]byte),
+		opts:    opts,
+	}, nil
+}
+
+func (o tsvWriterOptions) check() error {
+	switch o.Delimiter {
+	case '\n', '\r':
+		return fmt.Errorf("delimiter %q is a line terminator", o.Delimiter)
+	case '"':
+		if o.Quote {
+			return fmt.Errorf("delimiter %q is the quote character", o.Delimiter)
+		}
+	}
+	return nil
+}
+
+// WriteHeader writes the column names as the first row.
+func (w *tsvWriter) WriteHeader(names []string) error {
+	return w.WriteRowStrings(names)
+}
+
+// WriteRow writes one row; fields may alias parser buffers.
+func (w *tsvWriter) WriteRow(fields [][]byte) error {
+	row := (*w.scratch)[:0]
+	for i, field := range fields {
+		if i > 0 {
+			row = append(row, w.opts.Delimiter)
+		}
+		row = appendTSVField(row, field, w.opts)
+	}
+	return w.writeLine(row)
+}
+
+// WriteRowStrings is WriteRow for string fields.
+func (w *tsvWriter) WriteRowStrings(fields []string) error {
+	row := (*w.scratch)[:0]
+	for i, field := range fields {
+		if i > 0 {
+			row = append(row, w.opts.Delimiter)
+		}
+		row = appendTSVField(row, field, w.opts)
+	}
+	return w.writeLine(row)
+}
+
+// appendTSVField appends field to row, quoted when Quote is set and the
+// quoted-field reader would otherwise split or alter it.
+func appendTSVField[T string | []byte](row []byte, field T, opts tsvWriterOptions) []byte {
+	if !opts.Quote || !fieldNeedsQuote(field, opts.Delimiter) {
+		return append(row, field...)
+	}
+	row = append(row, '"')
+	for i := 0; i < len(field); i++ {
+		if field[i] == '"' {
+			row = append(row, '"')
+		}
+		row = append(row, field[i])
+	}
+	return append(row, '"')
+}
+
+func fieldNeedsQuote[T string | []byte](field T, delim byte) bool {
+	if len(field) > 0 && field[0] == '"' {
+		return true
+	}
+	for i := 0; i < len(field); i++ {
+		switch field[i] {
+		case delim, '\n', '\r':
+			return true
+		}
+	}
+	return false
+}
+
+func (w *tsvWriter) writeLine(row []byte) error {
+	row = append(row, '\n')
+	*w.scratch = row
+	_, err := w.buf.Write(row)
+	return err
+}
+
+// Flush writes buffered rows to the underlying writer. Compressed output
+// stays in the gzip stream until Close.
+func (w *tsvWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// bgzfBlocks returns the block starts of BGZF output, once it is closed.
+func (w *tsvWriter) bgzfBlocks() ([]bgzfBlockStart, bool) {
+	z, ok := w.gz.(*bgzfWriter)
+	if !ok {
+		return nil, false
+	}
+	return z.starts, true
+}
+
+// Close flushes the rows, finishes the gzip stream and closes the file
+// createTSVWriter opened. Later calls do nothing.
+func (w *tsvWriter) Close() error {
+	if w.scratch == nil {
+		return nil
+	}
+	*w.scratch = (*w.scratch)[:0]
+	tsvScratch.Put(w.scratch)
+	w.scratch = nil
+
+	err := w.buf.Flush()
+	if w.gz != nil {
+		if gzErr := w.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if w.file != nil {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readTSVRows(t *testing.T, path string, opts Options) [][]string {
+	t.Helper()
+	var rows [][]string
+	err := ParseRows(path, opts, func(row Row) error {
+		fields := make([]string, len(row.Fields))
+		for i, f := range row.Fields {
+			fields[i] = string(f)
+		}
+		rows = append(rows, fields)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestTSVWriterRoundTrip(t *testing.T) {
+	header := []string{"id", "note", "seq"}
+	rows := [][]string{
+		{"P1", "plain", "ACGT"},
+		{"P2", "tab\there", ""},
+		{"P3", "two\nlines", "crlf\r\n"},
+		{"P4", `"leading quote`, `inner "quote"`},
+		{"P5", `""`, "trailing\r"},
+		{"", "", ""},
+	}
+	dir := t.TempDir()
+	for _, bc := range []struct {
+		name string
+		opts tsvWriterOptions
+	}{
+		{"out.tsv", tsvWriterOptions{Quote: true}},
+		{"out.tsv.gz", tsvWriterOptions{Quote: true}},
+		{"pgzip.tsv.gz", tsvWriterOptions{Quote: true, GzipWorkers: 4}},
+		{"comma.csv", tsvWriterOptions{Quote: true, Delimiter: ','}},
+	} {
+		path := filepath.Join(dir, bc.name)
+		w, err := createTSVWriter(path, bc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		for i, row := range rows {
+			// Alternate the two row forms; they must write the same bytes.
+			if i%2 == 0 {
+				err = w.WriteRowStrings(row)
+			} else {
+				fields := make([][]byte, len(row))
+				for j, f := range row {
+					fields[j] = []byte(f)
+				}
+				err = w.WriteRow(fields)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: second close: %v", bc.name, err)
+		}
+
+		got := readTSVRows(t, path, Options{QuotedFields: true, Delimiter: bc.opts.Delimiter, StrictColumns: true})
+		want := append([][]string{header}, rows...)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s:\n got %q\nwant %q", bc.name, got, want)
+		}
+	}
+}
+
+// Without Quote fields are written verbatim: plain TSV reads back with the
+// default parser.
+func TestTSVWriterVerbatim(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newTSVWriter(&buf, tsvWriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.WriteHeader([]string{"a", "b"})
+	_ = w.WriteRow([][]byte{[]byte(`"x"`), nil})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "a\tb\n\"x\"\t\n" {
+		t.Fatalf("got %q", got)
+	}
+
+	for _, opts := range []tsvWriterOptions{{Delimiter: '\n'}, {Delimiter: '"', Quote: true}} {
+		if _, err := newTSVWriter(&buf, opts); err == nil {
+			t.Fatalf("%+v: expected an error", opts)
+		}
+	}
+}
+
+func BenchmarkTSVWriter(b *testing.B) {
+	row := make([]string, 10)
+	for i := range row {
+		row[i] = fmt.Sprintf("Field value %d", i)
+	}
+	for _, quote := range []bool{false, true} {
+		b.Run(fmt.Sprintf("quote=%v", quote), func(b *testing.B) {
+			w, err := newTSVWriter(io.Discard, tsvWriterOptions{Quote: quote})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(strings.Join(row, "\t")) + 1))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := w.WriteRowStrings(row); err != nil {
+					b.Fatal(err)
+				}
+			}
+			_ = w.Close()
+		})
+	}
+}
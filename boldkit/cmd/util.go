@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 )
 
 func fileExists(path string) bool {
@@ -28,23 +29,6 @@ func outputsExist(outDir string) bool {
 	return false
 }
 
-func snapshotID(inputPath string) string {
-	base := filepath.Base(inputPath)
-	if strings.HasSuffix(base, ".parquet") {
-		return strings.TrimSuffix(base, ".parquet")
-	}
-	if strings.HasSuffix(base, ".parq") {
-		return strings.TrimSuffix(base, ".parq")
-	}
-	if strings.HasSuffix(base, ".tsv.gz") {
-		return strings.TrimSuffix(base, ".tsv.gz")
-	}
-	if strings.HasSuffix(base, ".tsv") {
-		return strings.TrimSuffix(base, ".tsv")
-	}
-	return strings.TrimSuffix(base, filepath.Ext(base))
-}
-
 func normalizeBytes(value []byte) []byte {
 	if isNone(value) {
 		return nil
@@ -68,30 +52,37 @@ func indexOfBytes(values [][]byte, name string) int {
 	return -1
 }
 
-func filterSeqBytes(dst []byte, src []byte) []byte {
+// seqFilterMode selects the characters filterSeqBytes keeps.
+type seqFilterMode int
+
+const (
+	// seqKeepACGT keeps A, C, G, and T, dropping ambiguity codes.
+	seqKeepACGT seqFilterMode = iota
+	// seqKeepIUPAC also keeps the IUPAC ambiguity codes (RYSWKMBDHVN).
+	seqKeepIUPAC
+)
+
+// filterSeqBytes appends the bases of src that mode keeps to dst[:0],
+// uppercased, and counts the ambiguity codes in src whether kept or not.
+// Gaps and other characters are dropped.
+func filterSeqBytes(dst []byte, src []byte, mode seqFilterMode) ([]byte, int) {
 	dst = dst[:0]
+	ambiguous := 0
 	for _, c := range src {
 		switch c {
 		case 'A', 'C', 'G', 'T':
 			dst = append(dst, c)
 		case 'a', 'c', 'g', 't':
 			dst = append(dst, c-32)
+		case 'R', 'Y', 'S', 'W', 'K', 'M', 'B', 'D', 'H', 'V', 'N',
+			'r', 'y', 's', 'w', 'k', 'm', 'b', 'd', 'h', 'v', 'n':
+			ambiguous++
+			if mode == seqKeepIUPAC {
+				dst = append(dst, c&^0x20)
+			}
 		}
 	}
-	return dst
-}
-
-func sanitizeMarkerBytes(dst []byte, src []byte) string {
-	dst = dst[:0]
-	dst = append(dst, src...)
-	for i := 0; i < len(dst); i++ {
-		c := dst[i]
-		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '.' || c == '_' || c == '-' {
-			continue
-		}
-		dst[i] = '_'
-	}
-	return string(dst)
+	return dst, ambiguous
 }
 
 func maxIndex(values ...int) int {
@@ -148,28 +139,99 @@ func (r readCloser) Close() error {
 	return r.close()
 }
 
+// countReader counts the bytes read through it. Count may be called from
+// another goroutine than Read, as when pgzip reads ahead.
 type countReader struct {
 	reader io.Reader
-	count  int64
+	count  atomic.Int64
 }
 
 func (r *countReader) Read(p []byte) (int, error) {
 	n, err := r.reader.Read(p)
-	r.count += int64(n)
+	r.count.Add(int64(n))
 	return n, err
 }
 
 func (r *countReader) Count() int64 {
-	return r.count
+	return r.count.Load()
 }
 
-func openInput(path string) (io.ReadCloser, error) {
+// meteredFile counts bytes for the run resource report. It deliberately
+// does not expose ReadFrom/WriteTo so io.Copy cannot bypass the counters.
+type meteredFile struct {
+	f *os.File
+}
+
+// openFile opens path for reading; reads count toward bytes_read.
+func openFile(path string) (*meteredFile, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	return &meteredFile{f: f}, nil
+}
+
+// createFile creates path; writes count toward bytes_written.
+func createFile(path string) (*meteredFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredFile{f: f}, nil
+}
+
+// appendFile opens path for appending; writes count toward bytes_written.
+func appendFile(path string) (*meteredFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredFile{f: f}, nil
+}
+
+func (m *meteredFile) Read(p []byte) (int, error) {
+	n, err := m.f.Read(p)
+	ioBytesRead.Add(int64(n))
+	return n, err
+}
+
+func (m *meteredFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := m.f.ReadAt(p, off)
+	ioBytesRead.Add(int64(n))
+	return n, err
+}
+
+func (m *meteredFile) Seek(offset int64, whence int) (int64, error) {
+	return m.f.Seek(offset, whence)
+}
+
+func (m *meteredFile) Write(p []byte) (int, error) {
+	n, err := m.f.Write(p)
+	ioBytesWritten.Add(int64(n))
+	return n, err
+}
+
+func (m *meteredFile) Close() error {
+	return m.f.Close()
+}
+
+func (m *meteredFile) Name() string {
+	return m.f.Name()
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	return openInputWorkers(path, 0)
+}
+
+// openInputWorkers is openInput with up to workers goroutines decompressing
+// .gz input (<= 0 means GOMAXPROCS); see newGzipReader.
+func openInputWorkers(path string, workers int) (io.ReadCloser, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
 	if strings.HasSuffix(path, ".gz") {
-		gz, err := gzip.NewReader(f)
+		gz, err := newGzipReader(f, workers)
 		if err != nil {
 			_ = f.Close()
 			return nil, err
@@ -185,14 +247,16 @@ func openInput(path string) (io.ReadCloser, error) {
 	return f, nil
 }
 
-func openInputWithCounter(path string) (io.ReadCloser, *countReader, error) {
-	f, err := os.Open(path)
+// openInputWithCounter is openInputWorkers with a counter of the bytes read
+// from the file itself, before decompression.
+func openInputWithCounter(path string, workers int) (io.ReadCloser, *countReader, error) {
+	f, err := openFile(path)
 	if err != nil {
 		return nil, nil, err
 	}
 	counter := &countReader{reader: f}
 	if strings.HasSuffix(path, ".gz") {
-		gz, err := gzip.NewReader(counter)
+		gz, err := newGzipReader(counter, workers)
 		if err != nil {
 			_ = f.Close()
 			return nil, nil, err
@@ -211,7 +275,46 @@ func openInputWithCounter(path string) (io.ReadCloser, *countReader, error) {
 	}, counter, nil
 }
 
+type writeCloser struct {
+	writer io.Writer
+	close  func() error
+}
+
+func (w writeCloser) Write(p []byte) (int, error) {
+	return w.writer.Write(p)
+}
+
+func (w writeCloser) Close() error {
+	return w.close()
+}
+
+// createOutput creates path, gzip-compressing when it ends in .gz. Close
+// finishes the gzip stream before closing the file.
+func createOutput(path string) (io.WriteCloser, error) {
+	f, err := createFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(f)
+		return writeCloser{
+			writer: gz,
+			close: func() error {
+				if err := gz.Close(); err != nil {
+					_ = f.Close()
+					return err
+				}
+				return f.Close()
+			},
+		}, nil
+	}
+	return f, nil
+}
+
 func fileSize(path string) int64 {
+	if path == stdinPath {
+		return -1
+	}
 	info, err := os.Stat(path)
 	if err != nil {
 		return -1